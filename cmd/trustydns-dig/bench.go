@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/markdingo/trustydns/internal/resolver"
+
+	"github.com/miekg/dns"
+)
+
+// benchConfig controls a --bench run. If duration is zero, count queries are issued.
+type benchConfig struct {
+	count       int
+	duration    time.Duration
+	qps         float64 // Zero means as fast as the workers can go
+	concurrency int
+}
+
+// benchStats accumulates the results from all bench workers
+type benchStats struct {
+	mu        sync.Mutex
+	latencies []time.Duration // Successful queries only
+	errors    int
+	rcodes    map[int]int
+	servers   map[string]int // FinalServerUsed distribution
+}
+
+func (t *benchStats) add(resp *dns.Msg, respMeta *resolver.ResponseMetaData, latency time.Duration, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err != nil {
+		t.errors++
+		return
+	}
+	t.latencies = append(t.latencies, latency)
+	t.rcodes[resp.Rcode]++
+	if respMeta != nil {
+		t.servers[respMeta.FinalServerUsed]++
+	}
+}
+
+// runBench issues queries from bc.concurrency workers paced by bc.qps then writes aggregate
+// statistics to out. Pacing is by a single ticker feeding the workers so if the workers cannot
+// keep up, the achieved rate will be less than the requested rate which is exactly what the
+// operator wants to discover.
+func runBench(out io.Writer, res resolver.Resolver, qName string, qType uint16, bc benchConfig) {
+	stats := &benchStats{rcodes: make(map[int]int), servers: make(map[string]int)}
+	work := make(chan struct{})
+	wg := &sync.WaitGroup{}
+	for wx := 0; wx < bc.concurrency; wx++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range work {
+				start := time.Now()
+				resp, respMeta, err := res.Resolve(newQuery(qName, qType), nil)
+				stats.add(resp, respMeta, time.Since(start), err)
+			}
+		}()
+	}
+
+	var tick <-chan time.Time
+	if bc.qps > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / bc.qps))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	startTime := time.Now()
+	sent := 0
+	for {
+		if bc.duration > 0 {
+			if time.Since(startTime) >= bc.duration {
+				break
+			}
+		} else if sent >= bc.count {
+			break
+		}
+		if tick != nil {
+			<-tick
+		}
+		work <- struct{}{}
+		sent++
+	}
+	close(work)
+	wg.Wait()
+
+	stats.report(out, sent, time.Since(startTime), bc)
+}
+
+// report writes the aggregate statistics in dig-style comments
+func (t *benchStats) report(out io.Writer, sent int, elapsed time.Duration, bc benchConfig) {
+	target := "unlimited"
+	if bc.qps > 0 {
+		target = fmt.Sprintf("%.1f", bc.qps)
+	}
+	rate := 0.0
+	if elapsed > 0 {
+		rate = float64(sent) / elapsed.Seconds()
+	}
+	fmt.Fprintf(out, ";; Bench: %d queries in %s (%.1f qps) concurrency=%d target-qps=%s\n",
+		sent, elapsed.Truncate(time.Millisecond), rate, bc.concurrency, target)
+
+	errorRate := 0.0
+	if sent > 0 {
+		errorRate = float64(t.errors) * 100 / float64(sent)
+	}
+	fmt.Fprintf(out, ";; Errors: %d (%.1f%%)\n", t.errors, errorRate)
+
+	if len(t.latencies) > 0 {
+		sort.Slice(t.latencies, func(i, j int) bool { return t.latencies[i] < t.latencies[j] })
+		fmt.Fprintf(out, ";; Latency: min=%s p50=%s p90=%s p99=%s max=%s\n",
+			t.latencies[0], percentile(t.latencies, 50), percentile(t.latencies, 90),
+			percentile(t.latencies, 99), t.latencies[len(t.latencies)-1])
+	}
+
+	rcodes := make([]int, 0, len(t.rcodes))
+	for rcode := range t.rcodes {
+		rcodes = append(rcodes, rcode)
+	}
+	sort.Ints(rcodes)
+	for _, rcode := range rcodes {
+		fmt.Fprintf(out, ";; Rcode: %s=%d\n", dns.RcodeToString[rcode], t.rcodes[rcode])
+	}
+
+	servers := make([]string, 0, len(t.servers))
+	for server := range t.servers {
+		servers = append(servers, server)
+	}
+	sort.Strings(servers)
+	for _, server := range servers {
+		fmt.Fprintf(out, ";; Server: %s=%d\n", server, t.servers[server])
+	}
+}
+
+// percentile returns the nearest-rank percentile of the sorted latencies
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	ix := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if ix < 0 {
+		ix = 0
+	}
+
+	return sorted[ix]
+}
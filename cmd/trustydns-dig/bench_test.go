@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/markdingo/trustydns/internal/resolver"
+
+	"github.com/miekg/dns"
+)
+
+// mockResolver fails every failEvery'th query and otherwise returns a NOERROR response
+type mockResolver struct {
+	mu        sync.Mutex
+	calls     int
+	failEvery int
+}
+
+func (t *mockResolver) InBailiwick(qName string) bool {
+	return true
+}
+
+func (t *mockResolver) Resolve(q *dns.Msg, qMeta *resolver.QueryMetaData) (*dns.Msg, *resolver.ResponseMetaData, error) {
+	t.mu.Lock()
+	t.calls++
+	calls := t.calls
+	t.mu.Unlock()
+
+	if t.failEvery > 0 && calls%t.failEvery == 0 {
+		return nil, nil, errors.New("Mock failure")
+	}
+	r := &dns.Msg{}
+	r.SetReply(q)
+	server := "https://a.example.net"
+	if calls%2 == 0 {
+		server = "https://b.example.net"
+	}
+
+	return r, &resolver.ResponseMetaData{FinalServerUsed: server}, nil
+}
+
+func TestBenchCount(t *testing.T) {
+	mainInit(&bytes.Buffer{}, &bytes.Buffer{})
+	res := &mockResolver{failEvery: 4}
+	out := &bytes.Buffer{}
+	runBench(out, res, "example.net", dns.TypeA, benchConfig{count: 20, concurrency: 3})
+	if res.calls != 20 {
+		t.Error("Expected 20 queries, not", res.calls)
+	}
+	outStr := out.String()
+	for _, expect := range []string{";; Bench: 20 queries", "concurrency=3 target-qps=unlimited",
+		";; Errors: 5 (25.0%)", ";; Latency: min=", ";; Rcode: NOERROR=15",
+		";; Server: https://a.example.net=", ";; Server: https://b.example.net="} {
+		if !strings.Contains(outStr, expect) {
+			t.Error("Expected", expect, "in", outStr)
+		}
+	}
+}
+
+func TestBenchDuration(t *testing.T) {
+	mainInit(&bytes.Buffer{}, &bytes.Buffer{})
+	res := &mockResolver{}
+	out := &bytes.Buffer{}
+	start := time.Now()
+	runBench(out, res, "example.net", dns.TypeA,
+		benchConfig{count: 1, duration: 200 * time.Millisecond, qps: 50, concurrency: 2})
+	elapsed := time.Since(start)
+	if elapsed < 200*time.Millisecond || elapsed > 2*time.Second {
+		t.Error("Bench ran for an unexpected time", elapsed)
+	}
+	if res.calls < 5 || res.calls > 15 { // Nominally 10 at 50qps for 200ms
+		t.Error("Rate control did not pace queries. Calls:", res.calls)
+	}
+	if !strings.Contains(out.String(), "target-qps=50.0") {
+		t.Error("Expected target-qps in", out.String())
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var sorted []time.Duration
+	for ix := 1; ix <= 100; ix++ {
+		sorted = append(sorted, time.Duration(ix))
+	}
+	for _, tc := range []struct {
+		p      float64
+		expect time.Duration
+	}{{0, 1}, {50, 50}, {90, 90}, {99, 99}, {100, 100}} {
+		if got := percentile(sorted, tc.p); got != tc.expect {
+			t.Error("Percentile", tc.p, "expected", tc.expect, "got", got)
+		}
+	}
+	if got := percentile(sorted[:1], 99); got != 1 {
+		t.Error("Single entry percentile should be that entry, not", got)
+	}
+}
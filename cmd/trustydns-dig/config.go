@@ -8,10 +8,25 @@ import (
 )
 
 type config struct {
-	help     bool
-	parallel bool
-	short    bool
-	version  bool
+	debugMeta bool // Ask the server for resolution meta data
+	dnssec    bool // Set DO in the query and summarize the DNSSEC RRs in the response
+	help      bool
+	hexdump   bool // Print an annotated hex dump of the wire-format response
+	noIDN     bool // Do not convert internationalized names to A-labels
+	parallel  bool
+	reverse   bool // qName is an IP address to be converted to a reverse PTR query
+	short     bool
+	tcp       bool // Query a plain DNS server over TCP instead of a DoH server
+	udp       bool // Query a plain DNS server over UDP instead of a DoH server
+	version   bool
+	wire      bool // Write the wire-format response to stdout
+
+	requirePadding bool // Exit non-zero if any response is not padded
+
+	bench            bool // Run a load test and print aggregate statistics
+	benchConcurrency int
+	benchDuration    time.Duration // Run for this long rather than repeatCount queries
+	benchQPS         float64       // Zero means unlimited
 
 	repeatCount    int
 	requestTimeout time.Duration
@@ -3,6 +3,8 @@ package main
 
 import (
 	"bytes"
+	"encoding/binary"
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"io"
@@ -11,11 +13,14 @@ import (
 	"net/url"
 	"os"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/markdingo/trustydns/internal/constants"
+	"github.com/markdingo/trustydns/internal/dnsutil"
 	"github.com/markdingo/trustydns/internal/resolver"
 	"github.com/markdingo/trustydns/internal/resolver/doh"
+	"github.com/markdingo/trustydns/internal/resolver/plain"
 	"github.com/markdingo/trustydns/internal/tlsutil"
 
 	"github.com/miekg/dns"
@@ -31,6 +36,8 @@ var (
 	stderr io.Writer
 
 	flagSet *flag.FlagSet
+
+	unpaddedResponses atomic.Int32 // Counted for --require-padding
 )
 
 //////////////////////////////////////////////////////////////////////
@@ -50,6 +57,7 @@ func mainInit(out io.Writer, err io.Writer) {
 	cfg = &config{}
 	stdout = out
 	stderr = err
+	unpaddedResponses.Store(0)
 }
 
 func main() {
@@ -79,6 +87,54 @@ func mainExecute(args []string) int {
 		return fatal("Repeat count (-r) must be GE zero, not", cfg.repeatCount)
 	}
 
+	// Validate output format
+
+	formats := 0
+	for _, b := range []bool{cfg.short, cfg.wire, cfg.hexdump} {
+		if b {
+			formats++
+		}
+	}
+	if formats > 1 {
+		return fatal("Only one of --short, --wire or --hexdump can be set")
+	}
+
+	// Validate bench settings
+
+	if cfg.bench {
+		if formats > 0 || cfg.parallel {
+			return fatal("--bench cannot be combined with -p, --short, --wire or --hexdump")
+		}
+		if cfg.benchConcurrency < 1 {
+			return fatal("--concurrency must be GT zero, not", cfg.benchConcurrency)
+		}
+		if cfg.benchQPS < 0 {
+			return fatal("--qps must be GE zero, not", cfg.benchQPS)
+		}
+		if cfg.benchDuration < 0 {
+			return fatal("--duration must be GE zero, not", cfg.benchDuration)
+		}
+	}
+
+	// Validate plain DNS settings. DoH-specific options make no sense with plain DNS so rather
+	// than silently ignoring them, complain.
+
+	plainDNS := cfg.udp || cfg.tcp
+	if cfg.udp && cfg.tcp {
+		return fatal("Only one of --udp or --tcp can be set")
+	}
+	if plainDNS {
+		var dohOnly []string
+		flagSet.Visit(func(f *flag.Flag) {
+			if dohOnlyFlags[f.Name] {
+				dohOnly = append(dohOnly, f.Name)
+			}
+		})
+		if len(dohOnly) > 0 {
+			return fatal("DoH options cannot be used with --udp or --tcp:", strings.Join(dohOnly, " "))
+		}
+	}
+
 	// Validate ECS settings
 
 	var ecsIPNet *net.IPNet
@@ -105,32 +161,38 @@ func mainExecute(args []string) int {
 	remainingOptions := flagSet.NArg() // Track command line options
 	optionIndex := 0
 
-	// Validate DoH from command line: DoHServer qName [qType]
+	// Validate server from command line: DoHServer|DNSServer qName [qType]
 
+	serverDesc := "DoH Server URL"
+	if plainDNS {
+		serverDesc = "DNS Server address"
+	}
 	if remainingOptions < 1 {
-		return fatal("Require DoH Server URL on command line. Consider -h")
+		return fatal("Require " + serverDesc + " on command line. Consider -h")
 	}
-	dohServerURL := flagSet.Arg(optionIndex)
-	if len(dohServerURL) == 0 {
-		return fatal("DoH Server URL cannot be an empty string")
+	serverArg := flagSet.Arg(optionIndex)
+	if len(serverArg) == 0 {
+		return fatal(serverDesc + " cannot be an empty string")
 	}
 	optionIndex++
 	remainingOptions--
-	u, err := url.Parse(dohServerURL)
-	if err != nil {
-		return fatal(err)
-	}
-	if len(u.Scheme) == 0 && len(u.Host) == 0 && len(u.Path) > 0 { // A plain FQDN looks like this
-		u.Host = u.Path
-		u.Path = ""
-	}
-	if len(u.Host) == 0 {
-		return fatal(dohServerURL, "does not contain a hostname")
-	}
-	if len(u.Scheme) == 0 {
-		u.Scheme = "https"
+	if !plainDNS {
+		u, err := url.Parse(serverArg)
+		if err != nil {
+			return fatal(err)
+		}
+		if len(u.Scheme) == 0 && len(u.Host) == 0 && len(u.Path) > 0 { // A plain FQDN looks like this
+			u.Host = u.Path
+			u.Path = ""
+		}
+		if len(u.Host) == 0 {
+			return fatal(serverArg, "does not contain a hostname")
+		}
+		if len(u.Scheme) == 0 {
+			u.Scheme = "https"
+		}
+		serverArg = u.String() // Put possibly modified URL back into the config
 	}
-	dohServerURL = u.String() // Put possibly modified URL back into the config
 
 	// Validate qName
 
@@ -138,13 +200,35 @@ func mainExecute(args []string) int {
 		return fatal("Require qName on command line. Consider -h")
 	}
 
-	qName := dns.Fqdn(flagSet.Arg(optionIndex))
+	// With --reverse the qName is an IP address which is converted to its in-addr.arpa or
+	// ip6.arpa form in the same way as dig -x.
+
+	var qName string
+	if cfg.reverse {
+		var err error
+		qName, err = dns.ReverseAddr(flagSet.Arg(optionIndex))
+		if err != nil {
+			return fatal("--reverse", err)
+		}
+	} else {
+		qName = dns.Fqdn(flagSet.Arg(optionIndex))
+	}
+	if !cfg.reverse && !cfg.noIDN {
+		var err error
+		qName, _, err = dnsutil.IDNToASCII(qName)
+		if err != nil {
+			return fatal("Invalid IDN qName", flagSet.Arg(optionIndex), err)
+		}
+	}
 	optionIndex++
 	remainingOptions--
 
 	// Validate qType - if present
 
 	qTypeString := dns.TypeToString[dns.TypeA] // Default to an "A" query
+	if cfg.reverse {
+		qTypeString = dns.TypeToString[dns.TypePTR]
+	}
 	if remainingOptions > 0 {
 		qTypeString = strings.ToUpper(flagSet.Arg(optionIndex))
 		optionIndex++
@@ -161,43 +245,63 @@ func mainExecute(args []string) int {
 		return fatal("Don't know what to do with residual goop on command line:", flagSet.Arg(optionIndex))
 	}
 
-	// Create TLS configuration for constructing HTTPS transport. This is where we set up
-	// verification of server certs and activate http2.
+	// Construct the plain DNS resolver or the DoH resolver. The plain resolver exists so that
+	// DoH responses can be compared with those of a traditional DNS server.
 
-	client := &http.Client{Timeout: cfg.requestTimeout}
-	tlsConfig, err := tlsutil.NewClientTLSConfig(cfg.tlsUseSystemRootCAs, cfg.tlsCAFiles.Args(),
-		cfg.tlsClientCertFile, cfg.tlsClientKeyFile)
-	if err != nil {
-		return fatal(err)
-	}
+	var res resolver.Resolver
+	if plainDNS {
+		plainResolver, err := plain.New(plain.Config{Server: serverArg, Timeout: cfg.requestTimeout,
+			TCPOnly: cfg.tcp})
+		if err != nil {
+			return fatal(err)
+		}
+		res = plainResolver
+	} else {
+		// Create TLS configuration for constructing HTTPS transport. This is where we set up
+		// verification of server certs and activate http2.
 
-	tr := &http.Transport{TLSClientConfig: tlsConfig}
-	if err := http2.ConfigureTransport(tr); err != nil { // Use latest http2 support - is this still needed?
-		return fatal(err)
-	}
-	client.Transport = tr
+		client := &http.Client{Timeout: cfg.requestTimeout}
+		tlsConfig, err := tlsutil.NewClientTLSConfig(cfg.tlsUseSystemRootCAs, cfg.tlsCAFiles.Args(),
+			cfg.tlsClientCertFile, cfg.tlsClientKeyFile)
+		if err != nil {
+			return fatal(err)
+		}
 
-	// Complete doh Config settings and construct the DoH resolver
-	cfg.dohConfig.ECSSetCIDR = ecsIPNet
-	cfg.dohConfig.ServerURLs = []string{dohServerURL}
+		tr := &http.Transport{TLSClientConfig: tlsConfig}
+		if err := http2.ConfigureTransport(tr); err != nil { // Use latest http2 support - is this still needed?
+			return fatal(err)
+		}
+		client.Transport = tr
 
-	dohResolver, err := doh.New(cfg.dohConfig, client)
-	if err != nil {
-		return fatal(err)
+		// Complete doh Config settings and construct the DoH resolver
+		cfg.dohConfig.ECSSetCIDR = ecsIPNet
+		cfg.dohConfig.ServerURLs = []string{serverArg}
+
+		dohResolver, err := doh.New(cfg.dohConfig, client)
+		if err != nil {
+			return fatal(err)
+		}
+		res = dohResolver
 	}
 
 	// Verify that the remote resolver handles this FQDN
-	if !dohResolver.InBailiwick(qName) {
+	if !res.InBailiwick(qName) {
 		return fatal("qName cannot be resolved remotely. Is it a valid FQDN?", qName)
 	}
 
+	if cfg.bench {
+		runBench(stdout, res, qName, qType, benchConfig{count: cfg.repeatCount, duration: cfg.benchDuration,
+			qps: cfg.benchQPS, concurrency: cfg.benchConcurrency})
+		return 0
+	}
+
 	// Issue the query the requested number of times
 
 	chOut := make(chan string, 1) // Queries write to a chan so we can parallelize
 	chErr := make(chan string, 1) // and reap and print the outputs without interleaving.
 	if cfg.parallel {
 		for qx := 0; qx < cfg.repeatCount; qx++ {
-			go doQuery(chOut, chErr, dohResolver, qName, qType, cfg.short)
+			go doQuery(chOut, chErr, res, qName, qType)
 		}
 		for qx := 0; qx < cfg.repeatCount; qx++ {
 			s := <-chOut
@@ -207,7 +311,7 @@ func mainExecute(args []string) int {
 		}
 	} else {
 		for qx := 0; qx < cfg.repeatCount; qx++ {
-			doQuery(chOut, chErr, dohResolver, qName, qType, cfg.short)
+			doQuery(chOut, chErr, res, qName, qType)
 			s := <-chOut
 			fmt.Fprint(stdout, s)
 			s = <-chErr
@@ -215,39 +319,151 @@ func mainExecute(args []string) int {
 		}
 	}
 
+	if cfg.requirePadding && unpaddedResponses.Load() > 0 {
+		return 1 // Errors already printed by doQuery
+	}
+
 	return 0
 }
 
+// dohOnlyFlags are rejected when --udp or --tcp is set
+var dohOnlyFlags = map[string]bool{
+	"g": true, "user-agent": true, "padding": true,
+	"ecs-remove": true, "ecs-request-ipv4-prefixlen": true, "ecs-request-ipv6-prefixlen": true, "ecs-set": true,
+	"tls-cert": true, "tls-key": true, "tls-other-roots": true, "tls-use-system-roots": true,
+}
+
 //////////////////////////////////////////////////////////////////////
 
-func doQuery(chOut, chErr chan string, dohResolver resolver.Resolver, qName string, qType uint16, short bool) {
+func doQuery(chOut, chErr chan string, res resolver.Resolver, qName string, qType uint16) {
 	outBuf := &bytes.Buffer{}
 	errBuf := &bytes.Buffer{}
 	defer func() {
 		chOut <- outBuf.String()
 		chErr <- errBuf.String()
 	}()
-	query := &dns.Msg{}
-	query.SetQuestion(dns.Fqdn(qName), qType)
-	resp, respMeta, err := dohResolver.Resolve(query, nil)
+	resp, respMeta, err := res.Resolve(newQuery(qName, qType), nil)
 	if err != nil {
 		fmt.Fprintln(errBuf, "Error:", err)
+		unpaddedResponses.Add(1) // No response is no padding as far as --require-padding is concerned
 		return
 	}
 
-	if short {
+	padding, padded := paddingString(resp, len(wireBytes(resp, respMeta)))
+	if !padded {
+		unpaddedResponses.Add(1)
+		if cfg.requirePadding {
+			fmt.Fprintln(errBuf, "Error: response is not padded")
+		}
+	}
+
+	switch {
+	case cfg.wire:
+		outBuf.Write(wireBytes(resp, respMeta))
+	case cfg.hexdump:
+		fmt.Fprintln(outBuf, hexDump(wireBytes(resp, respMeta)))
+	case cfg.short:
 		for _, rr := range resp.Answer {
 			fmt.Fprintln(outBuf, rr.String())
 		}
-	} else {
+	default:
 		fmt.Fprintln(outBuf, resp)
 
+		if !cfg.noIDN {
+			if uName := dnsutil.IDNToUnicode(qName); uName != qName {
+				fmt.Fprintf(outBuf, ";; IDN Query: %s\n", uName)
+			}
+		}
 		fmt.Fprintf(outBuf, ";; Query Time: %s/%s\n",
 			respMeta.TransportDuration.Truncate(time.Millisecond).String(),
 			respMeta.ResolutionDuration.Truncate(time.Millisecond).String())
 		fmt.Fprintf(outBuf, ";; Final Server: %s\n", respMeta.FinalServerUsed)
 		fmt.Fprintf(outBuf, ";; Tries: %d(queries) %d(servers)\n", respMeta.QueryTries, respMeta.ServerTries)
 		fmt.Fprintf(outBuf, ";; Payload Size: %d\n", respMeta.PayloadSize)
+		fmt.Fprintf(outBuf, ";; Padding: %s\n", padding)
+		if cfg.dnssec {
+			fmt.Fprintf(outBuf, ";; DNSSEC: %s\n", dnssecString(resp))
+		}
 		fmt.Fprintln(outBuf)
 	}
 }
+
+// paddingString describes the rfc7830 padding in the response and whether there is any. The
+// modulo is inferred from the wire size as the padding server does not otherwise reveal it.
+func paddingString(resp *dns.Msg, wireSize int) (string, bool) {
+	padLen := dnsutil.FindPadding(resp)
+	if padLen < 0 {
+		return "none", false
+	}
+	modulo := "not a multiple of a known padding modulo"
+	for _, m := range []uint{consts.Rfc8467ServerPadModulo, consts.Rfc8467ClientPadModulo} {
+		if wireSize > 0 && uint(wireSize)%m == 0 {
+			modulo = fmt.Sprintf("modulo %d", m)
+			break
+		}
+	}
+
+	return fmt.Sprintf("%d octets, wire size %d is %s", padLen, wireSize, modulo), true
+}
+
+// newQuery constructs the query issued by doQuery and runBench
+func newQuery(qName string, qType uint16) *dns.Msg {
+	query := &dns.Msg{}
+	query.SetQuestion(dns.Fqdn(qName), qType)
+	if cfg.debugMeta {
+		opt := dnsutil.NewOPT()
+		opt.Option = append(opt.Option, &dns.EDNS0_LOCAL{Code: consts.TrustyDebugMetaOption})
+		query.Extra = append(query.Extra, opt)
+	}
+	if cfg.dnssec {
+		dnsutil.SetDO(query)
+	}
+
+	return query
+}
+
+// dnssecString summarizes the DNSSEC state of the response. No validation is attempted, this merely
+// shows whether the server claims to have validated and whether the DNSSEC RRs made it through.
+func dnssecString(resp *dns.Msg) string {
+	do := false
+	if opt := resp.IsEdns0(); opt != nil {
+		do = opt.Do()
+	}
+	counts := make(map[uint16]int)
+	for _, rrs := range [][]dns.RR{resp.Answer, resp.Ns, resp.Extra} {
+		for _, rr := range rrs {
+			counts[rr.Header().Rrtype]++
+		}
+	}
+
+	return fmt.Sprintf("ad=%t do=%t RRSIG=%d DNSKEY=%d DS=%d NSEC=%d NSEC3=%d",
+		resp.AuthenticatedData, do, counts[dns.TypeRRSIG], counts[dns.TypeDNSKEY], counts[dns.TypeDS],
+		counts[dns.TypeNSEC], counts[dns.TypeNSEC3])
+}
+
+// wireBytes returns the response exactly as it arrived from the resolver if available, otherwise it
+// falls back to re-packing the response which may differ from what was actually on the wire.
+func wireBytes(resp *dns.Msg, respMeta *resolver.ResponseMetaData) []byte {
+	if len(respMeta.RawResponse) > 0 {
+		return respMeta.RawResponse
+	}
+	b, _ := resp.Pack() // An unpacked message should always re-pack
+	return b
+}
+
+// hexDump formats the wire bytes as a canonical hex+ASCII dump preceded by dig-style comments
+// showing the size and the decoded header counts so the reader has a head start on working out
+// where each section begins.
+func hexDump(wire []byte) string {
+	out := &bytes.Buffer{}
+	fmt.Fprintf(out, ";; Wire Size: %d\n", len(wire))
+	if len(wire) >= 12 {
+		fmt.Fprintf(out, ";; Header: id=%d flags=%04x qd=%d an=%d ns=%d ar=%d\n",
+			binary.BigEndian.Uint16(wire[0:]), binary.BigEndian.Uint16(wire[2:]),
+			binary.BigEndian.Uint16(wire[4:]), binary.BigEndian.Uint16(wire[6:]),
+			binary.BigEndian.Uint16(wire[8:]), binary.BigEndian.Uint16(wire[10:]))
+	}
+	out.WriteString(hex.Dump(wire))
+
+	return out.String()
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"strings"
 	"testing"
+
+	"github.com/miekg/dns"
 )
 
 type testCase struct {
@@ -22,8 +24,10 @@ var mainTestCases = []testCase{
 		"connection refused"},
 
 	{[]string{"localhost", "example.net"}, []string{}, "connection refused"},
+	{[]string{"http://localhost:63080", "müller.example"}, []string{}, "connection refused"},
 
 	{[]string{"-t", "xx", "http://localhost:63080", "example.net"}, []string{}, "invalid value"},
+	{[]string{"--short", "--wire", "http://localhost:63080", "example.net"}, []string{}, "Only one of"},
 	{[]string{"--tls-cert", "/dev/null", "http://localhost:63080", "example.net"}, []string{},
 		"key file missing"},
 
@@ -70,3 +74,78 @@ func runTest(t *testing.T, tx int, tc testCase) {
 		}
 	})
 }
+
+func TestHexDump(t *testing.T) {
+	wire := []byte{0x12, 0x34, 0x81, 0x80, 0, 1, 0, 2, 0, 0, 0, 1, 'a', 'b'}
+	out := hexDump(wire)
+	for _, expect := range []string{";; Wire Size: 14", "id=4660 flags=8180 qd=1 an=2 ns=0 ar=1",
+		"00000000  12 34 81 80", "|.4..........ab|"} {
+		if !strings.Contains(out, expect) {
+			t.Error("hexDump expected", expect, "Got:\n", out)
+		}
+	}
+}
+
+func TestPaddingString(t *testing.T) {
+	mainInit(&bytes.Buffer{}, &bytes.Buffer{})
+	resp := &dns.Msg{}
+	if s, padded := paddingString(resp, 100); padded || s != "none" {
+		t.Error("Unpadded response reported as", s, padded)
+	}
+
+	resp.Extra = append(resp.Extra, &dns.OPT{Option: []dns.EDNS0{&dns.EDNS0_PADDING{Padding: make([]byte, 20)}}})
+	for _, tc := range []struct {
+		wireSize int
+		expect   string
+	}{
+		{468, "20 octets, wire size 468 is modulo 468"},
+		{256, "20 octets, wire size 256 is modulo 128"},
+		{300, "20 octets, wire size 300 is not a multiple"},
+	} {
+		s, padded := paddingString(resp, tc.wireSize)
+		if !padded || !strings.Contains(s, tc.expect) {
+			t.Error("paddingString expected", tc.expect, "got", s, padded)
+		}
+	}
+}
+
+func TestRequirePadding(t *testing.T) {
+	args := []string{"trustydns-dig", "--tcp", "127.0.0.1:63053", "example.net"}
+	mainInit(&bytes.Buffer{}, &bytes.Buffer{})
+	if ec := mainExecute(args); ec != 0 {
+		t.Error("Failed query should not set exit code without --require-padding", ec)
+	}
+
+	args = append([]string{args[0], "--require-padding"}, args[1:]...)
+	mainInit(&bytes.Buffer{}, &bytes.Buffer{})
+	if ec := mainExecute(args); ec == 0 {
+		t.Error("Unpadded response should set exit code with --require-padding")
+	}
+}
+
+func TestDNSSEC(t *testing.T) {
+	mainInit(&bytes.Buffer{}, &bytes.Buffer{})
+	if q := newQuery("example.net", dns.TypeA); q.IsEdns0() != nil {
+		t.Error("Query should not have an OPT without --dnssec")
+	}
+	cfg.dnssec = true
+	q := newQuery("example.net", dns.TypeA)
+	if opt := q.IsEdns0(); opt == nil || !opt.Do() {
+		t.Error("--dnssec did not set DO in the query", q)
+	}
+
+	resp := &dns.Msg{}
+	resp.SetReply(q)
+	if s := dnssecString(resp); s != "ad=false do=false RRSIG=0 DNSKEY=0 DS=0 NSEC=0 NSEC3=0" {
+		t.Error("Unexpected dnssecString for empty response", s)
+	}
+	resp.AuthenticatedData = true
+	resp.SetEdns0(1232, true)
+	resp.Answer = append(resp.Answer, &dns.A{Hdr: dns.RR_Header{Rrtype: dns.TypeA}},
+		&dns.RRSIG{Hdr: dns.RR_Header{Rrtype: dns.TypeRRSIG}})
+	resp.Ns = append(resp.Ns, &dns.NSEC{Hdr: dns.RR_Header{Rrtype: dns.TypeNSEC}},
+		&dns.RRSIG{Hdr: dns.RR_Header{Rrtype: dns.TypeRRSIG}})
+	if s := dnssecString(resp); s != "ad=true do=true RRSIG=2 DNSKEY=0 DS=0 NSEC=1 NSEC3=0" {
+		t.Error("Unexpected dnssecString", s)
+	}
+}
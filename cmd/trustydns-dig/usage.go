@@ -16,16 +16,42 @@ NAME
 
 SYNOPSIS
           {{.DigProgramName}} [options] DoH-server-URL FQDN [DNS-qType]
+          {{.DigProgramName}} [options] --udp|--tcp DNS-server[:port] FQDN [DNS-qType]
+          {{.DigProgramName}} [options] --reverse DoH-server-URL IP-address [DNS-qType]
 
 DESCRIPTION
           {{.DigProgramName}} issues DNS over HTTPS queries to {{.ServerProgramName}}. Some options generate
           specific request features that are unlikely to be available in normal DoH servers.
           Only qClass=IN is supported. If a DNS-Type is not supplied then qType=A is used.
+          With --reverse, the FQDN is replaced by an IPv4 or IPv6 address which is converted to
+          its in-addr.arpa or ip6.arpa name and the default qType becomes PTR, as with dig -x.
+          An internationalized FQDN such as müller.example is converted to its xn-- A-label
+          form prior to querying unless --no-idn is set.
 
           The primary purpose of {{.DigProgramName}} is to issue queries exactly as they are issued
           by {{.ProxyProgramName}} and thus test the feature exchange between it and the {{.ServerProgramName}}.
           In fact {{.DigProgramName}} purposely uses the same packages as {{.ProxyProgramName}}.
 
+          With --udp or --tcp the query is sent to a traditional DNS server instead, much like
+          "dig @server", so that DoH and classic DNS responses can be compared side-by-side.
+          The output format is unchanged. With --udp a truncated response is retried over TCP.
+          DoH-specific options such as -g, --ecs-* and --tls-* cannot be used in this mode.
+
+          The default output reports whether the response contains rfc7830 padding and, if so,
+          whether the wire size is a multiple of an rfc8467 modulo. --require-padding causes
+          {{.DigProgramName}} to exit non-zero if any response is not padded, which is useful
+          for checking that a privacy-focused DoH server actually pads its responses.
+
+          --dnssec sets the DO bit in the query, much like dig +dnssec, and adds a summary of
+          the AD and DO bits and DNSSEC RR counts to the default output. No validation is
+          performed; the intent is to confirm that a DoH server preserves DNSSEC data.
+
+          With --bench the responses are not printed. Instead queries are issued by
+          --concurrency workers at up to --qps queries per second for --duration, or until
+          -r queries have been issued if --duration is not set. At completion, throughput,
+          error rate, latency percentiles and the distribution of responding servers and rcodes
+          are printed. This is intended for sizing a DoH deployment.
+
           **********
           Production Use Alert: {{.DigProgramName}} is a diagnostic program which will almost certainly
           change with each new package release. Please do not rely on its current behaviour
@@ -44,10 +70,18 @@ EXAMPLES
             $ {{.DigProgramName}} https://mozilla.cloudflare-dns.com/dns-query yahoo.com MX
             $ {{.DigProgramName}} --ecs-set 17.0.0.0/18 https://dns.quad9.net/dns-query yahoo.com
 
+          To compare with the same query over traditional DNS:
+
+            $ {{.DigProgramName}} --udp 9.9.9.9 yahoo.com MX
+
 OPTIONS
-          [-ghp] [--short]
+          [-ghp] [--short | --wire | --hexdump] [--debug-meta] [--no-idn] [--reverse]
+          [--require-padding] [--dnssec]
 
+          [--udp | --tcp]
+          [--bench [--concurrency N] [--qps rate] [--duration duration]]
           [-r repeat count] [-t remote request timeout]
+          [--user-agent string]
 
           [--ecs-remove]
             [                                                  **Either**
@@ -84,14 +118,30 @@ func usage(out io.Writer) {
 // parseCommandLine sets up the flags-to-config mapping and parses the supplied command line
 // arguments. It starts from scratch each time to make it eaiser for test wrappers to use.
 func parseCommandLine(args []string) error {
+	flagSet.BoolVar(&cfg.bench, "bench", false, "Load test the server and report aggregate statistics")
+	flagSet.IntVar(&cfg.benchConcurrency, "concurrency", 10, "`Number` of concurrent --bench workers")
+	flagSet.DurationVar(&cfg.benchDuration, "duration", 0, "Run --bench for this `duration` instead of -r queries")
+	flagSet.Float64Var(&cfg.benchQPS, "qps", 0, "Target --bench queries per second `rate` (0 is unlimited)")
+	flagSet.BoolVar(&cfg.dnssec, "dnssec", false, "Set DO in the query and summarize DNSSEC RRs in the response")
 	flagSet.BoolVar(&cfg.dohConfig.UseGetMethod, "g", false, "Use HTTP GET with the 'dns' query parameter (instead of POST)")
 	flagSet.BoolVar(&cfg.help, "h", false, "Print usage message to Stdout then exit(0)")
 	flagSet.BoolVar(&cfg.parallel, "p", false, "Issue all queries in parallel")
 	flagSet.IntVar(&cfg.repeatCount, "r", 1, "`Number` of times to issue the query (GE zero)")
 
+	flagSet.BoolVar(&cfg.noIDN, "no-idn", false, "Do not convert an internationalized FQDN to xn-- form")
+	flagSet.BoolVar(&cfg.requirePadding, "require-padding", false, "Exit non-zero if any response is not padded")
+	flagSet.BoolVar(&cfg.reverse, "reverse", false, "Convert the IP address argument into a PTR query (like dig -x)")
 	flagSet.BoolVar(&cfg.short, "short", false, "Generate short output showing only Answer RRs")
+	flagSet.BoolVar(&cfg.debugMeta, "debug-meta", false,
+		"Ask "+consts.ServerProgramName+" to return resolution meta data in the Additional section")
+	flagSet.BoolVar(&cfg.wire, "wire", false, "Write the raw wire-format response to stdout")
+	flagSet.BoolVar(&cfg.hexdump, "hexdump", false, "Generate a hex dump of the wire-format response")
 
+	flagSet.BoolVar(&cfg.tcp, "tcp", false, "Query a plain DNS server over TCP (server is `host[:port]`, not a URL)")
+	flagSet.BoolVar(&cfg.udp, "udp", false, "Query a plain DNS server over UDP with TCP fallback on truncation")
 	flagSet.DurationVar(&cfg.requestTimeout, "t", time.Second*15, "Remote request `timeout`")
+	flagSet.StringVar(&cfg.dohConfig.UserAgent, "user-agent", "",
+		"HTTP User-Agent `string` sent to DoH servers (default "+consts.PackageName+"/version)")
 
 	flagSet.BoolVar(&cfg.dohConfig.ECSRemove, "ecs-remove", false, "Remove inbound ECS")
 	flagSet.IntVar(&cfg.dohConfig.ECSRequestIPv4PrefixLen, "ecs-request-ipv4-prefixlen", 0,
@@ -42,8 +42,32 @@ var usageTestCases = []testCase{
 		"cert file missing"},
 
 	{[]string{"http://localhost:63080", "example.."}, []string{}, "Is it a valid FQDN"},
+	{[]string{"http://localhost:63080", "\u0300abc.example"}, []string{}, "Invalid IDN qName"},
+	{[]string{"--no-idn", "http://localhost:63080", "\u0300abc.example"}, []string{}, "connection refused"},
 
 	{[]string{"-r", "-1", "http://localhost:63080", "example.net"}, []string{}, "Repeat count"},
+
+	{[]string{"--udp", "--tcp", "127.0.0.1", "example.net"}, []string{}, "Only one of --udp or --tcp"},
+	{[]string{"--udp"}, []string{}, "Require DNS Server address"},
+	{[]string{"--tcp", "", "example.net"}, []string{}, "DNS Server address cannot be an empty string"},
+	{[]string{"--udp", "-g", "--ecs-set", "10.0.120.0/24", "127.0.0.1", "example.net"}, []string{},
+		"DoH options cannot be used with --udp or --tcp: ecs-set g"},
+	{[]string{"--udp", "http://localhost:63080", "example.net"}, []string{}, "plainresolver"},
+	{[]string{"--tcp", "127.0.0.1:63053", "example.net"}, []string{}, "connection refused"},
+
+	{[]string{"--reverse", "http://localhost:63080", "example.net"}, []string{}, "unrecognized address: example.net"},
+	{[]string{"--reverse", "http://localhost:63080", "192.0.2.1", "BADTYPE"}, []string{}, "Unrecognized qType"},
+	{[]string{"--reverse", "http://localhost:63080", "192.0.2.1"}, []string{}, "connection refused"},
+	{[]string{"--reverse", "http://localhost:63080", "2001:db8::1", "TXT"}, []string{}, "connection refused"},
+
+	{[]string{"--bench", "--short", "http://localhost:63080", "example.net"}, []string{}, "--bench cannot be combined"},
+	{[]string{"--bench", "-p", "http://localhost:63080", "example.net"}, []string{}, "--bench cannot be combined"},
+	{[]string{"--bench", "--concurrency", "0", "http://localhost:63080", "example.net"}, []string{},
+		"--concurrency must be GT zero"},
+	{[]string{"--bench", "--qps", "-1", "http://localhost:63080", "example.net"}, []string{}, "--qps must be GE zero"},
+	{[]string{"--bench", "--duration", "-1s", "http://localhost:63080", "example.net"}, []string{},
+		"--duration must be GE zero"},
+	{[]string{"--bench", "-r", "3", "--tcp", "127.0.0.1:63053", "example.net"}, []string{";; Errors: 3 (100.0%)"}, ""},
 }
 
 func TestUsage(t *testing.T) {
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/markdingo/trustydns/internal/resolver/doh"
+)
+
+// serverAlert is called by the DoH resolver when a server's error rate crosses
+// --server-alert-threshold in either direction. Alerts are printed regardless of the --log-*
+// settings as they are the whole point of setting the threshold.
+func serverAlert(a doh.Alert) {
+	state := "ALERT:"
+	if !a.Raised {
+		state = "ALERT CLEARED:"
+	}
+	fmt.Fprintf(stdout, "%s DoH server %s error rate %0.1f%% (%d of %d in %s) threshold %0.1f%%\n",
+		state, a.URL, a.Rate*100, a.Errors, a.Requests, a.Window, cfg.dohConfig.Alerts.Threshold*100)
+}
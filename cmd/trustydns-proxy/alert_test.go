@@ -0,0 +1,33 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/markdingo/trustydns/internal/resolver/doh"
+)
+
+func TestServerAlert(t *testing.T) {
+	out := &mutexBytesBuffer{}
+	errOut := &mutexBytesBuffer{}
+	mainInit(out, errOut)
+	cfg.dohConfig.Alerts.Threshold = 0.25
+
+	serverAlert(doh.Alert{URL: "https://a.example.net/dns-query", Raised: true,
+		Requests: 20, Errors: 6, Rate: 0.3, Window: time.Minute})
+	serverAlert(doh.Alert{URL: "https://a.example.net/dns-query", Raised: false,
+		Requests: 20, Errors: 5, Rate: 0.25, Window: time.Minute})
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatal("Expected two lines, got", out.String())
+	}
+	exp := "ALERT: DoH server https://a.example.net/dns-query error rate 30.0% (6 of 20 in 1m0s) threshold 25.0%"
+	if lines[0] != exp {
+		t.Error("Raised mismatch\nExp:", exp, "\nGot:", lines[0])
+	}
+	if !strings.HasPrefix(lines[1], "ALERT CLEARED: DoH server https://a.example.net/dns-query error rate 25.0%") {
+		t.Error("Cleared mismatch", lines[1])
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/markdingo/trustydns/internal/constants"
+	"github.com/markdingo/trustydns/internal/reporter"
+	"github.com/markdingo/trustydns/internal/resolver/doh"
+	"github.com/markdingo/trustydns/internal/resolver/local"
+
+	"github.com/miekg/dns"
+)
+
+// Feed genuine status report lines through tools/tdt-analyze-proxylog to make sure its patterns
+// track the report format.
+func TestAnalyzeProxyLog(t *testing.T) {
+	perl, err := exec.LookPath("perl")
+	if err != nil {
+		t.Skip("perl not available to run tools/tdt-analyze-proxylog")
+	}
+	out := &mutexBytesBuffer{}
+	mainInit(out, &mutexBytesBuffer{})
+	consts := constants.Get()
+	lr, err := local.New(local.Config{ResolvConfPath: "testdata/resolv.conf"})
+	if err != nil {
+		t.Fatal("Setup error", err)
+	}
+	urls := []string{"https://localhost/dns-query"}
+	dr, err := doh.New(doh.Config{ServerURLs: urls}, nil)
+	if err != nil {
+		t.Fatal("Setup error", err)
+	}
+	s := &server{stdout: stdout, listenAddress: "127.0.0.1:53", transport: "udp"}
+	var evs events
+	evs[evOutTruncated] = true
+	s.addSuccessStats(time.Second, 100, evs)
+	s.addFailureStats(serNoResponse, evs)
+	q := &dns.Msg{}
+	q.SetQuestion("example.net.", dns.TypeA)
+	s.addQueryTypeStats(q)
+	s.addRcodeStats(dns.RcodeSuccess)
+
+	fmt.Fprintln(out, consts.ProxyProgramName, consts.Version, "Starting:", urls)
+	fmt.Fprintln(out, "Starting", s.Name())
+	statusReport("Status", false, []reporter.Reporter{lr, dr, s})
+
+	cmd := exec.Command(perl, "../../tools/tdt-analyze-proxylog")
+	cmd.Stdin = strings.NewReader(out.String())
+	var analysis, warnings bytes.Buffer
+	cmd.Stdout = &analysis
+	cmd.Stderr = &warnings
+	if err := cmd.Run(); err != nil {
+		t.Fatal("tdt-analyze-proxylog failed", err, warnings.String())
+	}
+	if w := warnings.String(); strings.Contains(w, "Warning @") || strings.Contains(w, "uninitialized") {
+		t.Error("tdt-analyze-proxylog warned about the report\n", w, out.String())
+	}
+	// Listener start and stats, local Totals and four Servers, DoH Totals and one Server
+	if !strings.Contains(analysis.String(), "Analyzed: 9,") {
+		t.Error("tdt-analyze-proxylog did not analyze all status lines\n", analysis.String(), out.String())
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+// parseBootstrapResolver converts the --bootstrap-resolver IP[:port] value into an IP:port address
+// suitable for dialing. The DNS port is assumed if none is supplied. A hostname is rejected as it
+// would itself need resolving.
+func parseBootstrapResolver(s string) (string, error) {
+	if _, _, err := net.SplitHostPort(s); err != nil {
+		s = net.JoinHostPort(s, "53")
+	}
+	host, _, err := net.SplitHostPort(s)
+	if err != nil {
+		return "", err
+	}
+	if net.ParseIP(host) == nil {
+		return "", fmt.Errorf("%s is not an IP address", host)
+	}
+
+	return s, nil
+}
+
+// newBootstrapResolver returns a net.Resolver which sends all lookups to the plain DNS server at
+// address rather than to the system resolvers. It is only used to resolve the DoH server hostnames
+// which avoids the chicken-and-egg problem of the system resolver being this proxy.
+func newBootstrapResolver(address string) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true, // The cgo resolver ignores Dial
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, address)
+		},
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestParseBootstrapResolver(t *testing.T) {
+	testCases := []struct {
+		in     string
+		expect string
+		ok     bool
+	}{
+		{"127.0.0.1", "127.0.0.1:53", true},
+		{"127.0.0.1:5353", "127.0.0.1:5353", true},
+		{"::1", "[::1]:53", true},
+		{"[::1]:5353", "[::1]:5353", true},
+		{"resolver.example.net", "", false},
+		{"resolver.example.net:53", "", false},
+	}
+
+	for _, tc := range testCases {
+		got, err := parseBootstrapResolver(tc.in)
+		if tc.ok && (err != nil || got != tc.expect) {
+			t.Error(tc.in, "Expected", tc.expect, "got", got, err)
+		}
+		if !tc.ok && err == nil {
+			t.Error(tc.in, "Expected an error, got", got)
+		}
+	}
+}
+
+// Start a plain DNS server which answers all A queries with 127.0.0.1 and check that the DoH
+// server hostname is resolved via the bootstrap resolver and the connection made to the answer.
+func TestBootstrapResolver(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dnsServer := &dns.Server{PacketConn: pc, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, q *dns.Msg) {
+		r := &dns.Msg{}
+		r.SetReply(q)
+		if q.Question[0].Qtype == dns.TypeA {
+			a, _ := dns.NewRR(q.Question[0].Name + " 60 IN A 127.0.0.1")
+			r.Answer = append(r.Answer, a)
+		}
+		w.WriteMsg(r)
+	})}
+	go dnsServer.ActivateAndServe()
+	defer dnsServer.Shutdown()
+
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	mainInit(os.Stdout, os.Stderr)
+	cfg.bootstrapResolver = pc.LocalAddr().String()
+	for _, happyEyeballs := range []bool{false, true} {
+		cfg.happyEyeballs = happyEyeballs
+		conn, err := upstreamDialer(nil, nil)(context.Background(), "tcp", net.JoinHostPort("doh.trustydns.invalid", port))
+		if err != nil {
+			t.Fatal("Dial via bootstrap resolver failed", happyEyeballs, err)
+		}
+		conn.Close()
+	}
+}
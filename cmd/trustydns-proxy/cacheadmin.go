@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/markdingo/trustydns/internal/dnsutil"
+	"github.com/markdingo/trustydns/internal/resolver/cache"
+)
+
+// Paths on the --debug-listen server which administer the response cache
+const (
+	cacheEntriesPath = "/cache/entries"
+	cacheFlushPath   = "/cache/flush"
+)
+
+// flushCache removes the entries for qName, or all entries if qName is empty, and returns the
+// number removed.
+func flushCache(c *cache.Cache, qName string) int {
+	var n int
+	if len(qName) > 0 {
+		n = c.FlushName(qName)
+	} else {
+		n = c.Flush()
+	}
+	if cfg.verbose {
+		fmt.Fprintln(stdout, "Cache flushed", qName, n)
+	}
+
+	return n
+}
+
+// cacheFlushHandler flushes the cache entries for the "name" query parameter or the whole cache if
+// there is no name. Only POST and DELETE are accepted so that an inadvertent GET changes nothing.
+func cacheFlushHandler(c *cache.Cache) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+			w.Header().Set("Allow", http.MethodPost+", "+http.MethodDelete)
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		qName := r.URL.Query().Get("name")
+		if len(qName) > 0 {
+			if err := dnsutil.ValidateName(qName); err != nil {
+				http.Error(w, "Bad name: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		fmt.Fprintf(w, "flushed=%d\n", flushCache(c, qName))
+	})
+}
+
+// cacheEntriesHandler returns a JSON snapshot of the unexpired cache entries
+func cacheEntriesHandler(c *cache.Cache) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(c.Entries())
+	})
+}
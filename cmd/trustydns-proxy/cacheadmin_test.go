@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/markdingo/trustydns/internal/resolver/cache"
+
+	"github.com/miekg/dns"
+)
+
+func TestCacheAdminHandlers(t *testing.T) {
+	mainInit(os.Stdout, os.Stderr)
+	aResp := &dns.Msg{}
+	a, _ := dns.NewRR("example.com. 600 IN A 192.0.2.33")
+	aResp.Answer = []dns.RR{a}
+
+	c := cache.New(cache.Config{})
+	r := c.Wrap(&qTypeResolver{responses: map[uint16]*dns.Msg{dns.TypeA: aResp}})
+	for _, qName := range []string{"example.com.", "example.net.", "example.org."} {
+		q := &dns.Msg{}
+		q.SetQuestion(qName, dns.TypeA)
+		r.Resolve(q, nil)
+	}
+	if !strings.HasPrefix(c.Report(false), "entries=3 ") {
+		t.Fatal("Setup expected 3 cache entries", c.Report(false))
+	}
+
+	w := httptest.NewRecorder()
+	cacheEntriesHandler(c).ServeHTTP(w, httptest.NewRequest(http.MethodGet, cacheEntriesPath, nil))
+	var infos []cache.EntryInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &infos); err != nil {
+		t.Fatal("Entries did not return JSON", err, w.Body.String())
+	}
+	if len(infos) != 3 || infos[0].Key != "example.com./1/1" || infos[0].Answer != "A*192.0.2.33" {
+		t.Error("Unexpected entries", infos)
+	}
+	w = httptest.NewRecorder()
+	cacheEntriesHandler(c).ServeHTTP(w, httptest.NewRequest(http.MethodPost, cacheEntriesPath, nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Error("Expected POST of entries to be rejected, got", w.Code)
+	}
+
+	h := cacheFlushHandler(c)
+	testCases := []struct {
+		method  string
+		target  string
+		status  int
+		body    string
+		entries string
+	}{
+		{http.MethodGet, cacheFlushPath, http.StatusMethodNotAllowed, "Method Not Allowed", "entries=3 "},
+		{http.MethodPost, cacheFlushPath + "?name=a..b", http.StatusBadRequest, "Bad name", "entries=3 "},
+		{http.MethodDelete, cacheFlushPath + "?name=EXAMPLE.com", http.StatusOK, "flushed=1", "entries=2 "},
+		{http.MethodPost, cacheFlushPath, http.StatusOK, "flushed=2", "entries=0 "},
+	}
+	for _, tc := range testCases {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(tc.method, tc.target, nil))
+		if w.Code != tc.status || !strings.Contains(w.Body.String(), tc.body) {
+			t.Error(tc.method, tc.target, "Expected", tc.status, tc.body, "got", w.Code, w.Body.String())
+		}
+		if !strings.HasPrefix(c.Report(false), tc.entries) {
+			t.Error(tc.method, tc.target, "Expected", tc.entries, "got", c.Report(false))
+		}
+	}
+}
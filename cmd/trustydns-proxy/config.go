@@ -8,28 +8,74 @@ import (
 )
 
 type config struct {
-	gops    bool
-	help    bool
-	tcp     bool // Listen on TCP
-	udp     bool // Listen on UDP
-	verbose bool
-	version bool
+	configFile string // --config file providing defaults for options not on the command line
+
+	check     bool // Validate configuration then exit
+	gops      bool
+	help      bool
+	reusePort bool // Set SO_REUSEPORT on listen sockets
+	systemd   bool // Adopt listen sockets from systemd socket activation
+	tcp       bool // Listen on TCP
+	udp       bool // Listen on UDP
+	verbose   bool
+	version   bool
+
+	versionJSON bool // Print build metadata as JSON
+	tcpFastOpen bool // Set TCP_FASTOPEN_CONNECT on connections to DoH servers
+
+	happyEyeballs    bool // Race connections to all DoH server addresses (RFC8305)
+	upstreamIPv4Only bool // Only connect to DoH servers over IPv4
+	upstreamIPv6Only bool // Only connect to DoH servers over IPv6
 
 	listenAddresses flagutil.StringValue // Listen address for inbound DNS queries
+	interfaces      flagutil.StringValue // Listen on all addresses of these interfaces
 
 	localResolvConf string
 	localDomains    flagutil.StringValue // In addition to those in resolv.conf
+	typeRoutes      flagutil.StringValue // qtype=local|remote overrides of split-horizon routing
 	statusInterval  time.Duration
+	reportFormat    string // text or json
 
 	maximumRemoteConnections int
 	requestTimeout           time.Duration
+	queryTimeout             time.Duration // Overall resolution deadline including fallback if GT zero
+	pinServer                string        // Send all queries to this DoH server URL - for diagnostics
+	serversFile              string        // JSON file of DoH servers with per-server settings
+	defaultResolver          string        // Plain DNS server of last resort if GT zero length
+	bootstrapResolver        string        // Plain DNS server used to resolve DoH server hostnames
+	cacheSize                int           // Maximum cached responses - zero disables the cache
+	cacheMaxTTL              time.Duration // Upper bound on how long a response is cached
+	cacheMaxMemory           int           // Estimated byte budget for cached responses if GT zero
+	cacheMaxNegativeTTL      time.Duration // Upper bound on how long NXDOMAIN and NODATA are cached if GT zero
+	warmStateFile            string        // Most frequent queries saved on shutdown and warmed on startup
+	warmStateEntries         int           // Number of queries saved in warmStateFile
+	tcpKeepaliveTimeout      time.Duration // Advertised via EDNS0 TCP Keepalive if GT zero
+	minAnswerTTL             time.Duration // Floor for Answer TTLs returned to clients if GT zero
 	ecsSet                   string
+	shuffleAnswers           bool // Randomly permute RRs within each Answer RRset
+	filterA                  bool // Remove A RRs from the Answer section
+	filterAAAA               bool // Remove AAAA RRs from the Answer section
+	maxUDPSize               int  // Truncation limit for UDP responses - overrides client EDNS0 if GT zero
+	dns64                    bool // Synthesize AAAA RRs from A RRs
+	dns64Prefix              string
+	onFailure                string // One of "drop" or a key in onFailureRcodes
+
+	serverIPs    flagutil.StringValue // hostname=IP pins which bypass DoH server lookups
+	preserveEDNS flagutil.StringValue // EDNS0 options forwarded to DoH servers - others are removed
+
+	rebindProtect string               // One of "off", "strip" or "nxdomain"
+	rebindAllow   flagutil.StringValue // Domains exempt from --rebind-protect
 
 	logAll       bool // Turns on all other log options
+	logDetail    bool // Include more detail in compact prints of DNS messages
 	logClientIn  bool // Print the DNS query arriving from the client
 	logClientOut bool // Print the DNS response returned to the client
 	logTLSErrors bool // Print x509 errors returned from the DoH Resolver
 
+	slowQueryThreshold time.Duration // Always print queries which take longer than this if GT zero
+
+	trace bool // Send a trace ID with each DoH query and append it to log lines
+
 	tlsClientCertFile   string // Connect to the DoH Server using these credentials
 	tlsClientKeyFile    string
 	tlsCAFiles          flagutil.StringValue // Non-system root CAs to validate DoH Servers
@@ -38,6 +84,8 @@ type config struct {
 	dohConfig doh.Config
 
 	cpuprofile, memprofile string
+	profileDir             string // SIGUSR2 writes goroutine and heap profiles here
+	debugListen            string // Loopback address serving /debug/vars and /debug/pprof
 
 	setuidName, setgidName, chrootDir string // Process constraint settings
 }
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/markdingo/trustydns/internal/resolver"
+
+	"github.com/miekg/dns"
+)
+
+// parseDNS64Prefix parses and validates a NAT64 prefix. rfc6052 Section 2.2 only allows prefix
+// lengths of 32, 40, 48, 56, 64 or 96.
+func parseDNS64Prefix(cidr string) (*net.IPNet, error) {
+	ip, prefix, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+	if ip.To4() != nil {
+		return nil, errors.New("DNS64 prefix must be an IPv6 CIDR: " + cidr)
+	}
+	switch ones, _ := prefix.Mask.Size(); ones {
+	case 32, 40, 48, 56, 64, 96:
+	default:
+		return nil, errors.New("DNS64 prefix length must be one of 32, 40, 48, 56, 64 or 96: " + cidr)
+	}
+
+	return prefix, nil
+}
+
+// embedIPv4 creates an IPv4-embedded IPv6 address as described in rfc6052 Section 2.2. Bits 64-71
+// (the "u" octet) are always zero so the IPv4 address is split around them for prefix lengths less
+// than 96.
+func embedIPv4(prefix *net.IPNet, ip4 net.IP) net.IP {
+	ip6 := make(net.IP, net.IPv6len)
+	copy(ip6, prefix.IP.To16())
+	ones, _ := prefix.Mask.Size()
+	ox := ones / 8
+	for _, b := range ip4.To4() {
+		if ox == 8 { // Skip the u octet
+			ox++
+		}
+		ip6[ox] = b
+		ox++
+	}
+
+	return ip6
+}
+
+// synthesizeDNS64 implements the core of rfc6147. If the response to an AAAA query contains no AAAA
+// RRs, query for A RRs and synthesize AAAA RRs from them using the NAT64 prefix. The response is
+// modified in-situ. Return true if AAAA RRs were synthesized.
+//
+// Responses with AD=1 are never modified as the synthesized RRs cannot validate. Any CNAMEs
+// returned by the A query are retained ahead of the synthesized AAAA RRs. Synthesized TTLs are
+// limited to the SOA minimum of the negative AAAA response, as per rfc6147 Section 5.1.7.
+//
+// If protector is non-nil it is applied to the A response prior to synthesis so that private A RRs
+// cannot be used to bypass --rebind-protect. The A query is bound by ctx so that it remains within
+// the --query-timeout deadline of the original query.
+func synthesizeDNS64(ctx context.Context, res resolver.Resolver, query, resp *dns.Msg,
+	qMeta *resolver.QueryMetaData, prefix *net.IPNet, protector *rebindProtector) bool {
+	if len(query.Question) != 1 || query.Question[0].Qtype != dns.TypeAAAA || resp.AuthenticatedData {
+		return false
+	}
+	if resp.Rcode != dns.RcodeSuccess {
+		return false
+	}
+	for _, rr := range resp.Answer {
+		if rr.Header().Rrtype == dns.TypeAAAA {
+			return false // Real AAAAs always win
+		}
+	}
+
+	maxTTL := ^uint32(0)
+	for _, rr := range resp.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			maxTTL = soa.Minttl
+			if soa.Hdr.Ttl < maxTTL {
+				maxTTL = soa.Hdr.Ttl
+			}
+		}
+	}
+
+	aQuery := query.Copy()
+	aQuery.Question[0].Qtype = dns.TypeA
+	aResp, _, err := resolver.ResolveContext(ctx, res, aQuery, qMeta)
+	if err != nil || aResp.Rcode != dns.RcodeSuccess || aResp.AuthenticatedData {
+		return false
+	}
+	if protector != nil {
+		protector.filter(aQuery, aResp)
+		if aResp.Rcode != dns.RcodeSuccess { // nxdomain mode
+			return false
+		}
+	}
+
+	answer := make([]dns.RR, 0, len(aResp.Answer))
+	synthesized := false
+	for _, rr := range aResp.Answer {
+		switch a := rr.(type) {
+		case *dns.CNAME:
+			answer = append(answer, a)
+		case *dns.A:
+			ttl := a.Hdr.Ttl
+			if ttl > maxTTL {
+				ttl = maxTTL
+			}
+			aaaa := &dns.AAAA{Hdr: dns.RR_Header{Name: a.Hdr.Name, Rrtype: dns.TypeAAAA,
+				Class: a.Hdr.Class, Ttl: ttl}, AAAA: embedIPv4(prefix, a.A)}
+			answer = append(answer, aaaa)
+			synthesized = true
+		}
+	}
+	if !synthesized {
+		return false
+	}
+
+	resp.Answer = answer
+	resp.Ns = nil // The negative AAAA SOA no longer applies
+
+	return true
+}
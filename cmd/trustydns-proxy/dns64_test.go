@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/markdingo/trustydns/internal/resolver"
+
+	"github.com/miekg/dns"
+)
+
+// qTypeResolver returns a response based on the qType of the query.
+type qTypeResolver struct {
+	responses map[uint16]*dns.Msg
+}
+
+func (t *qTypeResolver) InBailiwick(qname string) bool {
+	return true
+}
+
+func (t *qTypeResolver) Resolve(query *dns.Msg, qMeta *resolver.QueryMetaData) (*dns.Msg, *resolver.ResponseMetaData, error) {
+	r := t.responses[query.Question[0].Qtype].Copy()
+	return r, &resolver.ResponseMetaData{PayloadSize: r.Len()}, nil
+}
+
+type embedCase struct {
+	prefix string
+	ip4    string
+	ip6    string
+}
+
+// Examples from rfc6052 Section 2.4
+var embedCases = []embedCase{
+	{"2001:db8::/32", "192.0.2.33", "2001:db8:c000:221::"},
+	{"2001:db8:100::/40", "192.0.2.33", "2001:db8:1c0:2:21::"},
+	{"2001:db8:122::/48", "192.0.2.33", "2001:db8:122:c000:2:2100::"},
+	{"2001:db8:122:300::/56", "192.0.2.33", "2001:db8:122:3c0:0:221::"},
+	{"2001:db8:122:344::/64", "192.0.2.33", "2001:db8:122:344:c0:2:2100:0"},
+	{"2001:db8:122:344::/96", "192.0.2.33", "2001:db8:122:344::c000:221"},
+	{"64:ff9b::/96", "192.0.2.33", "64:ff9b::c000:221"},
+}
+
+func TestEmbedIPv4(t *testing.T) {
+	for _, tc := range embedCases {
+		prefix, err := parseDNS64Prefix(tc.prefix)
+		if err != nil {
+			t.Fatal("Unexpected parse error", tc.prefix, err)
+		}
+		got := embedIPv4(prefix, net.ParseIP(tc.ip4))
+		if !got.Equal(net.ParseIP(tc.ip6)) {
+			t.Error(tc.prefix, "Expected", tc.ip6, "got", got)
+		}
+	}
+}
+
+func TestServerDNS64(t *testing.T) {
+	mainInit(os.Stdout, os.Stderr)
+	dns64Prefix, _ = parseDNS64Prefix("64:ff9b::/96")
+
+	noAAAA := &dns.Msg{}
+	soa, _ := dns.NewRR("example.com. 3600 IN SOA ns.example.com. hostmaster.example.com. 1 2 3 4 300")
+	noAAAA.Ns = []dns.RR{soa}
+	aResp := &dns.Msg{}
+	cname, _ := dns.NewRR("www.example.com. 600 IN CNAME example.com.")
+	a1, _ := dns.NewRR("example.com. 600 IN A 192.0.2.33")
+	a2, _ := dns.NewRR("example.com. 60 IN A 192.0.2.34")
+	aResp.Answer = []dns.RR{cname, a1, a2}
+
+	res := &qTypeResolver{responses: map[uint16]*dns.Msg{dns.TypeAAAA: noAAAA, dns.TypeA: aResp}}
+	s := &server{stdout: stdout, remote: res, transport: "udp"}
+	q := &dns.Msg{}
+	q.SetQuestion("www.example.com.", dns.TypeAAAA)
+	mw := &mockResponseWriter{}
+	s.ServeDNS(mw, q)
+	if mw.messageWritten == nil {
+		t.Fatal("Test setup failed as response never got written to mockResponseWriter")
+	}
+	r := mw.messageWritten
+	if len(r.Answer) != 3 {
+		t.Fatal("Expected CNAME and two synthesized AAAAs, got", r.Answer)
+	}
+	if _, ok := r.Answer[0].(*dns.CNAME); !ok {
+		t.Error("CNAME not retained as first Answer", r.Answer)
+	}
+	aaaa, ok := r.Answer[1].(*dns.AAAA)
+	if !ok || !aaaa.AAAA.Equal(net.ParseIP("64:ff9b::c000:221")) {
+		t.Error("First AAAA not synthesized correctly", r.Answer[1])
+	}
+	if aaaa != nil && aaaa.Hdr.Ttl != 300 {
+		t.Error("Synthesized TTL should be capped by SOA minimum, not", aaaa.Hdr.Ttl)
+	}
+	if r.Answer[2].Header().Ttl != 60 {
+		t.Error("Synthesized TTL should retain a lower A TTL, not", r.Answer[2].Header().Ttl)
+	}
+	if len(r.Ns) != 0 {
+		t.Error("Negative SOA should have been removed", r.Ns)
+	}
+	if s.eventCounters[evDNS64] != 1 {
+		t.Error("DNS64 event not counted", s.eventCounters)
+	}
+
+	// Real AAAAs and AD=1 should inhibit synthesis
+	real, _ := dns.NewRR("example.com. IN AAAA 2001:db8::1")
+	res.responses[dns.TypeAAAA] = &dns.Msg{Answer: []dns.RR{real}}
+	s.ServeDNS(mw, q)
+	if len(mw.messageWritten.Answer) != 1 || mw.messageWritten.Answer[0].String() != real.String() {
+		t.Error("DNS64 synthesis should not occur with real AAAAs", mw.messageWritten.Answer)
+	}
+	ad := noAAAA.Copy()
+	ad.AuthenticatedData = true
+	res.responses[dns.TypeAAAA] = ad
+	s.ServeDNS(mw, q)
+	if len(mw.messageWritten.Answer) != 0 {
+		t.Error("DNS64 synthesis should not occur with AD=1", mw.messageWritten.Answer)
+	}
+	dns64Prefix = nil
+}
+
+// Private A RRs must not be synthesized into AAAAs when --rebind-protect is active
+func TestServerDNS64Rebind(t *testing.T) {
+	mainInit(os.Stdout, os.Stderr)
+	dns64Prefix, _ = parseDNS64Prefix("64:ff9b::/96")
+	for _, mode := range []string{"strip", "nxdomain"} {
+		rebind, _ = newRebindProtector(mode, nil)
+		private, _ := dns.NewRR("example.com. 600 IN A 10.0.0.1")
+		res := &qTypeResolver{responses: map[uint16]*dns.Msg{dns.TypeAAAA: &dns.Msg{},
+			dns.TypeA: &dns.Msg{Answer: []dns.RR{private}}}}
+		s := &server{stdout: stdout, remote: res, transport: "udp"}
+		q := &dns.Msg{}
+		q.SetQuestion("example.com.", dns.TypeAAAA)
+		mw := &mockResponseWriter{}
+		s.ServeDNS(mw, q)
+		if mw.messageWritten == nil {
+			t.Fatal("Test setup failed as response never got written to mockResponseWriter")
+		}
+		if len(mw.messageWritten.Answer) != 0 {
+			t.Error(mode, "Private A RR should not be synthesized", mw.messageWritten.Answer)
+		}
+		if s.eventCounters[evDNS64] != 0 {
+			t.Error(mode, "DNS64 event should not be counted", s.eventCounters)
+		}
+	}
+	rebind = nil
+	dns64Prefix = nil
+}
+
+// The A query must honour the deadline of the original query
+func TestDNS64Context(t *testing.T) {
+	prefix, _ := parseDNS64Prefix("64:ff9b::/96")
+	a, _ := dns.NewRR("example.com. 600 IN A 192.0.2.33")
+	res := &qTypeResolver{responses: map[uint16]*dns.Msg{dns.TypeA: &dns.Msg{Answer: []dns.RR{a}}}}
+	q := &dns.Msg{}
+	q.SetQuestion("example.com.", dns.TypeAAAA)
+	ctx, cancel := context.WithCancel(context.Background())
+	if !synthesizeDNS64(ctx, res, q, &dns.Msg{}, &resolver.QueryMetaData{}, prefix, nil) {
+		t.Error("Expected synthesis with a live context")
+	}
+	cancel()
+	if synthesizeDNS64(ctx, res, q, &dns.Msg{}, &resolver.QueryMetaData{}, prefix, nil) {
+		t.Error("Synthesis should not occur once the context is done")
+	}
+}
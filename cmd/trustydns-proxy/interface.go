@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+// interfaceAddresses returns the addresses currently assigned to the named interfaces. IPv6
+// link-local addresses are skipped as they are unusable without a zone.
+func interfaceAddresses(names []string) ([]string, error) {
+	var addrs []string
+	for _, name := range names {
+		ifi, err := net.InterfaceByName(name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err.Error())
+		}
+		ifAddrs, err := ifi.Addrs()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err.Error())
+		}
+		for _, a := range ifAddrs {
+			ipNet, ok := a.(*net.IPNet)
+			if !ok || ipNet.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			addrs = append(addrs, ipNet.IP.String())
+		}
+	}
+
+	return addrs, nil
+}
+
+// interfaceServers tracks the servers started on --interface addresses so they can be adjusted
+// when the addresses change. The key is the unadorned IP address.
+type interfaceServers map[string][]*server
+
+// rebind re-resolves the --interface addresses. Servers on addresses which have gone away are
+// stopped and removed from the servers list and servers are started on new addresses with the
+// start function. The adjusted servers list is returned.
+//
+// Note that if the process has been constrained with --user, binding to privileged ports fails.
+func (t interfaceServers) rebind(servers []*server, start func(addr string) []*server) ([]*server, error) {
+	addrs, err := interfaceAddresses(cfg.interfaces.Args())
+	if err != nil {
+		return servers, err
+	}
+
+	current := make(map[string]bool)
+	for _, addr := range addrs {
+		current[addr] = true
+	}
+
+	stopped := make(map[*server]bool)
+	for addr, ss := range t {
+		if current[addr] {
+			continue
+		}
+		for _, s := range ss {
+			if cfg.verbose {
+				fmt.Fprintln(stdout, "Stopping", s.Name())
+			}
+			s.stop()
+			stopped[s] = true
+		}
+		delete(t, addr)
+	}
+
+	var kept []*server
+	for _, s := range servers {
+		if !stopped[s] {
+			kept = append(kept, s)
+		}
+	}
+	for _, addr := range addrs {
+		if _, ok := t[addr]; !ok {
+			t[addr] = start(addr)
+			kept = append(kept, t[addr]...)
+		}
+	}
+
+	return kept, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+// loopbackName returns the name of the loopback interface as it varies by OS
+func loopbackName(t *testing.T) string {
+	ifs, err := net.Interfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ifi := range ifs {
+		if ifi.Flags&net.FlagLoopback != 0 {
+			return ifi.Name
+		}
+	}
+	t.Skip("No loopback interface found")
+
+	return ""
+}
+
+func TestInterfaceAddresses(t *testing.T) {
+	lo := loopbackName(t)
+	addrs, err := interfaceAddresses([]string{lo})
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	found := false
+	for _, a := range addrs {
+		if a == "127.0.0.1" || a == "::1" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("Expected a loopback address from", lo, "Got", addrs)
+	}
+
+	_, err = interfaceAddresses([]string{"nosuchinterface0"})
+	if err == nil || !strings.Contains(err.Error(), "nosuchinterface0") {
+		t.Error("Expected error naming the bogus interface, not", err)
+	}
+}
+
+func TestInterfaceRebind(t *testing.T) {
+	mainInit(&mutexBytesBuffer{}, &mutexBytesBuffer{})
+	lo := loopbackName(t)
+	cfg.interfaces.Set(lo)
+
+	gone := &server{listenAddress: "192.0.2.1:53"} // Never started so stop() is benign
+	static := &server{listenAddress: "192.0.2.2:53"}
+	ifs := interfaceServers{"192.0.2.1": []*server{gone}}
+
+	var started []string
+	start := func(addr string) []*server {
+		started = append(started, addr)
+		return []*server{{listenAddress: addr}}
+	}
+	servers, err := ifs.rebind([]*server{static, gone}, start)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if _, ok := ifs["192.0.2.1"]; ok {
+		t.Error("Departed address should have been removed", ifs)
+	}
+	if len(started) == 0 || len(ifs) != len(started) {
+		t.Error("Expected servers started on loopback addresses", started, ifs)
+	}
+	if len(servers) != len(started)+1 || servers[0] != static {
+		t.Error("Server list should contain static server plus new servers", servers)
+	}
+
+	started = nil // A second rebind should be a noop
+	servers, err = ifs.rebind(servers, start)
+	if err != nil || len(started) != 0 || len(servers) != len(ifs)+1 {
+		t.Error("Second rebind should not change anything", err, started, servers)
+	}
+}
@@ -2,12 +2,13 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
-	"net/url"
 	"os"
 	"runtime"
 	"runtime/pprof"
@@ -20,14 +21,17 @@ import (
 	gops "github.com/google/gops/agent"
 
 	"github.com/markdingo/trustydns/internal/constants"
+	"github.com/markdingo/trustydns/internal/debugserver"
+	"github.com/markdingo/trustydns/internal/dnsutil"
+	"github.com/markdingo/trustydns/internal/happyeyeballs"
 	"github.com/markdingo/trustydns/internal/osutil"
 	"github.com/markdingo/trustydns/internal/reporter"
 	"github.com/markdingo/trustydns/internal/resolver"
+	"github.com/markdingo/trustydns/internal/resolver/cache"
 	"github.com/markdingo/trustydns/internal/resolver/doh"
 	"github.com/markdingo/trustydns/internal/resolver/local"
+	"github.com/markdingo/trustydns/internal/resolver/plain"
 	"github.com/markdingo/trustydns/internal/tlsutil"
-
-	"golang.org/x/net/http2"
 )
 
 // Program-wide variables
@@ -35,6 +39,9 @@ var (
 	consts           = constants.Get()
 	cfg              *config
 	listenTransports = []string{}
+	dns64Prefix      *net.IPNet       // Set if --dns64 is active
+	rebind           *rebindProtector // Set if --rebind-protect is active
+	warmRecorder     *warmState       // Set if --warm-state-file is active
 
 	stdout io.Writer // All I/O goes via these writers
 	stderr io.Writer
@@ -57,6 +64,18 @@ func stopMain() {
 	stopChannel <- syscall.SIGINT
 }
 
+// writeProfiles is called on SIGUSR2 when --profile-dir is set. Failure is not fatal as the process
+// is otherwise unaffected. Note that dir is relative to any --chroot.
+func writeProfiles(dir string) {
+	paths, err := osutil.WriteProfiles(dir, consts.ProxyProgramName, time.Now())
+	for _, p := range paths {
+		fmt.Fprintln(stdout, "\nProfile written:", p)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "Error: --profile-dir", err)
+	}
+}
+
 //////////////////////////////////////////////////////////////////////
 // main wrappers make it easy for test programs
 //////////////////////////////////////////////////////////////////////
@@ -68,6 +87,9 @@ func stopMain() {
 func mainInit(out io.Writer, err io.Writer) {
 	cfg = &config{}
 	listenTransports = []string{}
+	dns64Prefix = nil
+	rebind = nil
+	warmRecorder = nil
 	stdout = out
 	stderr = err
 	mainState(initial)
@@ -96,138 +118,23 @@ func mainExecute(args []string) int {
 		fmt.Fprintln(stdout, consts.ProxyProgramName, "Version:", consts.Version)
 		return 0
 	}
-
-	if cfg.logAll {
-		cfg.logClientIn = true
-		cfg.logClientOut = true
-		cfg.logTLSErrors = true
-	}
-
-	// Validate transport settings
-
-	if cfg.udp {
-		listenTransports = append(listenTransports, consts.DNSUDPTransport)
-	}
-	if cfg.tcp {
-		listenTransports = append(listenTransports, consts.DNSTCPTransport)
-	}
-	if len(listenTransports) == 0 {
-		return fatal("Must have one of --tcp or --udp set")
-	}
-
-	// Validate ECS settings. These settings are also validated by the DoH resolver, but we
-	// check them here as well as we can generate a more meaningful error message that equates
-	// back the the command-line options whereas the DoH resolver really has no clue as to where
-	// its config values came from and thus produces somewhat generic error messages.
-
-	if cfg.dohConfig.UseGetMethod { // No ECS synthesis is possible with GET due to possible bogus caching
-		if len(cfg.ecsSet) > 0 ||
-			cfg.dohConfig.ECSRequestIPv4PrefixLen > 0 || cfg.dohConfig.ECSRequestIPv6PrefixLen > 0 {
-			return fatal("Cannot have any ECS synthesis options when using HTTP GET")
-		}
-	}
-
-	var ecsIPNet *net.IPNet
-	if len(cfg.ecsSet) > 0 {
-		var err error
-		_, ecsIPNet, err = net.ParseCIDR(cfg.ecsSet)
-		if err != nil {
-			return fatal("--ecs-set", err)
-		}
-		if cfg.dohConfig.ECSRequestIPv4PrefixLen != 0 || cfg.dohConfig.ECSRequestIPv6PrefixLen != 0 {
-			return fatal("Cannot have both --ecs-set and --ecs-request-* options set at the same time")
-		}
-	}
-
-	if cfg.dohConfig.ECSRequestIPv4PrefixLen < 0 || cfg.dohConfig.ECSRequestIPv4PrefixLen > 32 {
-		return fatal("--ecs-request-ipv4-prefixlen", cfg.dohConfig.ECSRequestIPv4PrefixLen,
-			"must be between 0 and 32")
-	}
-	if cfg.dohConfig.ECSRequestIPv6PrefixLen < 0 || cfg.dohConfig.ECSRequestIPv6PrefixLen > 128 {
-		return fatal("--ecs-request-ipv6-prefixlen", cfg.dohConfig.ECSRequestIPv6PrefixLen,
-			"must be between 0 and 128")
-	}
-
-	// Validate server URLs
-
-	for _, dohURL := range flagSet.Args() {
-		u, err := url.Parse(dohURL)
-		if err != nil {
-			return fatal(err)
-		}
-		if len(u.Scheme) == 0 && len(u.Host) == 0 && len(u.Path) > 0 { // A plain FQDN looks like this
-			u.Host = u.Path
-			u.Path = ""
-		}
-		if len(u.Host) == 0 {
-			return fatal(dohURL, "does not contain a hostname")
-		}
-		if len(u.Scheme) == 0 {
-			u.Scheme = "https"
-		}
-		cfg.dohConfig.ServerURLs = append(cfg.dohConfig.ServerURLs, u.String())
-	}
-
-	if len(cfg.dohConfig.ServerURLs) == 0 {
-		return fatal("Must supply at least one DoH server URL on the command line")
-	}
-
-	if cfg.maximumRemoteConnections < 1 {
-		return fatal("Minimum remote concurrency must be greater than zero (-r)")
-	}
-
-	var reporters []reporter.Reporter // Keep track of all reportable routines
-	var servers []*server             // Keep track of all servers so we can shut then down
-
-	// localResolver handles split-horizon domains
-
-	if len(cfg.localResolvConf) == 0 && cfg.localDomains.NArg() > 0 {
-		return fatal("Local Domains (-e) cannot be resolved without a resolv.conf (-c)")
-	}
-
-	var localResolver resolver.Resolver
-	var localDomains []string
-	if len(cfg.localResolvConf) > 0 {
-		lr, err := local.New(local.Config{
-			ResolvConfPath: cfg.localResolvConf, LocalDomains: cfg.localDomains.Args()})
-		if err != nil {
-			return fatal(err)
-		}
-		reporters = append(reporters, lr)
-		localResolver = lr                     // Hold on to the interface
-		localDomains = lr.InBailiwickDomains() // Capture while we access to the struct
-		sort.Strings(localDomains)
-	}
-
-	// Create TLS configuration for constructing HTTPS transport. This is where we set up
-	// verification of server certs and activate http2. Though maybe the latter is no longer
-	// needed since regular net/http is meant to be http2 aware now (or soon!)
-
-	client := &http.Client{Timeout: cfg.requestTimeout}
-	tlsConfig, err := tlsutil.NewClientTLSConfig(cfg.tlsUseSystemRootCAs, cfg.tlsCAFiles.Args(),
-		cfg.tlsClientCertFile, cfg.tlsClientKeyFile)
-	if err != nil {
-		return fatal(err)
+	if cfg.versionJSON {
+		b, _ := json.Marshal(constants.GetBuildInfo(consts.ProxyProgramName)) // Cannot fail with only strings
+		fmt.Fprintln(stdout, string(b))
+		return 0
 	}
 
-	tr := &http.Transport{TLSClientConfig: tlsConfig, MaxConnsPerHost: cfg.maximumRemoteConnections}
-	if err := http2.ConfigureTransport(tr); err != nil { // Use latest http2 support - is this still needed?
-		return fatal(err)
+	rs, ret := validate()
+	if ret != 0 {
+		return ret
 	}
-	client.Transport = tr
-
-	// Complete doh Config settings and construct the DoH resolver
-
-	cfg.dohConfig.ECSSetCIDR = ecsIPNet
-	remoteResolver, err := doh.New(cfg.dohConfig, client)
-	if err != nil {
-		return fatal(err)
+	if cfg.check {
+		fmt.Fprintln(stdout, consts.ProxyProgramName, "Configuration OK")
+		return 0
 	}
-	reporters = append(reporters, remoteResolver)
 
-	if cfg.listenAddresses.NArg() == 0 { // Use wildcard if none supplied
-		cfg.listenAddresses.Set("")
-	}
+	var servers []*server // Keep track of all servers so we can shut then down
+	reporters := append([]reporter.Reporter{}, rs.reporters...)
 
 	// Start CPU profiling now that most error checking is complete
 
@@ -255,28 +162,82 @@ func mainExecute(args []string) int {
 		defer memProfileFile.Close()
 	}
 
+	// Similarly the --warm-state-file is held open so that it can be re-written on shutdown.
+
+	var warmEntries []warmEntry
+	if len(cfg.warmStateFile) > 0 {
+		warmRecorder, warmEntries, err = openWarmState(cfg.warmStateFile, cfg.warmStateEntries)
+		if err != nil {
+			return fatal("--warm-state-file", err)
+		}
+		if cfg.verbose {
+			fmt.Fprintln(stdout, "Warm State:", len(warmEntries), "entries loaded from", cfg.warmStateFile)
+		}
+	}
+
 	if cfg.gops {
 		if err := gops.Listen(gops.Options{}); err != nil {
 			return fatal(err)
 		}
 	}
 
+	if len(cfg.debugListen) > 0 {
+		ds, err := debugserver.Start(cfg.debugListen)
+		if err != nil {
+			return fatal("--debug-listen", err)
+		}
+		defer ds.Close()
+		if rs.cache != nil {
+			ds.Handle(cacheEntriesPath, cacheEntriesHandler(rs.cache))
+			ds.Handle(cacheFlushPath, cacheFlushHandler(rs.cache))
+		}
+	}
+
 	// Start servers to accept queries and call the inBailiwick resolver.
 
 	if cfg.verbose {
+		serverURLs := cfg.dohConfig.ServerURLs
+		for _, sc := range cfg.dohConfig.Servers {
+			serverURLs = append(serverURLs, sc.URL)
+		}
 		fmt.Fprintln(stdout,
-			consts.ProxyProgramName, consts.Version, "Starting:", cfg.dohConfig.ServerURLs)
+			consts.ProxyProgramName, consts.Version, "Starting:", serverURLs)
 		if len(cfg.localResolvConf) > 0 {
 			fmt.Fprintln(stdout, "Local Resolution:", cfg.localResolvConf)
-			fmt.Fprintln(stdout, "Local Domains:", strings.Join(localDomains, ", "))
+			fmt.Fprintln(stdout, "Local Domains:", strings.Join(rs.localDomains, ", "))
 		}
 
 	}
 
-	errorChannel := make(chan error, cfg.listenAddresses.NArg()*len(listenTransports))
+	var activated []*server
+	if cfg.systemd {
+		files, err := osutil.ListenFDs()
+		if err != nil {
+			return fatal("--systemd", err)
+		}
+		if len(files) == 0 {
+			return fatal("--systemd set but no sockets were passed by systemd")
+		}
+		activated, err = activatedServers(files, rs)
+		if err != nil {
+			return fatal("--systemd", err)
+		}
+	}
+
+	errorChannel := make(chan error,
+		(len(activated)+cfg.listenAddresses.NArg()+len(rs.interfaceAddresses))*len(listenTransports))
 	wg := &sync.WaitGroup{} // Wait on all servers
 
-	for _, addr := range cfg.listenAddresses.Args() {
+	for _, s := range activated {
+		s.start(errorChannel, wg)
+		if cfg.verbose {
+			fmt.Fprintln(stdout, "Starting", s.Name())
+		}
+		servers = append(servers, s)
+	}
+
+	startAddress := func(addr string) []*server { // Start a server per transport on addr
+		var ss []*server
 		ip := net.ParseIP(addr) // We have to wrap unadorned ipv6 addresses so we can append port
 		if ip != nil && ip.To16() != nil {
 			addr = "[" + addr + "]" // It's naked, so wrap it
@@ -288,16 +249,40 @@ func mainExecute(args []string) int {
 		}
 
 		for _, transport := range listenTransports {
-			s := &server{stdout: stdout, local: localResolver, remote: remoteResolver,
-				listenAddress: addr, transport: transport}
+			s := &server{stdout: stdout, local: rs.localResolver, remote: rs.remoteResolver,
+				fallback: rs.defaultResolver, typeRoutes: rs.typeRoutes, listenAddress: addr, transport: transport}
 			s.start(errorChannel, wg)
 			if cfg.verbose {
 				fmt.Fprintln(stdout, "Starting", s.Name())
 			}
-
-			reporters = append(reporters, s)
-			servers = append(servers, s)
+			ss = append(ss, s)
 		}
+
+		return ss
+	}
+
+	for _, addr := range cfg.listenAddresses.Args() {
+		servers = append(servers, startAddress(addr)...)
+	}
+
+	ifServers := interfaceServers{}
+	for _, addr := range rs.interfaceAddresses {
+		ifServers[addr] = startAddress(addr)
+		servers = append(servers, ifServers[addr]...)
+	}
+	reporters = append(reporters, serverReporters(servers)...)
+	reporter.Publish(consts.ProxyProgramName, reporters)
+
+	// Warm in the background so that clients are served while warming is in progress
+
+	warmDone := make(chan struct{})
+	if len(warmEntries) > 0 {
+		go func() {
+			wr := warm(warmEntries, rs, warmDone)
+			if cfg.verbose {
+				fmt.Fprintf(stdout, "Warm State: warmed=%d failed=%d\n", wr.warmed, wr.failed)
+			}
+		}()
 	}
 
 	// Constrain the process via setuid/setgid/chroot. This is a no-op call if all parameters
@@ -325,12 +310,33 @@ Running:
 				statusReport("User1", false, reporters)
 				break
 			}
+			if osutil.IsSignalUSR2(s) && len(cfg.profileDir) > 0 {
+				writeProfiles(cfg.profileDir)
+				break
+			}
+			if osutil.IsSignalHUP(s) && (cfg.interfaces.NArg() > 0 || rs.cache != nil) {
+				if rs.cache != nil {
+					flushCache(rs.cache, "")
+				}
+				if cfg.interfaces.NArg() > 0 {
+					servers, err = ifServers.rebind(servers, startAddress)
+					if err != nil {
+						fmt.Fprintln(stderr, "Error: --interface", err)
+					}
+					reporters = append(append([]reporter.Reporter{}, rs.reporters...), serverReporters(servers)...)
+					reporter.Publish(consts.ProxyProgramName, reporters)
+				}
+				break
+			}
 			if cfg.verbose {
 				fmt.Fprintln(stdout, "\nSignal", s)
 			}
-			break Running // All signals bar USR1 cause loop exit
+			break Running // All signals bar USR1, HUP and USR2 with --profile-dir cause loop exit
 
 		case err := <-errorChannel:
+			if err == nil {
+				break // A server stopped by rebind
+			}
 			return fatal(err) // No cleanup if we got a server startup error
 
 		case <-time.After(nextStatusIn):
@@ -346,7 +352,23 @@ Running:
 	}
 
 	mainState(stopped) // Tell testers we've stopped accepting requests
-	wg.Wait()          // Wait for all servers to completely shut down
+
+	// Wait for all servers to completely shut down. The errorChannel is drained meanwhile as
+	// servers started by rebind may have exceeded its capacity.
+
+	go func() {
+		wg.Wait()
+		close(errorChannel)
+	}()
+	for range errorChannel {
+	}
+
+	close(warmDone)
+	if warmRecorder != nil {
+		if err := warmRecorder.save(); err != nil {
+			fmt.Fprintln(stderr, "Error: --warm-state-file", err)
+		}
+	}
 
 	if cfg.verbose {
 		statusReport("Status", true, reporters) // One last report prior to exiting
@@ -366,6 +388,385 @@ Running:
 	return 0
 }
 
+// resources are the validated objects constructed from the command-line options which are needed
+// to start serving.
+type resources struct {
+	localResolver  resolver.Resolver // May be nil
+	localDomains   []string
+	remoteResolver resolver.Resolver
+	reporters      []reporter.Reporter
+
+	defaultResolver resolver.Resolver // May be nil
+	typeRoutes      typeRoutes
+	cache           *cache.Cache // May be nil
+
+	interfaceAddresses []string // Current addresses of --interface
+}
+
+// validate checks all command-line options and constructs the resolvers. Nothing in here binds a
+// socket or otherwise changes the state of the system so it is safe to call with --check. Any
+// error has already been printed when the return code is non-zero.
+func validate() (*resources, int) {
+	var err error
+
+	if cfg.logAll {
+		cfg.logClientIn = true
+		cfg.logDetail = true
+		cfg.logClientOut = true
+		cfg.logTLSErrors = true
+	}
+
+	// Validate transport settings
+
+	if cfg.udp {
+		listenTransports = append(listenTransports, consts.DNSUDPTransport)
+	}
+	if cfg.tcp {
+		listenTransports = append(listenTransports, consts.DNSTCPTransport)
+	}
+	if len(listenTransports) == 0 {
+		return nil, fatal("Must have one of --tcp or --udp set")
+	}
+
+	if cfg.reportFormat != "text" && cfg.reportFormat != "json" {
+		return nil, fatal("--report-format", cfg.reportFormat, "must be one of text or json")
+	}
+	if len(cfg.debugListen) > 0 {
+		if err := debugserver.CheckAddress(cfg.debugListen); err != nil {
+			return nil, fatal("--debug-listen", err)
+		}
+	}
+
+	if cfg.maxUDPSize != 0 && (cfg.maxUDPSize < 512 || cfg.maxUDPSize > 65535) {
+		return nil, fatal("--max-udp-size", cfg.maxUDPSize, "must be between 512 and 65535")
+	}
+
+	if cfg.tcpKeepaliveTimeout < 0 {
+		return nil, fatal("--tcp-keepalive-timeout", cfg.tcpKeepaliveTimeout, "cannot be negative")
+	}
+	if cfg.minAnswerTTL < 0 {
+		return nil, fatal("--min-answer-ttl", cfg.minAnswerTTL, "cannot be negative")
+	}
+	if cfg.slowQueryThreshold < 0 {
+		return nil, fatal("--slow-query-threshold", cfg.slowQueryThreshold, "cannot be negative")
+	}
+	if cfg.dohConfig.Alerts.Threshold < 0 || cfg.dohConfig.Alerts.Threshold > 1 {
+		return nil, fatal("--server-alert-threshold", cfg.dohConfig.Alerts.Threshold, "must be between 0 and 1")
+	}
+	if cfg.dohConfig.Alerts.Window <= 0 {
+		return nil, fatal("--server-alert-window", cfg.dohConfig.Alerts.Window, "must be greater than zero")
+	}
+	if cfg.dohConfig.RetryServFail < 0 {
+		return nil, fatal("--retry-servfail", cfg.dohConfig.RetryServFail, "cannot be negative")
+	}
+
+	if _, ok := onFailureRcodes[cfg.onFailure]; !ok && cfg.onFailure != "drop" {
+		return nil, fatal("--on-failure", cfg.onFailure, "must be one of drop, servfail or refused")
+	}
+
+	if cfg.filterA && cfg.filterAAAA {
+		return nil, fatal("Cannot have both --filter-a and --filter-aaaa set at the same time")
+	}
+
+	if cfg.dns64 {
+		dns64Prefix, err = parseDNS64Prefix(cfg.dns64Prefix)
+		if err != nil {
+			return nil, fatal("--dns64-prefix", err)
+		}
+		if cfg.filterAAAA {
+			return nil, fatal("Cannot have both --dns64 and --filter-aaaa set at the same time")
+		}
+	}
+
+	switch cfg.rebindProtect {
+	case "off", "strip", "nxdomain":
+	default:
+		return nil, fatal("--rebind-protect", cfg.rebindProtect, "must be one of off, strip or nxdomain")
+	}
+	if cfg.rebindProtect == "off" && cfg.rebindAllow.NArg() > 0 {
+		return nil, fatal("--rebind-allow requires --rebind-protect")
+	}
+	rebind, err = newRebindProtector(cfg.rebindProtect, cfg.rebindAllow.Args())
+	if err != nil {
+		return nil, fatal("--rebind-allow", err)
+	}
+
+	// Validate ECS settings. These settings are also validated by the DoH resolver, but we
+	// check them here as well as we can generate a more meaningful error message that equates
+	// back the the command-line options whereas the DoH resolver really has no clue as to where
+	// its config values came from and thus produces somewhat generic error messages.
+
+	if cfg.dohConfig.UseGetMethod { // No ECS synthesis is possible with GET due to possible bogus caching
+		if len(cfg.ecsSet) > 0 ||
+			cfg.dohConfig.ECSRequestIPv4PrefixLen > 0 || cfg.dohConfig.ECSRequestIPv6PrefixLen > 0 {
+			return nil, fatal("Cannot have any ECS synthesis options when using HTTP GET")
+		}
+	}
+
+	var ecsIPNet *net.IPNet
+	if len(cfg.ecsSet) > 0 {
+		var err error
+		_, ecsIPNet, err = net.ParseCIDR(cfg.ecsSet)
+		if err != nil {
+			return nil, fatal("--ecs-set", err)
+		}
+		if cfg.dohConfig.ECSRequestIPv4PrefixLen != 0 || cfg.dohConfig.ECSRequestIPv6PrefixLen != 0 {
+			return nil, fatal("Cannot have both --ecs-set and --ecs-request-* options set at the same time")
+		}
+	}
+
+	if cfg.dohConfig.ECSRequestIPv4PrefixLen < 0 || cfg.dohConfig.ECSRequestIPv4PrefixLen > 32 {
+		return nil, fatal("--ecs-request-ipv4-prefixlen", cfg.dohConfig.ECSRequestIPv4PrefixLen,
+			"must be between 0 and 32")
+	}
+	if cfg.dohConfig.ECSRequestIPv6PrefixLen < 0 || cfg.dohConfig.ECSRequestIPv6PrefixLen > 128 {
+		return nil, fatal("--ecs-request-ipv6-prefixlen", cfg.dohConfig.ECSRequestIPv6PrefixLen,
+			"must be between 0 and 128")
+	}
+
+	// Validate server URLs
+
+	for _, dohURL := range flagSet.Args() {
+		u, err := normalizeDoHURL(dohURL)
+		if err != nil {
+			return nil, fatal(err)
+		}
+		cfg.dohConfig.ServerURLs = append(cfg.dohConfig.ServerURLs, u)
+	}
+
+	var serversFile []serversFileEntry
+	if len(cfg.serversFile) > 0 {
+		serversFile, err = loadServersFile(cfg.serversFile)
+		if err != nil {
+			return nil, fatal("--servers-file", err)
+		}
+	}
+
+	if len(cfg.dohConfig.ServerURLs) == 0 && len(serversFile) == 0 {
+		return nil, fatal("Must supply at least one DoH server URL on the command line or with --servers-file")
+	}
+
+	if cfg.queryTimeout < 0 {
+		return nil, fatal("--query-timeout", cfg.queryTimeout, "cannot be negative")
+	}
+
+	if cfg.maximumRemoteConnections < 1 {
+		return nil, fatal("Minimum remote concurrency must be greater than zero (-r)")
+	}
+
+	if len(cfg.bootstrapResolver) > 0 {
+		var err error
+		cfg.bootstrapResolver, err = parseBootstrapResolver(cfg.bootstrapResolver)
+		if err != nil {
+			return nil, fatal("--bootstrap-resolver", err)
+		}
+	}
+
+	if cfg.upstreamIPv4Only && cfg.upstreamIPv6Only {
+		return nil, fatal("Cannot have both --upstream-ipv4-only and --upstream-ipv6-only set at the same time")
+	}
+
+	rs := &resources{}
+
+	// localResolver handles split-horizon domains
+
+	if len(cfg.localResolvConf) == 0 && cfg.localDomains.NArg() > 0 {
+		return nil, fatal("Local Domains (-e) cannot be resolved without a resolv.conf (-c)")
+	}
+
+	if len(cfg.localResolvConf) > 0 {
+		lr, err := local.New(local.Config{
+			ResolvConfPath: cfg.localResolvConf, LocalDomains: cfg.localDomains.Args()})
+		if err != nil {
+			return nil, fatal(err)
+		}
+		rs.reporters = append(rs.reporters, lr)
+		rs.localResolver = lr                     // Hold on to the interface
+		rs.localDomains = lr.InBailiwickDomains() // Capture while we access to the struct
+		sort.Strings(rs.localDomains)
+	}
+
+	rs.typeRoutes, err = parseTypeRoutes(cfg.typeRoutes.Args())
+	if err != nil {
+		return nil, fatal("--type-route", err)
+	}
+	if rs.typeRoutes.hasLocal() && rs.localResolver == nil {
+		return nil, fatal("--type-route to local cannot be resolved without a resolv.conf (-c)")
+	}
+
+	// Create TLS configuration for constructing HTTPS transport. This is where we set up
+	// verification of server certs and activate http2. Though maybe the latter is no longer
+	// needed since regular net/http is meant to be http2 aware now (or soon!) it's also where
+	// http2 PING health checks are configured.
+
+	client := &http.Client{Timeout: cfg.requestTimeout}
+	tlsConfig, err := tlsutil.NewClientTLSConfig(cfg.tlsUseSystemRootCAs, cfg.tlsCAFiles.Args(),
+		cfg.tlsClientCertFile, cfg.tlsClientKeyFile)
+	if err != nil {
+		return nil, fatal(err)
+	}
+
+	sips, err := parseServerIPs(cfg.serverIPs.Args())
+	if err != nil {
+		return nil, fatal("--server-ip", err)
+	}
+	uss := unixSockets{}
+	for ix, u := range cfg.dohConfig.ServerURLs {
+		cfg.dohConfig.ServerURLs[ix] = uss.rewriteURL(u)
+	}
+	for ix := range serversFile {
+		serversFile[ix].URL = uss.rewriteURL(serversFile[ix].URL)
+	}
+	cfg.pinServer = uss.rewriteURL(cfg.pinServer)
+	dial := upstreamDialer(sips, uss)
+	tr := &http.Transport{TLSClientConfig: tlsConfig, MaxConnsPerHost: cfg.maximumRemoteConnections,
+		DialContext: dial}
+	if err := doh.ConfigureTransport(tr, cfg.dohConfig); err != nil {
+		return nil, fatal(err)
+	}
+	client.Transport = tr
+
+	cfg.dohConfig.Servers, err = serverConfigs(serversFile, tlsConfig, cfg.maximumRemoteConnections, cfg.dohConfig,
+		cfg.requestTimeout, dial)
+	if err != nil {
+		return nil, fatal(err)
+	}
+
+	// Complete doh Config settings and construct the DoH resolver
+
+	cfg.dohConfig.ECSSetCIDR = ecsIPNet
+	cfg.dohConfig.Alerts.Func = serverAlert
+	if cfg.preserveEDNS.NArg() > 0 {
+		cfg.dohConfig.PreserveEDNS0 = make(map[uint16]bool)
+		for _, arg := range cfg.preserveEDNS.Args() {
+			code, err := dnsutil.ParseEDNS0Code(arg)
+			if err != nil {
+				return nil, fatal("--preserve-edns", err)
+			}
+			cfg.dohConfig.PreserveEDNS0[code] = true
+		}
+	}
+	remoteResolver, err := doh.New(cfg.dohConfig, client)
+	if err != nil {
+		return nil, fatal(err)
+	}
+	if len(cfg.pinServer) > 0 {
+		if err := remoteResolver.PinServer(cfg.pinServer); err != nil {
+			return nil, fatal("--pin-server", err)
+		}
+	}
+	rs.reporters = append(rs.reporters, remoteResolver)
+	rs.remoteResolver = remoteResolver
+
+	if len(cfg.defaultResolver) > 0 {
+		rs.defaultResolver, err = plain.New(plain.Config{Server: cfg.defaultResolver, Timeout: cfg.requestTimeout})
+		if err != nil {
+			return nil, fatal("--default-resolver", err)
+		}
+	}
+
+	// A single cache is shared by both resolvers. The default resolver is deliberately not
+	// wrapped as it is only consulted when something is already amiss.
+
+	if cfg.cacheSize < 0 {
+		return nil, fatal("--cache-size", cfg.cacheSize, "cannot be negative")
+	}
+	if cfg.cacheSize > 0 {
+		if cfg.cacheMaxTTL <= 0 {
+			return nil, fatal("--cache-max-ttl", cfg.cacheMaxTTL, "must be greater than zero")
+		}
+		if cfg.cacheMaxMemory < 0 {
+			return nil, fatal("--cache-max-memory", cfg.cacheMaxMemory, "cannot be negative")
+		}
+		if cfg.cacheMaxNegativeTTL < 0 {
+			return nil, fatal("--negative-ttl-max", cfg.cacheMaxNegativeTTL, "cannot be negative")
+		}
+		c := cache.New(cache.Config{MaxEntries: cfg.cacheSize, MaxMemory: cfg.cacheMaxMemory,
+			MaxTTL: cfg.cacheMaxTTL, MaxNegativeTTL: cfg.cacheMaxNegativeTTL})
+		if rs.localResolver != nil {
+			rs.localResolver = c.Wrap(rs.localResolver)
+		}
+		rs.remoteResolver = c.Wrap(rs.remoteResolver)
+		rs.reporters = append(rs.reporters, c)
+		rs.cache = c
+	}
+
+	if len(cfg.warmStateFile) > 0 && cfg.warmStateEntries < 1 {
+		return nil, fatal("--warm-state-entries", cfg.warmStateEntries, "must be greater than zero")
+	}
+
+	if _, err := osutil.ListenConfig(cfg.reusePort); err != nil {
+		return nil, fatal("--reuse-port", err)
+	}
+
+	if cfg.interfaces.NArg() > 0 {
+		rs.interfaceAddresses, err = interfaceAddresses(cfg.interfaces.Args())
+		if err != nil {
+			return nil, fatal("--interface", err)
+		}
+		if len(rs.interfaceAddresses) == 0 {
+			return nil, fatal("--interface", cfg.interfaces.String(), "has no usable addresses")
+		}
+	}
+
+	if cfg.systemd {
+		if cfg.listenAddresses.NArg() > 0 || cfg.interfaces.NArg() > 0 {
+			return nil, fatal("Cannot have --systemd with -A listen addresses or --interface")
+		}
+	} else if cfg.listenAddresses.NArg() == 0 && cfg.interfaces.NArg() == 0 { // Use wildcard if none supplied
+		cfg.listenAddresses.Set("")
+	}
+
+	return rs, 0
+}
+
+// dialContextFunc is the signature of http.Transport.DialContext
+type dialContextFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// upstreamDialer returns the DialContext function used by all transports which connect to DoH
+// servers. Placeholder hostnames for unix:// URLs are dialed on their Unix domain sockets. Hostnames
+// pinned by --server-ip are dialed without a lookup, otherwise DoH server hostnames are looked up
+// via --bootstrap-resolver if set. If connections are constrained to one address family, the
+// generic "tcp" network is replaced with "tcp4" or "tcp6" so that only addresses of that family
+// are dialed.
+func upstreamDialer(sips serverIPs, uss unixSockets) dialContextFunc {
+	d := osutil.Dialer(cfg.tcpFastOpen)
+	if len(cfg.bootstrapResolver) > 0 {
+		d.Resolver = newBootstrapResolver(cfg.bootstrapResolver)
+	}
+	dial := d.DialContext
+	if cfg.happyEyeballs {
+		dial = (&happyeyeballs.Dialer{Dialer: d, Resolver: d.Resolver}).DialContext
+	}
+
+	family := ""
+	switch {
+	case cfg.upstreamIPv4Only:
+		family = "4"
+	case cfg.upstreamIPv6Only:
+		family = "6"
+	default:
+		return uss.dialContext(sips.dialContext(dial))
+	}
+
+	return uss.dialContext(sips.dialContext(func(ctx context.Context, network, address string) (net.Conn, error) {
+		if network == "tcp" {
+			network += family
+		}
+		return dial(ctx, network, address)
+	}))
+}
+
+// serverReporters returns the servers as a list of reporters.
+func serverReporters(servers []*server) []reporter.Reporter {
+	var reporters []reporter.Reporter
+	for _, s := range servers {
+		reporters = append(reporters, s)
+	}
+
+	return reporters
+}
+
 // nextInterval calculates the duration to the modulo interval next time. If now is 00:01:17 and
 // interval is 30s then return is 13s which is the duration to the next modulo of 00:01:30.
 func nextInterval(now time.Time, interval time.Duration) time.Duration {
@@ -380,6 +781,10 @@ func uptime() string {
 
 // statusReport prints stats about the server and all known reporters
 func statusReport(what string, resetCounters bool, reporters []reporter.Reporter) {
+	if cfg.reportFormat == "json" {
+		statusReportJSON(what, resetCounters, reporters)
+		return
+	}
 	fmt.Fprintln(stdout, "Status Up:", consts.ProxyProgramName, consts.Version, uptime())
 	for _, r := range reporters {
 		reps := strings.Split(r.Report(resetCounters), "\n")
@@ -390,3 +795,37 @@ func statusReport(what string, resetCounters bool, reporters []reporter.Reporter
 		}
 	}
 }
+
+// statusReportJSON prints one JSON object per reporter so that each line can be consumed
+// independently. Reporters which cannot produce JSON have their text report wrapped instead.
+func statusReportJSON(what string, resetCounters bool, reporters []reporter.Reporter) {
+	type jsonReport struct {
+		What    string          `json:"what"`
+		Program string          `json:"program"`
+		Version string          `json:"version"`
+		Uptime  string          `json:"uptime"`
+		Name    string          `json:"name"`
+		Report  json.RawMessage `json:"report,omitempty"`
+		Text    string          `json:"text,omitempty"`
+	}
+	for _, r := range reporters {
+		jr := jsonReport{What: what, Program: consts.ProxyProgramName, Version: consts.Version, Uptime: uptime(),
+			Name: r.Name()}
+		if mr, ok := r.(reporter.MetricsReporter); ok {
+			b, err := mr.ReportJSON(resetCounters)
+			if err != nil {
+				fmt.Fprintln(stderr, "Error:", r.Name(), err)
+				continue
+			}
+			jr.Report = b
+		} else {
+			jr.Text = strings.TrimSpace(r.Report(resetCounters))
+		}
+		b, err := json.Marshal(jr)
+		if err != nil {
+			fmt.Fprintln(stderr, "Error:", r.Name(), err)
+			continue
+		}
+		fmt.Fprintln(stdout, string(b))
+	}
+}
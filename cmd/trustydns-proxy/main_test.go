@@ -2,7 +2,11 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
 	"os"
 	"strings"
 	"sync"
@@ -43,6 +47,10 @@ type mainTestCase struct {
 	stderr      string        // Expected stderr string
 }
 
+// tempDirArg in test case args is replaced with a per-test temporary directory so that generated
+// files, such as profiles, do not end up in the source tree.
+const tempDirArg = "@tempdir@"
+
 // The -A 255.... arguments are present to cause mainExecute() to fail when it starts *after*
 // exercising the code coverage area intended.
 
@@ -86,10 +94,10 @@ var mainTestCases = []mainTestCase{
 		[]string{"Status Server:"}, ""},
 
 	{"CPU Profile",
-		false, 100 * time.Millisecond, []string{"-A", "127.0.0.1:62090", "--cpu-profile", "testdata/cpu",
+		false, 100 * time.Millisecond, []string{"-A", "127.0.0.1:62090", "--cpu-profile", tempDirArg + "/cpu",
 			"http://localhost"}, []string{}, ""},
 	{"Mem Profile",
-		false, 100 * time.Millisecond, []string{"-A", "127.0.0.1:62091", "--mem-profile", "testdata/mem",
+		false, 100 * time.Millisecond, []string{"-A", "127.0.0.1:62091", "--mem-profile", tempDirArg + "/mem",
 			"http://localhost"}, []string{}, ""},
 
 	{"Wildcard listen address",
@@ -106,6 +114,10 @@ func TestMain(t *testing.T) {
 				return
 			}
 			args := append([]string{"trustydns-proxy"}, tc.args...)
+			tempDir := t.TempDir()
+			for ix := range args {
+				args[ix] = strings.Replace(args[ix], tempDirArg, tempDir, 1)
+			}
 			out := &mutexBytesBuffer{}
 			err := &mutexBytesBuffer{}
 			mainInit(out, err)
@@ -191,6 +203,29 @@ func TestUSR1(t *testing.T) {
 	}
 }
 
+// Test that SIGUSR2 writes profiles when --profile-dir is set
+func TestUSR2Profile(t *testing.T) {
+	out := &mutexBytesBuffer{}
+	err := &mutexBytesBuffer{}
+	dir := t.TempDir()
+	args := []string{"trustydns-proxy", "-A", "127.0.0.1:5356", "--profile-dir", dir, "http://localhost"}
+	mainInit(out, err) // Start up quietly
+	go func() {
+		stopChannel <- syscall.SIGUSR2
+		time.Sleep(time.Millisecond * 200) // Give it time to process
+		stopMain()
+	}()
+	ec := mainExecute(args)
+	outStr := out.String()
+	errStr := err.String()
+	if ec != 0 {
+		t.Error("Expected zero exit return, not", ec, errStr)
+	}
+	if strings.Count(outStr, "Profile written: "+dir) != 2 {
+		t.Error("Expected two 'Profile written:' lines, got", outStr)
+	}
+}
+
 // waitForMainExecute is a helper routine which makes sure that main mainExecute() function starts up and
 // terminates as expected. If not, t.Fatal()
 func waitForMainExecute(t *testing.T, howLong time.Duration) error {
@@ -217,3 +252,69 @@ func waitForMainExecute(t *testing.T, howLong time.Duration) error {
 
 	return nil
 }
+
+// Test that --debug-listen serves the published reporters via expvar
+func TestDebugListen(t *testing.T) {
+	out := &mutexBytesBuffer{}
+	err := &mutexBytesBuffer{}
+	args := []string{"trustydns-proxy", "-A", "127.0.0.1:5356", "--debug-listen", "127.0.0.1:60460", "http://localhost"}
+	mainInit(out, err) // Start up quietly
+	var body string
+	go func() {
+		for ix := 0; ix < 10 && !isMain(started); ix++ {
+			time.Sleep(time.Millisecond * 200)
+		}
+		resp, err := http.Get("http://127.0.0.1:60460/debug/vars")
+		if err == nil {
+			b, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			body = string(b)
+		}
+		stopMain()
+	}()
+	ec := mainExecute(args)
+	if ec != 0 {
+		t.Error("Expected zero exit return, not", ec, err.String())
+	}
+	if !strings.Contains(body, `"trustydns-proxy": [{"name":`) {
+		t.Error("Expected published reporters in /debug/vars, got", body)
+	}
+}
+
+// Dialing an IPv4 literal must only succeed if IPv6 has not been forced, regardless of whether the
+// dial goes via happy-eyeballs.
+func TestUpstreamDialer(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	mainInit(os.Stdout, os.Stderr)
+	testCases := []struct {
+		ipv4Only, ipv6Only, happyEyeballs bool
+		ok                                bool
+	}{
+		{false, false, false, true},
+		{true, false, false, true},
+		{false, true, false, false},
+		{false, true, true, false},
+		{true, false, true, true},
+	}
+
+	for ix, tc := range testCases {
+		cfg.upstreamIPv4Only = tc.ipv4Only
+		cfg.upstreamIPv6Only = tc.ipv6Only
+		cfg.happyEyeballs = tc.happyEyeballs
+		conn, err := upstreamDialer(nil, nil)(context.Background(), "tcp", ln.Addr().String())
+		if conn != nil {
+			conn.Close()
+		}
+		if tc.ok && err != nil {
+			t.Error(ix, "Unexpected dial error", err)
+		}
+		if !tc.ok && err == nil {
+			t.Error(ix, "Expected dial of IPv4 address to fail")
+		}
+	}
+}
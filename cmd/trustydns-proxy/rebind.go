@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// rebindProtector implements --rebind-protect. It removes A and AAAA RRs which point at private,
+// loopback, link-local or unspecified addresses from responses returned by remote DoH servers as a
+// public name has no legitimate reason to resolve to such an address and doing so is the basis of
+// DNS rebinding attacks.
+type rebindProtector struct {
+	nxdomain bool     // Replace the whole response with NXDomain rather than just stripping RRs
+	allow    []string // Lower-cased domains with leading and trailing dots which are exempt
+}
+
+// newRebindProtector returns nil if mode is "off". Allowed domains exempt themselves and all their
+// sub-domains.
+func newRebindProtector(mode string, allow []string) (*rebindProtector, error) {
+	if mode == "off" {
+		return nil, nil
+	}
+	t := &rebindProtector{nxdomain: mode == "nxdomain"}
+	for _, d := range allow {
+		trimmed := strings.Trim(strings.ToLower(d), ".")
+		if _, ok := dns.IsDomainName(trimmed); !ok || len(trimmed) == 0 {
+			return nil, errors.New("Invalid domain name: " + d)
+		}
+		t.allow = append(t.allow, "."+trimmed+".")
+	}
+
+	return t, nil
+}
+
+// exempt returns true if name is within one of the allowed domains
+func (t *rebindProtector) exempt(name string) bool {
+	name = "." + strings.ToLower(dns.Fqdn(name))
+	for _, d := range t.allow {
+		if strings.HasSuffix(name, d) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isRebindAddress returns true if the address is not one a public name should resolve to
+func isRebindAddress(ip net.IP) bool {
+	return ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsUnspecified()
+}
+
+// filter applies rebind protection to the response and returns true if anything was removed. The
+// query name and the owner of each RR are both checked against the allowed domains so that a
+// CNAME into an allowed domain is also exempt.
+func (t *rebindProtector) filter(query, resp *dns.Msg) bool {
+	if len(query.Question) > 0 && t.exempt(query.Question[0].Name) {
+		return false
+	}
+
+	answer := make([]dns.RR, 0, len(resp.Answer))
+	for _, rr := range resp.Answer {
+		var ip net.IP
+		switch v := rr.(type) {
+		case *dns.A:
+			ip = v.A
+		case *dns.AAAA:
+			ip = v.AAAA
+		}
+		if ip == nil || !isRebindAddress(ip) || t.exempt(rr.Header().Name) {
+			answer = append(answer, rr)
+		}
+	}
+	if len(answer) == len(resp.Answer) {
+		return false
+	}
+
+	if t.nxdomain {
+		resp.Rcode = dns.RcodeNameError
+		resp.Answer = nil
+		resp.Ns = nil
+		return true
+	}
+	resp.Answer = answer
+
+	return true
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestIsRebindAddress(t *testing.T) {
+	testCases := []struct {
+		ip     string
+		expect bool
+	}{
+		{"10.1.2.3", true}, {"172.16.0.1", true}, {"192.168.1.1", true}, {"127.0.0.1", true},
+		{"169.254.1.1", true}, {"0.0.0.0", true}, {"::1", true}, {"fe80::1", true}, {"fd00::1", true},
+		{"::", true}, {"192.0.2.1", false}, {"172.32.0.1", false}, {"2001:db8::1", false},
+	}
+	for _, tc := range testCases {
+		if got := isRebindAddress(net.ParseIP(tc.ip)); got != tc.expect {
+			t.Error(tc.ip, "Expected", tc.expect, "got", got)
+		}
+	}
+}
+
+func TestNewRebindProtector(t *testing.T) {
+	rp, err := newRebindProtector("off", nil)
+	if rp != nil || err != nil {
+		t.Error("Expected nil protector and error with off", rp, err)
+	}
+	_, err = newRebindProtector("strip", []string{"."})
+	if err == nil {
+		t.Error("Expected an error with an empty allow domain")
+	}
+	rp, err = newRebindProtector("strip", []string{"Corp.Example.", "lan"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, expect := range map[string]bool{"corp.example.": true, "host.CORP.example.": true,
+		"printer.lan.": true, "notcorp.example.": false, "example.": false} {
+		if got := rp.exempt(name); got != expect {
+			t.Error(name, "Expected exempt", expect, "got", got)
+		}
+	}
+}
+
+func TestServerRebindProtect(t *testing.T) {
+	mainInit(os.Stdout, os.Stderr)
+
+	aResp := &dns.Msg{}
+	cname, _ := dns.NewRR("www.example.com. 600 IN CNAME host.corp.example.")
+	a1, _ := dns.NewRR("host.corp.example. 600 IN A 10.0.0.1")
+	a2, _ := dns.NewRR("www.example.com. 600 IN A 192.168.0.1")
+	a3, _ := dns.NewRR("www.example.com. 600 IN A 192.0.2.1")
+	aResp.Answer = []dns.RR{cname, a1, a2, a3}
+	res := &qTypeResolver{responses: map[uint16]*dns.Msg{dns.TypeA: aResp}}
+
+	testCases := []struct {
+		mode     string
+		allow    []string
+		local    bool // Resolve via the local resolver
+		fallback bool // The primary resolver fails so the fallback resolver answers
+		rcode    int
+		answers  int
+		event    int
+	}{
+		{"strip", nil, false, false, dns.RcodeSuccess, 2, 1},
+		{"strip", []string{"corp.example"}, false, false, dns.RcodeSuccess, 3, 1},
+		{"strip", []string{"example.com"}, false, false, dns.RcodeSuccess, 4, 0},
+		{"nxdomain", nil, false, false, dns.RcodeNameError, 0, 1},
+		{"nxdomain", nil, true, false, dns.RcodeSuccess, 4, 0},
+		{"strip", nil, false, true, dns.RcodeSuccess, 2, 1},
+		{"nxdomain", nil, true, true, dns.RcodeNameError, 0, 1},
+	}
+
+	for ix, tc := range testCases {
+		rebind, _ = newRebindProtector(tc.mode, tc.allow)
+		s := &server{stdout: stdout, remote: res, transport: "udp"}
+		if tc.local {
+			s.local = &qTypeResolver{responses: res.responses}
+		}
+		if tc.fallback {
+			failed := &mockResolver{ib: true, err: errors.New("Mock Resolver Error")}
+			s.remote = failed
+			if tc.local {
+				s.local = failed
+			}
+			s.fallback = res
+		}
+		q := &dns.Msg{}
+		q.SetQuestion("www.example.com.", dns.TypeA)
+		mw := &mockResponseWriter{}
+		s.ServeDNS(mw, q)
+		r := mw.messageWritten
+		if r == nil {
+			t.Fatal(ix, "Test setup failed as response never got written to mockResponseWriter")
+		}
+		if r.Rcode != tc.rcode || len(r.Answer) != tc.answers {
+			t.Error(ix, "Wrong response", r)
+		}
+		if s.eventCounters[evRebind] != tc.event {
+			t.Error(ix, "Expected rebind event count of", tc.event, "not", s.eventCounters[evRebind])
+		}
+	}
+	rebind = nil
+}
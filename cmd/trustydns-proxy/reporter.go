@@ -1,8 +1,20 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"time"
+
+	"github.com/markdingo/trustydns/internal/reporter"
+	"github.com/markdingo/trustydns/internal/sizehistogram"
+	"github.com/markdingo/trustydns/internal/topcounter"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	reportQueryTypes = 5 // Number of most frequent query types listed in the report
+	reportRcodes     = 5 // Number of most frequent response rcodes listed in the report
 )
 
 //////////////////////////////////////////////////////////////////////
@@ -10,12 +22,13 @@ import (
 //////////////////////////////////////////////////////////////////////
 
 // addSuccessStats transfers successful ServerDNS query stats to longer-term server stats.
-func (t *server) addSuccessStats(latency time.Duration, evs events) {
+func (t *server) addSuccessStats(latency time.Duration, size int, evs events) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
 	t.successCount++
 	t.totalLatency += latency
+	t.sizes.Add(size)
 	for ix := 0; ix < len(evs); ix++ {
 		if evs[ix] {
 			t.eventCounters[ix]++
@@ -36,11 +49,47 @@ func (t *server) addFailureStats(ix int, evs events) {
 	}
 }
 
+// addQueryTypeStats counts the qtype of a query. Queries lacking a question are ignored.
+func (t *server) addQueryTypeStats(query *dns.Msg) {
+	if len(query.Question) == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.qtypes.Add(query.Question[0].Qtype)
+}
+
+// addRcodeStats counts the rcode of a response returned to the client
+func (t *server) addRcodeStats(rcode int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.rcodes.Add(uint16(rcode))
+}
+
 func (t *server) Name() string {
 	return "Server: (on " + t.listenAddress + "/" + t.transport + ")"
 }
 
-func (t *server) Report(resetCounters bool) string {
+// serverReport is a snapshot of the server stats shared by Report() and ReportJSON()
+type serverReport struct {
+	Requests       int               `json:"req"`
+	Success        int               `json:"ok"`
+	Events         reporter.Counters `json:"events"`
+	AverageLatency float64           `json:"al"`
+	Errors         int               `json:"errs"`
+	Failures       reporter.Counters `json:"failures"`
+	Concurrency    int               `json:"concurrency"`
+	Coalesced      int               `json:"coalesced"` // Peak duplicate queries in-flight
+
+	QueryTypes []topcounter.Entry `json:"qtypes"` // Most frequent first
+	Rcodes     []topcounter.Entry `json:"rcodes"` // Most frequent first
+	Sizes      []int              `json:"sizes"`  // Response size buckets in ascending order
+}
+
+// snapshot gathers up the current stats and optionally resets them
+func (t *server) snapshot(resetCounters bool) *serverReport {
 	if resetCounters {
 		t.mu.Lock()
 		defer t.mu.Unlock()
@@ -49,27 +98,65 @@ func (t *server) Report(resetCounters bool) string {
 		defer t.mu.RUnlock()
 	}
 
-	errs := 0
+	sr := &serverReport{Success: t.successCount,
+		Events:     reporter.NewCounters(evNames[:], t.eventCounters[:]),
+		Failures:   reporter.NewCounters(serNames[:], t.failureCounters[:]),
+		QueryTypes: t.qtypes.Top(reportQueryTypes, qtypeName),
+		Rcodes:     t.rcodes.Top(reportRcodes, rcodeName),
+		Sizes:      t.sizes.Counts(),
+	}
 	for _, v := range t.failureCounters {
-		errs += v
+		sr.Errors += v
 	}
-	req := t.successCount + errs
+	sr.Requests = t.successCount + sr.Errors
 
-	var al float64
 	if t.successCount > 0 {
-		al = t.totalLatency.Seconds() / float64(t.successCount)
+		sr.AverageLatency = t.totalLatency.Seconds() / float64(t.successCount)
 	}
-
-	s := fmt.Sprintf("req=%d ok=%d (%s) al=%0.3f errs=%d (%s) Concurrency=%d",
-		req, t.successCount, formatCounters("%d", "/", t.eventCounters[:]), al,
-		errs, formatCounters("%d", "/", t.failureCounters[:]),
-		t.cct.Peak(resetCounters))
+	sr.Concurrency = t.cct.Peak(resetCounters)
+	sr.Coalesced = t.cct.Coalesced(resetCounters)
 
 	if resetCounters {
 		t.stats = stats{}
 	}
 
-	return s
+	return sr
+}
+
+func (t *server) Report(resetCounters bool) string {
+	sr := t.snapshot(resetCounters)
+
+	report := fmt.Sprintf("req=%d ok=%d (%s) al=%0.3f errs=%d (%s) Concurrency=%d Coalesced=%d",
+		sr.Requests, sr.Success, formatCounters("%d", "/", sr.Events.Values), sr.AverageLatency,
+		sr.Errors, formatCounters("%d", "/", sr.Failures.Values), sr.Concurrency, sr.Coalesced)
+	if len(sr.QueryTypes) > 0 { // Most frequent query types and the total of all others
+		report += "\nQtypes: " + topcounter.Format(sr.QueryTypes)
+	}
+	if len(sr.Rcodes) > 0 { // Likewise for response rcodes, including --on-failure responses
+		report += "\nRcodes: " + topcounter.Format(sr.Rcodes)
+	}
+	if sr.Success > 0 { // Bucketed sizes of responses, as compared against the client's UDP limit
+		report += "\nSizes: " + sizehistogram.Format(sr.Sizes)
+	}
+
+	return report
+}
+
+// ReportJSON implements the reporter.MetricsReporter interface
+func (t *server) ReportJSON(resetCounters bool) ([]byte, error) {
+	return json.Marshal(t.snapshot(resetCounters))
+}
+
+func qtypeName(qtype uint16) string {
+	return dns.Type(qtype).String()
+}
+
+func rcodeName(rcode uint16) string {
+	if s, ok := dns.RcodeToString[int(rcode)]; ok {
+		return s
+	}
+
+	return fmt.Sprintf("RCODE%d", rcode)
 }
 
 // formatCounters returns a nice %d/%d/%d format for an array of ints. This is less error-prone than
@@ -1,15 +1,22 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"os"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/markdingo/trustydns/internal/reporter"
+
+	"github.com/miekg/dns"
 )
 
 const (
-	expect1 = "req=5 ok=2 (0/0) al=0.450 errs=3 (1/2) Concurrency=0"
-	expect2 = "req=5 ok=2 (1/1) al=0.450 errs=3 (1/2) Concurrency=0"
+	expect1 = "req=5 ok=2 (0/0/0/0/0/0/0/0) al=0.450 errs=3 (1/2) Concurrency=0 Coalesced=0"
+	expect2 = "req=5 ok=2 (1/1/0/0/0/0/0/0) al=0.450 errs=3 (1/2) Concurrency=0 Coalesced=0" +
+		"\nSizes: 128=1 256=0 512=0 1232=0 4096=1 more=0"
 )
 
 func TestReporter(t *testing.T) {
@@ -21,7 +28,7 @@ func TestReporter(t *testing.T) {
 	}
 
 	rep1 := s.Report(false)
-	s.addSuccessStats(time.Millisecond*300, evs)
+	s.addSuccessStats(time.Millisecond*300, 600, evs)
 	rep2 := s.Report(true)
 	if rep2 == rep1 {
 		t.Error("Report should changed with counter updates", rep1, rep2)
@@ -31,8 +38,8 @@ func TestReporter(t *testing.T) {
 		t.Error("Reset Counters report should equal initial report", rep1, rep2)
 	}
 
-	s.addSuccessStats(time.Millisecond*400, evs)
-	s.addSuccessStats(time.Millisecond*500, evs) // (400+500) / 2 = 0.450ms average latency
+	s.addSuccessStats(time.Millisecond*400, 100, evs)
+	s.addSuccessStats(time.Millisecond*500, 1500, evs) // (400+500) / 2 = 0.450ms average latency
 	s.addFailureStats(serNoResponse, evs)
 	s.addFailureStats(serDNSWriteFailed, evs)
 	evs[evInTruncated] = true
@@ -48,3 +55,71 @@ func TestReporter(t *testing.T) {
 		t.Error("Report should not have changed. Expected:", expect2, "Got:", rep1)
 	}
 }
+
+func TestReporterQueryTypesAndRcodes(t *testing.T) {
+	s := &server{stdout: os.Stdout, listenAddress: "127.0.0.1", transport: "udp"}
+	q := &dns.Msg{}
+	for _, qtype := range []uint16{dns.TypePTR, dns.TypeAAAA, dns.TypePTR, dns.TypeTXT} {
+		q.SetQuestion("example.net.", qtype)
+		s.addQueryTypeStats(q)
+	}
+	s.addRcodeStats(dns.RcodeServerFailure)
+	s.addRcodeStats(dns.RcodeSuccess)
+	s.addRcodeStats(dns.RcodeServerFailure)
+
+	exp := "req=0 ok=0 (0/0/0/0/0/0/0/0) al=0.000 errs=0 (0/0) Concurrency=0 Coalesced=0" +
+		"\nQtypes: PTR=2 TXT=1 AAAA=1\nRcodes: SERVFAIL=2 NOERROR=1"
+	if rep := s.Report(true); rep != exp {
+		t.Error("Report does not contain expected Qtypes and Rcodes lines. Expected:", exp, "Got:", rep)
+	}
+	if rep := s.Report(false); strings.Contains(rep, "Qtypes:") || strings.Contains(rep, "Rcodes:") {
+		t.Error("Qtypes and Rcodes not reset with other counters", rep)
+	}
+}
+
+func TestReportJSON(t *testing.T) {
+	var evs events
+	s := &server{stdout: os.Stdout, listenAddress: "127.0.0.1", transport: "udp"}
+	evs[evFiltered] = true
+	s.addSuccessStats(time.Millisecond*400, 1300, evs)
+	s.addFailureStats(serDNSWriteFailed, evs)
+
+	b, err := s.ReportJSON(true)
+	if err != nil {
+		t.Fatal("Unexpected error from ReportJSON", err)
+	}
+	var sr struct { // Events and Failures are keyed by name
+		serverReport
+		Events   map[string]int `json:"events"`
+		Failures map[string]int `json:"failures"`
+	}
+	if err := json.Unmarshal(b, &sr); err != nil {
+		t.Fatal("ReportJSON did not produce valid JSON", err, string(b))
+	}
+	if sr.Requests != 2 || sr.Success != 1 || sr.Errors != 1 || sr.Events["filtered"] != 2 ||
+		len(sr.Events) != int(evListSize) || sr.Failures["dnsWriteFailed"] != 1 || sr.AverageLatency != 0.4 ||
+		len(sr.Sizes) != 6 || sr.Sizes[4] != 1 {
+		t.Error("ReportJSON returned wrong counters", string(b))
+	}
+	if s.Report(false) != "req=0 ok=0 (0/0/0/0/0/0/0/0) al=0.000 errs=0 (0/0) Concurrency=0 Coalesced=0" {
+		t.Error("ReportJSON(true) did not reset counters", s.Report(false))
+	}
+
+	// statusReport should produce one JSON object per reporter
+
+	out := &bytes.Buffer{}
+	mainInit(out, os.Stderr)
+	cfg.reportFormat = "json"
+	statusReport("Status", false, []reporter.Reporter{s, s})
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatal("Expected two JSON lines from statusReport, not", len(lines), out.String())
+	}
+	var jr map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &jr); err != nil {
+		t.Fatal("statusReport did not produce valid JSON", err, lines[0])
+	}
+	if jr["what"] != "Status" || jr["name"] != s.Name() || jr["report"] == nil {
+		t.Error("statusReport JSON is missing fields", lines[0])
+	}
+}
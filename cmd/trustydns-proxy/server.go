@@ -37,8 +37,10 @@ corner-cases probably isn't productive.
 */
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"net"
 	"strings"
 	"sync"
 	"time"
@@ -46,6 +48,9 @@ import (
 	"github.com/markdingo/trustydns/internal/concurrencytracker"
 	"github.com/markdingo/trustydns/internal/dnsutil"
 	"github.com/markdingo/trustydns/internal/resolver"
+	"github.com/markdingo/trustydns/internal/resolver/cache"
+	"github.com/markdingo/trustydns/internal/sizehistogram"
+	"github.com/markdingo/trustydns/internal/topcounter"
 
 	"github.com/miekg/dns"
 )
@@ -56,27 +61,55 @@ const ( // ser = Server ERror index into failureCounters
 	serListSize
 )
 
+// serNames are the JSON report keys of the failure counters, indexed by ser* constants
+var serNames = [serListSize]string{"noResponse", "dnsWriteFailed"}
+
 const ( // ev = EVent index into events array
 	evInTruncated  = iota // DoH returned TC=1
 	evOutTruncated        // We set TC=1
+	evFiltered            // A or AAAA RRs removed from Answer
+	evDNS64               // AAAA RRs synthesized from A RRs
+	evFallback            // Resolved by --default-resolver after the primary resolver failed
+	evRebind              // Private addresses removed by --rebind-protect
+	evSlow                // Took longer than --slow-query-threshold
+	evTTLRaised           // Answer TTLs raised to --min-answer-ttl
 	evListSize
 )
 
+// evNames are the JSON report keys of the event counters, indexed by ev* constants
+var evNames = [evListSize]string{"inTruncated", "outTruncated", "filtered", "dns64", "fallback", "rebind",
+	"slow", "ttlRaised"}
+
 type events [evListSize]bool
 
+// onFailureRcodes maps the --on-failure settings which return a response to the rcode returned. Any
+// setting not present, such as "drop", results in no response at all.
+var onFailureRcodes = map[string]int{
+	"servfail": dns.RcodeServerFailure,
+	"refused":  dns.RcodeRefused,
+}
+
 type stats struct {
-	successCount    int              // Queries that ran to completion without error
-	totalLatency    time.Duration    // Duration of all successful queries
-	eventCounters   [evListSize]int  // Events that occur during the course of a query
-	failureCounters [serListSize]int // Errors that stop a query from progressing
+	successCount    int                // Queries that ran to completion without error
+	totalLatency    time.Duration      // Duration of all successful queries
+	eventCounters   [evListSize]int    // Events that occur during the course of a query
+	failureCounters [serListSize]int   // Errors that stop a query from progressing
+	qtypes          topcounter.Counter // Query types of all queries received
+	rcodes          topcounter.Counter // Rcodes of all responses returned to clients
+
+	sizes sizehistogram.Histogram // Sizes of all responses returned to clients
 }
 
 type server struct {
 	stdout        io.Writer
 	remote        resolver.Resolver // Mandatory resolver - never nil
 	local         resolver.Resolver // Optional resolver - may be nil
+	fallback      resolver.Resolver // Optional last-resort resolver - may be nil
+	typeRoutes    typeRoutes        // Consulted before local.InBailiwick() - may be nil
 	listenAddress string
-	transport     string // One of listenTransports
+	transport     string         // One of listenTransports
+	listener      net.Listener   // Pre-opened TCP socket from --systemd
+	packetConn    net.PacketConn // Pre-opened UDP socket from --systemd
 	server        *dns.Server
 	cct           concurrencytracker.Counter // Track peak concurrent server requests
 
@@ -97,11 +130,18 @@ func (t *server) start(errorChan chan error, wg *sync.WaitGroup) {
 	notifyWG.Add(1)
 	t.server = &dns.Server{Addr: t.listenAddress, Net: t.transport, Handler: t, NotifyStartedFunc: func() {
 		once.Do(func() { notifyWG.Done() })
-	}}
+	}, Listener: t.listener, PacketConn: t.packetConn, ReusePort: cfg.reusePort}
+	if t.transport == consts.DNSTCPTransport && cfg.tcpKeepaliveTimeout > 0 { // Honor what we advertise
+		t.server.IdleTimeout = func() time.Duration { return cfg.tcpKeepaliveTimeout }
+	}
 
 	wg.Add(1) // Add to caller's waitGroup
 	go func() {
-		errorChan <- t.server.ListenAndServe()
+		if t.listener != nil || t.packetConn != nil {
+			errorChan <- t.server.ActivateAndServe()
+		} else {
+			errorChan <- t.server.ListenAndServe()
+		}
 		once.Do(func() { notifyWG.Done() })
 		wg.Done()
 	}()
@@ -112,21 +152,43 @@ func (t *server) start(errorChan chan error, wg *sync.WaitGroup) {
 func (t *server) ServeDNS(writer dns.ResponseWriter, query *dns.Msg) {
 	var evs events // Track events for end-of-request call to addSuccessStats()
 
-	t.cct.Add() // Track peak concurrency for reporting purposes
-	defer t.cct.Done()
+	// Track peak concurrency for reporting purposes. Queries for the same question are
+	// distinguished so that the number of duplicates in-flight is visible.
+
+	if len(query.Question) > 0 {
+		key := cache.Key(query.Question[0], nil)
+		t.cct.AddKey(key)
+		defer t.cct.DoneKey(key)
+	} else {
+		t.cct.Add()
+		defer t.cct.Done()
+	}
+	t.addQueryTypeStats(query)
+	if warmRecorder != nil && len(query.Question) > 0 {
+		warmRecorder.add(query.Question[0])
+	}
 
-	// Default to remote resolver. Only use local resolver if we have a local resolver and the
-	// qName is in their bailiwick.
+	// Default to remote resolver. Only use local resolver if we have a local resolver and either
+	// the qType is routed to it or the qName is in their bailiwick. A qType route to remote
+	// overrides the bailiwick.
 	currResolver := t.remote
 	inType := "Cr:"  // Client In to remote DoH resolver
 	outType := "CO:" // Client Out
-	if t.local != nil && len(query.Question) > 0 && t.local.InBailiwick(query.Question[0].Name) {
-		inType = "Cl:" // Client In to local resolver
-		currResolver = t.local
+	if t.local != nil && len(query.Question) > 0 {
+		route, routed := t.typeRoutes[query.Question[0].Qtype]
+		if route == routeLocal || (!routed && t.local.InBailiwick(query.Question[0].Name)) {
+			inType = "Cl:" // Client In to local resolver
+			currResolver = t.local
+		}
+	}
+
+	trace := ""
+	if cfg.trace {
+		trace = newTraceID()
 	}
 
 	if cfg.logClientIn {
-		fmt.Fprintln(t.stdout, inType+writer.RemoteAddr().String()+":"+dnsutil.CompactMsgString(query))
+		fmt.Fprintln(t.stdout, inType+writer.RemoteAddr().String()+":"+compactMsg(query)+logECS(query)+logTrace(trace))
 	}
 
 	// Forward the request for resolution to either the local resolver or a remote DoH
@@ -134,32 +196,120 @@ func (t *server) ServeDNS(writer dns.ResponseWriter, query *dns.Msg) {
 	// any recovery or retry loops here. We can't sensible manage an error return to a DNS
 	// response so the best bet is to simply let the client retry ... if it chooses to do so.
 
+	// The --query-timeout deadline spans both the primary and fallback resolvers as it reflects
+	// how long the client is prepared to wait, not how long any one resolver should take.
+
+	ctx := context.Background()
+	if cfg.queryTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.queryTimeout)
+		defer cancel()
+	}
+
 	startTime := time.Now() // Track latency
-	resp, respMeta, err := currResolver.Resolve(query,
-		&resolver.QueryMetaData{TransportType: resolver.DNSTransportType(t.transport)})
+	qMeta := &resolver.QueryMetaData{TransportType: resolver.DNSTransportType(t.transport), TraceID: trace}
+	resp, respMeta, err := resolver.ResolveContext(ctx, currResolver, query, qMeta)
+	if err != nil && t.fallback != nil { // Last resort for air-gapped and split deployments
+		if cfg.logClientOut {
+			fmt.Fprintln(t.stdout, "CF:"+compactMsg(query), err.Error()+logTrace(trace))
+		}
+		evs[evFallback] = true
+		currResolver = t.fallback
+		outType = "Cf:"
+		resp, respMeta, err = resolver.ResolveContext(ctx, currResolver, query, qMeta)
+	}
 	duration := time.Now().Sub(startTime)
+	if cfg.slowQueryThreshold > 0 && duration > cfg.slowQueryThreshold {
+		evs[evSlow] = true
+		t.logSlowQuery(query, duration, respMeta, trace)
+	}
 	if err != nil {
 		t.addFailureStats(serNoResponse, evs)
 		msg := err.Error()
 		if cfg.logClientOut || (cfg.logTLSErrors && strings.Contains(msg, "x509: ")) {
-			fmt.Fprintln(t.stdout, "CE:"+dnsutil.CompactMsgString(query), msg)
+			fmt.Fprintln(t.stdout, "CE:"+compactMsg(query), msg+logTrace(trace))
+		}
+		if rcode, ok := onFailureRcodes[cfg.onFailure]; ok {
+			writer.WriteMsg(newErrorResponse(query, rcode)) // Best effort - we're already failing
+			t.addRcodeStats(rcode)
 		}
 		return
 	}
 
+	// DNS64 synthesis and filtering change the size of the response so the payload size reported
+	// by the resolver is no longer accurate for the truncation check.
+
+	payloadSize := respMeta.PayloadSize
+
+	// Responses from the local resolver are for internal names so private addresses are
+	// expected. The fallback resolver is just as open to rebinding as the remote resolver as it
+	// answers for any name. DNS64 issues its own A query so it is also given the protector to
+	// ensure private A RRs are never used for synthesis.
+
+	var protector *rebindProtector
+	if currResolver == t.remote || currResolver == t.fallback {
+		protector = rebind
+	}
+	if protector != nil {
+		if protector.filter(query, resp) {
+			evs[evRebind] = true
+			payloadSize = resp.Len()
+		}
+	}
+
+	if dns64Prefix != nil {
+		if synthesizeDNS64(ctx, currResolver, query, resp, qMeta, dns64Prefix, protector) {
+			evs[evDNS64] = true
+			payloadSize = resp.Len()
+		}
+	}
+
+	if cfg.filterA || cfg.filterAAAA {
+		rrtype := dns.TypeA
+		if cfg.filterAAAA {
+			rrtype = dns.TypeAAAA
+		}
+		if removeAnswerType(resp, rrtype) {
+			evs[evFiltered] = true
+			payloadSize = resp.Len()
+		}
+	}
+
+	// A floor on Answer TTLs stops clients re-querying constantly for zero TTL responses. The
+	// cache has already stored the original TTLs so this only affects what the client sees. A
+	// TSIG signed response cannot be modified.
+
+	if cfg.minAnswerTTL > 0 && resp.IsTsig() == nil {
+		if dnsutil.RaiseTTL(resp, uint32(cfg.minAnswerTTL/time.Second)) > 0 {
+			evs[evTTLRaised] = true
+		}
+	}
+
+	// Shuffle prior to truncation so that when a truncated response is returned, the surviving
+	// RRs are also a random selection.
+
+	if cfg.shuffleAnswers {
+		dnsutil.ShuffleAnswers(resp)
+	}
+
 	// Check for the need to truncate the response. The client's size limit comes from the
 	// inbound DNS query OPT, not any residual or alternative OPT that may be present in the
-	// response from DoH. We use our definition of truncated rather than msg.Truncate() (which
-	// has changed over time) and we also preserve the Truncated flag if it's already set.
+	// response from DoH unless overridden by --max-udp-size. Clients without an OPT are always
+	// limited to the system default as they can't accept anything larger. We use our definition
+	// of truncated rather than msg.Truncate() (which has changed over time) and we also
+	// preserve the Truncated flag if it's already set.
 
 	evs[evInTruncated] = resp.Truncated
-	if t.transport == consts.DNSUDPTransport && respMeta.PayloadSize > consts.DNSTruncateThreshold {
+	if t.transport == consts.DNSUDPTransport {
 		limit := consts.DNSTruncateThreshold
 		opt := query.IsEdns0()                        // Only use client's upper limit from query
 		if opt != nil && int(opt.UDPSize()) > limit { // if present *and* GT system limit
 			limit = int(opt.UDPSize())
 		}
-		if respMeta.PayloadSize > limit { // Only call Truncate() if we have to
+		if opt != nil && cfg.maxUDPSize > 0 { // Operator override trumps EDNS0
+			limit = cfg.maxUDPSize
+		}
+		if payloadSize > limit { // Only call Truncate() if we have to
 			evs[evOutTruncated] = true
 			preserveTruncated := resp.Truncated
 			beforeCount := len(resp.Answer) + len(resp.Ns) + len(resp.Extra)
@@ -169,20 +319,74 @@ func (t *server) ServeDNS(writer dns.ResponseWriter, query *dns.Msg) {
 		}
 	}
 
+	// Advertise our idle timeout to TCP clients. rfc7828 only allows this in a response if the
+	// query used EDNS0 and never over UDP.
+
+	if t.transport == consts.DNSTCPTransport && cfg.tcpKeepaliveTimeout > 0 && query.IsEdns0() != nil {
+		dnsutil.SetTCPKeepalive(resp, cfg.tcpKeepaliveTimeout)
+	}
+
 	err = writer.WriteMsg(resp)
 	if err != nil {
 		t.addFailureStats(serDNSWriteFailed, evs)
 		if cfg.logClientOut {
-			fmt.Fprintln(t.stdout, "CE:"+err.Error())
+			fmt.Fprintln(t.stdout, "CE:"+err.Error()+logTrace(trace))
 		}
 		return
 	}
 
-	t.addSuccessStats(duration, evs)
+	t.addSuccessStats(duration, payloadSize, evs)
+	t.addRcodeStats(resp.Rcode)
 	if cfg.logClientOut {
-		fmt.Fprintln(t.stdout, outType+dnsutil.CompactMsgString(resp),
-			respMeta.QueryTries, respMeta.ServerTries, "F:"+respMeta.FinalServerUsed, duration)
+		fmt.Fprintln(t.stdout, outType+compactMsg(resp)+logECS(resp),
+			respMeta.QueryTries, respMeta.ServerTries, "F:"+respMeta.FinalServerUsed, duration.String()+logTrace(trace))
+	}
+}
+
+// logSlowQuery unconditionally prints a query which exceeded --slow-query-threshold. respMeta may
+// be nil if resolution failed. trace is empty unless --trace is set.
+func (t *server) logSlowQuery(query *dns.Msg, duration time.Duration, respMeta *resolver.ResponseMetaData,
+	trace string) {
+	qName, qType, final := "-", "-", "-"
+	if len(query.Question) > 0 {
+		qName = query.Question[0].Name
+		qType = dns.Type(query.Question[0].Qtype).String()
+	}
+	if respMeta != nil && len(respMeta.FinalServerUsed) > 0 {
+		final = respMeta.FinalServerUsed
 	}
+	fmt.Fprintln(t.stdout, "SQ:"+qName, qType, duration, "F:"+final, t.transport+logTrace(trace))
+}
+
+// newErrorResponse builds a minimal response to query containing just the query ID, question and
+// the supplied rcode. If the query has an OPT then so does the response as required by rfc6891.
+func newErrorResponse(query *dns.Msg, rcode int) *dns.Msg {
+	resp := &dns.Msg{}
+	resp.SetRcode(query, rcode)
+	resp.RecursionAvailable = true
+	if query.IsEdns0() != nil {
+		resp.Extra = append(resp.Extra, dnsutil.NewOPT())
+	}
+
+	return resp
+}
+
+// removeAnswerType removes all RRs of rrtype from the Answer section. The Authority and Additional
+// sections are left untouched as they may contain glue needed by the client. Return true if any RRs
+// were removed.
+func removeAnswerType(msg *dns.Msg, rrtype uint16) bool {
+	answer := make([]dns.RR, 0, len(msg.Answer))
+	for _, rr := range msg.Answer {
+		if rr.Header().Rrtype != rrtype {
+			answer = append(answer, rr)
+		}
+	}
+	if len(answer) == len(msg.Answer) {
+		return false
+	}
+	msg.Answer = answer
+
+	return true
 }
 
 // stop performs an orderly shutdown of listen sockets.
@@ -191,3 +395,22 @@ func (t *server) stop() {
 		t.server.Shutdown()
 	}
 }
+
+// logECS renders any ECS in msg as a suffix for the Cl/Co log lines. Empty if there is no ECS.
+func logECS(msg *dns.Msg) string {
+	if ecs := dnsutil.ECSString(msg); len(ecs) > 0 {
+		return " ECS:" + ecs
+	}
+
+	return ""
+}
+
+// compactMsg formats msg for the log lines, adding detail if --log-detail is set
+func compactMsg(msg *dns.Msg) string {
+	opts := dnsutil.CompactNone
+	if cfg.logDetail {
+		opts = dnsutil.CompactDetail
+	}
+
+	return dnsutil.CompactMsgStringWith(msg, opts)
+}
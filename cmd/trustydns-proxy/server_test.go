@@ -9,6 +9,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/markdingo/trustydns/internal/dnsutil"
 	"github.com/markdingo/trustydns/internal/resolver"
 
 	"github.com/miekg/dns"
@@ -137,6 +138,47 @@ func TestServerLogging(t *testing.T) {
 	if !strings.Contains(outStr, "CO:") {
 		t.Error("Logging did not log Client Out Message")
 	}
+	if strings.Contains(outStr, "rcode=") || strings.Contains(outStr, "ECS:") {
+		t.Error("Logging should be terse without --log-detail or an ECS", outStr)
+	}
+
+	stdout = &mutexBytesBuffer{}
+	s.stdout = stdout
+	cfg.logDetail = true
+	dnsutil.CreateECS(q, 1, 24, net.ParseIP("192.0.2.0").To4())
+	s.ServeDNS(mw, q)
+	outStr = stdout.String()
+	if !strings.Contains(outStr, "rcode=NOERROR") {
+		t.Error("--log-detail did not add detail to Client log Messages", outStr)
+	}
+	if !strings.Contains(outStr, "ECS:192.0.2.0/24 scope /0") {
+		t.Error("Logging did not include the ECS", outStr)
+	}
+}
+
+// Test that --slow-query-threshold logs and counts slow queries regardless of other log settings
+func TestServerSlowQuery(t *testing.T) {
+	stdout := &mutexBytesBuffer{}
+	mainInit(stdout, os.Stderr)
+	cfg.slowQueryThreshold = time.Hour
+	res := &mockResolver{rMeta: resolver.ResponseMetaData{FinalServerUsed: "https://doh.example.net"}}
+	s := &server{stdout: stdout, remote: res, transport: "udp"}
+	q := &dns.Msg{}
+	q.SetQuestion("example.com.", dns.TypeMX)
+	s.ServeDNS(&mockResponseWriter{}, q)
+	if len(stdout.String()) != 0 || s.eventCounters[evSlow] != 0 {
+		t.Error("Fast query should not be logged or counted", stdout.String(), s.stats)
+	}
+
+	cfg.slowQueryThreshold = time.Nanosecond
+	s.ServeDNS(&mockResponseWriter{}, q)
+	outStr := stdout.String()
+	if !strings.HasPrefix(outStr, "SQ:example.com. MX ") || !strings.Contains(outStr, " F:https://doh.example.net udp") {
+		t.Error("Slow query not logged as expected", outStr)
+	}
+	if s.eventCounters[evSlow] != 1 {
+		t.Error("Slow query not counted", s.stats)
+	}
 }
 
 // Test for error return from the resolver. Check error logging while we're at it.
@@ -165,6 +207,81 @@ func TestServerResolverError(t *testing.T) {
 	}
 }
 
+// Test that --on-failure synthesizes an error response rather than dropping the query
+func TestServerOnFailure(t *testing.T) {
+	mainInit(os.Stdout, os.Stderr)
+	resolver := &mockResolver{err: errors.New("Mock Resolver Error")}
+	s := &server{stdout: stdout, remote: resolver}
+	q := &dns.Msg{}
+	q.SetQuestion("example.com.", dns.TypeNS)
+	q.Id = 4321
+
+	for _, tc := range []struct {
+		onFailure string
+		rcode     int
+	}{{"drop", -1}, {"servfail", dns.RcodeServerFailure}, {"refused", dns.RcodeRefused}} {
+		cfg.onFailure = tc.onFailure
+		mw := &mockResponseWriter{}
+		s.ServeDNS(mw, q)
+		if tc.rcode == -1 {
+			if mw.messageWritten != nil {
+				t.Error(tc.onFailure, "should not have written a response", mw.messageWritten)
+			}
+			continue
+		}
+		resp := mw.messageWritten
+		if resp == nil {
+			t.Error(tc.onFailure, "did not write a response")
+			continue
+		}
+		if resp.Rcode != tc.rcode || resp.Id != q.Id || !resp.Response {
+			t.Error(tc.onFailure, "wrong response header", resp.MsgHdr)
+		}
+		if len(resp.Question) != 1 || resp.Question[0] != q.Question[0] {
+			t.Error(tc.onFailure, "question not copied", resp.Question)
+		}
+	}
+}
+
+// Test that --default-resolver is used when the primary resolver fails and that its own failure is
+// still reported as a failure.
+func TestServerFallback(t *testing.T) {
+	stdout := &mutexBytesBuffer{}
+	mainInit(stdout, os.Stderr)
+	cfg.logClientOut = true
+	remote := &mockResolver{err: errors.New("Mock Resolver Error")}
+	fallback := &mockResolver{ib: true}
+	fallback.response.Id = 1234
+	s := &server{stdout: stdout, remote: remote, fallback: fallback}
+	q := &dns.Msg{}
+	q.SetQuestion("example.com.", dns.TypeNS)
+
+	mw := &mockResponseWriter{}
+	s.ServeDNS(mw, q)
+	if mw.messageWritten == nil || mw.messageWritten.Id != 1234 {
+		t.Fatal("Fallback resolver response not written", mw.messageWritten)
+	}
+	if s.successCount != 1 || s.eventCounters[evFallback] != 1 {
+		t.Error("Fallback not counted as a successful fallback event", s.stats)
+	}
+	if !strings.Contains(stdout.String(), "CF:") {
+		t.Error("Expected CF: log of primary resolver failure, not", stdout.String())
+	}
+
+	fallback.err = errors.New("Mock Fallback Error")
+	mw = &mockResponseWriter{}
+	s.ServeDNS(mw, q)
+	if mw.messageWritten != nil {
+		t.Error("Response written when fallback also failed", mw.messageWritten)
+	}
+	if s.failureCounters[serNoResponse] != 1 || s.eventCounters[evFallback] != 2 {
+		t.Error("Fallback failure not counted", s.stats)
+	}
+	if !strings.Contains(stdout.String(), "Mock Fallback Error") {
+		t.Error("Expected fallback error to be logged, not", stdout.String())
+	}
+}
+
 // Test for error return from dbs.WriteMsg. Check for error logging while we're at it.
 func TestServerWriteMsgError(t *testing.T) {
 	stdout := &mutexBytesBuffer{}
@@ -284,4 +401,296 @@ func TestServerTruncation(t *testing.T) {
 	if mw.messageWritten.Len() > 768 {
 		t.Error("Truncate ignored edns override of system limit. Reduced to", mw.messageWritten.Len())
 	}
+
+	// Test that --max-udp-size overrides the edns0 limit
+	resolver.response = response // Refresh response
+	resolver.rMeta.PayloadSize = resolver.response.Len()
+	cfg.maxUDPSize = 600
+
+	mw.messageWritten = nil
+	s.ServeDNS(mw, q)
+	if mw.messageWritten == nil {
+		t.Fatal("Test setup failed as response never got written to mockResponseWriter")
+	}
+	if !mw.messageWritten.MsgHdr.Truncated || mw.messageWritten.Len() > 600 {
+		t.Error("Truncate ignored --max-udp-size. Reduced to", mw.messageWritten.Len())
+	}
+
+	// and that it doesn't raise the limit for non-EDNS0 clients
+	resolver.response = response // Refresh response
+	resolver.rMeta.PayloadSize = resolver.response.Len()
+	cfg.maxUDPSize = 1000
+	defer func() { cfg.maxUDPSize = 0 }()
+	plainQ := &dns.Msg{}
+	plainQ.SetQuestion("example.com.", dns.TypeNS)
+
+	mw.messageWritten = nil
+	s.ServeDNS(mw, plainQ)
+	if mw.messageWritten == nil {
+		t.Fatal("Test setup failed as response never got written to mockResponseWriter")
+	}
+	if !mw.messageWritten.MsgHdr.Truncated || mw.messageWritten.Len() > 512 {
+		t.Error("--max-udp-size raised the limit for a non-EDNS0 query", mw.messageWritten.Len())
+	}
+
+	// and that it doesn't affect TCP
+	s.transport = "tcp"
+	resolver.response = response // Refresh response
+	mw.messageWritten = nil
+	s.ServeDNS(mw, q)
+	if mw.messageWritten == nil {
+		t.Fatal("Test setup failed as response never got written to mockResponseWriter")
+	}
+	if mw.messageWritten.MsgHdr.Truncated {
+		t.Error("--max-udp-size should not truncate TCP responses", mw.messageWritten.Len())
+	}
+}
+
+// Test that --shuffle-answers reorders the Answer RRset prior to writing the response.
+func TestServerShuffleAnswers(t *testing.T) {
+	mainInit(os.Stdout, os.Stderr)
+	resolver := &mockResolver{ib: true}
+	for ix := 1; ix <= 8; ix++ {
+		rr, _ := dns.NewRR("example.com. IN A 10.0.0." + string(rune('0'+ix)))
+		resolver.response.Answer = append(resolver.response.Answer, rr)
+	}
+	original := make([]dns.RR, len(resolver.response.Answer))
+	copy(original, resolver.response.Answer)
+	s := &server{stdout: stdout, local: resolver, transport: "tcp"}
+	q := &dns.Msg{}
+	q.SetQuestion("example.com.", dns.TypeA)
+
+	// Off by default so order must be retained
+	mw := &mockResponseWriter{}
+	s.ServeDNS(mw, q)
+	if mw.messageWritten == nil {
+		t.Fatal("Test setup failed as response never got written to mockResponseWriter")
+	}
+	for ix := range original {
+		if mw.messageWritten.Answer[ix] != original[ix] {
+			t.Fatal("Answers reordered without --shuffle-answers", mw.messageWritten.Answer)
+		}
+	}
+
+	cfg.shuffleAnswers = true
+	changed := false
+	for try := 0; try < 20 && !changed; try++ {
+		s.ServeDNS(mw, q)
+		for ix := range original {
+			if mw.messageWritten.Answer[ix] != original[ix] {
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		t.Error("--shuffle-answers never changed the order of the Answer RRs")
+	}
+}
+
+// Test that --filter-a and --filter-aaaa remove Answer RRs but leave glue alone
+func TestServerFilter(t *testing.T) {
+	mainInit(os.Stdout, os.Stderr)
+	resolver := &mockResolver{ib: true}
+	s := &server{stdout: stdout, local: resolver, transport: "udp"}
+	q := &dns.Msg{}
+	q.SetQuestion("example.com.", dns.TypeANY)
+	mw := &mockResponseWriter{}
+
+	for _, tc := range []struct {
+		filterA, filterAAAA bool
+		keep                uint16
+	}{{true, false, dns.TypeAAAA}, {false, true, dns.TypeA}} {
+		a, _ := dns.NewRR("example.com. IN A 10.0.0.1")
+		aaaa, _ := dns.NewRR("example.com. IN AAAA 2001:db8::1")
+		glueA, _ := dns.NewRR("ns.example.com. IN A 10.0.0.2")
+		glueAAAA, _ := dns.NewRR("ns.example.com. IN AAAA 2001:db8::2")
+		resolver.response = dns.Msg{}
+		resolver.response.Answer = []dns.RR{a, aaaa}
+		resolver.response.Extra = []dns.RR{glueA, glueAAAA}
+		resolver.rMeta.PayloadSize = resolver.response.Len()
+		cfg.filterA = tc.filterA
+		cfg.filterAAAA = tc.filterAAAA
+
+		s.ServeDNS(mw, q)
+		if mw.messageWritten == nil {
+			t.Fatal("Test setup failed as response never got written to mockResponseWriter")
+		}
+		if len(mw.messageWritten.Answer) != 1 || mw.messageWritten.Answer[0].Header().Rrtype != tc.keep {
+			t.Error("Filter did not leave just the", dns.TypeToString[tc.keep], mw.messageWritten.Answer)
+		}
+		if len(mw.messageWritten.Extra) != 2 {
+			t.Error("Filter should not touch Additional RRs", mw.messageWritten.Extra)
+		}
+	}
+	if s.eventCounters[evFiltered] != 2 {
+		t.Error("Filter event counter should be 2, not", s.eventCounters[evFiltered])
+	}
+}
+
+// Test that --min-answer-ttl raises low Answer TTLs but leaves the rest of the response alone
+func TestServerMinAnswerTTL(t *testing.T) {
+	mainInit(os.Stdout, os.Stderr)
+	resolver := &mockResolver{ib: true}
+	s := &server{stdout: stdout, local: resolver, transport: "udp"}
+	q := &dns.Msg{}
+	q.SetQuestion("example.com.", dns.TypeA)
+	mw := &mockResponseWriter{}
+
+	for ix, tc := range []struct {
+		floor  time.Duration
+		expect []uint32
+		event  int
+	}{{0, []uint32{0, 300}, 0}, {30 * time.Second, []uint32{30, 300}, 1}, {time.Hour, []uint32{3600, 3600}, 2}} {
+		a1, _ := dns.NewRR("example.com. 0 IN A 192.0.2.1")
+		a2, _ := dns.NewRR("example.com. 300 IN A 192.0.2.2")
+		soa, _ := dns.NewRR("example.com. 5 IN SOA ns.example.com. hostmaster.example.com. 1 2 3 4 5")
+		resolver.response = dns.Msg{}
+		resolver.response.Answer = []dns.RR{a1, a2}
+		resolver.response.Ns = []dns.RR{soa}
+		cfg.minAnswerTTL = tc.floor
+
+		s.ServeDNS(mw, q)
+		if mw.messageWritten == nil {
+			t.Fatal("Test setup failed as response never got written to mockResponseWriter")
+		}
+		for ax, expect := range tc.expect {
+			if got := mw.messageWritten.Answer[ax].Header().Ttl; got != expect {
+				t.Error(ix, ax, "Expected TTL", expect, "got", got)
+			}
+		}
+		if mw.messageWritten.Ns[0].Header().Ttl != 5 {
+			t.Error(ix, "Authority TTL should not change", mw.messageWritten.Ns)
+		}
+		if s.eventCounters[evTTLRaised] != tc.event {
+			t.Error(ix, "Expected TTL raised event count of", tc.event, "not", s.eventCounters[evTTLRaised])
+		}
+	}
+}
+
+// Test that --tcp-keepalive-timeout only adds the keepalive option to EDNS0 TCP responses
+func TestServerTCPKeepalive(t *testing.T) {
+	mainInit(os.Stdout, os.Stderr)
+	cfg.tcpKeepaliveTimeout = 10 * time.Second
+	resolver := &mockResolver{ib: true}
+	s := &server{stdout: stdout, local: resolver}
+	mw := &mockResponseWriter{}
+	q := &dns.Msg{}
+	q.SetQuestion("example.com.", dns.TypeA)
+	q.SetEdns0(1232, false)
+
+	for _, tc := range []struct {
+		transport string
+		edns      bool
+		expect    bool
+	}{{"udp", true, false}, {"tcp", false, false}, {"tcp", true, true}} {
+		resolver.response = dns.Msg{}
+		s.transport = tc.transport
+		query := q.Copy()
+		if !tc.edns {
+			query.Extra = nil
+		}
+		s.ServeDNS(mw, query)
+		if mw.messageWritten == nil {
+			t.Fatal("Test setup failed as response never got written to mockResponseWriter")
+		}
+		found := false
+		if opt := mw.messageWritten.IsEdns0(); opt != nil {
+			for _, o := range opt.Option {
+				if ka, ok := o.(*dns.EDNS0_TCP_KEEPALIVE); ok && ka.Timeout == 100 {
+					found = true
+				}
+			}
+		}
+		if found != tc.expect {
+			t.Error(tc.transport, "EDNS0", tc.edns, "expected keepalive", tc.expect, "got", found)
+		}
+	}
+}
+
+// Two servers should be able to share the same address with --reuse-port
+func TestServerReusePort(t *testing.T) {
+	mainInit(os.Stdout, os.Stderr)
+	cfg.reusePort = true
+	errorChannel := make(chan error, 4)
+	wg := &sync.WaitGroup{}
+	var servers []*server
+	for _, transport := range []string{"udp", "udp", "tcp", "tcp"} {
+		s := &server{stdout: stdout, listenAddress: "127.0.0.1:59054", transport: transport}
+		s.start(errorChannel, wg)
+		servers = append(servers, s)
+	}
+	select {
+	case err := <-errorChannel:
+		t.Error("Unexpected start error with --reuse-port", err)
+	case <-time.After(time.Millisecond * 100):
+	}
+	for _, s := range servers {
+		s.stop()
+	}
+	wg.Wait()
+}
+
+// blockingResolver holds every Resolve() call until release is closed so that queries stay in-flight
+type blockingResolver struct {
+	mockResolver
+	started chan struct{}
+	release chan struct{}
+}
+
+func (t *blockingResolver) Resolve(query *dns.Msg, qMeta *resolver.QueryMetaData) (*dns.Msg, *resolver.ResponseMetaData, error) {
+	t.started <- struct{}{}
+	<-t.release
+	return t.mockResolver.Resolve(query, qMeta)
+}
+
+// Test that duplicate in-flight queries are reported as coalesced
+func TestServerCoalesced(t *testing.T) {
+	mainInit(os.Stdout, os.Stderr)
+	br := &blockingResolver{started: make(chan struct{}), release: make(chan struct{})}
+	s := &server{stdout: stdout, remote: br}
+
+	var wg sync.WaitGroup
+	for _, qName := range []string{"example.com.", "EXAMPLE.com.", "example.com.", "example.net."} {
+		q := &dns.Msg{}
+		q.SetQuestion(qName, dns.TypeA)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.ServeDNS(&mockResponseWriter{}, q)
+		}()
+		<-br.started
+	}
+	if d := s.cct.Distinct(); d != 2 {
+		t.Error("Expected two distinct in-flight queries, not", d)
+	}
+	close(br.release)
+	wg.Wait()
+
+	if rep := s.Report(true); !strings.Contains(rep, "Concurrency=4 Coalesced=2") {
+		t.Error("Expected Concurrency=4 Coalesced=2 in", rep)
+	}
+}
+
+// Test that --query-timeout abandons a slow resolution and counts it as a failure
+func TestServerQueryTimeout(t *testing.T) {
+	mainInit(os.Stdout, os.Stderr)
+	cfg.queryTimeout = time.Millisecond * 50
+	br := &blockingResolver{started: make(chan struct{}, 1), release: make(chan struct{})}
+	defer close(br.release)
+	s := &server{stdout: stdout, remote: br}
+	q := &dns.Msg{}
+	q.SetQuestion("example.com.", dns.TypeA)
+
+	mw := &mockResponseWriter{}
+	start := time.Now()
+	s.ServeDNS(mw, q)
+	if time.Since(start) > time.Second {
+		t.Error("ServeDNS did not honour --query-timeout", time.Since(start))
+	}
+	if mw.messageWritten != nil {
+		t.Error("Response written for an abandoned query", mw.messageWritten)
+	}
+	if s.failureCounters[serNoResponse] != 1 {
+		t.Error("Abandoned query not counted as a failure", s.stats)
+	}
 }
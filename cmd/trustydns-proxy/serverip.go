@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// serverIPs maps a lower-cased DoH server hostname to the IP addresses pinned by --server-ip. When a
+// hostname is pinned, connections are made to its pinned addresses in the order given without any
+// DNS lookup. TLS is still negotiated and verified against the hostname as the transport derives
+// the SNI and certificate name from the URL rather than from the dialed address.
+type serverIPs map[string][]string
+
+// parseServerIPs converts --server-ip hostname=IP values into a serverIPs table. A hostname may be
+// repeated to pin multiple addresses.
+func parseServerIPs(values []string) (serverIPs, error) {
+	sips := make(serverIPs)
+	for _, v := range values {
+		host, ip, found := strings.Cut(v, "=")
+		if !found || len(host) == 0 {
+			return nil, fmt.Errorf("%s is not of the form hostname=IP", v)
+		}
+		if net.ParseIP(ip) == nil {
+			return nil, fmt.Errorf("%s is not an IP address", ip)
+		}
+		host = strings.ToLower(strings.TrimSuffix(host, "."))
+		sips[host] = append(sips[host], ip)
+	}
+
+	return sips, nil
+}
+
+// dialContext wraps dial so that connections to pinned hostnames are made to each pinned address in
+// turn until one succeeds. All other addresses are passed directly to dial.
+func (t serverIPs) dialContext(dial dialContextFunc) dialContextFunc {
+	if len(t) == 0 {
+		return dial
+	}
+
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return dial(ctx, network, address)
+		}
+		ips := t[strings.ToLower(strings.TrimSuffix(host, "."))]
+		if len(ips) == 0 {
+			return dial(ctx, network, address)
+		}
+
+		var firstErr error
+		for _, ip := range ips {
+			conn, err := dial(ctx, network, net.JoinHostPort(ip, port))
+			if err == nil {
+				return conn, nil
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			if ctx.Err() != nil {
+				break
+			}
+		}
+
+		return nil, firstErr
+	}
+}
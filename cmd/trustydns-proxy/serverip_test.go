@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestParseServerIPs(t *testing.T) {
+	sips, err := parseServerIPs([]string{"DNS.example.net.=192.0.2.1", "dns.example.net=2001:db8::1",
+		"doh.example.org=192.0.2.2"})
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if strings.Join(sips["dns.example.net"], " ") != "192.0.2.1 2001:db8::1" {
+		t.Error("Multiple IPs not accumulated in order", sips)
+	}
+	if len(sips["doh.example.org"]) != 1 {
+		t.Error("Expected one IP for doh.example.org", sips)
+	}
+
+	testCases := []struct {
+		value string
+		err   string
+	}{
+		{"dns.example.net", "not of the form"},
+		{"=192.0.2.1", "not of the form"},
+		{"dns.example.net=", "not an IP address"},
+		{"dns.example.net=ns.example.net", "not an IP address"},
+	}
+	for _, tc := range testCases {
+		_, err := parseServerIPs([]string{tc.value})
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Error(tc.value, "Expected error with", tc.err, "got", err)
+		}
+	}
+}
+
+func TestServerIPsDialContext(t *testing.T) {
+	var dialed []string
+	fail := map[string]bool{"192.0.2.1:443": true}
+	dial := func(ctx context.Context, network, address string) (net.Conn, error) {
+		dialed = append(dialed, address)
+		if fail[address] {
+			return nil, errors.New("refused " + address)
+		}
+		c, _ := net.Pipe()
+		return c, nil
+	}
+
+	sips, _ := parseServerIPs([]string{"dns.example.net=192.0.2.1", "dns.example.net=2001:db8::1"})
+	dc := sips.dialContext(dial)
+
+	testCases := []struct {
+		address string
+		expect  string
+	}{
+		{"DNS.example.net:443", "192.0.2.1:443 [2001:db8::1]:443"}, // Fail over to second pin
+		{"doh.example.org:443", "doh.example.org:443"},             // Not pinned
+		{"192.0.2.3:443", "192.0.2.3:443"},
+	}
+	for _, tc := range testCases {
+		dialed = nil
+		conn, err := dc(context.Background(), "tcp", tc.address)
+		if err != nil {
+			t.Fatal(tc.address, "Unexpected error", err)
+		}
+		conn.Close()
+		if strings.Join(dialed, " ") != tc.expect {
+			t.Error(tc.address, "Expected", tc.expect, "got", dialed)
+		}
+	}
+
+	fail["[2001:db8::1]:443"] = true
+	_, err := dc(context.Background(), "tcp", "dns.example.net:443")
+	if err == nil || err.Error() != "refused 192.0.2.1:443" {
+		t.Error("Expected first error when all pins fail, not", err)
+	}
+}
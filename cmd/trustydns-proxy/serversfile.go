@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/markdingo/trustydns/internal/resolver/doh"
+)
+
+// serversFileEntry is a single DoH server in the --servers-file JSON array. All fields other than
+// URL are optional and default to the command-line settings. An example entry:
+//
+//	{"url": "https://dns.example.net/dns-query", "max-connections": 4, "weight": 2,
+//	 "tls-server-name": "doh.example.net", "headers": {"Authorization": "Bearer xyzzy"}}
+type serversFileEntry struct {
+	URL            string            `json:"url"`
+	MaxConnections int               `json:"max-connections"` // Replaces -r for this server
+	TLSServerName  string            `json:"tls-server-name"` // SNI and certificate verification name
+	Headers        map[string]string `json:"headers"`         // Added to every request
+	Weight         int               `json:"weight"`          // Latency ranking bias - 0 means 1
+}
+
+// loadServersFile reads and validates the --servers-file. Unknown fields are rejected so that
+// typos don't silently revert a server to the defaults.
+func loadServersFile(path string) ([]serversFileEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []serversFileEntry
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&entries)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	for ix := range entries {
+		e := &entries[ix]
+		e.URL, err = normalizeDoHURL(e.URL)
+		if err != nil {
+			return nil, fmt.Errorf("%s: entry %d: %s", path, ix+1, err.Error())
+		}
+		if e.Weight < 0 {
+			return nil, fmt.Errorf("%s: entry %d: weight cannot be negative", path, ix+1)
+		}
+		if e.MaxConnections < 0 {
+			return nil, fmt.Errorf("%s: entry %d: max-connections cannot be negative", path, ix+1)
+		}
+	}
+
+	return entries, nil
+}
+
+// normalizeDoHURL converts a DoH server URL as supplied by the user into a full URL. A plain FQDN
+// is accepted and an https scheme is assumed if none is supplied. A unix:///path URL names a Unix
+// domain socket and is returned as-is apart from requiring a path.
+func normalizeDoHURL(dohURL string) (string, error) {
+	u, err := url.Parse(dohURL)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme == "unix" {
+		if len(u.Host) > 0 || len(u.Path) == 0 {
+			return "", fmt.Errorf("%s is not of the form unix:///path", dohURL)
+		}
+		return u.String(), nil
+	}
+	if len(u.Scheme) == 0 && len(u.Host) == 0 && len(u.Path) > 0 { // A plain FQDN looks like this
+		u.Host = u.Path
+		u.Path = ""
+	}
+	if len(u.Host) == 0 {
+		return "", fmt.Errorf("%s does not contain a hostname", dohURL)
+	}
+	if len(u.Scheme) == 0 {
+		u.Scheme = "https"
+	}
+
+	return u.String(), nil
+}
+
+// serverConfigs converts the servers file entries into doh.ServerConfigs. Entries which need their
+// own transport settings get their own http.Client otherwise they share the resolver-wide client.
+// Their transports are otherwise configured the same as the resolver-wide transport, that is, with
+// tlsConfig, maxConns, dohConfig and dial.
+func serverConfigs(entries []serversFileEntry, tlsConfig *tls.Config, maxConns int, dohConfig doh.Config,
+	timeout time.Duration, dial dialContextFunc) ([]doh.ServerConfig, error) {
+	scs := make([]doh.ServerConfig, 0, len(entries))
+	for _, e := range entries {
+		sc := doh.ServerConfig{URL: e.URL, Headers: e.Headers, Weight: e.Weight}
+		if e.MaxConnections > 0 || len(e.TLSServerName) > 0 {
+			tr := &http.Transport{TLSClientConfig: tlsConfig.Clone(), MaxConnsPerHost: maxConns,
+				DialContext: dial}
+			if e.MaxConnections > 0 {
+				tr.MaxConnsPerHost = e.MaxConnections
+			}
+			if len(e.TLSServerName) > 0 {
+				tr.TLSClientConfig.ServerName = e.TLSServerName
+			}
+			if err := doh.ConfigureTransport(tr, dohConfig); err != nil {
+				return nil, err
+			}
+			sc.HTTPClient = &http.Client{Timeout: timeout, Transport: tr}
+		}
+		scs = append(scs, sc)
+	}
+
+	return scs, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/markdingo/trustydns/internal/resolver/doh"
+)
+
+func TestServersFile(t *testing.T) {
+	mainInit(&bytes.Buffer{}, &bytes.Buffer{})
+	entries, err := loadServersFile("testdata/servers.json")
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if len(entries) != 3 {
+		t.Fatal("Expected three entries, not", len(entries))
+	}
+	if entries[1].URL != "https://doh.example.org" {
+		t.Error("Plain FQDN was not normalized to an https URL", entries[1].URL)
+	}
+
+	scs, err := serverConfigs(entries, &tls.Config{}, 2, doh.Config{}, 0, nil)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if scs[0].HTTPClient != nil {
+		t.Error("Entry without transport settings should share the resolver client")
+	}
+	if scs[0].Weight != 2 || scs[1].Weight != 0 {
+		t.Error("weight not carried across", scs[0].Weight, scs[1].Weight)
+	}
+	if scs[1].Headers["Authorization"] != "Bearer xyzzy" {
+		t.Error("Headers not carried across", scs[1].Headers)
+	}
+	client, ok := scs[1].HTTPClient.(*http.Client)
+	if !ok {
+		t.Fatal("Expected an *http.Client for second entry, not", scs[1].HTTPClient)
+	}
+	tr := client.Transport.(*http.Transport)
+	if tr.MaxConnsPerHost != 4 {
+		t.Error("max-connections not applied", tr.MaxConnsPerHost)
+	}
+	if tr.TLSClientConfig.ServerName != "sni.example.org" {
+		t.Error("tls-server-name not applied", tr.TLSClientConfig.ServerName)
+	}
+	tr = scs[2].HTTPClient.(*http.Client).Transport.(*http.Transport)
+	if tr.MaxConnsPerHost != 2 || tr.TLSClientConfig.ServerName != "sni.example.com" {
+		t.Error("Resolver-wide max connections not applied", tr.MaxConnsPerHost)
+	}
+
+	testCases := []struct{ path, err string }{
+		{"testdata/servers-badfield.json", "cannot unmarshal string"},
+		{"testdata/servers-unknown.json", "unknown field"},
+		{"testdata/servers-weight.json", "entry 2: weight cannot be negative"},
+		{"testdata/emptyfile", "EOF"},
+		{"testdata/nosuchfile.json", "no such file"},
+	}
+	for _, tc := range testCases {
+		_, err := loadServersFile(tc.path)
+		if err == nil {
+			t.Error(tc.path, "Expected an error containing", tc.err)
+			continue
+		}
+		if !strings.Contains(err.Error(), tc.err) {
+			t.Error(tc.path, "Expected error containing", tc.err, "got", err)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+)
+
+// activatedServers creates a server for each socket passed via systemd socket activation. Stream
+// sockets are served as TCP and datagram sockets as UDP so --tcp and --udp are ignored. The files
+// are closed as the net package dups them.
+func activatedServers(files []*os.File, rs *resources) ([]*server, error) {
+	var servers []*server
+	for _, f := range files {
+		s := &server{stdout: stdout, local: rs.localResolver, remote: rs.remoteResolver,
+			fallback: rs.defaultResolver, typeRoutes: rs.typeRoutes}
+		if l, err := net.FileListener(f); err == nil {
+			s.listener = l
+			s.listenAddress = l.Addr().String()
+			s.transport = consts.DNSTCPTransport
+		} else if pc, err := net.FilePacketConn(f); err == nil {
+			s.packetConn = pc
+			s.listenAddress = pc.LocalAddr().String()
+			s.transport = consts.DNSUDPTransport
+		} else {
+			f.Close()
+			return nil, fmt.Errorf("%s is not a TCP or UDP socket: %s", f.Name(), err.Error())
+		}
+		f.Close()
+		servers = append(servers, s)
+	}
+
+	return servers, nil
+}
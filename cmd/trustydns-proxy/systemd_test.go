@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestActivatedServers(t *testing.T) {
+	mainInit(&mutexBytesBuffer{}, &mutexBytesBuffer{})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	lf, err := l.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pcf, err := pc.(*net.UDPConn).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	servers, err := activatedServers([]*os.File{lf, pcf}, &resources{})
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if len(servers) != 2 {
+		t.Fatal("Expected two servers, not", len(servers))
+	}
+	if servers[0].transport != consts.DNSTCPTransport || servers[0].listenAddress != l.Addr().String() {
+		t.Error("First server should be TCP on", l.Addr(), "not", servers[0].Name())
+	}
+	if servers[1].transport != consts.DNSUDPTransport || servers[1].listenAddress != pc.LocalAddr().String() {
+		t.Error("Second server should be UDP on", pc.LocalAddr(), "not", servers[1].Name())
+	}
+
+	errorChannel := make(chan error, len(servers))
+	wg := &sync.WaitGroup{}
+	for _, s := range servers {
+		s.start(errorChannel, wg)
+	}
+	for _, s := range servers {
+		s.stop()
+	}
+	wg.Wait()
+	close(errorChannel)
+	for err := range errorChannel {
+		if err != nil {
+			t.Error("Unexpected server error", err)
+		}
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	_, err = activatedServers([]*os.File{r}, &resources{})
+	if err == nil || !strings.Contains(err.Error(), "not a TCP or UDP socket") {
+		t.Error("Expected 'not a TCP or UDP socket' error with a pipe, not", err)
+	}
+}
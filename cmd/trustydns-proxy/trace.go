@@ -0,0 +1,25 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// newTraceID returns a random ID which --trace sends to the DoH server in the X-trustydns-Trace
+// header. The server echoes it in its logs so a query can be followed through both tiers.
+func newTraceID() string {
+	var b [8]byte
+	rand.Read(b[:]) // Failure leaves zeroes which is still a usable, if not unique, ID
+
+	return hex.EncodeToString(b[:])
+}
+
+// logTrace formats a trace ID for appending to a log line. Nothing is appended if there is no ID so
+// the logs of untraced queries are unchanged.
+func logTrace(id string) string {
+	if len(id) == 0 {
+		return ""
+	}
+
+	return " T:" + id
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/markdingo/trustydns/internal/resolver"
+
+	"github.com/miekg/dns"
+)
+
+// traceResolver records the TraceID passed in the QueryMetaData
+type traceResolver struct {
+	mockResolver
+	traceID string
+}
+
+func (t *traceResolver) Resolve(query *dns.Msg, qMeta *resolver.QueryMetaData) (*dns.Msg, *resolver.ResponseMetaData, error) {
+	t.traceID = qMeta.TraceID
+	return t.mockResolver.Resolve(query, qMeta)
+}
+
+func TestNewTraceID(t *testing.T) {
+	id1 := newTraceID()
+	id2 := newTraceID()
+	if !regexp.MustCompile("^[0-9a-f]{16}$").MatchString(id1) {
+		t.Error("Trace ID is not 16 hex digits", id1)
+	}
+	if id1 == id2 {
+		t.Error("Consecutive trace IDs should differ", id1, id2)
+	}
+}
+
+// Test that --trace passes a trace ID to the resolver and appends the same ID to the log lines
+func TestServerTrace(t *testing.T) {
+	stdout := &mutexBytesBuffer{}
+	mainInit(stdout, &mutexBytesBuffer{})
+	cfg.logClientIn = true
+	cfg.logClientOut = true
+	res := &traceResolver{}
+	s := &server{stdout: stdout, remote: res}
+	q := &dns.Msg{}
+	q.SetQuestion("example.com.", dns.TypeA)
+	s.ServeDNS(&mockResponseWriter{}, q)
+	if len(res.traceID) > 0 || strings.Contains(stdout.String(), " T:") {
+		t.Error("Did not expect a trace ID without --trace", res.traceID, stdout.String())
+	}
+
+	stdout = &mutexBytesBuffer{}
+	s.stdout = stdout
+	cfg.trace = true
+	s.ServeDNS(&mockResponseWriter{}, q)
+	if len(res.traceID) == 0 {
+		t.Fatal("Trace ID not passed to the resolver")
+	}
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatal("Expected Cr: and CO: log lines", lines)
+	}
+	for _, l := range lines {
+		if !strings.HasSuffix(l, " T:"+res.traceID) {
+			t.Error("Log line lacks trace ID", res.traceID, l)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	routeLocal  = "local"
+	routeRemote = "remote"
+)
+
+// typeRoutes maps a query type to the resolver which handles it regardless of the qName. It is
+// consulted ahead of the split-horizon InBailiwick() check. Values are routeLocal or routeRemote.
+type typeRoutes map[uint16]string
+
+// parseTypeRoutes converts --type-route qtype=local|remote values into a typeRoutes table. A later
+// setting for the same qtype replaces an earlier one.
+func parseTypeRoutes(routes []string) (typeRoutes, error) {
+	tr := make(typeRoutes)
+	for _, r := range routes {
+		qtype, route, found := strings.Cut(r, "=")
+		if !found {
+			return nil, fmt.Errorf("%s is not of the form qtype=%s|%s", r, routeLocal, routeRemote)
+		}
+		qt, ok := dns.StringToType[strings.ToUpper(qtype)]
+		if !ok {
+			return nil, fmt.Errorf("%s is not a known query type", qtype)
+		}
+		route = strings.ToLower(route)
+		if route != routeLocal && route != routeRemote {
+			return nil, fmt.Errorf("%s route must be one of %s or %s", qtype, routeLocal, routeRemote)
+		}
+		tr[qt] = route
+	}
+
+	return tr, nil
+}
+
+// hasLocal returns true if any query type is routed to the local resolver.
+func (t typeRoutes) hasLocal() bool {
+	for _, route := range t {
+		if route == routeLocal {
+			return true
+		}
+	}
+
+	return false
+}
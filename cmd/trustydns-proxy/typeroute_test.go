@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestParseTypeRoutes(t *testing.T) {
+	tr, err := parseTypeRoutes([]string{"PTR=local", "srv=REMOTE", "MX=remote", "MX=local"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tr) != 3 || tr[dns.TypePTR] != routeLocal || tr[dns.TypeSRV] != routeRemote ||
+		tr[dns.TypeMX] != routeLocal {
+		t.Error("Wrong routing table", tr)
+	}
+	if !tr.hasLocal() {
+		t.Error("hasLocal should be true with PTR=local")
+	}
+
+	testCases := []struct{ route, expect string }{
+		{"PTR", "not of the form"},
+		{"NOTATYPE=local", "not a known query type"},
+		{"PTR=elsewhere", "must be one of"},
+	}
+	for _, tc := range testCases {
+		_, err := parseTypeRoutes([]string{tc.route})
+		if err == nil {
+			t.Error("Expected error from", tc.route)
+		} else if !strings.Contains(err.Error(), tc.expect) {
+			t.Error("Expected", tc.expect, "got", err)
+		}
+	}
+}
+
+// Test that ServeDNS consults the type routes ahead of the local bailiwick
+func TestServerTypeRoute(t *testing.T) {
+	mainInit(os.Stdout, os.Stderr)
+	local := &mockResolver{ib: true}
+	local.response.Id = 1
+	remote := &mockResolver{}
+	remote.response.Id = 2
+	tr, err := parseTypeRoutes([]string{"PTR=local", "TXT=remote"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		ib     bool   // Local resolver bailiwick
+		qtype  uint16 // Of query
+		expect uint16 // Id of responding resolver
+	}{
+		{false, dns.TypePTR, 1}, // Routed local despite name
+		{true, dns.TypeTXT, 2},  // Routed remote despite name
+		{true, dns.TypeA, 1},    // Unrouted so name decides
+		{false, dns.TypeA, 2},
+	}
+
+	s := &server{stdout: stdout, local: local, remote: remote, typeRoutes: tr}
+	for ix, tc := range testCases {
+		local.ib = tc.ib
+		q := &dns.Msg{}
+		q.SetQuestion("1.2.0.192.in-addr.arpa.", tc.qtype)
+		mw := &mockResponseWriter{}
+		s.ServeDNS(mw, q)
+		if mw.messageWritten == nil {
+			t.Fatal(ix, "No response written")
+		}
+		if mw.messageWritten.Id != tc.expect {
+			t.Error(ix, "Wrong resolver used. Expected", tc.expect, "got", mw.messageWritten.Id)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// unixSocketDomain is the parent of the placeholder hostnames substituted for unix:// DoH URLs. The
+// .invalid TLD is reserved by RFC2606 so a placeholder can never be confused with a real server.
+const unixSocketDomain = ".unix.invalid"
+
+// unixSockets maps the placeholder hostnames substituted for unix:// DoH URLs to their socket
+// paths. net/http only speaks http and https so a unix:///path URL is rewritten as
+// http://placeholder/dns-query and the dialer connects the placeholder to the socket instead.
+type unixSockets map[string]string
+
+// rewriteURL returns the http URL substituted for a unix:// DoH URL. The same socket path always
+// gets the same placeholder. All other URLs are returned unchanged.
+func (t unixSockets) rewriteURL(dohURL string) string {
+	u, err := url.Parse(dohURL)
+	if err != nil || u.Scheme != "unix" {
+		return dohURL
+	}
+	host := ""
+	for h, p := range t {
+		if p == u.Path {
+			host = h
+			break
+		}
+	}
+	if len(host) == 0 {
+		host = fmt.Sprintf("socket%d%s", len(t)+1, unixSocketDomain)
+		t[host] = u.Path
+	}
+
+	return "http://" + host + consts.Rfc8484Path
+}
+
+// dialContext wraps dial so that connections to placeholder hostnames are made to the
+// corresponding Unix domain socket. All other addresses are passed directly to dial.
+func (t unixSockets) dialContext(dial dialContextFunc) dialContextFunc {
+	if len(t) == 0 {
+		return dial
+	}
+
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return dial(ctx, network, address)
+		}
+		path, ok := t[strings.ToLower(host)]
+		if !ok {
+			return dial(ctx, network, address)
+		}
+
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", path)
+	}
+}
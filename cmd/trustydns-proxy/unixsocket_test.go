@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnixSocketsRewriteURL(t *testing.T) {
+	uss := unixSockets{}
+	testCases := []struct {
+		in     string
+		expect string
+	}{
+		{"https://dns.example.net/dns-query", "https://dns.example.net/dns-query"},
+		{"unix:///run/a.sock", "http://socket1.unix.invalid/dns-query"},
+		{"unix:///run/b.sock", "http://socket2.unix.invalid/dns-query"},
+		{"unix:///run/a.sock", "http://socket1.unix.invalid/dns-query"}, // Same path, same placeholder
+		{"", ""},
+	}
+	for _, tc := range testCases {
+		if got := uss.rewriteURL(tc.in); got != tc.expect {
+			t.Error(tc.in, "expected", tc.expect, "got", got)
+		}
+	}
+	if len(uss) != 2 || uss["socket2.unix.invalid"] != "/run/b.sock" {
+		t.Error("Unexpected placeholder map", uss)
+	}
+}
+
+func TestUnixSocketsDialContext(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "doh.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	var dialed string
+	dial := func(ctx context.Context, network, address string) (net.Conn, error) {
+		dialed = address
+		return nil, errors.New("not a socket")
+	}
+	uss := unixSockets{}
+	uss.rewriteURL("unix://" + path)
+	dc := uss.dialContext(dial)
+
+	conn, err := dc(context.Background(), "tcp", "socket1.unix.invalid:80")
+	if err != nil {
+		t.Fatal("Placeholder not dialed on socket", err)
+	}
+	conn.Close()
+	if len(dialed) > 0 {
+		t.Error("Placeholder passed to underlying dial", dialed)
+	}
+
+	dc(context.Background(), "tcp", "dns.example.net:443")
+	if dialed != "dns.example.net:443" {
+		t.Error("Regular address not passed to underlying dial", dialed)
+	}
+
+	if unixSockets(nil).dialContext(nil) != nil {
+		t.Error("Empty unixSockets should return dial unchanged")
+	}
+}
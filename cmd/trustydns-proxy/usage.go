@@ -7,6 +7,9 @@ import (
 	"time"
 
 	"github.com/markdingo/trustydns/internal/bestserver"
+	"github.com/markdingo/trustydns/internal/flagutil"
+	"github.com/markdingo/trustydns/internal/resolver/cache"
+	"github.com/markdingo/trustydns/internal/resolver/doh"
 )
 
 // The "flag" package is not tty aware so we've arbitrarily picked 100 columns as a conservative tty
@@ -37,6 +40,36 @@ DESCRIPTION
           names can be supplied on the command-line if you want to use a system generated
           resolv.conf or similar immutable file.
 
+          Queries can also be routed by query type with --type-route, e.g. "--type-route PTR=local"
+          sends all PTR queries to the local resolver so that reverse lookups of internal addresses
+          work regardless of name. A "remote" route sends that query type to the DoH servers even
+          if the name is local. Query type routes take precedence over name matching.
+
+          Responses from both the local resolver and the DoH servers can be held in a shared cache
+          of --cache-size entries. Responses are cached for no longer than their TTL, or SOA MINIMUM
+          for negative responses, and never longer than --cache-max-ttl. Negative responses can be
+          held for less with --negative-ttl-max so that a newly created name is not hidden for long
+          by an upstream with a large SOA MINIMUM. A DoH server can shorten the cache period with a
+          Cache-Control max-age or prevent caching with no-cache. The TTLs of cached responses are
+          reduced to the remaining cache period so that clients are bound by the same limits. If a
+          DoH server sets stale-while-revalidate, an expired response continues to be returned with
+          zero TTLs for that period while it is re-resolved in the background. Responses to ECS
+          queries are only re-used for clients within the response's scope. As responses vary
+          greatly in size, --cache-max-memory additionally bounds the estimated memory used by the
+          cache by evicting the least recently used responses. The estimate is the sum of the packed
+          lengths of the cached responses so actual memory use is somewhat higher.
+
+          Answers with very short or zero TTLs cause some clients to re-query constantly.
+          --min-answer-ttl raises any Answer TTL below the floor just before the response is
+          returned to the client, regardless of which resolver answered. Cached responses retain
+          their original TTLs.
+
+          To reduce the cold-start latency after a restart, --warm-state-file records the
+          --warm-state-entries most frequently queried names and types on exit. At the next
+          startup they are re-resolved in the background which fills the cache and gives the DoH
+          server selection algorithm its initial latency samples. The file is opened prior to any
+          --chroot or --setuid and is created if it does not exist.
+
           The wildcard interface address and default DNS port are used if no listen addresses are
           specified. Queries are accepted on UDP and TCP.
 
@@ -44,6 +77,49 @@ DESCRIPTION
           selects the "preferred" server based on minimum average latency resulting in most queries
           being directed to the "preferred" server.
 
+          A DoH server can return a SERVFAIL response because of a transient problem with its own
+          upstream resolution. --retry-servfail re-sends such queries up to count more times before
+          the SERVFAIL is returned to the client. Each SERVFAIL counts against the server so a
+          retry is likely to go to a different server. No retries are attempted once
+          --query-timeout has expired.
+
+          --tcp-fastopen enables TCP Fast Open (RFC7413) on connections to the DoH servers. Once a
+          server has issued a Fast Open cookie, new connections carry the TLS ClientHello in the
+          SYN which saves a round trip. This option is currently only effective on Linux and is
+          silently ignored elsewhere or if the kernel does not support it.
+
+          --happy-eyeballs races connections to all the IPv4 and IPv6 addresses of a DoH server in
+          the style of RFC8305. Each address is given a 250ms head start over the next, alternating
+          between address families, and the first connection to succeed is used. This avoids long
+          connection stalls on dual-stack networks where one address family is partially broken.
+          Alternatively, if you know one address family is unusable, --upstream-ipv4-only or
+          --upstream-ipv6-only constrain all connections to DoH servers to the other family.
+
+          If {{.ProxyProgramName}} is the system resolver, it cannot rely on the system resolver to
+          look up the hostnames of the DoH servers. Either list the DoH servers in /etc/hosts or
+          name a plain DNS server with --bootstrap-resolver. The bootstrap resolver is only used to
+          look up DoH server hostnames and never sees client queries.
+
+          To remove any dependency on DNS, --server-ip pins the addresses of a DoH server, e.g.
+          "--server-ip dns.example.net=192.0.2.1". Connections to that hostname are made to the
+          pinned addresses in the order given, with each tried in turn if the previous fails. TLS
+          is still verified against the hostname. Repeat the option to pin multiple addresses.
+
+          A DoH-server-URL of the form unix:///path/to/socket connects to a {{.ServerProgramName}}
+          on the same system which is listening with --unix-socket. Queries are sent as plain HTTP
+          to the socket and appear in reports under a placeholder name such as
+          http://socket1.unix.invalid/dns-query.
+
+          Additional DoH servers can be listed in a --servers-file. This is a JSON array of objects
+          each with a "url" and optional "max-connections", "tls-server-name", "headers" and
+          "weight" settings which apply only to that server. Servers are normally selected by
+          latency and a "weight" biases that selection by dividing the server's average latency by
+          the weight, so a server with a weight of 2 is preferred unless it is more than twice as
+          slow as the fastest unweighted server. E.g.:
+
+              [{"url": "https://doh.example.net/dns-query", "tls-server-name": "doh.example.net",
+                "headers": {"Authorization": "Bearer xyzzy"}, "weight": 2}]
+
 RESOLUTION LOOPS
           Extreme care must be taken when creating a system-wide resolv.conf containing the listen
           address of this program *and* supplying a local resolv.conf to this program for
@@ -90,6 +166,66 @@ INVOCATION
           listen address of {{.ProxyProgramName}} to start reaping the benefits of DoH. In many
           cases this might be via changes to your DHCP server.
 
+          Options can also be supplied in a file named by --config. Each line of the file is of
+          the form "option = value" where option is the name without leading dashes, eg:
+
+              # Example {{.ProxyProgramName}} config file
+              report-format = json
+              log-client-out
+
+          Options can also be supplied by environment variables named {{.EnvironmentPrefix}} followed by
+          the upper-cased option name with dashes replaced by underscores, eg:
+          {{.EnvironmentPrefix}}REPORT_FORMAT=json. The values of repeatable options are separated by
+          commas, eg: {{.EnvironmentPrefix}}A=127.0.0.1,::1. The -h, --version, --version-json and
+          --check options are never taken from the environment.
+
+          Options given on the command line override those in the environment which override
+          those in the file which in turn override the defaults. An option which can be repeated
+          may appear on multiple lines but is ignored entirely if it is set by a higher
+          precedence source.
+
+SIGNALS
+          SIGUSR1 prints a status report without resetting counters. SIGHUP flushes the response
+          cache and re-checks the addresses of any --interface. If --profile-dir is set, SIGUSR2
+          writes a goroutine dump and a heap profile into that directory, otherwise it causes
+          {{.ProxyProgramName}} to exit as do all other signals. The heap profile is for "go tool
+          pprof".
+
+DEBUGGING
+          Queries which take longer than --slow-query-threshold to resolve are printed with an
+          "SQ:" prefix regardless of the --log-* settings. Each line shows the qName, qType,
+          resolution time, the final server used and the listen transport. The number of slow
+          queries is included in the status report.
+
+          --trace generates a random trace ID for each query which is appended as "T:id" to the
+          --log-* and "SQ:" lines for that query. The trace ID is also sent to the DoH server in
+          an X-trustydns-Trace header and {{.ServerProgramName}} includes it in its own logs so
+          that a query can be followed from the client, through the proxy and on to the server.
+          Queries resolved by the local resolver or answered from the cache do not reach a DoH
+          server so their trace ID only appears in {{.ProxyProgramName}} logs.
+
+          --server-alert-threshold sets the proportion of failed requests, between 0 and 1, which
+          causes an "ALERT:" line to be printed regardless of the --log-* settings. The error rate
+          of each DoH server is measured over a sliding --server-alert-window and no alert is
+          raised until the window contains at least 10 requests. An "ALERT CLEARED:" line is
+          printed once the error rate drops back to the threshold. The number of alerts raised for
+          each server is included in the status report.
+
+          --debug-listen starts a separate HTTP listener which serves the Go expvar variables at
+          /debug/vars and the pprof handlers at /debug/pprof/. The status counters appear in
+          /debug/vars as "{{.ProxyProgramName}}". The address must be a loopback address as
+          these handlers reveal a great deal about the running process.
+
+          If --cache-size is set, the --debug-listen listener also accepts a POST or DELETE to
+          /cache/flush which flushes the whole response cache, or only the responses for one name
+          if a "name" query parameter is supplied, eg:
+
+              $ curl -X DELETE 'http://127.0.0.1:6060/cache/flush?name=example.net'
+
+          and a GET of /cache/entries returns a JSON array of the unexpired cache entries showing
+          the key, remaining TTL, rcode, a compact form of the Answer RRs and the number of times
+          the entry has been used.
+
 EDNS0 CLIENT SUBNET (ECS)
           Unfortunately {{.RFC}} is silent on ECS handling yet there are good arguments that ECS
           settings for topologically remote resolution and protecting client IP disclosure are
@@ -161,6 +297,18 @@ ECS CAVEATS
           may be ignored by the DoH server or any DNS infrastructure used by the DoH server to
           resolve the query.
 
+OTHER EDNS0 OPTIONS
+          EDNS0 options other than ECS are normally forwarded to the DoH server unchanged. If
+          --preserve-edns is supplied then only the listed options survive and all others are
+          removed from queries before they are forwarded. This is useful when a security platform
+          identifies devices with its own EDNS0 options and nothing else should leak upstream.
+          Options can be named (COOKIE, KEYTAG, NSID, EXPIRE, TCP-KEEPALIVE, EDE, LLQ, DAU, DHU,
+          N3U) or given as decimal option codes. ECS and padding are never removed by this option
+          as they have their own handling.
+
+          Note that {{.ServerProgramName}} removes EDNS0 options before passing queries to its
+          local resolvers unless they are listed with its --edns-passthrough option.
+
 BEST SERVER
           The 'bestserver' options (all prefixed with --bs-) control the choice of DoH servers
           supplied on the command line. The 'bestserver' algorithm evaluates the DoH servers to
@@ -177,6 +325,11 @@ BEST SERVER
                that a new best server could be chosen if it has exhibited better performance than
                the current best server.
 
+          --bs-decay-half-life duration
+               A server which is rarely sampled may retain a stale latency average. When set, the
+               latency average used to compare servers moves half-way towards the mean of all
+               servers for every 'duration' since the server was last used. Zero disables decay.
+
           --bs-reset-failed-after duration
                When a server is reported as failing it is not considered by the reassesment process
                until after this duration has transpired. The only exception is if all servers are
@@ -189,6 +342,12 @@ BEST SERVER
                returned and thus sampled. For every 'rate' calls to Result() the subsequent call to
                Best() will return a non-best, non-failing server.
 
+          --bs-adaptive-sampling
+               With many servers, a fixed sample rate can leave each server unsampled for a long
+               time. This option increases the sample rate as needed so that every server is
+               sampled at least once per --bs-reassess-after duration based on the request rate
+               of the previous duration. The rate never drops below --bs-sample-others-every.
+
           --bs-weight-for-latest percent
                The percentage weight given to the latency supplied in the Result() call when
                calculating the the current latency average. The closer this number is to 100, the
@@ -198,17 +357,34 @@ BEST SERVER
                latency = 'percent' * Result(Latency) + (100 - 'percent') * latency
 
 OPTIONS
+          [--config path]
           [-ghpv]
-          [-A listen Address[:port] ...] [--tcp] [--udp]
+          [-A listen Address[:port] ...] [--interface name ...] [--systemd]
+          [--reuse-port] [--tcp] [--udp]
 
           [-c resolv.conf path with local domains] [-e localdomain ...]
-          [-i status-report-interval] [-r maximum remote concurrency]
-          [-t remote request timeout]
+          [--type-route qtype=local|remote ...]
+          [-i status-report-interval] [--report-format text|json]
+          [-r maximum remote concurrency]
+          [-t remote request timeout] [--query-timeout duration] [--user-agent string]
+          [--http2-ping-interval duration] [--tcp-fastopen] [--happy-eyeballs]
+          [--upstream-ipv4-only | --upstream-ipv6-only] [--pin-server DoH-server-URL]
+          [--servers-file path] [--default-resolver IP[:port]] [--retry-servfail count]
+          [--server-alert-threshold rate [--server-alert-window duration]]
+          [--bootstrap-resolver IP[:port]] [--server-ip hostname=IP ...]
+          [--cache-size entries [--cache-max-ttl duration] [--cache-max-memory bytes]
+              [--negative-ttl-max duration]]
+          [--warm-state-file file [--warm-state-entries queries]]
+          [--max-udp-size size] [--tcp-keepalive-timeout duration]
+          [--on-failure drop|servfail|refused]
+          [--shuffle-answers] [--filter-a | --filter-aaaa] [--min-answer-ttl duration]
+          [--dns64 [--dns64-prefix NAT64 prefix]]
+          [--rebind-protect off|strip|nxdomain [--rebind-allow domain ...]]
 
           [--bs-reassess-after duration]                       **best server
           [--bs-reassess-count count]                             controls**
-          [--bs-reset-failed-after duration]
-          [--bs-sample-others-every rate]
+          [--bs-reset-failed-after duration] [--bs-decay-half-life duration]
+          [--bs-sample-others-every rate] [--bs-adaptive-sampling]
           [--bs-weight-for-latest percent]
 
           [--ecs-remove]
@@ -220,9 +396,10 @@ OPTIONS
                 [--ecs-set CIDR]
                 [--ecs-redact-response]
             ]
+          [--preserve-edns option ...]
 
           [--log-client-in] [--log-client-out] [--log-tls-errors]
-          [--log-all]
+          [--log-detail] [--log-all] [--slow-query-threshold duration] [--trace]
 
           [--tls-cert TLS Client Certificate file]
           [--tls-key TLS Client Key file]
@@ -230,10 +407,11 @@ OPTIONS
           [--tls-use-system-roots]
 
           [--gops] [--cpu-profile file] [--mem-profile file]
+          [--profile-dir directory] [--debug-listen address]
 
           [--user userName] [--group groupName] [--chroot directory]
 
-          [--version]
+          [--check] [--version] [--version-json]
 
 `
 
@@ -270,9 +448,74 @@ func parseCommandLine(args []string) error {
 	flagSet.StringVar(&cfg.localResolvConf, "c", "",
 		"`path` to resolv.conf with split-horizon domains and local resolver IPs")
 	flagSet.Var(&cfg.localDomains, "e", "A `domain` to consider local along with those in resolv.conf (-c)")
+	flagSet.Var(&cfg.typeRoutes, "type-route", "Route a query type to a resolver with `qtype=local|remote`")
 	flagSet.DurationVar(&cfg.statusInterval, "i", time.Minute*15, "Periodic Status Report `interval`")
+	flagSet.StringVar(&cfg.reportFormat, "report-format", "text", "Status Report `format`: text or json")
 	flagSet.IntVar(&cfg.maximumRemoteConnections, "r", 10, "Maximum `concurrent` connections per DoH server")
 	flagSet.DurationVar(&cfg.requestTimeout, "t", time.Second*15, "Remote request `timeout`")
+	flagSet.DurationVar(&cfg.queryTimeout, "query-timeout", 0,
+		"Abandon queries not resolved within `duration` including any fallback (0 means no limit)")
+	flagSet.StringVar(&cfg.dohConfig.UserAgent, "user-agent", "",
+		"HTTP User-Agent `string` sent to DoH servers (default "+consts.PackageName+"/version)")
+	flagSet.IntVar(&cfg.maxUDPSize, "max-udp-size", 0,
+		"Truncate EDNS0 UDP responses to `size` bytes regardless of client UDP size (512-65535)")
+	flagSet.StringVar(&cfg.pinServer, "pin-server", "",
+		"Send all queries to this DoH server `URL` rather than the best server (diagnostic)")
+	flagSet.Var(&cfg.interfaces, "interface",
+		"Listen on all addresses of interface `name`. Addresses are re-checked on SIGHUP")
+	flagSet.BoolVar(&cfg.reusePort, "reuse-port", false,
+		"Set SO_REUSEPORT on listen sockets so multiple processes can share them")
+	flagSet.BoolVar(&cfg.systemd, "systemd", false,
+		"Serve on sockets passed by systemd socket activation instead of -A addresses")
+	flagSet.StringVar(&cfg.serversFile, "servers-file", "",
+		"JSON `path` listing additional DoH servers with per-server settings")
+	flagSet.Float64Var(&cfg.dohConfig.Alerts.Threshold, "server-alert-threshold", 0,
+		"Print an alert when a DoH server's error `rate` exceeds this fraction (0 disables)")
+	flagSet.DurationVar(&cfg.dohConfig.Alerts.Window, "server-alert-window", doh.DefaultAlertWindow,
+		"Measure the --server-alert-threshold error rate over this sliding `duration`")
+	flagSet.IntVar(&cfg.dohConfig.RetryServFail, "retry-servfail", 0,
+		"Re-send a query up to `count` more times if a DoH server returns SERVFAIL")
+	flagSet.StringVar(&cfg.defaultResolver, "default-resolver", "",
+		"Plain DNS server `IP[:port]` to try when the local or DoH resolver fails")
+	flagSet.StringVar(&cfg.bootstrapResolver, "bootstrap-resolver", "",
+		"Plain DNS server `IP[:port]` used only to resolve DoH server hostnames")
+	flagSet.Var(&cfg.serverIPs, "server-ip",
+		"Connect to DoH server hostname at this IP with `hostname=IP` (can be repeated)")
+	flagSet.IntVar(&cfg.cacheSize, "cache-size", 0, "Cache up to `entries` responses (0 disables the cache)")
+	flagSet.DurationVar(&cfg.cacheMaxTTL, "cache-max-ttl", cache.DefaultMaxTTL,
+		"Never cache a response for longer than `duration` regardless of its TTL")
+	flagSet.IntVar(&cfg.cacheMaxMemory, "cache-max-memory", 0,
+		"Evict least recently used responses when the cache exceeds `bytes` (0 means no limit)")
+	flagSet.DurationVar(&cfg.cacheMaxNegativeTTL, "negative-ttl-max", 0,
+		"Never cache NXDOMAIN or NODATA responses for longer than `duration` (0 means no limit)")
+	flagSet.StringVar(&cfg.warmStateFile, "warm-state-file", "",
+		"Save the most frequent queries to `file` on exit and re-resolve them on startup")
+	flagSet.IntVar(&cfg.warmStateEntries, "warm-state-entries", 100,
+		"Number of `queries` saved in the --warm-state-file")
+	flagSet.DurationVar(&cfg.dohConfig.HTTP2PingInterval, "http2-ping-interval", 0,
+		"Idle `interval` before checking DoH connections with an HTTP/2 PING (0 disables)")
+	flagSet.BoolVar(&cfg.tcpFastOpen, "tcp-fastopen", false,
+		"Use TCP Fast Open on connections to DoH servers (Linux only)")
+	flagSet.BoolVar(&cfg.happyEyeballs, "happy-eyeballs", false,
+		"Race connections to all IPv4 and IPv6 addresses of DoH servers (RFC8305)")
+	flagSet.BoolVar(&cfg.upstreamIPv4Only, "upstream-ipv4-only", false, "Only connect to DoH servers over IPv4")
+	flagSet.BoolVar(&cfg.upstreamIPv6Only, "upstream-ipv6-only", false, "Only connect to DoH servers over IPv6")
+	flagSet.DurationVar(&cfg.tcpKeepaliveTimeout, "tcp-keepalive-timeout", 0,
+		"Idle `timeout` for TCP clients advertised with EDNS0 TCP Keepalive (RFC7828)")
+	flagSet.StringVar(&cfg.onFailure, "on-failure", "drop",
+		"`Action` when resolution fails: drop, servfail or refused")
+	flagSet.BoolVar(&cfg.filterA, "filter-a", false, "Remove A RRs from the Answer section of responses")
+	flagSet.BoolVar(&cfg.filterAAAA, "filter-aaaa", false, "Remove AAAA RRs from the Answer section of responses")
+	flagSet.BoolVar(&cfg.dns64, "dns64", false, "Synthesize AAAA RRs from A RRs for NAT64 clients (RFC6147)")
+	flagSet.StringVar(&cfg.dns64Prefix, "dns64-prefix", "64:ff9b::/96", "NAT64 `prefix` used by --dns64")
+	flagSet.StringVar(&cfg.rebindProtect, "rebind-protect", "off",
+		"Remove private, loopback and link-local A/AAAA RRs from non-local responses: off, strip or nxdomain")
+	flagSet.Var(&cfg.rebindAllow, "rebind-allow",
+		"`domain` exempt from --rebind-protect for split-horizon names (can be repeated)")
+	flagSet.BoolVar(&cfg.shuffleAnswers, "shuffle-answers", false,
+		"Randomly reorder RRs within each Answer RRset (not applied to AD=1 responses)")
+	flagSet.DurationVar(&cfg.minAnswerTTL, "min-answer-ttl", 0,
+		"Raise Answer TTLs below `duration` before returning responses to clients (0 means no floor)")
 
 	// bestserver options
 
@@ -282,12 +525,16 @@ func parseCommandLine(args []string) error {
 	flagSet.IntVar(&cfg.dohConfig.LatencyConfig.ReassessCount, "bs-reassess-count",
 		bestserver.DefaultLatencyConfig.ReassessCount,
 		"Reassess after `count` requests")
+	flagSet.DurationVar(&cfg.dohConfig.LatencyConfig.DecayHalfLife, "bs-decay-half-life", 0,
+		"Decay idle server latency towards the mean with this half-life `duration`")
 	flagSet.DurationVar(&cfg.dohConfig.LatencyConfig.ResetFailedAfter, "bs-reset-failed-after",
 		bestserver.DefaultLatencyConfig.ResetFailedAfter,
 		"Reset failed servers to initial state after this `duration`")
 	flagSet.IntVar(&cfg.dohConfig.LatencyConfig.SampleOthersEvery, "bs-sample-others-every",
 		bestserver.DefaultLatencyConfig.SampleOthersEvery,
 		"Try other servers every `sample` Result() calls")
+	flagSet.BoolVar(&cfg.dohConfig.LatencyConfig.AdaptiveSampling, "bs-adaptive-sampling", false,
+		"Sample every server at least once per reassess duration")
 	flagSet.IntVar(&cfg.dohConfig.LatencyConfig.WeightForLatest, "bs-weight-for-latest",
 		bestserver.DefaultLatencyConfig.WeightForLatest,
 		"Weight Result(Latency) by `percent`")
@@ -302,11 +549,19 @@ func parseCommandLine(args []string) error {
 	flagSet.IntVar(&cfg.dohConfig.ECSRequestIPv6PrefixLen, "ecs-request-ipv6-prefixlen", 0,
 		"Server-side IPv6 ECS synthesis `Prefix-Length` (normally 64 when used)")
 	flagSet.StringVar(&cfg.ecsSet, "ecs-set", "", "`CIDR` to set ECS IP Address and Prefix Length")
+	flagSet.Var(&cfg.preserveEDNS, "preserve-edns",
+		"Only forward this EDNS0 `option` (name or code) and remove all others (can be repeated)")
 
 	flagSet.BoolVar(&cfg.logAll, "log-all", false, "Turns on all other --log-* options")
+	flagSet.BoolVar(&cfg.logDetail, "log-detail", false,
+		"Append flags, rcode, EDNS0 UDP size and answer count to compact DNS prints")
 	flagSet.BoolVar(&cfg.logClientIn, "log-client-in", false, "Compact print of query arriving from client")
 	flagSet.BoolVar(&cfg.logClientOut, "log-client-out", false, "Compact print of response returned to client")
 	flagSet.BoolVar(&cfg.logTLSErrors, "log-tls-errors", false, "Print crypto/x509 errors from HTTPS request")
+	flagSet.DurationVar(&cfg.slowQueryThreshold, "slow-query-threshold", 0,
+		"Print queries which take longer than `duration` to resolve (0 means off)")
+	flagSet.BoolVar(&cfg.trace, "trace", false,
+		"Send a trace ID to the DoH server with each query and include it in logs")
 
 	// TLS
 
@@ -321,6 +576,10 @@ func parseCommandLine(args []string) error {
 	flagSet.BoolVar(&cfg.gops, "gops", false, "Start github.com/google/gops agent")
 	flagSet.StringVar(&cfg.cpuprofile, "cpu-profile", "", "write cpu profile to `file`")
 	flagSet.StringVar(&cfg.memprofile, "mem-profile", "", "write mem profile to `file`")
+	flagSet.StringVar(&cfg.profileDir, "profile-dir", "",
+		"SIGUSR2 writes goroutine and heap profiles to `directory`")
+	flagSet.StringVar(&cfg.debugListen, "debug-listen", "",
+		"Serve /debug/vars (expvar) and /debug/pprof on loopback `address`")
 
 	// Process Constraint parameters
 
@@ -328,7 +587,28 @@ func parseCommandLine(args []string) error {
 	flagSet.StringVar(&cfg.setgidName, "group", "", "setgid `groupname` to constrain process after start-up (disabled for Linux)")
 	flagSet.StringVar(&cfg.chrootDir, "chroot", "", "chroot `directory` to constrain process after start-up")
 
+	flagSet.BoolVar(&cfg.check, "check", false,
+		"Validate options and configuration files then exit without serving")
 	flagSet.BoolVar(&cfg.version, "version", false, "Print version and exit")
+	flagSet.BoolVar(&cfg.versionJSON, "version-json", false, "Print version and build details as JSON and exit")
+
+	flagSet.StringVar(&cfg.configFile, flagutil.ConfigFileFlag, "", "Read additional options from config file `path`")
+
+	err := flagSet.Parse(args[1:])
+	if err != nil {
+		return err
+	}
+	err = flagutil.LoadEnvironment(flagSet, consts.EnvironmentPrefix)
+	if err != nil {
+		fmt.Fprintln(flagSet.Output(), err)
+		return err
+	}
+	if len(cfg.configFile) > 0 {
+		err = flagutil.LoadConfigFile(flagSet, cfg.configFile)
+		if err != nil {
+			fmt.Fprintln(flagSet.Output(), err) // Callers assume errors are already printed
+		}
+	}
 
-	return flagSet.Parse(args[1:])
+	return err
 }
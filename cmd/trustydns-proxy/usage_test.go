@@ -18,12 +18,102 @@ type usageTestCase struct {
 
 var usageTestCases = []usageTestCase{
 	{false, []string{"--version"}, []string{"trustydns-proxy", "Version:"}, ""},
+	{false, []string{"--version-json"}, []string{`"program":"trustydns-proxy"`, `"version":"v`, `"go_version":"go`}, ""},
 	{false, []string{"-h"}, []string{"NAME", "SYNOPSIS", "OPTIONS", "Version: v"}, ""},
 	{false, []string{}, []string{}, "Fatal: trustydns-proxy: Must supply at least one DoH server URL on the command line"},
 	{false, []string{"-badopt"}, []string{}, "flag provided but not defined"},
 	{false, []string{"-v", "-A", "255.254.253.252", "http://localhost:63080"}, []string{"Starting:"},
 		"assign requested address"},
 
+	// --check validates without binding so an unassignable address is not an error
+	{false, []string{"--check", "-A", "255.254.253.252", "http://localhost:63080"},
+		[]string{"Configuration OK"}, ""},
+	{false, []string{"--check", "--servers-file", "testdata/servers.json"}, []string{"Configuration OK"}, ""},
+	{false, []string{"--check", "-r", "0", "http://localhost:63080"}, []string{},
+		"Minimum remote concurrency"},
+
+	// --interface
+	{false, []string{"--interface", "nosuchinterface0", "http://localhost:63080"}, []string{},
+		"nosuchinterface0"},
+
+	// --reuse-port is supported on the platforms we test on
+	{false, []string{"--check", "--reuse-port", "http://localhost:63080"}, []string{"Configuration OK"}, ""},
+	{false, []string{"--check", "--tcp-fastopen", "http://localhost:63080"}, []string{"Configuration OK"}, ""},
+	{false, []string{"--check", "--happy-eyeballs", "http://localhost:63080"}, []string{"Configuration OK"}, ""},
+
+	// --systemd
+	{false, []string{"--systemd", "-A", "127.0.0.1", "http://localhost:63080"}, []string{},
+		"Cannot have --systemd with -A"},
+	{false, []string{"--systemd", "http://localhost:63080"}, []string{}, "no sockets were passed"},
+
+	// --servers-file
+	{false, []string{"--servers-file", "testdata/servers-unknown.json"}, []string{}, "unknown field"},
+	{false, []string{"--servers-file", "testdata/nosuchfile.json"}, []string{}, "--servers-file"},
+
+	// --type-route
+	{false, []string{"--type-route", "PTR=local", "http://localhost:63080"}, []string{},
+		"without a resolv.conf"},
+	{false, []string{"--type-route", "PTR", "http://localhost:63080"}, []string{}, "not of the form"},
+	{false, []string{"--check", "--type-route", "PTR=remote", "http://localhost:63080"},
+		[]string{"Configuration OK"}, ""},
+
+	// --default-resolver
+	{false, []string{"--check", "--default-resolver", "127.0.0.1:5353", "http://localhost:63080"},
+		[]string{"Configuration OK"}, ""},
+	{false, []string{"--default-resolver", "resolver.example.net", "http://localhost:63080"}, []string{},
+		"not an IP address"},
+
+	// --bootstrap-resolver
+	{false, []string{"--check", "--bootstrap-resolver", "127.0.0.1", "http://localhost:63080"},
+		[]string{"Configuration OK"}, ""},
+	{false, []string{"--bootstrap-resolver", "resolver.example.net:53", "http://localhost:63080"}, []string{},
+		"not an IP address"},
+
+	// --preserve-edns
+	{false, []string{"--check", "--preserve-edns", "COOKIE", "--preserve-edns", "65001", "http://localhost:63080"},
+		[]string{"Configuration OK"}, ""},
+	{false, []string{"--preserve-edns", "NOTANOPTION", "http://localhost:63080"}, []string{}, "--preserve-edns"},
+
+	// --warm-state-file
+	{false, []string{"--warm-state-file", "/tmp/warm.state", "--warm-state-entries", "0", "http://localhost:63080"},
+		[]string{}, "--warm-state-entries 0 must be greater than zero"},
+
+	// --trace
+	{false, []string{"--check", "--trace", "http://localhost:63080"}, []string{"Configuration OK"}, ""},
+
+	// --server-ip
+	{false, []string{"--check", "--server-ip", "localhost=127.0.0.1", "--server-ip", "localhost=::1",
+		"http://localhost:63080"}, []string{"Configuration OK"}, ""},
+	{false, []string{"--server-ip", "localhost", "http://localhost:63080"}, []string{}, "not of the form"},
+
+	// --query-timeout
+	{false, []string{"--check", "--query-timeout", "3s", "http://localhost:63080"},
+		[]string{"Configuration OK"}, ""},
+	{false, []string{"--query-timeout", "-1s", "http://localhost:63080"}, []string{}, "cannot be negative"},
+	{false, []string{"--slow-query-threshold", "-1s", "http://localhost:63080"}, []string{}, "cannot be negative"},
+	{false, []string{"--check", "--server-alert-threshold", "0.5", "--server-alert-window", "30s", "http://localhost:63080"},
+		[]string{"Configuration OK"}, ""},
+	{false, []string{"--server-alert-threshold", "1.5", "http://localhost:63080"}, []string{}, "must be between 0 and 1"},
+	{false, []string{"--server-alert-threshold", "-0.1", "http://localhost:63080"}, []string{}, "must be between 0 and 1"},
+	{false, []string{"--server-alert-window", "0s", "http://localhost:63080"}, []string{}, "must be greater than zero"},
+	{false, []string{"--check", "--retry-servfail", "2", "http://localhost:63080"}, []string{"Configuration OK"}, ""},
+	{false, []string{"--retry-servfail", "-1", "http://localhost:63080"}, []string{}, "cannot be negative"},
+
+	// --cache-size
+	{false, []string{"--check", "--cache-size", "100", "http://localhost:63080"},
+		[]string{"Configuration OK"}, ""},
+	{false, []string{"--cache-size", "-1", "http://localhost:63080"}, []string{}, "cannot be negative"},
+	{false, []string{"--cache-size", "100", "--cache-max-ttl", "0s", "http://localhost:63080"}, []string{},
+		"must be greater than zero"},
+	{false, []string{"--check", "--cache-size", "100", "--cache-max-memory", "65536", "http://localhost:63080"},
+		[]string{"Configuration OK"}, ""},
+	{false, []string{"--check", "--cache-size", "100", "--negative-ttl-max", "30s", "http://localhost:63080"},
+		[]string{"Configuration OK"}, ""},
+	{false, []string{"--cache-size", "100", "--negative-ttl-max", "-1s", "http://localhost:63080"}, []string{},
+		"cannot be negative"},
+	{false, []string{"--cache-size", "100", "--cache-max-memory", "-1", "http://localhost:63080"}, []string{},
+		"cannot be negative"},
+
 	// -e local domains without resolv.conf
 	{false, []string{"-e", "example.net", "http://localhost"}, []string{}, "Local Domains"},
 
@@ -46,12 +136,53 @@ var usageTestCases = []usageTestCase{
 	{false, []string{"--udp=false", "--tcp=false", "http://localhost:63080"}, []string{},
 		"Must have one of"},
 
+	// Truncation override
+	{false, []string{"--max-udp-size", "100", "http://localhost:63080"}, []string{}, "must be between 512 and 65535"},
+
+	{false, []string{"--tcp-keepalive-timeout", "-1s", "http://localhost:63080"}, []string{}, "cannot be negative"},
+	{false, []string{"--check", "--min-answer-ttl", "30s", "http://localhost:63080"}, []string{"Configuration OK"}, ""},
+	{false, []string{"--min-answer-ttl", "-1s", "http://localhost:63080"}, []string{}, "cannot be negative"},
+
+	{false, []string{"--pin-server", "http://localhost:63081", "http://localhost:63080"}, []string{},
+		"Cannot pin to unknown server"},
+	{false, []string{"--http2-ping-interval", "-1s", "http://localhost:63080"}, []string{}, "cannot be negative"},
+	{false, []string{"--report-format", "xml", "http://localhost:63080"}, []string{}, "must be one of text or json"},
+	{false, []string{"--debug-listen", "0.0.0.0:6060", "http://localhost:63080"}, []string{},
+		"is not a loopback address"},
+	{false, []string{"--on-failure", "ignore", "http://localhost:63080"}, []string{}, "must be one of drop"},
+
+	// Address filtering
+	{false, []string{"--filter-a", "--filter-aaaa", "http://localhost:63080"}, []string{}, "Cannot have both --filter-a"},
+	{false, []string{"--upstream-ipv4-only", "--upstream-ipv6-only", "http://localhost:63080"}, []string{},
+		"Cannot have both --upstream-ipv4-only"},
+
+	// DNS64
+	{false, []string{"--dns64", "--dns64-prefix", "10.0.0.0/8", "http://localhost:63080"}, []string{}, "must be an IPv6"},
+	{false, []string{"--dns64", "--dns64-prefix", "2001:db8::/80", "http://localhost:63080"}, []string{}, "prefix length"},
+	{false, []string{"--dns64", "--filter-aaaa", "http://localhost:63080"}, []string{}, "Cannot have both --dns64"},
+
+	// DNS rebinding protection
+	{false, []string{"--rebind-protect", "drop", "http://localhost:63080"}, []string{}, "must be one of off, strip"},
+
+	{false, []string{"--check", "--config", "testdata/config.conf", "http://localhost:63080"},
+		[]string{"Configuration OK"}, ""},
+	{false, []string{"--config", "testdata/config.conf", "--rebind-protect", "drop", "http://localhost:63080"},
+		[]string{}, "must be one of off, strip"}, // Command line wins
+	{false, []string{"--config", "testdata/config-bad.conf"}, []string{}, "config-bad.conf:1: 'no-such-option'"},
+	{false, []string{"--config", "testdata/no-such-file.conf"}, []string{}, "no such file"},
+	{false, []string{"--rebind-allow", "lan", "http://localhost:63080"}, []string{}, "requires --rebind-protect"},
+	{false, []string{"--rebind-protect", "strip", "--rebind-allow", "a..b", "http://localhost:63080"}, []string{},
+		"Invalid domain name"},
+
 	// ECS with GET
 	{false, []string{"-g", "--ecs-set", "10.0.120.0/24", "http://localhost:63080"}, []string{}, "any ECS synthesis"},
 
 	// Test URL mangling code paths
 	{false, []string{"http://"}, []string{}, "does not contain a hostname"},
 	{false, []string{"://localhost/xxx"}, []string{}, "missing protocol scheme"},
+	{false, []string{"--check", "unix:///tmp/trustydns.sock"}, []string{"Configuration OK"}, ""},
+	{false, []string{"unix://localhost/tmp/trustydns.sock"}, []string{}, "not of the form unix:///path"},
+	{false, []string{"unix://"}, []string{}, "not of the form unix:///path"},
 
 	// Bad options
 	{false, []string{"-t", "xxs", "http://localhost"}, []string{}, "invalid value"},
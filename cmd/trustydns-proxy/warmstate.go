@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/markdingo/trustydns/internal/resolver"
+
+	"github.com/miekg/dns"
+)
+
+// warmStateKeysFactor bounds the number of distinct names tracked by a warmState to this multiple
+// of the number written to the --warm-state-file. This is enough to find the most frequent names
+// without letting a client querying random names grow the map without limit.
+const warmStateKeysFactor = 10
+
+// warmEntry is a single qName/qType counted by a warmState or loaded from the --warm-state-file
+type warmEntry struct {
+	qName string
+	qType uint16
+}
+
+// warmState counts queries by qName/qType so that the most frequent can be written to the
+// --warm-state-file on shutdown and re-resolved at the next startup. This warms the cache and
+// gives the bestserver algorithm latency samples before clients start asking.
+//
+// The file is opened at startup and held open so that it can still be re-written on shutdown
+// after a chroot or setuid.
+type warmState struct {
+	file    *os.File
+	entries int // Number of entries written on shutdown
+
+	mu     sync.Mutex // Protects everything below here
+	counts map[warmEntry]int
+}
+
+// openWarmState opens or creates the --warm-state-file and returns the entries it contains. A
+// newly created file is empty so there is nothing to warm on the very first run.
+func openWarmState(path string, entries int) (*warmState, []warmEntry, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	we, err := readWarmEntries(f, path)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	return &warmState{file: f, entries: entries, counts: make(map[warmEntry]int)}, we, nil
+}
+
+// readWarmEntries parses "qname qtype" lines. Blank lines and lines starting with '#' are ignored.
+func readWarmEntries(rd io.Reader, path string) ([]warmEntry, error) {
+	var entries []warmEntry
+	scanner := bufio.NewScanner(rd)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: Expected qname qtype", path, lineNo)
+		}
+		we := warmEntry{qName: dns.Fqdn(fields[0])}
+		if _, ok := dns.IsDomainName(we.qName); !ok {
+			return nil, fmt.Errorf("%s:%d: Invalid qname %s", path, lineNo, fields[0])
+		}
+		qt, ok := dns.StringToType[strings.ToUpper(fields[1])]
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: Unknown qtype %s", path, lineNo, fields[1])
+		}
+		we.qType = qt
+		entries = append(entries, we)
+	}
+
+	return entries, scanner.Err()
+}
+
+// add counts one query. Once the map is full the counts are halved to make room which also means
+// that recent queries gradually outweigh those from long ago. If halving frees no room the new
+// name is simply not counted.
+func (t *warmState) add(q dns.Question) {
+	if q.Qclass != dns.ClassINET {
+		return
+	}
+	we := warmEntry{qName: strings.ToLower(q.Name), qType: q.Qtype}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.counts[we]; !ok && len(t.counts) >= t.entries*warmStateKeysFactor {
+		for k, v := range t.counts {
+			if v <= 1 {
+				delete(t.counts, k)
+			} else {
+				t.counts[k] = v / 2
+			}
+		}
+		if len(t.counts) >= t.entries*warmStateKeysFactor {
+			return
+		}
+	}
+	t.counts[we]++
+}
+
+// top returns up to n of the most frequently queried entries, most frequent first.
+func (t *warmState) top(n int) []warmEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entries := make([]warmEntry, 0, len(t.counts))
+	for we := range t.counts {
+		entries = append(entries, we)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		ci, cj := t.counts[entries[i]], t.counts[entries[j]]
+		if ci != cj {
+			return ci > cj
+		}
+		if entries[i].qName != entries[j].qName {
+			return entries[i].qName < entries[j].qName
+		}
+		return entries[i].qType < entries[j].qType
+	})
+	if len(entries) > n {
+		entries = entries[:n]
+	}
+
+	return entries
+}
+
+// save replaces the contents of the --warm-state-file with the most frequent entries and closes it.
+func (t *warmState) save() error {
+	defer t.file.Close()
+
+	if err := t.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := t.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	w := bufio.NewWriter(t.file)
+	fmt.Fprintln(w, "# Most frequent queries written by", consts.ProxyProgramName, "on shutdown")
+	for _, we := range t.top(t.entries) {
+		fmt.Fprintln(w, we.qName, dns.Type(we.qType).String())
+	}
+
+	return w.Flush()
+}
+
+// warmResults are the outcome of warming
+type warmResults struct {
+	warmed, failed int
+}
+
+// warm resolves each entry with the same resolver ServeDNS would choose. It stops early if done is
+// closed.
+func warm(entries []warmEntry, rs *resources, done chan struct{}) warmResults {
+	var wr warmResults
+	for _, we := range entries {
+		select {
+		case <-done:
+			return wr
+		default:
+		}
+		r := rs.remoteResolver
+		if rs.localResolver != nil {
+			route, routed := rs.typeRoutes[we.qType]
+			if route == routeLocal || (!routed && rs.localResolver.InBailiwick(we.qName)) {
+				r = rs.localResolver
+			}
+		}
+		q := &dns.Msg{}
+		q.SetQuestion(we.qName, we.qType)
+		_, _, err := r.Resolve(q, &resolver.QueryMetaData{})
+		if err == nil {
+			wr.warmed++
+		} else {
+			wr.failed++
+		}
+	}
+
+	return wr
+}
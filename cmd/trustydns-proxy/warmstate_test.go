@@ -0,0 +1,154 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestWarmStateTop(t *testing.T) {
+	ws := &warmState{entries: 2, counts: make(map[warmEntry]int)}
+	for ix := 0; ix < 3; ix++ {
+		ws.add(dns.Question{Name: "Example.COM.", Qtype: dns.TypeA, Qclass: dns.ClassINET})
+	}
+	ws.add(dns.Question{Name: "example.net.", Qtype: dns.TypeMX, Qclass: dns.ClassINET})
+	ws.add(dns.Question{Name: "example.org.", Qtype: dns.TypeA, Qclass: dns.ClassINET})
+	ws.add(dns.Question{Name: "example.org.", Qtype: dns.TypeA, Qclass: dns.ClassINET})
+	ws.add(dns.Question{Name: "version.bind.", Qtype: dns.TypeTXT, Qclass: dns.ClassCHAOS}) // Ignored
+
+	top := ws.top(2)
+	if len(top) != 2 || top[0] != (warmEntry{"example.com.", dns.TypeA}) ||
+		top[1] != (warmEntry{"example.org.", dns.TypeA}) {
+		t.Error("Unexpected top entries", top)
+	}
+	if len(ws.top(10)) != 3 {
+		t.Error("Expected all three entries", ws.top(10))
+	}
+}
+
+// Once the map is full, counts are halved to make room for new names
+func TestWarmStateAging(t *testing.T) {
+	ws := &warmState{entries: 1, counts: make(map[warmEntry]int)}
+	ws.add(dns.Question{Name: "frequent.", Qtype: dns.TypeA, Qclass: dns.ClassINET})
+	ws.add(dns.Question{Name: "frequent.", Qtype: dns.TypeA, Qclass: dns.ClassINET})
+	for ix := 0; ix < warmStateKeysFactor-1; ix++ {
+		ws.add(dns.Question{Name: "rare.", Qtype: uint16(100 + ix), Qclass: dns.ClassINET})
+	}
+	if len(ws.counts) != warmStateKeysFactor {
+		t.Fatal("Map should be full", len(ws.counts))
+	}
+	ws.add(dns.Question{Name: "new.", Qtype: dns.TypeA, Qclass: dns.ClassINET})
+	if len(ws.counts) != 2 {
+		t.Error("Expected the singletons to be aged out leaving frequent and new", ws.counts)
+	}
+	if ws.counts[warmEntry{"frequent.", dns.TypeA}] != 1 || ws.counts[warmEntry{"new.", dns.TypeA}] != 1 {
+		t.Error("Counts not halved or new entry not added", ws.counts)
+	}
+}
+
+func TestWarmStateFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "warm.state")
+	ws, entries, err := openWarmState(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Error("New file should have no entries", entries)
+	}
+	ws.add(dns.Question{Name: "example.com.", Qtype: dns.TypeAAAA, Qclass: dns.ClassINET})
+	ws.add(dns.Question{Name: "example.net.", Qtype: dns.TypeA, Qclass: dns.ClassINET})
+	ws.add(dns.Question{Name: "example.net.", Qtype: dns.TypeA, Qclass: dns.ClassINET})
+	ws.add(dns.Question{Name: "example.org.", Qtype: dns.TypeA, Qclass: dns.ClassINET})
+	if err := ws.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, entries, err = openWarmState(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0] != (warmEntry{"example.net.", dns.TypeA}) ||
+		entries[1] != (warmEntry{"example.com.", dns.TypeAAAA}) {
+		t.Error("Saved entries not reloaded in order", entries)
+	}
+
+	// A save with fewer entries must not leave residue from the previous contents
+
+	ws, _, _ = openWarmState(path, 2)
+	ws.save()
+	b, _ := ioutil.ReadFile(path)
+	if strings.Contains(string(b), "example") {
+		t.Error("Previous contents not truncated", string(b))
+	}
+}
+
+func TestWarmStateFileErrors(t *testing.T) {
+	dir := t.TempDir()
+	testCases := []struct {
+		contents string
+		err      string
+	}{
+		{"example.com.\n", "Expected qname qtype"},
+		{"example.com. A extra\n", "Expected qname qtype"},
+		{"# comment\n\nexample..com A\n", ":3: Invalid qname"},
+		{"example.com BOGUS\n", "Unknown qtype"},
+	}
+	for ix, tc := range testCases {
+		path := filepath.Join(dir, "warm"+string(rune('a'+ix)))
+		ioutil.WriteFile(path, []byte(tc.contents), 0644)
+		_, _, err := openWarmState(path, 1)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Error(tc.contents, "Expected error with", tc.err, "got", err)
+		}
+	}
+
+	_, _, err := openWarmState(filepath.Join(dir, "nosuchdir", "warm"), 1)
+	if !os.IsNotExist(err) {
+		t.Error("Expected not exist error, got", err)
+	}
+}
+
+func TestWarm(t *testing.T) {
+	mainInit(&mutexBytesBuffer{}, &mutexBytesBuffer{})
+	remote := &traceResolver{}
+	local := &mockResolver{ib: true}
+	rs := &resources{remoteResolver: remote, localResolver: local}
+	entries := []warmEntry{{"example.com.", dns.TypeA}, {"example.net.", dns.TypeA}}
+	wr := warm(entries, rs, make(chan struct{}))
+	if wr.warmed != 2 || wr.failed != 0 {
+		t.Error("Expected two warmed", wr)
+	}
+
+	local.ib = false
+	local.err = nil
+	remote.err = os.ErrDeadlineExceeded
+	wr = warm(entries, rs, make(chan struct{}))
+	if wr.failed != 2 {
+		t.Error("Expected two failures from the remote resolver", wr)
+	}
+
+	done := make(chan struct{})
+	close(done)
+	wr = warm(entries, rs, done)
+	if wr.warmed+wr.failed != 0 {
+		t.Error("Warming should stop once done is closed", wr)
+	}
+}
+
+// ServeDNS feeds queries to the warmRecorder
+func TestServerWarmRecorder(t *testing.T) {
+	mainInit(&mutexBytesBuffer{}, &mutexBytesBuffer{})
+	warmRecorder = &warmState{entries: 10, counts: make(map[warmEntry]int)}
+	s := &server{stdout: &mutexBytesBuffer{}, remote: &mockResolver{}}
+	q := &dns.Msg{}
+	q.SetQuestion("example.com.", dns.TypeMX)
+	s.ServeDNS(&mockResponseWriter{}, q)
+	s.ServeDNS(&mockResponseWriter{}, q)
+	if warmRecorder.counts[warmEntry{"example.com.", dns.TypeMX}] != 2 {
+		t.Error("Queries not recorded", warmRecorder.counts)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/markdingo/trustydns/internal/connectiontracker"
+	"github.com/markdingo/trustydns/internal/constants"
+	"github.com/markdingo/trustydns/internal/reporter"
+	"github.com/markdingo/trustydns/internal/resolver/local"
+
+	"github.com/miekg/dns"
+)
+
+// Feed genuine status report lines through tools/tdt-analyze-serverlog to make sure its patterns
+// track the report format.
+func TestAnalyzeServerLog(t *testing.T) {
+	perl, err := exec.LookPath("perl")
+	if err != nil {
+		t.Skip("perl not available to run tools/tdt-analyze-serverlog")
+	}
+	out := &mutexBytesBuffer{}
+	mainInit(out, &mutexBytesBuffer{})
+	consts := constants.Get()
+	lr, err := local.New(local.Config{ResolvConfPath: "testdata/resolv.conf"})
+	if err != nil {
+		t.Fatal("Setup error", err)
+	}
+	s := &server{stdout: stdout, listenAddress: "127.0.0.1:1443"}
+	s.connTrk = connectiontracker.New(s.listenName())
+	var evs events
+	evs[evGet] = true
+	s.addSuccessStats(time.Second, 100, evs)
+	s.addFailureStats(serBadMethod, evs)
+	q := &dns.Msg{}
+	q.SetQuestion("example.net.", dns.TypeA)
+	s.addQueryTypeStats(q)
+	s.addRcodeStats(dns.RcodeSuccess)
+
+	fmt.Fprintln(out, consts.ServerProgramName, consts.Version, "Starting")
+	statusReport("Status", false, []reporter.Reporter{s, s.connTrk, lr})
+
+	cmd := exec.Command(perl, "../../tools/tdt-analyze-serverlog")
+	cmd.Stdin = strings.NewReader(out.String())
+	var analysis, warnings bytes.Buffer
+	cmd.Stdout = &analysis
+	cmd.Stderr = &warnings
+	if err := cmd.Run(); err != nil {
+		t.Fatal("tdt-analyze-serverlog failed", err, warnings.String())
+	}
+	if w := warnings.String(); strings.Contains(w, "Warning @") || strings.Contains(w, "uninitialized") {
+		t.Error("tdt-analyze-serverlog warned about the report\n", w, out.String())
+	}
+	// Listener, Conn Track, local Totals and one Server line for each of the four nameservers
+	if !strings.Contains(analysis.String(), "Analyzed: 7,") {
+		t.Error("tdt-analyze-serverlog did not analyze all status lines\n", analysis.String(), out.String())
+	}
+}
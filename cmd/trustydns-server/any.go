@@ -0,0 +1,45 @@
+package main
+
+import (
+	"github.com/markdingo/trustydns/internal/dnsutil"
+
+	"github.com/miekg/dns"
+)
+
+// --any-policy values
+const (
+	anyPolicyForward = "forward" // Pass ANY queries to the local resolvers as for any other qtype
+	anyPolicyHINFO   = "hinfo"   // Answer with a synthesized HINFO as per rfc8482 Section 4.2
+	anyPolicyRefuse  = "refuse"  // Answer with REFUSED
+)
+
+const anyHINFOTTL = 3600 // rfc8482 suggests a TTL long enough to discourage repeated queries
+
+// anyResponse returns a response to a qtype ANY query according to --any-policy or nil if the
+// query should be resolved normally, either because it is not an ANY query or because the policy
+// is to forward them.
+func anyResponse(dnsQ *dns.Msg) *dns.Msg {
+	if len(dnsQ.Question) != 1 || dnsQ.Question[0].Qtype != dns.TypeANY {
+		return nil
+	}
+
+	q := dnsQ.Question[0]
+	dnsR := &dns.Msg{}
+	switch cfg.anyPolicy {
+	case anyPolicyRefuse:
+		dnsR.SetRcode(dnsQ, dns.RcodeRefused)
+	case anyPolicyHINFO:
+		dnsR.SetReply(dnsQ)
+		dnsR.RecursionAvailable = true
+		dnsR.Answer = append(dnsR.Answer, &dns.HINFO{
+			Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeHINFO, Class: q.Qclass, Ttl: anyHINFOTTL},
+			Cpu: "RFC8482"})
+	default:
+		return nil
+	}
+	if dnsQ.IsEdns0() != nil {
+		dnsR.Extra = append(dnsR.Extra, dnsutil.NewOPT())
+	}
+
+	return dnsR
+}
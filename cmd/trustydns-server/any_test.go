@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestAnyResponse(t *testing.T) {
+	mainInit(os.Stdout, os.Stderr)
+
+	q := &dns.Msg{}
+	q.SetQuestion("example.net.", dns.TypeANY)
+
+	if r := anyResponse(q); r != nil { // Default is to forward
+		t.Error("Expected ANY to be forwarded by default, got", r)
+	}
+
+	cfg.anyPolicy = anyPolicyRefuse
+	r := anyResponse(q)
+	if r == nil || r.Rcode != dns.RcodeRefused || len(r.Answer) != 0 {
+		t.Error("Expected REFUSED, got", r)
+	}
+
+	cfg.anyPolicy = anyPolicyHINFO
+	q.SetEdns0(1232, false)
+	r = anyResponse(q)
+	if r == nil || r.Rcode != dns.RcodeSuccess || len(r.Answer) != 1 {
+		t.Fatal("Expected a single HINFO answer, got", r)
+	}
+	hinfo, ok := r.Answer[0].(*dns.HINFO)
+	if !ok || hinfo.Cpu != "RFC8482" || hinfo.Hdr.Name != "example.net." || hinfo.Hdr.Class != dns.ClassINET {
+		t.Error("Expected rfc8482 HINFO, got", r.Answer[0])
+	}
+	if r.IsEdns0() == nil {
+		t.Error("Expected OPT in response to query with OPT")
+	}
+
+	q.SetQuestion("example.net.", dns.TypeA)
+	if r := anyResponse(q); r != nil {
+		t.Error("Non-ANY query should not be answered", r)
+	}
+}
+
+// Test that serveDoH answers ANY queries without involving the local resolver
+func TestServeDoHAny(t *testing.T) {
+	mainInit(os.Stdout, os.Stderr)
+	cfg.anyPolicy = anyPolicyHINFO
+	res := &mockResolver{err: errors.New("Local resolver should not be called")}
+	s := &server{stdout: stdout, local: res}
+	mw := newMockResponseWriter()
+
+	q := &dns.Msg{}
+	q.SetQuestion("example.net.", dns.TypeANY)
+	binary, err := q.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := http.NewRequest("POST", "http://localhost", bytes.NewReader(binary))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "application/dns-message")
+	s.serveDoH(mw, r)
+
+	if mw.statusCode != 0 {
+		t.Fatal("Request failed", mw.statusCode, mw.String())
+	}
+	if len(res.query.Question) != 0 {
+		t.Error("ANY query passed to local resolver", res.query.String())
+	}
+	resp := &dns.Msg{}
+	if err := resp.Unpack(mw.writeBuffer); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Answer) != 1 || resp.Id != q.Id {
+		t.Error("Expected HINFO answer with original Id", resp.String())
+	}
+	if s.successCount != 1 || s.eventCounters[evAny] != 1 || s.rcodes.Total() != 1 {
+		t.Error("ANY answer not counted", s.stats)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+
+	"github.com/markdingo/trustydns/internal/resolver"
+
+	"github.com/miekg/dns"
+)
+
+// localChain is a prioritized list of local resolvers built from -c and --resolv-conf. A query is
+// resolved by the first resolver which has the qName in its bailiwick. Queries outside every
+// bailiwick go to the first resolver, which is the -c resolv.conf.
+type localChain []resolver.Resolver
+
+// InBailiwick always returns true as the server resolves every query locally.
+func (t localChain) InBailiwick(qName string) bool {
+	return true
+}
+
+// Resolve passes the query to the highest priority resolver with the qName in its bailiwick.
+func (t localChain) Resolve(q *dns.Msg, qMeta *resolver.QueryMetaData) (*dns.Msg, *resolver.ResponseMetaData, error) {
+	return t.pick(q).Resolve(q, qMeta)
+}
+
+// ResolveContext is Resolve with the ctx passed on to the picked resolver.
+func (t localChain) ResolveContext(ctx context.Context, q *dns.Msg, qMeta *resolver.QueryMetaData) (*dns.Msg, *resolver.ResponseMetaData, error) {
+	return resolver.ResolveContext(ctx, t.pick(q), q, qMeta)
+}
+
+// pick returns the resolver for the query.
+func (t localChain) pick(q *dns.Msg) resolver.Resolver {
+	if len(q.Question) > 0 {
+		for _, r := range t {
+			if r.InBailiwick(q.Question[0].Name) {
+				return r
+			}
+		}
+	}
+
+	return t[0]
+}
+
+// maxZoneChases limits the CNAME targets resolved on behalf of a zone in case zones alias each other
+// in a loop.
+const maxZoneChases = 8
+
+// zoneChain answers queries within the --zone-file zones ahead of the local resolvers. It differs
+// from localChain in that queries outside every zone go to next rather than the first zone, and in
+// that it completes partial answers from a zone whose CNAME chain leads out of the zone.
+type zoneChain struct {
+	zones localChain
+	next  resolver.Resolver
+}
+
+// InBailiwick always returns true as the server resolves every query locally.
+func (t zoneChain) InBailiwick(qName string) bool {
+	return true
+}
+
+// Resolve is ResolveContext without any deadline.
+func (t zoneChain) Resolve(q *dns.Msg, qMeta *resolver.QueryMetaData) (*dns.Msg, *resolver.ResponseMetaData, error) {
+	return t.ResolveContext(context.Background(), q, qMeta)
+}
+
+// ResolveContext passes the query to the first zone with the qName in its bailiwick or to next. A
+// partial answer is completed by resolving the CNAME target in the same way.
+func (t zoneChain) ResolveContext(ctx context.Context, q *dns.Msg, qMeta *resolver.QueryMetaData) (*dns.Msg, *resolver.ResponseMetaData, error) {
+	resp, respMeta, err := resolver.ResolveContext(ctx, t.pick(q), q, qMeta)
+	for chase := 0; chase < maxZoneChases; chase++ {
+		if err != nil || respMeta == nil || len(respMeta.CNAMETarget) == 0 {
+			break
+		}
+		resp, respMeta = t.chase(ctx, q, qMeta, resp, respMeta)
+	}
+
+	return resp, respMeta, err
+}
+
+// chase resolves the CNAME target of a partial answer and appends the results. The rcode and
+// Authority section come from the target as they relate to the last name in the chain (RFC6604). If
+// the target cannot be resolved the partial answer is returned for the client to chase instead.
+func (t zoneChain) chase(ctx context.Context, q *dns.Msg, qMeta *resolver.QueryMetaData,
+	resp *dns.Msg, respMeta *resolver.ResponseMetaData) (*dns.Msg, *resolver.ResponseMetaData) {
+	tq := q.Copy() // Retain the EDNS0 options of the original query
+	tq.Question[0].Name = respMeta.CNAMETarget
+	tResp, tMeta, err := resolver.ResolveContext(ctx, t.pick(tq), tq, qMeta)
+	if err != nil {
+		respMeta.CNAMETarget = ""
+		return resp, respMeta
+	}
+
+	resp.Answer = append(resp.Answer, tResp.Answer...)
+	resp.Ns = tResp.Ns
+	resp.Rcode = tResp.Rcode
+	resp.Authoritative = resp.Authoritative && tResp.Authoritative
+
+	merged := *tMeta
+	merged.TransportDuration += respMeta.TransportDuration
+	merged.ResolutionDuration += respMeta.ResolutionDuration
+	merged.QueryTries += respMeta.QueryTries
+	merged.ServerTries += respMeta.ServerTries
+	merged.PayloadSize = resp.Len()
+	merged.RawResponse = nil // No longer matches the response
+
+	return resp, &merged
+}
+
+// pick returns the resolver for the query.
+func (t zoneChain) pick(q *dns.Msg) resolver.Resolver {
+	if len(q.Question) > 0 {
+		for _, z := range t.zones {
+			if z.InBailiwick(q.Question[0].Name) {
+				return z
+			}
+		}
+	}
+
+	return t.next
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/markdingo/trustydns/internal/resolver"
+	"github.com/markdingo/trustydns/internal/resolver/zonefile"
+
+	"github.com/miekg/dns"
+)
+
+func TestLocalChain(t *testing.T) {
+	primary := &mockResolver{}
+	primary.response.Id = 1
+	second := &mockResolver{}
+	second.response.Id = 2
+	third := &mockResolver{}
+	third.response.Id = 3
+	chain := localChain{primary, second, third}
+
+	testCases := []struct {
+		ib     [3]bool // InBailiwick of each resolver
+		expect uint16  // Id of responding resolver
+	}{
+		{[3]bool{false, false, false}, 1}, // Nobody claims it so primary resolves
+		{[3]bool{false, true, false}, 2},
+		{[3]bool{false, false, true}, 3},
+		{[3]bool{false, true, true}, 2}, // Priority order
+		{[3]bool{true, true, true}, 1},
+	}
+
+	if !chain.InBailiwick("anything.example.") {
+		t.Error("localChain should be in bailiwick for everything")
+	}
+
+	for ix, tc := range testCases {
+		primary.ib, second.ib, third.ib = tc.ib[0], tc.ib[1], tc.ib[2]
+		q := &dns.Msg{}
+		q.SetQuestion("example.org.", dns.TypeA)
+		resp, _, err := chain.Resolve(q, nil)
+		if err != nil {
+			t.Fatal(ix, err)
+		}
+		if resp.Id != tc.expect {
+			t.Error(ix, "Wrong resolver used. Expected", tc.expect, "got", resp.Id)
+		}
+	}
+
+	if chain.pick(&dns.Msg{}) != primary { // No question
+		t.Error("Query without a question should go to the primary resolver")
+	}
+}
+
+func TestZoneChain(t *testing.T) {
+	next := &mockResolver{}
+	next.response.Id = 1
+	zone1 := &mockResolver{}
+	zone1.response.Id = 2
+	zone2 := &mockResolver{}
+	zone2.response.Id = 3
+	chain := zoneChain{zones: localChain{zone1, zone2}, next: next}
+
+	testCases := []struct {
+		ib     [2]bool // InBailiwick of each zone
+		expect uint16  // Id of responding resolver
+	}{
+		{[2]bool{false, false}, 1}, // Outside the zones goes to next, not the first zone
+		{[2]bool{true, false}, 2},
+		{[2]bool{false, true}, 3},
+		{[2]bool{true, true}, 2},
+	}
+
+	if !chain.InBailiwick("anything.example.") {
+		t.Error("zoneChain should be in bailiwick for everything")
+	}
+
+	for ix, tc := range testCases {
+		zone1.ib, zone2.ib = tc.ib[0], tc.ib[1]
+		q := &dns.Msg{}
+		q.SetQuestion("example.net.", dns.TypeA)
+		resp, _, err := chain.Resolve(q, nil)
+		if err != nil {
+			t.Fatal(ix, err)
+		}
+		if resp.Id != tc.expect {
+			t.Error(ix, "Wrong resolver used. Expected", tc.expect, "got", resp.Id)
+		}
+	}
+
+	zone1.ib = true
+	if chain.pick(&dns.Msg{}) != next { // No question
+		t.Error("Query without a question should go to next")
+	}
+}
+
+// A CNAME which leads out of a zone should be completed by the next resolver
+func TestZoneChainChase(t *testing.T) {
+	z, err := zonefile.New(zonefile.Config{Path: "testdata/example.zone"})
+	if err != nil {
+		t.Fatal("Setup error", err)
+	}
+	next := &mockResolver{}
+	next.response.SetQuestion("www.example.org.", dns.TypeA)
+	next.response.Response = true
+	rr, _ := dns.NewRR("www.example.org. 60 IN A 192.0.2.99")
+	next.response.Answer = []dns.RR{rr}
+	next.rMeta = resolver.ResponseMetaData{FinalServerUsed: "next", QueryTries: 2, ServerTries: 1}
+	chain := zoneChain{zones: localChain{z}, next: next}
+
+	q := &dns.Msg{}
+	q.SetQuestion("ext.example.net.", dns.TypeA)
+	q.SetEdns0(1232, false)
+	resp, respMeta, err := chain.Resolve(q, nil)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if next.query.Question[0].Name != "www.example.org." || next.query.IsEdns0() == nil {
+		t.Error("Next resolver was not asked for the CNAME target with EDNS0", next.query.Question)
+	}
+	if len(resp.Answer) != 2 || resp.Answer[0].Header().Rrtype != dns.TypeCNAME ||
+		resp.Answer[1].Header().Rrtype != dns.TypeA {
+		t.Fatal("Expected CNAME then A, got", resp.Answer)
+	}
+	if resp.Id != q.Id || resp.Authoritative || resp.Rcode != dns.RcodeSuccess {
+		t.Error("Merged response header wrong", resp.MsgHdr)
+	}
+	if respMeta.CNAMETarget != "" || respMeta.FinalServerUsed != "next" || respMeta.QueryTries != 3 ||
+		respMeta.ServerTries != 2 || respMeta.PayloadSize != resp.Len() {
+		t.Error("Merged respMeta wrong", respMeta)
+	}
+
+	// If the target cannot be resolved the partial answer is returned
+
+	next.err = errors.New("no can do")
+	resp, respMeta, err = chain.Resolve(q, nil)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if len(resp.Answer) != 1 || !resp.Authoritative || respMeta.CNAMETarget != "" {
+		t.Error("Expected the partial answer", resp, respMeta)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"github.com/markdingo/trustydns/internal/dnsutil"
+
+	"github.com/miekg/dns"
+)
+
+// chaosResponse returns a response to a CHAOS class query or nil if the query is not CHAOS class.
+// TXT queries for version.bind are answered with --chaos-version and hostname.bind and id.server
+// (rfc4892) are answered with --chaos-hostname. Everything else, including queries for names whose
+// string has not been configured, is REFUSED so that nothing is disclosed by default.
+func chaosResponse(dnsQ *dns.Msg) *dns.Msg {
+	if len(dnsQ.Question) != 1 || dnsQ.Question[0].Qclass != dns.ClassCHAOS {
+		return nil
+	}
+
+	q := dnsQ.Question[0]
+	txt := ""
+	if q.Qtype == dns.TypeTXT {
+		switch dns.CanonicalName(q.Name) {
+		case "version.bind.":
+			txt = cfg.chaosVersion
+		case "hostname.bind.", "id.server.":
+			txt = cfg.chaosHostname
+		}
+	}
+
+	dnsR := &dns.Msg{}
+	if len(txt) == 0 {
+		dnsR.SetRcode(dnsQ, dns.RcodeRefused)
+	} else {
+		dnsR.SetReply(dnsQ)
+		dnsR.Authoritative = true
+		dnsR.Answer = append(dnsR.Answer, &dns.TXT{
+			Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeTXT, Class: dns.ClassCHAOS},
+			Txt: []string{txt}})
+	}
+	if dnsQ.IsEdns0() != nil {
+		dnsR.Extra = append(dnsR.Extra, dnsutil.NewOPT())
+	}
+
+	return dnsR
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestChaosResponse(t *testing.T) {
+	mainInit(os.Stdout, os.Stderr)
+	cfg.chaosVersion = "trustydns-v1"
+	cfg.chaosHostname = "doh1.example.net"
+
+	testCases := []struct {
+		qName  string
+		qClass uint16
+		qType  uint16
+		rcode  int
+		txt    string // Expected answer if rcode is success
+	}{
+		{"version.bind.", dns.ClassCHAOS, dns.TypeTXT, dns.RcodeSuccess, "trustydns-v1"},
+		{"VERSION.Bind.", dns.ClassCHAOS, dns.TypeTXT, dns.RcodeSuccess, "trustydns-v1"},
+		{"hostname.bind.", dns.ClassCHAOS, dns.TypeTXT, dns.RcodeSuccess, "doh1.example.net"},
+		{"id.server.", dns.ClassCHAOS, dns.TypeTXT, dns.RcodeSuccess, "doh1.example.net"},
+		{"version.server.", dns.ClassCHAOS, dns.TypeTXT, dns.RcodeRefused, ""},
+		{"version.bind.", dns.ClassCHAOS, dns.TypeA, dns.RcodeRefused, ""},
+	}
+
+	for ix, tc := range testCases {
+		q := &dns.Msg{}
+		q.SetQuestion(tc.qName, tc.qType)
+		q.Question[0].Qclass = tc.qClass
+		r := chaosResponse(q)
+		if r == nil {
+			t.Error(ix, "Expected a response for CHAOS query")
+			continue
+		}
+		if r.Rcode != tc.rcode {
+			t.Error(ix, "Expected rcode", dns.RcodeToString[tc.rcode], "got", dns.RcodeToString[r.Rcode])
+		}
+		if tc.rcode != dns.RcodeSuccess {
+			continue
+		}
+		if len(r.Answer) != 1 {
+			t.Error(ix, "Expected one answer, got", r.Answer)
+			continue
+		}
+		txt, ok := r.Answer[0].(*dns.TXT)
+		if !ok || len(txt.Txt) != 1 || txt.Txt[0] != tc.txt || txt.Hdr.Class != dns.ClassCHAOS {
+			t.Error(ix, "Expected CH TXT", tc.txt, "got", r.Answer[0])
+		}
+	}
+
+	// Nothing is disclosed by default
+
+	mainInit(os.Stdout, os.Stderr)
+	q := &dns.Msg{}
+	q.SetQuestion("version.bind.", dns.TypeTXT)
+	q.Question[0].Qclass = dns.ClassCHAOS
+	q.SetEdns0(1232, false)
+	r := chaosResponse(q)
+	if r == nil || r.Rcode != dns.RcodeRefused {
+		t.Error("Expected REFUSED by default, got", r)
+	} else if r.IsEdns0() == nil {
+		t.Error("Expected OPT in response to query with OPT")
+	}
+
+	q.Question[0].Qclass = dns.ClassINET
+	if r := chaosResponse(q); r != nil {
+		t.Error("IN query should not be answered", r)
+	}
+}
+
+// Test that serveDoH answers CHAOS queries without involving the local resolver
+func TestServeDoHChaos(t *testing.T) {
+	mainInit(os.Stdout, os.Stderr)
+	cfg.chaosVersion = "trustydns-v1"
+	res := &mockResolver{err: errors.New("Local resolver should not be called")}
+	s := &server{stdout: stdout, local: res}
+	mw := newMockResponseWriter()
+
+	q := &dns.Msg{}
+	q.SetQuestion("version.bind.", dns.TypeTXT)
+	q.Question[0].Qclass = dns.ClassCHAOS
+	binary, err := q.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := http.NewRequest("POST", "http://localhost", bytes.NewReader(binary))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "application/dns-message")
+	s.serveDoH(mw, r)
+
+	if mw.statusCode != 0 {
+		t.Fatal("Request failed", mw.statusCode, mw.String())
+	}
+	if len(res.query.Question) != 0 {
+		t.Error("CHAOS query passed to local resolver", res.query.String())
+	}
+	resp := &dns.Msg{}
+	if err := resp.Unpack(mw.writeBuffer); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Answer) != 1 || resp.Id != q.Id {
+		t.Error("Expected CH TXT answer with original Id", resp.String())
+	}
+	if s.successCount != 1 || s.eventCounters[evChaos] != 1 {
+		t.Error("CHAOS answer not counted", s.stats)
+	}
+}
@@ -7,24 +7,71 @@ import (
 )
 
 type config struct {
+	configFile string // --config file providing defaults for options not on the command line
+
+	check             bool // Validate configuration then exit
 	gops              bool
 	help              bool
+	reusePort         bool // Set SO_REUSEPORT on listen sockets
+	systemd           bool // Adopt listen sockets from systemd socket activation
 	verbose           bool
 	verifyClientCerts bool
 	version           bool
+	versionJSON       bool // Print build metadata as JSON
 
 	listenAddresses flagutil.StringValue // Addresses for inbound HTTP requests
+	interfaces      flagutil.StringValue // Listen on all addresses of these interfaces
+	unixSocket      string               // Serve plain HTTP on this Unix domain socket path
 
 	resolvConf     string
+	resolvConfs    flagutil.StringValue // Lower priority resolv.conf files consulted after -c
+	zoneFiles      flagutil.StringValue // Zones answered authoritatively ahead of the local resolvers
+	udpBufferSize  int
+	parallelLocal  int    // Number of local resolvers to query simultaneously
+	hybridLocal    bool   // Prefer the fastest local resolver once latency is known
+	reorderLocal   bool   // Periodically re-sort local resolvers by recent success rate
+	reportFormat   string // text or json
 	statusInterval time.Duration
 	requestTimeout time.Duration
+	maxRequestSize int // Maximum decoded DNS query size accepted from HTTP clients - zero means no limit
+
+	reapIdle time.Duration // Close client connections idle for longer than this if GT zero
+
+	maxTTLOnError time.Duration // Serve last known good or SERVFAIL with this TTL cap if GT zero
+
+	warmFile     string        // qName/qType entries resolved at startup
+	warmInterval time.Duration // Re-resolve warmFile entries this often if GT zero
 
 	ecsRemove           bool // Remove inbound ECS
 	ecsSet              bool
 	ecsSetIPv4PrefixLen int
 	ecsSetIPv6PrefixLen int
+	ecsMaxIPv4PrefixLen int // Cap on client requested synthesis prefix lengths - zero means no cap
+	ecsMaxIPv6PrefixLen int
+	trustedProxies      flagutil.StringValue // Peers whose X-Forwarded-For is believed for ECS synthesis
+	ecsExempt           flagutil.StringValue // Clients whose ECS is left untouched
+	ecsEcho             bool                 // Add synthesized ECS to responses which lack one
+
+	chaosVersion  string // CHAOS TXT version.bind answer - REFUSED if empty
+	chaosHostname string // CHAOS TXT hostname.bind and id.server answer - REFUSED if empty
+
+	anyPolicy string // forward, hinfo or refuse qtype ANY queries
+
+	debugMeta flagutil.StringValue // Clients which can ask for resolution meta data in the response
+
+	ednsPassthrough flagutil.StringValue // EDNS0 options forwarded to the local resolvers - others are removed
+
+	serverCookies bool // Issue and validate rfc7873 server cookies
+
+	minimalResponses  bool // Strip Authority and Additional from positive responses
+	preserveZeroId    bool // Debug: do not replace a zero query Id prior to resolution
+	validateRoundtrip bool // Debug: unpack packed responses and compare with the original
+
+	injectDelay         time.Duration // Testing: delay responses by this much if GT zero
+	injectDelayFraction float64       // Testing: fraction of responses to delay
 
 	logAll       bool // Turns on all other log options
+	logDetail    bool // Include more detail in compact prints of DNS messages
 	logClientIn  bool // Compact print of DNS query arriving from the HTTPS client
 	logClientOut bool // Compact print of DNS response returned to the HTTPS client
 	logHTTPIn    bool // Compact print of HTTP query arriving from the HTTPS client
@@ -33,12 +80,16 @@ type config struct {
 	logLocalOut  bool // Compact print of DNS query sent to the local resolver
 	logTLSErrors bool // Print Client TLS verification failures
 
+	slowQueryThreshold time.Duration // Always print queries which take longer than this if GT zero
+
 	tlsServerCertFiles  flagutil.StringValue
 	tlsServerKeyFiles   flagutil.StringValue
 	tlsCAFiles          flagutil.StringValue // Non-system root CAs
 	tlsUseSystemRootCAs bool                 // Do/Do not use system root CAs
 
 	cpuprofile, memprofile string
+	profileDir             string // SIGUSR2 writes goroutine and heap profiles here
+	debugListen            string // Loopback address serving /debug/vars and /debug/pprof
 
 	setuidName, setgidName, chrootDir string // Process constraint settings
 }
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/markdingo/trustydns/internal/dnsutil"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	cookieClientLen    = 8  // rfc7873 fixes the client cookie length
+	cookieServerMinLen = 8  // and constrains the server cookie length
+	cookieServerMaxLen = 32 // to this range.
+	cookieServerLen    = 16 // Length of the server cookies we issue
+	cookieVersion      = 1  // rfc9018 server cookie version
+	cookieSecretLen    = 32
+
+	cookieLifetime   = time.Hour       // Server cookies older than this are bad. Also the secret rotation interval
+	cookieFutureSkew = 5 * time.Minute // Server cookies timestamped further in the future than this are bad
+)
+
+type cookieStatus int
+
+const (
+	cookieNone       cookieStatus = iota // Query has no COOKIE option
+	cookieMalformed                      // COOKIE option lengths are invalid - FORMERR
+	cookieClientOnly                     // Only a client cookie is present - issue a server cookie
+	cookieValid                          // Client and server cookie are good - issue a fresh server cookie
+	cookieBad                            // Server cookie does not verify - BADCOOKIE
+)
+
+// cookieSecrets issues and validates rfc7873 server cookies for --enable-server-cookies. Server
+// cookies use the rfc9018 layout of Version, Reserved, Timestamp and Hash, but the Hash is the first
+// eight bytes of an HMAC-SHA256 rather than SipHash-2-4 as the latter is not in the standard
+// library. As a consequence our cookies are only verifiable by this server, which is all rfc7873
+// requires.
+//
+// The secret is rotated every cookieLifetime. The previous secret is retained so that cookies
+// issued just prior to a rotation remain valid until they expire.
+type cookieSecrets struct {
+	now func() time.Time // Replaceable for testing
+
+	mu       sync.Mutex // Protects everything below here
+	current  []byte
+	previous []byte // nil until the first rotation
+	rotated  time.Time
+}
+
+// newCookieSecrets creates a cookieSecrets with a randomly generated initial secret
+func newCookieSecrets() (*cookieSecrets, error) {
+	t := &cookieSecrets{now: time.Now}
+	var err error
+	t.current, err = newCookieSecret()
+	if err != nil {
+		return nil, err
+	}
+	t.rotated = t.now()
+
+	return t, nil
+}
+
+func newCookieSecret() ([]byte, error) {
+	secret := make([]byte, cookieSecretLen)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+
+	return secret, nil
+}
+
+// secrets returns the current and previous secrets, rotating them first if the current secret has
+// reached cookieLifetime. Rotation is lazy so there is no need for a ticker. If a new secret cannot
+// be generated the current one is retained until the next attempt.
+func (t *cookieSecrets) secrets(now time.Time) (current, previous []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if now.Sub(t.rotated) >= cookieLifetime {
+		if secret, err := newCookieSecret(); err == nil {
+			t.previous = t.current
+			t.current = secret
+			t.rotated = now
+		}
+	}
+
+	return t.current, t.previous
+}
+
+// check examines the COOKIE option in dnsQ and removes it as the server cookie exchange is between
+// the DoH client and us, not the local resolvers. The client IP is part of the cookie so that a
+// server cookie cannot be replayed from a different client.
+func (t *cookieSecrets) check(dnsQ *dns.Msg, ip net.IP) (clientCookie []byte, status cookieStatus) {
+	var cookie *dns.EDNS0_COOKIE
+	if opt := dnsQ.IsEdns0(); opt != nil {
+		for _, subOpt := range opt.Option {
+			if c, ok := subOpt.(*dns.EDNS0_COOKIE); ok {
+				cookie = c
+				break
+			}
+		}
+	}
+	if cookie == nil {
+		return nil, cookieNone
+	}
+	dnsutil.FilterEDNS0(dnsQ, func(code uint16) bool { return code != dns.EDNS0COOKIE }) // Retains the OPT
+
+	raw, err := hex.DecodeString(cookie.Cookie)
+	if err != nil || len(raw) < cookieClientLen {
+		return nil, cookieMalformed
+	}
+	clientCookie, serverCookie := raw[:cookieClientLen], raw[cookieClientLen:]
+	if len(serverCookie) == 0 {
+		return clientCookie, cookieClientOnly
+	}
+	if len(serverCookie) < cookieServerMinLen || len(serverCookie) > cookieServerMaxLen {
+		return nil, cookieMalformed
+	}
+	if !t.valid(clientCookie, serverCookie, ip) {
+		return clientCookie, cookieBad
+	}
+
+	return clientCookie, cookieValid
+}
+
+// valid returns true if serverCookie was issued by us to this client within cookieLifetime
+func (t *cookieSecrets) valid(clientCookie, serverCookie []byte, ip net.IP) bool {
+	if len(serverCookie) != cookieServerLen || serverCookie[0] != cookieVersion {
+		return false
+	}
+
+	now := t.now()
+	stamp := time.Unix(int64(binary.BigEndian.Uint32(serverCookie[4:8])), 0)
+	if now.Sub(stamp) > cookieLifetime || stamp.Sub(now) > cookieFutureSkew {
+		return false
+	}
+
+	current, previous := t.secrets(now)
+	for _, secret := range [][]byte{current, previous} {
+		if secret != nil && hmac.Equal(serverCookie, cookieHash(secret, clientCookie, serverCookie[:8], ip)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// issue returns a COOKIE option containing clientCookie and a newly generated server cookie
+func (t *cookieSecrets) issue(clientCookie []byte, ip net.IP) *dns.EDNS0_COOKIE {
+	now := t.now()
+	current, _ := t.secrets(now)
+	header := make([]byte, 8) // Version, Reserved and Timestamp
+	header[0] = cookieVersion
+	binary.BigEndian.PutUint32(header[4:], uint32(now.Unix()))
+
+	return &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE,
+		Cookie: hex.EncodeToString(clientCookie) + hex.EncodeToString(cookieHash(current, clientCookie, header, ip))}
+}
+
+// cookieHash returns the complete server cookie, that is, header followed by the truncated HMAC of
+// the client cookie, header and client IP.
+func cookieHash(secret, clientCookie, header []byte, ip net.IP) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(clientCookie)
+	mac.Write(header)
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	mac.Write(ip)
+
+	return append(append([]byte{}, header...), mac.Sum(nil)[:cookieServerLen-len(header)]...)
+}
+
+// cookieMsg wraps a COOKIE option in a message suitable for dnsutil.MergeEDNS()
+func cookieMsg(cookie *dns.EDNS0_COOKIE) *dns.Msg {
+	opt := dnsutil.NewOPT()
+	opt.Option = append(opt.Option, cookie)
+
+	return &dns.Msg{Extra: []dns.RR{opt}}
+}
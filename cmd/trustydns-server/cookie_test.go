@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/markdingo/trustydns/internal/dnsutil"
+
+	"github.com/miekg/dns"
+)
+
+const testClientCookie = "0102030405060708"
+
+func cookieQuery(cookie string) *dns.Msg {
+	q := &dns.Msg{}
+	q.SetQuestion("example.net.", dns.TypeA)
+	q.SetEdns0(1232, false)
+	if len(cookie) > 0 {
+		opt := q.IsEdns0()
+		opt.Option = append(opt.Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: cookie})
+	}
+
+	return q
+}
+
+func TestCookieCheck(t *testing.T) {
+	cs, err := newCookieSecrets()
+	if err != nil {
+		t.Fatal("Setup error", err)
+	}
+	now := time.Unix(1700000000, 0)
+	cs.now = func() time.Time { return now }
+	cs.rotated = now
+	ip := net.ParseIP("192.0.2.1")
+	cc, _ := hex.DecodeString(testClientCookie)
+	issued := cs.issue(cc, ip).Cookie
+	if len(issued) != (cookieClientLen+cookieServerLen)*2 || !strings.HasPrefix(issued, testClientCookie) {
+		t.Fatal("Issued cookie has wrong form", issued)
+	}
+
+	testCases := []struct {
+		cookie string
+		ip     string
+		status cookieStatus
+	}{
+		{"", "192.0.2.1", cookieNone},
+		{"0102", "192.0.2.1", cookieMalformed},                    // Client cookie too short
+		{"zz02030405060708", "192.0.2.1", cookieMalformed},        // Not hex
+		{testClientCookie + "0102", "192.0.2.1", cookieMalformed}, // Server cookie too short
+		{testClientCookie + strings.Repeat("00", 33), "192.0.2.1", cookieMalformed},
+		{testClientCookie, "192.0.2.1", cookieClientOnly},
+		{issued, "192.0.2.1", cookieValid},
+		{issued, "192.0.2.2", cookieBad},                                      // Replayed from elsewhere
+		{"0807060504030201" + issued[16:], "192.0.2.1", cookieBad},            // Different client cookie
+		{testClientCookie + strings.Repeat("00", 16), "192.0.2.1", cookieBad}, // Not ours
+	}
+	for ix, tc := range testCases {
+		q := cookieQuery(tc.cookie)
+		_, status := cs.check(q, net.ParseIP(tc.ip))
+		if status != tc.status {
+			t.Error(ix, "Expected status", tc.status, "got", status)
+		}
+		if opt := q.IsEdns0(); opt == nil || len(opt.Option) != 0 {
+			t.Error(ix, "COOKIE should be removed leaving the OPT", q.Extra)
+		}
+	}
+
+	// Cookies remain valid over a rotation of the secret but not once they are too old
+
+	now = now.Add(cookieLifetime - time.Second)
+	cs.secrets(now.Add(time.Second)) // Force a rotation
+	if cs.previous == nil {
+		t.Fatal("Secret was not rotated")
+	}
+	if _, status := cs.check(cookieQuery(issued), ip); status != cookieValid {
+		t.Error("Cookie issued with previous secret should be valid, got", status)
+	}
+	now = now.Add(2 * time.Second)
+	if _, status := cs.check(cookieQuery(issued), ip); status != cookieBad {
+		t.Error("Expired cookie should be bad, got", status)
+	}
+
+	// Nor if they are from the future
+
+	future := cs.issue(cc, ip).Cookie
+	now = now.Add(-cookieFutureSkew - time.Minute)
+	if _, status := cs.check(cookieQuery(future), ip); status != cookieBad {
+		t.Error("Future cookie should be bad, got", status)
+	}
+}
+
+// Test the server cookie exchange through serveDoH
+func TestServeDoHCookies(t *testing.T) {
+	mainInit(os.Stdout, os.Stderr)
+	res := &mockResolver{}
+	res.response.SetReply(cookieQuery(""))
+	cs, err := newCookieSecrets()
+	if err != nil {
+		t.Fatal("Setup error", err)
+	}
+	s := &server{stdout: stdout, local: res, cookies: cs}
+
+	exchange := func(q *dns.Msg) *dns.Msg {
+		binary, err := q.Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := http.NewRequest("POST", "http://localhost", bytes.NewReader(binary))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", "application/dns-message")
+		r.RemoteAddr = "192.0.2.1:4321"
+		mw := newMockResponseWriter()
+		s.serveDoH(mw, r)
+		if mw.statusCode != 0 {
+			t.Fatal("Request failed", mw.statusCode, mw.String())
+		}
+		resp := &dns.Msg{}
+		if err := resp.Unpack(mw.writeBuffer); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	findCookie := func(m *dns.Msg) string {
+		if opt := dnsutil.FindOPT(m); opt != nil {
+			for _, subOpt := range opt.Option {
+				if c, ok := subOpt.(*dns.EDNS0_COOKIE); ok {
+					return c.Cookie
+				}
+			}
+		}
+		return ""
+	}
+
+	// Client cookie only gets a server cookie and the COOKIE is not passed to the local resolver
+
+	resp := exchange(cookieQuery(testClientCookie))
+	issued := findCookie(resp)
+	if resp.Rcode != dns.RcodeSuccess || len(issued) <= len(testClientCookie) {
+		t.Fatal("Expected NOERROR with a server cookie, got", resp)
+	}
+	if findCookie(&res.query) != "" {
+		t.Error("COOKIE passed to the local resolver", res.query.String())
+	}
+
+	// The issued cookie is accepted and a fresh one returned
+
+	resp = exchange(cookieQuery(issued))
+	if resp.Rcode != dns.RcodeSuccess || !strings.HasPrefix(findCookie(resp), testClientCookie) {
+		t.Error("Expected NOERROR with a server cookie, got", resp)
+	}
+
+	// A bad server cookie gets BADCOOKIE along with a good one
+
+	resp = exchange(cookieQuery(testClientCookie + strings.Repeat("00", 16)))
+	if resp.Rcode != dns.RcodeBadCookie {
+		t.Error("Expected BADCOOKIE, got", dns.RcodeToString[resp.Rcode])
+	}
+	if _, status := cs.check(cookieQuery(findCookie(resp)), net.ParseIP("192.0.2.1")); status != cookieValid {
+		t.Error("BADCOOKIE response should carry a valid server cookie", resp)
+	}
+
+	// A malformed cookie gets FORMERR
+
+	resp = exchange(cookieQuery("0102"))
+	if resp.Rcode != dns.RcodeFormatError {
+		t.Error("Expected FORMERR, got", dns.RcodeToString[resp.Rcode])
+	}
+
+	// No COOKIE, no server cookie
+
+	resp = exchange(cookieQuery(""))
+	if resp.Rcode != dns.RcodeSuccess || findCookie(resp) != "" {
+		t.Error("Expected NOERROR without a cookie, got", resp)
+	}
+
+	if s.eventCounters[evCookie] != 2 || s.failureCounters[serBadCookie] != 2 {
+		t.Error("Cookie stats wrong", s.eventCounters[evCookie], s.failureCounters[serBadCookie])
+	}
+}
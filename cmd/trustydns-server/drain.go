@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/markdingo/trustydns/internal/osutil"
+	"github.com/markdingo/trustydns/internal/reporter"
+)
+
+// drainReportInterval is how often --verbose prints the draining progress during shutdown
+const drainReportInterval = time.Second
+
+// inFlight returns the number of connections still open to the server and the number of requests
+// still being processed. Connections are only tracked once start() has been called.
+func (t *server) inFlight() (conns, requests int) {
+	if t.connTrk != nil {
+		conns, _ = t.connTrk.Current()
+	}
+
+	return conns, t.ccTrk.Current()
+}
+
+// drainStatus summarizes the in-flight connections and requests across all servers
+func drainStatus(servers []*server) string {
+	var conns, requests int
+	for _, s := range servers {
+		c, r := s.inFlight()
+		conns += c
+		requests += r
+	}
+
+	return fmt.Sprintf("Draining: conns=%d requests=%d", conns, requests)
+}
+
+// drain stops all servers concurrently and waits for them to complete their in-flight requests.
+// While waiting, the progress is printed every drainReportInterval if --verbose is set and in
+// response to SIGUSR1 along with the regular status report. This gives an operator confidence
+// that a rolling restart is not dropping requests. All other signals are ignored while draining.
+func drain(servers []*server, reporters []reporter.Reporter) {
+	drained := make(chan struct{})
+	go func() {
+		wg := &sync.WaitGroup{}
+		for _, s := range servers {
+			wg.Add(1)
+			go func(s *server) {
+				s.stop()
+				wg.Done()
+			}(s)
+		}
+		wg.Wait()
+		close(drained)
+	}()
+
+	ticker := time.NewTicker(drainReportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-drained:
+			return
+
+		case <-ticker.C:
+			if cfg.verbose {
+				fmt.Fprintln(stdout, drainStatus(servers))
+			}
+
+		case s := <-stopChannel:
+			if osutil.IsSignalUSR1(s) {
+				statusReport("User1", false, reporters)
+				fmt.Fprintln(stdout, drainStatus(servers))
+			}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/markdingo/trustydns/internal/resolver"
+
+	"github.com/miekg/dns"
+)
+
+// gatedResolver blocks in Resolve() until gate is closed
+type gatedResolver struct {
+	mockResolver
+	entered chan struct{}
+	gate    chan struct{}
+}
+
+func (t *gatedResolver) Resolve(query *dns.Msg, qMeta *resolver.QueryMetaData) (*dns.Msg, *resolver.ResponseMetaData, error) {
+	close(t.entered)
+	<-t.gate
+	return t.mockResolver.Resolve(query, qMeta)
+}
+
+// Test that draining reports the in-flight request and waits for it to complete
+func TestDrain(t *testing.T) {
+	out := &mutexBytesBuffer{}
+	mainInit(out, &mutexBytesBuffer{})
+	cfg.verbose = true
+
+	l, err := net.Listen("tcp", "127.0.0.1:0") // Find a free port
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	res := &gatedResolver{entered: make(chan struct{}), gate: make(chan struct{})}
+	res.response.SetQuestion("example.net.", dns.TypeA)
+	s := &server{stdout: &mutexBytesBuffer{}, local: res, listenAddress: addr}
+	errorChannel := make(chan error, 1)
+	wg := &sync.WaitGroup{}
+	s.start(nil, errorChannel, wg)
+
+	q := &dns.Msg{}
+	q.SetQuestion("example.net.", dns.TypeA)
+	binary, _ := q.Pack()
+	reqDone := make(chan error, 1)
+	go func() {
+		resp, err := http.Post("http://"+addr+consts.Rfc8484Path, consts.Rfc8484AcceptValue,
+			bytes.NewReader(binary))
+		if err == nil {
+			resp.Body.Close()
+		}
+		reqDone <- err
+	}()
+	<-res.entered // Request is now in-flight
+
+	drainDone := make(chan struct{})
+	go func() {
+		drain([]*server{s}, nil)
+		close(drainDone)
+	}()
+	stopChannel <- syscall.SIGUSR1
+	time.Sleep(drainReportInterval + 200*time.Millisecond)
+
+	select {
+	case <-drainDone:
+		t.Fatal("drain returned with a request in-flight")
+	default:
+	}
+	got := out.String()
+	if strings.Count(got, "Draining: conns=1 requests=1") < 2 {
+		t.Error("Expected SIGUSR1 and periodic drain status", got)
+	}
+	if !strings.Contains(got, "Status Up:") {
+		t.Error("Expected SIGUSR1 status report while draining", got)
+	}
+
+	close(res.gate)
+	select {
+	case <-drainDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("drain did not return after the request completed")
+	}
+	if err := <-reqDone; err != nil {
+		t.Error("In-flight request was not completed", err)
+	}
+	if st := drainStatus([]*server{s}); st != "Draining: conns=0 requests=0" {
+		t.Error("Expected nothing in-flight after draining, got", st)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"github.com/markdingo/trustydns/internal/dnsutil"
+
+	"github.com/miekg/dns"
+)
+
+// ednsPassthrough is the set of EDNS0 option codes forwarded to the local resolvers. ECS is always
+// forwarded as it has its own policy controlled by the --ecs-* options.
+type ednsPassthrough map[uint16]bool
+
+// parseEDNSPassthrough converts --edns-passthrough names or decimal codes into an ednsPassthrough
+func parseEDNSPassthrough(args []string) (ednsPassthrough, error) {
+	ep := make(ednsPassthrough)
+	for _, arg := range args {
+		code, err := dnsutil.ParseEDNS0Code(arg)
+		if err != nil {
+			return nil, err
+		}
+		ep[code] = true
+	}
+
+	return ep, nil
+}
+
+// keep is passed to dnsutil.FilterEDNS0()
+func (t ednsPassthrough) keep(code uint16) bool {
+	return code == dns.EDNS0SUBNET || t[code]
+}
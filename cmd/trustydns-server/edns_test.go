@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestParseEDNSPassthrough(t *testing.T) {
+	ep, err := parseEDNSPassthrough([]string{"cookie", "KEYTAG", "65001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, code := range []uint16{dns.EDNS0COOKIE, 14, 65001, dns.EDNS0SUBNET} {
+		if !ep.keep(code) {
+			t.Error("Expected code", code, "to be kept")
+		}
+	}
+	if ep.keep(dns.EDNS0NSID) {
+		t.Error("NSID should not be kept")
+	}
+
+	for _, bad := range []string{"bogus", "-1", "65536"} {
+		_, err := parseEDNSPassthrough([]string{bad})
+		if err == nil || !strings.Contains(err.Error(), bad) {
+			t.Error("Expected error mentioning", bad, "got", err)
+		}
+	}
+}
+
+func TestServeDoHEDNSPassthrough(t *testing.T) {
+	mainInit(os.Stdout, os.Stderr)
+	ep, _ := parseEDNSPassthrough([]string{"COOKIE"})
+
+	for _, passthrough := range []ednsPassthrough{nil, ep} {
+		res := &mockResolver{}
+		s := &server{stdout: stdout, local: res, ednsAllowed: passthrough}
+		mw := newMockResponseWriter()
+
+		q := &dns.Msg{}
+		q.SetQuestion("example.net.", dns.TypeA)
+		q.SetEdns0(1232, false)
+		opt := q.IsEdns0()
+		opt.Option = append(opt.Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: "0102030405060708"},
+			&dns.EDNS0_NSID{Code: dns.EDNS0NSID})
+		binary, err := q.Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := http.NewRequest("POST", "http://localhost", bytes.NewReader(binary))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", "application/dns-message")
+		s.serveDoH(mw, r)
+		if mw.statusCode != 0 {
+			t.Fatal("Request failed", mw.statusCode, mw.String())
+		}
+
+		lopt := res.query.IsEdns0()
+		if lopt == nil {
+			t.Fatal("OPT removed from query passed to local resolver", res.query.String())
+		}
+		expect := 0
+		if passthrough != nil {
+			expect = 1
+		}
+		if len(lopt.Option) != expect || (expect == 1 && lopt.Option[0].Option() != dns.EDNS0COOKIE) {
+			t.Error("Wrong options passed to local resolver", lopt.Option)
+		}
+		if s.eventCounters[evEDNS0Filtered] != 1 {
+			t.Error("EDNS0 filtering not counted", s.stats)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+const forwardedForHeader = "X-Forwarded-For"
+
+// trustedProxies are the --trusted-proxy networks whose X-Forwarded-For headers are believed when
+// determining the client address for ECS synthesis.
+type trustedProxies struct {
+	networks
+}
+
+// clientAddr returns the address of the originating client in http.Request.RemoteAddr form. If the
+// immediate peer is a trusted proxy then X-Forwarded-For is walked from right to left to find the
+// first address which is not itself a trusted proxy. Untrusted peers cannot influence the result
+// so a client cannot spoof its address by supplying its own header. Any malformed entry stops the
+// walk at the last address which could be believed.
+func (t trustedProxies) clientAddr(httpReq *http.Request) string {
+	if len(t.networks) == 0 {
+		return httpReq.RemoteAddr
+	}
+	ip, err := parseRemoteAddr(httpReq.RemoteAddr)
+	if err != nil || !t.contains(ip) {
+		return httpReq.RemoteAddr
+	}
+
+	var hops []string
+	for _, v := range httpReq.Header.Values(forwardedForHeader) {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+
+	ra := httpReq.RemoteAddr
+	for ix := len(hops) - 1; ix >= 0; ix-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[ix]))
+		if hop == nil {
+			break
+		}
+		ra = net.JoinHostPort(hop.String(), "0")
+		if !t.contains(hop) {
+			break
+		}
+	}
+
+	return ra
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestClientAddr(t *testing.T) {
+	nets, err := parseNetworks([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp := trustedProxies{nets}
+
+	testCases := []struct {
+		trusted    trustedProxies
+		remoteAddr string
+		xff        []string
+		expect     string
+	}{
+		{trustedProxies{}, "10.0.0.1:443", []string{"192.0.2.1"}, "10.0.0.1:443"},               // None trusted
+		{tp, "198.51.100.1:443", []string{"192.0.2.1"}, "198.51.100.1:443"},                     // Untrusted peer
+		{tp, "10.0.0.1:443", nil, "10.0.0.1:443"},                                               // No header
+		{tp, "10.0.0.1:443", []string{"192.0.2.1"}, "192.0.2.1:0"},                              // Single hop
+		{tp, "10.0.0.1:443", []string{"203.0.113.9, 192.0.2.1, 10.1.1.1"}, "192.0.2.1:0"},       // Spoofed left
+		{tp, "10.0.0.1:443", []string{"203.0.113.9", "192.0.2.1"}, "192.0.2.1:0"},               // Multiple headers
+		{tp, "10.0.0.1:443", []string{"10.2.2.2, 10.1.1.1"}, "10.2.2.2:0"},                      // All trusted
+		{tp, "10.0.0.1:443", []string{"2001:db8::1"}, "[2001:db8::1]:0"},                        // IPv6 client
+		{tp, "10.0.0.1:443", []string{"192.0.2.1, garbage"}, "10.0.0.1:443"},                    // Malformed
+		{tp, "10.0.0.1:443", []string{"192.0.2.1, garbage, 10.1.1.1"}, "10.1.1.1:0"},            // Malformed mid
+		{tp, "[2001:db8::2]:443", []string{"192.0.2.1"}, "[2001:db8::2]:443"},                   // Untrusted IPv6
+		{tp, "noport", []string{"192.0.2.1"}, "noport"},                                         // Bad RemoteAddr
+		{tp, "10.0.0.1:443", []string{" 192.0.2.1 ,  198.51.100.7 "}, "198.51.100.7:0"},         // Whitespace
+		{tp, "10.0.0.1:443", []string{"192.0.2.1,198.51.100.7,10.3.3.3"}, "198.51.100.7:0"},     // No spaces
+		{tp, "10.0.0.1:443", []string{"192.0.2.1", "198.51.100.7, 10.3.3.3"}, "198.51.100.7:0"}, // Mixed
+	}
+
+	for ix, tc := range testCases {
+		req, err := http.NewRequest(http.MethodGet, "https://localhost/dns-query", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.RemoteAddr = tc.remoteAddr
+		for _, v := range tc.xff {
+			req.Header.Add(forwardedForHeader, v)
+		}
+		got := tc.trusted.clientAddr(req)
+		if got != tc.expect {
+			t.Error(ix, "Expected", tc.expect, "got", got)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestInterfaceRebind(t *testing.T) {
+	mainInit(&mutexBytesBuffer{}, &mutexBytesBuffer{})
+	ifis, err := net.Interfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ifi := range ifis {
+		if ifi.Flags&net.FlagLoopback != 0 {
+			cfg.interfaces.Set(ifi.Name)
+			break
+		}
+	}
+	if cfg.interfaces.NArg() == 0 {
+		t.Skip("No loopback interface found")
+	}
+
+	gone := &server{listenAddress: "192.0.2.1:443"} // Never started so stop() is benign
+	ifs := interfaceServers{"192.0.2.1": gone}
+	var started []string
+	start := func(addr string) *server {
+		started = append(started, addr)
+		return &server{listenAddress: addr}
+	}
+
+	servers, err := ifs.rebind([]*server{gone}, start)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if _, ok := ifs["192.0.2.1"]; ok {
+		t.Error("Departed address should have been removed", ifs)
+	}
+	if len(started) == 0 || len(servers) != len(started) {
+		t.Error("Expected only servers on loopback addresses", started, servers)
+	}
+	for _, s := range servers {
+		if strings.HasPrefix(s.listenAddress, "192.0.2.1") {
+			t.Error("Stopped server still in servers list", s.listenAddress)
+		}
+	}
+
+	cfg.interfaces.Set("nosuchinterface0")
+	_, err = ifs.rebind(servers, start)
+	if err == nil || !strings.Contains(err.Error(), "nosuchinterface0") {
+		t.Error("Expected error naming the bogus interface, not", err)
+	}
+}
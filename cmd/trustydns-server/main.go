@@ -2,10 +2,13 @@
 package main
 
 import (
+	"crypto/tls"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"net"
+	"net/http"
 	"os"
 	"runtime"
 	"runtime/pprof"
@@ -17,9 +20,12 @@ import (
 	gops "github.com/google/gops/agent"
 
 	"github.com/markdingo/trustydns/internal/constants"
+	"github.com/markdingo/trustydns/internal/debugserver"
 	"github.com/markdingo/trustydns/internal/osutil"
 	"github.com/markdingo/trustydns/internal/reporter"
+	"github.com/markdingo/trustydns/internal/resolver"
 	"github.com/markdingo/trustydns/internal/resolver/local"
+	"github.com/markdingo/trustydns/internal/resolver/zonefile"
 	"github.com/markdingo/trustydns/internal/tlsutil"
 )
 
@@ -50,6 +56,18 @@ func stopMain() {
 	stopChannel <- syscall.SIGINT
 }
 
+// writeProfiles is called on SIGUSR2 when --profile-dir is set. Failure is not fatal as the process
+// is otherwise unaffected. Note that dir is relative to any --chroot.
+func writeProfiles(dir string) {
+	paths, err := osutil.WriteProfiles(dir, consts.ServerProgramName, time.Now())
+	for _, p := range paths {
+		fmt.Fprintln(stdout, "\nProfile written:", p)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "Error: --profile-dir", err)
+	}
+}
+
 //////////////////////////////////////////////////////////////////////
 // main wrappers make it easy for test programs
 //////////////////////////////////////////////////////////////////////
@@ -88,70 +106,24 @@ func mainExecute(args []string) int {
 		fmt.Fprintln(stdout, consts.ServerProgramName, "Version:", consts.Version)
 		return 0
 	}
-
-	if flagSet.NArg() > 0 {
-		return fatal("Unexpected parameters on the command line", strings.Join(flagSet.Args(), " "))
-	}
-
-	if cfg.logAll {
-		cfg.logClientIn = true
-		cfg.logClientOut = true
-		cfg.logHTTPOut = true
-		cfg.logHTTPIn = true
-		cfg.logLocalOut = true
-		cfg.logLocalIn = true
-		cfg.logTLSErrors = true
-	}
-
-	// Validate ECS settings
-
-	// We need to know if either of the prefixlen values have been set and thus we should set
-	// --ecs-set if it's not already set. We can't just look at the value as that could easily
-	// be the default which is only meaningful if ecs-set is exlicitly set.
-
-	flagSet.Visit(func(f *flag.Flag) {
-		if f.Name == "ecs-set-ipv4-prefixlen" || f.Name == "ecs-set-ipv6-prefixlen" {
-			cfg.ecsSet = true
-		}
-	})
-
-	if cfg.ecsSet {
-		if cfg.ecsSetIPv4PrefixLen < 0 || cfg.ecsSetIPv4PrefixLen > 32 {
-			return fatal("--ecs-set-ipv4-prefixlen", cfg.ecsSetIPv4PrefixLen,
-				"must be between 0 and 32")
-		}
-		if cfg.ecsSetIPv6PrefixLen < 0 || cfg.ecsSetIPv6PrefixLen > 128 {
-			return fatal("--ecs-set-ipv6-prefixlen", cfg.ecsSetIPv6PrefixLen,
-				"must be between 0 and 128")
-		}
+	if cfg.versionJSON {
+		b, _ := json.Marshal(constants.GetBuildInfo(consts.ServerProgramName)) // Cannot fail with only strings
+		fmt.Fprintln(stdout, string(b))
+		return 0
 	}
 
-	var reporters []reporter.Reporter // Track of all reportables for periodic reporting
-	var servers []*server             // Track of all servers so we can shut then down
-
-	// Validate local resolver configuration
-
-	if len(cfg.resolvConf) == 0 {
-		return fatal("Must supplied a resolv.conf file with -c")
+	rs, ret := validate()
+	if ret != 0 {
+		return ret
 	}
-	resolver, err := local.New(local.Config{ResolvConfPath: cfg.resolvConf})
-	if err != nil {
-		return fatal(err)
-	}
-	reporters = append(reporters, resolver)
-
-	// Create a TLS configuration for constructing HTTPS transport. This is where we load in our
-	// cert/key files and possibly enable verification of client certs.
-
-	tlsConfig, err := tlsutil.NewServerTLSConfig(cfg.tlsUseSystemRootCAs, cfg.tlsCAFiles.Args(),
-		cfg.tlsServerCertFiles.Args(), cfg.tlsServerKeyFiles.Args())
-	if err != nil {
-		return fatal(err)
+	if cfg.check {
+		fmt.Fprintln(stdout, consts.ServerProgramName, "Configuration OK")
+		return 0
 	}
 
-	if cfg.listenAddresses.NArg() == 0 { // Use wildcard if none supplied
-		cfg.listenAddresses.Set(defaultListenAddress)
-	}
+	var servers []*server // Track of all servers so we can shut then down
+	reporters := append([]reporter.Reporter{}, rs.reporters...)
+	tlsConfig := rs.tlsConfig
 
 	// Start CPU profiling now that most error checking is complete
 
@@ -185,6 +157,18 @@ func mainExecute(args []string) int {
 		}
 	}
 
+	// A debug listener failure is not fatal as a graceful restart necessarily starts the new
+	// process while the old one still holds the --debug-listen address.
+
+	if len(cfg.debugListen) > 0 {
+		ds, err := debugserver.Start(cfg.debugListen)
+		if err != nil {
+			fmt.Fprintln(stderr, "Error: --debug-listen", err)
+		} else {
+			defer ds.Close()
+		}
+	}
+
 	// Start a server for each listen address
 
 	if cfg.verbose {
@@ -193,12 +177,55 @@ func mainExecute(args []string) int {
 			fmt.Fprintln(stdout, "Accepting TLS CN:", cn)
 		}
 		fmt.Fprintln(stdout, "Local resolution:", cfg.resolvConf)
+		for _, path := range cfg.resolvConfs.Args() {
+			fmt.Fprintln(stdout, "Local resolution:", path)
+		}
 	}
 
-	errorChannel := make(chan error, cfg.listenAddresses.NArg())
+	// A process started by restart() inherits the listen sockets of its parent
+
+	inherited, ready, err := inheritedListeners()
+	if err != nil {
+		return fatal("Restart", err)
+	}
+
+	var activated []*server
+	if cfg.systemd && inherited != nil { // systemd sockets were passed on by restart()
+		for addr, l := range inherited {
+			if addr == cfg.unixSocket { // Picked up with the --unix-socket server below
+				continue
+			}
+			activated = append(activated, &server{stdout: stdout, local: rs.resolver, listenAddress: addr,
+				listener: l, trusted: rs.trustedProxies, ecsExempt: rs.ecsExempt, debugMeta: rs.debugMeta,
+				ednsAllowed: rs.ednsPassthrough, cookies: rs.cookies})
+			delete(inherited, addr)
+		}
+	} else if cfg.systemd {
+		files, err := osutil.ListenFDs()
+		if err != nil {
+			return fatal("--systemd", err)
+		}
+		if len(files) == 0 {
+			return fatal("--systemd set but no sockets were passed by systemd")
+		}
+		activated, err = activatedServers(files, rs)
+		if err != nil {
+			return fatal("--systemd", err)
+		}
+	}
+
+	errorChannel := make(chan error, len(activated)+cfg.listenAddresses.NArg()+len(rs.interfaceAddresses)+1)
 	wg := &sync.WaitGroup{} // Wait on all servers
 
-	for _, addr := range cfg.listenAddresses.Args() {
+	for _, s := range activated {
+		s.start(tlsConfig, errorChannel, wg)
+		if cfg.verbose {
+			fmt.Fprintln(stdout, "Listening:", s.listenName())
+		}
+		servers = append(servers, s)
+	}
+
+	startAddress := func(addr string) *server {
 		ip := net.ParseIP(addr) // We have to wrap unadorned ipv6 addresses so we can append port
 		if ip != nil && ip.To16() != nil {
 			addr = "[" + addr + "]" // It's naked, so wrap it
@@ -209,15 +236,49 @@ func mainExecute(args []string) int {
 			addr += ":" + consts.HTTPSDefaultPort
 		}
 
-		s := &server{stdout: stdout, local: resolver, listenAddress: addr}
+		s := &server{stdout: stdout, local: rs.resolver, listenAddress: addr, trusted: rs.trustedProxies,
+			ecsExempt: rs.ecsExempt, debugMeta: rs.debugMeta,
+			ednsAllowed: rs.ednsPassthrough, cookies: rs.cookies}
+		if l, ok := inherited[addr]; ok {
+			s.listener = l
+			delete(inherited, addr)
+		}
+		s.start(tlsConfig, errorChannel, wg)
+		if cfg.verbose {
+			fmt.Fprintln(stdout, "Listening:", s.listenName())
+		}
+
+		return s
+	}
+
+	for _, addr := range cfg.listenAddresses.Args() {
+		servers = append(servers, startAddress(addr))
+	}
+
+	ifServers := interfaceServers{}
+	for _, addr := range rs.interfaceAddresses {
+		ifServers[addr] = startAddress(addr)
+		servers = append(servers, ifServers[addr])
+	}
+	if len(cfg.unixSocket) > 0 {
+		s := &server{stdout: stdout, local: rs.resolver, listenAddress: cfg.unixSocket, unixSocket: true,
+			trusted: rs.trustedProxies, ecsExempt: rs.ecsExempt, debugMeta: rs.debugMeta,
+			ednsAllowed: rs.ednsPassthrough, cookies: rs.cookies}
+		if l, ok := inherited[cfg.unixSocket]; ok {
+			s.listener = &unixListener{Listener: l}
+			delete(inherited, cfg.unixSocket)
+		}
 		s.start(tlsConfig, errorChannel, wg)
 		if cfg.verbose {
 			fmt.Fprintln(stdout, "Listening:", s.listenName())
 		}
-		reporters = append(reporters, s)
-		reporters = append(reporters, s.connTrk)
 		servers = append(servers, s)
 	}
+	reporters = append(reporters, serverReporters(servers)...)
+	reporter.Publish(consts.ServerProgramName, reporters)
+	for _, l := range inherited { // Close any no longer needed, e.g. an --interface address has gone
+		l.Close()
+	}
 
 	// Constrain the process via setuid/setgid/chroot. This is a no-op call if all parameters
 	// are empty strings.
@@ -237,10 +298,15 @@ func mainExecute(args []string) int {
 	// on, we delegate the Constrain call to a go-routine.
 	//
 	// Note that this is not a problem with DNS listening as miekg/dns.Server offers a notify
-	// function which is called once the socket has been opened.
+	// function which is called once the socket has been opened. Nor is it a problem with
+	// --systemd as all sockets are open before we start.
 
+	constrainDelay := 3 * time.Second // Hopefully absurdly large but also not too huge a security window
+	if cfg.systemd {
+		constrainDelay = 0
+	}
 	go func(setuidName, setgidName, chrootDir string, verbose bool, stdout io.Writer) {
-		time.Sleep(3 * time.Second) // Hopefully absurdly large but also not too huge a security window
+		time.Sleep(constrainDelay)
 		err := osutil.Constrain(setuidName, setgidName, chrootDir)
 		if err != nil {
 			errorChannel <- err // Force main go-routine to exit
@@ -251,9 +317,17 @@ func mainExecute(args []string) int {
 		}
 	}(cfg.setuidName, cfg.setgidName, cfg.chrootDir, cfg.verbose, stdout)
 
+	if rs.warmer != nil {
+		rs.warmer.start()
+	}
+
 	// Loop forever giving periodic status reports and checking for a termination event.
 
 	mainState(started) // Tell testers we're up and running
+	if ready != nil {  // Tell our parent process
+		ready.WriteString(restartReady)
+		ready.Close()
+	}
 	nextStatusIn := nextInterval(time.Now(), cfg.statusInterval)
 
 Running:
@@ -264,12 +338,39 @@ Running:
 				statusReport("User1", false, reporters)
 				break
 			}
+			if osutil.IsSignalUSR2(s) && len(cfg.profileDir) > 0 {
+				writeProfiles(cfg.profileDir)
+				break
+			}
+			if osutil.IsSignalUSR2(s) {
+				err := restart(servers)
+				if err != nil {
+					fmt.Fprintln(stderr, "Error: Restart failed:", err)
+					break
+				}
+				if cfg.verbose {
+					fmt.Fprintln(stdout, "\nRestart: new process is ready")
+				}
+				break Running // Drain and exit as the new process is now accepting
+			}
+			if osutil.IsSignalHUP(s) && cfg.interfaces.NArg() > 0 {
+				servers, err = ifServers.rebind(servers, startAddress)
+				if err != nil {
+					fmt.Fprintln(stderr, "Error: --interface", err)
+				}
+				reporters = append(append([]reporter.Reporter{}, rs.reporters...), serverReporters(servers)...)
+				reporter.Publish(consts.ServerProgramName, reporters)
+				break
+			}
 			if cfg.verbose {
 				fmt.Fprintln(stdout, "\nSignal", s)
 			}
-			break Running // All signals bar USR1 cause loop exit
+			break Running // All other signals cause loop exit
 
 		case err := <-errorChannel:
+			if err == http.ErrServerClosed {
+				break // A server stopped by rebind
+			}
 			return fatal(err) // No cleanup if we get a server startup error
 
 		case <-time.After(nextStatusIn):
@@ -282,11 +383,21 @@ Running:
 
 	// Shutting down
 
-	for _, s := range servers {
-		s.stop()
+	if rs.warmer != nil {
+		rs.warmer.stop()
 	}
+	drain(servers, reporters)
 	mainState(stopped) // Tell testers we've stopped accepting requests
-	wg.Wait()          // Wait for all servers to completely shut down
+
+	// Wait for all servers to completely shut down while draining errorChannel as servers
+	// started by rebind may have exceeded its capacity.
+
+	go func() {
+		wg.Wait()
+		close(errorChannel)
+	}()
+	for range errorChannel {
+	}
 
 	if cfg.verbose {
 		statusReport("Status", true, reporters) // One last report prior to exiting
@@ -306,6 +417,257 @@ Running:
 	return 0
 }
 
+// resources are the validated objects constructed from the command-line options which are needed
+// to start serving.
+type resources struct {
+	resolver  resolver.Resolver
+	tlsConfig *tls.Config
+	reporters []reporter.Reporter
+
+	interfaceAddresses []string // Current addresses of --interface
+	warmer             *warmer  // May be nil
+	trustedProxies     trustedProxies
+	ecsExempt          networks // Clients whose queries are passed through without ECS changes
+	debugMeta          networks // Clients allowed to ask for resolution meta data
+	ednsPassthrough    ednsPassthrough
+	cookies            *cookieSecrets // nil unless --enable-server-cookies
+}
+
+// validate checks all command-line options, loads the TLS files and constructs the local
+// resolver. No sockets are opened so this is all that --check runs. A non-zero return means the
+// error has already been printed.
+func validate() (*resources, int) {
+	if flagSet.NArg() > 0 {
+		return nil, fatal("Unexpected parameters on the command line", strings.Join(flagSet.Args(), " "))
+	}
+
+	if cfg.logAll {
+		cfg.logClientIn = true
+		cfg.logDetail = true
+		cfg.logClientOut = true
+		cfg.logHTTPOut = true
+		cfg.logHTTPIn = true
+		cfg.logLocalOut = true
+		cfg.logLocalIn = true
+		cfg.logTLSErrors = true
+	}
+
+	// Validate ECS settings
+
+	// We need to know if either of the prefixlen values have been set and thus we should set
+	// --ecs-set if it's not already set. We can't just look at the value as that could easily
+	// be the default which is only meaningful if ecs-set is exlicitly set.
+
+	flagSet.Visit(func(f *flag.Flag) {
+		if f.Name == "ecs-set-ipv4-prefixlen" || f.Name == "ecs-set-ipv6-prefixlen" {
+			cfg.ecsSet = true
+		}
+	})
+
+	if cfg.ecsSet {
+		if cfg.ecsSetIPv4PrefixLen < 0 || cfg.ecsSetIPv4PrefixLen > 32 {
+			return nil, fatal("--ecs-set-ipv4-prefixlen", cfg.ecsSetIPv4PrefixLen,
+				"must be between 0 and 32")
+		}
+		if cfg.ecsSetIPv6PrefixLen < 0 || cfg.ecsSetIPv6PrefixLen > 128 {
+			return nil, fatal("--ecs-set-ipv6-prefixlen", cfg.ecsSetIPv6PrefixLen,
+				"must be between 0 and 128")
+		}
+	}
+
+	if cfg.ecsMaxIPv4PrefixLen < 0 || cfg.ecsMaxIPv4PrefixLen > 32 {
+		return nil, fatal("--ecs-max-ipv4-prefixlen", cfg.ecsMaxIPv4PrefixLen, "must be between 0 and 32")
+	}
+	if cfg.ecsMaxIPv6PrefixLen < 0 || cfg.ecsMaxIPv6PrefixLen > 128 {
+		return nil, fatal("--ecs-max-ipv6-prefixlen", cfg.ecsMaxIPv6PrefixLen, "must be between 0 and 128")
+	}
+
+	rs := &resources{}
+
+	// Validate local resolver configuration
+
+	if len(cfg.resolvConf) == 0 {
+		return nil, fatal("Must supplied a resolv.conf file with -c")
+	}
+	if cfg.reportFormat != "text" && cfg.reportFormat != "json" {
+		return nil, fatal("--report-format", cfg.reportFormat, "must be one of text or json")
+	}
+	switch cfg.anyPolicy {
+	case anyPolicyForward, anyPolicyHINFO, anyPolicyRefuse:
+	default:
+		return nil, fatal("--any-policy", cfg.anyPolicy, "must be one of forward, hinfo or refuse")
+	}
+	if len(cfg.debugListen) > 0 {
+		if err := debugserver.CheckAddress(cfg.debugListen); err != nil {
+			return nil, fatal("--debug-listen", err)
+		}
+	}
+	if cfg.maxRequestSize < 0 {
+		return nil, fatal("--max-request-size", cfg.maxRequestSize, "cannot be negative")
+	}
+
+	if cfg.udpBufferSize < 512 || cfg.udpBufferSize > 65535 {
+		return nil, fatal("--udp-buffer-size", cfg.udpBufferSize, "must be between 512 and 65535")
+	}
+	if cfg.hybridLocal && cfg.reorderLocal {
+		return nil, fatal("--hybrid-local and --reorder-local are mutually exclusive")
+	}
+	if cfg.parallelLocal < 0 {
+		return nil, fatal("--parallel-local", cfg.parallelLocal, "cannot be negative")
+	}
+	if cfg.maxTTLOnError < 0 {
+		return nil, fatal("--max-ttl-on-error", cfg.maxTTLOnError, "cannot be negative")
+	}
+	if cfg.maxTTLOnError > 0 && cfg.maxTTLOnError < time.Second {
+		return nil, fatal("--max-ttl-on-error", cfg.maxTTLOnError, "must be at least one second")
+	}
+	failurePolicy := local.FailureError
+	if cfg.maxTTLOnError > 0 {
+		failurePolicy = local.FailureLastKnownGood
+	}
+
+	// Additional --resolv-conf files are chained behind -c in priority order. Each resolver is
+	// named after its file so their reports can be told apart.
+
+	var chain localChain
+	for _, path := range append([]string{cfg.resolvConf}, cfg.resolvConfs.Args()...) {
+		var name string
+		if cfg.resolvConfs.NArg() > 0 {
+			name = path
+		}
+		lr, err := local.New(local.Config{ResolvConfPath: path, Name: name,
+			UDPBufferSize: cfg.udpBufferSize, ParallelQueries: cfg.parallelLocal,
+			HybridBestServer: cfg.hybridLocal, ReorderBySuccess: cfg.reorderLocal,
+			FailureResponse: failurePolicy, FailureTTL: cfg.maxTTLOnError})
+		if err != nil {
+			return nil, fatal(err)
+		}
+		chain = append(chain, lr)
+		rs.reporters = append(rs.reporters, lr)
+	}
+	rs.resolver = chain[0]
+	if len(chain) > 1 {
+		rs.resolver = chain
+	}
+
+	// Zones from --zone-file take priority over all the resolv.conf files
+
+	if cfg.zoneFiles.NArg() > 0 {
+		zc := zoneChain{next: rs.resolver}
+		for _, path := range cfg.zoneFiles.Args() {
+			zr, err := zonefile.New(zonefile.Config{Path: path})
+			if err != nil {
+				return nil, fatal("--zone-file", err)
+			}
+			zc.zones = append(zc.zones, zr)
+			rs.reporters = append(rs.reporters, zr)
+		}
+		rs.resolver = zc
+	}
+
+	if cfg.injectDelay < 0 {
+		return nil, fatal("--inject-delay", cfg.injectDelay, "cannot be negative")
+	}
+	if cfg.injectDelayFraction < 0 || cfg.injectDelayFraction > 1 {
+		return nil, fatal("--inject-delay-fraction", cfg.injectDelayFraction, "must be between 0.0 and 1.0")
+	}
+	if cfg.injectDelay > 0 && cfg.verbose {
+		fmt.Fprintln(stdout, "Warning: --inject-delay", cfg.injectDelay, "is for testing only")
+	}
+
+	if cfg.slowQueryThreshold < 0 {
+		return nil, fatal("--slow-query-threshold", cfg.slowQueryThreshold, "cannot be negative")
+	}
+	if cfg.reapIdle < 0 {
+		return nil, fatal("--reap-idle", cfg.reapIdle, "cannot be negative")
+	}
+
+	if cfg.warmInterval < 0 {
+		return nil, fatal("--warm-interval", cfg.warmInterval, "cannot be negative")
+	}
+	if len(cfg.warmFile) > 0 {
+		entries, err := loadWarmFile(cfg.warmFile)
+		if err != nil {
+			return nil, fatal("--warm-file", err)
+		}
+		rs.warmer = newWarmer(entries, rs.resolver, cfg.warmInterval)
+		rs.reporters = append(rs.reporters, rs.warmer)
+	}
+
+	// Create a TLS configuration for constructing HTTPS transport. This is where we load in our
+	// cert/key files and possibly enable verification of client certs.
+
+	var err error
+	rs.tlsConfig, err = tlsutil.NewServerTLSConfig(cfg.tlsUseSystemRootCAs, cfg.tlsCAFiles.Args(),
+		cfg.tlsServerCertFiles.Args(), cfg.tlsServerKeyFiles.Args())
+	if err != nil {
+		return nil, fatal(err)
+	}
+
+	nets, err := parseNetworks(cfg.trustedProxies.Args())
+	if err != nil {
+		return nil, fatal("--trusted-proxy", err)
+	}
+	rs.trustedProxies = trustedProxies{nets}
+
+	rs.ecsExempt, err = parseNetworks(cfg.ecsExempt.Args())
+	if err != nil {
+		return nil, fatal("--ecs-exempt", err)
+	}
+
+	rs.debugMeta, err = parseNetworks(cfg.debugMeta.Args())
+	if err != nil {
+		return nil, fatal("--debug-meta", err)
+	}
+
+	rs.ednsPassthrough, err = parseEDNSPassthrough(cfg.ednsPassthrough.Args())
+	if err != nil {
+		return nil, fatal("--edns-passthrough", err)
+	}
+
+	if cfg.serverCookies {
+		rs.cookies, err = newCookieSecrets()
+		if err != nil {
+			return nil, fatal("--enable-server-cookies", err)
+		}
+	}
+
+	if _, err := osutil.ListenConfig(cfg.reusePort); err != nil {
+		return nil, fatal("--reuse-port", err)
+	}
+
+	if cfg.interfaces.NArg() > 0 {
+		rs.interfaceAddresses, err = interfaceAddresses(cfg.interfaces.Args())
+		if err != nil {
+			return nil, fatal("--interface", err)
+		}
+		if len(rs.interfaceAddresses) == 0 {
+			return nil, fatal("--interface", cfg.interfaces.String(), "has no usable addresses")
+		}
+	}
+
+	if cfg.systemd {
+		if cfg.listenAddresses.NArg() > 0 || cfg.interfaces.NArg() > 0 {
+			return nil, fatal("Cannot have --systemd with -A listen addresses or --interface")
+		}
+	} else if cfg.listenAddresses.NArg() == 0 && cfg.interfaces.NArg() == 0 && len(cfg.unixSocket) == 0 {
+		// Use wildcard if no listen addresses supplied
+		cfg.listenAddresses.Set(defaultListenAddress)
+	}
+
+	return rs, 0
+}
+
+// serverReporters returns the servers and their connection trackers as a list of reporters.
+func serverReporters(servers []*server) []reporter.Reporter {
+	var reporters []reporter.Reporter
+	for _, s := range servers {
+		reporters = append(reporters, s, s.connTrk)
+	}
+
+	return reporters
+}
+
 // nextInterval calculates the duration to now+modulo interval. If now is 00:01:17 and the interval
 // is 15m then the returned duration is 13m43s which is the distance to the 00:15:00. The idea is to
 // provide a wait/sleep value which gets the caller to the next interval tick-over.
@@ -321,6 +683,10 @@ func uptime() string {
 
 // statusReport prints stats about the server and all known reporters
 func statusReport(what string, resetCounters bool, reporters []reporter.Reporter) {
+	if cfg.reportFormat == "json" {
+		statusReportJSON(what, resetCounters, reporters)
+		return
+	}
 	fmt.Fprintln(stdout, "Status Up:", consts.ServerProgramName, consts.Version, uptime())
 	for _, r := range reporters {
 		reps := strings.Split(r.Report(resetCounters), "\n")
@@ -331,3 +697,37 @@ func statusReport(what string, resetCounters bool, reporters []reporter.Reporter
 		}
 	}
 }
+
+// statusReportJSON prints one JSON object per reporter so that each line can be consumed
+// independently. Reporters which cannot produce JSON have their text report wrapped instead.
+func statusReportJSON(what string, resetCounters bool, reporters []reporter.Reporter) {
+	type jsonReport struct {
+		What    string          `json:"what"`
+		Program string          `json:"program"`
+		Version string          `json:"version"`
+		Uptime  string          `json:"uptime"`
+		Name    string          `json:"name"`
+		Report  json.RawMessage `json:"report,omitempty"`
+		Text    string          `json:"text,omitempty"`
+	}
+	for _, r := range reporters {
+		jr := jsonReport{What: what, Program: consts.ServerProgramName, Version: consts.Version, Uptime: uptime(),
+			Name: r.Name()}
+		if mr, ok := r.(reporter.MetricsReporter); ok {
+			b, err := mr.ReportJSON(resetCounters)
+			if err != nil {
+				fmt.Fprintln(stderr, "Error:", r.Name(), err)
+				continue
+			}
+			jr.Report = b
+		} else {
+			jr.Text = strings.TrimSpace(r.Report(resetCounters))
+		}
+		b, err := json.Marshal(jr)
+		if err != nil {
+			fmt.Fprintln(stderr, "Error:", r.Name(), err)
+			continue
+		}
+		fmt.Fprintln(stdout, string(b))
+	}
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
 	"strings"
 	"sync"
@@ -43,6 +45,10 @@ type mainTestCase struct {
 	stderr      string        // Expected stderr string
 }
 
+// tempDirArg in test case args is replaced with a per-test temporary directory so that generated
+// files, such as profiles, do not end up in the source tree.
+const tempDirArg = "@tempdir@"
+
 // The -A 255.... arguments are present to cause mainExecute() to fail when it starts *after*
 // exercising the code coverage area intended. Yeah a bit of a hack, but the go testing framework is
 // not well suited to running command-line tests that involve running then killing.
@@ -66,8 +72,8 @@ var mainTestCases = []mainTestCase{
 		[]string{"Starting", "Exiting"}, ""},
 
 	{"Good profile files",
-		false, 100 * time.Millisecond, []string{"--cpu-profile", "testdata/cpu",
-			"--mem-profile", "testdata/mem", "-v", "-A", "127.0.0.1:63085", "-c", "testdata/resolv.conf"},
+		false, 100 * time.Millisecond, []string{"--cpu-profile", tempDirArg + "/cpu",
+			"--mem-profile", tempDirArg + "/mem", "-v", "-A", "127.0.0.1:63085", "-c", "testdata/resolv.conf"},
 		[]string{"Starting", "Exiting"}, ""},
 
 	{"Logging",
@@ -93,6 +99,10 @@ func TestMain(t *testing.T) {
 			}
 
 			args := append([]string{"trustydns-server"}, tc.args...)
+			tempDir := t.TempDir()
+			for ix := range args {
+				args[ix] = strings.Replace(args[ix], tempDirArg, tempDir, 1)
+			}
 			out := &mutexBytesBuffer{}
 			err := &mutexBytesBuffer{}
 			mainInit(out, err)
@@ -202,3 +212,54 @@ func TestUSR1(t *testing.T) {
 		t.Error("Expected 'User1 Listener:', got", outStr)
 	}
 }
+
+// Test that SIGUSR2 writes profiles when --profile-dir is set
+func TestUSR2Profile(t *testing.T) {
+	out := &mutexBytesBuffer{}
+	err := &mutexBytesBuffer{}
+	dir := t.TempDir()
+	args := []string{"trustydns-server", "-A", "127.0.0.1:60443", "--profile-dir", dir}
+	mainInit(out, err) // Start up quietly
+	go func() {
+		stopChannel <- syscall.SIGUSR2
+		time.Sleep(time.Millisecond * 200) // Give it time to process
+		stopMain()
+	}()
+	ec := mainExecute(args)
+	outStr := out.String()
+	errStr := err.String()
+	if ec != 0 {
+		t.Error("Expected zero exit return, not", ec, errStr)
+	}
+	if strings.Count(outStr, "Profile written: "+dir) != 2 {
+		t.Error("Expected two 'Profile written:' lines, got", outStr)
+	}
+}
+
+// Test that --debug-listen serves the published reporters via expvar
+func TestDebugListen(t *testing.T) {
+	out := &mutexBytesBuffer{}
+	err := &mutexBytesBuffer{}
+	args := []string{"trustydns-server", "-A", "127.0.0.1:60443", "--debug-listen", "127.0.0.1:60460"}
+	mainInit(out, err) // Start up quietly
+	var body string
+	go func() {
+		for ix := 0; ix < 10 && !isMain(started); ix++ {
+			time.Sleep(time.Millisecond * 200)
+		}
+		resp, err := http.Get("http://127.0.0.1:60460/debug/vars")
+		if err == nil {
+			b, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			body = string(b)
+		}
+		stopMain()
+	}()
+	ec := mainExecute(args)
+	if ec != 0 {
+		t.Error("Expected zero exit return, not", ec, err.String())
+	}
+	if !strings.Contains(body, `"trustydns-server": [{"name":`) {
+		t.Error("Expected published reporters in /debug/vars, got", body)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// networks is a list of IP networks constructed from command-line options such as --trusted-proxy
+// and --ecs-exempt.
+type networks []*net.IPNet
+
+// parseNetworks converts a list of CIDRs into networks. A bare IP address is treated as a single
+// host network.
+func parseNetworks(cidrs []string) (networks, error) {
+	var nets networks
+	for _, c := range cidrs {
+		if !strings.Contains(c, "/") {
+			ip := net.ParseIP(c)
+			if ip == nil {
+				return nil, fmt.Errorf("Invalid IP address or CIDR: %s", c)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+				bits = 8 * net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+
+	return nets, nil
+}
+
+// contains returns true if ip is within any of the networks.
+func (t networks) contains(ip net.IP) bool {
+	for _, n := range t {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
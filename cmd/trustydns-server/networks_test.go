@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseNetworks(t *testing.T) {
+	nets, err := parseNetworks([]string{"10.0.0.0/8", "192.0.2.1", "2001:db8::/32", "::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nets) != 4 {
+		t.Fatal("Expected four networks, not", len(nets))
+	}
+	if ones, _ := nets[1].Mask.Size(); ones != 32 {
+		t.Error("Bare IPv4 address should be a /32, not", ones)
+	}
+	if ones, _ := nets[3].Mask.Size(); ones != 128 {
+		t.Error("Bare IPv6 address should be a /128, not", ones)
+	}
+	if !nets.contains(net.ParseIP("10.9.8.7")) || !nets.contains(net.ParseIP("::1")) {
+		t.Error("contains() missed an address within the networks")
+	}
+	if nets.contains(net.ParseIP("192.0.2.2")) {
+		t.Error("contains() matched an address outside the networks")
+	}
+
+	for _, bad := range []string{"10.0.0.0/33", "notanip", "192.0.2"} {
+		_, err = parseNetworks([]string{bad})
+		if err == nil {
+			t.Error("Expected error return from", bad)
+		}
+	}
+}
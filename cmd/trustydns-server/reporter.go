@@ -1,18 +1,31 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"time"
+
+	"github.com/markdingo/trustydns/internal/reporter"
+	"github.com/markdingo/trustydns/internal/sizehistogram"
+	"github.com/markdingo/trustydns/internal/topcounter"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	reportQueryTypes = 5 // Number of most frequent query types listed in the report
+	reportRcodes     = 5 // Number of most frequent response rcodes listed in the report
 )
 
 // addSuccessStats bumps the success counter as well as total duration which are used to generate
 // reports. All event settings for the request are transferred to counters.
-func (t *server) addSuccessStats(latency time.Duration, evs events) {
+func (t *server) addSuccessStats(latency time.Duration, size int, evs events) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
 	t.successCount++
 	t.totalLatency += latency
+	t.sizes.Add(size)
 	for ix := 0; ix < len(evs); ix++ {
 		if evs[ix] {
 			t.eventCounters[ix]++
@@ -33,15 +46,37 @@ func (t *server) addFailureStats(ix serFailureIndex, evs events) {
 	}
 }
 
+// addQueryTypeStats counts the qtype of an unpacked query. Queries lacking a question are ignored.
+func (t *server) addQueryTypeStats(dnsQ *dns.Msg) {
+	if len(dnsQ.Question) == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.qtypes.Add(dnsQ.Question[0].Qtype)
+}
+
+// addRcodeStats counts the rcode of a DNS response returned to the client
+func (t *server) addRcodeStats(rcode int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.rcodes.Add(uint16(rcode))
+}
+
 func (t *server) Name() string {
 	return "Listener"
 }
 
 func (t *server) listenName() string {
 	s := "("
-	if cfg.tlsServerKeyFiles.NArg() > 0 {
+	switch {
+	case t.unixSocket:
+		s += "HTTP on unix:"
+	case cfg.tlsServerKeyFiles.NArg() > 0:
 		s += "HTTPS on "
-	} else {
+	default:
 		s += "HTTP on "
 	}
 	s += t.listenAddress + ")"
@@ -52,37 +87,103 @@ func (t *server) listenName() string {
 /*
 
 Reporter Output:
-                            Error Counters
-req=1 ok=0 (0/0/120/120/0/120) al=0.000 errs=1 (0/1/0/0/0/0/0/0/0/0/0/0) Concurrency=1 listenName
-    ^    ^  ^ ^ ^   ^   ^ ^       ^          ^  ^ ^ ^ ^ ^ ^ ^ ^ ^ ^ ^ ^              ^
-    |    |  | | |   |   | |       |          |  | | | | | | | | | | | |              |
-    |    |  | | |   |   | |       |          |  | | | | | | | | | | | |              +--Peak inbound HTTP
-    |    |  | | |   |   | |       |          |  | | | | | | | | | | | +--QueryParamMissing
-    |    |  | | |   |   | |       |          |  | | | | | | | | | | +--LocalResolutionFailed
-    |    |  | | |   |   | |       |          |  | | | | | | | | | +--HTTPWriterFailed
-    |    |  | | |   |   | |       |          |  | | | | | | | | +--FailureListSize
-    |    |  | | |   |   | |       |          |  | | | | | | | +--ECSSynthesisFailed
-    |    |  | | |   |   | |       |          |  | | | | | | +--DNSUnpackRequestFailed
-    |    |  | | |   |   | |       |          |  | | | | | +--DNSPackResponseFailed
-    |    |  | | |   |   | |       |          |  | | | | +--ClientTLSBad
-    |    |  | | |   |   | |       |          |  | | | +--BodyReadError
-    |    |  | | |   |   | |       |          |  | | +--BadQueryParamDecode
-    |    |  | | |   |   | |       |          |  | +--BadPrefixLengths
-    |    |  | | |   |   | |       |          |  +--BadContentType
-    |    |  | | |   |   | |       |          +--Total Bad Requests
-    |    |  | | |   |   | |       +--Average resolution latency
-    |    |  | | |   |   | +--evPadding
-    |    |  | | |   |   +--evECSv6Synth
-    |    |  | | |   +--evECSv4Synth
+                              Error Counters
+req=1 ok=0 (0/0/0/0/0/0/0/0/0/0/0/0/0/0/0) al=0.000 errs=1 (0/0/1/0/0/0/0/0/0/0/0/0/0/0/0) Concurrency=1 listenName
+    ^    ^  ^ ^ ^ ^ ^ ^ ^ ^ ^ ^ ^ ^ ^ ^ ^     ^          ^  ^ ^ ^ ^ ^ ^ ^ ^ ^ ^ ^ ^ ^ ^ ^              ^
+    |    |  | | | | | | | | | | | | | | |     |          |  | | | | | | | | | | | | | | |              |
+    |    |  | | | | | | | | | | | | | | |     |          |  | | | | | | | | | | | | | | |              +--Peak inbound HTTP
+    |    |  | | | | | | | | | | | | | | |     |          |  | | | | | | | | | | | | | | +--RequestTooLarge
+    |    |  | | | | | | | | | | | | | | |     |          |  | | | | | | | | | | | | | +--QueryParamMissing
+    |    |  | | | | | | | | | | | | | | |     |          |  | | | | | | | | | | | | +--LocalResolutionFailed
+    |    |  | | | | | | | | | | | | | | |     |          |  | | | | | | | | | | | +--HTTPWriterFailed
+    |    |  | | | | | | | | | | | | | | |     |          |  | | | | | | | | | | +--ECSSynthesisFailed
+    |    |  | | | | | | | | | | | | | | |     |          |  | | | | | | | | | +--DNSUnpackRequestFailed
+    |    |  | | | | | | | | | | | | | | |     |          |  | | | | | | | | +--DNSPackResponseFailed
+    |    |  | | | | | | | | | | | | | | |     |          |  | | | | | | | +--ClientTLSBad
+    |    |  | | | | | | | | | | | | | | |     |          |  | | | | | | +--BodyReadError
+    |    |  | | | | | | | | | | | | | | |     |          |  | | | | | +--BadQueryParamDecode
+    |    |  | | | | | | | | | | | | | | |     |          |  | | | | +--BadQueryName
+    |    |  | | | | | | | | | | | | | | |     |          |  | | | +--BadPrefixLengths
+    |    |  | | | | | | | | | | | | | | |     |          |  | | +--BadMethod
+    |    |  | | | | | | | | | | | | | | |     |          |  | +--BadCookie
+    |    |  | | | | | | | | | | | | | | |     |          |  +--BadContentType
+    |    |  | | | | | | | | | | | | | | |     |          +--Total Bad Requests
+    |    |  | | | | | | | | | | | | | | |     +--Average resolution latency
+    |    |  | | | | | | | | | | | | | | +--evCookie
+    |    |  | | | | | | | | | | | | | +--evSlow
+    |    |  | | | | | | | | | | | | +--evAny
+    |    |  | | | | | | | | | | | +--evEDNS0Filtered
+    |    |  | | | | | | | | | | +--evDebugMeta
+    |    |  | | | | | | | | | +--evChaos
+    |    |  | | | | | | | | +--evECSEcho
+    |    |  | | | | | | | +--evRoundtripMismatch
+    |    |  | | | | | | +--evMinimal
+    |    |  | | | | | +--evPadding
+    |    |  | | | | +--evECSv6Synth
+    |    |  | | | +--evECSv4Synth
     |    |  | | +--evEDNS0Removed
     |    |  | +--evTsig
     |    |  +--evGet
     |    +--Good Requests
     +--Total Requests
 
+Qtypes: A=10 AAAA=6 HTTPS=3 PTR=1 MX=1 other=2
+Rcodes: NOERROR=19 NXDOMAIN=3 SERVFAIL=1
+Sizes: 128=12 256=7 512=3 1232=1 4096=0 more=0
+
+Lists the most frequent query types and response rcodes in descending order and the total of all
+others. Each line is omitted if there is nothing to list. Rcodes include the SERVFAIL, FORMERR and
+BADCOOKIE responses generated by the server itself.
+
+Sizes counts the successful responses in each size bucket. Each bucket holds the responses of up to
+and including that many bytes which are larger than the previous bucket. Anything over 512 would
+be truncated for a classic UDP client and anything over 1232 exceeds the DNS Flag Day 2020 EDNS
+buffer size recommendation.
+
 */
 
 func (t *server) Report(resetCounters bool) string {
+	sr := t.snapshot(resetCounters)
+
+	report := fmt.Sprintf("req=%d ok=%d (%s) al=%0.3f errs=%d (%s) Concurrency=%d %s\n",
+		sr.Requests, sr.Success, formatCounters("%d", "/", sr.Events.Values), sr.AverageLatency,
+		sr.Errors, formatCounters("%d", "/", sr.Failures.Values), sr.Concurrency, sr.Listen)
+	if len(sr.QueryTypes) > 0 {
+		report += "Qtypes: " + topcounter.Format(sr.QueryTypes) + "\n"
+	}
+	if len(sr.Rcodes) > 0 {
+		report += "Rcodes: " + topcounter.Format(sr.Rcodes) + "\n"
+	}
+	if sr.Success > 0 {
+		report += "Sizes: " + sizehistogram.Format(sr.Sizes) + "\n"
+	}
+
+	return report
+}
+
+// ReportJSON implements the reporter.MetricsReporter interface
+func (t *server) ReportJSON(resetCounters bool) ([]byte, error) {
+	return json.Marshal(t.snapshot(resetCounters))
+}
+
+// serverReport is a snapshot of the server stats shared by Report() and ReportJSON()
+type serverReport struct {
+	Requests       int               `json:"req"`
+	Success        int               `json:"ok"`
+	Events         reporter.Counters `json:"events"`
+	AverageLatency float64           `json:"al"`
+	Errors         int               `json:"errs"`
+	Failures       reporter.Counters `json:"failures"`
+	Concurrency    int               `json:"concurrency"`
+	Listen         string            `json:"listen"`
+
+	QueryTypes []topcounter.Entry `json:"qtypes"` // Most frequent first
+	Rcodes     []topcounter.Entry `json:"rcodes"` // Most frequent first
+	Sizes      []int              `json:"sizes"`  // Response size buckets in ascending order
+}
+
+// snapshot gathers up the current stats and optionally resets them
+func (t *server) snapshot(resetCounters bool) *serverReport {
 	if resetCounters {
 		t.mu.Lock()
 		defer t.mu.Unlock()
@@ -91,26 +192,41 @@ func (t *server) Report(resetCounters bool) string {
 		defer t.mu.RUnlock()
 	}
 
-	errs := 0
+	sr := &serverReport{Success: t.successCount,
+		Events:     reporter.NewCounters(evNames[:], t.eventCounters[:]),
+		Failures:   reporter.NewCounters(serNames[:], t.failureCounters[:]),
+		Listen:     t.listenName(),
+		QueryTypes: t.qtypes.Top(reportQueryTypes, qtypeName),
+		Rcodes:     t.rcodes.Top(reportRcodes, rcodeName),
+		Sizes:      t.sizes.Counts(),
+	}
 	for _, v := range t.failureCounters {
-		errs += v
+		sr.Errors += v
 	}
-	req := t.successCount + errs
+	sr.Requests = t.successCount + sr.Errors
 
-	var al float64
 	if t.successCount > 0 {
-		al = t.totalLatency.Seconds() / float64(t.successCount)
+		sr.AverageLatency = t.totalLatency.Seconds() / float64(t.successCount)
 	}
-	s := fmt.Sprintf("req=%d ok=%d (%s) al=%0.3f errs=%d (%s) Concurrency=%d %s\n",
-		req, t.successCount, formatCounters("%d", "/", t.eventCounters[:]), al,
-		errs, formatCounters("%d", "/", t.failureCounters[:]),
-		t.ccTrk.Peak(resetCounters), t.listenName())
+	sr.Concurrency = t.ccTrk.Peak(resetCounters)
 
 	if resetCounters {
 		t.stats = stats{}
 	}
 
-	return s
+	return sr
+}
+
+func qtypeName(qtype uint16) string {
+	return dns.Type(qtype).String()
+}
+
+func rcodeName(rcode uint16) string {
+	if s, ok := dns.RcodeToString[int(rcode)]; ok {
+		return s
+	}
+
+	return fmt.Sprintf("RCODE%d", rcode)
 }
 
 // formatCounters returns a nice %d/%d/%d format for an array of ints. This is less error-prone than
@@ -1,13 +1,16 @@
 package main
 
 import (
+	"encoding/json"
 	"os"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/miekg/dns"
 )
 
-const expect1 = "req=14 ok=2 (0/0/0/0/0/0) al=0.750 errs=12 (1/1/1/1/1/1/1/1/1/1/1/1) Concurrency=0"
+const expect1 = "req=17 ok=2 (0/0/0/0/0/0/0/0/0/0/0/0/0/0/0) al=0.750 errs=15 (1/1/1/1/1/1/1/1/1/1/1/1/1/1/1) Concurrency=0"
 
 func TestReporter(t *testing.T) {
 	mainInit(os.Stdout, os.Stderr) // Make sure cfg is initialized
@@ -22,7 +25,7 @@ func TestReporter(t *testing.T) {
 	}
 
 	var evs events
-	s.addSuccessStats(time.Second, evs)
+	s.addSuccessStats(time.Second, 100, evs)
 	rep2 := s.Report(true)
 	if rep2 == rep1 {
 		t.Error("Report should changed with counter updates", rep1, rep2)
@@ -31,11 +34,13 @@ func TestReporter(t *testing.T) {
 	if rep2 != rep1 {
 		t.Error("Reset Counters report should equal initial report", rep1, rep2)
 	}
-	s.addSuccessStats(time.Second, evs)
-	s.addSuccessStats(time.Millisecond*500, evs) // ok=2, al=1.5/2 = 0.750
+	s.addSuccessStats(time.Second, 100, evs)
+	s.addSuccessStats(time.Millisecond*500, 700, evs) // ok=2, al=1.5/2 = 0.750
 	s.addFailureStats(serBadContentType, evs)
+	s.addFailureStats(serBadCookie, evs)
 	s.addFailureStats(serBadMethod, evs)
 	s.addFailureStats(serBadPrefixLengths, evs)
+	s.addFailureStats(serBadQueryName, evs)
 	s.addFailureStats(serBadQueryParamDecode, evs)
 	s.addFailureStats(serBodyReadError, evs)
 	s.addFailureStats(serClientTLSBad, evs)
@@ -44,7 +49,8 @@ func TestReporter(t *testing.T) {
 	s.addFailureStats(serECSSynthesisFailed, evs)
 	s.addFailureStats(serHTTPWriterFailed, evs)
 	s.addFailureStats(serLocalResolutionFailed, evs)
-	s.addFailureStats(serQueryParamMissing, evs) // errs=12
+	s.addFailureStats(serQueryParamMissing, evs)
+	s.addFailureStats(serRequestTooLarge, evs) // errs=15
 
 	rep1 = s.Report(false)
 	rep2 = s.Report(false)
@@ -55,4 +61,63 @@ func TestReporter(t *testing.T) {
 	if !strings.Contains(rep1, expect1) {
 		t.Error("Report should not have changed. Expected:", expect1, "Got:", rep1)
 	}
+	if !strings.Contains(rep1, "\nSizes: 128=1 256=0 512=0 1232=1 4096=0 more=0\n") {
+		t.Error("Report does not contain expected Sizes line", rep1)
+	}
+	if strings.Contains(rep1, "Qtypes:") {
+		t.Error("Qtypes line should be omitted when no queries have been counted", rep1)
+	}
+}
+
+func TestReporterQueryTypesAndRcodes(t *testing.T) {
+	mainInit(os.Stdout, os.Stderr)
+	s := &server{stdout: stdout, listenAddress: "127.0.0.1"}
+	q := &dns.Msg{}
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA, dns.TypeA, dns.TypePTR, dns.TypeA, dns.TypeAAAA} {
+		q.SetQuestion("example.net.", qtype)
+		s.addQueryTypeStats(q)
+	}
+	s.addQueryTypeStats(&dns.Msg{}) // No question is ignored
+
+	for _, rcode := range []int{dns.RcodeSuccess, dns.RcodeNameError, dns.RcodeSuccess, 3841} {
+		s.addRcodeStats(rcode)
+	}
+
+	rep := s.Report(true)
+	if !strings.Contains(rep, "\nQtypes: A=3 AAAA=2 PTR=1\nRcodes: NOERROR=2 NXDOMAIN=1 RCODE3841=1\n") {
+		t.Error("Report does not contain expected Qtypes and Rcodes lines", rep)
+	}
+	if rep = s.Report(false); strings.Contains(rep, "Qtypes:") || strings.Contains(rep, "Rcodes:") {
+		t.Error("Qtypes and Rcodes not reset with other counters", rep)
+	}
+}
+
+func TestReportJSON(t *testing.T) {
+	mainInit(os.Stdout, os.Stderr)
+	s := &server{stdout: stdout, listenAddress: "127.0.0.1"}
+	var evs events
+	evs[evGet] = true
+	evs[evCookie] = true
+	s.addSuccessStats(time.Second, 100, evs)
+	s.addFailureStats(serBadCookie, evs)
+
+	b, err := s.ReportJSON(true)
+	if err != nil {
+		t.Fatal("Unexpected error from ReportJSON", err)
+	}
+	var sr struct { // Events and Failures are keyed by name
+		serverReport
+		Events   map[string]int `json:"events"`
+		Failures map[string]int `json:"failures"`
+	}
+	if err := json.Unmarshal(b, &sr); err != nil {
+		t.Fatal("ReportJSON did not produce valid JSON", err, string(b))
+	}
+	if sr.Requests != 2 || sr.Success != 1 || sr.Errors != 1 || sr.Events["get"] != 2 ||
+		sr.Events["cookie"] != 2 || sr.Failures["badCookie"] != 1 {
+		t.Error("ReportJSON returned wrong counters", string(b))
+	}
+	if len(sr.Events) != int(evListSize) || len(sr.Failures) != int(serArraySize) {
+		t.Error("ReportJSON is missing names", string(b))
+	}
 }
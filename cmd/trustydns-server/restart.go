@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/markdingo/trustydns/internal/osutil"
+)
+
+// Graceful restart on SIGUSR2 starts a new copy of the program which inherits all the listen
+// sockets. Once the new process signals that it is ready, the old process stops accepting
+// connections, drains in-flight requests and exits. Both processes accept connections on the
+// shared sockets in the interim so no connections are refused.
+//
+// The listen addresses are passed in an environment variable in the same order as the sockets are
+// passed in exec.Cmd.ExtraFiles. The socket list is followed by the write end of a pipe used to
+// signal readiness.
+
+const (
+	restartEnv     = "TRUSTYDNS_RESTART_ADDRESSES" // Space separated listen addresses
+	restartReady   = "ready"
+	restartTimeout = 30 * time.Second // How long to wait for the new process to become ready
+)
+
+// restart starts a new copy of this program with the same command line and passes it the listen
+// sockets of all servers. It returns once the new process is ready to accept connections. If an
+// error is returned the caller should continue to serve as normal.
+func restart(servers []*server) error {
+	var addrs []string
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	var unixListeners []*net.UnixListener
+	for _, s := range servers {
+		f, ul, err := listenerFile(s)
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+		addrs = append(addrs, s.listenAddress)
+		if ul != nil {
+			unixListeners = append(unixListeners, ul)
+		}
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	executable, err := os.Executable()
+	if err != nil {
+		w.Close()
+		return err
+	}
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.Env = append(os.Environ(), restartEnv+"="+strings.Join(addrs, " "))
+	cmd.ExtraFiles = append(append([]*os.File{}, files...), w)
+	err = cmd.Start()
+	w.Close() // Only the new process holds the write end now
+	if err != nil {
+		return err
+	}
+
+	r.SetReadDeadline(time.Now().Add(restartTimeout))
+	buf := make([]byte, len(restartReady))
+	_, err = io.ReadFull(r, buf)
+	if err != nil || string(buf) != restartReady {
+		cmd.Process.Kill()
+		cmd.Wait()
+		if err == nil {
+			err = errors.New("unexpected ready message")
+		}
+		return fmt.Errorf("new process did not become ready: %s", err.Error())
+	}
+
+	// The socket path now belongs to the new process so it must survive our listener closing
+
+	for _, ul := range unixListeners {
+		ul.SetUnlinkOnClose(false)
+	}
+
+	return cmd.Process.Release()
+}
+
+// listenerFile returns a dup of the server's listen socket suitable for passing to a new
+// process. If the listener is a --unix-socket the underlying UnixListener is also returned so that
+// the caller can stop it unlinking the socket path once the new process has taken over.
+func listenerFile(s *server) (*os.File, *net.UnixListener, error) {
+	switch l := s.listener.(type) {
+	case *net.TCPListener:
+		f, err := l.File()
+		return f, nil, err
+	case *unixListener:
+		ul, ok := l.Listener.(*net.UnixListener)
+		if ok && !strings.ContainsAny(s.listenAddress, " \t\n") { // Addresses are space separated
+			f, err := ul.File()
+			return f, ul, err
+		}
+	}
+
+	return nil, nil, fmt.Errorf("%s cannot be passed to a new process", s.listenName())
+}
+
+// inheritedListeners returns the listen sockets passed by a parent process via restart() keyed by
+// listen address, along with the pipe used to signal readiness back to the parent. Nil values are
+// returned if this process was not started by restart().
+func inheritedListeners() (map[string]net.Listener, *os.File, error) {
+	v, ok := os.LookupEnv(restartEnv)
+	if !ok {
+		return nil, nil, nil
+	}
+	os.Unsetenv(restartEnv) // Don't pass on to any of our children
+	addrs := strings.Fields(v)
+	files := osutil.InheritedFiles(len(addrs) + 1)
+	if len(files) != len(addrs)+1 {
+		return nil, nil, errors.New("graceful restart is not supported on this platform")
+	}
+	listeners, err := listenersFromFiles(addrs, files[:len(addrs)])
+	if err != nil {
+		files[len(addrs)].Close()
+		return nil, nil, err
+	}
+
+	return listeners, files[len(addrs)], nil
+}
+
+// listenersFromFiles converts the files into listeners keyed by the corresponding address. All
+// files are closed as the net package dups them.
+func listenersFromFiles(addrs []string, files []*os.File) (map[string]net.Listener, error) {
+	listeners := make(map[string]net.Listener)
+	var err error
+	for ix, f := range files {
+		var l net.Listener
+		if err == nil {
+			l, err = net.FileListener(f)
+			if err == nil {
+				listeners[addrs[ix]] = l
+			}
+		}
+		f.Close()
+	}
+	if err != nil {
+		for _, l := range listeners {
+			l.Close()
+		}
+		return nil, err
+	}
+
+	return listeners, nil
+}
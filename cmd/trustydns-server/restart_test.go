@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestListenersFromFiles(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	f, err := l.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listeners, err := listenersFromFiles([]string{"127.0.0.1:443"}, []*os.File{f})
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	il, ok := listeners["127.0.0.1:443"]
+	if !ok {
+		t.Fatal("Listener not keyed by supplied address", listeners)
+	}
+	if il.Addr().String() != l.Addr().String() {
+		t.Error("Inherited listener has wrong address", il.Addr(), l.Addr())
+	}
+	il.Close()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	_, err = listenersFromFiles([]string{"127.0.0.1:443"}, []*os.File{r})
+	if err == nil {
+		t.Error("Expected an error converting a pipe to a listener")
+	}
+}
+
+// A --unix-socket listener is passed on and the socket path survives the old listener closing
+func TestUnixListenerFile(t *testing.T) {
+	mainInit(&mutexBytesBuffer{}, &mutexBytesBuffer{})
+	path := filepath.Join(t.TempDir(), "trustydns.sock")
+	l, err := listenUnix(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{listenAddress: path, unixSocket: true, listener: l}
+	f, ul, err := listenerFile(s)
+	if err != nil || ul == nil {
+		t.Fatal("Unexpected error", err, ul)
+	}
+	listeners, err := listenersFromFiles([]string{path}, []*os.File{f})
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	il := listeners[path]
+	defer il.Close()
+	if il.Addr().Network() != "unix" {
+		t.Error("Inherited listener is not a Unix socket", il.Addr())
+	}
+
+	ul.SetUnlinkOnClose(false) // As restart() does once the new process is ready
+	l.Close()
+	c, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal("Socket path did not survive the old listener closing", err)
+	}
+	c.Close()
+
+	s.listenAddress = "/tmp/with space.sock"
+	if _, _, err := listenerFile(s); err == nil || !strings.Contains(err.Error(), "cannot be passed") {
+		t.Error("Expected 'cannot be passed' error for a path with a space, not", err)
+	}
+}
+
+func TestRestartErrors(t *testing.T) {
+	mainInit(&mutexBytesBuffer{}, &mutexBytesBuffer{})
+	listeners, ready, err := inheritedListeners()
+	if listeners != nil || ready != nil || err != nil {
+		t.Error("Expected nothing inherited without", restartEnv, listeners, ready, err)
+	}
+
+	err = restart([]*server{{listenAddress: "127.0.0.1:443"}})
+	if err == nil || !strings.Contains(err.Error(), "cannot be passed") {
+		t.Error("Expected 'cannot be passed' error for a server without a listener, not", err)
+	}
+}
@@ -4,10 +4,12 @@ import (
 	"context"
 	"crypto/tls"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"math/rand"
 	"net"
 	"net/http"
 	"strconv"
@@ -18,7 +20,10 @@ import (
 	"github.com/markdingo/trustydns/internal/concurrencytracker"
 	"github.com/markdingo/trustydns/internal/connectiontracker"
 	"github.com/markdingo/trustydns/internal/dnsutil"
+	"github.com/markdingo/trustydns/internal/osutil"
 	"github.com/markdingo/trustydns/internal/resolver"
+	"github.com/markdingo/trustydns/internal/sizehistogram"
+	"github.com/markdingo/trustydns/internal/topcounter"
 
 	"github.com/miekg/dns"
 )
@@ -27,8 +32,10 @@ type serFailureIndex int
 
 const ( // ser = Server ERror index into failure counter array
 	serBadContentType serFailureIndex = iota // iota resets to zero in each const() spec set
+	serBadCookie
 	serBadMethod
 	serBadPrefixLengths
+	serBadQueryName
 	serBadQueryParamDecode
 	serBodyReadError
 	serClientTLSBad
@@ -38,9 +45,16 @@ const ( // ser = Server ERror index into failure counter array
 	serHTTPWriterFailed
 	serLocalResolutionFailed
 	serQueryParamMissing
+	serRequestTooLarge
 	serArraySize
 )
 
+// serNames are the JSON report keys of the failure counters, indexed by ser* constants
+var serNames = [serArraySize]string{"badContentType", "badCookie", "badMethod", "badPrefixLengths",
+	"badQueryName", "badQueryParamDecode", "bodyReadError", "clientTLSBad", "dnsPackResponseFailed",
+	"dnsUnpackRequestFailed", "ecsSynthesisFailed", "httpWriterFailed", "localResolutionFailed",
+	"queryParamMissing", "requestTooLarge"}
+
 type evIndex int
 
 const ( // ev = EVent index into eventCounters
@@ -50,25 +64,54 @@ const ( // ev = EVent index into eventCounters
 	evECSv4Synth
 	evECSv6Synth
 	evPadding
+	evMinimal
+	evRoundtripMismatch
+	evECSEcho
+	evChaos
+	evDebugMeta
+	evEDNS0Filtered
+	evAny
+	evSlow
+	evCookie
 	evListSize
 )
 
+// evNames are the JSON report keys of the event counters, indexed by ev* constants
+var evNames = [evListSize]string{"get", "tsig", "edns0Removed", "ecsv4Synth", "ecsv6Synth", "padding",
+	"minimal", "roundtripMismatch", "ecsEcho", "chaos", "debugMeta", "edns0Filtered", "any", "slow",
+	"cookie"}
+
 type events [evListSize]bool
 
 type stats struct {
-	successCount    int               // Queries that ran to completion without error
-	totalLatency    time.Duration     // Duration of all successful queries
-	eventCounters   [evListSize]int   // Events that occur during the course of a query
-	failureCounters [serArraySize]int // Errors that stop a query from progressing
+	successCount    int                // Queries that ran to completion without error
+	totalLatency    time.Duration      // Duration of all successful queries
+	eventCounters   [evListSize]int    // Events that occur during the course of a query
+	failureCounters [serArraySize]int  // Errors that stop a query from progressing
+	qtypes          topcounter.Counter // Query types of all unpacked queries
+	rcodes          topcounter.Counter // Rcodes of all DNS responses returned to clients
+
+	sizes sizehistogram.Histogram // Sizes of all DNS responses returned to clients
 }
 
 type server struct {
 	stdout        io.Writer
 	local         resolver.Resolver
 	listenAddress string
+	listener      net.Listener               // Pre-opened socket from --systemd
+	unixSocket    bool                       // listenAddress is a --unix-socket path
 	server        *http.Server               // Keep a copy solely for the stop() method
 	ccTrk         concurrencytracker.Counter // Track peak concurrent server requests
 	connTrk       *connectiontracker.Tracker
+	trusted       trustedProxies  // Peers whose X-Forwarded-For header is believed
+	ecsExempt     networks        // Clients whose queries are exempt from ECS removal and synthesis
+	debugMeta     networks        // Clients allowed to ask for resolution meta data
+	ednsAllowed   ednsPassthrough // EDNS0 options forwarded to the local resolver
+	cookies       *cookieSecrets  // Issue and validate server cookies if not nil
+
+	connMu   sync.Mutex          // Protects conns
+	conns    map[string]net.Conn // Open connections by RemoteAddr - only populated with --reap-idle
+	reapDone chan struct{}       // Closed by stop() to end the reaper go-routine
 
 	mu sync.RWMutex // Protects everything below here
 	stats
@@ -113,13 +156,36 @@ func (t *server) start(tlsConfig *tls.Config, errorChan chan error, wg *sync.Wai
 	t.server.ConnState = func(c net.Conn, state http.ConnState) {
 		t.connTrk.ConnState(c.RemoteAddr().String(), time.Now(), state)
 	}
+	if cfg.reapIdle > 0 {
+		t.startReaper(cfg.reapIdle)
+	}
+
+	// The listen socket is opened here rather than by ListenAndServe as net/http has no way to
+	// set socket options and restart() needs the socket to pass on to the new process.
+
+	if t.listener == nil {
+		var err error
+		if t.unixSocket {
+			t.listener, err = listenUnix(t.listenAddress)
+		} else {
+			var lc *net.ListenConfig
+			lc, err = osutil.ListenConfig(cfg.reusePort)
+			if err == nil {
+				t.listener, err = lc.Listen(context.Background(), "tcp", t.listenAddress)
+			}
+		}
+		if err != nil {
+			errorChan <- err
+			return
+		}
+	}
 
 	wg.Add(1)
 	go func() {
-		if cfg.tlsServerKeyFiles.NArg() > 0 {
-			errorChan <- t.server.ListenAndServeTLS("", "") // Keys and certs are in tlsConfig
+		if cfg.tlsServerKeyFiles.NArg() > 0 && !t.unixSocket { // TLS is pointless on a local socket
+			errorChan <- t.server.ServeTLS(t.listener, "", "") // Keys and certs are in tlsConfig
 		} else {
-			errorChan <- t.server.ListenAndServe() // Only returns on start-up error or shutdown request
+			errorChan <- t.server.Serve(t.listener) // Only returns on start-up error or shutdown request
 		}
 		wg.Done()
 	}()
@@ -147,13 +213,20 @@ func (t *server) serveDoH(writer http.ResponseWriter, httpReq *http.Request) {
 		defer t.connTrk.SessionDone(httpReq.RemoteAddr)
 	}
 
+	// Echo any trace ID so that it is present on every response, including errors
+
+	trace := traceID(httpReq.Header)
+	if len(trace) > 0 {
+		writer.Header().Set(consts.TrustyTraceHeader, trace)
+	}
+
 	if cfg.logHTTPIn {
-		fmt.Fprintln(t.stdout, "HI:"+httpReq.RemoteAddr, http.MethodPost, httpReq.URL.String())
+		fmt.Fprintln(t.stdout, "HI:"+httpReq.RemoteAddr, http.MethodPost, httpReq.URL.String()+logTrace(trace))
 	}
 
 	// Validate the request
 
-	body, serx, httpStatusCode, errMsg := t.validateRequest(httpReq)
+	body, serx, httpStatusCode, errMsg := t.validateRequest(writer, httpReq)
 	if len(errMsg) > 0 {
 		t.error(writer, httpReq.RemoteAddr, httpStatusCode, errMsg)
 		t.addFailureStats(serx, evs)
@@ -167,10 +240,11 @@ func (t *server) serveDoH(writer http.ResponseWriter, httpReq *http.Request) {
 	if httpReq.Method == http.MethodGet {
 		evs[evGet] = true
 		var serx serFailureIndex
+		var httpStatusCode int
 		var errMsg string
-		body, serx, errMsg = t.decodeQueryParam(httpReq)
+		body, serx, httpStatusCode, errMsg = t.decodeQueryParam(httpReq)
 		if len(errMsg) > 0 {
-			t.error(writer, httpReq.RemoteAddr, http.StatusBadRequest, errMsg)
+			t.error(writer, httpReq.RemoteAddr, httpStatusCode, errMsg)
 			t.addFailureStats(serx, evs)
 			return
 		}
@@ -191,34 +265,101 @@ func (t *server) serveDoH(writer http.ResponseWriter, httpReq *http.Request) {
 	}
 
 	if cfg.logClientIn {
-		fmt.Fprintln(t.stdout, "CI:"+dnsutil.CompactMsgString(dnsQ))
+		fmt.Fprintln(t.stdout, "CI:"+compactMsg(dnsQ)+logECS(dnsQ)+logTrace(trace))
 	}
+	t.addQueryTypeStats(dnsQ)
+
+	// From here on failures are returned as DNS responses rather than HTTP errors so that DoH
+	// clients see a DNS-level failure. An EDE is only added if the client's query had an OPT.
+
+	queryHasOPT := dnsQ.IsEdns0() != nil
 
 	// If the query Id is zero (which it should be for GET), generate a non-zero Id and remember
-	// to reinstantiate the original Id in the response returned to the caller.
+	// to reinstantiate the original Id in the response returned to the caller. The debug option
+	// --preserve-zero-id passes the zero Id through to the local resolver unchanged.
 
 	originalId := dnsQ.MsgHdr.Id
-	if originalId == 0 {
+	if originalId == 0 && !cfg.preserveZeroId {
 		dnsQ.MsgHdr.Id = dns.Id()
 	}
 
+	// Pathological names are rejected here rather than risk upsetting the local resolvers.
+
+	for _, q := range dnsQ.Question {
+		if err := dnsutil.ValidateName(q.Name); err != nil {
+			msg := fmt.Sprintf("Error: invalid query name: %s", err.Error())
+			t.dnsError(writer, httpReq.RemoteAddr, dnsQ, originalId, queryHasOPT, dns.RcodeFormatError,
+				http.StatusBadRequest, dns.ExtendedErrorCodeOther, msg)
+			if cfg.logClientIn {
+				fmt.Fprintln(t.stdout, "CE:"+msg)
+			}
+			t.addFailureStats(serBadQueryName, evs)
+			return
+		}
+	}
+
 	// Determine whether we can mutate the message for ECS and padding.
 
 	msgIsMutable := dnsQ.IsTsig() == nil
 	evs[evTsig] = !msgIsMutable
 	addServerPadding := -1
+	addDebugMeta := false
+	var clientCookie []byte // Set if a server cookie is to be issued in the response
+	var clientIP net.IP
 
 	if msgIsMutable {
 		ecsRequestData := httpReq.Header.Get(consts.TrustySynthesizeECSRequestHeader)
-		if cfg.ecsRemove || len(ecsRequestData) > 0 || cfg.ecsSet { // Expunge any pre-existing ECS OPT?
+		clientAddr := t.trusted.clientAddr(httpReq)
+
+		// The debug meta option is only meaningful to us so it is never passed on. The OPT is
+		// retained as it still conveys the client's UDP size and DO bit.
+		notDebugMeta := func(code uint16) bool { return code != consts.TrustyDebugMetaOption }
+		if dnsutil.FilterEDNS0(dnsQ, notDebugMeta) > 0 {
+			addDebugMeta = t.isDebugMetaAllowed(clientAddr)
+		}
+		ecsExempt := t.isECSExempt(clientAddr) // If so, pass the query's ECS, if any, through unchanged
+
+		// Server cookies (rfc7873) protect against spoofed responses between DoH clients and us
+		// even over plain HTTP. A bad server cookie is answered with BADCOOKIE and a fresh server
+		// cookie so that the client can immediately retry.
+
+		if t.cookies != nil {
+			clientIP, _ = parseRemoteAddr(clientAddr) // A nil IP is fine for a unix socket client
+			var status cookieStatus
+			clientCookie, status = t.cookies.check(dnsQ, clientIP)
+			switch status {
+			case cookieMalformed:
+				msg := "Error: malformed COOKIE option"
+				t.dnsError(writer, httpReq.RemoteAddr, dnsQ, originalId, queryHasOPT, dns.RcodeFormatError,
+					http.StatusBadRequest, dns.ExtendedErrorCodeOther, msg)
+				if cfg.logClientIn {
+					fmt.Fprintln(t.stdout, "CE:"+msg)
+				}
+				t.addFailureStats(serBadCookie, evs)
+				return
+			case cookieBad:
+				msg := "Error: bad server cookie"
+				t.dnsError(writer, httpReq.RemoteAddr, dnsQ, originalId, queryHasOPT, dns.RcodeBadCookie,
+					http.StatusBadRequest, dns.ExtendedErrorCodeOther, msg, t.cookies.issue(clientCookie, clientIP))
+				if cfg.logClientIn {
+					fmt.Fprintln(t.stdout, "CE:"+msg)
+				}
+				t.addFailureStats(serBadCookie, evs)
+				return
+			}
+		}
+
+		// Expunge any pre-existing ECS OPT?
+		if !ecsExempt && (cfg.ecsRemove || len(ecsRequestData) > 0 || cfg.ecsSet) {
 			dnsutil.RemoveEDNS0FromOPT(dnsQ, dns.EDNS0SUBNET)
 			evs[evEDNS0Removed] = true
 		}
 
-		if len(ecsRequestData) > 0 || cfg.ecsSet {
-			evx, serx, errMsg := t.synthesizeECS(dnsQ, ecsRequestData, httpReq.RemoteAddr)
+		if !ecsExempt && (len(ecsRequestData) > 0 || cfg.ecsSet) {
+			evx, serx, errMsg := t.synthesizeECS(dnsQ, ecsRequestData, clientAddr)
 			if len(errMsg) > 0 {
-				t.error(writer, httpReq.RemoteAddr, http.StatusBadRequest, errMsg)
+				t.dnsError(writer, httpReq.RemoteAddr, dnsQ, originalId, queryHasOPT, dns.RcodeServerFailure,
+					http.StatusBadRequest, dns.ExtendedErrorCodeOther, errMsg)
 				t.addFailureStats(serx, evs)
 				return
 			}
@@ -230,31 +371,79 @@ func (t *server) serveDoH(writer http.ResponseWriter, httpReq *http.Request) {
 			evs[evPadding] = true
 			dnsutil.RemoveEDNS0FromOPT(dnsQ, dns.EDNS0PADDING)
 		}
-	}
 
-	// Resolve
+		// Only options the operator has vouched for are forwarded as the local resolvers may
+		// mishandle or be unduly influenced by others.
 
-	if cfg.logLocalOut {
-		fmt.Fprintln(t.stdout, "LO:"+dnsutil.CompactMsgString(dnsQ))
+		if dnsutil.FilterEDNS0(dnsQ, t.ednsAllowed.keep) > 0 {
+			evs[evEDNS0Filtered] = true
+		}
 	}
+
+	// Resolve. CHAOS class queries are answered here as the local resolvers only deal with IN.
+	// ANY queries may also be answered here to spare the local resolvers the expansion.
+
 	startTime := time.Now() // Track latency
 	var dnsR *dns.Msg
 	var dnsRMeta *resolver.ResponseMetaData
-	queryMeta := &resolver.QueryMetaData{TransportType: resolver.DNSTransportType(httpReq.URL.Scheme)}
-	dnsR, dnsRMeta, err = t.local.Resolve(dnsQ, queryMeta)
-	if err != nil {
-		msg := fmt.Sprintf("Error: local resolution failed: %s", err.Error())
-		t.error(writer, httpReq.RemoteAddr, http.StatusServiceUnavailable, msg)
+	if dnsR = chaosResponse(dnsQ); dnsR != nil {
+		evs[evChaos] = true
+		dnsRMeta = &resolver.ResponseMetaData{FinalServerUsed: "chaos"}
+	} else if dnsR = anyResponse(dnsQ); dnsR != nil {
+		evs[evAny] = true
+		dnsRMeta = &resolver.ResponseMetaData{FinalServerUsed: "any"}
+	} else {
 		if cfg.logLocalOut {
-			fmt.Fprintln(t.stdout, "LE:"+msg)
+			fmt.Fprintln(t.stdout, "LO:"+compactMsg(dnsQ)+logECS(dnsQ))
+		}
+		queryMeta := &resolver.QueryMetaData{TransportType: resolver.DNSTransportType(httpReq.URL.Scheme)}
+		dnsR, dnsRMeta, err = resolver.ResolveContext(httpReq.Context(), t.local, dnsQ, queryMeta)
+		if err != nil {
+			evs[evSlow] = t.slowQuery(dnsQ, time.Since(startTime), nil, httpReq)
+			msg := fmt.Sprintf("Error: local resolution failed: %s", err.Error())
+			t.dnsError(writer, httpReq.RemoteAddr, dnsQ, originalId, queryHasOPT, dns.RcodeServerFailure,
+				http.StatusServiceUnavailable, dns.ExtendedErrorCodeNetworkError, msg)
+			if cfg.logLocalOut {
+				fmt.Fprintln(t.stdout, "LE:"+msg)
+			}
+			t.addFailureStats(serLocalResolutionFailed, evs)
+			return
+		}
+
+		if cfg.logLocalIn {
+			fmt.Fprintln(t.stdout, "LI:"+compactMsg(dnsR)+logECS(dnsR),
+				dnsRMeta.QueryTries, dnsRMeta.ServerTries, dnsRMeta.FinalServerUsed)
 		}
-		t.addFailureStats(serLocalResolutionFailed, evs)
-		return
 	}
 
-	if cfg.logLocalIn {
-		fmt.Fprintln(t.stdout, "LI:"+dnsutil.CompactMsgString(dnsR),
-			dnsRMeta.QueryTries, dnsRMeta.ServerTries, dnsRMeta.FinalServerUsed)
+	// Trim the response if configured to do so. This cannot be applied to a TSIG signed
+	// response as the signature covers all sections, nor is it applied when the client has
+	// asked for DNSSEC records as they often reside in the sections being removed.
+
+	if cfg.minimalResponses && msgIsMutable {
+		if opt := dnsQ.IsEdns0(); opt == nil || !opt.Do() {
+			evs[evMinimal] = minimizeResponse(dnsR)
+		}
+	}
+
+	// Some downstream caches expect the ECS of a query to be reflected in the response. If we
+	// synthesized the ECS and the resolver did not echo it, do so on its behalf with a scope of
+	// zero to indicate the response applies to all clients.
+
+	if cfg.ecsEcho && (evs[evECSv4Synth] || evs[evECSv6Synth]) {
+		evs[evECSEcho] = echoECS(dnsQ, dnsR)
+	}
+
+	if addDebugMeta {
+		evs[evDebugMeta] = true
+		dnsR.Extra = append(dnsR.Extra, debugMetaRR(dnsRMeta))
+	}
+
+	// Any COOKIE from the local resolver is replaced with ours
+
+	if clientCookie != nil {
+		evs[evCookie] = true
+		dnsutil.MergeEDNS(dnsR, cookieMsg(t.cookies.issue(clientCookie, clientIP)))
 	}
 
 	// Convert DNS message back into HTTP body binary
@@ -268,7 +457,8 @@ func (t *server) serveDoH(writer http.ResponseWriter, httpReq *http.Request) {
 	}
 	if err != nil {
 		msg := fmt.Sprintf("DNS Pack Failed: %s", err.Error())
-		t.error(writer, httpReq.RemoteAddr, http.StatusServiceUnavailable, msg)
+		t.dnsError(writer, httpReq.RemoteAddr, dnsQ, originalId, queryHasOPT, dns.RcodeServerFailure,
+			http.StatusServiceUnavailable, dns.ExtendedErrorCodeOther, msg)
 		if cfg.logClientOut {
 			fmt.Fprintln(t.stdout, "LE:"+msg)
 		}
@@ -276,9 +466,28 @@ func (t *server) serveDoH(writer http.ResponseWriter, httpReq *http.Request) {
 		return
 	}
 
+	// --validate-roundtrip only warns as the client may well be able to use the response
+
+	if cfg.validateRoundtrip {
+		if err := dnsutil.ValidatePacked(dnsR, body); err != nil {
+			evs[evRoundtripMismatch] = true
+			fmt.Fprintln(t.stdout, "VE:"+compactMsg(dnsR), err.Error())
+		}
+	}
+
+	// Testing-only delay. Abandon it if the client goes away as there's nothing to test then.
+
+	if cfg.injectDelay > 0 && rand.Float64() < cfg.injectDelayFraction {
+		select {
+		case <-time.After(cfg.injectDelay):
+		case <-httpReq.Context().Done():
+		}
+	}
+
 	// Return message to caller
 
 	duration := time.Since(startTime)
+	evs[evSlow] = t.slowQuery(dnsQ, duration, dnsRMeta, httpReq)
 	writer.Header().Set(consts.ContentTypeHeader, consts.Rfc8484AcceptValue)
 	writer.Header().Set(consts.TrustyDurationHeader, duration.String())
 
@@ -293,19 +502,84 @@ func (t *server) serveDoH(writer http.ResponseWriter, httpReq *http.Request) {
 		return
 	}
 
-	t.addSuccessStats(duration, evs)
+	t.addSuccessStats(duration, len(body), evs)
+	t.addRcodeStats(dnsR.Rcode)
 	if cfg.logClientOut {
-		fmt.Fprintln(t.stdout, "CO:"+dnsutil.CompactMsgString(dnsR),
-			dnsRMeta.QueryTries, dnsRMeta.ServerTries, dnsRMeta.FinalServerUsed, duration)
+		fmt.Fprintln(t.stdout, "CO:"+compactMsg(dnsR)+logECS(dnsR),
+			dnsRMeta.QueryTries, dnsRMeta.ServerTries, dnsRMeta.FinalServerUsed, duration.String()+logTrace(trace))
 	}
 	if cfg.logHTTPOut {
-		fmt.Fprintln(t.stdout, "HO:", httpReq.RemoteAddr, "200 Ok", len(body), duration)
+		fmt.Fprintln(t.stdout, "HO:", httpReq.RemoteAddr, "200 Ok", len(body), duration.String()+logTrace(trace))
+	}
+}
+
+// slowQuery prints a query which took longer than --slow-query-threshold regardless of the --log-*
+// settings. dnsRMeta is nil if resolution failed. Return true if the query was slow.
+func (t *server) slowQuery(dnsQ *dns.Msg, duration time.Duration, dnsRMeta *resolver.ResponseMetaData,
+	httpReq *http.Request) bool {
+	if cfg.slowQueryThreshold == 0 || duration <= cfg.slowQueryThreshold {
+		return false
+	}
+
+	qName, qType, final := "-", "-", "-"
+	if len(dnsQ.Question) > 0 {
+		qName = dnsQ.Question[0].Name
+		qType = dns.Type(dnsQ.Question[0].Qtype).String()
+	}
+	if dnsRMeta != nil && len(dnsRMeta.FinalServerUsed) > 0 {
+		final = dnsRMeta.FinalServerUsed
 	}
+	transport := "http"
+	if httpReq.TLS != nil {
+		transport = "https"
+	}
+	fmt.Fprintln(t.stdout, "SQ:"+qName, qType, duration, "F:"+final, transport+logTrace(traceID(httpReq.Header)))
+
+	return true
+}
+
+// echoECS copies the ECS option from the query to the response if the response lacks one. The
+// SourceScope is left at zero. Return true if an ECS option was added.
+func echoECS(dnsQ, dnsR *dns.Msg) bool {
+	_, qECS := dnsutil.FindECS(dnsQ)
+	if qECS == nil {
+		return false
+	}
+	if _, rECS := dnsutil.FindECS(dnsR); rECS != nil {
+		return false
+	}
+	dnsutil.CreateECS(dnsR, int(qECS.Family), int(qECS.SourceNetmask), qECS.Address)
+
+	return true
+}
+
+// minimizeResponse removes all Authority RRs and all Additional RRs except for OPT and TSIG. The
+// Authority section of a response without answers is retained as it normally contains the SOA
+// needed for negative caching. Return true if any RRs were removed.
+func minimizeResponse(dnsR *dns.Msg) bool {
+	removed := false
+	if len(dnsR.Answer) > 0 && len(dnsR.Ns) > 0 {
+		dnsR.Ns = nil
+		removed = true
+	}
+
+	extra := make([]dns.RR, 0, len(dnsR.Extra))
+	for _, rr := range dnsR.Extra {
+		switch rr.(type) {
+		case *dns.OPT, *dns.TSIG:
+			extra = append(extra, rr)
+		default:
+			removed = true
+		}
+	}
+	dnsR.Extra = extra
+
+	return removed
 }
 
 // validateRequest does some preliminary decoding of the HTTP requesst and returns the POST body, if any.
 // Returns serx and a non-empty errMsg if any errors occur.
-func (t *server) validateRequest(httpReq *http.Request) (body []byte, serx serFailureIndex, hsc int, errMsg string) {
+func (t *server) validateRequest(writer http.ResponseWriter, httpReq *http.Request) (body []byte, serx serFailureIndex, hsc int, errMsg string) {
 
 	// Check Method first
 
@@ -329,10 +603,22 @@ func (t *server) validateRequest(httpReq *http.Request) (body []byte, serx serFa
 	}
 
 	// Reading the body should be ok for POST *and* GET. The http.Server closes the Body so we
-	// don't need to worry about that.
+	// don't need to worry about that. Limit the read so a hostile client cannot consume
+	// unbounded memory prior to dns.Unpack() rejecting the message.
 
+	reader := httpReq.Body
+	if cfg.maxRequestSize > 0 {
+		reader = http.MaxBytesReader(writer, httpReq.Body, int64(cfg.maxRequestSize))
+	}
 	var err error
-	body, err = ioutil.ReadAll(httpReq.Body)
+	body, err = ioutil.ReadAll(reader)
+	var maxBytesError *http.MaxBytesError
+	if errors.As(err, &maxBytesError) {
+		serx = serRequestTooLarge
+		hsc = http.StatusRequestEntityTooLarge
+		errMsg = fmt.Sprintf("Error: Request body exceeds %d bytes", cfg.maxRequestSize)
+		return
+	}
 	if err != nil {
 		serx = serBodyReadError
 		hsc = http.StatusBadRequest
@@ -344,8 +630,9 @@ func (t *server) validateRequest(httpReq *http.Request) (body []byte, serx serFa
 }
 
 // decodeQueryParam converts the GET qp into a byte slice ready for converting back into a DNS
-// message. Return serx and a non-empty errMsg if any errors occur.
-func (t *server) decodeQueryParam(httpReq *http.Request) (body []byte, serx serFailureIndex, errMsg string) {
+// message. Return serx, HTTP status code and a non-empty errMsg if any errors occur.
+func (t *server) decodeQueryParam(httpReq *http.Request) (body []byte, serx serFailureIndex, hsc int, errMsg string) {
+	hsc = http.StatusBadRequest
 	qp := httpReq.URL.Query()
 	qpData, ok := qp[consts.Rfc8484QueryParam]
 	if !ok {
@@ -361,6 +648,14 @@ func (t *server) decodeQueryParam(httpReq *http.Request) (body []byte, serx serF
 		return
 	}
 
+	if cfg.maxRequestSize > 0 && len(qpData[0]) > base64.URLEncoding.EncodedLen(cfg.maxRequestSize) {
+		serx = serRequestTooLarge
+		hsc = http.StatusRequestEntityTooLarge
+		errMsg = fmt.Sprintf("Error: Query Param '%s' exceeds %d bytes when decoded",
+			consts.Rfc8484QueryParam, cfg.maxRequestSize)
+		return
+	}
+
 	body, err := base64.URLEncoding.DecodeString(qpData[0])
 	if err != nil {
 		serx = serBadQueryParamDecode
@@ -386,6 +681,8 @@ func (t *server) synthesizeECS(dnsQ *dns.Msg, ecsRequestData, remoteAddr string)
 			serx = serBadPrefixLengths
 			return
 		}
+		ipv4PrefixLen = clampPrefixLength(ipv4PrefixLen, cfg.ecsMaxIPv4PrefixLen)
+		ipv6PrefixLen = clampPrefixLength(ipv6PrefixLen, cfg.ecsMaxIPv6PrefixLen)
 	}
 
 	var ip net.IP
@@ -442,6 +739,52 @@ func extractPrefixLengths(requestData string) (int, int, error) {
 	return int(ipv4PrefixLen), int(ipv6PrefixLen), nil
 }
 
+// isECSExempt returns true if the client address falls within an --ecs-exempt network. An
+// unparseable address is never exempt so that it is reported by synthesizeECS().
+func (t *server) isECSExempt(clientAddr string) bool {
+	if len(t.ecsExempt) == 0 {
+		return false
+	}
+	ip, err := parseRemoteAddr(clientAddr)
+	if err != nil {
+		return false
+	}
+
+	return t.ecsExempt.contains(ip)
+}
+
+// isDebugMetaAllowed returns true if the client address falls within a --debug-meta network.
+func (t *server) isDebugMetaAllowed(clientAddr string) bool {
+	ip, err := parseRemoteAddr(clientAddr)
+	if err != nil {
+		return false
+	}
+
+	return t.debugMeta.contains(ip)
+}
+
+// debugMetaRR returns a TXT RR describing how the query was resolved for --debug-meta clients. It's
+// CHAOS class as it says nothing about the DNS data proper.
+func debugMetaRR(dnsRMeta *resolver.ResponseMetaData) dns.RR {
+	return &dns.TXT{
+		Hdr: dns.RR_Header{Name: consts.TrustyDebugMetaName, Rrtype: dns.TypeTXT, Class: dns.ClassCHAOS},
+		Txt: []string{"server=" + dnsRMeta.FinalServerUsed,
+			"queries=" + strconv.Itoa(dnsRMeta.QueryTries),
+			"servers=" + strconv.Itoa(dnsRMeta.ServerTries),
+			"duration=" + dnsRMeta.ResolutionDuration.String()}}
+}
+
+// clampPrefixLength limits a client requested prefix length to the --ecs-max-* setting so that a
+// client cannot force the disclosure of more of its address than the server allows. A max of zero
+// means there is no limit.
+func clampPrefixLength(prefixLen, max int) int {
+	if max > 0 && prefixLen > max {
+		return max
+	}
+
+	return prefixLen
+}
+
 // parseRemoteAddr parses the IP:port from the HTTP Request's RemoteAddr
 //
 // The http.Request.RemoteAddr value is documented to be of the form ipv4:port or
@@ -470,14 +813,50 @@ func parseRemoteAddr(ra string) (net.IP, error) {
 
 // error is our generic HTTP error responder which constructs the HTTP error
 func (t *server) error(writer http.ResponseWriter, remoteAddr string, statusCode int, msg string) {
+	trace := writer.Header().Get(consts.TrustyTraceHeader) // Echoed by serveDoH
 	http.Error(writer, msg, statusCode)
 	if cfg.logHTTPOut {
-		fmt.Fprintln(t.stdout, "HE:", remoteAddr, statusCode, msg)
+		fmt.Fprintln(t.stdout, "HE:", remoteAddr, statusCode, msg+logTrace(trace))
+	}
+}
+
+// dnsError returns an rcode response, normally SERVFAIL, to the client for a failure which occurs
+// after the query has been unpacked. If the query had an OPT the response carries an rfc8914
+// Extended DNS Error with msg as the extra text along with any opts. In the unlikely event that the
+// response cannot be packed, an HTTP error with statusCode is returned instead.
+func (t *server) dnsError(writer http.ResponseWriter, remoteAddr string, dnsQ *dns.Msg, originalId uint16,
+	queryHasOPT bool, rcode int, statusCode int, infoCode uint16, msg string, opts ...dns.EDNS0) {
+	resp := &dns.Msg{}
+	resp.SetRcode(dnsQ, rcode)
+	resp.RecursionAvailable = true
+	resp.MsgHdr.Id = originalId
+	if queryHasOPT {
+		resp.Extra = append(resp.Extra, dnsutil.NewOPT())
+		dnsutil.AddEDE(resp, infoCode, msg)
+		opt := dnsutil.FindOPT(resp)
+		opt.Option = append(opt.Option, opts...)
+	}
+	body, err := resp.Pack()
+	if err != nil {
+		t.error(writer, remoteAddr, statusCode, msg)
+		return
+	}
+
+	writer.Header().Set(consts.ContentTypeHeader, consts.Rfc8484AcceptValue)
+	writer.Write(body) // Best effort - we're already failing
+	t.addRcodeStats(rcode)
+	if cfg.logHTTPOut {
+		fmt.Fprintln(t.stdout, "HE:", remoteAddr, dns.RcodeToString[rcode],
+			msg+logTrace(writer.Header().Get(consts.TrustyTraceHeader)))
 	}
 }
 
 // stop performs an orderly shutdown of listen sockets. Mainly for tests!
 func (t *server) stop() {
+	if t.reapDone != nil {
+		close(t.reapDone)
+		t.reapDone = nil
+	}
 	if t.server != nil {
 		err := t.server.Shutdown(context.Background())
 		if cfg.logHTTPOut && err != nil {
@@ -485,3 +864,72 @@ func (t *server) stop() {
 		}
 	}
 }
+
+// startReaper wraps the ConnState function so that open connections can be found by key and starts
+// a go-routine which periodically closes those connections the tracker deems to have been idle for
+// longer than maxIdle. Closing the net.Conn causes http.Server to transition it to StateClosed which
+// removes it from both the tracker and conns.
+func (t *server) startReaper(maxIdle time.Duration) {
+	t.conns = make(map[string]net.Conn)
+	t.reapDone = make(chan struct{})
+	trackState := t.server.ConnState
+	t.server.ConnState = func(c net.Conn, state http.ConnState) {
+		key := c.RemoteAddr().String()
+		t.connMu.Lock()
+		switch state {
+		case http.StateNew:
+			t.conns[key] = c
+		case http.StateHijacked, http.StateClosed:
+			delete(t.conns, key)
+		}
+		t.connMu.Unlock()
+		trackState(c, state)
+	}
+
+	done := t.reapDone
+	go func() {
+		ticker := time.NewTicker(maxIdle / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				t.connTrk.Reap(now, maxIdle, t.closeConn)
+			}
+		}
+	}()
+}
+
+// closeConn is the connection tracker reaper callback.
+func (t *server) closeConn(key string) {
+	t.connMu.Lock()
+	c, ok := t.conns[key]
+	t.connMu.Unlock()
+	if !ok {
+		return
+	}
+	if cfg.logHTTPOut {
+		fmt.Fprintln(t.stdout, "HR:", key, "idle connection reaped")
+	}
+	c.Close()
+}
+
+// logECS returns the client subnet suffix appended to log lines if msg contains an ECS
+func logECS(msg *dns.Msg) string {
+	if ecs := dnsutil.ECSString(msg); len(ecs) > 0 {
+		return " ECS:" + ecs
+	}
+
+	return ""
+}
+
+// compactMsg is dnsutil.CompactMsgString() with the extra fields requested by --log-detail
+func compactMsg(msg *dns.Msg) string {
+	opts := dnsutil.CompactNone
+	if cfg.logDetail {
+		opts = dnsutil.CompactDetail
+	}
+
+	return dnsutil.CompactMsgStringWith(msg, opts)
+}
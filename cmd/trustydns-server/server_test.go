@@ -181,6 +181,36 @@ var serverHTTPCases = []*serverHTTPCase{
 		dnsQuestion:     dnsQuestionParams{qId: 0, qType: dns.TypeNS, qName: "example.com."},
 		statusCode:      200},
 
+	{method: http.MethodGet, description: "Expect zero ID to be replaced",
+		httpHeaders:     []header{{consts.ContentTypeHeader, consts.Rfc8484AcceptValue}},
+		httpQueryParams: consts.Rfc8484QueryParam,
+		dnsQuestion:     dnsQuestionParams{qId: 0, qType: dns.TypeNS, qName: "example.com."},
+		statusCode:      200,
+		postDoFunc: func(tc *serverHTTPCase, t *testing.T) bool {
+			if tc.resolver.query.Id == 0 {
+				t.Error("Zero ID was passed through to the resolver")
+			}
+			if tc.httpR.Id != 0 {
+				t.Error("Original zero ID not restored in response", tc.httpR.Id)
+			}
+			return false
+		}},
+
+	{method: http.MethodGet, description: "Expect zero ID to be preserved with preserveZeroId",
+		httpHeaders:     []header{{consts.ContentTypeHeader, consts.Rfc8484AcceptValue}},
+		httpQueryParams: consts.Rfc8484QueryParam,
+		dnsQuestion:     dnsQuestionParams{qId: 0, qType: dns.TypeNS, qName: "example.com."},
+		statusCode:      200,
+		preDoFunc: func(tc *serverHTTPCase, req *http.Request) {
+			cfg.preserveZeroId = true
+		},
+		postDoFunc: func(tc *serverHTTPCase, t *testing.T) bool {
+			if tc.resolver.query.Id != 0 {
+				t.Error("Zero ID was not preserved through to the resolver", tc.resolver.query.Id)
+			}
+			return false
+		}},
+
 	{method: http.MethodPost, description: "Expect a good response to the POST request",
 		httpHeaders: []header{{consts.ContentTypeHeader, consts.Rfc8484AcceptValue}},
 		dnsQuestion: dnsQuestionParams{qId: 1, qType: dns.TypeNS, qName: "example.com."},
@@ -204,6 +234,23 @@ var serverHTTPCases = []*serverHTTPCase{
 		dnsQuestion:     dnsQuestionParams{qId: 1, qType: dns.TypeNS, qName: "example.com."},
 		statusCode:      400, responseBody: "illegal base64"},
 
+	{method: http.MethodPost, description: "Request body too large",
+		httpHeaders: []header{{consts.ContentTypeHeader, consts.Rfc8484AcceptValue}},
+		dnsQuestion: dnsQuestionParams{qId: 1, qType: dns.TypeNS, qName: "example.com."},
+		statusCode:  413, responseBody: "exceeds 16 bytes",
+		preDoFunc: func(tc *serverHTTPCase, req *http.Request) {
+			cfg.maxRequestSize = 16
+		}},
+
+	{method: http.MethodGet, description: "Query Param too large",
+		httpHeaders:     []header{{consts.ContentTypeHeader, consts.Rfc8484AcceptValue}},
+		httpQueryParams: consts.Rfc8484QueryParam,
+		dnsQuestion:     dnsQuestionParams{qId: 1, qType: dns.TypeNS, qName: "example.com."},
+		statusCode:      413, responseBody: "exceeds 16 bytes when decoded",
+		preDoFunc: func(tc *serverHTTPCase, req *http.Request) {
+			cfg.maxRequestSize = 16
+		}},
+
 	{method: http.MethodPost, description: "Unpack failure",
 		httpHeaders: []header{{consts.ContentTypeHeader, consts.Rfc8484AcceptValue}},
 		dnsQuestion: dnsQuestionParams{qId: 1, qType: dns.TypeNS},
@@ -263,13 +310,37 @@ var serverHTTPCases = []*serverHTTPCase{
 		dnsQuestion: dnsQuestionParams{qId: 103, qType: dns.TypeA, qName: "example.com."},
 		statusCode:  200,
 	},
+	{method: http.MethodPost, description: "Synthesize ECS capped",
+		httpHeaders: []header{
+			{consts.ContentTypeHeader, consts.Rfc8484AcceptValue},
+			{consts.TrustySynthesizeECSRequestHeader, "32/128"},
+		},
+		dnsQuestion: dnsQuestionParams{qId: 103, qType: dns.TypeA, qName: "example.com."},
+		statusCode:  200,
+		preDoFunc: func(tc *serverHTTPCase, req *http.Request) {
+			*cfg = tc.saveConfig
+			cfg.ecsMaxIPv4PrefixLen = 20
+			cfg.ecsMaxIPv6PrefixLen = 48
+		},
+		postDoFunc: func(tc *serverHTTPCase, t *testing.T) bool {
+			*cfg = tc.saveConfig
+			_, e := dnsutil.FindECS(&tc.resolver.query)
+			if e == nil {
+				t.Fatal("Synthesis header not acted on by server", tc.resolver.query.String())
+			}
+			if e.SourceNetmask != 20 {
+				t.Error("Synthesis did not cap to cfg.ecsMaxIPv4PrefixLen=20", e.String())
+			}
+			return false
+		}},
 	{method: http.MethodPost, description: "Synthesize ECS ipv4 Nonumer",
 		httpHeaders: []header{
 			{consts.ContentTypeHeader, consts.Rfc8484AcceptValue},
 			{consts.TrustySynthesizeECSRequestHeader, "nonumer/64"},
 		},
 		dnsQuestion: dnsQuestionParams{qId: 104, qType: dns.TypeA, qName: "example.com."},
-		statusCode:  400, responseBody: "Could not convert",
+		statusCode:  200, responseBody: "Could not convert",
+		prePackFunc: addOPT, postDoFunc: expectServfail,
 	},
 	{method: http.MethodPost, description: "Synthesize ECS ipv4 Too Big",
 		httpHeaders: []header{
@@ -277,7 +348,8 @@ var serverHTTPCases = []*serverHTTPCase{
 			{consts.TrustySynthesizeECSRequestHeader, "33/64"},
 		},
 		dnsQuestion: dnsQuestionParams{qId: 105, qType: dns.TypeA, qName: "example.com."},
-		statusCode:  400, responseBody: "not in range 0-32",
+		statusCode:  200, responseBody: "not in range 0-32",
+		prePackFunc: addOPT, postDoFunc: expectServfail,
 	},
 	{method: http.MethodPost, description: "Synthesize ECS ipv6 Nonumer",
 		httpHeaders: []header{
@@ -285,7 +357,8 @@ var serverHTTPCases = []*serverHTTPCase{
 			{consts.TrustySynthesizeECSRequestHeader, "24/nonumer"},
 		},
 		dnsQuestion: dnsQuestionParams{qId: 204, qType: dns.TypeAAAA, qName: "example.com."},
-		statusCode:  400, responseBody: "Could not convert",
+		statusCode:  200, responseBody: "Could not convert",
+		prePackFunc: addOPT, postDoFunc: expectServfail,
 	},
 	{method: http.MethodPost, description: "Synthesize ECS ipv6 Too Big",
 		httpHeaders: []header{
@@ -293,7 +366,8 @@ var serverHTTPCases = []*serverHTTPCase{
 			{consts.TrustySynthesizeECSRequestHeader, "24/129"},
 		},
 		dnsQuestion: dnsQuestionParams{qId: 205, qType: dns.TypeAAAA, qName: "example.com."},
-		statusCode:  400, responseBody: "not in range 0-128",
+		statusCode:  200, responseBody: "not in range 0-128",
+		prePackFunc: addOPT, postDoFunc: expectServfail,
 	},
 	{method: http.MethodPost, description: "Synthesize ECS tokens",
 		httpHeaders: []header{
@@ -301,7 +375,8 @@ var serverHTTPCases = []*serverHTTPCase{
 			{consts.TrustySynthesizeECSRequestHeader, "24/37/67"},
 		},
 		dnsQuestion: dnsQuestionParams{qId: 301, qType: dns.TypeSOA, qName: "example.com."},
-		statusCode:  400, responseBody: "Expected i",
+		statusCode:  200, responseBody: "Expected i",
+		prePackFunc: addOPT, postDoFunc: expectServfail,
 	},
 
 	{method: http.MethodPost, description: "Padding",
@@ -328,12 +403,57 @@ var serverHTTPCases = []*serverHTTPCase{
 		},
 	},
 
+	{method: http.MethodPost, description: "Minimal responses",
+		httpHeaders: []header{{consts.ContentTypeHeader, consts.Rfc8484AcceptValue}},
+		dnsQuestion: dnsQuestionParams{qId: 551, qType: dns.TypeA, qName: "example.com."},
+		statusCode:  200,
+		prePackFunc: func(tc *serverHTTPCase, q *dns.Msg) {
+			q.SetEdns0(dns.DefaultMsgSize, false)
+			setMinimalResponse(&tc.resolver.response)
+		},
+		preDoFunc: func(tc *serverHTTPCase, req *http.Request) {
+			cfg.minimalResponses = true
+		},
+		postDoFunc: func(tc *serverHTTPCase, t *testing.T) bool {
+			if len(tc.httpR.Answer) != 1 {
+				t.Error("Minimal responses removed Answer RRs", tc.httpR.String())
+			}
+			if len(tc.httpR.Ns) != 0 {
+				t.Error("Minimal responses did not remove Authority RRs", tc.httpR.String())
+			}
+			if len(tc.httpR.Extra) != 1 || tc.httpR.IsEdns0() == nil {
+				t.Error("Minimal responses should only leave the OPT in Extra", tc.httpR.String())
+			}
+			return false
+		},
+	},
+
+	{method: http.MethodPost, description: "Minimal responses ignored with DO=1",
+		httpHeaders: []header{{consts.ContentTypeHeader, consts.Rfc8484AcceptValue}},
+		dnsQuestion: dnsQuestionParams{qId: 552, qType: dns.TypeA, qName: "example.com."},
+		statusCode:  200,
+		prePackFunc: func(tc *serverHTTPCase, q *dns.Msg) {
+			q.SetEdns0(dns.DefaultMsgSize, true)
+			setMinimalResponse(&tc.resolver.response)
+		},
+		preDoFunc: func(tc *serverHTTPCase, req *http.Request) {
+			cfg.minimalResponses = true
+		},
+		postDoFunc: func(tc *serverHTTPCase, t *testing.T) bool {
+			if len(tc.httpR.Ns) != 1 || len(tc.httpR.Extra) != 2 {
+				t.Error("Minimal responses should not apply to DO=1 queries", tc.httpR.String())
+			}
+			return false
+		},
+	},
+
 	{method: http.MethodPost, description: "Resolve Error",
 		httpHeaders: []header{
 			{consts.ContentTypeHeader, consts.Rfc8484AcceptValue},
 		},
 		dnsQuestion: dnsQuestionParams{qId: 601, qType: dns.TypeA, qName: "example.com."},
-		statusCode:  503, responseBody: "local resolution failed: server_test_error",
+		statusCode:  200, responseBody: "local resolution failed: server_test_error",
+		prePackFunc: addOPT, postDoFunc: expectServfail,
 		preDoFunc: func(tc *serverHTTPCase, req *http.Request) {
 			tc.resolver.err = fmt.Errorf("server_test_error")
 		},
@@ -344,11 +464,58 @@ var serverHTTPCases = []*serverHTTPCase{
 			{consts.ContentTypeHeader, consts.Rfc8484AcceptValue},
 		},
 		dnsQuestion: dnsQuestionParams{qId: 701, qType: dns.TypeA, qName: "example.com."},
-		statusCode:  503, responseBody: "Pack Failed",
+		statusCode:  200, responseBody: "Pack Failed",
+		prePackFunc: addOPT, postDoFunc: expectServfail,
 		preDoFunc: func(tc *serverHTTPCase, req *http.Request) {
 			tc.resolver.response.Rcode = 0x1000 // Should cause a Pack failure
 		},
 	},
+
+	{method: http.MethodPost, description: "Too many labels",
+		httpHeaders: []header{
+			{consts.ContentTypeHeader, consts.Rfc8484AcceptValue},
+		},
+		dnsQuestion: dnsQuestionParams{qId: 801, qType: dns.TypeA, qName: strings.Repeat("a.", 65)},
+		statusCode:  200, responseBody: "invalid query name",
+		prePackFunc: addOPT,
+		postDoFunc: func(tc *serverHTTPCase, t *testing.T) bool {
+			if tc.httpR.Rcode != dns.RcodeFormatError || tc.httpR.Id != tc.dnsQuestion.qId {
+				t.Error("Expected FORMERR response with original Id", tc.httpR.MsgHdr)
+			}
+			if len(tc.resolver.query.Question) > 0 {
+				t.Error("Invalid query name should not reach the resolver")
+			}
+			return false
+		},
+	},
+}
+
+// addOPT is a prePackFunc which gives the query an OPT so that error responses carry an EDE.
+func addOPT(tc *serverHTTPCase, q *dns.Msg) {
+	q.SetEdns0(4096, false)
+}
+
+// expectServfail is a postDoFunc which checks that a post-unpack failure was returned as a
+// SERVFAIL with an EDE rather than as an HTTP error.
+func expectServfail(tc *serverHTTPCase, t *testing.T) bool {
+	if tc.httpR.Rcode != dns.RcodeServerFailure || tc.httpR.Id != tc.dnsQuestion.qId {
+		t.Error("Expected SERVFAIL response with original Id", tc.httpR.MsgHdr)
+	}
+	if opt := tc.httpR.IsEdns0(); opt == nil || len(opt.Option) != 1 || opt.Option[0].Option() != dns.EDNS0EDE {
+		t.Error("Expected EDE in SERVFAIL response", tc.httpR.String())
+	}
+	return false
+}
+
+// setMinimalResponse populates a response with RRs in all sections for the minimal response tests.
+func setMinimalResponse(r *dns.Msg) {
+	a, _ := dns.NewRR("example.com. IN A 10.0.0.1")
+	ns, _ := dns.NewRR("example.com. IN NS ns.example.com.")
+	glue, _ := dns.NewRR("ns.example.com. IN A 10.0.0.2")
+	r.Answer = []dns.RR{a}
+	r.Ns = []dns.RR{ns}
+	r.Extra = []dns.RR{glue}
+	r.SetEdns0(dns.DefaultMsgSize, false)
 }
 
 // Test via the http.Client.Do() interface - a real HTTP request in other words
@@ -566,6 +733,7 @@ func TestParseRemoteFailure(t *testing.T) {
 
 	msg := &dns.Msg{}
 	msg.SetQuestion("example.com.", dns.TypeMX)
+	msg.SetEdns0(4096, false) // So the error text is returned in an EDE
 	binary, err := msg.Pack()
 	if err != nil {
 		t.Fatal("Packing DNS message for test setup failed unexpectedly", err)
@@ -585,6 +753,31 @@ func TestParseRemoteFailure(t *testing.T) {
 	if !strings.Contains(response, "Invalid RemoteAddr") {
 		t.Error("Expected 'Invalid RemoteAddr' got,", response)
 	}
+
+	// Without an OPT in the query the SERVFAIL must not have one either (rfc6891)
+
+	msg.Extra = nil
+	binary, err = msg.Pack()
+	if err != nil {
+		t.Fatal("Packing DNS message for test setup failed unexpectedly", err)
+	}
+	r, err = http.NewRequest("POST", "http://localhost", bytes.NewReader(binary))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "application/dns-message")
+	r.Header.Set("X-trustydns-Synth", "24/64")
+	r.RemoteAddr = "256.257.258.259:80"
+	mw = newMockResponseWriter()
+	s.serveDoH(mw, r)
+
+	resp := &dns.Msg{}
+	if err := resp.Unpack(mw.writeBuffer); err != nil {
+		t.Fatal("Could not unpack error response", err)
+	}
+	if resp.Rcode != dns.RcodeServerFailure || resp.IsEdns0() != nil {
+		t.Error("Expected SERVFAIL without OPT", resp.String())
+	}
 }
 
 // Test via serverDoH directly
@@ -681,3 +874,280 @@ func TestClientVerificationFailure(t *testing.T) {
 		t.Error("Expected 'cannot validate certificate' error message, not", err)
 	}
 }
+
+// Two servers should be able to share the same address with --reuse-port
+func TestServerReusePort(t *testing.T) {
+	mainInit(&mutexBytesBuffer{}, &mutexBytesBuffer{})
+	cfg.reusePort = true
+	l, err := net.Listen("tcp", "127.0.0.1:0") // Find a free port
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	errorChannel := make(chan error, 2)
+	wg := &sync.WaitGroup{}
+	s1 := &server{stdout: &mutexBytesBuffer{}, local: &mockResolver{}, listenAddress: addr}
+	s2 := &server{stdout: &mutexBytesBuffer{}, local: &mockResolver{}, listenAddress: addr}
+	s1.start(nil, errorChannel, wg)
+	s2.start(nil, errorChannel, wg)
+	select {
+	case err := <-errorChannel:
+		t.Fatal("Unexpected start error with --reuse-port", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	s1.stop()
+	s2.stop()
+	wg.Wait()
+}
+
+// A faithfully packed response should not trigger a --validate-roundtrip warning
+func TestValidateRoundtrip(t *testing.T) {
+	out := &mutexBytesBuffer{}
+	mainInit(out, out)
+	cfg.validateRoundtrip = true
+	resolver := &mockResolver{}
+	setMinimalResponse(&resolver.response)
+	s := &server{stdout: out, local: resolver}
+
+	q := &dns.Msg{}
+	q.SetQuestion("example.com.", dns.TypeA)
+	q.SetEdns0(dns.DefaultMsgSize, false)
+	body, err := q.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, consts.Rfc8484Path, bytes.NewReader(body))
+	req.Header.Set(consts.ContentTypeHeader, consts.Rfc8484AcceptValue)
+	rec := httptest.NewRecorder()
+	s.serveDoH(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatal("Expected 200 response, not", rec.Code, rec.Body.String())
+	}
+	if s.successCount != 1 || s.eventCounters[evRoundtripMismatch] != 0 {
+		t.Error("Unexpected stats", s.successCount, s.eventCounters)
+	}
+	if strings.Contains(out.String(), "VE:") {
+		t.Error("Unexpected roundtrip warning", out.String())
+	}
+}
+
+// Test via serverDoH directly that exempt clients have their ECS passed through unchanged
+func TestECSExempt(t *testing.T) {
+	mainInit(os.Stdout, os.Stderr)
+
+	exempt, err := parseNetworks([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		remoteAddr string
+		netmask    uint8 // Expected SourceNetmask of the ECS sent to the resolver
+	}{
+		{"10.1.1.1:80", 16},  // Exempt so original ECS is retained
+		{"192.0.2.1:80", 24}, // Not exempt so ECS is synthesized from the header
+	}
+
+	for ix, tc := range testCases {
+		resolver := &mockResolver{}
+		s := &server{stdout: stdout, local: resolver, ecsExempt: exempt}
+		mw := newMockResponseWriter()
+
+		msg := &dns.Msg{}
+		msg.SetQuestion("example.com.", dns.TypeMX)
+		dnsutil.CreateECS(msg, 1, 16, net.IPv4(198, 51, 100, 0))
+		binary, err := msg.Pack()
+		if err != nil {
+			t.Fatal("Packing DNS message for test setup failed unexpectedly", err)
+		}
+
+		r, err := http.NewRequest("POST", "http://localhost", bytes.NewReader(binary))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", "application/dns-message")
+		r.Header.Set("X-trustydns-Synth", "24/64")
+		r.RemoteAddr = tc.remoteAddr
+		s.serveDoH(mw, r)
+
+		if mw.statusCode != 0 {
+			t.Fatal(ix, "Request failed", mw.statusCode, mw.String())
+		}
+		_, e := dnsutil.FindECS(&resolver.query)
+		if e == nil {
+			t.Fatal(ix, "ECS missing from resolver query", resolver.query.String())
+		}
+		if e.SourceNetmask != tc.netmask {
+			t.Error(ix, "Expected netmask", tc.netmask, "got", e.String())
+		}
+	}
+}
+
+func TestEchoECS(t *testing.T) {
+	q := &dns.Msg{}
+	q.SetQuestion("example.com.", dns.TypeA)
+	r := &dns.Msg{}
+	r.SetReply(q)
+	if echoECS(q, r) {
+		t.Error("echoECS should not add ECS when the query has none")
+	}
+
+	dnsutil.CreateECS(q, 1, 24, net.IPv4(192, 0, 2, 0))
+	if !echoECS(q, r) {
+		t.Fatal("echoECS did not add ECS to response")
+	}
+	_, e := dnsutil.FindECS(r)
+	if e == nil || e.SourceNetmask != 24 || e.SourceScope != 0 || !e.Address.Equal(net.IPv4(192, 0, 2, 0)) {
+		t.Error("Echoed ECS does not match query", e)
+	}
+	if echoECS(q, r) {
+		t.Error("echoECS should not add ECS when the response already has one")
+	}
+}
+
+func TestDebugMeta(t *testing.T) {
+	mainInit(os.Stdout, os.Stderr)
+
+	allowed, err := parseNetworks([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		remoteAddr string
+		option     bool // Whether the query asks for meta data
+		expect     bool // Whether the meta data TXT is expected
+	}{
+		{"10.1.1.1:80", true, true},
+		{"10.1.1.1:80", false, false},
+		{"192.0.2.1:80", true, false}, // Not allowed
+	}
+
+	for ix, tc := range testCases {
+		resolver := &mockResolver{}
+		resolver.rMeta.FinalServerUsed = "192.0.2.53:53"
+		resolver.rMeta.QueryTries = 2
+		resolver.rMeta.ServerTries = 1
+		s := &server{stdout: stdout, local: resolver, debugMeta: allowed}
+		mw := newMockResponseWriter()
+
+		msg := &dns.Msg{}
+		msg.SetQuestion("example.com.", dns.TypeA)
+		msg.SetEdns0(1232, false)
+		if tc.option {
+			opt := msg.IsEdns0()
+			opt.Option = append(opt.Option, &dns.EDNS0_LOCAL{Code: consts.TrustyDebugMetaOption})
+		}
+		binary, err := msg.Pack()
+		if err != nil {
+			t.Fatal("Packing DNS message for test setup failed unexpectedly", err)
+		}
+
+		r, err := http.NewRequest("POST", "http://localhost", bytes.NewReader(binary))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", "application/dns-message")
+		r.RemoteAddr = tc.remoteAddr
+		s.serveDoH(mw, r)
+
+		if mw.statusCode != 0 {
+			t.Fatal(ix, "Request failed", mw.statusCode, mw.String())
+		}
+		opt := resolver.query.IsEdns0()
+		if opt == nil || opt.UDPSize() != 1232 {
+			t.Error(ix, "OPT not retained after removing debug meta option", resolver.query.String())
+		} else if len(opt.Option) > 0 {
+			t.Error(ix, "Debug meta option passed on to resolver", opt.String())
+		}
+		resp := &dns.Msg{}
+		if err := resp.Unpack(mw.writeBuffer); err != nil {
+			t.Fatal(ix, err)
+		}
+		var txt *dns.TXT
+		for _, rr := range resp.Extra {
+			if rr.Header().Name == consts.TrustyDebugMetaName {
+				txt, _ = rr.(*dns.TXT)
+			}
+		}
+		if (txt != nil) != tc.expect {
+			t.Error(ix, "Expected debug meta TXT", tc.expect, "got", resp.String())
+			continue
+		}
+		if txt != nil && strings.Join(txt.Txt[:3], " ") != "server=192.0.2.53:53 queries=2 servers=1" {
+			t.Error(ix, "Unexpected debug meta TXT", txt.String())
+		}
+	}
+}
+
+func TestInjectDelay(t *testing.T) {
+	mainInit(os.Stdout, os.Stderr)
+	cfg.injectDelay = time.Millisecond * 100
+
+	for _, fraction := range []float64{0, 1} {
+		cfg.injectDelayFraction = fraction
+		s := &server{stdout: stdout, local: &mockResolver{}}
+		mw := newMockResponseWriter()
+		msg := &dns.Msg{}
+		msg.SetQuestion("example.com.", dns.TypeA)
+		binary, err := msg.Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := http.NewRequest("POST", "http://localhost", bytes.NewReader(binary))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", "application/dns-message")
+		start := time.Now()
+		s.serveDoH(mw, r)
+		delayed := time.Since(start) >= cfg.injectDelay
+		if delayed != (fraction == 1) {
+			t.Error("Fraction", fraction, "expected delay", fraction == 1, "got", time.Since(start))
+		}
+		if s.successCount != 1 {
+			t.Error("Delayed request should still succeed", s.stats)
+		}
+	}
+}
+
+// Test that --slow-query-threshold logs and counts slow queries regardless of other log settings
+func TestServeDoHSlowQuery(t *testing.T) {
+	out := &bytes.Buffer{}
+	mainInit(out, os.Stderr)
+	res := &mockResolver{rMeta: resolver.ResponseMetaData{FinalServerUsed: "192.0.2.53"}}
+	s := &server{stdout: out, local: res}
+
+	q := &dns.Msg{}
+	q.SetQuestion("example.net.", dns.TypeTXT)
+	binary, err := q.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve := func() {
+		r, err := http.NewRequest("POST", "http://localhost", bytes.NewReader(binary))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", "application/dns-message")
+		s.serveDoH(newMockResponseWriter(), r)
+	}
+
+	cfg.slowQueryThreshold = time.Hour
+	serve()
+	if out.Len() != 0 || s.eventCounters[evSlow] != 0 {
+		t.Error("Fast query should not be logged or counted", out.String(), s.stats)
+	}
+
+	cfg.slowQueryThreshold = time.Nanosecond
+	serve()
+	outStr := out.String()
+	if !strings.HasPrefix(outStr, "SQ:example.net. TXT ") || !strings.Contains(outStr, " F:192.0.2.53 http\n") {
+		t.Error("Slow query not logged as expected", outStr)
+	}
+	if s.eventCounters[evSlow] != 1 {
+		t.Error("Slow query not counted", s.stats)
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+)
+
+// activatedServers creates a server for each socket passed via systemd socket activation. Only
+// stream sockets are acceptable as DoH runs over TCP. The files are closed as the net package dups
+// them.
+func activatedServers(files []*os.File, rs *resources) ([]*server, error) {
+	var servers []*server
+	for _, f := range files {
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s is not a TCP socket: %s", f.Name(), err.Error())
+		}
+		servers = append(servers, &server{stdout: stdout, local: rs.resolver, listenAddress: l.Addr().String(),
+			listener: l, trusted: rs.trustedProxies, ecsExempt: rs.ecsExempt, debugMeta: rs.debugMeta,
+			ednsAllowed: rs.ednsPassthrough, cookies: rs.cookies})
+	}
+
+	return servers, nil
+}
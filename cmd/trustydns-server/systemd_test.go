@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestActivatedServers(t *testing.T) {
+	mainInit(&mutexBytesBuffer{}, &mutexBytesBuffer{})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	lf, err := l.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	servers, err := activatedServers([]*os.File{lf}, &resources{})
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if len(servers) != 1 {
+		t.Fatal("Expected one server, not", len(servers))
+	}
+	s := servers[0]
+	if s.listenAddress != l.Addr().String() {
+		t.Error("Server should be listening on", l.Addr(), "not", s.listenAddress)
+	}
+
+	errorChannel := make(chan error, 1)
+	wg := &sync.WaitGroup{}
+	s.start(nil, errorChannel, wg)
+	resp, err := http.Get("http://" + s.listenAddress + "/")
+	if err != nil {
+		t.Error("Activated server did not respond", err)
+	} else {
+		resp.Body.Close()
+	}
+	s.stop()
+	wg.Wait()
+	err = <-errorChannel
+	if err != http.ErrServerClosed {
+		t.Error("Expected ErrServerClosed, not", err)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	_, err = activatedServers([]*os.File{r}, &resources{})
+	if err == nil || !strings.Contains(err.Error(), "not a TCP socket") {
+		t.Error("Expected 'not a TCP socket' error with a pipe, not", err)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+)
+
+const maxTraceIDLength = 64
+
+// traceID returns the trace ID supplied by the proxy in the X-trustydns-Trace header. Since the ID
+// ends up in our logs it is ignored unless it is of modest length and only contains characters
+// which cannot masquerade as log syntax. An empty string is returned if there is no usable ID.
+func traceID(h http.Header) string {
+	id := h.Get(consts.TrustyTraceHeader)
+	if len(id) == 0 || len(id) > maxTraceIDLength {
+		return ""
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return ""
+		}
+	}
+
+	return id
+}
+
+// logTrace formats a trace ID for appending to a log line. Nothing is appended if there is no ID so
+// the logs of untraced requests are unchanged.
+func logTrace(id string) string {
+	if len(id) == 0 {
+		return ""
+	}
+
+	return " T:" + id
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestTraceID(t *testing.T) {
+	testCases := []struct {
+		value  string
+		expect string
+	}{
+		{"", ""},
+		{"0123456789abcdef", "0123456789abcdef"},
+		{"req-42_x.Y", "req-42_x.Y"},
+		{"two words", ""},
+		{"bad\nnewline", ""},
+		{strings.Repeat("a", maxTraceIDLength), strings.Repeat("a", maxTraceIDLength)},
+		{strings.Repeat("a", maxTraceIDLength+1), ""},
+	}
+	for _, tc := range testCases {
+		h := http.Header{}
+		h.Set(consts.TrustyTraceHeader, tc.value)
+		if got := traceID(h); got != tc.expect {
+			t.Errorf("traceID(%q) expected %q got %q", tc.value, tc.expect, got)
+		}
+	}
+}
+
+// A trace ID from the proxy should be echoed in the response and appear in the logs
+func TestTraceEcho(t *testing.T) {
+	out := &mutexBytesBuffer{}
+	mainInit(out, &mutexBytesBuffer{})
+	cfg.logHTTPIn = true
+	cfg.logHTTPOut = true
+	cfg.logClientIn = true
+	cfg.logClientOut = true
+
+	res := &mockResolver{}
+	res.response.SetQuestion("example.net.", dns.TypeA)
+	res.response.Response = true
+	s := &server{stdout: out, local: res}
+	httpServer := httptest.NewServer(s.newRouter())
+	defer httpServer.Close()
+
+	q := &dns.Msg{}
+	q.SetQuestion("example.net.", dns.TypeA)
+	binary, _ := q.Pack()
+	req, _ := http.NewRequest(http.MethodPost, httpServer.URL+consts.Rfc8484Path, bytes.NewReader(binary))
+	req.Header.Set(consts.ContentTypeHeader, consts.Rfc8484AcceptValue)
+	req.Header.Set(consts.TrustyTraceHeader, "feedface")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if hv := resp.Header.Get(consts.TrustyTraceHeader); hv != "feedface" {
+		t.Error("Trace ID not echoed", hv)
+	}
+	logs := out.String()
+	for _, prefix := range []string{"HI:", "CI:", "CO:", "HO:"} {
+		found := false
+		for _, line := range strings.Split(logs, "\n") {
+			if strings.HasPrefix(line, prefix) && strings.HasSuffix(line, " T:feedface") {
+				found = true
+			}
+		}
+		if !found {
+			t.Error(prefix, "log line lacks trace ID", logs)
+		}
+	}
+
+	// Errors echo the trace ID too
+
+	req, _ = http.NewRequest(http.MethodPost, httpServer.URL+consts.Rfc8484Path, bytes.NewReader(binary))
+	req.Header.Set(consts.TrustyTraceHeader, "deadbeef") // Missing Content-Type
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if hv := resp.Header.Get(consts.TrustyTraceHeader); hv != "deadbeef" {
+		t.Error("Trace ID not echoed in error response", hv)
+	}
+	if !strings.Contains(out.String(), " T:deadbeef") {
+		t.Error("HE: log line lacks trace ID", out.String())
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net"
+	"os"
+	"sync/atomic"
+)
+
+// listenUnix opens a Unix domain socket listener at path. A stale socket left behind by a previous
+// process which did not exit cleanly is removed first, but any other type of file is left alone so
+// that a mistyped path cannot destroy something important.
+func listenUnix(path string) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &unixListener{Listener: l}, nil
+}
+
+// unixListener gives each accepted connection a distinct loopback RemoteAddr of the form
+// 127.0.0.1:N. Unix domain socket peers are unnamed so they would otherwise all share the one empty
+// address which confuses the connection tracker and cannot be parsed by the ECS, --debug-meta and
+// --trusted-proxy checks. A local peer has the same standing as a loopback TCP client in any case.
+type unixListener struct {
+	net.Listener
+	seq uint32
+}
+
+type unixConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (t *unixListener) Accept() (net.Conn, error) {
+	c, err := t.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	port := int(atomic.AddUint32(&t.seq, 1)%65535) + 1
+
+	return &unixConn{Conn: c, remote: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}}, nil
+}
+
+func (t *unixConn) RemoteAddr() net.Addr {
+	return t.remote
+}
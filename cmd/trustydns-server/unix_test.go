@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Exercise a complete DoH query over a --unix-socket listener, including removal of a stale socket
+// file and confirmation that the client appears to come from the loopback address.
+func TestUnixSocket(t *testing.T) {
+	mainInit(&mutexBytesBuffer{}, &mutexBytesBuffer{})
+	cfg.tlsServerKeyFiles.Set("testdata/server.key") // Must be ignored for the unix socket
+	path := filepath.Join(t.TempDir(), "doh.sock")
+
+	stale, err := net.Listen("unix", path) // Leave a stale socket file behind
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	if _, err := os.Lstat(path); err != nil {
+		t.Fatal("Stale socket was not left behind", err)
+	}
+
+	res := &mockResolver{}
+	res.response.SetQuestion("example.net.", dns.TypeA)
+	res.response.Response = true
+	s := &server{stdout: &mutexBytesBuffer{}, local: res, listenAddress: path, unixSocket: true}
+	errorChannel := make(chan error, 1)
+	wg := &sync.WaitGroup{}
+	s.start(nil, errorChannel, wg)
+	defer s.stop()
+	select {
+	case err := <-errorChannel:
+		t.Fatal("Unexpected start error", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if ln := s.listenName(); ln != "(HTTP on unix:"+path+")" {
+		t.Error("listenName wrong", ln)
+	}
+
+	client := http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		}}}
+	q := &dns.Msg{}
+	q.SetQuestion("example.net.", dns.TypeA)
+	binary, _ := q.Pack()
+	req, _ := http.NewRequest(http.MethodPost, "http://localhost"+consts.Rfc8484Path, bytes.NewReader(binary))
+	req.Header.Set(consts.ContentTypeHeader, consts.Rfc8484AcceptValue)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal("Expected 200, got", resp.Status, string(body))
+	}
+	if res.query.Question[0].Name != "example.net." {
+		t.Error("Resolver did not see query", res.query.String())
+	}
+}
+
+func TestUnixSocketNotClobbered(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "regular")
+	if err := ioutil.WriteFile(path, []byte("keep"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err := listenUnix(path)
+	if err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Error("Expected address in use error, not", err)
+	}
+	if b, _ := ioutil.ReadFile(path); string(b) != "keep" {
+		t.Error("Regular file was modified", string(b))
+	}
+}
+
+func TestUnixListenerRemoteAddr(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ra.sock")
+	l, err := listenUnix(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	seen := map[string]bool{}
+	for ix := 0; ix < 2; ix++ {
+		c, err := net.Dial("unix", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		ac, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ac.Close()
+		ra := ac.RemoteAddr().String()
+		if !strings.HasPrefix(ra, "127.0.0.1:") {
+			t.Error("RemoteAddr should be loopback, not", ra)
+		}
+		seen[ra] = true
+	}
+	if len(seen) != 2 {
+		t.Error("Expected distinct RemoteAddrs", seen)
+	}
+}
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"io"
 	"text/template"
 	"time"
+
+	"github.com/markdingo/trustydns/internal/flagutil"
+	"github.com/markdingo/trustydns/internal/resolver/local"
 )
 
 // The "flag" package is not tty aware so we've arbitrarily picked 100 columns as a conservative tty
@@ -24,7 +28,70 @@ DESCRIPTION
           hijacking or snooping.
 
           The wildcard interface address and default HTTPS port are used if no listen addresses are
-          specified.
+          specified and there is no --unix-socket.
+
+          For sidecar deployments where {{.ProxyProgramName}} runs on the same system,
+          --unix-socket serves plain HTTP on a Unix domain socket which avoids both the TCP
+          overhead and any network exposure. TLS is never used on this socket even if -K is set.
+          Clients on the socket are treated as if they connected from 127.0.0.1 for the purposes
+          of --debug-meta, --trusted-proxy and ECS synthesis. The socket is passed on by a SIGUSR2
+          restart along with all other listen sockets.
+
+          Queries are normally resolved by the nameservers in the -c resolv.conf. Additional
+          resolv.conf files supplied with --resolv-conf are consulted in the order given for
+          queries within their 'domain' or 'search' names. A query within the names of more than
+          one file is resolved by the first such file and -c always has the highest priority.
+          Queries outside all these names are resolved by the -c nameservers.
+
+          Queries within the zone of a --zone-file are answered authoritatively from that RFC1035
+          master file ahead of any resolv.conf. Each file must contain exactly one SOA which
+          defines the zone. Names at or below a delegation within the zone are resolved as if the
+          zone was not present. Wildcards are expanded and a CNAME which leads out of the zone is
+          chased by the other zones and resolv.conf files so that clients get a complete answer.
+          Zone files are read once at startup.
+
+          The first query for a popular name after a restart can be slow if the backend resolvers
+          have cold caches. Names listed in a --warm-file, one 'qname [qtype]' per line, are
+          resolved in the background at startup and optionally every --warm-interval thereafter.
+
+          Clients which open connections and never close them tie up server resources. If
+          --reap-idle is set, connections which have carried no requests for that duration are
+          closed by the server. Well-behaved clients simply reconnect when they next need to.
+
+          Normally a query which all local nameservers fail to resolve results in an HTTP error.
+          With --max-ttl-on-error, the last good response for the same question, ECS subnet and
+          DO bit is returned instead, or if there is none, a SERVFAIL with an Extended DNS Error.
+          The TTLs of these responses are capped at the --max-ttl-on-error duration so clients
+          soon try again.
+
+          CHAOS class queries are never passed to the local resolvers. TXT queries for version.bind
+          are answered with --chaos-version and those for hostname.bind and id.server with
+          --chaos-hostname. All other CHAOS queries, and those for which no string is configured,
+          are REFUSED so that nothing is disclosed by default.
+
+          Queries for qtype ANY are popular in amplification attacks and can be expensive for the
+          local resolvers to answer. By default they are resolved like any other query. With
+          --any-policy hinfo they are answered with a single synthesized HINFO RR as described in
+          rfc8482 and with --any-policy refuse they are REFUSED.
+
+          To help debug remote deployments, clients within a --debug-meta network can ask for the
+          backend nameserver used and the number of tries taken to resolve their query. These are
+          returned as a TXT RR named {{.TrustyDebugMetaName}} in the Additional section. Use
+          {{.DigProgramName}} --debug-meta to ask for them.
+
+          EDNS0 options other than ECS and padding, which have their own handling, are removed from
+          queries before they are passed to the local resolvers unless they are listed with
+          --edns-passthrough. Options can be named (COOKIE, KEYTAG, NSID, EXPIRE, TCP-KEEPALIVE,
+          EDE, LLQ, DAU, DHU, N3U) or given as decimal option codes.
+
+          With --enable-server-cookies, {{.ServerProgramName}} acts as the server end of an rfc7873
+          DNS cookie exchange with its DoH clients. This protects against spoofed queries and
+          responses between client and server even over plain HTTP. A query with a client cookie
+          is answered with a server cookie derived from the client cookie, the client address and
+          a secret which is rotated hourly. A query with a server cookie which does not verify or
+          which is more than an hour old is answered with BADCOOKIE and a fresh server cookie. A
+          malformed COOKIE option results in FORMERR. COOKIE options are consumed by
+          {{.ServerProgramName}} so --edns-passthrough COOKIE has no effect with this option.
 
 INVOCATION
           The simplest invocation is:
@@ -33,10 +100,61 @@ INVOCATION
 
           at which point you should be able to send DoH queries to the default listen address.
 
+          Options can also be supplied in a file named by --config. Each line of the file is of
+          the form "option = value" where option is the name without leading dashes, eg:
+
+              # Example {{.ServerProgramName}} config file
+              report-format = json
+              log-client-out
+
+          Options can also be supplied by environment variables named {{.EnvironmentPrefix}} followed by
+          the upper-cased option name with dashes replaced by underscores, eg:
+          {{.EnvironmentPrefix}}REPORT_FORMAT=json. The values of repeatable options are separated by
+          commas, eg: {{.EnvironmentPrefix}}A=127.0.0.1,::1. The -h, --version, --version-json and
+          --check options are never taken from the environment.
+
+          Options given on the command line override those in the environment which override
+          those in the file which in turn override the defaults. An option which can be repeated
+          may appear on multiple lines but is ignored entirely if it is set by a higher
+          precedence source.
+
           When {{.ServerProgramName}} is invoked with a TLS Key File the listen connections accept
           HTTPS connections otherwise the listen connections accept HTTP connections. Normally HTTP
           will only be used for testing purposes and is not specified to work for DoH in general.
 
+SIGNALS
+          SIGUSR1 prints a status report without resetting counters. SIGHUP re-checks the addresses
+          of any --interface. SIGUSR2 gracefully restarts {{.ServerProgramName}}: a new copy of the
+          program is started with the same command line and inherits all listen sockets. Once the
+          new copy is ready the old one stops accepting, completes in-flight requests and exits. If
+          the new copy fails to start, the old one carries on as normal. Graceful restart is
+          normally used to upgrade the program binary without refusing any connections.
+
+          While stopping, whether due to a signal or a graceful restart, {{.ServerProgramName}}
+          waits for in-flight requests to complete. The number of connections and requests still
+          in-flight is printed every second with --verbose and with the status report printed in
+          response to SIGUSR1, e.g. "Draining: conns=3 requests=1".
+
+          If --profile-dir is set, SIGUSR2 instead writes a goroutine dump and a heap profile into
+          that directory and {{.ServerProgramName}} carries on as normal. Graceful restart is not
+          available in this mode. The heap profile is for "go tool pprof".
+
+DEBUGGING
+          Queries which take longer than --slow-query-threshold to resolve are printed with an
+          "SQ:" prefix regardless of the --log-* settings. Each line shows the qName, qType,
+          resolution time, the final local server used and whether the query arrived over HTTP or
+          HTTPS. The number of slow queries is included in the status report.
+
+          A request carrying an X-trustydns-Trace header, as sent by {{.ProxyProgramName}} --trace,
+          has its trace ID appended as "T:id" to its HI:, CI:, CO:, HO:, HE: and SQ: lines and
+          echoed in the response headers. This correlates a query across the logs of both tiers.
+
+          --debug-listen starts a separate HTTP listener which serves the Go expvar variables at
+          /debug/vars and the pprof handlers at /debug/pprof/. The status counters appear in
+          /debug/vars as "{{.ServerProgramName}}". As these expose the internals of the process the
+          address must be a loopback address. If the address cannot be opened, such as during a
+          graceful restart, {{.ServerProgramName}} reports an error and carries on without it.
+
 COMPANION PROXY
           {{.ProxyProgramName}} is a full-featured DoH proxy which is normally packaged with
           {{.ServerProgramName}}. While {{.ServerProgramName}} and {{.ProxyProgramName}} have a few feature extensions
@@ -61,11 +179,28 @@ EDNS0 CLIENT SUBNET (ECS)
 
           2. If the HTTP request contains an ECS synthesis header then an ECS option is created from
              the HTTPS client IP address using the prefix lengths supplied in the synthesis header.
+             These prefix lengths are capped by --ecs-max-ipv4-prefixlen and
+             --ecs-max-ipv6-prefixlen if set.
 
           3. If no synthesis header is present and --ecs-set is set (either explicitly or due to
              presence of one of the --ecs-set-*-prefixlen options) then an ECS option is created
              from the HTTPS client IP address and the corresponding --ecs-set-*-prefixlen option.
 
+          If {{.ServerProgramName}} sits behind a reverse proxy or load balancer then the HTTPS
+          client IP address is that of the proxy rather than the originating client. Nominating
+          the proxy with --trusted-proxy causes its X-Forwarded-For header to be used to determine
+          the originating client IP address for ECS synthesis. The X-Forwarded-For header from any
+          other peer is ignored to prevent spoofing.
+
+          Queries from clients within an --ecs-exempt network bypass all of the above processing
+          and are resolved with their original ECS option, if any, unchanged. This is typically
+          used to avoid leaking internal network topology to authoritative servers.
+
+          If the local resolver does not return an ECS option in response to a synthesized ECS
+          option then --ecs-echo adds a copy of the synthesized option to the response with a
+          scope prefix length of zero. This satisfies downstream ECS-aware caches which expect
+          to see the query ECS reflected in the response.
+
 ECS CAVEATS
           The EDNS0 CLIENT SUBNET option is documented as an "Informational" rather than a
           "Standards Track" RFC. In part this is because it is only of use to a relatively small
@@ -78,21 +213,36 @@ ECS CAVEATS
           query.
 
 OPTIONS
+          [--config path]
           [-hjv]
-          [-A listen Address[:port] ...]
-
-          [-c resolv.conf for issuing DNS queries]
-          [-i status-report-interval] [-t remote request timeout]
-
+          [-A listen Address[:port] ...] [--interface name ...] [--systemd]
+          [--reuse-port] [--unix-socket path]
+
+          [-c resolv.conf for issuing DNS queries] [--resolv-conf resolv.conf ...]
+          [--zone-file path ...]
+          [-i status-report-interval] [--report-format text|json]
+          [-t remote request timeout]
+          [--udp-buffer-size size] [--parallel-local count] [--hybrid-local]
+          [--reorder-local] [--max-request-size bytes] [--reap-idle duration]
+          [--max-ttl-on-error duration]
+          [--warm-file path [--warm-interval duration]]
+
+          [--ecs-max-ipv4-prefixlen prefix-len] [--ecs-max-ipv6-prefixlen prefix-len]
           [--ecs-remove] [--ecs-set]
           [--ecs-set-ipv4-prefixlen prefix-len]
           [--ecs-set-ipv6-prefixlen prefix-len]
+          [--ecs-echo] [--ecs-exempt IP/CIDR ...] [--trusted-proxy IP/CIDR ...]
+
+          [--chaos-version string] [--chaos-hostname string] [--debug-meta IP/CIDR ...]
+          [--edns-passthrough option ...] [--any-policy forward|hinfo|refuse]
+          [--enable-server-cookies]
+          [--minimal-responses] [--preserve-zero-id] [--validate-roundtrip]
 
           [--log-client-in] [--log-client-out]
           [--log-http-in] [--log-http-out]
           [--log-local-in] [--log-local-out]
           [--log-tls-errors]
-          [--log-all]
+          [--log-detail] [--log-all] [--slow-query-threshold duration]
 
           [--tls-cert TLS Server Certificate file] ...
           [--tls-key TLS Server Key file] ...
@@ -100,15 +250,19 @@ OPTIONS
           [--tls-use-system-roots]
 
           [--gops] [--cpu-profile file] [--mem-profile file]
+          [--profile-dir directory] [--debug-listen address]
 
           [--user userName] [--group groupName] [--chroot directory]
 
-          [--version]
+          [--check] [--version] [--version-json]
 
 `
 
 //////////////////////////////////////////////////////////////////////
 
+// hiddenFlags are accepted on the command line but not shown in the usage message.
+var hiddenFlags = map[string]bool{"inject-delay": true, "inject-delay-fraction": true}
+
 func usage(out io.Writer) {
 	tmpl, err := template.New("usage").Parse(usageMessageTemplate)
 	if err != nil {
@@ -119,7 +273,15 @@ func usage(out io.Writer) {
 		panic(err) // We've messed up our template
 	}
 	flagSet.SetOutput(out) // This is permanent so we assume an exit summarily
-	flagSet.PrintDefaults()
+	visible := flag.NewFlagSet(flagSet.Name(), flag.ContinueOnError)
+	visible.SetOutput(out)
+	flagSet.VisitAll(func(f *flag.Flag) {
+		if !hiddenFlags[f.Name] {
+			visible.Var(f.Value, f.Name, f.Usage)
+			visible.Lookup(f.Name).DefValue = f.DefValue
+		}
+	})
+	visible.PrintDefaults()
 	fmt.Fprintln(out, "\nVersion:", consts.Version)
 }
 
@@ -131,20 +293,94 @@ func parseCommandLine(args []string) error {
 
 	flagSet.Var(&cfg.listenAddresses, "A",
 		"Listen `address` to accept DoH queries (default "+defaultListenAddress+")")
+	flagSet.Var(&cfg.interfaces, "interface",
+		"Listen on all addresses of interface `name`. Addresses are re-checked on SIGHUP")
+	flagSet.StringVar(&cfg.unixSocket, "unix-socket", "",
+		"Also serve plain HTTP on the Unix domain socket `path`")
+	flagSet.BoolVar(&cfg.reusePort, "reuse-port", false,
+		"Set SO_REUSEPORT on listen sockets so multiple processes can share them")
+	flagSet.BoolVar(&cfg.systemd, "systemd", false,
+		"Serve on sockets passed by systemd socket activation instead of -A addresses")
 
 	flagSet.StringVar(&cfg.resolvConf, "c", "/etc/resolv.conf", "resolv.conf `file` for issuing DNS queries")
+	flagSet.Var(&cfg.resolvConfs, "resolv-conf",
+		"Additional resolv.conf `file` consulted for its domains after -c (can be repeated)")
+	flagSet.Var(&cfg.zoneFiles, "zone-file",
+		"Answer authoritatively from the RFC1035 zone `file` ahead of -c (can be repeated)")
+	flagSet.IntVar(&cfg.udpBufferSize, "udp-buffer-size", local.DefaultUDPBufferSize,
+		"EDNS0 UDP buffer `size` advertised to the local resolvers (512-65535)")
+	flagSet.BoolVar(&cfg.hybridLocal, "hybrid-local", false,
+		"Prefer the lowest latency local resolver once enough latency data is gathered")
+	flagSet.BoolVar(&cfg.reorderLocal, "reorder-local", false,
+		"Periodically re-order local resolvers by recent success rate rather than resolv.conf order")
+	flagSet.IntVar(&cfg.parallelLocal, "parallel-local", 0,
+		"Send each query to `count` local resolvers simultaneously and use the first good response")
+	flagSet.DurationVar(&cfg.maxTTLOnError, "max-ttl-on-error", 0,
+		"Serve last known good or SERVFAIL with TTLs capped at `duration` when resolution fails (0 means off)")
+	flagSet.IntVar(&cfg.maxRequestSize, "max-request-size", 4096,
+		"Reject DNS queries larger than `bytes` with HTTP 413 (0 means no limit)")
+	flagSet.DurationVar(&cfg.reapIdle, "reap-idle", 0,
+		"Close client connections idle for longer than `duration` (0 means never)")
+	flagSet.StringVar(&cfg.warmFile, "warm-file", "",
+		"Resolve the 'qname [qtype]' lines in `path` at startup to warm backend caches")
+	flagSet.DurationVar(&cfg.warmInterval, "warm-interval", 0,
+		"Re-resolve --warm-file entries every `duration` (0 means only at startup)")
 	flagSet.DurationVar(&cfg.statusInterval, "i", time.Minute*15, "Periodic Status Report `interval` (needs -v set)")
+	flagSet.StringVar(&cfg.reportFormat, "report-format", "text", "Status Report `format`: text or json")
 	flagSet.DurationVar(&cfg.requestTimeout, "t", time.Second*15, "Remote request `timeout`")
 	flagSet.BoolVar(&cfg.verbose, "v", false, "Verbose status and stats - otherwise only errors are output")
 
 	flagSet.BoolVar(&cfg.ecsRemove, "ecs-remove", false, "Remove any and all inbound ECS options and requests")
 	flagSet.BoolVar(&cfg.ecsSet, "ecs-set", false, "Synthesize ECS from HTTPS Client IP")
+	flagSet.IntVar(&cfg.ecsMaxIPv4PrefixLen, "ecs-max-ipv4-prefixlen", 0,
+		"Cap client requested ECS IPv4 Synthesis `Prefix-Length` (0 = no cap)")
+	flagSet.IntVar(&cfg.ecsMaxIPv6PrefixLen, "ecs-max-ipv6-prefixlen", 0,
+		"Cap client requested ECS IPv6 Synthesis `Prefix-Length` (0 = no cap)")
 	flagSet.IntVar(&cfg.ecsSetIPv4PrefixLen, "ecs-set-ipv4-prefixlen", 24,
 		"ECS IPv4 Synthesis `Prefix-Length` - implies --ecs-set")
 	flagSet.IntVar(&cfg.ecsSetIPv6PrefixLen, "ecs-set-ipv6-prefixlen", 64,
 		"ECS IPv6 Synthesis `Prefix-Length` - implies --ecs-set")
+	flagSet.BoolVar(&cfg.ecsEcho, "ecs-echo", false,
+		"Add the synthesized ECS to responses which lack one, with a scope of zero")
+	flagSet.Var(&cfg.ecsExempt, "ecs-exempt",
+		"Leave ECS untouched for clients in this `IP/CIDR` (can be repeated)")
+	flagSet.Var(&cfg.trustedProxies, "trusted-proxy",
+		"Believe X-Forwarded-For from this `IP/CIDR` for ECS synthesis (can be repeated)")
+
+	flagSet.StringVar(&cfg.chaosVersion, "chaos-version", "",
+		"Answer CHAOS TXT version.bind queries with `string` rather than REFUSED")
+	flagSet.StringVar(&cfg.chaosHostname, "chaos-hostname", "",
+		"Answer CHAOS TXT hostname.bind and id.server queries with `string` rather than REFUSED")
+	flagSet.Var(&cfg.debugMeta, "debug-meta",
+		"Return resolution meta data to clients in this `IP/CIDR` which ask for it (can be repeated)")
+
+	flagSet.Var(&cfg.ednsPassthrough, "edns-passthrough",
+		"Forward EDNS0 `option` (name or code) to the local resolvers rather than removing it (can be repeated)")
+	flagSet.BoolVar(&cfg.serverCookies, "enable-server-cookies", false,
+		"Issue and validate rfc7873 DNS server cookies")
+
+	flagSet.StringVar(&cfg.anyPolicy, "any-policy", anyPolicyForward,
+		"Handling of qtype ANY queries: forward, hinfo (rfc8482) or refuse")
+
+	flagSet.BoolVar(&cfg.minimalResponses, "minimal-responses", false,
+		"Remove Authority and Additional RRs from responses to non-DNSSEC queries")
+
+	flagSet.BoolVar(&cfg.preserveZeroId, "preserve-zero-id", false,
+		"Debug: pass a query Id of zero through to the local resolver unchanged")
+	flagSet.BoolVar(&cfg.validateRoundtrip, "validate-roundtrip", false,
+		"Debug: unpack each packed response and log a warning if it differs from the original")
+
+	// Testing-only flags are omitted from the usage message so they are not mistaken for
+	// something useful in production.
+
+	flagSet.DurationVar(&cfg.injectDelay, "inject-delay", 0,
+		"Testing: delay responses by `duration` to exercise client timeouts and failover")
+	flagSet.Float64Var(&cfg.injectDelayFraction, "inject-delay-fraction", 1.0,
+		"Testing: randomly delay this `fraction` (0.0-1.0) of responses with --inject-delay")
 
 	flagSet.BoolVar(&cfg.logAll, "log-all", false, "Turns on all other --log-* options")
+	flagSet.BoolVar(&cfg.logDetail, "log-detail", false,
+		"Append flags, rcode, EDNS0 UDP size and answer count to compact DNS prints")
 	flagSet.BoolVar(&cfg.logClientIn, "log-client-in", false, "Compact print of inbound DNS query (from client)")
 	flagSet.BoolVar(&cfg.logClientOut, "log-client-out", false, "Compact print of outbound DNS response (to client)")
 	flagSet.BoolVar(&cfg.logHTTPIn, "log-http-in", false, "Compact print of inbound HTTP query")
@@ -153,6 +389,8 @@ func parseCommandLine(args []string) error {
 	flagSet.BoolVar(&cfg.logLocalOut, "log-local-out", false, "Compact print of DNS query (to local resolver)")
 
 	flagSet.BoolVar(&cfg.logTLSErrors, "log-tls-errors", false, "Print Client TLS verification failures")
+	flagSet.DurationVar(&cfg.slowQueryThreshold, "slow-query-threshold", 0,
+		"Print queries which take longer than `duration` to resolve (0 means off)")
 
 	// TLS
 
@@ -167,6 +405,10 @@ func parseCommandLine(args []string) error {
 	flagSet.BoolVar(&cfg.gops, "gops", false, "Start github.com/google/gops agent")
 	flagSet.StringVar(&cfg.cpuprofile, "cpu-profile", "", "write cpu profile to `file`")
 	flagSet.StringVar(&cfg.memprofile, "mem-profile", "", "write mem profile to `file`")
+	flagSet.StringVar(&cfg.profileDir, "profile-dir", "",
+		"SIGUSR2 writes goroutine and heap profiles to `directory`")
+	flagSet.StringVar(&cfg.debugListen, "debug-listen", "",
+		"Serve /debug/vars (expvar) and /debug/pprof on loopback `address`")
 
 	// Process Constraint parameters
 
@@ -174,7 +416,28 @@ func parseCommandLine(args []string) error {
 	flagSet.StringVar(&cfg.setgidName, "group", "", "setgid `groupname` to constrain process after start-up (disabled for Linux)")
 	flagSet.StringVar(&cfg.chrootDir, "chroot", "", "chroot `directory` to constrain process after start-up")
 
+	flagSet.BoolVar(&cfg.check, "check", false,
+		"Validate options and configuration files then exit without serving")
 	flagSet.BoolVar(&cfg.version, "version", false, "Print version and exit")
+	flagSet.BoolVar(&cfg.versionJSON, "version-json", false, "Print version and build details as JSON and exit")
+
+	flagSet.StringVar(&cfg.configFile, flagutil.ConfigFileFlag, "", "Read additional options from config file `path`")
+
+	err := flagSet.Parse(args[1:])
+	if err != nil {
+		return err
+	}
+	err = flagutil.LoadEnvironment(flagSet, consts.EnvironmentPrefix)
+	if err != nil {
+		fmt.Fprintln(flagSet.Output(), err)
+		return err
+	}
+	if len(cfg.configFile) > 0 {
+		err = flagutil.LoadConfigFile(flagSet, cfg.configFile)
+		if err != nil {
+			fmt.Fprintln(flagSet.Output(), err) // Callers assume errors are already printed
+		}
+	}
 
-	return flagSet.Parse(args[1:])
+	return err
 }
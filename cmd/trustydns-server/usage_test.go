@@ -1,7 +1,10 @@
 package main
 
 import (
+	"bytes"
+	"flag"
 	"fmt"
+	"os"
 	"strings"
 	"testing"
 	"time"
@@ -22,16 +25,93 @@ var testUsageCases = []testUsageCase{
 		"assign requested address"},
 	{false, []string{"Command", "line", "goop"}, []string{}, "Unexpected parameters"},
 
+	// --resolv-conf
+	{false, []string{"--check", "-c", "testdata/resolv.conf", "--resolv-conf", "testdata/internal.resolv.conf"},
+		[]string{"Configuration OK"}, ""},
+	{false, []string{"-c", "testdata/resolv.conf", "--resolv-conf", "testdata/nosuchfile"}, []string{},
+		"nosuchfile"},
+
+	// --zone-file
+	{false, []string{"--check", "-c", "testdata/resolv.conf", "--zone-file", "testdata/example.zone"},
+		[]string{"Configuration OK"}, ""},
+	{false, []string{"-c", "testdata/resolv.conf", "--zone-file", "testdata/resolv.conf"}, []string{},
+		"--zone-file"},
+
+	// --warm-file
+	{false, []string{"--check", "-c", "testdata/resolv.conf", "--warm-file", "testdata/warm.txt"},
+		[]string{"Configuration OK"}, ""},
+	{false, []string{"--warm-file", "testdata/warm-badtype.txt"}, []string{}, "Unknown qtype"},
+	{false, []string{"--warm-interval", "-1s"}, []string{}, "cannot be negative"},
+
+	// --chaos-version
+	{false, []string{"--check", "-c", "testdata/resolv.conf", "--chaos-version", "trustydns"}, []string{}, ""},
+
+	// --debug-meta
+	{false, []string{"--check", "-c", "testdata/resolv.conf", "--debug-meta", "10.0.0.0/8"}, []string{}, ""},
+	{false, []string{"--check", "-c", "testdata/resolv.conf", "--edns-passthrough", "COOKIE"}, []string{}, ""},
+	{false, []string{"--edns-passthrough", "NOTANOPTION"}, []string{}, "--edns-passthrough"},
+	{false, []string{"--debug-meta", "10.0.0.0/33"}, []string{}, "--debug-meta"},
+
+	// --inject-delay
+	{false, []string{"--check", "-c", "testdata/resolv.conf", "-v", "--inject-delay", "1s"},
+		[]string{"testing only"}, ""},
+	{false, []string{"--inject-delay", "-1s"}, []string{}, "cannot be negative"},
+	{false, []string{"--inject-delay-fraction", "1.5"}, []string{}, "between 0.0 and 1.0"},
+
+	// --reap-idle
+	{false, []string{"--check", "-c", "testdata/resolv.conf", "--reap-idle", "5m"}, []string{}, ""},
+	{false, []string{"--reap-idle", "-1s"}, []string{}, "cannot be negative"},
+	{false, []string{"--check", "-c", "testdata/resolv.conf", "--max-ttl-on-error", "30s"}, []string{}, ""},
+
 	// Bad ecs-set values
 	{false, []string{"--ecs-set-ipv4-prefixlen", "200"}, []string{}, "must be between 0 and 32"},
 	{false, []string{"--ecs-set-ipv6-prefixlen", "200"}, []string{}, "must be between 0 and 128"},
 	{false, []string{"--ecs-set-ipv4-prefixlen", "-1"}, []string{}, "must be between 0 and 32"},
 	{false, []string{"--ecs-set-ipv6-prefixlen", "-2"}, []string{}, "must be between 0 and 128"},
+	{false, []string{"--ecs-max-ipv4-prefixlen", "33"}, []string{}, "must be between 0 and 32"},
+	{false, []string{"--ecs-max-ipv6-prefixlen", "-1"}, []string{}, "must be between 0 and 128"},
+	{false, []string{"--trusted-proxy", "10.0.0.0/33"}, []string{}, "--trusted-proxy"},
+	{false, []string{"--ecs-exempt", "notanip"}, []string{}, "--ecs-exempt"},
 
 	// Bad local resolver config
+	{false, []string{"--udp-buffer-size", "511"}, []string{}, "must be between 512 and 65535"},
+	{false, []string{"--parallel-local", "-1"}, []string{}, "cannot be negative"},
+	{false, []string{"--hybrid-local", "--reorder-local"}, []string{}, "mutually exclusive"},
+	{false, []string{"--max-ttl-on-error", "-1s"}, []string{}, "cannot be negative"},
+
+	{false, []string{"--version-json"}, []string{`"program":"trustydns-server"`, `"version":"v`, `"go_version":"go`}, ""},
+	{false, []string{"--check", "--config", "testdata/config.conf"}, []string{"Configuration OK"}, ""},
+	{false, []string{"--check", "--enable-server-cookies"}, []string{"Configuration OK"}, ""},
+	{false, []string{"--config", "testdata/config.conf", "--max-ttl-on-error", "-1s"}, []string{},
+		"cannot be negative"}, // Command line wins
+	{false, []string{"--config", "testdata/config-bad.conf"}, []string{}, "config-bad.conf:1: 'no-such-option'"},
+	{false, []string{"--config", "testdata/no-such-file.conf"}, []string{}, "no such file"},
+	{false, []string{"--max-ttl-on-error", "10ms"}, []string{}, "at least one second"},
+	{false, []string{"--max-request-size", "-1"}, []string{}, "cannot be negative"},
+	{false, []string{"--slow-query-threshold", "-1s"}, []string{}, "cannot be negative"},
+	{false, []string{"--report-format", "xml"}, []string{}, "must be one of text or json"},
+	{false, []string{"--any-policy", "drop"}, []string{}, "must be one of forward, hinfo or refuse"},
+	{false, []string{"--debug-listen", "0.0.0.0:6060"}, []string{}, "is not a loopback address"},
 	{false, []string{"-c", ""}, []string{}, "Must supplied a resolv.conf"},
 	{false, []string{"-c", "testdata/emptyfile"}, []string{}, "No servers"},
 
+	// --check validates without binding so an unassignable address is not an error
+	{false, []string{"--check", "-A", "255.254.253.252"}, []string{"Configuration OK"}, ""},
+	{false, []string{"--check", "-c", "testdata/emptyfile"}, []string{}, "No servers"},
+
+	// --interface
+	{false, []string{"--interface", "nosuchinterface0"}, []string{}, "nosuchinterface0"},
+
+	// --reuse-port is supported on the platforms we test on
+	{false, []string{"--check", "--reuse-port"}, []string{"Configuration OK"}, ""},
+
+	// --unix-socket
+	{false, []string{"--check", "--unix-socket", "/tmp/trustydns.sock"}, []string{"Configuration OK"}, ""},
+
+	// --systemd
+	{false, []string{"--systemd", "-A", "127.0.0.1"}, []string{}, "Cannot have --systemd with -A"},
+	{false, []string{"--systemd"}, []string{}, "no sockets were passed"},
+
 	// tls
 	{false, []string{"--tls-cert", "testdata/nosuchfile"}, []string{}, "Certificate file count"},
 	{false, []string{"--tls-key", "testdata/nosuchfile"}, []string{}, "key file count"},
@@ -75,3 +155,48 @@ func TestUsage(t *testing.T) {
 		})
 	}
 }
+
+func TestHiddenFlags(t *testing.T) {
+	mainInit(os.Stdout, os.Stderr)
+	flagSet = flag.NewFlagSet("trustydns-server", flag.ContinueOnError)
+	if err := parseCommandLine([]string{"trustydns-server", "-v", "--inject-delay", "1s"}); err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	usage(out)
+	if strings.Contains(out.String(), "inject-delay") {
+		t.Error("Hidden flag shown in usage message")
+	}
+	if !strings.Contains(out.String(), "-reap-idle duration") {
+		t.Error("Visible flag missing from usage message", out.String())
+	}
+	if strings.Contains(out.String(), "Verbose status and stats - otherwise only errors are output (default true)") {
+		t.Error("Usage message should show the default rather than the parsed value")
+	}
+}
+
+// Options can be supplied by the environment but the command line wins
+func TestEnvironmentOptions(t *testing.T) {
+	t.Setenv(consts.EnvironmentPrefix+"MAX_TTL_ON_ERROR", "-1s")
+	t.Setenv(consts.EnvironmentPrefix+"C", "testdata/resolv.conf")
+
+	out := &mutexBytesBuffer{}
+	err := &mutexBytesBuffer{}
+	mainInit(out, err)
+	if ec := mainExecute([]string{"trustydns-server", "--check"}); ec == 0 {
+		t.Error("Expected environment value to be rejected", out.String())
+	}
+	if !strings.Contains(err.String(), "cannot be negative") {
+		t.Error("Expected --max-ttl-on-error error from the environment, not", err.String())
+	}
+
+	out = &mutexBytesBuffer{}
+	err = &mutexBytesBuffer{}
+	mainInit(out, err)
+	if ec := mainExecute([]string{"trustydns-server", "--check", "--max-ttl-on-error", "30s"}); ec != 0 {
+		t.Error("Command line should override the environment", err.String())
+	}
+	if cfg.maxTTLOnError != 30*time.Second || cfg.resolvConf != "testdata/resolv.conf" {
+		t.Error("Wrong precedence", cfg.maxTTLOnError, cfg.resolvConf)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/markdingo/trustydns/internal/resolver"
+
+	"github.com/miekg/dns"
+)
+
+// warmEntry is a single qName/qType loaded from the --warm-file
+type warmEntry struct {
+	qName string
+	qType uint16
+}
+
+// loadWarmFile reads the --warm-file. Each line contains a qName optionally followed by a qType
+// which defaults to A. Blank lines and lines starting with '#' are ignored.
+func loadWarmFile(path string) ([]warmEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []warmEntry
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("%s:%d: Expected qname [qtype]", path, lineNo)
+		}
+		we := warmEntry{qName: dns.Fqdn(fields[0]), qType: dns.TypeA}
+		if _, ok := dns.IsDomainName(we.qName); !ok {
+			return nil, fmt.Errorf("%s:%d: Invalid qname %s", path, lineNo, fields[0])
+		}
+		if len(fields) == 2 {
+			qt, ok := dns.StringToType[strings.ToUpper(fields[1])]
+			if !ok {
+				return nil, fmt.Errorf("%s:%d: Unknown qtype %s", path, lineNo, fields[1])
+			}
+			we.qType = qt
+		}
+		entries = append(entries, we)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// warmer resolves the --warm-file entries via the local resolver so that the backend recursive
+// resolvers have them cached before clients ask. Warming runs in its own go-routine so it never
+// delays accepting requests.
+type warmer struct {
+	entries  []warmEntry
+	local    resolver.Resolver
+	interval time.Duration // Re-warm this often if GT zero
+	done     chan struct{}
+
+	mu     sync.Mutex // Protects everything below here
+	warmed int        // Successful resolutions
+	failed int
+}
+
+func newWarmer(entries []warmEntry, local resolver.Resolver, interval time.Duration) *warmer {
+	return &warmer{entries: entries, local: local, interval: interval, done: make(chan struct{})}
+}
+
+// start warms all entries in a separate go-routine and, if an interval is set, periodically
+// re-warms them until stop() is called.
+func (t *warmer) start() {
+	go func() {
+		for {
+			t.warm()
+			if t.interval <= 0 {
+				return
+			}
+			select {
+			case <-t.done:
+				return
+			case <-time.After(t.interval):
+			}
+		}
+	}()
+}
+
+// stop ends any periodic re-warming. A pass which is in progress is abandoned at the next entry.
+func (t *warmer) stop() {
+	close(t.done)
+}
+
+// warm makes one pass over all entries.
+func (t *warmer) warm() {
+	for _, we := range t.entries {
+		select {
+		case <-t.done:
+			return
+		default:
+		}
+		q := &dns.Msg{}
+		q.SetQuestion(we.qName, we.qType)
+		_, _, err := t.local.Resolve(q, &resolver.QueryMetaData{})
+		t.mu.Lock()
+		if err == nil {
+			t.warmed++
+		} else {
+			t.failed++
+		}
+		t.mu.Unlock()
+	}
+}
+
+func (t *warmer) Name() string {
+	return "Warmer"
+}
+
+// warmerReport is a snapshot of the warmer stats shared by Report() and ReportJSON()
+type warmerReport struct {
+	Entries int `json:"entries"`
+	Warmed  int `json:"warmed"`
+	Failed  int `json:"failed"`
+}
+
+func (t *warmer) snapshot(resetCounters bool) *warmerReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	wr := &warmerReport{Entries: len(t.entries), Warmed: t.warmed, Failed: t.failed}
+	if resetCounters {
+		t.warmed = 0
+		t.failed = 0
+	}
+
+	return wr
+}
+
+func (t *warmer) Report(resetCounters bool) string {
+	wr := t.snapshot(resetCounters)
+
+	return fmt.Sprintf("entries=%d warmed=%d failed=%d", wr.Entries, wr.Warmed, wr.Failed)
+}
+
+// ReportJSON implements the reporter.MetricsReporter interface
+func (t *warmer) ReportJSON(resetCounters bool) ([]byte, error) {
+	return json.Marshal(t.snapshot(resetCounters))
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestLoadWarmFile(t *testing.T) {
+	entries, err := loadWarmFile("testdata/warm.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []warmEntry{{"example.com.", dns.TypeA}, {"example.net.", dns.TypeAAAA},
+		{"www.example.org.", dns.TypeMX}}
+	if len(entries) != len(expect) {
+		t.Fatal("Expected", len(expect), "entries, got", entries)
+	}
+	for ix, we := range expect {
+		if entries[ix] != we {
+			t.Error(ix, "Expected", we, "got", entries[ix])
+		}
+	}
+
+	_, err = loadWarmFile("testdata/warm-badtype.txt")
+	if err == nil || !strings.Contains(err.Error(), "Unknown qtype") {
+		t.Error("Expected Unknown qtype error, got", err)
+	}
+	_, err = loadWarmFile("testdata/nosuchfile")
+	if err == nil {
+		t.Error("Expected error from missing file")
+	}
+}
+
+func TestWarmer(t *testing.T) {
+	entries, err := loadWarmFile("testdata/warm.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver := &mockResolver{}
+	w := newWarmer(entries, resolver, 0)
+	w.warm()
+	if rep := w.Report(true); rep != "entries=3 warmed=3 failed=0" {
+		t.Error("Unexpected report after warming", rep)
+	}
+	if resolver.query.Question[0].Name != "www.example.org." {
+		t.Error("Last entry not resolved", resolver.query.String())
+	}
+
+	resolver.err = errors.New("Mock Resolver Error")
+	w.warm()
+	if rep := w.Report(false); rep != "entries=3 warmed=0 failed=3" {
+		t.Error("Unexpected report after failed warming", rep)
+	}
+
+	// Periodic re-warming continues until stopped
+
+	resolver.err = nil
+	w = newWarmer(entries, resolver, time.Millisecond*10)
+	w.start()
+	time.Sleep(time.Millisecond * 100)
+	w.stop()
+	if wr := w.snapshot(false); wr.Warmed <= len(entries) {
+		t.Error("Expected multiple warming passes, got", wr.Warmed)
+	}
+}
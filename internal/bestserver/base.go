@@ -10,6 +10,7 @@ type algorithm string
 const (
 	LatencyAlgorithm     algorithm = "latency"     // Pick the fastest most reliable server
 	TraditionalAlgorithm           = "traditional" // Pick until fails - just as res_send() does
+	HybridAlgorithm                = "hybrid"      // Traditional until there is latency data
 )
 
 // baseManager implements most of the Manager interface and provides helper routines that assist in
@@ -53,7 +54,7 @@ func (t *baseManager) init(algType algorithm, servers []Server) error {
 		return errors.New("bestserver:No servers in list")
 	}
 	t.algType = algType
-	t.servers = servers
+	t.servers = append([]Server{}, servers...) // Take a copy as AddServer() may modify our list
 	t.serverCount = len(t.servers)
 
 	t.serverToIndex = make(map[Server]int)
@@ -79,16 +80,86 @@ func (t *baseManager) Best() (Server, int) {
 }
 
 func (t *baseManager) Servers() []Server {
+	t.rlock()
+	defer t.runlock()
+
 	servers := make([]Server, len(t.servers))
 	copy(servers, t.servers)
 
 	return servers
 }
 
+// Order returns the 'best' followed by its successors in Servers() order, wrapping around at the end
+func (t *baseManager) Order() []int {
+	t.rlock()
+	defer t.runlock()
+
+	order := make([]int, 0, t.serverCount)
+	for ix := 0; ix < t.serverCount; ix++ {
+		order = append(order, (t.bestIndex+ix)%t.serverCount)
+	}
+
+	return order
+}
+
 func (t *baseManager) Len() int {
+	t.rlock()
+	defer t.runlock()
+
 	return len(t.servers)
 }
 
+// addServer appends the server to the list. Caller must hold the lock.
+func (t *baseManager) addServer(server Server) error {
+	if _, ok := t.serverToIndex[server]; ok {
+		return errors.New("bestserver.AddServer: Duplicate Server in list: " + server.Name())
+	}
+	t.serverToIndex[server] = len(t.servers)
+	t.servers = append(t.servers, server)
+	t.serverCount = len(t.servers)
+
+	return nil
+}
+
+// removeServer removes the server from the list and returns the index it previously occupied. If
+// the 'best' server was removed, bestIndex moves to the server which followed it, otherwise it is
+// adjusted to continue referring to the same server. Caller must hold the lock.
+func (t *baseManager) removeServer(server Server) (int, error) {
+	ix, ok := t.serverToIndex[server]
+	if !ok {
+		return 0, errors.New("bestserver.RemoveServer: Server not in list: " + server.Name())
+	}
+	if t.serverCount == 1 {
+		return 0, errors.New("bestserver.RemoveServer: Cannot remove the only Server: " + server.Name())
+	}
+
+	servers := make([]Server, 0, t.serverCount-1) // A new slice so prior Servers() copies are safe
+	servers = append(servers, t.servers[:ix]...)
+	t.servers = append(servers, t.servers[ix+1:]...)
+	t.serverCount = len(t.servers)
+	delete(t.serverToIndex, server)
+	for nix := ix; nix < t.serverCount; nix++ {
+		t.serverToIndex[t.servers[nix]] = nix
+	}
+
+	t.bestIndex = adjustIndex(t.bestIndex, ix, t.serverCount)
+
+	return ix, nil
+}
+
+// adjustIndex returns the index which continues to refer to the same server after the server at
+// removed has been removed. If index *is* the removed server then the following server is returned.
+func adjustIndex(index, removed, serverCount int) int {
+	if index > removed {
+		return index - 1
+	}
+	if index == removed {
+		return index % serverCount
+	}
+
+	return index
+}
+
 // defaultServer is the internal struct used to hold the server names provided to the NewFromNames()
 // constructor.
 type defaultServer struct {
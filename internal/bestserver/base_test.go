@@ -165,3 +165,76 @@ func sameServers(goodList, newList []Server) bool {
 
 	return found == len(goodList)
 }
+
+// Exercise AddServer and RemoveServer across all the algorithms via the Manager interface
+func TestAddRemoveServer(t *testing.T) {
+	constructors := map[string]func([]Server) (Manager, error){
+		"latency":     func(s []Server) (Manager, error) { return NewLatency(LatencyConfig{}, s) },
+		"traditional": func(s []Server) (Manager, error) { return NewTraditional(TraditionalConfig{}, s) },
+		"hybrid":      func(s []Server) (Manager, error) { return NewHybrid(HybridConfig{}, s) },
+	}
+	for name, newFunc := range constructors {
+		initial := []Server{one, two}
+		m, err := newFunc(initial)
+		if err != nil {
+			t.Fatal(name, "Did not expect error during setup", err)
+		}
+		if err := m.AddServer(two); err == nil {
+			t.Error(name, "Expected error adding a duplicate server")
+		}
+		if err := m.AddServer(three); err != nil {
+			t.Error(name, "Unexpected error adding a server", err)
+		}
+		if initial[1] != two || len(initial) != 2 {
+			t.Error(name, "AddServer modified the caller's server list", initial)
+		}
+		if !sameServers([]Server{one, two, three}, m.Servers()) {
+			t.Error(name, "Servers() did not include added server", m.Servers())
+		}
+		if m.Len() != 3 {
+			t.Error(name, "Len() should be 3, not", m.Len())
+		}
+
+		// Remove the 'best' and make sure a remaining server replaces it with a valid index
+
+		if err := m.RemoveServer(one); err != nil {
+			t.Error(name, "Unexpected error removing best server", err)
+		}
+		s, ix := m.Best()
+		if s == one || m.Servers()[ix] != s {
+			t.Error(name, "Best() returned removed or mis-indexed server", s.Name(), ix)
+		}
+		if !m.Result(s, true, time.Now(), time.Millisecond) {
+			t.Error(name, "Result() rejected the new best server")
+		}
+		if m.Result(one, true, time.Now(), time.Millisecond) {
+			t.Error(name, "Result() accepted a removed server")
+		}
+
+		if err := m.RemoveServer(one); err == nil {
+			t.Error(name, "Expected error removing a server not in the list")
+		}
+		if err := m.RemoveServer(three); err != nil {
+			t.Error(name, "Unexpected error removing server", err)
+		}
+		if err := m.RemoveServer(two); err == nil {
+			t.Error(name, "Expected error removing the only server")
+		}
+		if s, ix := m.Best(); s != two || ix != 0 {
+			t.Error(name, "Best() should be the sole remaining server, not", s.Name(), ix)
+		}
+	}
+}
+
+// Removing a non-best server must leave the same server as 'best' even though its index changes
+func TestRemoveServerKeepsBest(t *testing.T) {
+	bs, err := NewTraditional(TraditionalConfig{}, []Server{one, two, three})
+	if err != nil {
+		t.Fatal("Did not expect error during setup", err)
+	}
+	bs.Result(one, false, time.Now(), 0) // Traditional moves on to two
+	bs.RemoveServer(one)
+	if s, ix := bs.Best(); s != two || ix != 0 {
+		t.Error("Best should still be 'two' at index 0, not", s.Name(), ix)
+	}
+}
@@ -28,9 +28,9 @@ but no reassessment of the current best.
 
 Callers must not cache returns from Best() as that distorts the reassessment algorithm.
 
-There are currently two types of "best servers" to choose from: 'latency' and 'traditional' which
-are created with the obviously named NewLatency() and NewTraditional() functions respectively. They
-each implement different algorithms when choosing a new best server. This package is structured to
+There are currently three types of "best servers" to choose from: 'latency', 'traditional' and
+'hybrid' which are created with the obviously named NewLatency(), NewTraditional() and NewHybrid()
+functions respectively. They each implement different algorithms when choosing a new best server. This package is structured to
 make it easy to add additional algorithms if the need arises.
 
 The 'latency' algorithm generally tries to gravitate towards the lowest latency server by
@@ -45,11 +45,17 @@ algorithm is:
     o the configured number of Result() calls have been reached
 
 Reassessment chooses the server with the lowest weighted average latency to become the new 'best'
-server.
+server. Optionally the weighted average of servers which have not been used recently can be decayed
+towards the mean of all servers so that stale latency data gradually loses its influence. Servers
+which implement WeightedServer have their average divided by their weight for this comparison so
+that the caller can bias the choice towards preferred servers.
 
 To ensure there is latency data for all server, after a Result() call, Best() will periodically
 return a non-'best' server to gather performance information for that server. The default sample
-rate at which non-'best' servers are returned is approximately 5% of the time.
+rate at which non-'best' servers are returned is approximately 5% of the time. With a long list of
+servers that can leave individual servers unsampled for extended periods, so an adaptive mode can
+be configured which raises the sample rate enough to sample every server once per reassessment
+period.
 
 Servers which are unsuccessful as indicated by Result() calls are excluded from this sampling
 process for a configured time period.
@@ -63,6 +69,13 @@ selection by res_send(3) as described in RESOLVER(3). That is, the first server
 fails then the next server is used until it fails and so on. Once the end of the server list is
 reached, then the algorithm wraps around to the first server and the process repeats.
 
+The 'hybrid' implementation created with NewHybrid() composes the other two. It behaves exactly like
+'traditional' until any server has accumulated the configured number of successful Result() calls,
+at which point there is enough latency data to switch to the 'latency' algorithm. Both underlying
+algorithms are given every Result() so the 'latency' statistics are already populated at the
+switch-over. A run of consecutive failed Result() calls switches back to 'traditional', which then
+remains in charge until a server again accumulates the configured number of successes.
+
 Multiple goroutines can safely invoke all the Manager interface methods concurrently.
 */
 package bestserver
@@ -0,0 +1,177 @@
+package bestserver
+
+import (
+	"fmt"
+	"time"
+)
+
+// HybridConfig defines all the public parameters that the calling application can set. The
+// LatencyConfig is passed thru to the underlying latency algorithm.
+type HybridConfig struct {
+	LatencyConfig
+	SwitchAfter     int // Switch to latency once any server has this many successful Result() calls
+	SwitchBackAfter int // Revert to traditional after this many consecutive failed Result() calls
+}
+
+var (
+	DefaultHybridConfig = HybridConfig{
+		LatencyConfig:   DefaultLatencyConfig,
+		SwitchAfter:     10,
+		SwitchBackAfter: 3,
+	}
+)
+
+type hybrid struct {
+	HybridConfig
+	baseManager
+
+	traditional *traditional
+	latency     *latency
+	successes   []int // Successful Result() calls per server - only tracked prior to switching
+	failures    int   // Consecutive failed Result() calls - only tracked after switching
+	switched    bool  // Set while latency is in charge
+}
+
+func NewHybrid(config HybridConfig, servers []Server) (*hybrid, error) {
+	t := &hybrid{HybridConfig: config}
+	err := t.baseManager.init(HybridAlgorithm, servers)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.SwitchAfter < 0 {
+		return nil, fmt.Errorf("SwitchAfter is negative: %d", t.SwitchAfter)
+	}
+	if t.SwitchAfter == 0 {
+		t.SwitchAfter = DefaultHybridConfig.SwitchAfter
+	}
+	if t.SwitchBackAfter < 0 {
+		return nil, fmt.Errorf("SwitchBackAfter is negative: %d", t.SwitchBackAfter)
+	}
+	if t.SwitchBackAfter == 0 {
+		t.SwitchBackAfter = DefaultHybridConfig.SwitchBackAfter
+	}
+
+	t.traditional, err = NewTraditional(defaultTraditionalConfig, servers)
+	if err != nil {
+		return nil, err
+	}
+	t.latency, err = NewLatency(t.LatencyConfig, servers)
+	if err != nil {
+		return nil, err
+	}
+	t.successes = make([]int, t.serverCount)
+
+	return t, nil
+}
+
+// Best returns the best server from whichever algorithm is currently in charge
+func (t *hybrid) Best() (Server, int) {
+	t.rlock()
+	defer t.runlock()
+
+	if t.switched {
+		return t.latency.Best()
+	}
+
+	return t.traditional.Best()
+}
+
+// Order returns the Order of whichever algorithm is currently in charge
+func (t *hybrid) Order() []int {
+	t.rlock()
+	defer t.runlock()
+
+	if t.switched {
+		return t.latency.Order()
+	}
+
+	return t.traditional.Order()
+}
+
+// Stats returns the Stats of whichever algorithm is currently in charge. The traditional cycle and
+// failure counts are retained after switching as they remain useful history.
+func (t *hybrid) Stats() Stats {
+	t.rlock()
+	defer t.runlock()
+
+	st := t.traditional.Stats()
+	st.Algorithm = string(t.algType)
+	if t.switched {
+		_, st.BestIndex = t.latency.Best()
+	}
+
+	return st
+}
+
+// Result passes the results to both algorithms so that latency stats accumulate while traditional
+// is in charge. A run of SwitchBackAfter failures while latency is in charge suggests its data no
+// longer reflects reality so traditional takes over again. The success counts are reset at that
+// point so another SwitchAfter successes are needed before switching again, which stops the two
+// algorithms from flip-flopping.
+func (t *hybrid) Result(server Server, success bool, now time.Time, latency time.Duration) bool {
+	t.lock()
+	defer t.unlock()
+
+	ix, found := t.serverToIndex[server]
+	if !found {
+		return false
+	}
+
+	t.traditional.Result(server, success, now, latency)
+	t.latency.Result(server, success, now, latency)
+
+	switch {
+	case success:
+		t.failures = 0
+		if !t.switched {
+			t.successes[ix]++
+			if t.successes[ix] >= t.SwitchAfter {
+				t.switched = true
+			}
+		}
+	case t.switched:
+		t.failures++
+		if t.failures >= t.SwitchBackAfter {
+			t.switched = false
+			t.failures = 0
+			for ix := range t.successes {
+				t.successes[ix] = 0
+			}
+		}
+	}
+
+	return true
+}
+
+// AddServer adds the server to both algorithms
+func (t *hybrid) AddServer(server Server) error {
+	t.lock()
+	defer t.unlock()
+
+	err := t.addServer(server)
+	if err != nil {
+		return err
+	}
+	t.traditional.AddServer(server) // Cannot fail if our own list accepted it
+	t.latency.AddServer(server)
+	t.successes = append(t.successes, 0)
+
+	return nil
+}
+
+// RemoveServer removes the server from both algorithms
+func (t *hybrid) RemoveServer(server Server) error {
+	t.lock()
+	defer t.unlock()
+
+	ix, err := t.removeServer(server)
+	if err != nil {
+		return err
+	}
+	t.traditional.RemoveServer(server)
+	t.latency.RemoveServer(server)
+	t.successes = append(t.successes[:ix:ix], t.successes[ix+1:]...)
+
+	return nil
+}
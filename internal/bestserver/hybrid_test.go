@@ -0,0 +1,130 @@
+package bestserver
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHybridNew(t *testing.T) {
+	bs, err := NewHybrid(HybridConfig{}, []Server{first, second, third, fourth})
+	if err != nil {
+		t.Fatal("Unexpected error when setting up for test", err)
+	}
+	if bs.Algorithm() != HybridAlgorithm {
+		t.Error("Wrong algorithm name", bs.Algorithm())
+	}
+	if bs.SwitchAfter != DefaultHybridConfig.SwitchAfter {
+		t.Error("SwitchAfter did not default", bs.SwitchAfter)
+	}
+	if bs.SwitchBackAfter != DefaultHybridConfig.SwitchBackAfter {
+		t.Error("SwitchBackAfter did not default", bs.SwitchBackAfter)
+	}
+
+	_, err = NewHybrid(HybridConfig{}, []Server{})
+	if err == nil {
+		t.Error("Expected an error with no servers")
+	}
+	_, err = NewHybrid(HybridConfig{SwitchAfter: -1}, []Server{first})
+	if err == nil {
+		t.Error("Expected an error with a negative SwitchAfter")
+	}
+	_, err = NewHybrid(HybridConfig{SwitchBackAfter: -1}, []Server{first})
+	if err == nil {
+		t.Error("Expected an error with a negative SwitchBackAfter")
+	}
+	_, err = NewHybrid(HybridConfig{LatencyConfig: LatencyConfig{WeightForLatest: 101}}, []Server{first})
+	if err == nil {
+		t.Error("Expected an error with a bad LatencyConfig")
+	}
+}
+
+// Hybrid should follow traditional rules until SwitchAfter successes then follow latency rules.
+func TestHybridSwitch(t *testing.T) {
+	var m Manager
+	bs, err := NewHybrid(HybridConfig{SwitchAfter: 3,
+		LatencyConfig: LatencyConfig{SampleOthersEvery: 1000, ReassessCount: 1}},
+		[]Server{first, second, third})
+	if err != nil {
+		t.Fatal("Unexpected error when setting up for test", err)
+	}
+	m = bs // Make sure it meets the interface
+	now := time.Now()
+
+	if s, _ := m.Best(); s != first {
+		t.Fatal("Hybrid should start with first server, not", s.Name())
+	}
+	m.Result(third, true, now, time.Millisecond) // Fastest server but not yet in charge
+	m.Result(third, true, now, time.Millisecond)
+	m.Result(first, false, now, time.Second) // Traditional moves to the next server
+	if s, ix := m.Best(); s != second || ix != 1 {
+		t.Error("Hybrid should behave traditionally and move to second, not", s.Name(), ix)
+	}
+	if bs.switched {
+		t.Error("Hybrid should not have switched with only two successes")
+	}
+
+	m.Result(third, true, now, time.Millisecond) // Third success switches to latency
+	if !bs.switched {
+		t.Fatal("Hybrid should have switched after three successes")
+	}
+	m.Result(second, true, now, time.Second) // Trigger latency reassessment of best
+	if s, _ := m.Best(); s != third {
+		t.Error("Hybrid should now prefer the lowest latency server, not", s.Name())
+	}
+
+	if m.Result(&defaultServer{name: "unknown"}, true, now, time.Second) {
+		t.Error("Result should return false for an unknown server")
+	}
+}
+
+// Hybrid should revert to traditional after SwitchBackAfter consecutive failures and only return to
+// latency after another SwitchAfter successes.
+func TestHybridSwitchBack(t *testing.T) {
+	bs, err := NewHybrid(HybridConfig{SwitchAfter: 2, SwitchBackAfter: 2,
+		LatencyConfig: LatencyConfig{SampleOthersEvery: 1000, ReassessCount: 1}},
+		[]Server{first, second, third})
+	if err != nil {
+		t.Fatal("Unexpected error when setting up for test", err)
+	}
+	now := time.Now()
+
+	bs.Result(first, true, now, time.Second) // Give all servers some latency data
+	bs.Result(second, true, now, time.Second)
+	bs.Result(third, true, now, time.Millisecond)
+	bs.Result(third, true, now, time.Millisecond) // Switch to latency
+	if !bs.switched {
+		t.Fatal("Hybrid should have switched after two successes")
+	}
+	bs.Result(first, true, now, time.Second) // Trigger latency reassessment of best
+	if s, _ := bs.Best(); s != third {
+		t.Fatal("Hybrid should prefer the lowest latency server, not", s.Name())
+	}
+
+	bs.Result(third, false, now, time.Second)
+	bs.Result(third, true, now, time.Millisecond) // Success breaks the run of failures
+	bs.Result(third, false, now, time.Second)
+	if !bs.switched {
+		t.Fatal("Hybrid should not switch back without consecutive failures")
+	}
+	bs.Result(second, false, now, time.Second) // Second consecutive failure switches back
+	if bs.switched {
+		t.Fatal("Hybrid should have switched back after two consecutive failures")
+	}
+	if st := bs.Stats(); st.Algorithm != "hybrid" || st.BestIndex != 0 {
+		t.Error("Hybrid should report the traditional best server", st)
+	}
+	if s, ix := bs.Best(); s != first || ix != 0 {
+		t.Error("Hybrid should behave traditionally again and use first, not", s.Name(), ix)
+	}
+
+	// Successes prior to switching back no longer count so it takes another two to switch again
+
+	bs.Result(first, true, now, time.Second)
+	if bs.switched {
+		t.Error("Hybrid should not switch again after only one new success")
+	}
+	bs.Result(first, true, now, time.Second)
+	if !bs.switched {
+		t.Error("Hybrid should have switched again after two new successes")
+	}
+}
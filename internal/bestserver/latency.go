@@ -2,6 +2,7 @@ package bestserver
 
 import (
 	"fmt"
+	"math"
 	"time"
 )
 
@@ -14,6 +15,16 @@ type LatencyConfig struct {
 	ResetFailedAfter  time.Duration // Reset server stats to zero if failed this long ago
 	SampleOthersEvery int           // Result() samples another server once every SampleOthersEvery calls
 	WeightForLatest   int           // Percent weight for latest Result() latency (range: 0-100)
+
+	// AdaptiveSampling shortens the SampleOthersEvery interval, if need be, so that every server
+	// is sampled at least once per ReassessAfter window based on the Result() rate of the
+	// previous window.
+	AdaptiveSampling bool
+
+	// DecayHalfLife, if GT zero, causes the weighted average latency of a server to decay
+	// towards the mean of all servers by half for every DecayHalfLife since its last Result()
+	// call. This stops a server which was fast long ago from retaining its advantage.
+	DecayHalfLife time.Duration
 }
 
 var (
@@ -54,6 +65,10 @@ type latency struct {
 	saveBestIndex     int               // The source of truth for the bestIndex
 	bestExpires       time.Time         // When to reassess 'best'
 	reassessRationale reassessAlgorithm // Record why 'best' server was chosen
+
+	windowEnds  time.Time // AdaptiveSampling: end of the current ReassessAfter window
+	windowCalls int       // AdaptiveSampling: calls to assess() in the current window
+	sampleEvery int       // Effective sample interval - SampleOthersEvery unless adapted
 }
 
 func NewLatency(config LatencyConfig, servers []Server) (*latency, error) {
@@ -82,6 +97,9 @@ func NewLatency(config LatencyConfig, servers []Server) (*latency, error) {
 	if t.SampleOthersEvery < 0 {
 		return nil, fmt.Errorf("SampleOthersEvery is negative: %d", t.SampleOthersEvery)
 	}
+	if t.DecayHalfLife < 0 {
+		return nil, fmt.Errorf("DecayHalfLife is negative: %d", t.DecayHalfLife)
+	}
 
 	// Set config defaults
 
@@ -102,6 +120,7 @@ func NewLatency(config LatencyConfig, servers []Server) (*latency, error) {
 	}
 
 	t.stats = make([]latencyServerStats, t.serverCount)
+	t.sampleEvery = t.SampleOthersEvery
 
 	return t, nil
 }
@@ -165,8 +184,12 @@ func (t *latency) assess(now time.Time, ix int, success bool) {
 	// be that over time the right number of samples do occur, it just may not seem that way on
 	// a busy system in the microscopic view.
 
+	if t.AdaptiveSampling {
+		t.adaptSampling(now)
+	}
+
 	t.sampleCount++
-	if t.sampleCount < t.SampleOthersEvery {
+	if t.sampleCount < t.sampleEvery {
 		t.bestIndex = t.saveBestIndex // Not sampling so ensure reversion to real 'best'
 		return                        // and we're done
 	}
@@ -179,14 +202,86 @@ func (t *latency) assess(now time.Time, ix int, success bool) {
 
 }
 
-// reassessBest searches for the server with the lowest weighted average latency.  Also rehabilitate
-// servers that have been sidelined for sufficient time.
+// decayedAverages returns the weighted average latency of each server for comparison by
+// reassessBest(). If DecayHalfLife is set, each known average is moved towards the mean of all
+// known averages in proportion to how long ago the server last had a Result() call. The stored
+// averages are never modified so repeated reassessments do not compound the decay. Unknown
+// averages remain as zero.
+func (t *latency) decayedAverages(now time.Time) []time.Duration {
+	averages := make([]time.Duration, t.serverCount)
+	var sum time.Duration
+	known := 0
+	for ix, stats := range t.stats {
+		averages[ix] = stats.weightedAverage
+		if stats.weightedAverage > 0 {
+			sum += stats.weightedAverage
+			known++
+		}
+	}
+	if t.DecayHalfLife == 0 || known < 2 { // Decaying towards yourself is a no-op
+		return averages
+	}
+
+	mean := float64(sum) / float64(known)
+	for ix, stats := range t.stats {
+		idle := now.Sub(stats.lastStatusTime)
+		if averages[ix] == 0 || idle <= 0 {
+			continue
+		}
+		remaining := math.Pow(0.5, float64(idle)/float64(t.DecayHalfLife))
+		averages[ix] = time.Duration(mean + (float64(averages[ix])-mean)*remaining)
+		if averages[ix] <= 0 { // Never let a known average look unknown
+			averages[ix] = 1
+		}
+	}
+
+	return averages
+}
+
+// adaptSampling counts assess() calls over each ReassessAfter window and at the end of the window
+// uses that count to set the sample interval for the next window. The interval is chosen so that
+// all non-'best' servers get a sample within the window, assuming the call rate stays much the
+// same. As the sample sequence cycles thru all servers, including 'best', the calls are divided by
+// serverCount rather than serverCount-1. It never increases the interval beyond SampleOthersEvery.
+func (t *latency) adaptSampling(now time.Time) {
+	t.windowCalls++
+	if now.Before(t.windowEnds) {
+		return
+	}
+
+	t.sampleEvery = t.SampleOthersEvery
+	if t.serverCount > 1 && !t.windowEnds.IsZero() { // No data in the first window
+		every := t.windowCalls / t.serverCount
+		if every < 1 {
+			every = 1
+		}
+		if every < t.sampleEvery {
+			t.sampleEvery = every
+		}
+	}
+	t.windowEnds = now.Add(t.ReassessAfter)
+	t.windowCalls = 0
+}
+
+// serverWeight returns the WeightedServer weight of the server, or 1 if it has none.
+func serverWeight(server Server) int {
+	if ws, ok := server.(WeightedServer); ok && ws.Weight() > 1 {
+		return ws.Weight()
+	}
+
+	return 1
+}
+
+// reassessBest searches for the server with the lowest weighted average latency after each average
+// is divided by the server's weight.  Also rehabilitate servers that have been sidelined for
+// sufficient time.
 func (t *latency) reassessBest(now time.Time) {
 	t.reassessRationale = algNone
 	if t.serverCount == 1 { // Premature optimization or common case?
 		t.reassessRationale = algOnlyOne
 		return
 	}
+	averages := t.decayedAverages(now)
 	newBest := -1                           // This is set to the new 'best', if one is found
 	for ix := 0; ix < t.serverCount; ix++ { // Iterate over all servers
 		stats := &t.stats[ix]
@@ -201,14 +296,15 @@ func (t *latency) reassessBest(now time.Time) {
 			newBest = ix // Tentative 'best'
 			stats = &t.stats[newBest]
 
-		case stats.weightedAverage == 0: // Ignore servers with unknown latency
+		case averages[ix] == 0: // Ignore servers with unknown latency
 
-		case t.stats[newBest].weightedAverage == 0: // Replace first cab with a known server
+		case averages[newBest] == 0: // Replace first cab with a known server
 			t.reassessRationale = algSecondCab
 			newBest = ix
 			stats = &t.stats[newBest]
 
-		case stats.weightedAverage < t.stats[newBest].weightedAverage: // Prefer fastest
+		case averages[ix]*time.Duration(serverWeight(t.servers[newBest])) <
+			averages[newBest]*time.Duration(serverWeight(t.servers[ix])): // Prefer fastest
 			t.reassessRationale = algFastest
 			newBest = ix // Tentative 'best'
 			stats = &t.stats[newBest]
@@ -223,3 +319,44 @@ func (t *latency) reassessBest(now time.Time) {
 	t.bestIndex = newBest
 	t.bestExpires = now.Add(t.ReassessAfter)
 }
+
+// AddServer appends the server with empty statistics. It will be sampled in due course.
+func (t *latency) AddServer(server Server) error {
+	t.lock()
+	defer t.unlock()
+
+	err := t.addServer(server)
+	if err != nil {
+		return err
+	}
+	t.stats = append(t.stats, latencyServerStats{})
+
+	return nil
+}
+
+// RemoveServer removes the server and its statistics. If the real 'best' server is removed then a
+// reassessment immediately chooses a new 'best'. If a sample server is removed then Best() reverts
+// to the real 'best'.
+func (t *latency) RemoveServer(server Server) error {
+	t.lock()
+	defer t.unlock()
+
+	ix, err := t.removeServer(server)
+	if err != nil {
+		return err
+	}
+	t.stats = append(t.stats[:ix:ix], t.stats[ix+1:]...)
+	t.sampleIndex = adjustIndex(t.sampleIndex, ix, t.serverCount)
+
+	if t.saveBestIndex == ix {
+		t.reassessBest(time.Now())
+		t.saveBestIndex = t.bestIndex
+		t.assessCount = 0
+		return nil
+	}
+
+	t.saveBestIndex = adjustIndex(t.saveBestIndex, ix, t.serverCount)
+	t.bestIndex = t.saveBestIndex // Abandon any sampling in progress
+
+	return nil
+}
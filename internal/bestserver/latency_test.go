@@ -76,6 +76,7 @@ var (
 		{LatencyConfig{WeightForLatest: -1}, []string{"a"}, "WeightForLatest"},
 		{LatencyConfig{ResetFailedAfter: -1}, []string{"a"}, "ResetFailedAfter"},
 		{LatencyConfig{SampleOthersEvery: -1}, []string{"a"}, "SampleOthersEvery"},
+		{LatencyConfig{DecayHalfLife: -1}, []string{"a"}, "DecayHalfLife"},
 	}
 )
 
@@ -183,6 +184,50 @@ func TestLatencySampling(t *testing.T) {
 	}
 }
 
+// Test that adaptive sampling reduces the sample interval so that every server is sampled within a
+// ReassessAfter window even when SampleOthersEvery is too large to achieve that.
+func TestLatencyAdaptiveSampling(t *testing.T) {
+	bs, err := NewLatency(LatencyConfig{AdaptiveSampling: true, SampleOthersEvery: 1000,
+		ReassessAfter: 10 * time.Second}, []Server{first, second, third, fourth})
+	if err != nil {
+		t.Fatal("Unexpected error when setting up for test", err)
+	}
+
+	now := time.Now()
+	for ix := 0; ix < 12; ix++ { // First window gathers the call rate at one call per second
+		s, _ := bs.Best()
+		bs.Result(s, true, now, time.Millisecond)
+		now = now.Add(time.Second)
+	}
+	if bs.sampleEvery != 2 { // 10 calls in the window spread across the 4 servers
+		t.Fatal("Adaptive sampling should have set sampleEvery to 2, not", bs.sampleEvery)
+	}
+
+	sMap := make(map[Server]int)
+	for ix := 0; ix < 10; ix++ {
+		s, _ := bs.Best()
+		sMap[s]++
+		bs.Result(s, true, now, time.Millisecond)
+		now = now.Add(time.Second)
+	}
+	if len(sMap) != 4 {
+		t.Error("Every server should have been offered within the window, not just", sMap)
+	}
+
+	// Without adaptive sampling the configured interval should stand
+
+	bs, _ = NewLatency(LatencyConfig{SampleOthersEvery: 1000, ReassessAfter: 10 * time.Second},
+		[]Server{first, second, third, fourth})
+	for ix := 0; ix < 12; ix++ {
+		s, _ := bs.Best()
+		bs.Result(s, true, now, time.Millisecond)
+		now = now.Add(time.Second)
+	}
+	if bs.sampleEvery != 1000 {
+		t.Error("sampleEvery should not change without AdaptiveSampling, not", bs.sampleEvery)
+	}
+}
+
 // Test that reassessment occurs after ReassessCount
 func TestLatencyReassessCount(t *testing.T) {
 	bs, err := newTestLatency(LatencyConfig{ReassessCount: 5}, []Server{first, second, third})
@@ -297,6 +342,70 @@ func TestLatencyFastest(t *testing.T) {
 	}
 }
 
+type weightedServer struct {
+	defaultServer
+	weight int
+}
+
+func (t *weightedServer) Weight() int {
+	return t.weight
+}
+
+// Test that a heavier server is preferred unless it is proportionally slower
+func TestLatencyWeight(t *testing.T) {
+	heavy := &weightedServer{defaultServer{name: "heavy"}, 3}
+	testCases := []struct {
+		heavyLatency time.Duration
+		expect       Server
+	}{
+		{time.Millisecond * 50, heavy}, // Slower but within its weight
+		{time.Millisecond * 80, third}, // Too slow even with its weight
+	}
+	for ix, tc := range testCases {
+		bs, err := NewLatency(LatencyConfig{}, []Server{first, second, third, heavy})
+		if err != nil {
+			t.Fatal("Unexpected error when setting up for test", err)
+		}
+		now := time.Unix(1, 0)
+		bs.Result(first, true, now, time.Millisecond*20)
+		bs.Result(second, true, now, time.Millisecond*90)
+		bs.Result(third, true, now, time.Millisecond*25)
+		bs.Result(heavy, true, now, tc.heavyLatency)
+		bs.Result(first, false, now, time.Millisecond*20) // Force reassess
+		s, _ := bs.Best()
+		if s != tc.expect {
+			t.Error(ix, "Expected best to be", tc.expect.Name(), "but got", s.Name())
+		}
+	}
+}
+
+// Test that a server which was fast long ago loses its advantage once its latency decays
+func TestLatencyDecay(t *testing.T) {
+	for _, halfLife := range []time.Duration{0, time.Minute} {
+		bs, err := NewLatency(LatencyConfig{DecayHalfLife: halfLife}, []Server{first, second, third})
+		if err != nil {
+			t.Fatal("Unexpected error when setting up for test", err)
+		}
+		then := time.Unix(1, 0)
+		now := then.Add(10 * time.Minute)
+		bs.Result(first, true, then, time.Millisecond*20) // Fast but stale
+		bs.Result(second, true, now, time.Millisecond*30)
+		bs.Result(third, true, now, time.Millisecond*130) // Mean of all three is 60ms
+		bs.reassessBest(now)
+		s, _ := bs.Best()
+		expect := first // Without decay stale data wins
+		if halfLife > 0 {
+			expect = second // first has decayed to almost 60ms
+		}
+		if s != expect {
+			t.Error("HalfLife", halfLife, "expected", expect.Name(), "got", s.Name())
+		}
+		if bs.stats[0].weightedAverage != time.Millisecond*20 {
+			t.Error("Decay should not modify the stored average", bs.stats[0].weightedAverage)
+		}
+	}
+}
+
 // Test that the weighted average is in fact a weighted average
 func TestLatencyweightedAverage(t *testing.T) {
 	bs, err := newTestLatency(LatencyConfig{}, []Server{first, second, third, fourth})
@@ -14,6 +14,15 @@ type Server interface {
 	Name() string
 }
 
+// WeightedServer is optionally implemented by a Server to bias the 'latency' algorithm in its
+// favor. When comparing servers their average latencies are divided by their Weight() so a server
+// with a weight of 2 is preferred over a server with the default weight of 1 unless it is more than
+// twice as slow. A Weight() less than 1 is treated as 1.
+type WeightedServer interface {
+	Server
+	Weight() int
+}
+
 // Manager is the public interface for bestserver.
 type Manager interface {
 	// Algorithm returns the name of the implementation
@@ -37,9 +46,32 @@ type Manager interface {
 	// Return false if Server is not part of this collection
 	Result(server Server, success bool, now time.Time, latency time.Duration) bool
 
-	// Servers returns a slice of all Servers in the order originally created.
+	// Servers returns a slice of all Servers in the order originally created
+	// followed by any added with AddServer().
 	Servers() []Server
 
+	// Order returns the indexes into Servers() in the order the algorithm
+	// would try them if each in turn were to fail, starting with the
+	// current 'best'. It is intended for callers which try multiple
+	// servers at once.
+	Order() []int
+
+	// AddServer appends a Server to the collection. It starts with no
+	// statistics and is only chosen as 'best' by the normal workings of the
+	// algorithm. Return an error if the Server is already present.
+	AddServer(server Server) error
+
+	// RemoveServer removes a Server from the collection. Indexes returned
+	// by Best() prior to this call may no longer refer to the same Server.
+	// If the current 'best' is removed a new 'best' is chosen
+	// immediately. Return an error if the Server is not present or is the
+	// only remaining Server.
+	RemoveServer(server Server) error
+
 	// Len returns the count of servers
 	Len() int
+
+	// Stats returns a snapshot of the algorithm's internal state for
+	// diagnostic purposes.
+	Stats() Stats
 }
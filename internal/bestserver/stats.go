@@ -0,0 +1,20 @@
+package bestserver
+
+// Stats is a snapshot of the internal state of a Manager which is mainly of interest for
+// diagnostics. Fields which do not apply to an algorithm are left as zero values.
+type Stats struct {
+	Algorithm string `json:"algorithm"`
+	BestIndex int    `json:"bestIx"` // Index of the current 'best' in Servers() order
+
+	// Traditional only
+	Cycles              int   `json:"cycles"`      // Times failures have advanced through the whole list
+	ConsecutiveFailures []int `json:"consecFails"` // Per-server in Servers() order
+}
+
+// Stats returns the state common to all algorithms.
+func (t *baseManager) Stats() Stats {
+	t.rlock()
+	defer t.runlock()
+
+	return Stats{Algorithm: string(t.algType), BestIndex: t.bestIndex}
+}
@@ -1,31 +1,62 @@
 package bestserver
 
 import (
+	"fmt"
+	"sort"
 	"time"
 )
 
-// TraditionalConfig defines all the public parameters that the calling application can
-// set. Currently this is just a place-holder for the API. But easier to add a field to an empty
-// struct than to add an additional parameter to an API in widespread use.
+// TraditionalConfig defines all the public parameters that the calling application can set.
 type TraditionalConfig struct {
+	// ReorderBySuccess periodically re-sorts the traversal order so that servers with the highest
+	// recent success rate are tried first. Latency plays no part so as to stay close to res_send
+	// semantics. Servers with equal success rates retain their original relative order.
+	ReorderBySuccess bool
+
+	// ReorderInterval is how often the traversal order is re-sorted. Zero means use the default.
+	ReorderInterval time.Duration
 }
 
 var (
-	defaultTraditionalConfig = TraditionalConfig{}
+	defaultTraditionalConfig = TraditionalConfig{ReorderInterval: time.Minute}
 )
 
 type traditional struct {
 	TraditionalConfig
 	baseManager
+
+	order       []int // Traversal order as indexes into servers
+	position    int   // Position of bestIndex within order
+	successes   []int // Per-server counts halved at each reorder - only tracked if ReorderBySuccess
+	attempts    []int
+	lastReorder time.Time
+
+	cycles      int   // Times failures have wrapped 'best' back to the start of the order
+	consecFails []int // Per-server failures since the last success
 }
 
 func NewTraditional(config TraditionalConfig, servers []Server) (*traditional, error) {
-	t := &traditional{}
+	t := &traditional{TraditionalConfig: config}
 	err := t.baseManager.init(TraditionalAlgorithm, servers)
 	if err != nil {
 		return nil, err
 	}
 
+	if t.ReorderInterval < 0 {
+		return nil, fmt.Errorf("ReorderInterval is negative: %s", t.ReorderInterval)
+	}
+	if t.ReorderInterval == 0 {
+		t.ReorderInterval = defaultTraditionalConfig.ReorderInterval
+	}
+
+	t.order = make([]int, t.serverCount)
+	for ix := range t.order {
+		t.order[ix] = ix
+	}
+	t.successes = make([]int, t.serverCount)
+	t.attempts = make([]int, t.serverCount)
+	t.consecFails = make([]int, t.serverCount)
+
 	return t, err
 }
 
@@ -38,13 +69,130 @@ func (t *traditional) Result(server Server, success bool, now time.Time, latency
 		return false
 	}
 
+	if t.ReorderBySuccess {
+		t.attempts[ix]++
+		if success {
+			t.successes[ix]++
+		}
+		defer t.reorder(now) // After any advance so a reorder has the final say
+	}
+
 	if success {
+		t.consecFails[ix] = 0
 		return true
 	}
+	t.consecFails[ix]++
 
 	if ix == t.bestIndex { // If 'best' failed, move to next server.
-		t.bestIndex = (t.bestIndex + 1) % t.serverCount
+		t.position = (t.position + 1) % t.serverCount
+		t.bestIndex = t.order[t.position]
+		if t.position == 0 {
+			t.cycles++
+		}
 	}
 
 	return true
 }
+
+// reorder re-sorts the traversal order by success rate if ReorderInterval has passed since the last
+// reorder. Servers which have not been tried since the last reorder are given the benefit of the
+// doubt, otherwise a flaky first server would never give way to an untried second server. Counts
+// are halved after each reorder so that the rate reflects recent history. The most successful
+// server becomes 'best'. Caller must hold the lock.
+func (t *traditional) reorder(now time.Time) {
+	if t.lastReorder.IsZero() {
+		t.lastReorder = now
+		return
+	}
+	if now.Sub(t.lastReorder) < t.ReorderInterval {
+		return
+	}
+	t.lastReorder = now
+
+	rate := func(ix int) float64 {
+		if t.attempts[ix] == 0 {
+			return 1
+		}
+		return float64(t.successes[ix]) / float64(t.attempts[ix])
+	}
+	for ix := range t.order {
+		t.order[ix] = ix
+	}
+	sort.SliceStable(t.order, func(i, j int) bool { return rate(t.order[i]) > rate(t.order[j]) })
+
+	for ix := range t.attempts {
+		t.attempts[ix] /= 2
+		t.successes[ix] /= 2
+	}
+	t.position = 0
+	t.bestIndex = t.order[0]
+}
+
+// AddServer appends the server to the end of the traversal order.
+func (t *traditional) AddServer(server Server) error {
+	t.lock()
+	defer t.unlock()
+
+	err := t.addServer(server)
+	if err == nil {
+		t.order = append(t.order, t.serverCount-1)
+		t.successes = append(t.successes, 0)
+		t.attempts = append(t.attempts, 0)
+		t.consecFails = append(t.consecFails, 0)
+	}
+
+	return err
+}
+
+// RemoveServer removes the server. If it was the 'best' server then the next server in the
+// traversal order becomes the 'best' just as if the 'best' had failed.
+func (t *traditional) RemoveServer(server Server) error {
+	t.lock()
+	defer t.unlock()
+
+	ix, err := t.removeServer(server)
+	if err != nil {
+		return err
+	}
+
+	order := make([]int, 0, t.serverCount)
+	for pos, oix := range t.order {
+		switch {
+		case oix == ix:
+			if pos < t.position {
+				t.position--
+			} else if pos == t.position && pos == len(t.order)-1 {
+				t.position = 0 // Wrap just as a failure would
+			}
+		case oix > ix:
+			order = append(order, oix-1)
+		default:
+			order = append(order, oix)
+		}
+	}
+	t.order = order
+	t.bestIndex = t.order[t.position]
+	t.successes = append(t.successes[:ix], t.successes[ix+1:]...)
+	t.attempts = append(t.attempts[:ix], t.attempts[ix+1:]...)
+	t.consecFails = append(t.consecFails[:ix], t.consecFails[ix+1:]...)
+
+	return nil
+}
+
+// Order returns the traversal order, which may have been re-sorted by ReorderBySuccess, starting at
+// the 'best' server.
+func (t *traditional) Order() []int {
+	t.rlock()
+	defer t.runlock()
+
+	return append(append([]int{}, t.order[t.position:]...), t.order[:t.position]...)
+}
+
+// Stats adds the traditional traversal state to the common Stats.
+func (t *traditional) Stats() Stats {
+	t.rlock()
+	defer t.runlock()
+
+	return Stats{Algorithm: string(t.algType), BestIndex: t.bestIndex,
+		Cycles: t.cycles, ConsecutiveFailures: append([]int{}, t.consecFails...)}
+}
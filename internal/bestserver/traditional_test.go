@@ -84,3 +84,109 @@ func TestTraditionalResult(t *testing.T) {
 		t.Error("Result returned true with a bogus server name")
 	}
 }
+
+func TestTraditionalReorder(t *testing.T) {
+	_, err := NewTraditional(TraditionalConfig{ReorderInterval: -time.Second}, []Server{first})
+	if err == nil {
+		t.Error("Expected an error with a negative ReorderInterval")
+	}
+
+	// Without ReorderBySuccess the order never changes regardless of results
+	now := time.Now()
+	bs, _ := NewTraditional(TraditionalConfig{}, []Server{first, second, third})
+	bs.Result(first, false, now, 0)
+	bs.Result(second, true, now.Add(time.Hour), 0)
+	if s, _ := bs.Best(); s != second {
+		t.Error("Expected second after first failed, not", s.Name())
+	}
+
+	bs, err = NewTraditional(TraditionalConfig{ReorderBySuccess: true, ReorderInterval: time.Minute},
+		[]Server{first, second, third})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// first is flaky, second and third are untried. At the first reorder third is ahead of first
+	// as untried servers get the benefit of the doubt.
+	for ix := 0; ix < 10; ix++ {
+		bs.Result(first, ix%3 != 0, now, 0)
+	}
+	bs.Result(second, true, now, 0)
+	if s, ix := bs.Best(); s != second || ix != 1 {
+		t.Error("Expected second after first failed, not", s.Name(), ix)
+	}
+	bs.Result(second, true, now.Add(time.Minute), 0) // Triggers reorder
+	if s, _ := bs.Best(); s != second {
+		t.Error("Expected second to be best after reorder, not", s.Name())
+	}
+	if got := bs.order; got[0] != 1 || got[1] != 2 || got[2] != 0 {
+		t.Error("Expected order of second, third, first, not", got)
+	}
+	if got := bs.Order(); got[0] != 1 || got[1] != 2 || got[2] != 0 {
+		t.Error("Expected Order of second, third, first, not", got)
+	}
+
+	// Failures now follow the new order
+	bs.Result(second, false, now.Add(time.Minute), 0)
+	if s, _ := bs.Best(); s != third {
+		t.Error("Expected third after second failed, not", s.Name())
+	}
+	if got := bs.Order(); got[0] != 2 || got[1] != 0 || got[2] != 1 {
+		t.Error("Expected Order to start at third, not", got)
+	}
+
+	// Removing the best moves to the next in traversal order and indexes stay correct
+	if err := bs.RemoveServer(third); err != nil {
+		t.Fatal(err)
+	}
+	if s, ix := bs.Best(); s != first || ix != 0 {
+		t.Error("Expected first at index 0 after removing third, not", s.Name(), ix)
+	}
+	if err := bs.AddServer(fourth); err != nil {
+		t.Fatal(err)
+	}
+	if got := bs.order; len(got) != 3 || got[0] != 1 || got[1] != 0 || got[2] != 2 {
+		t.Error("Expected order of second, first, fourth, not", got)
+	}
+}
+
+func TestTraditionalStats(t *testing.T) {
+	bs, _ := NewTraditional(TraditionalConfig{}, []Server{first, second, third})
+	now := time.Now()
+	st := bs.Stats()
+	if st.Algorithm != "traditional" || st.BestIndex != 0 || st.Cycles != 0 || len(st.ConsecutiveFailures) != 3 {
+		t.Fatal("Unexpected initial Stats", st)
+	}
+
+	bs.Result(first, false, now, 0)
+	bs.Result(second, false, now, 0)
+	bs.Result(second, false, now, 0) // Not best so no advance but still counted
+	bs.Result(third, false, now, 0)  // Wraps back to first
+	st = bs.Stats()
+	if st.BestIndex != 0 || st.Cycles != 1 {
+		t.Error("Expected a cycle back to first", st)
+	}
+	if got := st.ConsecutiveFailures; got[0] != 1 || got[1] != 2 || got[2] != 1 {
+		t.Error("Wrong ConsecutiveFailures", got)
+	}
+
+	bs.Result(second, true, now, 0)
+	st.ConsecutiveFailures[0] = 99 // Stats must be a copy
+	st = bs.Stats()
+	if got := st.ConsecutiveFailures; got[0] != 1 || got[1] != 0 {
+		t.Error("Success did not reset ConsecutiveFailures or Stats() is not a copy", got)
+	}
+
+	// Other algorithms provide the common Stats
+
+	var m Manager
+	m, _ = NewLatency(LatencyConfig{}, []Server{first, second})
+	if st := m.Stats(); st.Algorithm != "latency" || st.ConsecutiveFailures != nil {
+		t.Error("Unexpected latency Stats", st)
+	}
+	m, _ = NewHybrid(HybridConfig{}, []Server{first, second})
+	m.Result(first, false, now, 0)
+	if st := m.Stats(); st.Algorithm != "hybrid" || st.BestIndex != 1 || st.ConsecutiveFailures[0] != 1 {
+		t.Error("Unexpected hybrid Stats", st)
+	}
+}
@@ -13,6 +13,10 @@ simply to provide the ability to report peak concurrency over a reporting period
 and in some reporting function
 
 	fmt.Println("Peak Concurrency",  cct.Peak(true))
+
+If requests can be identified, AddKey() and DoneKey() additionally track how many of the concurrent
+requests duplicate another in-flight request with the same key. These are the requests which could
+be, or are, coalesced into a single upstream request and Coalesced() reports their peak.
 */
 package concurrencytracker
 
@@ -25,6 +29,10 @@ type Counter struct {
 	sync.Mutex
 	current int // Count of pending Done() calls
 	peak    int // Max 'current' has ever reached
+
+	keys          map[string]int // In-flight count per AddKey() key
+	coalesced     int            // Count of in-flight requests which duplicate another key
+	coalescedPeak int            // Max 'coalesced' has ever reached
 }
 
 // Add increments 'current' and if a new peak has been reached, the peak value is updated. Return
@@ -52,6 +60,75 @@ func (t *Counter) Done() {
 	t.current--
 }
 
+// AddKey is Add() for a request identified by key. If another request with the same key is already
+// in-flight, this request is counted as coalesced. DoneKey() must be called with the same key.
+func (t *Counter) AddKey(key string) (increased bool) {
+	increased = t.Add()
+	t.Lock()
+	defer t.Unlock()
+	if t.keys == nil {
+		t.keys = make(map[string]int)
+	}
+	t.keys[key]++
+	if t.keys[key] > 1 {
+		t.coalesced++
+		if t.coalesced > t.coalescedPeak {
+			t.coalescedPeak = t.coalesced
+		}
+	}
+
+	return
+}
+
+// DoneKey is Done() for a request previously passed to AddKey(). A panic ensues if key is not
+// in-flight.
+func (t *Counter) DoneKey(key string) {
+	t.Done()
+	t.Lock()
+	defer t.Unlock()
+	n := t.keys[key]
+	switch {
+	case n == 0:
+		panic("concurrencytracker.DoneKey() lacks matching .AddKey()")
+	case n == 1:
+		delete(t.keys, key)
+	default:
+		t.keys[key] = n - 1
+		t.coalesced--
+	}
+}
+
+// Current returns the number of requests currently in-flight, that is, Add() calls awaiting a
+// matching Done().
+func (t *Counter) Current() int {
+	t.Lock()
+	defer t.Unlock()
+
+	return t.current
+}
+
+// Distinct returns the current number of distinct keys in-flight. Requests added with Add() rather
+// than AddKey() are not included.
+func (t *Counter) Distinct() int {
+	t.Lock()
+	defer t.Unlock()
+
+	return len(t.keys)
+}
+
+// Coalesced returns the peak count of in-flight requests which duplicated another in-flight
+// request and optionally resets the peak to the current value in the same way as Peak().
+func (t *Counter) Coalesced(resetCounters bool) (peak int) {
+	t.Lock()
+	defer t.Unlock()
+	peak = t.coalescedPeak
+	if resetCounters {
+		t.coalescedPeak = t.coalesced
+	}
+
+	return
+}
+
 // Peak returns the peak concurrency count and optionally resets the peak value to the current
 // concurrency value. Note that the current counter is *not* reset by this call. In fact that value
 // is never rest. The reset occurs *after* the return value is set so the impact of the reset is not
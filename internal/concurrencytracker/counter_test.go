@@ -21,6 +21,10 @@ func TestAll(t *testing.T) {
 		t.Error("Peak should reflect Add->2, not", peak)
 	}
 
+	if cct.Current() != 2 {
+		t.Error("Current should be 2, not", cct.Current())
+	}
+
 	cct.Done()            // Should be: current=1, peak=2
 	peak = cct.Peak(true) // true means peak=current. Should be: current=1, peak=1
 	if peak != 2 {
@@ -77,3 +81,44 @@ func panicFunc(gotPanic *bool) {
 	}()
 	cct.Done() // Should cause panic and set the gotPanic flag
 }
+
+func TestKeys(t *testing.T) {
+	var cct Counter
+	cct.AddKey("a") // a=1
+	cct.AddKey("b") // a=1 b=1
+	cct.AddKey("a") // a=2 b=1 - one coalesced
+	cct.AddKey("a") // a=3 b=1 - two coalesced
+	if d := cct.Distinct(); d != 2 {
+		t.Error("Expected two distinct keys, not", d)
+	}
+	if p := cct.Peak(false); p != 4 {
+		t.Error("Keyed requests should count towards peak. Expect 4, not", p)
+	}
+
+	cct.DoneKey("a")                      // a=2 b=1 - one coalesced
+	if c := cct.Coalesced(true); c != 2 { // Reset to current of one
+		t.Error("Coalesced peak should be 2, not", c)
+	}
+	if c := cct.Coalesced(false); c != 1 {
+		t.Error("Coalesced peak should have been reset to current of 1, not", c)
+	}
+
+	cct.DoneKey("a")
+	cct.DoneKey("a")
+	cct.DoneKey("b")
+	if d := cct.Distinct(); d != 0 {
+		t.Error("Expected no distinct keys, not", d)
+	}
+	cct.Coalesced(true)
+	if c := cct.Coalesced(false); c != 0 {
+		t.Error("Coalesced peak should have been reset to zero, not", c)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected panic from DoneKey without AddKey")
+		}
+	}()
+	cct.Add()
+	cct.DoneKey("c")
+}
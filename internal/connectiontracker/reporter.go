@@ -1,8 +1,11 @@
 package connectiontracker
 
 import (
+	"encoding/json"
 	"fmt"
 	"time"
+
+	"github.com/markdingo/trustydns/internal/reporter"
 )
 
 // Name implements the reporter interface
@@ -12,16 +15,46 @@ func (t *Tracker) Name() string {
 
 // Name Report implements the reporter interface
 func (t *Tracker) Report(resetCounters bool) string {
+	tr := t.snapshot(resetCounters)
+
+	return fmt.Sprintf("curr=%d pk=%d sess=%d (%s) errs=%d (%s) reaped=%d connFor=%0.1fs activeFor=%0.1fs %s",
+		tr.Current, tr.PeakConns, tr.PeakSessions, formatCounters("%d", "/", tr.Sessions),
+		tr.Errors, formatCounters("%d", "/", tr.Failures.Values), tr.Reaped, tr.ConnFor, tr.ActiveFor, tr.Name)
+}
+
+// ReportJSON implements the reporter.MetricsReporter interface
+func (t *Tracker) ReportJSON(resetCounters bool) ([]byte, error) {
+	return json.Marshal(t.snapshot(resetCounters))
+}
+
+// trackerReport is a snapshot of the tracker stats shared by Report() and ReportJSON()
+type trackerReport struct {
+	Current      int               `json:"curr"`
+	PeakConns    int               `json:"pk"`
+	PeakSessions int               `json:"sess"`
+	Sessions     []int             `json:"sessHist"` // Connections with 1/2-5/6-20/21+ peak sessions
+	Errors       int               `json:"errs"`
+	Failures     reporter.Counters `json:"failures"`
+	Reaped       int               `json:"reaped"`
+	ConnFor      float64           `json:"connFor"`   // Seconds
+	ActiveFor    float64           `json:"activeFor"` // Seconds
+	Name         string            `json:"name"`
+}
+
+// snapshot gathers up the current stats and optionally resets them
+func (t *Tracker) snapshot(resetCounters bool) *trackerReport {
 	t.mu.Lock()
 	defer t.mu.Unlock()
-	errs := 0
+	tr := &trackerReport{Current: len(t.connMap), PeakConns: t.peakConns, PeakSessions: t.peakSessions,
+		Sessions:  append([]int{}, t.sessions[:]...),
+		Failures:  reporter.NewCounters(errNames[:], t.errors[:]),
+		Reaped:    t.reaped,
+		ConnFor:   t.connFor.Round(time.Millisecond * 100).Seconds(),
+		ActiveFor: t.activeFor.Round(time.Millisecond * 100).Seconds(),
+		Name:      t.name}
 	for _, v := range t.errors {
-		errs += v
+		tr.Errors += v
 	}
-	report := fmt.Sprintf("curr=%d pk=%d sess=%d errs=%d (%s) connFor=%0.1fs activeFor=%0.1fs %s",
-		len(t.connMap), t.peakConns, t.peakSessions, errs, formatCounters("%d", "/", t.errors[:]),
-		t.connFor.Round(time.Millisecond*100).Seconds(), t.activeFor.Round(time.Millisecond*100).Seconds(),
-		t.name)
 	if resetCounters {
 		t.trackerStats = trackerStats{}
 		for _, v := range t.connMap {
@@ -29,7 +62,7 @@ func (t *Tracker) Report(resetCounters bool) string {
 		}
 	}
 
-	return report
+	return tr
 }
 
 // formatCounters returns a nice %d/%d/%d format from an array of ints. This is less error-prone
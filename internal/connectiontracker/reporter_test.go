@@ -19,8 +19,8 @@ func TestReporterName(t *testing.T) {
 }
 
 const (
-	zero = "curr=0 pk=0 sess=0 errs=0 (0/0/0/0/0/0) connFor=0.0s activeFor=0.0s Filo"
-	one  = "curr=1 pk=1 sess=0 errs=0 (0/0/0/0/0/0) connFor=0.0s activeFor=0.0s Filo"
+	zero = "curr=0 pk=0 sess=0 (0/0/0/0) errs=0 (0/0/0/0/0/0) reaped=0 connFor=0.0s activeFor=0.0s Filo"
+	one  = "curr=1 pk=1 sess=0 (0/0/0/0) errs=0 (0/0/0/0/0/0) reaped=0 connFor=0.0s activeFor=0.0s Filo"
 )
 
 func TestReporterReport(t *testing.T) {
@@ -41,6 +41,7 @@ type connectionStats struct {
 	connStart       time.Time     // When connection was first established
 	activeStart     time.Time     // Last transition to active
 	activeFor       time.Duration // Sum of active periods
+	idleStart       time.Time     // Last transition to idle - zero when active
 	currentSessions int
 	peakSessions    int
 }
@@ -64,12 +65,44 @@ const (
 	errArSize
 )
 
+// errNames are the JSON report keys of the errors array
+var errNames = [errArSize]string{"noConnInMap", "noConnForSession", "danglingConn", "negativeConcurrency",
+	"connsLost", "unknownState"}
+
+// sessIx indexes the histogram of peak concurrent sessions per closed connection. Connections which
+// never carried a session are not counted.
+type sessIx int
+
+const (
+	sess1     sessIx = iota // Exactly one
+	sess2to5                // 2-5
+	sess6to20               // 6-20
+	sess21Up                // More than 20
+	sessArSize
+)
+
+// sessionBucket returns the histogram index for a connection's peak concurrent sessions.
+func sessionBucket(peak int) sessIx {
+	switch {
+	case peak <= 1:
+		return sess1
+	case peak <= 5:
+		return sess2to5
+	case peak <= 20:
+		return sess6to20
+	}
+
+	return sess21Up
+}
+
 type trackerStats struct {
 	peakConns    int
 	peakSessions int
 	connFor      time.Duration // Total connections existence time (can easily be GT elapse)
 	activeFor    time.Duration // Total connections active time
 	errors       [errArSize]int
+	reaped       int             // Connections passed to the Reap() callback
+	sessions     [sessArSize]int // Histogram of peak concurrent sessions per connection
 }
 
 type Tracker struct {
@@ -109,6 +142,7 @@ func (t *Tracker) ConnState(key string, now time.Time, state http.ConnState) boo
 	if state == http.StateNew { // All other states must have a pre-existing connection
 		cs := &connection{} // Always create a new and possibly over-write any dangling
 		cs.connStart = now  // connection.
+		cs.idleStart = now
 		t.connMap[key] = cs
 		if ok { // Dangling connection? Report it
 			t.errors[errDanglingConn]++
@@ -128,6 +162,7 @@ func (t *Tracker) ConnState(key string, now time.Time, state http.ConnState) boo
 	switch state {
 	case http.StateActive:
 		cs.activeStart = now
+		cs.idleStart = time.Time{}
 		return true
 
 	case http.StateIdle:
@@ -135,6 +170,7 @@ func (t *Tracker) ConnState(key string, now time.Time, state http.ConnState) boo
 			cs.activeFor += now.Sub(cs.activeStart)
 			cs.activeStart = time.Time{}
 		}
+		cs.idleStart = now
 		return true
 
 	case http.StateHijacked, http.StateClosed:
@@ -152,6 +188,9 @@ func (t *Tracker) ConnState(key string, now time.Time, state http.ConnState) boo
 		if cs.peakSessions > t.peakSessions {
 			t.peakSessions = cs.peakSessions
 		}
+		if cs.peakSessions > 0 {
+			t.sessions[sessionBucket(cs.peakSessions)]++
+		}
 		return true
 	}
 
@@ -159,6 +198,19 @@ func (t *Tracker) ConnState(key string, now time.Time, state http.ConnState) boo
 	return false
 }
 
+// Current returns the number of connections currently open along with the number of sessions
+// active across all of those connections.
+func (t *Tracker) Current() (conns, sessions int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, cs := range t.connMap {
+		sessions += cs.currentSessions
+	}
+
+	return len(t.connMap), sessions
+}
+
 // SessionAdd increments a session counter within a connection. Not all connections support multiple
 // sessions, but some such as HTTP2, do. Return false if the connection key is not know.
 func (t *Tracker) SessionAdd(key string) bool {
@@ -199,3 +251,29 @@ func (t *Tracker) SessionDone(key string) bool {
 
 	return true
 }
+
+// Reap calls reaper for each connection which has been idle with no current sessions for longer
+// than maxIdle and returns the number of connections passed to reaper. Normally reaper closes the
+// underlying net.Conn which in turn causes http.Server to transition the connection to
+// StateClosed, thus Reap does not remove the connection itself. The connection is marked as
+// freshly idle so that it is not offered to reaper again until another maxIdle period elapses.
+//
+// reaper is called without the tracker lock held so it is free to call back into the tracker.
+func (t *Tracker) Reap(now time.Time, maxIdle time.Duration, reaper func(key string)) int {
+	var keys []string
+	t.mu.Lock()
+	for key, cs := range t.connMap {
+		if cs.currentSessions == 0 && !cs.idleStart.IsZero() && now.Sub(cs.idleStart) > maxIdle {
+			keys = append(keys, key)
+			cs.idleStart = now
+		}
+	}
+	t.reaped += len(keys)
+	t.mu.Unlock()
+
+	for _, key := range keys {
+		reaper(key)
+	}
+
+	return len(keys)
+}
@@ -2,6 +2,8 @@ package connectiontracker
 
 import (
 	"net/http"
+	"reflect"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -25,6 +27,11 @@ func TestUniqueConns(t *testing.T) {
 	if !strings.Contains(rep, "curr=2") {
 		t.Error("Expected curr=2, got", rep)
 	}
+	trk.SessionAdd("1.2.3.4:5")
+	if conns, sessions := trk.Current(); conns != 2 || sessions != 1 {
+		t.Error("Expected Current() of 2, 1 not", conns, sessions)
+	}
+	trk.SessionDone("1.2.3.4:5")
 
 	res = trk.ConnState("1.2.3.4:5", now, http.StateClosed)
 	if !res {
@@ -43,7 +50,7 @@ func TestUniqueConns(t *testing.T) {
 }
 
 const (
-	exp = "curr=0 pk=2 sess=0 errs=0 (0/0/0/0/0/0) connFor=1260.0s activeFor=420.0s Active"
+	exp = "curr=0 pk=2 sess=0 (0/0/0/0) errs=0 (0/0/0/0/0/0) reaped=0 connFor=1260.0s activeFor=420.0s Active"
 )
 
 // Check that the active times are accumlated correctly
@@ -87,7 +94,7 @@ func TestDurations(t *testing.T) {
 }
 
 const (
-	peakSession = "curr=0 pk=1 sess=2 errs=0 (0/0/0/0/0/0) connFor=0.0s activeFor=0.0s Sessions"
+	peakSession = "curr=0 pk=1 sess=2 (0/1/0/0) errs=0 (0/0/0/0/0/0) reaped=0 connFor=0.0s activeFor=0.0s Sessions"
 )
 
 func TestSessions(t *testing.T) {
@@ -111,6 +118,30 @@ func TestSessions(t *testing.T) {
 	}
 }
 
+func TestSessionHistogram(t *testing.T) {
+	trk := New("Hist")
+	for ix, peak := range []int{0, 1, 1, 2, 5, 6, 20, 21, 100} {
+		key := strconv.Itoa(ix)
+		trk.ConnState(key, time.Now(), http.StateNew)
+		for sx := 0; sx < peak; sx++ {
+			trk.SessionAdd(key)
+		}
+		for sx := 0; sx < peak; sx++ {
+			trk.SessionDone(key)
+		}
+		trk.ConnState(key, time.Now(), http.StateClosed)
+	}
+	expect := []int{2, 2, 2, 2}
+	tr := trk.snapshot(true)
+	if !reflect.DeepEqual(tr.Sessions, expect) {
+		t.Error("Expected histogram", expect, "got", tr.Sessions)
+	}
+	tr = trk.snapshot(false)
+	if !reflect.DeepEqual(tr.Sessions, []int{0, 0, 0, 0}) {
+		t.Error("Expected reset histogram, got", tr.Sessions)
+	}
+}
+
 // Exercise all the error paths when the supplied state doesn't match the internal state.
 func TestStateErrors(t *testing.T) {
 	trk := New("State Errors")
@@ -185,3 +216,45 @@ func TestStateErrors(t *testing.T) {
 		t.Error("Invalid state should have returned false", trk)
 	}
 }
+
+func TestReap(t *testing.T) {
+	trk := New("Reap")
+	now := time.Now()
+	trk.ConnState("idle", now, http.StateNew)
+	trk.ConnState("active", now, http.StateNew)
+	trk.ConnState("active", now, http.StateActive)
+	trk.ConnState("session", now, http.StateNew)
+	trk.SessionAdd("session")
+	trk.ConnState("recent", now, http.StateNew)
+	trk.ConnState("recent", now, http.StateActive)
+	trk.ConnState("recent", now.Add(time.Minute), http.StateIdle)
+
+	var reaped []string
+	reaper := func(key string) {
+		reaped = append(reaped, key)
+		trk.ConnState(key, now, http.StateClosed) // Make sure the lock is not held
+	}
+	if n := trk.Reap(now.Add(time.Second*30), time.Minute, reaper); n != 0 {
+		t.Error("Nothing should be reaped before maxIdle", n, reaped)
+	}
+	n := trk.Reap(now.Add(time.Second*90), time.Minute, reaper)
+	if n != 1 || len(reaped) != 1 || reaped[0] != "idle" {
+		t.Error("Expected only 'idle' to be reaped, got", n, reaped)
+	}
+	if trk.snapshot(false).Reaped != 1 {
+		t.Error("Expected reaped count of 1", trk.Report(false))
+	}
+
+	trk.SessionDone("session") // Now eligible along with "recent"
+	reaped = nil
+	n = trk.Reap(now.Add(time.Minute*3), time.Minute, reaper)
+	if n != 2 || len(reaped) != 2 {
+		t.Error("Expected 'session' and 'recent' to be reaped, got", n, reaped)
+	}
+	if tr := trk.snapshot(true); tr.Current != 1 || tr.Reaped != 3 {
+		t.Error("Expected only 'active' to remain with 3 reaped", tr.Current, tr.Reaped)
+	}
+	if trk.snapshot(false).Reaped != 0 {
+		t.Error("resetCounters did not reset reaped", trk.Report(false))
+	}
+}
@@ -0,0 +1,59 @@
+package constants
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// GitCommit and BuildDate are injected by the linker when building via the Makefiles, eg:
+//
+//	go build -ldflags "-X github.com/markdingo/trustydns/internal/constants.GitCommit=abc123"
+//
+// They are variables rather than members of Constants because the linker can only set string
+// variables.
+var (
+	GitCommit string
+	BuildDate string
+)
+
+// BuildInfo describes the running executable for --version-json
+type BuildInfo struct {
+	Program   string `json:"program"`
+	Version   string `json:"version"`
+	GoVersion string `json:"go_version"`
+	BuildDate string `json:"build_date"`
+	GitCommit string `json:"git_commit"`
+}
+
+// GetBuildInfo returns the build metadata for program. If the linker did not set GitCommit or
+// BuildDate, the VCS details recorded by the go tool are used instead, if present. In that case the
+// commit time stands in for the build date.
+func GetBuildInfo(program string) BuildInfo {
+	bi := BuildInfo{Program: program, Version: readOnlyConstants.Version, GoVersion: runtime.Version(),
+		BuildDate: BuildDate, GitCommit: GitCommit}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return bi
+	}
+	modified := false
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			if len(bi.GitCommit) == 0 {
+				bi.GitCommit = s.Value
+			}
+		case "vcs.time":
+			if len(bi.BuildDate) == 0 {
+				bi.BuildDate = s.Value
+			}
+		case "vcs.modified":
+			modified = s.Value == "true"
+		}
+	}
+	if modified && len(GitCommit) == 0 && len(bi.GitCommit) > 0 {
+		bi.GitCommit += "-dirty"
+	}
+
+	return bi
+}
@@ -22,9 +22,11 @@ type Constants struct {
 	PackageName       string
 	PackageURL        string
 	RFC               string
+	EnvironmentPrefix string // Of environment variables which supply option values
 
-	HTTPSDefaultPort string // HTTP related constants
-	AgeHeader        string
+	HTTPSDefaultPort   string // HTTP related constants
+	AgeHeader          string
+	CacheControlHeader string
 
 	AcceptHeader      string // Place in every request
 	ContentTypeHeader string
@@ -32,6 +34,10 @@ type Constants struct {
 
 	TrustyDurationHeader             string // Server header with time.Duration of server-side resolution
 	TrustySynthesizeECSRequestHeader string // Proxy header with ipv4, ipv6 prefix length
+	TrustyTraceHeader                string // Proxy header with trace ID correlating proxy and server logs
+
+	TrustyDebugMetaOption uint16 // EDNS0 local option asking the server for resolution meta data
+	TrustyDebugMetaName   string // Owner name of the TXT RR returned in response to the above
 
 	ConnectionValue    string
 	Rfc8484AcceptValue string
@@ -64,10 +70,12 @@ func createReadOnlyConstants() {
 		PackageName:       "Trusty DNS Over HTTPS",
 		PackageURL:        "https://github.com/markdingo/trustydns",
 		RFC:               "RFC8484",
+		EnvironmentPrefix: "TRUSTYDNS_",
 
 		HTTPSDefaultPort: "443",
 
-		AgeHeader: "Age",
+		AgeHeader:          "Age",
+		CacheControlHeader: "Cache-Control",
 
 		AcceptHeader:      "Accept",
 		ContentTypeHeader: "Content-Type",
@@ -75,6 +83,10 @@ func createReadOnlyConstants() {
 
 		TrustyDurationHeader:             "X-trustydns-Duration",
 		TrustySynthesizeECSRequestHeader: "X-trustydns-Synth",
+		TrustyTraceHeader:                "X-trustydns-Trace",
+
+		TrustyDebugMetaOption: 65311, // From the rfc6891 Local/Experimental range
+		TrustyDebugMetaName:   "trustydns-meta.",
 
 		ConnectionValue:    "Keep-Alive",
 		Rfc8484AcceptValue: "application/dns-message",
@@ -36,3 +36,18 @@ func TestValues(t *testing.T) {
 		t.Error("consts.MinimumViableDNSMessage should be set but it's zero")
 	}
 }
+
+func TestGetBuildInfo(t *testing.T) {
+	bi := GetBuildInfo("test-program")
+	if bi.Program != "test-program" || bi.Version != Get().Version || len(bi.GoVersion) == 0 {
+		t.Error("BuildInfo not populated", bi)
+	}
+
+	GitCommit = "abc123"
+	BuildDate = "2024-01-02T03:04:05Z"
+	defer func() { GitCommit, BuildDate = "", "" }()
+	bi = GetBuildInfo("test-program")
+	if bi.GitCommit != "abc123" || bi.BuildDate != "2024-01-02T03:04:05Z" {
+		t.Error("Linker variables should take precedence", bi)
+	}
+}
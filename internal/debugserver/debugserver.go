@@ -0,0 +1,93 @@
+/*
+Package debugserver provides an HTTP listener which serves the standard Go introspection handlers:
+expvar at /debug/vars and net/http/pprof at /debug/pprof/ along with any administrative handlers
+added by the application. It is intended for ad hoc debugging of a running process so it only
+listens on loopback addresses. Anything more exposed than that should be fronted by something which
+provides authentication.
+
+The handlers are registered on a private ServeMux rather than http.DefaultServeMux so they are
+never inadvertently served by any other listener in the process.
+*/
+package debugserver
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"time"
+)
+
+// Server is a running debug listener
+type Server struct {
+	listener net.Listener
+	mux      *http.ServeMux
+	server   *http.Server
+}
+
+// CheckAddress returns an error if address is not a host:port where the host is a loopback IP
+// address or "localhost".
+func CheckAddress(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if host == "localhost" {
+		return nil
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !ip.IsLoopback() {
+		return errors.New(address + " is not a loopback address")
+	}
+
+	return nil
+}
+
+// Start listens on address and serves the debug handlers in a separate go-routine until Close()
+// is called. The address is checked with CheckAddress().
+func Start(address string) (*Server, error) {
+	if err := CheckAddress(address); err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/debug/pprof/", pprof.Index) // Index also serves the named profiles
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	t := &Server{listener: ln, mux: mux,
+		server: &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}}
+	go t.server.Serve(ln)
+
+	return t, nil
+}
+
+// Handle registers an additional handler for pattern, typically an administrative action such as
+// flushing a cache. Such handlers inherit the loopback-only restriction of the listener.
+func (t *Server) Handle(pattern string, handler http.Handler) {
+	t.mux.Handle(pattern, handler)
+}
+
+// Addr returns the listen address which is mainly of interest if Start() was given port zero.
+func (t *Server) Addr() net.Addr {
+	return t.listener.Addr()
+}
+
+// Close stops the listener and any in-progress requests.
+func (t *Server) Close() error {
+	err := t.server.Close()
+	if err != nil {
+		return fmt.Errorf("debugserver: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,84 @@
+package debugserver
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCheckAddress(t *testing.T) {
+	testCases := []struct {
+		address string
+		ok      bool
+	}{
+		{"127.0.0.1:6060", true},
+		{"127.0.0.2:6060", true},
+		{"[::1]:6060", true},
+		{"localhost:6060", true},
+		{"0.0.0.0:6060", false},
+		{":6060", false},
+		{"192.0.2.1:6060", false},
+		{"example.net:6060", false},
+		{"127.0.0.1", false}, // No port
+	}
+
+	for _, tc := range testCases {
+		err := CheckAddress(tc.address)
+		if tc.ok && err != nil {
+			t.Error(tc.address, "unexpected error", err)
+		}
+		if !tc.ok && err == nil {
+			t.Error(tc.address, "expected an error")
+		}
+	}
+}
+
+func TestStart(t *testing.T) {
+	_, err := Start("0.0.0.0:0")
+	if err == nil {
+		t.Fatal("Expected Start to reject a non-loopback address")
+	}
+
+	s, err := Start("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for _, path := range []string{"/debug/vars", "/debug/pprof/", "/debug/pprof/goroutine?debug=1"} {
+		resp, err := http.Get("http://" + s.Addr().String() + path)
+		if err != nil {
+			t.Fatal(path, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Error(path, "unexpected status", resp.Status)
+		}
+		if path == "/debug/vars" && !strings.Contains(string(body), `"memstats"`) {
+			t.Error("/debug/vars missing memstats", string(body))
+		}
+	}
+
+	resp, err := http.Get("http://" + s.Addr().String() + "/dns-query")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Error("Expected non-debug path to be Not Found, got", resp.Status)
+	}
+
+	s.Handle("/admin", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	resp, err = http.Get("http://" + s.Addr().String() + "/admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Error("Expected added handler to be served, got", resp.Status)
+	}
+}
@@ -0,0 +1,176 @@
+package dnsutil
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// CanonicalizeMsg sorts the RRs in the Answer, Authority and Additional sections of msg into
+// canonical order and removes duplicates so that two responses containing the same RRs in
+// different orders end up identical. It is intended for comparing responses and generating stable
+// cache keys.
+//
+// RRs are ordered by owner name in rfc4034 Section 6.1 canonical order, then by type, class, RDATA
+// as uncompressed wire-format octets and lastly TTL. The RDATA comparison follows rfc4034 Section
+// 6.3 except that embedded domain names are not lower-cased. RRs are duplicates if they differ only
+// by TTL, in which case the one with the lowest TTL survives as that is the one a cache honors.
+//
+// The question is never touched. OPT, TSIG and SIG RRs in the Additional section retain their
+// positions as their placement is significant. The relative order of RRsets is not preserved, so
+// a CNAME chain may no longer be in resolution order; canonicalize a copy if the message is
+// subsequently returned to a client.
+//
+// Return the number of duplicate RRs removed.
+func CanonicalizeMsg(msg *dns.Msg) (removed int) {
+	var n int
+	msg.Answer, n = canonicalizeRRs(msg.Answer)
+	removed += n
+	msg.Ns, n = canonicalizeRRs(msg.Ns)
+	removed += n
+
+	// Extract the position-sensitive RRs from Additional, canonicalize the rest then put them
+	// back where they were.
+
+	type pinned struct {
+		ix int
+		rr dns.RR
+	}
+	var pins []pinned
+	others := make([]dns.RR, 0, len(msg.Extra))
+	for ix, rr := range msg.Extra {
+		switch rr.Header().Rrtype {
+		case dns.TypeOPT, dns.TypeTSIG, dns.TypeSIG:
+			pins = append(pins, pinned{ix, rr})
+		default:
+			others = append(others, rr)
+		}
+	}
+	if len(pins) == 0 {
+		msg.Extra, n = canonicalizeRRs(msg.Extra)
+		return removed + n
+	}
+
+	others, n = canonicalizeRRs(others)
+	removed += n
+	extra := make([]dns.RR, 0, len(others)+len(pins))
+	for _, p := range pins {
+		ix := p.ix
+		if ix > len(extra)+len(others) { // Duplicate removal may have shortened the section
+			ix = len(extra) + len(others)
+		}
+		take := ix - len(extra)
+		extra = append(extra, others[:take]...)
+		others = others[take:]
+		extra = append(extra, p.rr)
+	}
+	msg.Extra = append(extra, others...)
+
+	return
+}
+
+// canonicalRR caches the sort keys of an RR so they are only computed once per sort.
+type canonicalRR struct {
+	rr    dns.RR
+	name  []string // Lower-cased labels in reverse order
+	rdata []byte
+}
+
+// canonicalizeRRs returns the RRs sorted into canonical order with duplicates removed as well as
+// the number of duplicates removed.
+func canonicalizeRRs(rrs []dns.RR) ([]dns.RR, int) {
+	if len(rrs) < 2 {
+		return rrs, 0
+	}
+
+	crrs := make([]canonicalRR, 0, len(rrs))
+	for _, rr := range rrs {
+		crrs = append(crrs, canonicalRR{rr: rr, name: reverseLabels(rr.Header().Name), rdata: packRdata(rr)})
+	}
+	sort.SliceStable(crrs, func(i, j int) bool { return compareCanonical(&crrs[i], &crrs[j], true) < 0 })
+
+	out := make([]dns.RR, 0, len(crrs))
+	out = append(out, crrs[0].rr)
+	for ix := 1; ix < len(crrs); ix++ {
+		if compareCanonical(&crrs[ix-1], &crrs[ix], false) != 0 {
+			out = append(out, crrs[ix].rr)
+		}
+	}
+
+	return out, len(rrs) - len(out)
+}
+
+// compareCanonical returns -1, 0 or 1 in the style of bytes.Compare. The TTL is only considered
+// if withTTL is true.
+func compareCanonical(a, b *canonicalRR, withTTL bool) int {
+	if c := compareLabels(a.name, b.name); c != 0 {
+		return c
+	}
+	ah, bh := a.rr.Header(), b.rr.Header()
+	if ah.Rrtype != bh.Rrtype {
+		return compareUint(uint32(ah.Rrtype), uint32(bh.Rrtype))
+	}
+	if ah.Class != bh.Class {
+		return compareUint(uint32(ah.Class), uint32(bh.Class))
+	}
+	if c := bytes.Compare(a.rdata, b.rdata); c != 0 {
+		return c
+	}
+	if withTTL {
+		return compareUint(ah.Ttl, bh.Ttl)
+	}
+
+	return 0
+}
+
+func compareUint(a, b uint32) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+
+	return 0
+}
+
+// compareLabels compares reversed label lists. A name which is a prefix of another, such as
+// example.net vs www.example.net, sorts first.
+func compareLabels(a, b []string) int {
+	for ix := 0; ix < len(a) && ix < len(b); ix++ {
+		if c := strings.Compare(a[ix], b[ix]); c != 0 {
+			return c
+		}
+	}
+
+	return compareUint(uint32(len(a)), uint32(len(b)))
+}
+
+// reverseLabels returns the lower-cased labels of name from the root down.
+func reverseLabels(name string) []string {
+	labels := dns.SplitDomainName(strings.ToLower(name))
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+
+	return labels
+}
+
+// packRdata returns the uncompressed wire-format RDATA of rr. If rr cannot be packed, the
+// presentation format is used instead so that the ordering is still deterministic.
+func packRdata(rr dns.RR) []byte {
+	buf := make([]byte, dns.Len(rr)+1)
+	off, err := dns.PackRR(rr, buf, 0, nil, false)
+	if err != nil {
+		return []byte(rr.String())
+	}
+	nameEnd := 0 // Walk the uncompressed owner name labels to find the end of the header
+	for buf[nameEnd] != 0 {
+		nameEnd += int(buf[nameEnd]) + 1
+	}
+	nameEnd++
+
+	return buf[nameEnd+10 : off] // Skip type, class, TTL and rdlength
+}
@@ -0,0 +1,141 @@
+package dnsutil
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func newRRs(t *testing.T, rrStrings ...string) []dns.RR {
+	var rrs []dns.RR
+	for _, s := range rrStrings {
+		rr, err := dns.NewRR(s)
+		checkFatal(t, err, s)
+		rrs = append(rrs, rr)
+	}
+
+	return rrs
+}
+
+func rrStrings(rrs []dns.RR) []string {
+	var out []string
+	for _, rr := range rrs {
+		out = append(out, rr.String())
+	}
+
+	return out
+}
+
+func TestCanonicalizeEmpty(t *testing.T) {
+	m := &dns.Msg{}
+	if CanonicalizeMsg(m) != 0 {
+		t.Error("CanonicalizeMsg removed RRs from an empty message")
+	}
+	if m.Answer != nil || m.Ns != nil || m.Extra != nil {
+		t.Error("CanonicalizeMsg created sections in an empty message", m)
+	}
+}
+
+// Canonical order is by reversed labels so the parent sorts before its children and case is
+// ignored. Within an RRset the RDATA octets decide.
+func TestCanonicalizeOrder(t *testing.T) {
+	expect := newRRs(t,
+		"example.net. 60 IN A 10.0.0.1",
+		"example.net. 60 IN A 10.0.0.2",
+		"example.net. 60 IN A 192.0.2.1",
+		"example.net. 60 IN AAAA 2001:db8::1",
+		"a.example.net. 60 IN A 10.0.0.9",
+		"Z.a.example.net. 60 IN TXT \"z\"",
+		"www.example.net. 60 IN CNAME example.net.",
+		"example.org. 60 IN A 10.0.0.1",
+	)
+
+	// Many shuffles should all produce the same order
+	for ix := 0; ix < 50; ix++ {
+		m := &dns.Msg{}
+		m.Answer = append(m.Answer, expect...)
+		rand.Shuffle(len(m.Answer), func(i, j int) { m.Answer[i], m.Answer[j] = m.Answer[j], m.Answer[i] })
+		if CanonicalizeMsg(m) != 0 {
+			t.Fatal("CanonicalizeMsg removed RRs which are not duplicates", m.Answer)
+		}
+		for jx := range expect {
+			if m.Answer[jx] != expect[jx] {
+				t.Fatal(ix, "Wrong order. Expected\n", rrStrings(expect), "\ngot\n", rrStrings(m.Answer))
+			}
+		}
+	}
+}
+
+func TestCanonicalizeDuplicates(t *testing.T) {
+	m := &dns.Msg{}
+	m.Answer = newRRs(t,
+		"example.net. 300 IN A 10.0.0.1",
+		"EXAMPLE.net. 60 IN A 10.0.0.1", // Duplicate differing in case and TTL
+		"example.net. 60 IN A 10.0.0.2",
+		"example.net. 60 IN A 10.0.0.2", // Exact duplicate
+		"example.net. 60 CH A 10.0.0.2", // Class differs so not a duplicate
+	)
+	m.Ns = newRRs(t, "example.net. 60 IN NS ns.example.net.", "example.net. 60 IN NS ns.example.net.")
+
+	removed := CanonicalizeMsg(m)
+	if removed != 3 {
+		t.Error("Expected 3 duplicates removed, not", removed, rrStrings(m.Answer), rrStrings(m.Ns))
+	}
+	if len(m.Answer) != 3 || len(m.Ns) != 1 {
+		t.Fatal("Wrong survivors", rrStrings(m.Answer), rrStrings(m.Ns))
+	}
+	if m.Answer[0].Header().Ttl != 60 {
+		t.Error("Expected the lowest TTL duplicate to survive", m.Answer[0])
+	}
+	if m.Answer[2].Header().Class != dns.ClassCHAOS {
+		t.Error("Expected the CH RR to sort last", rrStrings(m.Answer))
+	}
+}
+
+// The question is untouched and the OPT stays where it was in Additional
+func TestCanonicalizePinned(t *testing.T) {
+	m := &dns.Msg{}
+	m.SetQuestion("Example.NET.", dns.TypeNS)
+	glue := newRRs(t, "ns2.example.net. 60 IN A 10.0.0.2", "ns1.example.net. 60 IN A 10.0.0.1",
+		"ns1.example.net. 60 IN A 10.0.0.1")
+	opt := NewOPT()
+	m.Extra = []dns.RR{glue[0], opt, glue[1], glue[2]}
+
+	if CanonicalizeMsg(m) != 1 {
+		t.Error("Expected one duplicate glue RR to be removed", m.Extra)
+	}
+	if m.Question[0].Name != "Example.NET." {
+		t.Error("Question modified", m.Question)
+	}
+	if len(m.Extra) != 3 || m.Extra[1] != opt || m.Extra[0] != glue[1] || m.Extra[2] != glue[0] {
+		t.Error("OPT not retained in position or glue not sorted", m.Extra)
+	}
+
+	// OPT at the end with duplicate removal shortening the section
+	m.Extra = []dns.RR{glue[0], glue[1], glue[2], opt}
+	CanonicalizeMsg(m)
+	if len(m.Extra) != 3 || m.Extra[2] != opt {
+		t.Error("OPT should be last", m.Extra)
+	}
+}
+
+// Canonicalizing the same data in different orders results in identical packed messages
+func TestCanonicalizePacked(t *testing.T) {
+	a := &dns.Msg{}
+	a.SetQuestion("example.net.", dns.TypeA)
+	a.Answer = newRRs(t, "example.net. 60 IN A 10.0.0.3", "example.net. 60 IN A 10.0.0.1",
+		"example.net. 60 IN A 10.0.0.2")
+	b := a.Copy()
+	b.Answer[0], b.Answer[2] = b.Answer[2], b.Answer[0]
+
+	CanonicalizeMsg(a)
+	CanonicalizeMsg(b)
+	pa, err := a.Pack()
+	checkFatal(t, err, "Pack a")
+	pb, err := b.Pack()
+	checkFatal(t, err, "Pack b")
+	if string(pa) != string(pb) {
+		t.Error("Canonicalized messages differ\n", a, "\n", b)
+	}
+}
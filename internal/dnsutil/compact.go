@@ -2,16 +2,37 @@ package dnsutil
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/miekg/dns"
 )
 
+// CompactOptions is a bitmask of the optional fields appended by CompactMsgStringWith()
+type CompactOptions uint
+
+const (
+	CompactFlags       CompactOptions = 1 << iota // flags=qr,aa,tc,rd,ra,z,ad,cd,do in dig(1) style
+	CompactRcode                                  // rcode=NXDOMAIN
+	CompactUDPSize                                // udp=1232 or udp=- if there is no OPT
+	CompactAnswerCount                            // an=2
+
+	CompactNone   CompactOptions = 0
+	CompactDetail                = CompactFlags | CompactRcode | CompactUDPSize | CompactAnswerCount
+)
+
 // CompactMsgString generates a relatively compact single-line, printable representation of most of
 // the useful data for DoH in dns.Msg. The output is intended to be well suited to printing to a log
 // or trace file.
 //
 // The generated format is: ID/Op/rcode (bits) IN/type/qname ACount/NCount/ECount Answers Auths Extras
 func CompactMsgString(m *dns.Msg) string {
+	return CompactMsgStringWith(m, CompactNone)
+}
+
+// CompactMsgStringWith is CompactMsgString() with the fields selected by opts appended as
+// space-separated key=value pairs. These duplicate some of the terse output in a form which is
+// easier for a human to read.
+func CompactMsgStringWith(m *dns.Msg, opts CompactOptions) string {
 	bits := ""
 	if m.MsgHdr.Response {
 		bits += "R"
@@ -56,6 +77,42 @@ func CompactMsgString(m *dns.Msg) string {
 		qClass, qType, qName, len(m.Answer), len(m.Ns), len(m.Extra))
 	s += " A:" + CompactRRsString(m.Answer) + " N:" + CompactRRsString(m.Ns) + " E:" + CompactRRsString(m.Extra)
 
+	opt := m.IsEdns0()
+	if opts&CompactFlags != 0 {
+		var flags []string
+		for _, f := range []struct {
+			set  bool
+			name string
+		}{
+			{m.MsgHdr.Response, "qr"}, {m.MsgHdr.Authoritative, "aa"}, {m.MsgHdr.Truncated, "tc"},
+			{m.MsgHdr.RecursionDesired, "rd"}, {m.MsgHdr.RecursionAvailable, "ra"},
+			{m.MsgHdr.Zero, "z"}, {m.MsgHdr.AuthenticatedData, "ad"},
+			{m.MsgHdr.CheckingDisabled, "cd"}, {opt != nil && opt.Do(), "do"},
+		} {
+			if f.set {
+				flags = append(flags, f.name)
+			}
+		}
+		s += " flags=" + strings.Join(flags, ",")
+	}
+	if opts&CompactRcode != 0 {
+		rcode, ok := dns.RcodeToString[m.MsgHdr.Rcode]
+		if !ok {
+			rcode = fmt.Sprintf("%d", m.MsgHdr.Rcode)
+		}
+		s += " rcode=" + rcode
+	}
+	if opts&CompactUDPSize != 0 {
+		if opt != nil {
+			s += fmt.Sprintf(" udp=%d", opt.UDPSize())
+		} else {
+			s += " udp=-"
+		}
+	}
+	if opts&CompactAnswerCount != 0 {
+		s += fmt.Sprintf(" an=%d", len(m.Answer))
+	}
+
 	return s
 }
 
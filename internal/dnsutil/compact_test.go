@@ -78,3 +78,40 @@ func TestCompactString(t *testing.T) {
 		t.Error("Expected Extended OPT output", s1)
 	}
 }
+
+func TestCompactStringWith(t *testing.T) {
+	m := &dns.Msg{}
+	m.SetQuestion("example.net.", dns.TypeA)
+	if CompactMsgStringWith(m, CompactNone) != CompactMsgString(m) {
+		t.Error("CompactNone should produce the same output as CompactMsgString")
+	}
+
+	s := CompactMsgStringWith(m, CompactDetail)
+	if !strings.HasSuffix(s, " flags=rd rcode=NOERROR udp=- an=0") {
+		t.Error("CompactDetail without OPT wrong", s)
+	}
+
+	a, err := dns.NewRR("example.net. 300 IN A 192.0.2.1")
+	checkFatal(t, err, "newRR a")
+	m.Answer = append(m.Answer, a)
+	m.Response = true
+	m.RecursionAvailable = true
+	m.Rcode = dns.RcodeNameError
+	m.SetEdns0(1232, true)
+
+	s = CompactMsgStringWith(m, CompactDetail)
+	if !strings.HasSuffix(s, " flags=qr,rd,ra,do rcode=NXDOMAIN udp=1232 an=1") {
+		t.Error("CompactDetail with OPT wrong", s)
+	}
+
+	s = CompactMsgStringWith(m, CompactRcode|CompactAnswerCount)
+	if !strings.HasSuffix(s, " rcode=NXDOMAIN an=1") || strings.Contains(s, "flags=") {
+		t.Error("Subset of options wrong", s)
+	}
+
+	m.Rcode = 4000 // Unknown rcode
+	s = CompactMsgStringWith(m, CompactRcode)
+	if !strings.HasSuffix(s, " rcode=4000") {
+		t.Error("Unknown rcode should print as a number", s)
+	}
+}
@@ -0,0 +1,61 @@
+package dnsutil
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+// IDNToASCII converts any non-ASCII labels in name to their rfc5891 A-label (xn--) form so that
+// an internationalized domain name such as "müller.example" can be queried. Labels which are
+// already ASCII are left untouched as the IDNA lookup rules reject characters which are
+// legitimate in the DNS, such as the underscore in "_sip._tcp". A trailing dot is preserved.
+//
+// Return the converted name and true if any label was converted. An error is returned if a
+// non-ASCII label is not a valid U-label.
+func IDNToASCII(name string) (string, bool, error) {
+	if isASCII(name) {
+		return name, false, nil
+	}
+
+	labels := strings.Split(name, ".")
+	for ix, label := range labels {
+		if isASCII(label) {
+			continue
+		}
+		alabel, err := idna.Lookup.ToASCII(label)
+		if err != nil {
+			return "", false, err
+		}
+		labels[ix] = alabel
+	}
+
+	return strings.Join(labels, "."), true, nil
+}
+
+// IDNToUnicode is the display-side inverse of IDNToASCII(). Any A-labels in name are decoded to
+// their Unicode form. Labels which fail to decode are left as-is as this function is only
+// intended for presentation.
+func IDNToUnicode(name string) string {
+	labels := strings.Split(name, ".")
+	for ix, label := range labels {
+		if len(label) > 4 && strings.EqualFold(label[:4], "xn--") {
+			if ulabel, err := idna.Lookup.ToUnicode(label); err == nil {
+				labels[ix] = ulabel
+			}
+		}
+	}
+
+	return strings.Join(labels, ".")
+}
+
+func isASCII(s string) bool {
+	for ix := 0; ix < len(s); ix++ {
+		if s[ix] >= utf8.RuneSelf {
+			return false
+		}
+	}
+
+	return true
+}
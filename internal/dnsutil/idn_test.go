@@ -0,0 +1,50 @@
+package dnsutil
+
+import (
+	"testing"
+)
+
+func TestIDNToASCII(t *testing.T) {
+	testCases := []struct {
+		in        string
+		out       string
+		converted bool
+		err       bool
+	}{
+		{"example.net.", "example.net.", false, false},
+		{"_sip._tcp.Example.net", "_sip._tcp.Example.net", false, false}, // ASCII is untouched
+		{"müller.example.", "xn--mller-kva.example.", true, false},
+		{"MÜLLER.example", "xn--mller-kva.example", true, false},
+		{"_sip._tcp.müller.example.", "_sip._tcp.xn--mller-kva.example.", true, false},
+		{"bücher.日本.", "xn--bcher-kva.xn--wgv71a.", true, false},
+		{"\u0300abc.example.", "", false, true}, // Combining mark cannot start a label
+	}
+
+	for ix, tc := range testCases {
+		out, converted, err := IDNToASCII(tc.in)
+		if (err != nil) != tc.err {
+			t.Error(ix, "Unexpected error state", err)
+			continue
+		}
+		if out != tc.out || converted != tc.converted {
+			t.Error(ix, "Expected", tc.out, tc.converted, "got", out, converted)
+		}
+	}
+}
+
+func TestIDNToUnicode(t *testing.T) {
+	testCases := []struct{ in, out string }{
+		{"example.net.", "example.net."},
+		{"xn--mller-kva.example.", "müller.example."},
+		{"XN--MLLER-KVA.example", "müller.example"},
+		{"_sip._tcp.xn--bcher-kva.xn--wgv71a.", "_sip._tcp.bücher.日本."},
+		{"xn--.example.", "xn--.example."},                       // Too short to be an A-label
+		{"xn--99999999999.example.", "xn--99999999999.example."}, // Undecodable is left alone
+	}
+
+	for ix, tc := range testCases {
+		if out := IDNToUnicode(tc.in); out != tc.out {
+			t.Error(ix, "Expected", tc.out, "got", out)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package dnsutil
+
+import (
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// SetTCPKeepalive replaces any EDNS0 TCP Keepalive sub-option (rfc7828) in msg with one containing
+// the supplied idle timeout. If no OPT exists, one is created. The timeout is converted to the
+// 100ms units used on the wire and is clamped to the range 100ms to 6553.5s as a timeout of zero
+// has no useful meaning in a response.
+//
+// Return the created keepalive option.
+func SetTCPKeepalive(msg *dns.Msg, timeout time.Duration) *dns.EDNS0_TCP_KEEPALIVE {
+	units := timeout / (100 * time.Millisecond)
+	if units < 1 {
+		units = 1
+	}
+	if units > 0xFFFF {
+		units = 0xFFFF
+	}
+
+	RemoveEDNS0FromOPT(msg, dns.EDNS0TCPKEEPALIVE)
+	ka := &dns.EDNS0_TCP_KEEPALIVE{Code: dns.EDNS0TCPKEEPALIVE, Timeout: uint16(units)}
+
+	optRR := FindOPT(msg)
+	if optRR == nil {
+		optRR = NewOPT()
+		msg.Extra = append(msg.Extra, optRR)
+	}
+	optRR.Option = append(optRR.Option, ka)
+
+	return ka
+}
@@ -0,0 +1,58 @@
+package dnsutil
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func findKeepalive(msg *dns.Msg) (count int, ka *dns.EDNS0_TCP_KEEPALIVE) {
+	for _, rr := range msg.Extra {
+		if opt, ok := rr.(*dns.OPT); ok {
+			for _, subOpt := range opt.Option {
+				if k, ok := subOpt.(*dns.EDNS0_TCP_KEEPALIVE); ok {
+					count++
+					ka = k
+				}
+			}
+		}
+	}
+
+	return
+}
+
+func TestSetTCPKeepalive(t *testing.T) {
+	m := &dns.Msg{}
+	SetTCPKeepalive(m, 30*time.Second)
+	count, ka := findKeepalive(m)
+	if count != 1 {
+		t.Fatal("Expected one keepalive option to be created, not", count)
+	}
+	if ka.Timeout != 300 {
+		t.Error("Expected keepalive timeout of 300 (x100ms) not", ka.Timeout)
+	}
+
+	SetTCPKeepalive(m, time.Millisecond) // Replaces and clamps to minimum
+	count, ka = findKeepalive(m)
+	if count != 1 || ka.Timeout != 1 {
+		t.Error("Expected one keepalive with timeout 1, got", count, ka)
+	}
+
+	SetTCPKeepalive(m, 24*time.Hour) // Clamps to maximum
+	_, ka = findKeepalive(m)
+	if ka.Timeout != 0xFFFF {
+		t.Error("Expected keepalive to be clamped to 0xFFFF not", ka.Timeout)
+	}
+
+	// Make sure it survives a pack/unpack cycle
+	m.SetQuestion("example.net.", dns.TypeA)
+	binary, err := m.Pack()
+	checkFatal(t, err, "Pack")
+	m2 := &dns.Msg{}
+	checkFatal(t, m2.Unpack(binary), "Unpack")
+	_, ka = findKeepalive(m2)
+	if ka == nil || ka.Timeout != 0xFFFF {
+		t.Error("Keepalive did not survive pack/unpack", m2)
+	}
+}
@@ -6,6 +6,7 @@ checked that the dns.Msg is a legitimate IN/Query prior to calling any of these
 package dnsutil
 
 import (
+	"fmt"
 	"net"
 
 	"github.com/markdingo/trustydns/internal/constants"
@@ -51,6 +52,21 @@ func FindECS(q *dns.Msg) (*dns.OPT, *dns.EDNS0_SUBNET) {
 	return nil, nil
 }
 
+// ECSString renders the first ECS sub-option found by FindECS() in the form "192.0.2.0/24 scope /16"
+// for logging. An empty string is returned if msg contains no ECS.
+func ECSString(msg *dns.Msg) string {
+	_, ecs := FindECS(msg)
+	if ecs == nil {
+		return ""
+	}
+	addr := "?"
+	if len(ecs.Address) > 0 {
+		addr = ecs.Address.String()
+	}
+
+	return fmt.Sprintf("%s/%d scope /%d", addr, ecs.SourceNetmask, ecs.SourceScope)
+}
+
 // RemoveEDNS0FromOPT aggressively removes all occurrences of the specified EDNS0 sub-option in the
 // Extra RR list of a dns.Msg. It makes the worst-case assumption that there may be multiple options
 // and sub-options.
@@ -85,6 +101,82 @@ func RemoveEDNS0FromOPT(msg *dns.Msg, edns0Code uint16) (removed bool) {
 	return
 }
 
+// KeepOnlyEDNS0 is the inverse of RemoveEDNS0FromOPT(). It removes every EDNS0 sub-option whose
+// code is not in codes from all OPTs in the Extra RR list of a dns.Msg. As with
+// RemoveEDNS0FromOPT() an OPT which becomes empty is removed entirely. Use FilterEDNS0() if an
+// emptied OPT must be retained.
+//
+// True is returned if at least one sub-option was removed.
+func KeepOnlyEDNS0(msg *dns.Msg, codes ...uint16) (removed bool) {
+	outRRs := make([]dns.RR, 0, len(msg.Extra))
+	for _, rr := range msg.Extra {
+		inOpt, ok := rr.(*dns.OPT)
+		if !ok {
+			outRRs = append(outRRs, rr)
+			continue
+		}
+
+		outOpt := &dns.OPT{Hdr: inOpt.Hdr}
+		for _, opt := range inOpt.Option {
+			if containsCode(codes, opt.Option()) {
+				outOpt.Option = append(outOpt.Option, opt)
+			}
+		}
+		switch {
+		case len(outOpt.Option) == len(inOpt.Option): // Untouched, including originally empty OPTs
+			outRRs = append(outRRs, inOpt)
+		case len(outOpt.Option) > 0:
+			removed = true
+			outRRs = append(outRRs, outOpt)
+		default:
+			removed = true // Emptied OPT is dropped
+		}
+	}
+
+	if removed {
+		msg.Extra = outRRs
+	}
+
+	return
+}
+
+func containsCode(codes []uint16, code uint16) bool {
+	for _, c := range codes {
+		if c == code {
+			return true
+		}
+	}
+
+	return false
+}
+
+// FilterEDNS0 removes all sub-options for which keep returns false from every OPT in the Extra
+// section of the dns.Msg. Unlike RemoveEDNS0FromOPT() an OPT is retained even if all of its
+// sub-options are removed, as the OPT itself still conveys the UDP size, DO bit and EDNS version.
+//
+// Return the number of sub-options removed.
+func FilterEDNS0(msg *dns.Msg, keep func(code uint16) bool) (removed int) {
+	for _, rr := range msg.Extra {
+		opt, ok := rr.(*dns.OPT)
+		if !ok {
+			continue
+		}
+		survivors := make([]dns.EDNS0, 0, len(opt.Option))
+		for _, o := range opt.Option {
+			if keep(o.Option()) {
+				survivors = append(survivors, o)
+			} else {
+				removed++
+			}
+		}
+		if len(survivors) != len(opt.Option) {
+			opt.Option = survivors
+		}
+	}
+
+	return
+}
+
 // CreateECS arbitrarily creates an EDNS0_SUBNET sub-option which is appended to the OPT in the
 // Extra section of the dns.Msg. If no OPT exists, one is created. This function does not check for
 // any pre-existing EDNS0_SUBNET sub-option.
@@ -109,6 +201,78 @@ func CreateECS(msg *dns.Msg, family, prefixLength int, ip net.IP) *dns.EDNS0_SUB
 	return ecs
 }
 
+// AddEDE appends an rfc8914 Extended DNS Error sub-option to the OPT in the Extra section of the
+// dns.Msg. Unlike CreateECS() no OPT is created as rfc6891 only allows an OPT in a response if the
+// query also contained one, which only the caller knows.
+//
+// Return the created ede option or nil if the message has no OPT.
+func AddEDE(msg *dns.Msg, infoCode uint16, extraText string) *dns.EDNS0_EDE {
+	optRR := FindOPT(msg)
+	if optRR == nil {
+		return nil
+	}
+	ede := &dns.EDNS0_EDE{InfoCode: infoCode, ExtraText: extraText}
+	optRR.Option = append(optRR.Option, ede)
+
+	return ede
+}
+
+// IsNegative returns true if msg is an rfc2308 negative response, that is, either NXDOMAIN or
+// NODATA. NODATA is a NOERROR response with no Answer RRs of the question type, though a CNAME
+// chain leading to nothing may be present. Without a question, NODATA means an empty Answer.
+func IsNegative(msg *dns.Msg) bool {
+	switch msg.Rcode {
+	case dns.RcodeNameError:
+		return true
+	case dns.RcodeSuccess:
+	default:
+		return false
+	}
+
+	if len(msg.Question) == 0 || msg.Question[0].Qtype == dns.TypeANY || msg.Question[0].Qtype == dns.TypeCNAME {
+		return len(msg.Answer) == 0
+	}
+	for _, rr := range msg.Answer {
+		if rr.Header().Rrtype == msg.Question[0].Qtype {
+			return false
+		}
+	}
+
+	return true
+}
+
+// MinTTL returns the smallest TTL across all RRs in the Answer and Authority sections of msg. For
+// negative responses the SOA MINIMUM is also included as rfc2308 Section 5 limits negative caching
+// to the lesser of the SOA TTL and SOA MINIMUM.
+//
+// False is returned if there is no TTL to be had, which includes negative responses lacking a SOA
+// as rfc2308 says they should not be cached at all.
+func MinTTL(msg *dns.Msg) (uint32, bool) {
+	negative := IsNegative(msg)
+	var min uint32
+	found, foundSOA := false, false
+	for _, section := range [][]dns.RR{msg.Answer, msg.Ns} {
+		for _, rr := range section {
+			ttl := rr.Header().Ttl
+			if soa, ok := rr.(*dns.SOA); ok && negative {
+				foundSOA = true
+				if soa.Minttl < ttl {
+					ttl = soa.Minttl
+				}
+			}
+			if !found || ttl < min {
+				min = ttl
+				found = true
+			}
+		}
+	}
+	if negative && !foundSOA {
+		return 0, false
+	}
+
+	return min, found
+}
+
 // ReduceTTL reduces the TTL in all the RRs in Answer, Ns and Extra that have a TTL greater than 1.
 // "by" defines how much to reduce TTLs by and "minimum" is the lower limit that we'll ever let a
 // TTL reduce to.
@@ -127,6 +291,25 @@ func ReduceTTL(msg *dns.Msg, by uint32, minimum uint32) int {
 	return changeCount
 }
 
+// RaiseTTL raises the TTL of all the RRs in the Answer section which are below minimum up to
+// minimum. Only the Answer section is changed as the Authority SOA of a negative response governs
+// how long the negative response is cached and rfc2308 already provides a floor for that. The
+// caller is responsible for not calling this with a TSIG signed message.
+//
+// Return the number of RRs changed.
+func RaiseTTL(msg *dns.Msg, minimum uint32) int {
+	changeCount := 0
+	for _, rr := range msg.Answer {
+		hdr := rr.Header()
+		if hdr.Ttl < minimum {
+			hdr.Ttl = minimum
+			changeCount++
+		}
+	}
+
+	return changeCount
+}
+
 // Helper that does the actual TTL Reduction work for the supplied RRSet. Even tho the "by" and
 // "minimum" are int64 parameters we know that they originated from a uint32 so calcs in 64bit
 // comfortably fit the full range of possible values without contortions.
@@ -162,3 +345,56 @@ func NewOPT() *dns.OPT {
 
 	return optRR
 }
+
+// SetDO sets the DNSSEC OK bit in the OPT in the Extra section of the dns.Msg so that the resolver
+// returns DNSSEC RRs. If no OPT exists, one is created with NewOPT().
+//
+// Return the OPT containing the DO bit.
+func SetDO(msg *dns.Msg) *dns.OPT {
+	opt := FindOPT(msg)
+	if opt == nil {
+		opt = NewOPT()
+		msg.Extra = append(msg.Extra, opt)
+	}
+	opt.SetDo()
+
+	return opt
+}
+
+// MergeEDNS copies the EDNS0 sub-options in the OPT of src into the OPT of dst so that a series of
+// EDNS0 manipulations can be composed without one losing the work of another. If dst has no OPT,
+// one is created with NewOPT(). Codes are never duplicated: a src sub-option replaces any dst
+// sub-option with the same code in place, otherwise it is appended. The sub-options are copied so
+// subsequent changes to src do not affect dst. Nothing is done if src has no OPT.
+//
+// Return the number of sub-options merged into dst.
+func MergeEDNS(dst, src *dns.Msg) (merged int) {
+	srcOpt := FindOPT(src)
+	if srcOpt == nil || len(srcOpt.Option) == 0 {
+		return
+	}
+	srcOpt = dns.Copy(srcOpt).(*dns.OPT) // Deep copy of the sub-options
+
+	dstOpt := FindOPT(dst)
+	if dstOpt == nil {
+		dstOpt = NewOPT()
+		dst.Extra = append(dst.Extra, dstOpt)
+	}
+
+	for _, subOpt := range srcOpt.Option {
+		replaced := false
+		for ix, existing := range dstOpt.Option {
+			if existing.Option() == subOpt.Option() {
+				dstOpt.Option[ix] = subOpt
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			dstOpt.Option = append(dstOpt.Option, subOpt)
+		}
+		merged++
+	}
+
+	return
+}
@@ -74,6 +74,31 @@ func TestFindECS(t *testing.T) {
 	}
 }
 
+func TestECSString(t *testing.T) {
+	m := &dns.Msg{}
+	if s := ECSString(m); s != "" {
+		t.Error("ECSString should return empty string without an ECS, not", s)
+	}
+
+	ecs := CreateECS(m, 1, 24, net.ParseIP("192.0.2.0").To4())
+	ecs.SourceScope = 16
+	if s := ECSString(m); s != "192.0.2.0/24 scope /16" {
+		t.Error("ECSString IPv4 wrong", s)
+	}
+
+	m = &dns.Msg{}
+	CreateECS(m, 2, 56, net.ParseIP("2001:db8:0:ff00::"))
+	if s := ECSString(m); s != "2001:db8:0:ff00::/56 scope /0" {
+		t.Error("ECSString IPv6 wrong", s)
+	}
+
+	m = &dns.Msg{}
+	CreateECS(m, 1, 0, net.IP{})
+	if s := ECSString(m); s != "?/0 scope /0" {
+		t.Error("ECSString empty address wrong", s)
+	}
+}
+
 //////////////////////////////////////////////////////////////////////
 
 func TestRemoveEDNS0Single(t *testing.T) {
@@ -197,6 +222,79 @@ func TestRemoveNonEmptyOPT(t *testing.T) {
 	}
 }
 
+func TestKeepOnlyEDNS0(t *testing.T) {
+	m := &dns.Msg{}
+	if KeepOnlyEDNS0(m, dns.EDNS0SUBNET) {
+		t.Error("KeepOnlyEDNS0 claimed removals with an empty message")
+	}
+
+	m.Extra = append(m.Extra, &dns.OPT{}) // An originally empty OPT is left alone
+	if KeepOnlyEDNS0(m) || FindOPT(m) == nil {
+		t.Error("KeepOnlyEDNS0 should not touch an originally empty OPT")
+	}
+
+	m = &dns.Msg{}
+	newOpt := &dns.OPT{}
+	newOpt.Option = append(newOpt.Option,
+		&dns.EDNS0_COOKIE{},
+		&dns.EDNS0_PADDING{},
+		&dns.EDNS0_SUBNET{},
+		&dns.EDNS0_PADDING{})
+	m.Extra = append(m.Extra, newOpt)
+
+	if KeepOnlyEDNS0(m, dns.EDNS0SUBNET, dns.EDNS0COOKIE, dns.EDNS0PADDING) {
+		t.Error("KeepOnlyEDNS0 claimed removals when all codes were allowed")
+	}
+	if !KeepOnlyEDNS0(m, dns.EDNS0SUBNET, dns.EDNS0COOKIE) {
+		t.Error("KeepOnlyEDNS0 failed to remove EDNS0_PADDING")
+	}
+	opt := FindOPT(m)
+	if opt == nil {
+		t.Fatal("FindOPT failed but it should have found the multi-subopt OPT")
+	}
+	if len(opt.Option) != 2 {
+		t.Error("Wrong number of remaining subopts. Expected 2, got", len(opt.Option), opt)
+	}
+	if _, subOpt := FindECS(m); subOpt == nil {
+		t.Error("KeepOnlyEDNS0 removed an allowed EDNS0_SUBNET")
+	}
+
+	if !KeepOnlyEDNS0(m, dns.EDNS0NSID) {
+		t.Error("KeepOnlyEDNS0 failed to remove remaining subopts")
+	}
+	if FindOPT(m) != nil {
+		t.Error("OPT should have been removed when last subopt was removed")
+	}
+}
+
+// Test KeepOnlyEDNS0 when multiple OPTs are present. As with RemoveEDNS0FromOPT, all are filtered.
+func TestKeepOnlyEDNS0Multiple(t *testing.T) {
+	m := &dns.Msg{}
+	ecsOpt := &dns.OPT{}
+	ecsOpt.Option = append(ecsOpt.Option, &dns.EDNS0_SUBNET{})
+	mixedOpt := &dns.OPT{}
+	mixedOpt.Option = append(mixedOpt.Option, &dns.EDNS0_COOKIE{}, &dns.EDNS0_SUBNET{})
+	newOther := &dns.NS{}
+	m.Extra = append(m.Extra, newOther, ecsOpt, mixedOpt, ecsOpt, newOther)
+
+	if !KeepOnlyEDNS0(m, dns.EDNS0COOKIE) {
+		t.Error("KeepOnlyEDNS0 failed to remove existing ECS")
+	}
+	if _, subOpt := FindECS(m); subOpt != nil {
+		t.Error("FindECS had unexpected success after KeepOnlyEDNS0")
+	}
+	if len(m.Extra) != 3 {
+		t.Fatal("Should have two NS RRs and the COOKIE OPT in Extra. Not", len(m.Extra), m.Extra)
+	}
+	opt, ok := m.Extra[1].(*dns.OPT)
+	if !ok || len(opt.Option) != 1 || opt.Option[0].Option() != dns.EDNS0COOKIE {
+		t.Error("Surviving OPT should only contain the COOKIE", m.Extra[1])
+	}
+	if len(ecsOpt.Option) != 1 || len(mixedOpt.Option) != 2 {
+		t.Error("KeepOnlyEDNS0 modified the original OPTs")
+	}
+}
+
 func TestCreateECS(t *testing.T) {
 	m := &dns.Msg{}
 	CreateECS(m, 1, 19, net.IP{})
@@ -307,3 +405,225 @@ func TestReduceTTL(t *testing.T) {
 		}
 	}
 }
+
+func TestRaiseTTL(t *testing.T) {
+	a1, err := dns.NewRR("a.example.net. 0 IN A 192.0.2.1")
+	checkFatal(t, err, "newRR a1")
+	a2, err := dns.NewRR("a.example.net. 30 IN A 192.0.2.2")
+	checkFatal(t, err, "newRR a2")
+	a3, err := dns.NewRR("a.example.net. 300 IN A 192.0.2.3")
+	checkFatal(t, err, "newRR a3")
+	n1, err := dns.NewRR("example.net. 5 IN SOA ns.example.net. hostmaster.example.net. 1 2 3 4 5")
+	checkFatal(t, err, "newRR n1")
+	m := &dns.Msg{Answer: []dns.RR{a1, a2, a3}, Ns: []dns.RR{n1}}
+
+	if changed := RaiseTTL(m, 30); changed != 1 {
+		t.Error("Expected one RR raised, not", changed)
+	}
+	for ix, expect := range []uint32{30, 30, 300} {
+		if got := m.Answer[ix].Header().Ttl; got != expect {
+			t.Error(ix, "Expected TTL", expect, "got", got)
+		}
+	}
+	if n1.Header().Ttl != 5 {
+		t.Error("RaiseTTL should not change the Authority section", n1)
+	}
+	if changed := RaiseTTL(m, 0); changed != 0 {
+		t.Error("A zero minimum should change nothing, not", changed)
+	}
+}
+
+func TestAddEDE(t *testing.T) {
+	m := &dns.Msg{}
+	if AddEDE(m, dns.ExtendedErrorCodeOther, "no opt") != nil {
+		t.Error("AddEDE should not create an OPT")
+	}
+	if len(m.Extra) != 0 {
+		t.Error("AddEDE modified message without an OPT", m.Extra)
+	}
+
+	m.Extra = append(m.Extra, NewOPT())
+	ede := AddEDE(m, dns.ExtendedErrorCodeNetworkError, "timeout")
+	if ede == nil {
+		t.Fatal("AddEDE did not add EDE to existing OPT")
+	}
+	opt := FindOPT(m)
+	if len(opt.Option) != 1 || opt.Option[0] != ede {
+		t.Error("EDE not appended to OPT", opt.Option)
+	}
+	if ede.InfoCode != dns.ExtendedErrorCodeNetworkError || ede.ExtraText != "timeout" {
+		t.Error("EDE has wrong values", ede.String())
+	}
+}
+
+func TestFilterEDNS0(t *testing.T) {
+	m := &dns.Msg{}
+	if FilterEDNS0(m, func(uint16) bool { return false }) != 0 {
+		t.Error("FilterEDNS0 claimed removals with an empty message")
+	}
+
+	m.SetEdns0(1232, true)
+	opt := m.IsEdns0()
+	opt.Option = append(opt.Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: "0102030405060708"},
+		&dns.EDNS0_NSID{Code: dns.EDNS0NSID}, &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET})
+	removed := FilterEDNS0(m, func(code uint16) bool { return code == dns.EDNS0SUBNET })
+	if removed != 2 {
+		t.Error("Expected 2 removed, not", removed)
+	}
+	opt = m.IsEdns0()
+	if opt == nil || len(opt.Option) != 1 || opt.Option[0].Option() != dns.EDNS0SUBNET {
+		t.Fatal("Expected only ECS to survive", m)
+	}
+
+	removed = FilterEDNS0(m, func(uint16) bool { return false })
+	opt = m.IsEdns0()
+	if removed != 1 || opt == nil || len(opt.Option) != 0 {
+		t.Error("Expected an empty OPT to be retained", removed, m)
+	}
+	if !opt.Do() || opt.UDPSize() != 1232 {
+		t.Error("OPT header not preserved", opt)
+	}
+}
+
+func TestIsNegativeAndMinTTL(t *testing.T) {
+	rr := func(s string) dns.RR {
+		r, err := dns.NewRR(s)
+		checkFatal(t, err, s)
+		return r
+	}
+	a := rr("example.net. 300 IN A 192.0.2.1")
+	cname := rr("www.example.net. 600 IN CNAME example.net.")
+	ns := rr("example.net. 100 IN NS ns.example.net.")
+	soa := rr("example.net. 3600 IN SOA ns.example.net. hostmaster.example.net. 1 2 3 4 60")
+	glue := rr("ns.example.net. 5 IN A 192.0.2.53")
+
+	testCases := []struct {
+		qType    uint16
+		rcode    int
+		answer   []dns.RR
+		ns       []dns.RR
+		negative bool
+		ttl      uint32
+		ok       bool
+	}{
+		{dns.TypeA, dns.RcodeSuccess, []dns.RR{cname, a}, []dns.RR{ns}, false, 100, true},
+		{dns.TypeA, dns.RcodeSuccess, []dns.RR{cname, a}, nil, false, 300, true},
+		{dns.TypeAAAA, dns.RcodeSuccess, nil, []dns.RR{soa}, true, 60, true},             // NODATA
+		{dns.TypeAAAA, dns.RcodeSuccess, []dns.RR{cname}, []dns.RR{soa}, true, 60, true}, // NODATA via CNAME
+		{dns.TypeA, dns.RcodeNameError, nil, []dns.RR{soa}, true, 60, true},
+		{dns.TypeA, dns.RcodeNameError, nil, nil, true, 0, false}, // No SOA
+		{dns.TypeANY, dns.RcodeSuccess, []dns.RR{a}, nil, false, 300, true},
+		{dns.TypeA, dns.RcodeServerFailure, nil, nil, false, 0, false},
+	}
+
+	for ix, tc := range testCases {
+		m := &dns.Msg{}
+		m.SetQuestion("www.example.net.", tc.qType)
+		m.Rcode = tc.rcode
+		m.Answer = tc.answer
+		m.Ns = tc.ns
+		m.Extra = []dns.RR{glue, NewOPT()} // Additional never counts
+		if got := IsNegative(m); got != tc.negative {
+			t.Error(ix, "IsNegative expected", tc.negative, "got", got)
+		}
+		ttl, ok := MinTTL(m)
+		if ttl != tc.ttl || ok != tc.ok {
+			t.Error(ix, "MinTTL expected", tc.ttl, tc.ok, "got", ttl, ok)
+		}
+	}
+
+	// Without a question NODATA is simply an empty Answer
+	m := &dns.Msg{}
+	if !IsNegative(m) {
+		t.Error("Empty NOERROR message should be negative")
+	}
+	m.Answer = []dns.RR{a}
+	if IsNegative(m) {
+		t.Error("NOERROR message with an Answer should not be negative")
+	}
+}
+
+func TestSetDO(t *testing.T) {
+	m := &dns.Msg{}
+	opt := SetDO(m)
+	if opt == nil || !opt.Do() || len(m.Extra) != 1 || m.Extra[0] != opt {
+		t.Fatal("SetDO did not create an OPT with DO set", m.Extra)
+	}
+	if opt.UDPSize() != dns.DefaultMsgSize {
+		t.Error("SetDO should create the OPT with NewOPT(), UDP size is", opt.UDPSize())
+	}
+
+	m = &dns.Msg{}
+	existing := NewOPT()
+	existing.Option = append(existing.Option, &dns.EDNS0_COOKIE{})
+	m.Extra = append(m.Extra, &dns.NS{}, existing)
+	if opt = SetDO(m); opt != existing || !existing.Do() {
+		t.Error("SetDO should set DO in the pre-existing OPT")
+	}
+	if len(m.Extra) != 2 || len(existing.Option) != 1 {
+		t.Error("SetDO should not modify anything else", m.Extra)
+	}
+}
+
+func TestMergeEDNS(t *testing.T) {
+	src := &dns.Msg{}
+	dst := &dns.Msg{}
+	if MergeEDNS(dst, src) != 0 || len(dst.Extra) != 0 {
+		t.Error("MergeEDNS should do nothing if src has no OPT", dst.Extra)
+	}
+
+	// Create an OPT when dst has none
+
+	cookie := &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: "0102030405060708"}
+	src.Extra = append(src.Extra, &dns.NS{})
+	CreateECS(src, 1, 24, net.ParseIP("192.0.2.0").To4())
+	FindOPT(src).Option = append(FindOPT(src).Option, cookie)
+	if merged := MergeEDNS(dst, src); merged != 2 {
+		t.Error("Expected two sub-options merged, not", merged)
+	}
+	opt := FindOPT(dst)
+	if opt == nil || len(dst.Extra) != 1 || len(opt.Option) != 2 {
+		t.Fatal("MergeEDNS did not create an OPT with both sub-options", dst.Extra)
+	}
+	if opt.UDPSize() != dns.DefaultMsgSize {
+		t.Error("MergeEDNS should create the OPT with NewOPT(), UDP size is", opt.UDPSize())
+	}
+	cookie.Cookie = "ffffffffffffffff"
+	if opt.Option[1].(*dns.EDNS0_COOKIE).Cookie != "0102030405060708" {
+		t.Error("MergeEDNS should copy sub-options rather than share them")
+	}
+
+	// Merge into an existing OPT with a code collision. The src sub-option replaces the dst
+	// sub-option in place and the other dst sub-options are untouched.
+
+	dst = &dns.Msg{}
+	existing := NewOPT()
+	existing.Option = append(existing.Option, &dns.EDNS0_PADDING{Padding: make([]byte, 8)},
+		&dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: "aaaaaaaaaaaaaaaa"})
+	dst.Extra = append(dst.Extra, existing)
+	src = &dns.Msg{}
+	srcOpt := NewOPT()
+	srcOpt.Option = append(srcOpt.Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: "bbbbbbbbbbbbbbbb"},
+		&dns.EDNS0_NSID{Code: dns.EDNS0NSID})
+	src.Extra = append(src.Extra, srcOpt)
+
+	if merged := MergeEDNS(dst, src); merged != 2 {
+		t.Error("Expected two sub-options merged, not", merged)
+	}
+	if FindOPT(dst) != existing || len(dst.Extra) != 1 {
+		t.Fatal("MergeEDNS should use the existing OPT", dst.Extra)
+	}
+	codes := []uint16{}
+	for _, subOpt := range existing.Option {
+		codes = append(codes, subOpt.Option())
+	}
+	if len(codes) != 3 || codes[0] != dns.EDNS0PADDING || codes[1] != dns.EDNS0COOKIE || codes[2] != dns.EDNS0NSID {
+		t.Error("Unexpected sub-options after merge", existing.Option)
+	}
+	if c := existing.Option[1].(*dns.EDNS0_COOKIE).Cookie; c != "bbbbbbbbbbbbbbbb" {
+		t.Error("src COOKIE should have replaced the dst COOKIE, got", c)
+	}
+	if len(srcOpt.Option) != 2 {
+		t.Error("MergeEDNS modified src", srcOpt.Option)
+	}
+}
@@ -0,0 +1,70 @@
+package dnsutil
+
+import (
+	"fmt"
+)
+
+const (
+	MaxNameLength  = 255 // rfc1035 Section 2.3.4 limit in wire-format octets
+	MaxLabelLength = 63  // rfc1035 Section 2.3.4 limit in octets
+	MaxNameLabels  = 64  // Not a protocol limit, simply more than any legitimate name needs
+)
+
+// ValidateName checks that name, in the presentation format produced by dns.Msg.Unpack(), is
+// within the rfc1035 size limits and has no more than MaxNameLabels labels. Escaped characters
+// such as "\." and "\000" count as the single octet they represent. The name may or may not have
+// a trailing dot. The root name "." is valid, an empty name or one containing an empty label is
+// not.
+//
+// Return nil if name is acceptable otherwise an error describing the first problem found.
+func ValidateName(name string) error {
+	if name == "." {
+		return nil
+	}
+	if len(name) == 0 {
+		return fmt.Errorf("empty name")
+	}
+
+	wireLength := 1 // The terminating root label
+	labels := 0
+	labelLength := 0
+	for ix := 0; ix < len(name); ix++ {
+		switch name[ix] {
+		case '.':
+			if labelLength == 0 {
+				return fmt.Errorf("empty label at offset %d", ix)
+			}
+			labels++
+			wireLength += labelLength + 1
+			labelLength = 0
+			continue
+		case '\\':
+			if ix+3 < len(name) && isDigit(name[ix+1]) && isDigit(name[ix+2]) && isDigit(name[ix+3]) {
+				ix += 3 // \DDD
+			} else if ix+1 < len(name) {
+				ix++ // \X
+			}
+		}
+		labelLength++
+		if labelLength > MaxLabelLength {
+			return fmt.Errorf("label exceeds %d octets", MaxLabelLength)
+		}
+	}
+	if labelLength > 0 { // Final label lacked a trailing dot
+		labels++
+		wireLength += labelLength + 1
+	}
+
+	if wireLength > MaxNameLength {
+		return fmt.Errorf("name is %d octets which exceeds %d", wireLength, MaxNameLength)
+	}
+	if labels > MaxNameLabels {
+		return fmt.Errorf("name has %d labels which exceeds %d", labels, MaxNameLabels)
+	}
+
+	return nil
+}
+
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
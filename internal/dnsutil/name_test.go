@@ -0,0 +1,43 @@
+package dnsutil
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateName(t *testing.T) {
+	label63 := strings.Repeat("a", 63)
+	long := strings.Repeat(label63+".", 4) // 4*64 + 1 = 257 octets
+	testCases := []struct {
+		name string
+		ok   bool
+	}{
+		{".", true},
+		{"example.net.", true},
+		{"example.net", true},
+		{label63 + ".example.", true},
+		{strings.Repeat("a.", 64), true}, // Exactly MaxNameLabels
+		{strings.Repeat(label63+".", 3) + strings.Repeat("a", 61) + ".", true}, // Exactly 255
+		{`a\.b.example.`, true},
+		{`\000\001.example.`, true},
+		{strings.Repeat(`\000`, 63) + ".", true},
+
+		{"", false},
+		{"a..example.", false},
+		{".example.", false},
+		{strings.Repeat("a", 64) + ".example.", false},
+		{strings.Repeat(`\000`, 64) + ".", false},
+		{long, false},
+		{strings.Repeat("a.", 65), false},
+	}
+
+	for ix, tc := range testCases {
+		err := ValidateName(tc.name)
+		if tc.ok && err != nil {
+			t.Error(ix, "Unexpected error", err)
+		}
+		if !tc.ok && err == nil {
+			t.Error(ix, "Expected an error for", tc.name)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package dnsutil
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// EDNS0OptionNames maps the names accepted by ParseEDNS0Code() to their EDNS0 option codes. Options
+// can also be supplied as decimal codes so this list need only contain the common ones.
+var EDNS0OptionNames = map[string]uint16{
+	"LLQ":           dns.EDNS0LLQ,
+	"NSID":          dns.EDNS0NSID,
+	"DAU":           dns.EDNS0DAU,
+	"DHU":           dns.EDNS0DHU,
+	"N3U":           dns.EDNS0N3U,
+	"EXPIRE":        dns.EDNS0EXPIRE,
+	"COOKIE":        dns.EDNS0COOKIE,
+	"TCP-KEEPALIVE": dns.EDNS0TCPKEEPALIVE,
+	"KEYTAG":        14, // rfc8145 - not defined by miekg/dns
+	"EDE":           dns.EDNS0EDE,
+}
+
+// ParseEDNS0Code converts a case-insensitive name from EDNS0OptionNames or a decimal code into an
+// EDNS0 option code.
+func ParseEDNS0Code(s string) (uint16, error) {
+	if code, ok := EDNS0OptionNames[strings.ToUpper(s)]; ok {
+		return code, nil
+	}
+	code, err := strconv.ParseUint(s, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("'%s' is neither a known option name nor a code in the range 0-65535", s)
+	}
+
+	return uint16(code), nil
+}
@@ -0,0 +1,34 @@
+package dnsutil
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestParseEDNS0Code(t *testing.T) {
+	testCases := []struct {
+		in     string
+		expect uint16
+	}{
+		{"cookie", dns.EDNS0COOKIE},
+		{"KEYTAG", 14},
+		{"Tcp-Keepalive", dns.EDNS0TCPKEEPALIVE},
+		{"65001", 65001},
+		{"0", 0},
+	}
+	for _, tc := range testCases {
+		code, err := ParseEDNS0Code(tc.in)
+		if err != nil || code != tc.expect {
+			t.Error(tc.in, "Expected", tc.expect, "got", code, err)
+		}
+	}
+
+	for _, bad := range []string{"", "bogus", "-1", "65536"} {
+		_, err := ParseEDNS0Code(bad)
+		if err == nil || !strings.Contains(err.Error(), "'"+bad+"'") {
+			t.Error("Expected error mentioning", bad, "got", err)
+		}
+	}
+}
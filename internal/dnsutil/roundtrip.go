@@ -0,0 +1,47 @@
+package dnsutil
+
+import (
+	"fmt"
+
+	"github.com/miekg/dns"
+)
+
+// ValidatePacked unpacks the packed form of msg and checks that it matches msg. It is intended
+// as a debugging aid to catch messages which dns.Pack() does not faithfully convert to wire format
+// such as when an upstream resolver returns a message which miekg/dns re-packs differently.
+//
+// Only the Id, Rcode, section counts and lengths are compared as those are the discrepancies which
+// cause interop problems. Returns nil if the two match or an error describing the first mismatch.
+func ValidatePacked(msg *dns.Msg, packed []byte) error {
+	unpacked := &dns.Msg{}
+	err := unpacked.Unpack(packed)
+	if err != nil {
+		return fmt.Errorf("ValidatePacked: Unpack failed: %s", err.Error())
+	}
+	if unpacked.Id != msg.Id {
+		return fmt.Errorf("ValidatePacked: Id %d != %d", unpacked.Id, msg.Id)
+	}
+	if unpacked.Rcode != msg.Rcode {
+		return fmt.Errorf("ValidatePacked: Rcode %d != %d", unpacked.Rcode, msg.Rcode)
+	}
+
+	for _, s := range []struct {
+		name          string
+		before, after int
+	}{
+		{"Question", len(msg.Question), len(unpacked.Question)},
+		{"Answer", len(msg.Answer), len(unpacked.Answer)},
+		{"Ns", len(msg.Ns), len(unpacked.Ns)},
+		{"Extra", len(msg.Extra), len(unpacked.Extra)},
+	} {
+		if s.before != s.after {
+			return fmt.Errorf("ValidatePacked: %s count %d != unpacked count %d", s.name, s.before, s.after)
+		}
+	}
+
+	if l := msg.Len(); l != len(packed) {
+		return fmt.Errorf("ValidatePacked: Len() %d != packed length %d", l, len(packed))
+	}
+
+	return nil
+}
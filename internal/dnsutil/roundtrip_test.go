@@ -0,0 +1,63 @@
+package dnsutil
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestValidatePacked(t *testing.T) {
+	msg := &dns.Msg{}
+	msg.SetQuestion("example.net.", dns.TypeA)
+	msg.Response = true
+	rr, err := dns.NewRR("example.net. 60 IN A 192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.Answer = append(msg.Answer, rr)
+	msg.SetEdns0(1232, false)
+
+	packed, err := msg.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidatePacked(msg, packed); err != nil {
+		t.Error("Unexpected error for a faithful Pack()", err)
+	}
+
+	padded, err := PadAndPack(msg, consts.Rfc8467ServerPadModulo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidatePacked(msg, padded); err != nil {
+		t.Error("Unexpected error for a padded Pack()", err)
+	}
+
+	testCases := []struct {
+		modify func(m *dns.Msg)
+		err    string
+	}{
+		{func(m *dns.Msg) { m.Id++ }, "Id"},
+		{func(m *dns.Msg) { m.Rcode = dns.RcodeServerFailure }, "Rcode"},
+		{func(m *dns.Msg) { m.Answer = append(m.Answer, m.Answer[0]) }, "Answer count"},
+		{func(m *dns.Msg) { m.Compress = !m.Compress }, "length"},
+	}
+	for ix, tc := range testCases {
+		m := msg.Copy()
+		packed, err := m.Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		tc.modify(m)
+		err = ValidatePacked(m, packed)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Error(ix, "Expected error containing", tc.err, "not", err)
+		}
+	}
+
+	err = ValidatePacked(msg, packed[:len(packed)-1])
+	if err == nil || !strings.Contains(err.Error(), "Unpack") {
+		t.Error("Expected Unpack error with truncated packed message, not", err)
+	}
+}
@@ -0,0 +1,42 @@
+package dnsutil
+
+import (
+	"math/rand"
+
+	"github.com/miekg/dns"
+)
+
+// ShuffleAnswers randomly permutes the order of RRs within each RRset in the Answer section of
+// msg. An RRset is taken to be a contiguous run of RRs with the same owner name, class and type, so
+// the relative order of RRsets - such as a CNAME chain preceding the final A RRs - is preserved.
+//
+// Responses with AD=1 are left untouched as their RRsets are validated in canonical order and there
+// is no benefit in giving a validating client any reason to doubt them.
+//
+// True is returned if at least one RRset containing more than one RR was permuted.
+func ShuffleAnswers(msg *dns.Msg) (shuffled bool) {
+	if msg.AuthenticatedData {
+		return false
+	}
+
+	rrs := msg.Answer
+	for start := 0; start < len(rrs); {
+		end := start + 1
+		for end < len(rrs) && sameRRset(rrs[start].Header(), rrs[end].Header()) {
+			end++
+		}
+		if end-start > 1 {
+			set := rrs[start:end]
+			rand.Shuffle(len(set), func(i, j int) { set[i], set[j] = set[j], set[i] })
+			shuffled = true
+		}
+		start = end
+	}
+
+	return
+}
+
+// sameRRset returns true if both headers belong to the same RRset.
+func sameRRset(h1, h2 *dns.RR_Header) bool {
+	return h1.Rrtype == h2.Rrtype && h1.Class == h2.Class && dns.CanonicalName(h1.Name) == dns.CanonicalName(h2.Name)
+}
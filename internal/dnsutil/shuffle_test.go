@@ -0,0 +1,63 @@
+package dnsutil
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestShuffleAnswers(t *testing.T) {
+	m := &dns.Msg{}
+	if ShuffleAnswers(m) {
+		t.Error("ShuffleAnswers claims to have shuffled an empty message")
+	}
+
+	cname, err := dns.NewRR("www.example.net. IN CNAME example.net.")
+	checkFatal(t, err, "CNAME")
+	m.Answer = append(m.Answer, cname)
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4", "10.0.0.5", "10.0.0.6"} {
+		rr, err := dns.NewRR("example.net. IN A " + ip)
+		checkFatal(t, err, ip)
+		m.Answer = append(m.Answer, rr)
+	}
+	aaaa, err := dns.NewRR("example.net. IN AAAA ::1")
+	checkFatal(t, err, "AAAA")
+	m.Answer = append(m.Answer, aaaa)
+
+	original := make([]dns.RR, len(m.Answer))
+	copy(original, m.Answer)
+
+	// With 6! permutations the chance of never seeing a change is vanishingly small.
+	changed := false
+	for ix := 0; ix < 20 && !changed; ix++ {
+		if !ShuffleAnswers(m) {
+			t.Fatal("ShuffleAnswers did not shuffle a multi-RR RRset")
+		}
+		if len(m.Answer) != len(original) {
+			t.Fatal("ShuffleAnswers changed the Answer count", len(m.Answer), len(original))
+		}
+		if m.Answer[0] != cname || m.Answer[len(m.Answer)-1] != aaaa {
+			t.Fatal("ShuffleAnswers moved RRs across RRsets", m.Answer)
+		}
+		for jx := range original {
+			if m.Answer[jx] != original[jx] {
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		t.Error("ShuffleAnswers never changed the order of the A RRset")
+	}
+
+	// AD=1 should stop all shuffling
+	copy(m.Answer, original)
+	m.AuthenticatedData = true
+	if ShuffleAnswers(m) {
+		t.Error("ShuffleAnswers shuffled an AD=1 response")
+	}
+	for jx := range original {
+		if m.Answer[jx] != original[jx] {
+			t.Fatal("ShuffleAnswers changed order of an AD=1 response", m.Answer)
+		}
+	}
+}
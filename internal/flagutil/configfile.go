@@ -0,0 +1,68 @@
+package flagutil
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ConfigFileFlag is the name of the flag which is never accepted within a config file as nested
+// config files are not supported.
+const ConfigFileFlag = "config"
+
+// LoadConfigFile reads option settings from path and applies them to flagSet. It is intended to be
+// called after flagSet.Parse() so that options on the command line take precedence over those in
+// the file which in turn take precedence over the defaults.
+//
+// Each non-blank line is of the form "name = value" where name is a flag name without leading
+// dashes. Boolean flags may omit "= value" to mean true. Lines starting with '#' are comments and
+// values may be surrounded by double quotes. Multiple occurrence flags such as those backed by a
+// StringValue may appear on multiple lines. If a flag is already set, either on the command line or
+// by LoadEnvironment(), all occurrences of it in the file are ignored rather than merged.
+func LoadConfigFile(flagSet *flag.FlagSet, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	alreadySet := make(map[string]bool)
+	flagSet.Visit(func(f *flag.Flag) { alreadySet[f.Name] = true })
+
+	scanner := bufio.NewScanner(f)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		name, value, hasValue := strings.Cut(line, "=")
+		name = strings.TrimSpace(name)
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = value[1 : len(value)-1]
+		}
+
+		fl := flagSet.Lookup(name)
+		if fl == nil || name == ConfigFileFlag {
+			return fmt.Errorf("%s:%d: '%s' is not a valid option", path, lineNumber, name)
+		}
+		if !hasValue {
+			if bf, ok := fl.Value.(interface{ IsBoolFlag() bool }); !ok || !bf.IsBoolFlag() {
+				return fmt.Errorf("%s:%d: '%s' requires a value", path, lineNumber, name)
+			}
+			value = "true"
+		}
+		if alreadySet[name] {
+			continue
+		}
+		if err := flagSet.Set(name, value); err != nil {
+			return fmt.Errorf("%s:%d: invalid value \"%s\" for %s: %s", path, lineNumber, value, name, err)
+		}
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,104 @@
+package flagutil
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "test.conf")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+type configFlags struct {
+	fs      *flag.FlagSet
+	verbose bool
+	count   int
+	name    string
+	multi   StringValue
+	config  string
+}
+
+func newConfigFlags() *configFlags {
+	t := &configFlags{fs: flag.NewFlagSet("test", flag.ContinueOnError)}
+	t.fs.SetOutput(io.Discard)
+	t.fs.BoolVar(&t.verbose, "v", false, "")
+	t.fs.IntVar(&t.count, "count", 1, "")
+	t.fs.StringVar(&t.name, "name", "default", "")
+	t.fs.Var(&t.multi, "multi", "")
+	t.fs.StringVar(&t.config, ConfigFileFlag, "", "")
+
+	return t
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	path := writeConfigFile(t, `
+# A comment
+v
+count = 10
+name = "quoted value"
+multi = a
+multi=b
+`)
+	cf := newConfigFlags()
+	if err := cf.fs.Parse([]string{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadConfigFile(cf.fs, path); err != nil {
+		t.Fatal(err)
+	}
+	if !cf.verbose || cf.count != 10 || cf.name != "quoted value" || cf.multi.String() != "a b" {
+		t.Error("File values not applied", cf.verbose, cf.count, cf.name, cf.multi.Args())
+	}
+
+	// Command line wins over the file and the file wins over defaults
+
+	cf = newConfigFlags()
+	if err := cf.fs.Parse([]string{"-count", "3", "-multi", "c"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadConfigFile(cf.fs, path); err != nil {
+		t.Fatal(err)
+	}
+	if cf.count != 3 || cf.multi.String() != "c" {
+		t.Error("Command line values should win", cf.count, cf.multi.Args())
+	}
+	if cf.name != "quoted value" {
+		t.Error("File value should win over the default", cf.name)
+	}
+}
+
+func TestLoadConfigFileErrors(t *testing.T) {
+	testCases := []struct {
+		content string
+		expect  string
+	}{
+		{"unknown = 1", "test.conf:1: 'unknown' is not a valid option"},
+		{"\n\nconfig = other.conf", "test.conf:3: 'config' is not a valid option"},
+		{"count", "'count' requires a value"},
+		{"count = abc", "invalid value \"abc\" for count"},
+		{"v = maybe", "invalid value \"maybe\" for v"},
+	}
+
+	for ix, tc := range testCases {
+		cf := newConfigFlags()
+		cf.fs.Parse([]string{})
+		err := LoadConfigFile(cf.fs, writeConfigFile(t, tc.content))
+		if err == nil || !strings.Contains(err.Error(), tc.expect) {
+			t.Error(ix, "Expected error", tc.expect, "got", err)
+		}
+	}
+
+	cf := newConfigFlags()
+	if err := LoadConfigFile(cf.fs, "testdata/does-not-exist.conf"); err == nil {
+		t.Error("Expected an error for a missing config file")
+	}
+}
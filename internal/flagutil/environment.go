@@ -0,0 +1,57 @@
+package flagutil
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ActionFlags names the flags which cause the program to print something and exit rather than
+// configure it. LoadEnvironment() never sets them as an environment variable which happens to share
+// the name, such as TRUSTYDNS_VERSION, would otherwise stop the program starting.
+var ActionFlags = map[string]bool{"h": true, "help": true, "version": true, "version-json": true, "check": true}
+
+// EnvironmentName returns the environment variable which LoadEnvironment() consults for the named
+// flag. The name is upper-cased and dashes are replaced with underscores, so with a prefix of
+// "TRUSTYDNS_" the flag "max-ttl-on-error" becomes TRUSTYDNS_MAX_TTL_ON_ERROR.
+func EnvironmentName(prefix, name string) string {
+	return prefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// LoadEnvironment sets every flag in flagSet, other than ActionFlags, which was not set on the
+// command line from its corresponding environment variable, if present and not empty. It is intended to be called after
+// flagSet.Parse() and before LoadConfigFile() so that the precedence is command line, environment,
+// config file then defaults.
+//
+// Values are parsed exactly as they would be on the command line. The exception is StringValue
+// flags which can occur multiple times on the command line. Their environment value is split on
+// commas with each element set separately, eg: TRUSTYDNS_A=127.0.0.1,::1
+func LoadEnvironment(flagSet *flag.FlagSet, prefix string) error {
+	onCommandLine := make(map[string]bool)
+	flagSet.Visit(func(f *flag.Flag) { onCommandLine[f.Name] = true })
+
+	var err error
+	flagSet.VisitAll(func(f *flag.Flag) {
+		if err != nil || onCommandLine[f.Name] || ActionFlags[f.Name] {
+			return
+		}
+		envName := EnvironmentName(prefix, f.Name)
+		value := os.Getenv(envName)
+		if len(value) == 0 {
+			return
+		}
+		values := []string{value}
+		if _, ok := f.Value.(*StringValue); ok {
+			values = strings.Split(value, ",")
+		}
+		for _, v := range values {
+			if setErr := flagSet.Set(f.Name, strings.TrimSpace(v)); setErr != nil {
+				err = fmt.Errorf("invalid value \"%s\" for %s: %s", v, envName, setErr)
+				return
+			}
+		}
+	})
+
+	return err
+}
@@ -0,0 +1,89 @@
+package flagutil
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnvironmentName(t *testing.T) {
+	if n := EnvironmentName("TRUSTYDNS_", "max-ttl-on-error"); n != "TRUSTYDNS_MAX_TTL_ON_ERROR" {
+		t.Error("Wrong environment name", n)
+	}
+	if n := EnvironmentName("TRUSTYDNS_", "A"); n != "TRUSTYDNS_A" {
+		t.Error("Wrong environment name", n)
+	}
+}
+
+func TestLoadEnvironment(t *testing.T) {
+	cf := newConfigFlags()
+	var timeout time.Duration
+	cf.fs.DurationVar(&timeout, "request-timeout", time.Second, "")
+	t.Setenv("TEST_V", "true")
+	t.Setenv("TEST_COUNT", "7")
+	t.Setenv("TEST_NAME", "") // Empty is the same as unset
+	t.Setenv("TEST_MULTI", "a, b,c")
+	t.Setenv("TEST_REQUEST_TIMEOUT", "90s")
+	t.Setenv("TEST_CONFIG", writeConfigFile(t, "name = from-file\ncount = 99\n"))
+
+	if err := cf.fs.Parse([]string{"-count", "3"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadEnvironment(cf.fs, "TEST_"); err != nil {
+		t.Fatal(err)
+	}
+	if !cf.verbose || cf.name != "default" || cf.multi.String() != "a b c" || timeout != 90*time.Second {
+		t.Error("Environment values not applied", cf.verbose, cf.name, cf.multi.Args(), timeout)
+	}
+	if cf.count != 3 {
+		t.Error("Command line value should win over the environment", cf.count)
+	}
+
+	// The environment can name the config file, but the environment wins over it
+
+	if err := LoadConfigFile(cf.fs, cf.config); err != nil {
+		t.Fatal(err)
+	}
+	if cf.name != "from-file" || cf.count != 3 {
+		t.Error("Config file after environment wrong", cf.name, cf.count)
+	}
+	t.Setenv("TEST_NAME", "from-env")
+	cf = newConfigFlags()
+	cf.fs.Parse([]string{})
+	LoadEnvironment(cf.fs, "TEST_")
+	LoadConfigFile(cf.fs, cf.config)
+	if cf.name != "from-env" || cf.count != 7 {
+		t.Error("Environment should win over the config file", cf.name, cf.count)
+	}
+}
+
+func TestLoadEnvironmentErrors(t *testing.T) {
+	t.Setenv("TEST_COUNT", "many")
+	cf := newConfigFlags()
+	cf.fs.Parse([]string{})
+	err := LoadEnvironment(cf.fs, "TEST_")
+	if err == nil || !strings.Contains(err.Error(), "invalid value \"many\" for TEST_COUNT") {
+		t.Error("Expected invalid value error, got", err)
+	}
+}
+
+// Action flags are never set from the environment
+func TestLoadEnvironmentActionFlags(t *testing.T) {
+	cf := newConfigFlags()
+	var version, check bool
+	cf.fs.BoolVar(&version, "version", false, "")
+	cf.fs.BoolVar(&check, "check", false, "")
+	t.Setenv("TEST_VERSION", "1.2.3") // Would be an invalid boolean
+	t.Setenv("TEST_CHECK", "true")
+	t.Setenv("TEST_V", "true")
+	cf.fs.Parse([]string{})
+	if err := LoadEnvironment(cf.fs, "TEST_"); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if version || check {
+		t.Error("Action flags should not be set from the environment", version, check)
+	}
+	if !cf.verbose {
+		t.Error("Other flags should still be set from the environment")
+	}
+}
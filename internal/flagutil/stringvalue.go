@@ -1,7 +1,7 @@
 // Package flagutil provides additional support around the flag package. At the moment that consists
-// solely of the StringValue struct which conforms to the flag.Value method for multiple occurrence
-// flags containing string values. Conceivably an IPValue struct would be pretty useful too as well
-// as, e.g. a CIDRValue.
+// of the StringValue struct which conforms to the flag.Value method for multiple occurrence flags
+// containing string values and LoadConfigFile() which sets flags from a file. Conceivably an IPValue
+// struct would be pretty useful too as well as, e.g. a CIDRValue.
 //
 // The reason for providing StringValue is so that commands can offer a flag to set multiple values
 // such as:
@@ -0,0 +1,192 @@
+/*
+Package happyeyeballs provides a DialContext function which races connection attempts to all the
+addresses of a host in the style of RFC8305 "Happy Eyeballs Version 2". Typical usage:
+
+	d := &happyeyeballs.Dialer{}
+	tr := &http.Transport{DialContext: d.DialContext}
+
+The addresses of the host are interleaved by address family starting with the family of the first
+address returned by the resolver. An attempt is started for the first address and each subsequent
+address is attempted after AttemptDelay or as soon as the previous attempt fails, whichever comes
+first. The first connection to succeed is returned and all other attempts are abandoned.
+
+The net.Dialer in the standard library already falls back from one address family to the other
+after a fixed delay, but it tries all addresses within a family serially so a host with a number
+of unreachable addresses in the preferred family can stall for a long time. This Dialer treats all
+addresses equally which gives much more predictable connection times on dual-stack networks with
+partial breakage.
+*/
+package happyeyeballs
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// DefaultAttemptDelay is the RFC8305 recommended "Connection Attempt Delay"
+const DefaultAttemptDelay = 250 * time.Millisecond
+
+// Dialer is the core structure used by happyeyeballs. The zero value is ready to use.
+type Dialer struct {
+	Dialer       *net.Dialer   // Makes each connection attempt. nil means a zero value net.Dialer
+	Resolver     *net.Resolver // Looks up host addresses. nil means net.DefaultResolver
+	AttemptDelay time.Duration // Head start given to each attempt. Zero means DefaultAttemptDelay
+
+	// Test hooks which replace Dialer and Resolver when set
+
+	dial   func(ctx context.Context, network, address string) (net.Conn, error)
+	lookup func(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+type result struct {
+	conn net.Conn
+	err  error
+}
+
+// DialContext has the same signature as net.Dialer.DialContext so it can be used by
+// http.Transport. Only the "tcp", "tcp4" and "tcp6" networks are raced, all others are passed
+// directly to the underlying net.Dialer as are addresses which are already IP literals.
+func (t *Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	dial := t.dial
+	if dial == nil {
+		d := t.Dialer
+		if d == nil {
+			d = &net.Dialer{}
+		}
+		dial = d.DialContext
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || net.ParseIP(host) != nil {
+		return dial(ctx, network, address) // Let the dialer deal with it
+	}
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return dial(ctx, network, address)
+	}
+
+	lookup := t.lookup
+	if lookup == nil {
+		r := t.Resolver
+		if r == nil {
+			r = net.DefaultResolver
+		}
+		lookup = r.LookupIPAddr
+	}
+	addrs, err := lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	addrs = interleave(filter(network, addrs))
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("happyeyeballs: no %s addresses for %s", network, host)
+	}
+
+	delay := t.AttemptDelay
+	if delay <= 0 {
+		delay = DefaultAttemptDelay
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // Abandons all outstanding attempts once a winner is chosen
+
+	results := make(chan result, len(addrs)) // Buffered so abandoned attempts never block
+	next := 0
+	pending := 0
+	var delayC <-chan time.Time
+	start := func() {
+		target := net.JoinHostPort(addrs[next].String(), port)
+		next++
+		pending++
+		go func() {
+			conn, err := dial(ctx, network, target)
+			results <- result{conn, err}
+		}()
+		delayC = nil
+		if next < len(addrs) {
+			delayC = time.After(delay)
+		}
+	}
+
+	start()
+	var firstErr error
+	for pending > 0 {
+		select {
+		case <-delayC:
+			start()
+
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				go closeLosers(results, pending)
+				return r.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			if next < len(addrs) {
+				start() // Don't wait for the delay when an attempt has already failed
+			}
+		}
+	}
+
+	return nil, firstErr
+}
+
+// closeLosers waits for the remaining abandoned attempts and closes any that managed to connect
+// before noticing the cancellation.
+func closeLosers(results chan result, pending int) {
+	for ; pending > 0; pending-- {
+		r := <-results
+		if r.conn != nil {
+			r.conn.Close()
+		}
+	}
+}
+
+// filter removes addresses which cannot be used with network
+func filter(network string, addrs []net.IPAddr) []net.IPAddr {
+	if network == "tcp" {
+		return addrs
+	}
+	res := make([]net.IPAddr, 0, len(addrs))
+	for _, a := range addrs {
+		if (a.IP.To4() != nil) == (network == "tcp4") {
+			res = append(res, a)
+		}
+	}
+
+	return res
+}
+
+// interleave re-orders addrs so that the address families alternate, starting with the family of
+// the first address. The order within each family is preserved.
+func interleave(addrs []net.IPAddr) []net.IPAddr {
+	if len(addrs) == 0 {
+		return addrs
+	}
+	firstIs4 := addrs[0].IP.To4() != nil
+	var first, second []net.IPAddr
+	for _, a := range addrs {
+		if (a.IP.To4() != nil) == firstIs4 {
+			first = append(first, a)
+		} else {
+			second = append(second, a)
+		}
+	}
+
+	res := make([]net.IPAddr, 0, len(addrs))
+	for ix := 0; ix < len(first) || ix < len(second); ix++ {
+		if ix < len(first) {
+			res = append(res, first[ix])
+		}
+		if ix < len(second) {
+			res = append(res, second[ix])
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,204 @@
+package happyeyeballs
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func ipAddrs(ss ...string) []net.IPAddr {
+	res := make([]net.IPAddr, 0, len(ss))
+	for _, s := range ss {
+		res = append(res, net.IPAddr{IP: net.ParseIP(s)})
+	}
+	return res
+}
+
+func addrString(addrs []net.IPAddr) string {
+	ss := make([]string, 0, len(addrs))
+	for _, a := range addrs {
+		ss = append(ss, a.IP.String())
+	}
+	return strings.Join(ss, " ")
+}
+
+func TestInterleave(t *testing.T) {
+	testCases := []struct {
+		in     []net.IPAddr
+		expect string
+	}{
+		{nil, ""},
+		{ipAddrs("192.0.2.1"), "192.0.2.1"},
+		{ipAddrs("2001:db8::1", "2001:db8::2", "192.0.2.1"), "2001:db8::1 192.0.2.1 2001:db8::2"},
+		{ipAddrs("192.0.2.1", "192.0.2.2", "2001:db8::1", "2001:db8::2"),
+			"192.0.2.1 2001:db8::1 192.0.2.2 2001:db8::2"},
+		{ipAddrs("192.0.2.1", "2001:db8::1", "2001:db8::2", "2001:db8::3"),
+			"192.0.2.1 2001:db8::1 2001:db8::2 2001:db8::3"},
+	}
+
+	for ix, tc := range testCases {
+		got := addrString(interleave(tc.in))
+		if got != tc.expect {
+			t.Error(ix, "Expected", tc.expect, "got", got)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	addrs := ipAddrs("192.0.2.1", "2001:db8::1", "192.0.2.2")
+	testCases := []struct {
+		network string
+		expect  string
+	}{
+		{"tcp", "192.0.2.1 2001:db8::1 192.0.2.2"},
+		{"tcp4", "192.0.2.1 192.0.2.2"},
+		{"tcp6", "2001:db8::1"},
+	}
+
+	for _, tc := range testCases {
+		got := addrString(filter(tc.network, addrs))
+		if got != tc.expect {
+			t.Error(tc.network, "Expected", tc.expect, "got", got)
+		}
+	}
+}
+
+// fakeNet provides dial and lookup hooks. Addresses in "hang" block until the context is cancelled,
+// addresses in "fail" fail immediately and all others succeed after "latency".
+type fakeNet struct {
+	sync.Mutex
+	addrs   []net.IPAddr
+	hang    map[string]bool
+	fail    map[string]bool
+	latency time.Duration
+	dialed  []string
+	closed  int
+}
+
+type fakeConn struct {
+	net.Conn
+	fn *fakeNet
+}
+
+func (t *fakeConn) Close() error {
+	t.fn.Lock()
+	t.fn.closed++
+	t.fn.Unlock()
+	return t.Conn.Close()
+}
+
+func (t *fakeNet) lookup(ctx context.Context, host string) ([]net.IPAddr, error) {
+	if host == "nxdomain.example" {
+		return nil, errors.New("no such host")
+	}
+	return t.addrs, nil
+}
+
+func (t *fakeNet) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	host, _, _ := net.SplitHostPort(address)
+	t.Lock()
+	t.dialed = append(t.dialed, host)
+	t.Unlock()
+	switch {
+	case t.hang[host]:
+		<-ctx.Done()
+		return nil, ctx.Err()
+	case t.fail[host]:
+		return nil, errors.New("refused " + host)
+	}
+	time.Sleep(t.latency)
+	c, _ := net.Pipe()
+	return &fakeConn{c, t}, nil
+}
+
+func (t *fakeNet) dialer() *Dialer {
+	return &Dialer{AttemptDelay: 50 * time.Millisecond, dial: t.dial, lookup: t.lookup}
+}
+
+func TestDialHangingFirstAddress(t *testing.T) {
+	fn := &fakeNet{addrs: ipAddrs("2001:db8::1", "192.0.2.1"), hang: map[string]bool{"2001:db8::1": true}}
+	now := time.Now()
+	conn, err := fn.dialer().DialContext(context.Background(), "tcp", "doh.example:443")
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	conn.Close()
+	elapsed := time.Since(now)
+	if elapsed < 50*time.Millisecond || elapsed > time.Second {
+		t.Error("Second attempt should start after AttemptDelay, took", elapsed)
+	}
+}
+
+func TestDialFailureStartsNextAttempt(t *testing.T) {
+	fn := &fakeNet{addrs: ipAddrs("2001:db8::1", "192.0.2.1"), fail: map[string]bool{"2001:db8::1": true}}
+	fn.latency = time.Millisecond
+	d := fn.dialer()
+	d.AttemptDelay = time.Hour
+	conn, err := d.DialContext(context.Background(), "tcp", "doh.example:443")
+	if err != nil {
+		t.Fatal("Failed attempt should immediately start the next attempt", err)
+	}
+	conn.Close()
+}
+
+func TestDialAllFail(t *testing.T) {
+	fn := &fakeNet{addrs: ipAddrs("2001:db8::1", "192.0.2.1"),
+		fail: map[string]bool{"2001:db8::1": true, "192.0.2.1": true}}
+	_, err := fn.dialer().DialContext(context.Background(), "tcp", "doh.example:443")
+	if err == nil || err.Error() != "refused 2001:db8::1" {
+		t.Error("Expected first error to be returned, not", err)
+	}
+
+	_, err = fn.dialer().DialContext(context.Background(), "tcp", "nxdomain.example:443")
+	if err == nil {
+		t.Error("Expected lookup error to be returned")
+	}
+
+	fn.addrs = ipAddrs("192.0.2.1")
+	_, err = fn.dialer().DialContext(context.Background(), "tcp6", "doh.example:443")
+	if err == nil || !strings.Contains(err.Error(), "no tcp6 addresses") {
+		t.Error("Expected no addresses error, not", err)
+	}
+}
+
+// Losing connections which complete after the winner must be closed
+func TestDialClosesLosers(t *testing.T) {
+	fn := &fakeNet{addrs: ipAddrs("2001:db8::1", "192.0.2.1"), latency: 100 * time.Millisecond}
+	d := fn.dialer()
+	d.AttemptDelay = time.Millisecond
+	conn, err := d.DialContext(context.Background(), "tcp", "doh.example:443")
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	time.Sleep(300 * time.Millisecond) // Give the loser time to complete
+	fn.Lock()
+	closed := fn.closed
+	fn.Unlock()
+	if closed != 1 {
+		t.Error("Expected the losing connection to be closed, closed count", closed)
+	}
+	conn.Close()
+}
+
+// IP literals and non-tcp networks bypass the race
+func TestDialPassThrough(t *testing.T) {
+	fn := &fakeNet{addrs: ipAddrs("2001:db8::1", "192.0.2.1")}
+	for _, tc := range []struct{ network, address string }{
+		{"tcp", "192.0.2.99:443"},
+		{"udp", "doh.example:53"},
+	} {
+		fn.dialed = nil
+		conn, err := fn.dialer().DialContext(context.Background(), tc.network, tc.address)
+		if err != nil {
+			t.Fatal(tc, "Unexpected error", err)
+		}
+		conn.Close()
+		if len(fn.dialed) != 1 || fn.dialed[0] != strings.Split(tc.address, ":")[0] {
+			t.Error(tc, "Expected direct dial, got", fn.dialed)
+		}
+	}
+}
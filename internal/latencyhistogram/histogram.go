@@ -0,0 +1,80 @@
+/*
+Package latencyhistogram accumulates durations into a fixed set of buckets so that approximate
+percentiles can be reported without retaining every sample. The buckets follow a 1-2-5 series from
+1ms to 10s which covers everything from a cache hit to a resolution that is about to time out. A
+percentile is reported as the upper bound of the bucket it falls in, so it is never an understatement
+of the true value. Typical usage:
+
+	var h latencyhistogram.Histogram
+
+	h.Add(time.Since(startTime))
+	...
+	fmt.Println("p99", h.Percentile(99))
+
+A Histogram is not safe for concurrent use as it is normally embedded in a stats struct which is
+already protected by the caller. The zero value is ready to use and resetting is simply a matter of
+assigning a zero value.
+*/
+package latencyhistogram
+
+import (
+	"time"
+)
+
+// bounds are the inclusive upper bounds of each bucket. An additional overflow bucket holds
+// everything larger than the last bound.
+var bounds = []time.Duration{
+	time.Millisecond, 2 * time.Millisecond, 5 * time.Millisecond,
+	10 * time.Millisecond, 20 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 200 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2 * time.Second, 5 * time.Second, 10 * time.Second,
+}
+
+// Histogram is the core structure used by latencyhistogram
+type Histogram struct {
+	counts [14]int // len(bounds)+1 for the overflow bucket
+	total  int
+	max    time.Duration // Reported for percentiles which land in the overflow bucket
+}
+
+// Add records a single duration
+func (t *Histogram) Add(d time.Duration) {
+	ix := 0
+	for ix < len(bounds) && d > bounds[ix] {
+		ix++
+	}
+	t.counts[ix]++
+	t.total++
+	if d > t.max {
+		t.max = d
+	}
+}
+
+// Count returns the number of durations added
+func (t *Histogram) Count() int {
+	return t.total
+}
+
+// Percentile returns the upper bound of the bucket containing the p'th percentile where p is in the
+// range 0-100. Zero is returned if no durations have been added.
+func (t *Histogram) Percentile(p float64) time.Duration {
+	if t.total == 0 {
+		return 0
+	}
+	rank := int(float64(t.total)*p/100 + 0.5) // Number of samples which must be LE the result
+	if rank < 1 {
+		rank = 1
+	}
+	seen := 0
+	for ix, c := range t.counts {
+		seen += c
+		if seen >= rank && ix < len(bounds) {
+			if bounds[ix] > t.max { // Never report more than we've actually seen
+				return t.max
+			}
+			return bounds[ix]
+		}
+	}
+
+	return t.max
+}
@@ -0,0 +1,51 @@
+package latencyhistogram
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	var h Histogram
+	if h.Percentile(50) != 0 || h.Count() != 0 {
+		t.Error("Zero value Histogram should return zero, not", h.Percentile(50), h.Count())
+	}
+
+	for ix := 0; ix < 90; ix++ {
+		h.Add(3 * time.Millisecond)
+	}
+	for ix := 0; ix < 9; ix++ {
+		h.Add(150 * time.Millisecond)
+	}
+	h.Add(30 * time.Second)
+
+	testCases := []struct {
+		p      float64
+		expect time.Duration
+	}{
+		{0, 5 * time.Millisecond},
+		{50, 5 * time.Millisecond},
+		{90, 5 * time.Millisecond},
+		{91, 200 * time.Millisecond},
+		{99, 200 * time.Millisecond},
+		{100, 30 * time.Second},
+	}
+	for _, tc := range testCases {
+		got := h.Percentile(tc.p)
+		if got != tc.expect {
+			t.Error("Percentile", tc.p, "expected", tc.expect, "got", got)
+		}
+	}
+	if h.Count() != 100 {
+		t.Error("Count should be 100, not", h.Count())
+	}
+}
+
+// The upper bound of a bucket should never exceed the largest duration actually added
+func TestPercentileMax(t *testing.T) {
+	var h Histogram
+	h.Add(120 * time.Millisecond)
+	if got := h.Percentile(50); got != 120*time.Millisecond {
+		t.Error("Expected Percentile capped at max of 120ms, not", got)
+	}
+}
@@ -0,0 +1,43 @@
+//go:build linux
+// +build linux
+
+package osutil
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestDialerFastOpen(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	for _, fastOpen := range []bool{false, true} {
+		conn, err := Dialer(fastOpen).DialContext(context.Background(), "tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal("Dial should succeed regardless of kernel support", fastOpen, err)
+		}
+		rc, err := conn.(*net.TCPConn).SyscallConn()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var val int
+		var opErr error
+		rc.Control(func(fd uintptr) {
+			val, opErr = unix.GetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_FASTOPEN_CONNECT)
+		})
+		conn.Close()
+		if opErr != nil {
+			t.Skip("Kernel does not support TCP_FASTOPEN_CONNECT", opErr)
+		}
+		if (val != 0) != fastOpen {
+			t.Error("TCP_FASTOPEN_CONNECT is", val, "with tcpFastOpen", fastOpen)
+		}
+	}
+}
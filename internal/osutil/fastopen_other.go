@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package osutil
+
+import (
+	"net"
+)
+
+// Dialer returns a plain net.Dialer as client-side TCP Fast Open is only supported on Linux. The
+// tcpFastOpen request is silently ignored so callers need not be platform aware.
+func Dialer(tcpFastOpen bool) *net.Dialer {
+	return &net.Dialer{}
+}
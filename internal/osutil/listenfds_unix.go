@@ -0,0 +1,71 @@
+//go:build unix || !windows
+// +build unix !windows
+
+package osutil
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	listenFDsStart = 3 // SD_LISTEN_FDS_START - the first fd passed by systemd
+)
+
+// ListenFDs returns the sockets passed to this process by systemd socket activation as per
+// sd_listen_fds(3). The LISTEN_* environment variables are removed so that any child processes do
+// not mistakenly try to adopt the same sockets. A nil slice is returned if no sockets were passed
+// to this process. Files are named from LISTEN_FDNAMES if present, otherwise they are named after
+// their fd.
+func ListenFDs() ([]*os.File, error) {
+	return listenFDs(listenFDsStart)
+}
+
+// listenFDs is the implementation of ListenFDs with a settable starting fd for testing.
+func listenFDs(start int) ([]*os.File, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil // Not for us
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil {
+		return nil, errors.New("osutil.ListenFDs: Invalid LISTEN_FDS: " + err.Error())
+	}
+	if count < 0 {
+		return nil, errors.New("osutil.ListenFDs: LISTEN_FDS cannot be negative")
+	}
+
+	return newFiles(start, count, strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")), nil
+}
+
+// InheritedFiles returns the count files passed to this process by a parent process via
+// exec.Cmd.ExtraFiles. It is up to the caller to know how many files were passed, typically via
+// an environment variable set by the parent.
+func InheritedFiles(count int) []*os.File {
+	return newFiles(listenFDsStart, count, nil)
+}
+
+// newFiles wraps count fds starting at start in os.Files. Files are given the corresponding name
+// from names or named after their fd if there is no corresponding name. All fds are marked
+// close-on-exec as they are now owned by this process.
+func newFiles(start, count int, names []string) []*os.File {
+	files := make([]*os.File, 0, count)
+	for ix := 0; ix < count; ix++ {
+		fd := start + ix
+		syscall.CloseOnExec(fd)
+		name := "LISTEN_FD_" + strconv.Itoa(fd)
+		if ix < len(names) && len(names[ix]) > 0 {
+			name = names[ix]
+		}
+		files = append(files, os.NewFile(uintptr(fd), name))
+	}
+
+	return files
+}
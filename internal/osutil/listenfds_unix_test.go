@@ -0,0 +1,73 @@
+//go:build unix || !windows
+// +build unix !windows
+
+package osutil
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// Fds 3 and up are most likely in use by the go runtime so the test dups a pipe to high fds which
+// are safe to adopt and close.
+func TestListenFDs(t *testing.T) {
+	const start = 100
+	pid := strconv.Itoa(os.Getpid())
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	for ix, f := range []*os.File{r, w} {
+		if err := unix.Dup2(int(f.Fd()), start+ix); err != nil {
+			t.Skip("Cannot Dup2 to test fds", err)
+		}
+	}
+
+	files, err := listenFDs(start) // Nothing set
+	if err != nil || files != nil {
+		t.Error("Expected nil, nil with no environment, not", files, err)
+	}
+
+	os.Setenv("LISTEN_PID", "1")
+	os.Setenv("LISTEN_FDS", "1")
+	files, err = listenFDs(start)
+	if err != nil || files != nil {
+		t.Error("Expected nil, nil when LISTEN_PID is for another process, not", files, err)
+	}
+	if len(os.Getenv("LISTEN_FDS")) > 0 {
+		t.Error("LISTEN_FDS should have been removed from the environment")
+	}
+
+	os.Setenv("LISTEN_PID", pid)
+	os.Setenv("LISTEN_FDS", "two")
+	_, err = listenFDs(start)
+	if err == nil || !strings.Contains(err.Error(), "Invalid LISTEN_FDS") {
+		t.Error("Expected Invalid LISTEN_FDS error, not", err)
+	}
+
+	os.Setenv("LISTEN_PID", pid)
+	os.Setenv("LISTEN_FDS", "2")
+	os.Setenv("LISTEN_FDNAMES", "dns-udp")
+	files, err = listenFDs(start)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if len(files) != 2 {
+		t.Fatal("Expected two files, not", len(files))
+	}
+	if files[0].Fd() != start || files[0].Name() != "dns-udp" {
+		t.Error("First file wrong", files[0].Fd(), files[0].Name())
+	}
+	if files[1].Fd() != start+1 || files[1].Name() != "LISTEN_FD_101" {
+		t.Error("Second file wrong", files[1].Fd(), files[1].Name())
+	}
+	for _, f := range files {
+		f.Close()
+	}
+}
@@ -0,0 +1,17 @@
+//go:build windows || !unix
+// +build windows !unix
+
+package osutil
+
+import (
+	"errors"
+	"os"
+)
+
+func ListenFDs() ([]*os.File, error) {
+	return nil, errors.New("osutil.ListenFDs: Socket activation is not supported on Windows")
+}
+
+func InheritedFiles(count int) []*os.File {
+	return nil
+}
@@ -0,0 +1,59 @@
+package osutil
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"time"
+)
+
+// WriteProfiles writes a goroutine dump and a heap profile of the running process into dir. It is
+// intended to be triggered by a signal so a stuck or leaking process can be diagnosed without
+// having been started with profiling options. The file names contain the program name, the time
+// and the pid so that successive dumps, or dumps from multiple processes, do not collide.
+//
+// The goroutine dump is in the same human-readable form as an unrecovered panic while the heap
+// profile is in pprof format for "go tool pprof".
+//
+// Return the paths of the files written.
+func WriteProfiles(dir, program string, now time.Time) ([]string, error) {
+	base := filepath.Join(dir, fmt.Sprintf("%s-%s-%d", program, now.UTC().Format("20060102T150405Z"),
+		os.Getpid()))
+
+	var paths []string
+	goroutinePath := base + ".goroutine"
+	err := writeProfile(goroutinePath, func(f *os.File) error {
+		return pprof.Lookup("goroutine").WriteTo(f, 2)
+	})
+	if err != nil {
+		return paths, err
+	}
+	paths = append(paths, goroutinePath)
+
+	heapPath := base + ".heap"
+	err = writeProfile(heapPath, func(f *os.File) error {
+		runtime.GC() // Bring the heap statistics up to date
+		return pprof.WriteHeapProfile(f)
+	})
+	if err != nil {
+		return paths, err
+	}
+	paths = append(paths, heapPath)
+
+	return paths, nil
+}
+
+func writeProfile(path string, write func(*os.File) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = write(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
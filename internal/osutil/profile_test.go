@@ -0,0 +1,39 @@
+package osutil
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteProfiles(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	paths, err := WriteProfiles(dir, "testprog", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 2 {
+		t.Fatal("Expected two profiles, got", paths)
+	}
+	for _, p := range paths {
+		if !strings.HasPrefix(filepath.Base(p), "testprog-20240102T030405Z-") {
+			t.Error("Unexpected profile name", p)
+		}
+		fi, err := os.Stat(p)
+		if err != nil || fi.Size() == 0 {
+			t.Error("Profile missing or empty", p, err)
+		}
+	}
+	b, _ := os.ReadFile(paths[0])
+	if !strings.Contains(string(b), "TestWriteProfiles") {
+		t.Error("Goroutine dump should contain the running test function")
+	}
+
+	_, err = WriteProfiles(filepath.Join(dir, "does-not-exist"), "testprog", now)
+	if err == nil {
+		t.Error("Expected an error writing to a non-existent directory")
+	}
+}
@@ -0,0 +1,36 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build aix darwin dragonfly freebsd linux netbsd openbsd
+
+package osutil
+
+import (
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// ListenConfig returns a net.ListenConfig which sets SO_REUSEPORT on each socket it creates if
+// reusePort is true. This allows multiple processes to share the same listen address with the
+// kernel distributing inbound connections and packets between them. An error is returned on
+// platforms which do not support SO_REUSEPORT.
+func ListenConfig(reusePort bool) (*net.ListenConfig, error) {
+	lc := &net.ListenConfig{}
+	if reusePort {
+		lc.Control = reusePortControl
+	}
+
+	return lc, nil
+}
+
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	var opErr error
+	err := c.Control(func(fd uintptr) {
+		opErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	})
+	if err != nil {
+		return err
+	}
+
+	return opErr
+}
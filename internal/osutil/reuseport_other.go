@@ -0,0 +1,18 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package osutil
+
+import (
+	"errors"
+	"net"
+	"runtime"
+)
+
+func ListenConfig(reusePort bool) (*net.ListenConfig, error) {
+	if reusePort {
+		return nil, errors.New("osutil.ListenConfig: SO_REUSEPORT is not supported on " + runtime.GOOS)
+	}
+
+	return &net.ListenConfig{}, nil
+}
@@ -0,0 +1,47 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build aix darwin dragonfly freebsd linux netbsd openbsd
+
+package osutil
+
+import (
+	"context"
+	"testing"
+)
+
+func TestListenConfig(t *testing.T) {
+	lc, err := ListenConfig(true)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	l1, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l1.Close()
+	l2, err := lc.Listen(context.Background(), "tcp", l1.Addr().String())
+	if err != nil {
+		t.Fatal("Second listen on same port should succeed with SO_REUSEPORT", err)
+	}
+	l2.Close()
+
+	pc1, err := lc.ListenPacket(context.Background(), "udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc1.Close()
+	pc2, err := lc.ListenPacket(context.Background(), "udp", pc1.LocalAddr().String())
+	if err != nil {
+		t.Fatal("Second UDP listen on same port should succeed with SO_REUSEPORT", err)
+	}
+	pc2.Close()
+
+	lc, err = ListenConfig(false)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	l3, err := lc.Listen(context.Background(), "tcp", l1.Addr().String())
+	if err == nil {
+		l3.Close()
+		t.Error("Listen without SO_REUSEPORT should fail on a port already in use")
+	}
+}
@@ -11,10 +11,20 @@ import (
 
 // SignalNotify sends all the main Unix signals to the supplied channel. A noop on Windows.
 func SignalNotify(c chan os.Signal) {
-	signal.Notify(c, syscall.SIGINT, syscall.SIGHUP, syscall.SIGTERM, syscall.SIGUSR1)
+	signal.Notify(c, syscall.SIGINT, syscall.SIGHUP, syscall.SIGTERM, syscall.SIGUSR1, syscall.SIGUSR2)
 }
 
 // IsSignalUSR1 returns true if the supplied signal is SIGUSR1. A noop on Windows.
 func IsSignalUSR1(s os.Signal) bool {
 	return s == syscall.SIGUSR1
 }
+
+// IsSignalUSR2 returns true if the supplied signal is SIGUSR2. A noop on Windows.
+func IsSignalUSR2(s os.Signal) bool {
+	return s == syscall.SIGUSR2
+}
+
+// IsSignalHUP returns true if the supplied signal is SIGHUP. A noop on Windows.
+func IsSignalHUP(s os.Signal) bool {
+	return s == syscall.SIGHUP
+}
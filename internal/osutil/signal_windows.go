@@ -13,3 +13,11 @@ func SignalNotify(c chan os.Signal) {
 func IsSignalUSR1(s os.Signal) bool {
 	return false
 }
+
+func IsSignalUSR2(s os.Signal) bool {
+	return false
+}
+
+func IsSignalHUP(s os.Signal) bool {
+	return false
+}
@@ -0,0 +1,47 @@
+package reporter
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+)
+
+// Counters is a snapshot of an array of counters indexed by a set of iota constants, such as event
+// or failure counters, along with the name of each index. Report() normally prints the Values in
+// index order whereas JSON reports are keyed by name so that machine consumers are not dependent on
+// the order of the constants.
+type Counters struct {
+	Names  []string
+	Values []int
+}
+
+// NewCounters returns a Counters containing a copy of values. names and values must be the same
+// length.
+func NewCounters(names []string, values []int) Counters {
+	if len(names) != len(values) {
+		panic("reporter.NewCounters: names and values differ in length")
+	}
+
+	return Counters{Names: names, Values: append([]int{}, values...)}
+}
+
+// MarshalJSON implements json.Marshaler. The object members are in index order.
+func (t Counters) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for ix, name := range t.Names {
+		if ix > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(name)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.WriteString(strconv.Itoa(t.Values[ix]))
+	}
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
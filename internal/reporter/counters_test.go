@@ -0,0 +1,34 @@
+package reporter
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCounters(t *testing.T) {
+	vals := []int{3, 0, 7}
+	c := NewCounters([]string{"timeout", "refused", "other"}, vals)
+	vals[0] = 99 // Must be a copy
+	b, err := json.Marshal(struct {
+		Failures Counters `json:"failures"`
+	}{c})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := `{"failures":{"timeout":3,"refused":0,"other":7}}`
+	if string(b) != exp {
+		t.Error("Wrong JSON. \nGot:", string(b), "\nExp:", exp)
+	}
+
+	b, err = json.Marshal(Counters{})
+	if err != nil || string(b) != "{}" {
+		t.Error("Empty Counters should be an empty object, not", string(b), err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Mismatched lengths should panic")
+		}
+	}()
+	NewCounters([]string{"one"}, []int{1, 2})
+}
@@ -0,0 +1,71 @@
+package reporter
+
+import (
+	"encoding/json"
+	"expvar"
+	"strings"
+	"sync"
+)
+
+var (
+	publishMu sync.Mutex
+	published = make(map[string]*publishedReporters)
+)
+
+type publishedReporters struct {
+	mu        sync.Mutex
+	reporters []Reporter
+}
+
+// publishedReport is the expvar representation of a single Reporter. Reporters are listed in
+// order rather than keyed by name as multiple Reporters may share the same name.
+type publishedReport struct {
+	Name   string          `json:"name"`
+	Report json.RawMessage `json:"report,omitempty"`
+	Text   string          `json:"text,omitempty"`
+}
+
+// Publish makes the reporters visible as the expvar called name, which in turn makes them visible
+// via the expvar handler at /debug/vars. Each read of the expvar produces a fresh report from each
+// reporter without resetting counters. MetricsReporters contribute their JSON report, all others
+// their text report.
+//
+// Unlike expvar.Publish, calling Publish again with the same name is not an error; the new
+// reporters replace the old ones. This allows a program to republish whenever its list of
+// reporters changes.
+func Publish(name string, reporters []Reporter) {
+	publishMu.Lock()
+	defer publishMu.Unlock()
+
+	pr, ok := published[name]
+	if !ok {
+		pr = &publishedReporters{}
+		published[name] = pr
+		expvar.Publish(name, expvar.Func(pr.snapshot))
+	}
+	pr.mu.Lock()
+	pr.reporters = append([]Reporter{}, reporters...)
+	pr.mu.Unlock()
+}
+
+func (t *publishedReporters) snapshot() interface{} {
+	t.mu.Lock()
+	reporters := t.reporters
+	t.mu.Unlock()
+
+	reports := make([]publishedReport, 0, len(reporters))
+	for _, r := range reporters {
+		pr := publishedReport{Name: r.Name()}
+		if mr, ok := r.(MetricsReporter); ok {
+			if b, err := mr.ReportJSON(false); err == nil {
+				pr.Report = b
+			}
+		}
+		if pr.Report == nil {
+			pr.Text = strings.TrimSpace(r.Report(false))
+		}
+		reports = append(reports, pr)
+	}
+
+	return reports
+}
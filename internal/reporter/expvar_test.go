@@ -0,0 +1,47 @@
+package reporter
+
+import (
+	"expvar"
+	"strings"
+	"testing"
+)
+
+type textReporter struct{ name, text string }
+
+func (t *textReporter) Name() string                     { return t.name }
+func (t *textReporter) Report(resetCounters bool) string { return t.text + "\n" }
+
+type jsonReporter struct {
+	textReporter
+	resets int
+}
+
+func (t *jsonReporter) ReportJSON(resetCounters bool) ([]byte, error) {
+	if resetCounters {
+		t.resets++
+	}
+	return []byte(`{"req":1}`), nil
+}
+
+func TestPublish(t *testing.T) {
+	jr := &jsonReporter{textReporter: textReporter{"Listener", "req=1"}}
+	Publish("testPublish", []Reporter{&textReporter{"Cache", "hits=2"}, jr})
+	v := expvar.Get("testPublish")
+	if v == nil {
+		t.Fatal("Publish did not create expvar")
+	}
+	got := v.String()
+	exp := `[{"name":"Cache","text":"hits=2"},{"name":"Listener","report":{"req":1}}]`
+	if got != exp {
+		t.Error("Wrong expvar. \nGot:", got, "\nExp:", exp)
+	}
+	if jr.resets != 0 {
+		t.Error("Reading the expvar should not reset counters")
+	}
+
+	Publish("testPublish", []Reporter{&textReporter{"Other", "x"}}) // Replace rather than panic
+	got = expvar.Get("testPublish").String()
+	if !strings.Contains(got, "Other") || strings.Contains(got, "Cache") {
+		t.Error("Republish did not replace reporters", got)
+	}
+}
@@ -0,0 +1,19 @@
+package reporter
+
+import (
+	"fmt"
+
+	"github.com/markdingo/trustydns/internal/latencyhistogram"
+)
+
+// Percentiles returns the p50/p90/p99 latencies of h in seconds. This is the latency summary used by
+// the resolver reports.
+func Percentiles(h *latencyhistogram.Histogram) []float64 {
+	return []float64{h.Percentile(50).Seconds(), h.Percentile(90).Seconds(), h.Percentile(99).Seconds()}
+}
+
+// FormatPercentiles returns the latencies returned by Percentiles() in the %0.3f/%0.3f/%0.3f form
+// used by text reports.
+func FormatPercentiles(lat []float64) string {
+	return fmt.Sprintf("%0.3f/%0.3f/%0.3f", lat[0], lat[1], lat[2])
+}
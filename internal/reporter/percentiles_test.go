@@ -0,0 +1,25 @@
+package reporter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/markdingo/trustydns/internal/latencyhistogram"
+)
+
+func TestPercentiles(t *testing.T) {
+	var h latencyhistogram.Histogram
+	if got := FormatPercentiles(Percentiles(&h)); got != "0.000/0.000/0.000" {
+		t.Error("Empty histogram should format as zeroes, not", got)
+	}
+
+	for ix := 0; ix < 90; ix++ {
+		h.Add(3 * time.Millisecond)
+	}
+	for ix := 0; ix < 10; ix++ {
+		h.Add(150 * time.Millisecond)
+	}
+	if got := FormatPercentiles(Percentiles(&h)); got != "0.005/0.005/0.150" {
+		t.Error("Wrong percentiles", got)
+	}
+}
@@ -24,3 +24,13 @@ type Reporter interface {
 	// Report() may be called by multiple go-routines - albeit unlikely.
 	Report(resetCounters bool) string
 }
+
+// MetricsReporter is implemented by Reporters which can also produce their report as JSON for
+// machine consumption. The counters and the reset semantics are identical to those of Report().
+type MetricsReporter interface {
+	Reporter
+
+	// ReportJSON returns the same counters as Report() as a single JSON object with no trailing
+	// newline.
+	ReportJSON(resetCounters bool) ([]byte, error)
+}
@@ -0,0 +1,519 @@
+package cache
+
+import (
+	"container/heap"
+	"container/list"
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/markdingo/trustydns/internal/dnsutil"
+	"github.com/markdingo/trustydns/internal/resolver"
+
+	"github.com/miekg/dns"
+)
+
+// Cache holds DNS responses on behalf of one or more wrapped resolvers. A single Cache can Wrap()
+// multiple resolvers so that they share the one pool of responses and the one set of statistics.
+//
+// Positive responses are cached for the smallest Answer or Authority TTL. Negative responses
+// (NXDOMAIN and NODATA) are cached for the lesser of the SOA TTL and SOA MINIMUM as per rfc2308
+// Section 5 and are not cached at all if there is no SOA. Config.MaxNegativeTTL, if set, further
+// caps negative responses so that a newly created name is not hidden for long. Any upstream
+// Cache-Control max-age further limits the cache period and no-cache prevents caching. All other
+// responses, including truncated ones, are never cached.
+//
+// An upstream Cache-Control stale-while-revalidate extends the period an entry is retained beyond
+// its expiry. During that period the stale response is returned with zero TTLs while the first
+// query to find it stale refreshes the entry in the background (rfc5861 Section 3).
+//
+// If Config.MaxMemory is set the total size of all entries is kept within that budget by evicting
+// the least recently used entries. The size of an entry is estimated from the packed length of the
+// response as that is a reasonable proxy for the memory consumed by the unpacked dns.Msg.
+//
+// Entries are also held in a recently used list and in a heap ordered by when they can no longer be
+// served so that eviction never has to scan all entries.
+type Cache struct {
+	config Config
+
+	mu      sync.Mutex        // Protects everything below here
+	entries map[string]*entry // Indexed by Key()
+	scopes  map[string][]int  // ECS scopes stored per question - indexed by non-ECS Key()
+	lru     *list.List        // Of *entry with the most recently used at the front
+	expiry  expiryHeap        // Of *entry with the earliest stale time at the top
+	memory  int               // Sum of entry sizes
+	stats
+}
+
+type entry struct {
+	key          string   // Index into Cache.entries
+	qName        string   // Canonical form for FlushName()
+	resp         *dns.Msg // Never handed out - only copies
+	respMeta     resolver.ResponseMetaData
+	stored       time.Time
+	expires      time.Time
+	stale        time.Time // Served while refreshed until then. Same as expires if there is no stale period
+	refreshAfter time.Time // No background refresh of the stale entry is started before then
+	size         int       // Estimated memory used which is taken to be the packed length
+	hits         int
+
+	lruElement *list.Element // Position in Cache.lru
+	heapIndex  int           // Position in Cache.expiry
+}
+
+// EntryInfo describes a cached response as returned by Entries()
+type EntryInfo struct {
+	Key    string `json:"key"`
+	TTL    int    `json:"ttl"` // Remaining seconds
+	Rcode  string `json:"rcode"`
+	Answer string `json:"answer"` // In dnsutil.CompactRRsString() form
+	Hits   int    `json:"hits"`
+}
+
+type stats struct {
+	hits    int
+	misses  int
+	evicted int
+}
+
+// New constructs an empty Cache.
+func New(config Config) *Cache {
+	if config.MaxEntries <= 0 {
+		config.MaxEntries = DefaultMaxEntries
+	}
+	if config.MaxTTL <= 0 {
+		config.MaxTTL = DefaultMaxTTL
+	}
+	if config.NowFunc == nil {
+		config.NowFunc = time.Now
+	}
+
+	return &Cache{config: config, entries: make(map[string]*entry), scopes: make(map[string][]int),
+		lru: list.New()}
+}
+
+// Wrap returns a resolver.Resolver which consults the cache prior to passing queries on to child
+// and which caches child's responses.
+func (t *Cache) Wrap(child resolver.Resolver) resolver.Resolver {
+	return &wrapper{cache: t, child: child}
+}
+
+type wrapper struct {
+	cache      *Cache
+	child      resolver.Resolver
+	refreshing sync.WaitGroup // Background refreshes in progress - mainly for tests
+}
+
+const refreshTimeout = 10 * time.Second // Upper bound on a background refresh
+
+// InBailiwick defers to the wrapped resolver.
+func (t *wrapper) InBailiwick(qName string) bool {
+	return t.child.InBailiwick(qName)
+}
+
+// Resolve is ResolveContext without any deadline.
+func (t *wrapper) Resolve(q *dns.Msg, qMeta *resolver.QueryMetaData) (*dns.Msg, *resolver.ResponseMetaData, error) {
+	return t.ResolveContext(context.Background(), q, qMeta)
+}
+
+// ResolveContext returns a cached response if possible, otherwise a copy of the query is passed to
+// the wrapped resolver along with ctx. A copy is needed as resolvers such as doh modify the query
+// (ECS, EDNS0 filtering and Id) which would otherwise change the key the response is stored
+// under. Callers are free to modify the returned response as it is never shared with the cache.
+func (t *wrapper) ResolveContext(ctx context.Context, q *dns.Msg, qMeta *resolver.QueryMetaData) (*dns.Msg, *resolver.ResponseMetaData, error) {
+	if resp, respMeta, refresh := t.cache.get(q); resp != nil {
+		if refresh {
+			t.refreshing.Add(1)
+			go t.refresh(q.Copy(), qMeta)
+		}
+		return resp, respMeta, nil
+	}
+	resp, respMeta, err := resolver.ResolveContext(ctx, t.child, q.Copy(), qMeta)
+	if err == nil {
+		t.cache.put(q, resp, respMeta)
+	}
+
+	return resp, respMeta, err
+}
+
+// refresh re-resolves a stale entry in the background. On failure the stale entry continues to be
+// served and get() requests another refresh once refreshTimeout has passed.
+func (t *wrapper) refresh(q *dns.Msg, qMeta *resolver.QueryMetaData) {
+	defer t.refreshing.Done()
+	ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
+	defer cancel()
+	resp, respMeta, err := resolver.ResolveContext(ctx, t.child, q.Copy(), qMeta)
+	if err == nil {
+		t.cache.put(q, resp, respMeta)
+	}
+}
+
+// queryECS returns the ECS option in the query, if any.
+func queryECS(q *dns.Msg) *dns.EDNS0_SUBNET {
+	opt := q.IsEdns0()
+	if opt == nil {
+		return nil
+	}
+	for _, o := range opt.Option {
+		if ecs, ok := o.(*dns.EDNS0_SUBNET); ok {
+			return ecs
+		}
+	}
+
+	return nil
+}
+
+// cacheable returns true if the query is one that can be satisfied from the cache. DNSSEC-related
+// queries are excluded as the DO and CD bits change the content of the response.
+func cacheable(q *dns.Msg) bool {
+	if len(q.Question) != 1 || q.CheckingDisabled {
+		return false
+	}
+	if opt := q.IsEdns0(); opt != nil && opt.Do() {
+		return false
+	}
+
+	return true
+}
+
+// scopedKey returns the Key() for the query at the given response scope. The query ECS address is
+// used so that the key describes the subnet the query came from.
+func scopedKey(q dns.Question, ecs *dns.EDNS0_SUBNET, scope int) string {
+	if ecs == nil || scope == 0 {
+		return Key(q, nil)
+	}
+	scoped := *ecs
+	scoped.SourceScope = uint8(scope)
+
+	return Key(q, &scoped)
+}
+
+// get returns a copy of the cached response to the query with the Id and TTLs adjusted, or nil if
+// there is no unexpired or stale response. refresh is returned true for the first get() of a stale
+// response, and again every refreshTimeout in case the refresh failed, so that the caller can start
+// a background refresh. The scopes previously stored for the question are probed from narrowest to
+// widest so that the most specific response is preferred. A scope wider than the query's source
+// prefix can never match as the response would be for clients outside the subnet the query
+// represents.
+func (t *Cache) get(q *dns.Msg) (resp *dns.Msg, respMeta *resolver.ResponseMetaData, refresh bool) {
+	if !cacheable(q) {
+		return nil, nil, false
+	}
+	now := t.config.NowFunc()
+	ecs := queryECS(q)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var ent *entry
+	if ecs != nil {
+		scopes := t.scopes[Key(q.Question[0], nil)]
+		for ix := len(scopes) - 1; ix >= 0 && ent == nil; ix-- {
+			if scopes[ix] <= int(ecs.SourceNetmask) {
+				ent = t.entries[scopedKey(q.Question[0], ecs, scopes[ix])]
+			}
+		}
+	} else {
+		ent = t.entries[Key(q.Question[0], nil)]
+	}
+	if ent == nil || !now.Before(ent.stale) {
+		t.misses++
+		return nil, nil, false
+	}
+	t.hits++
+	ent.hits++
+	t.lru.MoveToFront(ent.lruElement)
+	if !now.Before(ent.expires) && !now.Before(ent.refreshAfter) {
+		ent.refreshAfter = now.Add(refreshTimeout)
+		refresh = true
+	}
+
+	resp = ent.resp.Copy()
+	resp.Id = q.Id
+	ageTTLs(resp, uint32(now.Sub(ent.stored)/time.Second), remainingTTL(ent, now))
+
+	rm := ent.respMeta // Report the origin of the response but none of the effort
+	rm.TransportDuration = 0
+	rm.ResolutionDuration = 0
+	rm.PayloadSize = resp.Len()
+	rm.QueryTries = 0
+	rm.ServerTries = 0
+	rm.CacheControl = nil
+	rm.RawResponse = nil
+
+	return resp, &rm, refresh
+}
+
+// remainingTTL returns the whole seconds until the entry expires, or zero if it has expired. Stale
+// responses are thus always returned with zero TTLs.
+func remainingTTL(ent *entry, now time.Time) uint32 {
+	if !now.Before(ent.expires) {
+		return 0
+	}
+
+	return uint32(ent.expires.Sub(now) / time.Second)
+}
+
+// ageTTLs reduces all TTLs in the response by age and caps them at remaining, the time left before
+// the cache entry expires. The cap ensures that downstream caches honor the same limits as we do,
+// such as Config.MaxTTL, Config.MaxNegativeTTL and any upstream Cache-Control max-age. The SOA
+// MINIMUM is capped as well as it limits negative caching downstream (rfc2308 Section 5).
+func ageTTLs(resp *dns.Msg, age, remaining uint32) {
+	for _, section := range [][]dns.RR{resp.Answer, resp.Ns, resp.Extra} {
+		for _, rr := range section {
+			if rr.Header().Rrtype == dns.TypeOPT {
+				continue
+			}
+			if rr.Header().Ttl > age {
+				rr.Header().Ttl -= age
+			} else {
+				rr.Header().Ttl = 0
+			}
+			if rr.Header().Ttl > remaining {
+				rr.Header().Ttl = remaining
+			}
+			if soa, ok := rr.(*dns.SOA); ok && soa.Minttl > remaining {
+				soa.Minttl = remaining
+			}
+		}
+	}
+}
+
+// put stores a copy of the response if it is cacheable.
+func (t *Cache) put(q *dns.Msg, resp *dns.Msg, respMeta *resolver.ResponseMetaData) {
+	if !cacheable(q) || resp == nil || resp.Truncated {
+		return
+	}
+	ttl := t.ttl(resp)
+	var staleTTL time.Duration
+	if respMeta != nil && respMeta.CacheControl != nil {
+		cc := respMeta.CacheControl
+		if cc.NoCache {
+			return
+		}
+		if cc.HasMaxAge && cc.MaxAge < ttl {
+			ttl = cc.MaxAge
+		}
+		staleTTL = cc.StaleWhileRevalidate
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	// The response scope determines which clients the response applies to. It cannot be any
+	// wider than the subnet described by the query.
+
+	scope := 0
+	ecs := queryECS(q)
+	if ecs != nil {
+		if respECS := queryECS(resp); respECS != nil {
+			scope = int(respECS.SourceScope)
+		}
+		if scope > int(ecs.SourceNetmask) {
+			scope = int(ecs.SourceNetmask)
+		}
+	}
+
+	size := resp.Len()
+	if t.config.MaxMemory > 0 && size > t.config.MaxMemory {
+		return // Could never fit
+	}
+
+	now := t.config.NowFunc()
+	key := scopedKey(q.Question[0], ecs, scope)
+	baseKey := Key(q.Question[0], nil)
+	ent := &entry{key: key, qName: dns.CanonicalName(q.Question[0].Name), resp: resp.Copy(), stored: now,
+		expires: now.Add(ttl), stale: now.Add(ttl + staleTTL), size: size}
+	if respMeta != nil {
+		ent.respMeta = *respMeta
+		ent.respMeta.RawResponse = nil // Never returned by get() so don't hold the memory
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if old, ok := t.entries[key]; ok {
+		t.remove(old)
+	} else if len(t.entries) >= t.config.MaxEntries {
+		t.evict(now)
+	}
+	t.entries[key] = ent
+	ent.lruElement = t.lru.PushFront(ent)
+	heap.Push(&t.expiry, ent)
+	t.memory += size
+	t.addScope(baseKey, scope)
+	if t.config.MaxMemory > 0 && t.memory > t.config.MaxMemory {
+		t.evictLRU(now)
+	}
+}
+
+// remove deletes the entry and its contribution to memory. Caller must hold the lock.
+func (t *Cache) remove(ent *entry) {
+	delete(t.entries, ent.key)
+	t.lru.Remove(ent.lruElement)
+	heap.Remove(&t.expiry, ent.heapIndex)
+	t.memory -= ent.size
+}
+
+// Flush removes all entries from the cache and returns the number removed. Statistics are left
+// alone.
+func (t *Cache) Flush() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n := len(t.entries)
+	t.entries = make(map[string]*entry)
+	t.scopes = make(map[string][]int)
+	t.lru.Init()
+	t.expiry = nil
+	t.memory = 0
+
+	return n
+}
+
+// FlushName removes all entries for qName regardless of qtype, qclass or ECS scope and returns the
+// number removed. As with evict() any scopes for qName are left alone.
+func (t *Cache) FlushName(qName string) int {
+	qName = dns.CanonicalName(qName)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n := 0
+	for _, ent := range t.entries {
+		if ent.qName == qName {
+			t.remove(ent)
+			n++
+		}
+	}
+
+	return n
+}
+
+// Entries returns a snapshot of all unexpired entries in Key order. It is intended for debugging so
+// no great effort is made to minimize the time the cache is locked.
+func (t *Cache) Entries() []EntryInfo {
+	now := t.config.NowFunc()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	infos := make([]EntryInfo, 0, len(t.entries))
+	for key, ent := range t.entries {
+		if !now.Before(ent.expires) {
+			continue
+		}
+		infos = append(infos, EntryInfo{Key: key, TTL: int(ent.expires.Sub(now) / time.Second),
+			Rcode: dns.RcodeToString[ent.resp.Rcode], Answer: dnsutil.CompactRRsString(ent.resp.Answer),
+			Hits: ent.hits})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Key < infos[j].Key })
+
+	return infos
+}
+
+// addScope records scope against the question in ascending order so that get() can probe
+// narrowest first by walking backwards. Caller must hold the lock.
+func (t *Cache) addScope(baseKey string, scope int) {
+	scopes := t.scopes[baseKey]
+	ix := 0
+	for ; ix < len(scopes); ix++ {
+		if scopes[ix] == scope {
+			return
+		}
+		if scopes[ix] > scope {
+			break
+		}
+	}
+	scopes = append(scopes, 0)
+	copy(scopes[ix+1:], scopes[ix:])
+	scopes[ix] = scope
+	t.scopes[baseKey] = scopes
+}
+
+// evict makes room for one more entry by removing all expired entries or, if there are none, the
+// least recently used entry. Caller must hold the lock. The scopes map is left alone as a stale
+// scope merely causes a harmless probe miss.
+func (t *Cache) evict(now time.Time) {
+	t.evictExpired(now)
+	if len(t.entries) < t.config.MaxEntries {
+		return
+	}
+	if back := t.lru.Back(); back != nil {
+		t.remove(back.Value.(*entry))
+		t.evicted++
+	}
+}
+
+// evictLRU brings memory within MaxMemory by removing all expired entries then as many of the
+// least recently used entries as needed. The most recently used entry, normally the one just
+// added, is never removed. Caller must hold the lock.
+func (t *Cache) evictLRU(now time.Time) {
+	t.evictExpired(now)
+	for t.memory > t.config.MaxMemory {
+		back := t.lru.Back()
+		if back == nil || back == t.lru.Front() {
+			return
+		}
+		t.remove(back.Value.(*entry))
+		t.evicted++
+	}
+}
+
+// evictExpired removes all expired entries which are beyond their stale period. Caller must hold the
+// lock.
+func (t *Cache) evictExpired(now time.Time) {
+	for len(t.expiry) > 0 && !now.Before(t.expiry[0].stale) {
+		t.remove(t.expiry[0])
+		t.evicted++
+	}
+}
+
+// expiryHeap implements heap.Interface ordered by entry.stale
+type expiryHeap []*entry
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].stale.Before(h[j].stale) }
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].heapIndex = i
+	h[j].heapIndex = j
+}
+
+func (h *expiryHeap) Push(x interface{}) {
+	ent := x.(*entry)
+	ent.heapIndex = len(*h)
+	*h = append(*h, ent)
+}
+
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	ent := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+
+	return ent
+}
+
+// ttl returns how long the response can be cached for based on its contents, or zero if it should
+// not be cached.
+func (t *Cache) ttl(resp *dns.Msg) time.Duration {
+	if resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError {
+		return 0
+	}
+	min, ok := dnsutil.MinTTL(resp)
+	if !ok {
+		return 0
+	}
+
+	ttl := time.Duration(min) * time.Second
+	if ttl > t.config.MaxTTL {
+		ttl = t.config.MaxTTL
+	}
+	if t.config.MaxNegativeTTL > 0 && ttl > t.config.MaxNegativeTTL && dnsutil.IsNegative(resp) {
+		ttl = t.config.MaxNegativeTTL
+	}
+
+	return ttl
+}
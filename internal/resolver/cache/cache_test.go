@@ -0,0 +1,555 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/markdingo/trustydns/internal/resolver"
+
+	"github.com/miekg/dns"
+)
+
+// mockResolver returns a canned response, or err if set, and counts calls
+type mockResolver struct {
+	resp     *dns.Msg
+	respMeta *resolver.ResponseMetaData
+	err      error
+	calls    int
+}
+
+func (t *mockResolver) InBailiwick(qName string) bool {
+	return qName == "example.net."
+}
+
+func (t *mockResolver) Resolve(q *dns.Msg, qMeta *resolver.QueryMetaData) (*dns.Msg, *resolver.ResponseMetaData, error) {
+	t.calls++
+	if t.err != nil {
+		return nil, nil, t.err
+	}
+	resp := t.resp.Copy()
+	resp.Id = q.Id
+	respMeta := &resolver.ResponseMetaData{FinalServerUsed: "mock", QueryTries: 1, ServerTries: 1}
+	if t.respMeta != nil {
+		respMeta = t.respMeta
+	}
+
+	return resp, respMeta, nil
+}
+
+type clock struct {
+	now time.Time
+}
+
+func (t *clock) Now() time.Time {
+	return t.now
+}
+
+func newQuery(qName string) *dns.Msg {
+	q := &dns.Msg{}
+	q.SetQuestion(qName, dns.TypeA)
+
+	return q
+}
+
+func newAnswer(qName string, ttl uint32) *dns.Msg {
+	resp := &dns.Msg{}
+	resp.SetReply(newQuery(qName))
+	rr, _ := dns.NewRR(qName + " 0 IN A 192.0.2.1")
+	rr.Header().Ttl = ttl
+	resp.Answer = append(resp.Answer, rr)
+
+	return resp
+}
+
+func newNegative(qName string, rcode int, ttl, minttl uint32) *dns.Msg {
+	resp := &dns.Msg{}
+	resp.SetRcode(newQuery(qName), rcode)
+	resp.Ns = append(resp.Ns, &dns.SOA{Hdr: dns.RR_Header{Name: "net.", Rrtype: dns.TypeSOA,
+		Class: dns.ClassINET, Ttl: ttl}, Ns: "a.net.", Mbox: "b.net.", Minttl: minttl})
+
+	return resp
+}
+
+func TestHitAndExpire(t *testing.T) {
+	clk := &clock{now: time.Now()}
+	c := New(Config{NowFunc: clk.Now})
+	mr := &mockResolver{resp: newAnswer("example.net.", 60)}
+	r := c.Wrap(mr)
+	if !r.InBailiwick("example.net.") || r.InBailiwick("example.org.") {
+		t.Error("InBailiwick not passed thru to wrapped resolver")
+	}
+
+	q := newQuery("example.net.")
+	r.Resolve(q, nil)
+	q.Id++
+	clk.now = clk.now.Add(time.Second * 10)
+	resp, respMeta, err := r.Resolve(q, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mr.calls != 1 {
+		t.Error("Expected second query to be answered from cache", mr.calls)
+	}
+	if resp.Id != q.Id {
+		t.Error("Cached response Id not set to query Id", resp.Id, q.Id)
+	}
+	if resp.Answer[0].Header().Ttl != 50 {
+		t.Error("Expected TTL to be decremented to 50, not", resp.Answer[0].Header().Ttl)
+	}
+	if respMeta.FinalServerUsed != "mock" || respMeta.QueryTries != 0 {
+		t.Error("Unexpected response meta data from cache hit", respMeta)
+	}
+
+	resp.Answer[0].Header().Ttl = 1234 // Caller modifications must not affect the cache
+	resp, _, _ = r.Resolve(q, nil)
+	if resp.Answer[0].Header().Ttl != 50 {
+		t.Error("Cache entry was modified via a returned response", resp.Answer[0].Header().Ttl)
+	}
+
+	clk.now = clk.now.Add(time.Second * 50)
+	r.Resolve(q, nil)
+	if mr.calls != 2 {
+		t.Error("Expected expired entry to be resolved again", mr.calls)
+	}
+
+	size := mr.resp.Len()
+	if rep := c.Report(true); rep != fmt.Sprintf("entries=1 memory=%d hits=2 misses=2 evicted=0", size) {
+		t.Error("Unexpected report", rep)
+	}
+	if rep := c.Report(false); rep != fmt.Sprintf("entries=1 memory=%d hits=0 misses=0 evicted=0", size) {
+		t.Error("resetCounters did not reset", rep)
+	}
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	clk := &clock{now: time.Now()}
+	c := New(Config{NowFunc: clk.Now})
+	mr := &mockResolver{resp: newAnswer("example.net.", 60), respMeta: &resolver.ResponseMetaData{
+		CacheControl: &resolver.CacheControl{StaleWhileRevalidate: 30 * time.Second}}}
+	r := c.Wrap(mr)
+	q := newQuery("example.net.")
+	r.Resolve(q, nil)
+
+	// Stale responses are served with zero TTLs and only the first triggers a refresh
+
+	clk.now = clk.now.Add(70 * time.Second)
+	resp, _, refresh := c.get(q)
+	if resp == nil || !refresh || resp.Answer[0].Header().Ttl != 0 {
+		t.Fatal("Expected stale response with zero TTL and a refresh", resp, refresh)
+	}
+	if resp, _, refresh = c.get(q); resp == nil || refresh {
+		t.Error("Expected stale response without a second refresh", resp, refresh)
+	}
+
+	// The refresh via the wrapper replaces the stale entry
+
+	c.Flush()
+	r.Resolve(q, nil) // Re-prime at the current time
+	clk.now = clk.now.Add(70 * time.Second)
+	resp, _, _ = r.Resolve(q, nil)
+	r.(*wrapper).refreshing.Wait()
+	if resp == nil || mr.calls != 3 {
+		t.Fatal("Expected stale response and a background refresh", resp, mr.calls)
+	}
+	if resp, _, refresh = c.get(q); resp == nil || refresh || resp.Answer[0].Header().Ttl != 60 {
+		t.Error("Expected refreshed entry", resp, refresh)
+	}
+
+	// Beyond the stale period is a miss
+
+	clk.now = clk.now.Add(91 * time.Second)
+	r.Resolve(q, nil)
+	if mr.calls != 4 {
+		t.Error("Expected entry beyond stale period to be resolved again", mr.calls)
+	}
+}
+
+func TestFailedRefresh(t *testing.T) {
+	clk := &clock{now: time.Now()}
+	c := New(Config{NowFunc: clk.Now})
+	mr := &mockResolver{resp: newAnswer("example.net.", 60), respMeta: &resolver.ResponseMetaData{
+		CacheControl: &resolver.CacheControl{StaleWhileRevalidate: time.Minute}}}
+	r := c.Wrap(mr)
+	q := newQuery("example.net.")
+	r.Resolve(q, nil)
+
+	// The stale entry continues to be served after the refresh fails
+
+	mr.err = errors.New("mock failure")
+	clk.now = clk.now.Add(70 * time.Second)
+	resp, _, _ := r.Resolve(q, nil)
+	r.(*wrapper).refreshing.Wait()
+	if resp == nil || mr.calls != 2 {
+		t.Fatal("Expected stale response and a background refresh", resp, mr.calls)
+	}
+	r.Resolve(q, nil)
+	r.(*wrapper).refreshing.Wait()
+	if mr.calls != 2 {
+		t.Error("Did not expect another refresh within refreshTimeout", mr.calls)
+	}
+
+	// Once refreshTimeout has passed another refresh is attempted
+
+	mr.err = nil
+	clk.now = clk.now.Add(refreshTimeout)
+	r.Resolve(q, nil)
+	r.(*wrapper).refreshing.Wait()
+	if mr.calls != 3 {
+		t.Fatal("Expected failed refresh to be retried", mr.calls)
+	}
+	if resp, _, refresh := c.get(q); resp == nil || refresh || resp.Answer[0].Header().Ttl != 60 {
+		t.Error("Expected refreshed entry", resp, refresh)
+	}
+}
+
+func TestTTL(t *testing.T) {
+	serverFailure := &dns.Msg{}
+	serverFailure.SetRcode(newQuery("example.net."), dns.RcodeServerFailure)
+	truncated := newAnswer("example.net.", 60)
+	truncated.Truncated = true
+
+	testCases := []struct {
+		resp     *dns.Msg
+		respMeta *resolver.ResponseMetaData
+		cached   bool
+	}{
+		{newAnswer("example.net.", 60), nil, true},
+		{newAnswer("example.net.", 0), nil, false},
+		{newNegative("example.net.", dns.RcodeNameError, 60, 30), nil, true},
+		{newNegative("example.net.", dns.RcodeSuccess, 60, 30), nil, true}, // NODATA
+		{newNegative("example.net.", dns.RcodeNameError, 60, 0), nil, false},
+		{serverFailure, nil, false},
+		{truncated, nil, false},
+		{newAnswer("example.net.", 60),
+			&resolver.ResponseMetaData{CacheControl: &resolver.CacheControl{NoCache: true}}, false},
+		{newAnswer("example.net.", 60),
+			&resolver.ResponseMetaData{CacheControl: &resolver.CacheControl{HasMaxAge: true}}, false},
+	}
+
+	for ix, tc := range testCases {
+		mr := &mockResolver{resp: tc.resp, respMeta: tc.respMeta}
+		r := New(Config{}).Wrap(mr)
+		r.Resolve(newQuery("example.net."), nil)
+		r.Resolve(newQuery("example.net."), nil)
+		if tc.cached != (mr.calls == 1) {
+			t.Error(ix, "Expected cached", tc.cached, "but resolver called", mr.calls)
+		}
+	}
+
+	// Negative responses use the lesser of the SOA TTL and MINIMUM and MaxTTL caps everything
+
+	c := New(Config{MaxTTL: time.Second * 20})
+	if ttl := c.ttl(newNegative("example.net.", dns.RcodeNameError, 60, 30)); ttl != time.Second*20 {
+		t.Error("Expected MaxTTL to cap TTL, got", ttl)
+	}
+	c = New(Config{})
+	if ttl := c.ttl(newNegative("example.net.", dns.RcodeNameError, 60, 30)); ttl != time.Second*30 {
+		t.Error("Expected SOA MINIMUM TTL, got", ttl)
+	}
+
+	// MaxNegativeTTL only caps negative responses
+
+	c = New(Config{MaxNegativeTTL: time.Second * 5})
+	if ttl := c.ttl(newNegative("example.net.", dns.RcodeNameError, 60, 30)); ttl != time.Second*5 {
+		t.Error("Expected MaxNegativeTTL to cap NXDOMAIN TTL, got", ttl)
+	}
+	if ttl := c.ttl(newNegative("example.net.", dns.RcodeSuccess, 60, 30)); ttl != time.Second*5 {
+		t.Error("Expected MaxNegativeTTL to cap NODATA TTL, got", ttl)
+	}
+	if ttl := c.ttl(newNegative("example.net.", dns.RcodeNameError, 60, 3)); ttl != time.Second*3 {
+		t.Error("Expected SOA MINIMUM below MaxNegativeTTL to apply, got", ttl)
+	}
+	if ttl := c.ttl(newAnswer("example.net.", 60)); ttl != time.Second*60 {
+		t.Error("Expected MaxNegativeTTL to leave positive responses alone, got", ttl)
+	}
+}
+
+// Returned TTLs should never exceed the time the entry remains cached so that downstream caches are
+// bound by the same limits.
+func TestReturnedTTLCapped(t *testing.T) {
+	clk := &clock{now: time.Now()}
+	c := New(Config{NowFunc: clk.Now, MaxTTL: time.Hour, MaxNegativeTTL: 5 * time.Minute})
+	q := newQuery("example.net.")
+
+	testCases := []struct {
+		resp     *dns.Msg
+		respMeta *resolver.ResponseMetaData
+		expect   uint32 // After 10s
+	}{
+		{newAnswer("example.net.", 86400), nil, 3590},                             // MaxTTL
+		{newNegative("example.net.", dns.RcodeNameError, 86400, 86400), nil, 290}, // MaxNegativeTTL
+		{newAnswer("example.net.", 86400), &resolver.ResponseMetaData{ // max-age
+			CacheControl: &resolver.CacheControl{HasMaxAge: true, MaxAge: time.Minute}}, 50},
+		{newAnswer("example.net.", 30), nil, 20}, // Below all limits
+		{newAnswer("example.net.", 86400), &resolver.ResponseMetaData{ // Stale
+			CacheControl: &resolver.CacheControl{HasMaxAge: true, MaxAge: 5 * time.Second,
+				StaleWhileRevalidate: time.Minute}}, 0},
+	}
+	for ix, tc := range testCases {
+		c.Flush()
+		c.put(q, tc.resp, tc.respMeta)
+		clk.now = clk.now.Add(10 * time.Second)
+		resp, _, _ := c.get(q)
+		if resp == nil {
+			t.Fatal(ix, "Expected a cached response")
+		}
+		rrs := append(resp.Answer, resp.Ns...)
+		if ttl := rrs[0].Header().Ttl; ttl != tc.expect {
+			t.Error(ix, "Expected returned TTL of", tc.expect, "not", ttl)
+		}
+		if soa, ok := rrs[0].(*dns.SOA); ok && soa.Minttl != tc.expect {
+			t.Error(ix, "Expected SOA MINIMUM of", tc.expect, "not", soa.Minttl)
+		}
+	}
+}
+
+func TestUncacheableQuery(t *testing.T) {
+	mr := &mockResolver{resp: newAnswer("example.net.", 60)}
+	r := New(Config{}).Wrap(mr)
+	q := newQuery("example.net.")
+	q.SetEdns0(1232, true) // DO bit
+	r.Resolve(q, nil)
+	r.Resolve(q, nil)
+	q = newQuery("example.net.")
+	q.CheckingDisabled = true
+	r.Resolve(q, nil)
+	r.Resolve(q, nil)
+	if mr.calls != 4 {
+		t.Error("DO and CD queries should bypass the cache", mr.calls)
+	}
+}
+
+func newECSQuery(ip string, netmask uint8) *dns.Msg {
+	q := newQuery("example.net.")
+	q.SetEdns0(1232, false)
+	opt := q.IsEdns0()
+	opt.Option = append(opt.Option, &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1,
+		SourceNetmask: netmask, Address: net.ParseIP(ip)})
+
+	return q
+}
+
+func TestECSScope(t *testing.T) {
+	resp := newAnswer("example.net.", 60)
+	resp.SetEdns0(1232, false)
+	respECS := &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 24, SourceScope: 24,
+		Address: net.ParseIP("192.0.2.0")}
+	resp.IsEdns0().Option = append(resp.IsEdns0().Option, respECS)
+	mr := &mockResolver{resp: resp}
+	r := New(Config{}).Wrap(mr)
+
+	r.Resolve(newECSQuery("192.0.2.1", 24), nil)
+	r.Resolve(newECSQuery("192.0.2.99", 24), nil) // Same /24 so cache hit
+	if mr.calls != 1 {
+		t.Error("Expected query from same scope to be a cache hit", mr.calls)
+	}
+	r.Resolve(newECSQuery("198.51.100.1", 24), nil) // Different /24
+	if mr.calls != 2 {
+		t.Error("Expected query from different scope to be a cache miss", mr.calls)
+	}
+	r.Resolve(newECSQuery("192.0.2.1", 16), nil) // Source too wide for the /24 response
+	if mr.calls != 3 {
+		t.Error("Expected query with wider source than scope to be a cache miss", mr.calls)
+	}
+
+	// A zero scope response applies to all clients
+
+	respECS.SourceScope = 0
+	r = New(Config{}).Wrap(mr)
+	r.Resolve(newECSQuery("192.0.2.1", 24), nil)
+	r.Resolve(newECSQuery("198.51.100.1", 24), nil)
+	r.Resolve(newQuery("example.net."), nil)
+	if mr.calls != 4 {
+		t.Error("Expected zero scope response to be a cache hit for all clients", mr.calls)
+	}
+}
+
+// ecsSetResolver adds an ECS option to the query in the same way the doh resolver does for
+// --ecs-set and returns a response scoped to that subnet.
+type ecsSetResolver struct {
+	mockResolver
+}
+
+func (t *ecsSetResolver) Resolve(q *dns.Msg, qMeta *resolver.QueryMetaData) (*dns.Msg, *resolver.ResponseMetaData, error) {
+	ecs := &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 24, SourceScope: 24,
+		Address: net.ParseIP("192.0.2.0")}
+	q.SetEdns0(1232, false)
+	q.IsEdns0().Option = append(q.IsEdns0().Option, ecs)
+	q.Id = 0
+	resp, respMeta, err := t.mockResolver.Resolve(q, qMeta)
+	resp.SetEdns0(1232, false)
+	resp.IsEdns0().Option = append(resp.IsEdns0().Option, ecs)
+
+	return resp, respMeta, err
+}
+
+// A child which modifies the query must not change the key the response is cached under
+func TestChildModifiesQuery(t *testing.T) {
+	mr := &ecsSetResolver{mockResolver{resp: newAnswer("example.net.", 60)}}
+	r := New(Config{}).Wrap(mr)
+	q := newQuery("example.net.")
+	before := q.String()
+	r.Resolve(q, nil)
+	if q.String() != before {
+		t.Error("Caller's query was modified", q.String())
+	}
+	r.Resolve(q, nil)
+	if mr.calls != 1 {
+		t.Error("Expected second query to be a cache hit", mr.calls)
+	}
+}
+
+func TestEvict(t *testing.T) {
+	clk := &clock{now: time.Now()}
+	c := New(Config{MaxEntries: 2, NowFunc: clk.Now})
+	mr := &mockResolver{resp: newAnswer("example.net.", 60)}
+	r := c.Wrap(mr)
+	r.Resolve(newQuery("a.example.net."), nil)
+	r.Resolve(newQuery("b.example.net."), nil)
+	r.Resolve(newQuery("c.example.net."), nil)
+	size := mr.resp.Len()
+	if rep := c.Report(false); rep != fmt.Sprintf("entries=2 memory=%d hits=0 misses=3 evicted=1", size*2) {
+		t.Error("Expected one eviction", rep)
+	}
+	if _, ok := c.entries[Key(newQuery("a.example.net.").Question[0], nil)]; ok {
+		t.Error("Expected the least recently used entry to be evicted")
+	}
+
+	clk.now = clk.now.Add(time.Minute * 2) // Expired entries are evicted first and en masse
+	r.Resolve(newQuery("d.example.net."), nil)
+	if rep := c.Report(false); rep != fmt.Sprintf("entries=1 memory=%d hits=0 misses=4 evicted=3", size) {
+		t.Error("Expected expired evictions", rep)
+	}
+}
+
+func TestSharedWrap(t *testing.T) {
+	c := New(Config{})
+	mr1 := &mockResolver{resp: newAnswer("example.net.", 60)}
+	mr2 := &mockResolver{resp: newAnswer("example.net.", 60)}
+	c.Wrap(mr1).Resolve(newQuery("example.net."), nil)
+	c.Wrap(mr2).Resolve(newQuery("example.net."), nil)
+	if mr1.calls != 1 || mr2.calls != 0 {
+		t.Error("Expected wrapped resolvers to share the cache", mr1.calls, mr2.calls)
+	}
+	if c.Name() != "Cache" {
+		t.Error("Unexpected Name", c.Name())
+	}
+	if _, err := c.ReportJSON(false); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	c := New(Config{})
+	for _, qName := range []string{"example.net.", "example.org.", "www.example.net."} {
+		c.put(newQuery(qName), newAnswer(qName, 60), nil)
+		q := newQuery(qName)
+		q.Question[0].Qtype = dns.TypeAAAA
+		c.put(q, newAnswer(qName, 60), nil)
+	}
+	if len(c.entries) != 6 {
+		t.Fatal("Setup expected 6 entries, not", len(c.entries))
+	}
+
+	if n := c.FlushName("EXAMPLE.net"); n != 2 {
+		t.Error("FlushName should remove both qtypes of example.net only, removed", n)
+	}
+	if resp, _, _ := c.get(newQuery("example.net.")); resp != nil {
+		t.Error("Flushed name still answered from cache")
+	}
+	if resp, _, _ := c.get(newQuery("www.example.net.")); resp == nil {
+		t.Error("Sub-domain should not be flushed by FlushName")
+	}
+	if n := c.FlushName("example.com."); n != 0 {
+		t.Error("Unknown name should remove nothing, removed", n)
+	}
+
+	if n := c.Flush(); n != 4 {
+		t.Error("Flush should remove remaining 4 entries, removed", n)
+	}
+	if len(c.entries) != 0 || len(c.scopes) != 0 {
+		t.Error("Flush left entries or scopes behind", len(c.entries), len(c.scopes))
+	}
+	if c.hits != 1 {
+		t.Error("Flush should not reset stats", c.hits)
+	}
+}
+
+func TestEntries(t *testing.T) {
+	clk := &clock{now: time.Now()}
+	c := New(Config{NowFunc: clk.Now})
+	c.put(newQuery("example.org."), newAnswer("example.org.", 60), nil)
+	c.put(newQuery("example.net."), newAnswer("example.net.", 30), nil)
+	c.put(newQuery("example.com."), newAnswer("example.com.", 5), nil)
+	c.get(newQuery("example.net."))
+	c.get(newQuery("example.net."))
+	clk.now = clk.now.Add(time.Second * 10) // Expires example.com.
+
+	infos := c.Entries()
+	if len(infos) != 2 {
+		t.Fatal("Expected 2 unexpired entries, not", len(infos), infos)
+	}
+	expect := EntryInfo{Key: "example.net./1/1", TTL: 20, Rcode: "NOERROR", Answer: "A*192.0.2.1", Hits: 2}
+	if infos[0] != expect {
+		t.Error("Expected", expect, "got", infos[0])
+	}
+	if infos[1].Key != "example.org./1/1" || infos[1].TTL != 50 || infos[1].Hits != 0 {
+		t.Error("Unexpected second entry", infos[1])
+	}
+}
+
+func TestEvictLRU(t *testing.T) {
+	clk := &clock{now: time.Now()}
+	size := newAnswer("a.example.net.", 60).Len()
+	c := New(Config{MaxMemory: size * 3, NowFunc: clk.Now})
+	for _, qName := range []string{"a.example.net.", "b.example.net.", "c.example.net."} {
+		c.put(newQuery(qName), newAnswer(qName, 60), nil)
+		clk.now = clk.now.Add(time.Second)
+	}
+	c.get(newQuery("a.example.net.")) // Now b is least recently used
+	clk.now = clk.now.Add(time.Second)
+
+	c.put(newQuery("d.example.net."), newAnswer("d.example.net.", 60), nil)
+	if resp, _, _ := c.get(newQuery("b.example.net.")); resp != nil {
+		t.Error("Expected least recently used entry to be evicted")
+	}
+	for _, qName := range []string{"a.example.net.", "c.example.net.", "d.example.net."} {
+		if resp, _, _ := c.get(newQuery(qName)); resp == nil {
+			t.Error("Expected", qName, "to remain cached")
+		}
+	}
+	if c.memory != size*3 || c.evicted != 1 {
+		t.Error("Expected memory of", size*3, "and one eviction, not", c.memory, c.evicted)
+	}
+
+	// A larger response needs more than one eviction and one which can never fit is not cached
+
+	big := newAnswer("e.example.net.", 60)
+	big.Answer = append(big.Answer, big.Answer[0], big.Answer[0])
+	c.put(newQuery("e.example.net."), big, nil)
+	if len(c.entries) != 2 || c.memory > size*3 {
+		t.Error("Expected a multi-entry eviction for larger response", len(c.entries), c.memory)
+	}
+	big.Answer = append(big.Answer, big.Answer...)
+	c.put(newQuery("f.example.net."), big, nil)
+	if resp, _, _ := c.get(newQuery("f.example.net.")); resp != nil {
+		t.Error("Response larger than MaxMemory should not be cached")
+	}
+
+	// The raw response is never returned so it is not retained to consume unaccounted memory
+
+	c.put(newQuery("g.example.net."), newAnswer("g.example.net.", 60),
+		&resolver.ResponseMetaData{RawResponse: make([]byte, size)})
+	if ent := c.entries[Key(newQuery("g.example.net.").Question[0], nil)]; ent == nil || ent.respMeta.RawResponse != nil {
+		t.Error("Expected entry without RawResponse", ent)
+	}
+
+	c.FlushName("e.example.net.")
+	c.Flush()
+	if c.memory != 0 {
+		t.Error("Flush should zero memory, not", c.memory)
+	}
+}
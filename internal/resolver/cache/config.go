@@ -0,0 +1,19 @@
+package cache
+
+import "time"
+
+const (
+	DefaultMaxEntries = 10000     // Used if Config.MaxEntries is zero
+	DefaultMaxTTL     = time.Hour // Used if Config.MaxTTL is zero
+)
+
+// Config is passed to the New() constructor.
+type Config struct {
+	MaxEntries int           // The least recently used entry is evicted when this is exceeded
+	MaxMemory  int           // Least recently used entries are evicted when this is exceeded if GT zero
+	MaxTTL     time.Duration // Upper bound on how long any response is cached
+
+	MaxNegativeTTL time.Duration // Upper bound on how long NXDOMAIN and NODATA are cached if GT zero
+
+	NowFunc func() time.Time // Caller can supply their own clock, normally for testing
+}
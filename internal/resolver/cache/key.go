@@ -0,0 +1,57 @@
+// Package cache (aka internal/resolver/cache) contains the DNS response caching support used by
+// the resolvers.
+package cache
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Key returns the cache key for a response to the supplied question. ecs is the EDNS0 Client
+// Subnet option returned in the response, if any.
+//
+// A response to an ECS query is only valid for clients within the subnet described by the
+// response's SCOPE PREFIX-LENGTH (rfc7871 Section 7.3.1) so the ECS address is masked to that
+// scope and included in the key. This ensures that a /24-scoped answer is only re-used by clients
+// within that /24. A response with a scope of zero is valid for all clients and thus shares the
+// same key as a response to a non-ECS query.
+//
+// Because the scope is only known once a response has been received, a caller looking up a new
+// query has to probe with each scope it has previously stored for the question.
+func Key(q dns.Question, ecs *dns.EDNS0_SUBNET) string {
+	var sb strings.Builder
+	sb.WriteString(dns.CanonicalName(q.Name))
+	sb.WriteByte('/')
+	sb.WriteString(strconv.Itoa(int(q.Qtype)))
+	sb.WriteByte('/')
+	sb.WriteString(strconv.Itoa(int(q.Qclass)))
+
+	if ecs == nil || ecs.SourceScope == 0 {
+		return sb.String()
+	}
+
+	bits := 32
+	ip := ecs.Address.To4()
+	if ecs.Family == 2 || ip == nil {
+		bits = 128
+		ip = ecs.Address.To16()
+	}
+	if ip == nil { // Unusable address so make sure it can never match a legitimate key
+		sb.WriteString("/?")
+		return sb.String()
+	}
+
+	scope := int(ecs.SourceScope)
+	if scope > bits {
+		scope = bits
+	}
+	sb.WriteByte('/')
+	sb.WriteString(ip.Mask(net.CIDRMask(scope, bits)).String())
+	sb.WriteByte('/')
+	sb.WriteString(strconv.Itoa(scope))
+
+	return sb.String()
+}
@@ -0,0 +1,64 @@
+package cache
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+type keyCase struct {
+	family  uint16
+	address string
+	scope   uint8
+}
+
+func TestKey(t *testing.T) {
+	q := dns.Question{Name: "Example.COM.", Qtype: dns.TypeA, Qclass: dns.ClassINET}
+	global := Key(q, nil)
+	if global != Key(dns.Question{Name: "example.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET}, nil) {
+		t.Error("Key is sensitive to qName case", global)
+	}
+	if global == Key(dns.Question{Name: "example.com.", Qtype: dns.TypeAAAA, Qclass: dns.ClassINET}, nil) {
+		t.Error("Key ignores qType", global)
+	}
+
+	zero := &dns.EDNS0_SUBNET{Family: 1, SourceNetmask: 24, SourceScope: 0, Address: net.ParseIP("10.0.1.1")}
+	if Key(q, zero) != global {
+		t.Error("Scope zero should be globally cacheable", Key(q, zero), global)
+	}
+
+	ecs := func(kc keyCase) *dns.EDNS0_SUBNET {
+		return &dns.EDNS0_SUBNET{Family: kc.family, SourceScope: kc.scope, Address: net.ParseIP(kc.address)}
+	}
+
+	same := [][2]keyCase{
+		{{1, "10.0.1.1", 24}, {1, "10.0.1.254", 24}},
+		{{1, "10.0.1.1", 16}, {1, "10.0.200.7", 16}},
+		{{2, "2001:db8:1:2::1", 48}, {2, "2001:db8:1:ffff::9", 48}},
+		{{1, "10.0.1.1", 40}, {1, "10.0.1.1", 32}}, // Out of range scope is clamped
+	}
+	for ix, tc := range same {
+		k1 := Key(q, ecs(tc[0]))
+		k2 := Key(q, ecs(tc[1]))
+		if k1 != k2 {
+			t.Error(ix, "Expected same key within scope", k1, k2)
+		}
+		if k1 == global {
+			t.Error(ix, "Scoped key should not equal global key", k1)
+		}
+	}
+
+	different := [][2]keyCase{
+		{{1, "10.0.1.1", 24}, {1, "10.0.2.1", 24}},
+		{{1, "10.0.1.1", 24}, {1, "10.0.1.1", 16}},
+		{{2, "2001:db8:1:2::1", 64}, {2, "2001:db8:1:3::1", 64}},
+	}
+	for ix, tc := range different {
+		k1 := Key(q, ecs(tc[0]))
+		k2 := Key(q, ecs(tc[1]))
+		if k1 == k2 {
+			t.Error(ix, "Expected different keys across scopes", k1, k2)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Name implements the reporter interface
+func (t *Cache) Name() string {
+	return "Cache"
+}
+
+// cacheReport is a snapshot of the cache stats shared by Report() and ReportJSON()
+type cacheReport struct {
+	Entries int `json:"entries"`
+	Memory  int `json:"memory"` // Estimated bytes used by all entries
+	Hits    int `json:"hits"`
+	Misses  int `json:"misses"`
+	Evicted int `json:"evicted"`
+}
+
+func (t *Cache) snapshot(resetCounters bool) *cacheReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	cr := &cacheReport{Entries: len(t.entries), Memory: t.memory, Hits: t.hits, Misses: t.misses, Evicted: t.evicted}
+	if resetCounters {
+		t.stats = stats{}
+	}
+
+	return cr
+}
+
+// Report implements the reporter interface
+func (t *Cache) Report(resetCounters bool) string {
+	cr := t.snapshot(resetCounters)
+
+	return fmt.Sprintf("entries=%d memory=%d hits=%d misses=%d evicted=%d", cr.Entries, cr.Memory, cr.Hits,
+		cr.Misses, cr.Evicted)
+}
+
+// ReportJSON implements the reporter.MetricsReporter interface
+func (t *Cache) ReportJSON(resetCounters bool) ([]byte, error) {
+	return json.Marshal(t.snapshot(resetCounters))
+}
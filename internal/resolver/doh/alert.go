@@ -0,0 +1,115 @@
+package doh
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	DefaultAlertWindow = time.Minute // Used if Alerts.Window is zero
+	alertMinRequests   = 10          // Fewer requests than this in the window never raise an alert
+	alertBuckets       = 12          // Granularity of the sliding window
+)
+
+// AlertConfig enables error rate alerts on individual DoH servers. When the proportion of failed
+// requests to a server over the sliding Window exceeds Threshold, the server enters the alert
+// state and Func is called. Func is called again when the error rate drops back to or below the
+// Threshold. A zero Threshold (the default) disables alerts entirely.
+type AlertConfig struct {
+	Threshold float64       // Error rate which raises an alert - 0.0 to 1.0
+	Window    time.Duration // Sliding window the error rate is measured over - 0=DefaultAlertWindow
+	Func      func(Alert)   // Called on each alert state change - nil means counters only
+}
+
+// Alert is passed to AlertConfig.Func when a server enters or leaves the alert state.
+type Alert struct {
+	URL      string
+	Raised   bool          // True when entering the alert state, false when leaving it
+	Requests int           // In the window
+	Errors   int           // In the window
+	Rate     float64       // Errors/Requests
+	Window   time.Duration // Over which Requests and Errors were counted
+}
+
+func (t *AlertConfig) validate() error {
+	if t.Threshold < 0 || t.Threshold > 1 {
+		return fmt.Errorf(me+": Alerts.Threshold of %f is outside the range 0.0 to 1.0", t.Threshold)
+	}
+	if t.Window < 0 {
+		return fmt.Errorf(me+": Alerts.Window cannot be negative: %s", t.Window)
+	}
+	if t.Window == 0 {
+		t.Window = DefaultAlertWindow
+	}
+
+	return nil
+}
+
+// errorWindow counts requests and errors in a ring of time buckets which together span the alert
+// window. Buckets are lazily reset as time moves past them so there is no need for a ticker.
+type errorWindow struct {
+	buckets [alertBuckets]struct {
+		start          time.Time
+		requests, errs int
+	}
+}
+
+// add counts one outcome at time now and returns the totals across the window.
+func (t *errorWindow) add(now time.Time, window time.Duration, failed bool) (requests, errs int) {
+	width := window / alertBuckets
+	if width <= 0 {
+		width = 1
+	}
+	start := now.Truncate(width)
+	b := &t.buckets[(start.UnixNano()/int64(width))%alertBuckets]
+	if !b.start.Equal(start) {
+		b.start = start
+		b.requests = 0
+		b.errs = 0
+	}
+	b.requests++
+	if failed {
+		b.errs++
+	}
+
+	oldest := start.Add(-width * (alertBuckets - 1))
+	for ix := range t.buckets {
+		if !t.buckets[ix].start.Before(oldest) {
+			requests += t.buckets[ix].requests
+			errs += t.buckets[ix].errs
+		}
+	}
+
+	return
+}
+
+// checkAlert records the outcome of a request to bs and raises or clears the server's alert state
+// as the error rate crosses the threshold. Func is called after the lock is released so that it is
+// free to do slow things like logging.
+func (t *remote) checkAlert(bs *bestServer, failed bool) {
+	ac := &t.config.Alerts
+	if ac.Threshold <= 0 {
+		return
+	}
+
+	t.mu.Lock()
+	requests, errs := bs.window.add(time.Now(), ac.Window, failed)
+	rate := float64(errs) / float64(requests)
+	changed := false
+	switch {
+	case !bs.alerting && requests >= alertMinRequests && rate > ac.Threshold:
+		bs.alerting = true
+		bs.alerts++
+		changed = true
+	case bs.alerting && rate <= ac.Threshold:
+		bs.alerting = false
+		changed = true
+	}
+	raised := bs.alerting
+	t.mu.Unlock()
+
+	if changed && ac.Func != nil {
+		ac.Func(Alert{URL: bs.name, Raised: raised,
+			Requests: requests, Errors: errs, Rate: rate, Window: ac.Window})
+	}
+}
@@ -0,0 +1,123 @@
+package doh
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAlertValidate(t *testing.T) {
+	testCases := []struct {
+		ac  AlertConfig
+		ok  bool
+		win time.Duration
+	}{
+		{AlertConfig{}, true, DefaultAlertWindow},
+		{AlertConfig{Threshold: 0.5, Window: time.Second}, true, time.Second},
+		{AlertConfig{Threshold: 1}, true, DefaultAlertWindow},
+		{AlertConfig{Threshold: -0.1}, false, 0},
+		{AlertConfig{Threshold: 1.1}, false, 0},
+		{AlertConfig{Threshold: 0.5, Window: -time.Second}, false, 0},
+	}
+	for ix, tc := range testCases {
+		err := tc.ac.validate()
+		if tc.ok != (err == nil) {
+			t.Error(ix, "Unexpected validate() return", err)
+			continue
+		}
+		if tc.ok && tc.ac.Window != tc.win {
+			t.Error(ix, "Window expected", tc.win, "got", tc.ac.Window)
+		}
+	}
+}
+
+func TestErrorWindow(t *testing.T) {
+	var ew errorWindow
+	window := time.Minute
+	now := time.Unix(1000000, 0)
+	for ix := 0; ix < 4; ix++ {
+		ew.add(now, window, ix%2 == 0)
+	}
+	reqs, errs := ew.add(now.Add(window/2), window, true)
+	if reqs != 5 || errs != 3 {
+		t.Error("Expected 5/3 within the window, got", reqs, errs)
+	}
+
+	// Move past the first four outcomes - only the mid-window one and this one should remain
+
+	reqs, errs = ew.add(now.Add(window+window/4), window, false)
+	if reqs != 2 || errs != 1 {
+		t.Error("Expected 2/1 after sliding, got", reqs, errs)
+	}
+
+	// Move well past everything
+
+	reqs, errs = ew.add(now.Add(window*10), window, false)
+	if reqs != 1 || errs != 0 {
+		t.Error("Expected 1/0 after a long gap, got", reqs, errs)
+	}
+}
+
+func TestCheckAlert(t *testing.T) {
+	var alerts []Alert
+	res, err := New(Config{ServerURLs: []string{"http://localhost"},
+		Alerts: AlertConfig{Threshold: 0.5, Func: func(a Alert) { alerts = append(alerts, a) }}}, nil)
+	if err != nil {
+		t.Fatal("Setup error", err)
+	}
+	bs := res.bsList[0]
+
+	// An error rate over the threshold is ignored until there are enough requests
+
+	for ix := 0; ix < alertMinRequests-1; ix++ {
+		res.addServerFailure(bs, dexDoRequest)
+	}
+	if len(alerts) != 0 {
+		t.Fatal("Alert raised with too few requests", alerts)
+	}
+	res.addServerFailure(bs, dexDoRequest)
+	if len(alerts) != 1 || !alerts[0].Raised {
+		t.Fatal("Expected one raised alert, got", alerts)
+	}
+	a := alerts[0]
+	if a.URL != "http://localhost" || a.Requests != alertMinRequests || a.Errors != alertMinRequests ||
+		a.Rate != 1 || a.Window != DefaultAlertWindow {
+		t.Error("Alert contents wrong", a)
+	}
+
+	// Further failures do not raise it again
+
+	res.addServerFailure(bs, dexDoRequest)
+	if len(alerts) != 1 {
+		t.Error("Alert raised more than once", alerts)
+	}
+
+	// Enough successes to drop to the threshold clears the alert
+
+	for ix := 0; ix < alertMinRequests+1; ix++ {
+		res.addSuccessStats(bs, time.Millisecond, time.Millisecond, false, false, false, false)
+	}
+	if len(alerts) != 2 || alerts[1].Raised {
+		t.Fatal("Expected alert to clear, got", alerts)
+	}
+	if alerts[1].Rate > 0.5 {
+		t.Error("Cleared with rate over threshold", alerts[1])
+	}
+
+	rr := res.snapshot(false)
+	if rr.Servers[0].Alerts != 1 {
+		t.Error("Expected report to show one alert, got", rr.Servers[0].Alerts)
+	}
+}
+
+func TestCheckAlertDisabled(t *testing.T) {
+	res, err := New(Config{ServerURLs: []string{"http://localhost"}}, nil)
+	if err != nil {
+		t.Fatal("Setup error", err)
+	}
+	for ix := 0; ix < alertMinRequests*2; ix++ {
+		res.addServerFailure(res.bsList[0], dexDoRequest)
+	}
+	if res.bsList[0].alerting || res.bsList[0].alerts != 0 {
+		t.Error("Alert raised when disabled")
+	}
+}
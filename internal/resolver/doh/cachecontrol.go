@@ -0,0 +1,53 @@
+package doh
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/markdingo/trustydns/internal/resolver"
+)
+
+// parseCacheControl extracts the max-age, stale-while-revalidate and no-cache directives from an
+// HTTP Cache-Control header value. Directives are case-insensitive and may have quoted values. All
+// other directives are ignored, as are directives with unparseable values. no-store is treated as
+// no-cache as far as we're concerned as neither allows us to re-use the response.
+//
+// Return nil if the header is empty or contains none of the directives of interest.
+func parseCacheControl(value string) *resolver.CacheControl {
+	cc := &resolver.CacheControl{}
+	found := false
+	for _, directive := range strings.Split(value, ",") {
+		name, arg := directive, ""
+		if ix := strings.Index(directive, "="); ix >= 0 {
+			name, arg = directive[:ix], directive[ix+1:]
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		arg = strings.Trim(strings.TrimSpace(arg), `"`)
+
+		switch name {
+		case "no-cache", "no-store":
+			cc.NoCache = true
+			found = true
+
+		case "max-age":
+			if secs, err := strconv.ParseUint(arg, 10, 31); err == nil {
+				cc.MaxAge = time.Duration(secs) * time.Second
+				cc.HasMaxAge = true
+				found = true
+			}
+
+		case "stale-while-revalidate":
+			if secs, err := strconv.ParseUint(arg, 10, 31); err == nil {
+				cc.StaleWhileRevalidate = time.Duration(secs) * time.Second
+				found = true
+			}
+		}
+	}
+
+	if !found {
+		return nil
+	}
+
+	return cc
+}
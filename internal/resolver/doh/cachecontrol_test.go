@@ -0,0 +1,66 @@
+package doh
+
+import (
+	"testing"
+	"time"
+
+	"github.com/markdingo/trustydns/internal/resolver"
+)
+
+type cacheControlCase struct {
+	value  string
+	expect *resolver.CacheControl
+}
+
+var cacheControlCases = []cacheControlCase{
+	{"", nil},
+	{"public, must-revalidate", nil},
+	{"max-age=xx", nil},
+	{"max-age=-1", nil},
+	{"max-age=300", &resolver.CacheControl{MaxAge: 300 * time.Second, HasMaxAge: true}},
+	{"max-age=0", &resolver.CacheControl{HasMaxAge: true}},
+	{`Max-Age="60" , Stale-While-Revalidate=30`,
+		&resolver.CacheControl{MaxAge: time.Minute, HasMaxAge: true, StaleWhileRevalidate: 30 * time.Second}},
+	{"no-cache", &resolver.CacheControl{NoCache: true}},
+	{"private, no-store, max-age=10", &resolver.CacheControl{MaxAge: 10 * time.Second, HasMaxAge: true, NoCache: true}},
+}
+
+func TestParseCacheControl(t *testing.T) {
+	for _, tc := range cacheControlCases {
+		got := parseCacheControl(tc.value)
+		switch {
+		case got == nil && tc.expect == nil:
+		case got == nil || tc.expect == nil:
+			t.Error(tc.value, "Expected", tc.expect, "got", got)
+		case *got != *tc.expect:
+			t.Error(tc.value, "Expected", *tc.expect, "got", *got)
+		}
+	}
+}
+
+// Check that Resolve() passes Cache-Control directives back in the response meta data
+func TestResolveCacheControl(t *testing.T) {
+	mock := newMockDoSimpleMsg(baseDNSQueryMsg())
+	res, _ := New(Config{ServerURLs: []string{"localhost"}}, mock)
+	_, rMeta, err := res.Resolve(baseDNSQueryMsg(), qMeta)
+	if err != nil {
+		t.Fatal("Unexpected failure of Resolve() as part of mock setup", err)
+	}
+	if rMeta.CacheControl != nil {
+		t.Error("CacheControl should be nil without a Cache-Control header", rMeta.CacheControl)
+	}
+
+	mock = newMockDoSimpleMsg(baseDNSQueryMsg())
+	addHTTPResponseHeader(&mock.response, "Cache-Control", "max-age=120, stale-while-revalidate=60")
+	res, _ = New(Config{ServerURLs: []string{"localhost"}}, mock)
+	_, rMeta, err = res.Resolve(baseDNSQueryMsg(), qMeta)
+	if err != nil {
+		t.Fatal("Unexpected failure of Resolve() as part of mock setup", err)
+	}
+	if rMeta.CacheControl == nil {
+		t.Fatal("CacheControl not returned in response meta data")
+	}
+	if rMeta.CacheControl.MaxAge != 2*time.Minute || rMeta.CacheControl.StaleWhileRevalidate != time.Minute {
+		t.Error("CacheControl has wrong values", *rMeta.CacheControl)
+	}
+}
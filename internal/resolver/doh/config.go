@@ -2,6 +2,7 @@ package doh
 
 import (
 	"net"
+	"time"
 
 	"github.com/markdingo/trustydns/internal/bestserver"
 )
@@ -11,12 +12,34 @@ type Config struct {
 	UseGetMethod    bool // Instead of the default POST
 	GeneratePadding bool // RFC8467 query and response padding with zeroes
 
+	UserAgent string // Replaces the default User-Agent header value if not empty
+
+	HTTP2PingInterval time.Duration // Idle period before an HTTP/2 PING health check - 0=no checks
+
 	ECSRedactResponse       bool       // If server-side synthesis/set remove ECS before returning to client
 	ECSRemove               bool       // If ECS options are removed from inbound queries
 	ECSRequestIPv4PrefixLen int        // Server-side synthesis if client address is IPv4 - 0=no synth
 	ECSRequestIPv6PrefixLen int        // Server-side synthesis if client address is IPv6 - 0=no synth
 	ECSSetCIDR              *net.IPNet // Set the ECS locally with this CIDR - cannot have ECSRequest* as well
 
+	PreserveEDNS0 map[uint16]bool // If not nil, remove all other EDNS0 options bar ECS and Padding
+
 	bestserver.LatencyConfig          // Latency Config and Server URLs are passed down
 	ServerURLs               []string // to the DoH resolver.
+
+	Servers []ServerConfig // Servers with their own settings - in addition to ServerURLs
+
+	Alerts AlertConfig // Per-server error rate alerts
+
+	RetryServFail int // Extra tries when the response is SERVFAIL - 0=return SERVFAIL immediately
+
+	FaultInjection FaultInjection // Testing only - fail a proportion of exchanges on purpose
+}
+
+// ServerConfig defines a server with settings which override the resolver-wide defaults.
+type ServerConfig struct {
+	URL        string
+	Headers    map[string]string // Added to every request to this server, e.g. Authorization
+	HTTPClient HTTPClientDo      // Replaces the resolver-wide client if not nil
+	Weight     int               // Bias towards this server - see bestserver.WeightedServer
 }
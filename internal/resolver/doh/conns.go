@@ -0,0 +1,145 @@
+package doh
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// connTracker counts the connections currently open to each dialed address. It does this by
+// wrapping the DialContext of the http.Transports used by the resolver so that a connection is
+// counted from a successful dial until it is closed, regardless of whether the transport closes it
+// because it was idle for too long, because the server went away or because an http2 PING failed.
+type connTracker struct {
+	mu      sync.Mutex
+	open    map[string]int // Keyed by dial address, i.e. host:port
+	wrapped map[*http.Transport]bool
+}
+
+func newConnTracker() *connTracker {
+	return &connTracker{open: make(map[string]int), wrapped: make(map[*http.Transport]bool)}
+}
+
+// instrument wraps the DialContext of client's transport if client is an *http.Client with an
+// *http.Transport. Anything else, such as the mock clients used in tests or a client relying on
+// the shared http.DefaultTransport, is left alone and its connections are not counted.
+func (t *connTracker) instrument(client HTTPClientDo) {
+	hc, ok := client.(*http.Client)
+	if !ok {
+		return
+	}
+	tr, ok := hc.Transport.(*http.Transport)
+	if !ok || tr == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.wrapped[tr] { // Multiple clients can share a transport
+		return
+	}
+	t.wrapped[tr] = true
+
+	dial := tr.DialContext
+	if dial == nil { // Mimic http.DefaultTransport
+		dial = (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+	}
+	tr.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := dial(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		t.mu.Lock()
+		t.open[address]++
+		t.mu.Unlock()
+
+		return &trackedConn{Conn: conn, tracker: t, address: address}, nil
+	}
+}
+
+// openConns returns the number of connections currently open to address
+func (t *connTracker) openConns(address string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.open[address]
+}
+
+// trackedConn decrements the open count of its address on the first Close()
+type trackedConn struct {
+	net.Conn
+	tracker *connTracker
+	address string
+	once    sync.Once
+}
+
+func (t *trackedConn) Close() error {
+	t.once.Do(func() {
+		t.tracker.mu.Lock()
+		t.tracker.open[t.address]--
+		t.tracker.mu.Unlock()
+	})
+
+	return t.Conn.Close()
+}
+
+// dialAddress returns the host:port which the transport dials to reach serverURL. This is the key
+// used by connTracker. An empty string is returned if serverURL cannot be parsed.
+func dialAddress(serverURL string) string {
+	u, err := url.Parse(serverURL)
+	if err != nil || len(u.Host) == 0 {
+		return ""
+	}
+	port := u.Port()
+	if len(port) == 0 {
+		port = "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+	}
+
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// connTrace returns an httptrace.ClientTrace which records the connection used by a request to bs
+// along with a function which releases that connection once the request is complete. A connection
+// is "active" while at least one request is using it. With http2 a connection may carry many
+// concurrent requests.
+func (t *remote) connTrace(bs *bestServer) (*httptrace.ClientTrace, func()) {
+	var conn net.Conn
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+
+			conn = info.Conn
+			if bs.inUse == nil {
+				bs.inUse = make(map[net.Conn]int)
+			}
+			bs.inUse[conn]++
+			if info.Reused {
+				bs.reusedConns++
+			} else {
+				bs.newConns++
+			}
+		},
+	}
+	release := func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		if conn == nil {
+			return
+		}
+		bs.inUse[conn]--
+		if bs.inUse[conn] <= 0 {
+			delete(bs.inUse, conn)
+		}
+	}
+
+	return trace, release
+}
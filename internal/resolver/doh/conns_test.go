@@ -0,0 +1,77 @@
+package doh
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestDialAddress(t *testing.T) {
+	testCases := []struct {
+		url    string
+		expect string
+	}{
+		{"https://dns.example.net/dns-query", "dns.example.net:443"},
+		{"http://dns.example.net/dns-query", "dns.example.net:80"},
+		{"https://dns.example.net:8443/dns-query", "dns.example.net:8443"},
+		{"https://[2001:db8::1]/dns-query", "[2001:db8::1]:443"},
+		{"localhost", ""},
+		{"://", ""},
+	}
+	for _, tc := range testCases {
+		if got := dialAddress(tc.url); got != tc.expect {
+			t.Error(tc.url, "expected", tc.expect, "got", got)
+		}
+	}
+}
+
+// Run queries over a real transport and check that the connection stats track the pool
+func TestConnStats(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		q := &dns.Msg{}
+		q.Unpack(body)
+		resp := &dns.Msg{}
+		resp.SetReply(q)
+		binary, _ := resp.Pack()
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(binary)
+	}))
+	defer ts.Close()
+
+	tr := &http.Transport{}
+	res, err := New(Config{ServerURLs: []string{ts.URL}}, &http.Client{Transport: tr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ix := 0; ix < 3; ix++ {
+		if _, _, err := res.Resolve(baseDNSQueryMsg(), qMeta); err != nil {
+			t.Fatal("Unexpected Resolve error", err)
+		}
+	}
+
+	sr := res.snapshot(false).Servers[0]
+	if len(sr.Conns) != 4 || sr.Conns[0] != 1 || sr.Conns[1] != 0 || sr.Conns[2] != 1 || sr.Conns[3] != 2 {
+		t.Error("Expected conns 1/0/1/2 (idle/active/new/reused), got", sr.Conns)
+	}
+
+	tr.CloseIdleConnections()
+	sr = res.snapshot(true).Servers[0]
+	if sr.Conns[0] != 0 {
+		t.Error("Closed connection still counted as idle", sr.Conns)
+	}
+	sr = res.snapshot(false).Servers[0]
+	if sr.Conns[2] != 0 || sr.Conns[3] != 0 {
+		t.Error("New/Reused counters not reset", sr.Conns)
+	}
+
+	// A second client sharing the same transport must not be wrapped twice
+
+	res.conns.instrument(&http.Client{Transport: tr})
+	if len(res.conns.wrapped) != 1 {
+		t.Error("Transport wrapped more than once", len(res.conns.wrapped))
+	}
+}
@@ -0,0 +1,80 @@
+package doh
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strings"
+
+	"github.com/markdingo/trustydns/internal/constants"
+)
+
+// FaultType is the kind of failure injected in place of a real exchange with the DoH server.
+type FaultType int
+
+const (
+	FaultError     FaultType = iota // Do() returns an error
+	FaultStatus503                  // Server responds with "503 Service Unavailable"
+	FaultMalformed                  // Server responds with 200 but the body is not a DNS message
+)
+
+// FaultInjection causes a proportion of exchanges with DoH servers to fail in a controlled way. It
+// is purely for resilience testing of bestserver demotion, failover and caching. A zero Rate (the
+// default) disables injection entirely.
+type FaultInjection struct {
+	Rate     float64        // Proportion of exchanges which fail - 0.0 to 1.0
+	Type     FaultType      // What sort of failure to inject
+	RandFunc func() float64 // Replaces math/rand.Float64 if not nil - mostly for tests
+}
+
+var errFaultInjected = errors.New(me + ": Injected fault")
+
+// malformedBody is long enough to pass the minimum viable check so that it fails at dns.Unpack
+const malformedBody = "Injected fault: this is not a DNS message"
+
+func (t *FaultInjection) validate() error {
+	if t.Rate < 0 || t.Rate > 1 {
+		return fmt.Errorf(me+": FaultInjection.Rate of %f is outside the range 0.0 to 1.0", t.Rate)
+	}
+	switch t.Type {
+	case FaultError, FaultStatus503, FaultMalformed:
+	default:
+		return fmt.Errorf(me+": Unknown FaultInjection.Type %d", t.Type)
+	}
+
+	return nil
+}
+
+// inject decides whether this exchange fails. If it does, the returned response and error are
+// what the caller should treat as coming from httpClient.Do().
+func (t *FaultInjection) inject(req *http.Request, consts constants.Constants) (bool, *http.Response, error) {
+	if t.Rate <= 0 {
+		return false, nil, nil
+	}
+	rf := t.RandFunc
+	if rf == nil {
+		rf = rand.Float64
+	}
+	if rf() >= t.Rate {
+		return false, nil, nil
+	}
+
+	resp := &http.Response{Request: req, Header: make(http.Header),
+		StatusCode: http.StatusOK, Status: "200 OK",
+		Body: ioutil.NopCloser(strings.NewReader(""))}
+
+	switch t.Type {
+	case FaultStatus503:
+		resp.StatusCode = http.StatusServiceUnavailable
+		resp.Status = "503 Service Unavailable"
+	case FaultMalformed:
+		resp.Header.Set(consts.ContentTypeHeader, consts.Rfc8484AcceptValue)
+		resp.Body = ioutil.NopCloser(strings.NewReader(malformedBody))
+	default:
+		return true, nil, errFaultInjected
+	}
+
+	return true, resp, nil
+}
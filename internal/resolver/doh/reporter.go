@@ -1,18 +1,19 @@
 package doh
 
 import (
+	"encoding/json"
 	"fmt"
 	"time"
+
+	"github.com/markdingo/trustydns/internal/reporter"
 )
 
 // addSuccessStats tracks successful resolutions.
-func (t *remote) addSuccessStats(bsIX int, total, server time.Duration, ecsRemoved, ecsSet, ecsRequest, ecsReturned bool) {
+func (t *remote) addSuccessStats(bs *bestServer, total, server time.Duration, ecsRemoved, ecsSet, ecsRequest, ecsReturned bool) {
 	t.mu.Lock()
-	defer t.mu.Unlock()
-	bs := t.bsList[bsIX]
-
 	bs.success++
 	bs.totalLatency += total
+	t.latency.Add(total)
 	bs.serverLatency += server
 
 	if ecsRemoved {
@@ -27,6 +28,9 @@ func (t *remote) addSuccessStats(bsIX int, total, server time.Duration, ecsRemov
 	if ecsReturned {
 		bs.ecsReturned++
 	}
+	t.mu.Unlock()
+
+	t.checkAlert(bs, false)
 }
 
 // addGeneralFailure tracks failed resolution attempts that are not server specific.
@@ -38,13 +42,12 @@ func (t *remote) addGeneralFailure(dgx dgxInt) {
 }
 
 // addServerFailure tracks failed resolution attempts that can be related to a specific server.
-func (t *remote) addServerFailure(bsIX int, dex dexInt) {
+func (t *remote) addServerFailure(bs *bestServer, dex dexInt) {
 	t.mu.Lock()
-	defer t.mu.Unlock()
-
-	bs := t.bsList[bsIX]
-
 	bs.failures[dex]++
+	t.mu.Unlock()
+
+	t.checkAlert(bs, true)
 }
 
 func (t *remote) Name() string {
@@ -57,21 +60,31 @@ if resetCounters is true.
 
 Output:
 
-Totals: req=305 ok=301 errs=2 (4/0)
+Totals: req=305 ok=301 errs=2 (4/0) (lat 0.050/0.200/0.500)
 
-	^       ^      ^       ^ ^
-	|       |      |       | |
+	^       ^      ^       ^ ^       ^   ^     ^     ^
+	|       |      |       | |       |   |     |     |
+	|       |      |       | |       |   |     |     +--p99
+	|       |      |       | |       |   |     +--p90
+	|       |      |       | |       |   +--p50
+	|       |      |       | |       +--Total query Latency percentiles
 	|       |      |       | +--RFFU Error
 	|       |      |       +--DNSPackError
 	|       |      +--Total Error Requests
 	|       +--Total Good requests
 	+---Total Requests
 
-Server: ok=301 tl=0.254 rl=0.235 errs=5 (0/0/4/0/0/1) (ecs 0/0/305/64) URL
-
-	^      ^        ^        ^       ^ ^ ^ ^ ^ ^  ^    ^ ^ ^   ^   ^
-	|      |        |        |       | | | | | |  |    | | |   |   |
-	|      |        |        |       | | | | | |  |    | | |   |   +-- Server URL
+Server: ok=301 tl=0.254 rl=0.235 errs=5 (0/0/4/0/0/1) (ecs 0/0/305/64) (conns 1/1/2/299) alerts=0 URL
+
+	^      ^        ^        ^       ^ ^ ^ ^ ^ ^  ^    ^ ^ ^   ^    ^    ^ ^ ^ ^   ^        ^
+	|      |        |        |       | | | | | |  |    | | |   |    |    | | | |   |        |
+	|      |        |        |       | | | | | |  |    | | |   |    |    | | | |   |        +-- Server URL
+	|      |        |        |       | | | | | |  |    | | |   |    |    | | | |   +--Error rate alerts raised
+	|      |        |        |       | | | | | |  |    | | |   |    |    | | | +--Reused connections
+	|      |        |        |       | | | | | |  |    | | |   |    |    | | +--New connections
+	|      |        |        |       | | | | | |  |    | | |   |    |    | +--Active connections
+	|      |        |        |       | | | | | |  |    | | |   |    |    +--Idle connections
+	|      |        |        |       | | | | | |  |    | | |   |    +--Connection pool stats
 	|      |        |        |       | | | | | |  |    | | |   +--ecsReturned
 	|      |        |        |       | | | | | |  |    | | +--ecsRequest
 	|      |        |        |       | | | | | |  |    | +--ecsSet
@@ -87,8 +100,59 @@ Server: ok=301 tl=0.254 rl=0.235 errs=5 (0/0/4/0/0/1) (ecs 0/0/305/64) URL
 	|      |        +--Remote server Latency
 	|      +--Total query Latency
 	+--Good Requests
+
+Idle and Active are the current number of open connections to the server's host:port which are
+idle or carrying at least one in-flight request. New and Reused count the requests which obtained a
+newly dialed or a previously used connection. A steadily growing Idle or a high ratio of New to
+Reused suggests a connection leak or a --maximum-remote-connections setting which is too low.
+
+Alerts counts the number of times the server's error rate rose above Config.Alerts.Threshold. It
+is always zero if alerts are not enabled.
 */
 func (t *remote) Report(resetCounters bool) string {
+	rr := t.snapshot(resetCounters)
+
+	report := fmt.Sprintf("Totals: req=%d ok=%d errs=%d (%s) (lat %s)\n",
+		rr.Requests, rr.Success, rr.Errors, formatCounters("%d", "/", rr.Failures.Values),
+		reporter.FormatPercentiles(rr.Latency))
+	for _, sr := range rr.Servers {
+		report += fmt.Sprintf("Server: ok=%d tl=%0.3f rl=%0.3f errs=%d (%s) (ecs %s) (conns %s) alerts=%d %s\n",
+			sr.Success, sr.TotalLatency, sr.RemoteLatency, sr.Errors, formatCounters("%d", "/", sr.Failures.Values),
+			formatCounters("%d", "/", sr.ECS), formatCounters("%d", "/", sr.Conns), sr.Alerts, sr.URL)
+	}
+
+	return report
+}
+
+// ReportJSON implements the reporter.MetricsReporter interface
+func (t *remote) ReportJSON(resetCounters bool) ([]byte, error) {
+	return json.Marshal(t.snapshot(resetCounters))
+}
+
+// resolverReport is a snapshot of the resolver stats shared by Report() and ReportJSON()
+type resolverReport struct {
+	Requests int               `json:"req"`
+	Success  int               `json:"ok"`
+	Errors   int               `json:"errs"`
+	Failures reporter.Counters `json:"failures"`
+	Latency  []float64         `json:"lat"` // p50, p90, p99 in seconds
+	Servers  []*serverReport   `json:"servers"`
+}
+
+type serverReport struct {
+	Success       int               `json:"ok"`
+	TotalLatency  float64           `json:"tl"`
+	RemoteLatency float64           `json:"rl"`
+	Errors        int               `json:"errs"`
+	Failures      reporter.Counters `json:"failures"`
+	ECS           []int             `json:"ecs"`   // Removed, Set, Request, Returned
+	Conns         []int             `json:"conns"` // Idle, Active, New, Reused
+	Alerts        int               `json:"alerts"`
+	URL           string            `json:"url"`
+}
+
+// snapshot gathers up the current stats and optionally resets them
+func (t *remote) snapshot(resetCounters bool) *resolverReport {
 	if resetCounters {
 		t.mu.Lock()
 		defer t.mu.Unlock()
@@ -100,40 +164,40 @@ func (t *remote) Report(resetCounters bool) string {
 	// Create the best server reports first as that lets us calculate the summary stats for the
 	// main report as we pass thru the individual server stats.
 
-	bestReport := ""
-	ok := 0
-	errs := 0
+	rr := &resolverReport{Failures: reporter.NewCounters(dgxNames[:], t.failures[:]), Latency: reporter.Percentiles(&t.latency)}
 	for _, bs := range t.bsList {
-		bsErrs := 0
-		ok += bs.success
+		sr := &serverReport{Success: bs.success, Failures: reporter.NewCounters(dexNames[:], bs.failures[:]),
+			ECS: []int{bs.ecsRemoved, bs.ecsSet, bs.ecsRequest, bs.ecsReturned}, Alerts: bs.alerts, URL: bs.name}
+		active := len(bs.inUse)
+		idle := t.conns.openConns(bs.address) - active
+		if idle < 0 { // Possible with http2 as the transport may tidy up before we release
+			idle = 0
+		}
+		sr.Conns = []int{idle, active, bs.newConns, bs.reusedConns}
 		for _, v := range bs.failures {
-			bsErrs += v
+			sr.Errors += v
 		}
-		errs += bsErrs
-		var tl, rl float64
 		if bs.success > 0 {
-			tl = bs.totalLatency.Seconds() / float64(bs.success)
-			rl = bs.serverLatency.Seconds() / float64(bs.success)
+			sr.TotalLatency = bs.totalLatency.Seconds() / float64(bs.success)
+			sr.RemoteLatency = bs.serverLatency.Seconds() / float64(bs.success)
 		}
-		bestReport += fmt.Sprintf("Server: ok=%d tl=%0.3f rl=%0.3f errs=%d (%s) (ecs %d/%d/%d/%d) %s\n",
-			bs.success, tl, rl, bsErrs, formatCounters("%d", "/", bs.failures[:]),
-			bs.ecsRemoved, bs.ecsSet, bs.ecsRequest, bs.ecsReturned, bs.name)
+		rr.Success += sr.Success
+		rr.Errors += sr.Errors
+		rr.Servers = append(rr.Servers, sr)
 		if resetCounters {
 			bs.resetCounters()
 		}
 	}
 	for _, v := range t.failures {
-		errs += v
+		rr.Errors += v
 	}
-	mainReport := fmt.Sprintf("Totals: req=%d ok=%d errs=%d (%s)\n",
-		ok+errs, ok, errs,
-		formatCounters("%d", "/", t.failures[:]))
+	rr.Requests = rr.Success + rr.Errors
 
 	if resetCounters {
 		t.resetCounters()
 	}
 
-	return mainReport + bestReport
+	return rr
 }
 
 // formatCounters returns a nice %d/%d/%d format from an array of ints. This is less error-prone
@@ -1,17 +1,18 @@
 package doh
 
 import (
+	"encoding/json"
 	"strings"
 	"testing"
 	"time"
 )
 
 const (
-	expect0 = `Totals: req=0 ok=0 errs=0 (0/0)
-Server: ok=0 tl=0.000 rl=0.000 errs=0 (0/0/0/0/0/0) (ecs 0/0/0/0) http://localhost
+	expect0 = `Totals: req=0 ok=0 errs=0 (0/0) (lat 0.000/0.000/0.000)
+Server: ok=0 tl=0.000 rl=0.000 errs=0 (0/0/0/0/0/0) (ecs 0/0/0/0) (conns 0/0/0/0) alerts=0 http://localhost
 `
-	expect1 = `Totals: req=17 ok=5 errs=12 (1/0)
-Server: ok=5 tl=0.380 rl=0.280 errs=11 (2/3/1/1/3/1) (ecs 1/2/3/4) http://localhost
+	expect1 = `Totals: req=17 ok=5 errs=12 (1/0) (lat 0.500/0.500/0.500)
+Server: ok=5 tl=0.380 rl=0.280 errs=11 (2/3/1/1/3/1) (ecs 1/2/3/4) (conns 0/0/2/3) alerts=0 http://localhost
 `
 )
 
@@ -27,25 +28,27 @@ func TestReporter(t *testing.T) {
 		t.Error("Expected:", expect0, "Got:", st)
 	}
 
-	res.addSuccessStats(0, time.Millisecond*200, time.Millisecond*100, false, false, false, false)
-	res.addSuccessStats(0, time.Millisecond*300, time.Millisecond*200, false, false, false, true)
-	res.addSuccessStats(0, time.Millisecond*400, time.Millisecond*300, false, false, true, true)
-	res.addSuccessStats(0, time.Millisecond*500, time.Millisecond*400, false, true, true, true)
-	res.addSuccessStats(0, time.Millisecond*500, time.Millisecond*400, true, true, true, true)
+	res.addSuccessStats(res.bsList[0], time.Millisecond*200, time.Millisecond*100, false, false, false, false)
+	res.addSuccessStats(res.bsList[0], time.Millisecond*300, time.Millisecond*200, false, false, false, true)
+	res.addSuccessStats(res.bsList[0], time.Millisecond*400, time.Millisecond*300, false, false, true, true)
+	res.addSuccessStats(res.bsList[0], time.Millisecond*500, time.Millisecond*400, false, true, true, true)
+	res.addSuccessStats(res.bsList[0], time.Millisecond*500, time.Millisecond*400, true, true, true, true)
 	// 200+300+400+500+500 / 5 = 380 = Total Latency
 	// 100+200+300+400+400 / 5 = 280 = Remote Latency (if reported by remote end)
 	res.addGeneralFailure(dgxPackDNSQuery) // A whole bunch of distinquishible error counts
-	res.addServerFailure(0, dexCreateHTTPRequest)
-	res.addServerFailure(0, dexCreateHTTPRequest)
-	res.addServerFailure(0, dexDoRequest)
-	res.addServerFailure(0, dexDoRequest)
-	res.addServerFailure(0, dexDoRequest)
-	res.addServerFailure(0, dexNonStatusOk)
-	res.addServerFailure(0, dexResponseReadAll)
-	res.addServerFailure(0, dexContentType)
-	res.addServerFailure(0, dexContentType)
-	res.addServerFailure(0, dexContentType)
-	res.addServerFailure(0, dexUnpackDNSResponse)
+	res.addServerFailure(res.bsList[0], dexCreateHTTPRequest)
+	res.addServerFailure(res.bsList[0], dexCreateHTTPRequest)
+	res.addServerFailure(res.bsList[0], dexDoRequest)
+	res.addServerFailure(res.bsList[0], dexDoRequest)
+	res.addServerFailure(res.bsList[0], dexDoRequest)
+	res.addServerFailure(res.bsList[0], dexNonStatusOk)
+	res.addServerFailure(res.bsList[0], dexResponseReadAll)
+	res.addServerFailure(res.bsList[0], dexContentType)
+	res.addServerFailure(res.bsList[0], dexContentType)
+	res.addServerFailure(res.bsList[0], dexContentType)
+	res.addServerFailure(res.bsList[0], dexUnpackDNSResponse)
+	res.bsList[0].newConns = 2
+	res.bsList[0].reusedConns = 3
 	st = res.Report(true)
 	if st != expect1 {
 		t.Error("Expected:", expect1, "Got:", st)
@@ -58,3 +61,36 @@ func TestReporter(t *testing.T) {
 	}
 
 }
+
+func TestReportJSON(t *testing.T) {
+	res, _ := New(Config{ServerURLs: []string{"http://localhost"}}, nil)
+	res.addSuccessStats(res.bsList[0], time.Millisecond*200, time.Millisecond*100, false, true, false, true)
+	res.addServerFailure(res.bsList[0], dexDoRequest)
+	res.addGeneralFailure(dgxPackDNSQuery)
+
+	b, err := res.ReportJSON(false)
+	if err != nil {
+		t.Fatal("Unexpected error from ReportJSON", err)
+	}
+	var rr struct { // Failures are keyed by name
+		resolverReport
+		Failures map[string]int `json:"failures"`
+		Servers  []struct {
+			serverReport
+			Failures map[string]int `json:"failures"`
+		} `json:"servers"`
+	}
+	if err := json.Unmarshal(b, &rr); err != nil {
+		t.Fatal("ReportJSON did not produce valid JSON", err, string(b))
+	}
+	if rr.Requests != 3 || rr.Success != 1 || rr.Errors != 2 || len(rr.Latency) != 3 || len(rr.Servers) != 1 {
+		t.Fatal("ReportJSON returned wrong totals", string(b))
+	}
+	if rr.Failures["packDNSQuery"] != 1 || len(rr.Failures) != int(dgxArraySize) {
+		t.Error("ReportJSON returned wrong resolver failures", string(b))
+	}
+	sr := rr.Servers[0]
+	if sr.URL != "http://localhost" || sr.Failures["doRequest"] != 1 || sr.ECS[1] != 1 || sr.ECS[3] != 1 {
+		t.Error("ReportJSON returned wrong server counters", string(b))
+	}
+}
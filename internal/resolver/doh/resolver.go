@@ -20,6 +20,7 @@ package doh
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"errors"
 	"fmt"
@@ -27,6 +28,7 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"strconv"
 	"strings"
 	"sync"
@@ -35,6 +37,7 @@ import (
 	"github.com/markdingo/trustydns/internal/bestserver"
 	"github.com/markdingo/trustydns/internal/constants"
 	"github.com/markdingo/trustydns/internal/dnsutil"
+	"github.com/markdingo/trustydns/internal/latencyhistogram"
 	"github.com/markdingo/trustydns/internal/resolver"
 
 	"github.com/miekg/dns"
@@ -59,6 +62,9 @@ const (
 	dgxArraySize
 )
 
+// dgxNames are the JSON report keys of the resolver errors array
+var dgxNames = [dgxArraySize]string{"packDNSQuery", "rffu"}
+
 // dex = Doh Error indeX into per-best-server errors array
 type dexInt int
 
@@ -72,16 +78,29 @@ const (
 	dexArraySize
 )
 
+// dexNames are the JSON report keys of the per-best-server errors array
+var dexNames = [dexArraySize]string{"createHTTPRequest", "doRequest", "nonStatusOk", "responseReadAll",
+	"contentType", "unpackDNSResponse"}
+
 type bestServerStats struct {
 	success                                     int
 	ecsRemoved, ecsSet, ecsRequest, ecsReturned int
 	totalLatency, serverLatency                 time.Duration
 	failures                                    [dexArraySize]int
+	newConns, reusedConns                       int // Connections obtained by requests
+	alerts                                      int // Times the error rate alert was raised
 }
 
 // bestServer tracks the statistics of each of our best servers for reporter purposes.
 type bestServer struct {
-	name string
+	name       string
+	headers    map[string]string // Per-server extra request headers
+	httpClient HTTPClientDo      // Per-server client - nil means use the resolver-wide client
+	weight     int               // Per-server bias for bestserver - zero means the default
+	address    string            // host:port dialed by the transport - for connTracker
+	inUse      map[net.Conn]int  // Connections carrying in-flight requests - not reset with stats
+	window     errorWindow       // Recent outcomes for error rate alerts - not reset with stats
+	alerting   bool              // Error rate is currently above the alert threshold
 	bestServerStats
 }
 
@@ -90,12 +109,18 @@ func (t *bestServer) Name() string {
 	return t.name
 }
 
+// Weight meets the bestserver.WeightedServer interface
+func (t *bestServer) Weight() int {
+	return t.weight
+}
+
 func (t *bestServer) resetCounters() {
 	t.bestServerStats = bestServerStats{}
 }
 
 type resolverStats struct {
 	failures [dgxArraySize]int
+	latency  latencyhistogram.Histogram // Total latency of all successful resolutions
 }
 
 type remote struct {
@@ -104,16 +129,19 @@ type remote struct {
 
 	httpClient      HTTPClientDo
 	httpMethod      string // Normally POST
+	userAgent       string // Either from Config or the package default
 	ecsFamily       int    // 0 = none, 1 = ip4, 2 = ipv6 (There are no miekg/dns consts for these values)
 	ecsPrefixLength int    // Only valid if ecsFamily != 0
 	ecsIP           net.IP // Only valid if ecsFamily != 0
 	ecsRequestData  string
 
 	bestServer bestserver.Manager // Tracks which servers are performing well for us
+	conns      *connTracker       // Counts open connections per dial address
 
 	mu sync.RWMutex // Protects everything below here
 
 	bsList []*bestServer
+	pinned *bestServer // If set, used for all queries instead of the bestServer choice
 	resolverStats
 }
 
@@ -133,6 +161,15 @@ func New(config Config, httpClient HTTPClientDo) (*remote, error) {
 
 	t.consts = constants.Get() // Get system-wide read-only constants
 
+	t.userAgent = t.config.UserAgent
+	if len(t.userAgent) == 0 {
+		t.userAgent = t.consts.PackageName + "/" + t.consts.Version + " (" + t.consts.PackageURL + ")"
+	}
+
+	if t.config.HTTP2PingInterval < 0 {
+		return nil, fmt.Errorf(me+":HTTP2PingInterval cannot be negative: %s", t.config.HTTP2PingInterval)
+	}
+
 	t.httpMethod = http.MethodPost // Default is POST
 	if t.config.UseGetMethod {
 		if t.config.ECSSetCIDR != nil ||
@@ -181,14 +218,38 @@ func New(config Config, httpClient HTTPClientDo) (*remote, error) {
 	}
 	t.ecsRequestData = fmt.Sprintf("%d/%d", t.config.ECSRequestIPv4PrefixLen, t.config.ECSRequestIPv6PrefixLen)
 
+	if err := t.config.FaultInjection.validate(); err != nil {
+		return nil, err
+	}
+	if err := t.config.Alerts.validate(); err != nil {
+		return nil, err
+	}
+	if t.config.RetryServFail < 0 {
+		return nil, fmt.Errorf(me+": RetryServFail cannot be negative: %d", t.config.RetryServFail)
+	}
+
 	// Create a "latency" bestserver.Manager to pick the fastest, most reliable server.
 
 	var err error
-	t.bsList = make([]*bestServer, 0, len(t.config.ServerURLs))
-	ifList := make([]bestserver.Server, 0, len(t.config.ServerURLs)) // go doesn't coerce arrays
+	t.conns = newConnTracker()
+	t.conns.instrument(t.httpClient)
+	t.bsList = make([]*bestServer, 0, len(t.config.ServerURLs)+len(t.config.Servers))
 	for _, n := range t.config.ServerURLs {
-		bs := &bestServer{name: n}
-		t.bsList = append(t.bsList, bs)
+		t.bsList = append(t.bsList, &bestServer{name: n, address: dialAddress(n)})
+	}
+	for _, sc := range t.config.Servers {
+		if len(sc.URL) == 0 {
+			return nil, errors.New(me + ": Server Config has an empty URL")
+		}
+		if sc.Weight < 0 {
+			return nil, fmt.Errorf(me+": Server Config Weight cannot be negative: %d", sc.Weight)
+		}
+		t.conns.instrument(sc.HTTPClient)
+		t.bsList = append(t.bsList, &bestServer{name: sc.URL, headers: sc.Headers, httpClient: sc.HTTPClient,
+			weight: sc.Weight, address: dialAddress(sc.URL)})
+	}
+	ifList := make([]bestserver.Server, 0, len(t.bsList)) // go doesn't coerce arrays
+	for _, bs := range t.bsList {
 		ifList = append(ifList, bs)
 	}
 	t.bestServer, err = bestserver.NewLatency(t.config.LatencyConfig, ifList)
@@ -199,6 +260,78 @@ func New(config Config, httpClient HTTPClientDo) (*remote, error) {
 	return t, nil
 }
 
+// AddServer adds a DoH server URL to the list of servers available for resolution. It is intended
+// for use by a discovery mechanism which needs to keep the list current while the resolver is in
+// use. The new server is sampled for performance in due course.
+func (t *remote) AddServer(serverURL string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	bs := &bestServer{name: serverURL, address: dialAddress(serverURL)}
+	for _, existing := range t.bsList { // The bestServer Manager only knows pointers, not URLs
+		if existing.name == serverURL {
+			return errors.New(me + ": Duplicate server URL: " + serverURL)
+		}
+	}
+	err := t.bestServer.AddServer(bs)
+	if err != nil {
+		return fmt.Errorf(me+": %s", err.Error())
+	}
+	t.bsList = append(t.bsList, bs)
+
+	return nil
+}
+
+// RemoveServer removes a DoH server URL from the list of servers available for resolution. The last
+// server cannot be removed. In-flight queries to the removed server complete normally but their
+// results no longer appear in the Report() stats.
+func (t *remote) RemoveServer(serverURL string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for ix, bs := range t.bsList {
+		if bs.name == serverURL {
+			err := t.bestServer.RemoveServer(bs)
+			if err != nil {
+				return fmt.Errorf(me+": %s", err.Error())
+			}
+			t.bsList = append(t.bsList[:ix:ix], t.bsList[ix+1:]...)
+			if t.pinned == bs {
+				t.pinned = nil
+			}
+			return nil
+		}
+	}
+
+	return errors.New(me + ": Server URL not present: " + serverURL)
+}
+
+// PinServer forces all subsequent queries to the named server regardless of what the bestserver
+// algorithm prefers. It is intended for diagnosing a single misbehaving server in a multi-server
+// configuration. Results are still passed to the bestserver algorithm so its view of the servers
+// remains current when UnpinServer() is called.
+func (t *remote) PinServer(serverURL string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, bs := range t.bsList {
+		if bs.name == serverURL {
+			t.pinned = bs
+			return nil
+		}
+	}
+
+	return errors.New(me + ": Cannot pin to unknown server URL: " + serverURL)
+}
+
+// UnpinServer reverts to the bestserver algorithm choosing the server for each query.
+func (t *remote) UnpinServer() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.pinned = nil
+}
+
 // InBailiwick is a not-very-robust test for whether this resolver can handle the name in
 // question. It liberally accept anything that looks vaguely like a FQDN according to the miekg
 // checker routines.
@@ -211,7 +344,17 @@ func (t *remote) InBailiwick(qName string) bool {
 	return ok && dns.IsFqdn(qName)
 }
 
-// Resolve implements the client side of DoH including our trustydns-specific features. In
+// preserveEDNS0 is passed to dnsutil.FilterEDNS0()
+func (t *remote) preserveEDNS0(code uint16) bool {
+	return code == dns.EDNS0SUBNET || code == dns.EDNS0PADDING || t.config.PreserveEDNS0[code]
+}
+
+// Resolve is ResolveContext without any deadline.
+func (t *remote) Resolve(dnsQ *dns.Msg, dnsQMeta *resolver.QueryMetaData) (*dns.Msg, *resolver.ResponseMetaData, error) {
+	return t.ResolveContext(context.Background(), dnsQ, dnsQMeta)
+}
+
+// ResolveContext implements the client side of DoH including our trustydns-specific features. In
 // particular that means adjusting ECS according to our config. The general philosophy of this
 // method is to know as little about the query as possible - in part because we don't need to and in
 // part to insulate us from any future DNS enhancements we may not understand.
@@ -230,9 +373,47 @@ func (t *remote) InBailiwick(qName string) bool {
 // These rules are sequentially processed which means that the step 2. test occurs after whatever
 // step 1. may have done.
 //
+// Prior to the ECS rules, if PreserveEDNS0 is set then all EDNS0 options other than those listed
+// are removed from the query. ECS and Padding are never removed by this step as they are governed
+// by their own settings. This applies to all queries which lack a TSIG, not just IN/Queries.
+//
 // Zero values in the SynthesizeECS HTTP headers have special meaning to the trustydns server in
 // that they instruct it *not* to generate an ECS option under *any* circumstances.
-func (t *remote) Resolve(dnsQ *dns.Msg, dnsQMeta *resolver.QueryMetaData) (*dns.Msg, *resolver.ResponseMetaData, error) {
+//
+// The HTTP request is abandoned if ctx is done before the response arrives.
+//
+// If Config.RetryServFail is set, a SERVFAIL response is treated as a transient server problem and
+// the query is re-sent up to RetryServFail more times. Each SERVFAIL is reported to the bestserver
+// Manager as a failure so a retry may well go to a different server. Retries stop once ctx is
+// done and the last SERVFAIL is returned if all retries are exhausted.
+func (t *remote) ResolveContext(ctx context.Context, dnsQ *dns.Msg, dnsQMeta *resolver.QueryMetaData) (*dns.Msg, *resolver.ResponseMetaData, error) {
+	if t.config.RetryServFail == 0 {
+		resp, respMeta, _, err := t.exchange(ctx, dnsQ, dnsQMeta)
+		return resp, respMeta, err
+	}
+
+	// exchange() modifies the query so each try starts with a fresh copy of the original.
+
+	var queryTries, serverTries int
+	for try := 0; ; try++ {
+		resp, respMeta, server, err := t.exchange(ctx, dnsQ.Copy(), dnsQMeta)
+		if err != nil {
+			return resp, respMeta, err
+		}
+		queryTries += respMeta.QueryTries
+		serverTries += respMeta.ServerTries
+		respMeta.QueryTries = queryTries
+		respMeta.ServerTries = serverTries
+		if resp.Rcode != dns.RcodeServerFailure || try >= t.config.RetryServFail || ctx.Err() != nil {
+			return resp, respMeta, nil
+		}
+		t.bestServer.Result(server, false, time.Now(), 0) // Encourage a switch to another server
+	}
+}
+
+// exchange is the guts of ResolveContext. It makes one HTTP request to the current best server and
+// also returns that server so the caller can influence future server selection.
+func (t *remote) exchange(ctx context.Context, dnsQ *dns.Msg, dnsQMeta *resolver.QueryMetaData) (*dns.Msg, *resolver.ResponseMetaData, bestserver.Server, error) {
 	startTime := time.Now() // Track stats
 
 	originalECSRetained := true  // Track whether the original ECS was forwarded to the DoH server
@@ -250,6 +431,10 @@ func (t *remote) Resolve(dnsQ *dns.Msg, dnsQMeta *resolver.QueryMetaData) (*dns.
 
 	msgIsMutable := dnsQ.IsTsig() == nil
 
+	if msgIsMutable && t.config.PreserveEDNS0 != nil {
+		dnsutil.FilterEDNS0(dnsQ, t.preserveEDNS0)
+	}
+
 	// Constrain special processing to legitimate looking IN queries that lack a TSIG
 
 	if dnsQ.MsgHdr.Opcode == dns.OpcodeQuery &&
@@ -317,13 +502,19 @@ func (t *remote) Resolve(dnsQ *dns.Msg, dnsQMeta *resolver.QueryMetaData) (*dns.
 	}
 	if err != nil {
 		t.addGeneralFailure(dgxPackDNSQuery)
-		return nil, nil, errors.New(me + ":Msg Pack" + err.Error())
+		return nil, nil, nil, errors.New(me + ":Msg Pack" + err.Error())
 	}
 
-	// Form the URL based on the current best server
+	// Form the URL based on the current best server unless a server has been pinned
 
-	bestURL, bsix := t.bestServer.Best()
-	url := bestURL.Name() // Extract the actual base URL
+	bestURL, _ := t.bestServer.Best()
+	t.mu.RLock()
+	if t.pinned != nil {
+		bestURL = t.pinned
+	}
+	t.mu.RUnlock()
+	url := bestURL.Name()       // Extract the actual base URL
+	bs := bestURL.(*bestServer) // Rather than the index as the server list can change
 
 	// If using HTTP GET the DNS query is base64URL encoded as the value of the query string. If
 	// using POST the DNS query is transported as raw binary POST data. The io.Reader 'rd'
@@ -339,18 +530,17 @@ func (t *remote) Resolve(dnsQ *dns.Msg, dnsQMeta *resolver.QueryMetaData) (*dns.
 	// Explicitly construct the http.Request for http.Client.Do() so that we can add Headers and
 	// conditionally supply an io.Reader.
 
-	req, err := http.NewRequest(t.httpMethod, url, rd)
+	req, err := http.NewRequestWithContext(ctx, t.httpMethod, url, rd)
 	if err != nil {
-		t.addServerFailure(bsix, dexCreateHTTPRequest)
-		return nil, nil, err
+		t.addServerFailure(bs, dexCreateHTTPRequest)
+		return nil, nil, nil, err
 	}
 
 	// Set all our standard HTTP headers
 
 	req.Header.Set(t.consts.AcceptHeader, t.consts.Rfc8484AcceptValue)      // RFC SHOULD
 	req.Header.Set(t.consts.ContentTypeHeader, t.consts.Rfc8484AcceptValue) // RFC MUST
-	req.Header.Set(t.consts.UserAgentHeader,
-		t.consts.PackageName+"/"+t.consts.Version+" ("+t.consts.PackageURL+")")
+	req.Header.Set(t.consts.UserAgentHeader, t.userAgent)
 
 	// Are we configured to request ECS synthesis by the DoH server based on client IP and are
 	// we allowed to mutate the message? The DoH server will similarly check for mutability so
@@ -361,14 +551,36 @@ func (t *remote) Resolve(dnsQ *dns.Msg, dnsQMeta *resolver.QueryMetaData) (*dns.
 		req.Header.Set(t.consts.TrustySynthesizeECSRequestHeader, ecsRequestData)
 	}
 
-	resp, err := t.httpClient.Do(req) // Issue the HTTP request
+	if dnsQMeta != nil && len(dnsQMeta.TraceID) > 0 {
+		req.Header.Set(t.consts.TrustyTraceHeader, dnsQMeta.TraceID)
+	}
+
+	for k, v := range bs.headers { // Per-server headers are last so they can override ours
+		req.Header.Set(k, v)
+	}
+
+	// Trace which connection the request uses for the connection stats. It remains in use until
+	// the response body is closed.
+
+	trace, releaseConn := t.connTrace(bs)
+	defer releaseConn()
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	httpClient := t.httpClient
+	if bs.httpClient != nil {
+		httpClient = bs.httpClient
+	}
+	injected, resp, err := t.config.FaultInjection.inject(req, t.consts)
+	if !injected {
+		resp, err = httpClient.Do(req) // Issue the HTTP request
+	}
 	endTime := time.Now()
 	totalDuration := endTime.Sub(startTime)
 
 	if err != nil {
-		t.addServerFailure(bsix, dexDoRequest)
+		t.addServerFailure(bs, dexDoRequest)
 		t.bestServer.Result(bestURL, false, endTime, 0)
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
 	t.bestServer.Result(bestURL, true, endTime, totalDuration)
@@ -378,31 +590,31 @@ func (t *remote) Resolve(dnsQ *dns.Msg, dnsQMeta *resolver.QueryMetaData) (*dns.
 	defer resp.Body.Close() // net/http advises this Close() to avoid a resource leak
 
 	if resp.StatusCode != http.StatusOK { // Only accept a 200 ok status
-		t.addServerFailure(bsix, dexNonStatusOk)
+		t.addServerFailure(bs, dexNonStatusOk)
 		qName := "?"
 		if len(dnsQ.Question) >= 1 {
 			qName = dnsQ.Question[0].Name
 		}
-		return nil, nil, fmt.Errorf(me+": Bad HTTP Status: %s with %s query id=%d qName=%s",
+		return nil, nil, nil, fmt.Errorf(me+": Bad HTTP Status: %s with %s query id=%d qName=%s",
 			resp.Status, bestURL.Name(), dnsQ.Id, qName)
 	}
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		t.addServerFailure(bsix, dexResponseReadAll)
-		return nil, nil, fmt.Errorf(me+": Body Read Error: %s", err.Error())
+		t.addServerFailure(bs, dexResponseReadAll)
+		return nil, nil, nil, fmt.Errorf(me+": Body Read Error: %s", err.Error())
 	}
 
 	ct := resp.Header.Get(t.consts.ContentTypeHeader)
 	if ct != t.consts.Rfc8484AcceptValue {
-		t.addServerFailure(bsix, dexContentType)
-		return nil, nil, fmt.Errorf(me+": Expected Content-Type of '%s' but got '%s'",
+		t.addServerFailure(bs, dexContentType)
+		return nil, nil, nil, fmt.Errorf(me+": Expected Content-Type of '%s' but got '%s'",
 			t.consts.Rfc8484AcceptValue, ct)
 	}
 
 	if uint(len(body)) < t.consts.MinimumViableDNSMessage {
-		t.addServerFailure(bsix, dexContentType)
-		return nil, nil, fmt.Errorf(me+": Response message length of %d is less than minimum viable of %d",
+		t.addServerFailure(bs, dexContentType)
+		return nil, nil, nil, fmt.Errorf(me+": Response message length of %d is less than minimum viable of %d",
 			len(body), t.consts.MinimumViableDNSMessage)
 	}
 
@@ -419,8 +631,8 @@ func (t *remote) Resolve(dnsQ *dns.Msg, dnsQMeta *resolver.QueryMetaData) (*dns.
 	httpR := &dns.Msg{}
 	err = httpR.Unpack(body)
 	if err != nil {
-		t.addServerFailure(bsix, dexUnpackDNSResponse)
-		return nil, nil, fmt.Errorf(me+": dns.Unpack of reply failed: %s", err.Error())
+		t.addServerFailure(bs, dexUnpackDNSResponse)
+		return nil, nil, nil, fmt.Errorf(me+": dns.Unpack of reply failed: %s", err.Error())
 	}
 
 	msgIsMutable = httpR.IsTsig() == nil // Set whether the response is immutable due to the presence of a TSIG
@@ -466,7 +678,7 @@ func (t *remote) Resolve(dnsQ *dns.Msg, dnsQMeta *resolver.QueryMetaData) (*dns.
 		}
 	}
 
-	t.addSuccessStats(bsix, totalDuration, remoteDuration, ecsRemoved, ecsSet, ecsRequest, ecsReturned)
+	t.addSuccessStats(bs, totalDuration, remoteDuration, ecsRemoved, ecsSet, ecsRequest, ecsReturned)
 
 	respMeta := &resolver.ResponseMetaData{
 		TransportType:      resolver.DNSTransportHTTP,
@@ -476,6 +688,8 @@ func (t *remote) Resolve(dnsQ *dns.Msg, dnsQMeta *resolver.QueryMetaData) (*dns.
 		QueryTries:         1,
 		ServerTries:        1,
 		FinalServerUsed:    bestURL.Name(),
+		CacheControl:       parseCacheControl(resp.Header.Get(t.consts.CacheControlHeader)),
+		RawResponse:        body,
 	}
 	if respMeta.TransportDuration <= 0 {
 		respMeta.TransportDuration = 1 // Never let durations be LE 0
@@ -484,5 +698,5 @@ func (t *remote) Resolve(dnsQ *dns.Msg, dnsQMeta *resolver.QueryMetaData) (*dns.
 		respMeta.ResolutionDuration = 1
 	}
 
-	return httpR, respMeta, nil
+	return httpR, respMeta, bestURL, nil
 }
@@ -2,6 +2,7 @@ package doh
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"errors"
 	"io"
@@ -338,6 +339,21 @@ func TestResolveBasic(t *testing.T) {
 	}
 }
 
+// Check that the ctx is attached to the HTTP request
+func TestResolveContext(t *testing.T) {
+	type ctxKey string
+	mock := newMockDoSimpleMsg(baseDNSQueryMsg())
+	res, _ := New(Config{ServerURLs: []string{"localhost"}}, mock)
+	ctx := context.WithValue(context.Background(), ctxKey("k"), "v")
+	_, _, err := res.ResolveContext(ctx, &dns.Msg{}, qMeta)
+	if err != nil {
+		t.Fatal("Unexpected Mock error return", err)
+	}
+	if mock.request.Context().Value(ctxKey("k")) != "v" {
+		t.Error("ctx not passed thru to the HTTP request")
+	}
+}
+
 // Check the errors paths of Resolve()
 func TestResolveErrors(t *testing.T) {
 	mock := newMockDoSimpleMsg(baseDNSQueryMsg())
@@ -450,6 +466,43 @@ func TestResolveHTTPRequest(t *testing.T) {
 	}
 }
 
+// Test that Config.UserAgent replaces the default User-Agent
+func TestResolveUserAgent(t *testing.T) {
+	mock := newMockDoSimpleMsg(baseDNSQueryMsg())
+	res, _ := New(Config{ServerURLs: []string{"localhost"}, UserAgent: "proxy-42/1.0"}, mock)
+	_, _, err := res.Resolve(baseDNSQueryMsg(), qMeta)
+	if err != nil {
+		t.Fatal("Unexpected failure of mock setup", err)
+	}
+	hv := mock.request.Header.Get("User-Agent")
+	if hv != "proxy-42/1.0" {
+		t.Error("User-Agent not set from Config.UserAgent", hv)
+	}
+}
+
+// Test that a QueryMetaData.TraceID is sent as a header and that it is absent otherwise
+func TestResolveTraceID(t *testing.T) {
+	mock := newMockDoSimpleMsg(baseDNSQueryMsg())
+	res, _ := New(Config{ServerURLs: []string{"localhost"}}, mock)
+	_, _, err := res.Resolve(baseDNSQueryMsg(), qMeta)
+	if err != nil {
+		t.Fatal("Unexpected failure of mock setup", err)
+	}
+	if hv := mock.request.Header.Get("X-trustydns-Trace"); len(hv) > 0 {
+		t.Error("Did not expect a trace header without a TraceID", hv)
+	}
+
+	mock = newMockDoSimpleMsg(baseDNSQueryMsg())
+	res, _ = New(Config{ServerURLs: []string{"localhost"}}, mock)
+	_, _, err = res.Resolve(baseDNSQueryMsg(), &resolver.QueryMetaData{TraceID: "0123456789abcdef"})
+	if err != nil {
+		t.Fatal("Unexpected failure of mock setup", err)
+	}
+	if hv := mock.request.Header.Get("X-trustydns-Trace"); hv != "0123456789abcdef" {
+		t.Error("Trace header not set from TraceID", hv)
+	}
+}
+
 // Test good path for the HTTP response side of Resolve()
 // XXXX Is there more we can test here?
 func TestResolveHTTPResponse(t *testing.T) {
@@ -694,6 +747,39 @@ func TestResolveECSRemove(t *testing.T) {
 	}
 }
 
+// Set PreserveEDNS0 and make sure only the listed options, ECS and Padding reach the DoH server
+func TestResolvePreserveEDNS0(t *testing.T) {
+	for _, preserve := range []map[uint16]bool{nil, {65001: true}} {
+		mock := newMockDoSimpleMsg(baseDNSQueryMsg())
+		res, _ := New(Config{PreserveEDNS0: preserve, ServerURLs: []string{"localhost"}}, mock)
+
+		dnsQ := baseDNSQueryMsg()
+		dnsutil.CreateECS(dnsQ, 1, 8, net.ParseIP("10.0.1.1"))
+		opt := dnsQ.IsEdns0()
+		opt.Option = append(opt.Option, &dns.EDNS0_LOCAL{Code: 65001, Data: []byte{1, 2}},
+			&dns.EDNS0_LOCAL{Code: 65002, Data: []byte{3}}, &dns.EDNS0_NSID{Code: dns.EDNS0NSID})
+
+		_, _, err := res.Resolve(dnsQ, qMeta)
+		if err != nil {
+			t.Fatal("Unexpected error", err)
+		}
+		httpQ, _ := mock.extractHTTPRequestMsg()
+		if httpQ == nil {
+			t.Fatal("Unexpected failure from mock while extracting Query Message")
+		}
+		codes := map[uint16]bool{}
+		for _, o := range httpQ.IsEdns0().Option {
+			codes[o.Option()] = true
+		}
+		if !codes[dns.EDNS0SUBNET] || !codes[65001] {
+			t.Error("ECS and preserved option should always be forwarded", preserve, codes)
+		}
+		if codes[65002] == (preserve != nil) || codes[dns.EDNS0NSID] == (preserve != nil) {
+			t.Error("Unlisted options should only be removed when PreserveEDNS0 is set", preserve, codes)
+		}
+	}
+}
+
 // ECSSet query that does not contain an ECS. The HTTP request should have the Config ECS.
 func TestResolveECSSet0(t *testing.T) {
 	mock := newMockDoSimpleMsg(baseDNSQueryMsg())
@@ -966,4 +1052,229 @@ func TestResolveDetails(t *testing.T) {
 		details.TransportType == resolver.DNSTransportUndefined {
 		t.Error("Details returned from Resolve seem unpopulated", details)
 	}
+
+	raw := &dns.Msg{}
+	if err := raw.Unpack(details.RawResponse); err != nil {
+		t.Error("RawResponse should unpack as a DNS message", err)
+	}
+}
+
+// Test that servers can be added and removed dynamically and that resolution continues to work
+func TestAddRemoveServer(t *testing.T) {
+	mock := newMockDoSimpleMsg(baseDNSQueryMsg())
+	res, err := New(Config{ServerURLs: []string{"https://a.example.net"}}, mock)
+	if err != nil {
+		t.Fatal("Unexpected error setting up test", err)
+	}
+	if err := res.AddServer("https://a.example.net"); err == nil {
+		t.Error("Expected an error adding a duplicate URL")
+	}
+	if err := res.AddServer("https://b.example.net"); err != nil {
+		t.Error("Unexpected error adding a server", err)
+	}
+	if err := res.RemoveServer("https://c.example.net"); err == nil {
+		t.Error("Expected an error removing a non-existent server")
+	}
+	if err := res.RemoveServer("https://a.example.net"); err != nil {
+		t.Error("Unexpected error removing the best server", err)
+	}
+	if err := res.RemoveServer("https://b.example.net"); err == nil {
+		t.Error("Expected an error removing the last server")
+	}
+
+	_, respMeta, err := res.Resolve(baseDNSQueryMsg(), qMeta)
+	if err != nil {
+		t.Fatal("Unexpected error from Resolve after server changes", err)
+	}
+	if respMeta.FinalServerUsed != "https://b.example.net" {
+		t.Error("Resolve should have used the remaining server, not", respMeta.FinalServerUsed)
+	}
+	if !strings.Contains(res.Report(false), "ok=1") || strings.Contains(res.Report(false), "a.example.net") {
+		t.Error("Report should only show the remaining server", res.Report(false))
+	}
+}
+
+// Test that a pinned server is used regardless of the bestserver choice
+func TestPinServer(t *testing.T) {
+	mock := newMockDoSimpleMsg(baseDNSQueryMsg())
+	res, err := New(Config{ServerURLs: []string{"https://a.example.net", "https://b.example.net"}}, mock)
+	if err != nil {
+		t.Fatal("Unexpected error setting up test", err)
+	}
+	if err := res.PinServer("https://c.example.net"); err == nil {
+		t.Error("Expected an error pinning an unknown server")
+	}
+	if err := res.PinServer("https://b.example.net"); err != nil {
+		t.Fatal("Unexpected error pinning a server", err)
+	}
+	for ix := 0; ix < 50; ix++ { // Enough to ensure bestserver sampling is bypassed
+		res.httpClient = newMockDoSimpleMsg(baseDNSQueryMsg()) // Mock bodies can only be read once
+		_, respMeta, err := res.Resolve(baseDNSQueryMsg(), qMeta)
+		if err != nil {
+			t.Fatal("Unexpected error from Resolve", err)
+		}
+		if respMeta.FinalServerUsed != "https://b.example.net" {
+			t.Fatal("Resolve did not use the pinned server", respMeta.FinalServerUsed)
+		}
+	}
+
+	res.UnpinServer()
+	res.httpClient = newMockDoSimpleMsg(baseDNSQueryMsg())
+	best, _ := res.bestServer.Best()
+	_, respMeta, _ := res.Resolve(baseDNSQueryMsg(), qMeta)
+	if respMeta.FinalServerUsed != best.Name() {
+		t.Error("Resolve should revert to", best.Name(), "after unpinning, not", respMeta.FinalServerUsed)
+	}
+
+	res.PinServer("https://a.example.net") // Removing a pinned server also unpins it
+	res.RemoveServer("https://a.example.net")
+	if res.pinned != nil {
+		t.Error("Removing the pinned server should have unpinned it")
+	}
+}
+
+// Test that per-server headers and clients are used for the server they're configured with
+func TestServerConfig(t *testing.T) {
+	shared := newMockDoSimpleMsg(baseDNSQueryMsg())
+	private := newMockDoSimpleMsg(baseDNSQueryMsg())
+	res, err := New(Config{Servers: []ServerConfig{{URL: "https://a.example.net",
+		Headers: map[string]string{"Authorization": "Bearer xyzzy"}, HTTPClient: private}}}, shared)
+	if err != nil {
+		t.Fatal("Unexpected error setting up test", err)
+	}
+	_, _, err = res.Resolve(baseDNSQueryMsg(), qMeta)
+	if err != nil {
+		t.Fatal("Unexpected error from Resolve", err)
+	}
+	if shared.request.Method != "" || private.request.Method == "" {
+		t.Fatal("Resolve did not use the per-server HTTP client")
+	}
+	if got := private.request.Header.Get("Authorization"); got != "Bearer xyzzy" {
+		t.Error("Per-server header not set. Got", got)
+	}
+
+	_, err = New(Config{Servers: []ServerConfig{{}}}, shared)
+	if err == nil {
+		t.Error("Expected an error with an empty Server Config URL")
+	}
+	_, err = New(Config{Servers: []ServerConfig{{URL: "https://a.example.net", Weight: -1}}}, shared)
+	if err == nil {
+		t.Error("Expected an error with a negative Server Config Weight")
+	}
+}
+
+// Check that injected faults take the normal failure paths and are counted against the server
+func TestFaultInjection(t *testing.T) {
+	for _, fi := range []FaultInjection{{Rate: -0.1}, {Rate: 1.1}, {Rate: 0.5, Type: FaultType(99)}} {
+		_, err := New(Config{ServerURLs: []string{"localhost"}, FaultInjection: fi}, nil)
+		if err == nil {
+			t.Error("Expected New() to reject", fi)
+		}
+	}
+
+	testCases := []struct {
+		fault  FaultType
+		dex    dexInt
+		errStr string
+	}{
+		{FaultError, dexDoRequest, "Injected fault"},
+		{FaultStatus503, dexNonStatusOk, "Status: 503"},
+		{FaultMalformed, dexUnpackDNSResponse, "dns.Unpack"},
+	}
+
+	for _, tc := range testCases {
+		mock := newMockDoSimpleMsg(baseDNSQueryMsg())
+		roll := 0.0
+		fi := FaultInjection{Rate: 0.5, Type: tc.fault, RandFunc: func() float64 { return roll }}
+		res, err := New(Config{ServerURLs: []string{"localhost"}, FaultInjection: fi}, mock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, err = res.Resolve(baseDNSQueryMsg(), qMeta)
+		if err == nil || !strings.Contains(err.Error(), tc.errStr) {
+			t.Error(tc.fault, "Expected error containing", tc.errStr, "got", err)
+		}
+		if mock.request.Method != "" {
+			t.Error(tc.fault, "Injected fault should not have reached the HTTP client")
+		}
+		if res.bsList[0].failures[tc.dex] != 1 {
+			t.Error(tc.fault, "Expected failure counted at", tc.dex, res.bsList[0].failures)
+		}
+
+		roll = 0.5 // At or above Rate means no injection
+		_, _, err = res.Resolve(baseDNSQueryMsg(), qMeta)
+		if err != nil {
+			t.Error(tc.fault, "Unexpected error when fault not injected", err)
+		}
+	}
+}
+
+// mockDoServFail returns SERVFAIL for the first servFails requests then NOERROR
+type mockDoServFail struct {
+	servFails int
+	requests  int
+}
+
+func (m *mockDoServFail) Do(r *http.Request) (*http.Response, error) {
+	m.requests++
+	reply := baseDNSQueryMsg()
+	reply.Response = true
+	if m.requests <= m.servFails {
+		reply.Rcode = dns.RcodeServerFailure
+	}
+
+	return newMockDoSimpleMsg(reply).Do(r)
+}
+
+func TestRetryServFail(t *testing.T) {
+	_, err := New(Config{ServerURLs: []string{"http://localhost"}, RetryServFail: -1}, nil)
+	if err == nil {
+		t.Error("Expected New() to reject a negative RetryServFail")
+	}
+
+	testCases := []struct {
+		retries   int
+		servFails int
+		rcode     int
+		tries     int
+	}{
+		{0, 1, dns.RcodeServerFailure, 1}, // Disabled
+		{2, 0, dns.RcodeSuccess, 1},
+		{2, 1, dns.RcodeSuccess, 2},
+		{2, 2, dns.RcodeSuccess, 3},
+		{2, 3, dns.RcodeServerFailure, 3}, // Retries exhausted
+	}
+
+	for ix, tc := range testCases {
+		mock := &mockDoServFail{servFails: tc.servFails}
+		res, err := New(Config{ServerURLs: []string{"http://a.example.net", "http://b.example.net"},
+			RetryServFail: tc.retries}, mock)
+		if err != nil {
+			t.Fatal(ix, "Setup error", err)
+		}
+		resp, respMeta, err := res.Resolve(baseDNSQueryMsg(), qMeta)
+		if err != nil {
+			t.Fatal(ix, "Unexpected error", err)
+		}
+		if resp.Rcode != tc.rcode {
+			t.Error(ix, "Expected rcode", dns.RcodeToString[tc.rcode], "got", dns.RcodeToString[resp.Rcode])
+		}
+		if mock.requests != tc.tries || respMeta.QueryTries != tc.tries || respMeta.ServerTries != tc.tries {
+			t.Error(ix, "Expected", tc.tries, "tries, got", mock.requests, respMeta.QueryTries, respMeta.ServerTries)
+		}
+	}
+
+	// A done context stops further retries
+
+	mock := &mockDoServFail{servFails: 5}
+	res, err := New(Config{ServerURLs: []string{"http://localhost"}, RetryServFail: 3}, mock)
+	if err != nil {
+		t.Fatal("Setup error", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err = res.ResolveContext(ctx, baseDNSQueryMsg(), qMeta)
+	if mock.requests > 1 {
+		t.Error("Retried after context was done", mock.requests, err)
+	}
 }
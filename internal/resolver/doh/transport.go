@@ -0,0 +1,39 @@
+package doh
+
+import (
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// defaultPingTimeout mirrors the http2 package default for how long to wait for a PING response
+const defaultPingTimeout = 15 * time.Second
+
+// ConfigureTransport activates http2 on the supplied transport in the same way as
+// http2.ConfigureTransport and, if Config.HTTP2PingInterval is set, enables PING health checks of
+// idle connections. A connection which fails to respond to a PING is closed so that the next
+// request establishes a fresh connection rather than waiting on one silently discarded by a
+// stateful middlebox.
+func ConfigureTransport(tr *http.Transport, config Config) error {
+	_, err := configureHTTP2(tr, config)
+
+	return err
+}
+
+// configureHTTP2 is the guts of ConfigureTransport which returns the http2 transport for testing
+func configureHTTP2(tr *http.Transport, config Config) (*http2.Transport, error) {
+	h2, err := http2.ConfigureTransports(tr)
+	if err != nil {
+		return nil, err
+	}
+	if config.HTTP2PingInterval > 0 {
+		h2.ReadIdleTimeout = config.HTTP2PingInterval
+		h2.PingTimeout = defaultPingTimeout
+		if config.HTTP2PingInterval < h2.PingTimeout { // Don't let a PING outlive the next one
+			h2.PingTimeout = config.HTTP2PingInterval
+		}
+	}
+
+	return h2, nil
+}
@@ -0,0 +1,47 @@
+package doh
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestConfigureTransport(t *testing.T) {
+	tr := &http.Transport{}
+	if err := ConfigureTransport(tr, Config{}); err != nil {
+		t.Fatal("Unexpected error from ConfigureTransport", err)
+	}
+	if tr.TLSNextProto["h2"] == nil {
+		t.Error("ConfigureTransport did not activate h2")
+	}
+
+	// A second configure of the same transport should fail just as http2 does
+
+	if err := ConfigureTransport(tr, Config{}); err == nil {
+		t.Error("Expected an error when configuring h2 twice")
+	}
+
+	testCases := []struct {
+		interval, readIdle, ping time.Duration
+	}{
+		{0, 0, 0},
+		{time.Minute, time.Minute, defaultPingTimeout},
+		{time.Second, time.Second, time.Second},
+	}
+	for _, tc := range testCases {
+		h2, err := configureHTTP2(&http.Transport{}, Config{HTTP2PingInterval: tc.interval})
+		if err != nil {
+			t.Fatal("Unexpected error from configureHTTP2", err)
+		}
+		if h2.ReadIdleTimeout != tc.readIdle || h2.PingTimeout != tc.ping {
+			t.Error(tc.interval, "expected", tc.readIdle, tc.ping, "got", h2.ReadIdleTimeout, h2.PingTimeout)
+		}
+	}
+}
+
+func TestNewHTTP2PingInterval(t *testing.T) {
+	_, err := New(Config{ServerURLs: []string{"http://localhost"}, HTTP2PingInterval: -time.Second}, nil)
+	if err == nil {
+		t.Error("Expected an error return from a negative HTTP2PingInterval")
+	}
+}
@@ -1,10 +1,40 @@
 package local
 
+import "time"
+
+// DefaultUDPBufferSize is the EDNS0 UDP payload size recommended by DNS Flag Day 2020 as being
+// unlikely to cause IP fragmentation on most networks.
+const DefaultUDPBufferSize = 1232
+
 // Config is passed to the New() constructor.
 type Config struct {
 	ResolvConfPath string
+	Name           string   // Distinguishes this resolver in reports if there is more than one
 	LocalDomains   []string // In addition to those found in the resolvConfPath
 
+	// UDPBufferSize replaces the OPT UDP payload size of EDNS0 queries. Zero means use
+	// DefaultUDPBufferSize.
+	UDPBufferSize int
+
+	// ParallelQueries, if greater than one, sends each query to this many of the best servers
+	// at the same time and returns the first acceptable response.
+	ParallelQueries int
+
+	// HybridBestServer selects servers in res_send order until enough latency data has been
+	// gathered to prefer the fastest server instead.
+	HybridBestServer bool
+
+	// ReorderBySuccess periodically re-sorts the res_send server order so that the servers which
+	// have been most successful recently are tried first. Ignored if HybridBestServer is set.
+	ReorderBySuccess bool
+
+	// FailureResponse determines what is returned when all servers fail. FailureTTL caps the
+	// TTLs of such responses and defaults to DefaultFailureTTL. LastKnownGoodEntries limits the
+	// responses retained for FailureLastKnownGood and defaults to DefaultLastKnownGoodEntries.
+	FailureResponse      FailurePolicy
+	FailureTTL           time.Duration
+	LastKnownGoodEntries int
+
 	// Caller can create their own Exchangers on our behalf
 	NewDNSClientExchangerFunc func(net string) DNSClientExchanger
 }
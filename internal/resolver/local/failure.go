@@ -0,0 +1,132 @@
+package local
+
+import (
+	"time"
+
+	"github.com/markdingo/trustydns/internal/dnsutil"
+	"github.com/markdingo/trustydns/internal/resolver/cache"
+
+	"github.com/miekg/dns"
+)
+
+// FailurePolicy determines what ResolveContext() returns when no server produces a usable response.
+type FailurePolicy int
+
+const (
+	FailureError         FailurePolicy = iota // Return an error - the historical behavior
+	FailureServFail                           // Return a synthetic SERVFAIL with an EDE
+	FailureLastKnownGood                      // Return the last good response if known, otherwise as FailureServFail
+)
+
+const (
+	// DefaultFailureTTL caps the TTLs of responses synthesized or re-served due to failure.
+	DefaultFailureTTL = 5 * time.Second
+
+	// DefaultLastKnownGoodEntries is the number of responses retained for FailureLastKnownGood.
+	DefaultLastKnownGoodEntries = 10000
+
+	failureEDEText = "all upstream servers failed"
+)
+
+// lastGoodKey identifies a query in the last known good map. It is a cache.Key() so the name is
+// canonical and an ECS query only matches responses to queries from exactly the same subnet, which
+// is the widest scope known without the response. The DO bit is appended as a response to a DO=1
+// query contains RRSIGs which a response to a DO=0 query lacks.
+type lastGoodKey string
+
+func newLastGoodKey(q *dns.Msg) (lastGoodKey, bool) {
+	if len(q.Question) != 1 {
+		return "", false
+	}
+	var ecs *dns.EDNS0_SUBNET
+	do := false
+	if opt := q.IsEdns0(); opt != nil {
+		do = opt.Do()
+		for _, o := range opt.Option {
+			if e, ok := o.(*dns.EDNS0_SUBNET); ok {
+				scoped := *e
+				scoped.SourceScope = e.SourceNetmask
+				ecs = &scoped
+				break
+			}
+		}
+	}
+	key := cache.Key(q.Question[0], ecs)
+	if do {
+		key += "/do"
+	}
+
+	return lastGoodKey(key), true
+}
+
+// rememberGood retains a copy of a successful or NXDomain response for FailureLastKnownGood. Any
+// other rcode is not worth re-serving. When full, an arbitrary entry is discarded.
+func (t *local) rememberGood(q, r *dns.Msg) {
+	if t.config.FailureResponse != FailureLastKnownGood {
+		return
+	}
+	if r.Rcode != dns.RcodeSuccess && r.Rcode != dns.RcodeNameError {
+		return
+	}
+	key, ok := newLastGoodKey(q)
+	if !ok {
+		return
+	}
+
+	r = r.Copy()
+	t.lgMu.Lock()
+	defer t.lgMu.Unlock()
+	if _, exists := t.lastGood[key]; !exists && len(t.lastGood) >= t.config.LastKnownGoodEntries {
+		for k := range t.lastGood {
+			delete(t.lastGood, k)
+			break
+		}
+	}
+	t.lastGood[key] = r
+}
+
+// failureResponse returns the response dictated by Config.FailureResponse after all servers have
+// failed, or nil if the caller should return the error as per normal.
+func (t *local) failureResponse(q *dns.Msg) *dns.Msg {
+	maxTTL := uint32(t.config.FailureTTL / time.Second)
+	switch t.config.FailureResponse {
+	case FailureLastKnownGood:
+		if key, ok := newLastGoodKey(q); ok {
+			t.lgMu.Lock()
+			r := t.lastGood[key]
+			t.lgMu.Unlock()
+			if r != nil {
+				r = r.Copy()
+				r.Id = q.Id
+				capTTL(r, maxTTL)
+				return r
+			}
+		}
+		fallthrough
+
+	case FailureServFail:
+		r := &dns.Msg{}
+		r.SetRcode(q, dns.RcodeServerFailure)
+		r.RecursionAvailable = true
+		if opt := q.IsEdns0(); opt != nil {
+			r.SetEdns0(opt.UDPSize(), false)
+			dnsutil.AddEDE(r, dns.ExtendedErrorCodeNoReachableAuthority, failureEDEText)
+		}
+		return r
+	}
+
+	return nil
+}
+
+// capTTL reduces any TTL in the response which exceeds maxTTL. The OPT is skipped as its TTL field
+// holds flags rather than a TTL.
+func capTTL(r *dns.Msg, maxTTL uint32) {
+	for _, rrs := range [][]dns.RR{r.Answer, r.Ns, r.Extra} {
+		for _, rr := range rrs {
+			hdr := rr.Header()
+			if hdr.Rrtype != dns.TypeOPT && hdr.Ttl > maxTTL {
+				hdr.Ttl = maxTTL
+			}
+		}
+	}
+}
@@ -1,17 +1,23 @@
 package local
 
 import (
+	"encoding/json"
 	"fmt"
 	"time"
+
+	"github.com/markdingo/trustydns/internal/bestserver"
+	"github.com/markdingo/trustydns/internal/reporter"
 )
 
 // addGeneralSuccess tracks successful resolution attempts that are not server specific. There is a
-// maximum of one of these calls per Resolve() call.
-func (t *local) addGeneralSuccess() {
+// maximum of one of these calls per Resolve() call. The latency is the total resolution time which
+// may span multiple server exchanges.
+func (t *local) addGeneralSuccess(latency time.Duration) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
 	t.success++
+	t.latency.Add(latency)
 }
 
 // addGeneralFailure tracks failed resolution attempts that are not server specific. There is a
@@ -60,6 +66,10 @@ func (t *local) addServerFailure(bsix int, tcpFallback, tcpSuperior bool, sfx sf
 }
 
 func (t *local) Name() string {
+	if len(t.config.Name) > 0 {
+		return "Local Resolver (" + t.config.Name + ")"
+	}
+
 	return "Local Resolver"
 }
 
@@ -67,10 +77,14 @@ func (t *local) Name() string {
 Report returns a multi-line string showing stats suitable for printing to a log file. Zero counters
 if resetCounters is true.
 
-Totals: req=1273 ok=1273 errs=0 (0/0)
+Totals: req=1273 ok=1273 errs=0 (0/0) (lat 0.002/0.005/0.020)
 
-	^        ^       ^       ^ ^
-	|        |       |       | |
+	^        ^       ^       ^ ^       ^   ^     ^     ^
+	|        |       |       | |       |   |     |     |
+	|        |       |       | |       |   |     |     +--p99
+	|        |       |       | |       |   |     +--p90
+	|        |       |       | |       |   +--p50
+	|        |       |       | |       +--Resolution latency percentiles
 	|        |       |       | +--Retry count exceeded
 	|        |       |       +--Timeout limit exceeded
 	|        |       +--Total bad requests
@@ -95,8 +109,63 @@ Server: req=1273 ok=1273 al=0.003 errs=0 (0/0/0/0/0/0) (ev 0/0) 127.0.0.1:53
 	|        |       +--Average latency
 	|        +--Good requests
 	+---Total requests
+
+Best: traditional ix=0 cycles=0 consec=0/0
+
+	^     ^           ^    ^        ^
+	|     |           |    |        |
+	|     |           |    |        +--Consecutive failures of each server
+	|     |           |    +--Times failures cycled thru all servers (traditional)
+	|     |           +--Index of current best server
+	|     +--Best server algorithm
+	+--Best server state
 */
 func (t *local) Report(resetCounters bool) string {
+	rr := t.snapshot(resetCounters)
+
+	report := fmt.Sprintf("Totals: req=%d ok=%d errs=%d (%s) (lat %s)\n",
+		rr.Requests, rr.Success, rr.Errors, formatCounters("%d", "/", rr.Failures.Values),
+		reporter.FormatPercentiles(rr.Latency))
+	for _, sr := range rr.Servers {
+		report += fmt.Sprintf("Server: req=%d ok=%d al=%0.3f errs=%d (%s) (ev %s) %s\n",
+			sr.Requests, sr.Success, sr.AverageLatency, sr.Errors, formatCounters("%d", "/", sr.Failures.Values),
+			formatCounters("%d", "/", sr.Events.Values), sr.Server)
+	}
+	report += fmt.Sprintf("Best: %s ix=%d cycles=%d consec=%s\n", rr.Best.Algorithm, rr.Best.BestIndex,
+		rr.Best.Cycles, formatCounters("%d", "/", rr.Best.ConsecutiveFailures))
+
+	return report
+}
+
+// ReportJSON implements the reporter.MetricsReporter interface
+func (t *local) ReportJSON(resetCounters bool) ([]byte, error) {
+	return json.Marshal(t.snapshot(resetCounters))
+}
+
+// resolverReport is a snapshot of the resolver stats shared by Report() and ReportJSON()
+type resolverReport struct {
+	Requests int               `json:"req"`
+	Success  int               `json:"ok"`
+	Errors   int               `json:"errs"`
+	Failures reporter.Counters `json:"failures"`
+	Latency  []float64         `json:"lat"` // p50, p90, p99 in seconds
+	Servers  []*serverReport   `json:"servers"`
+
+	Best bestserver.Stats `json:"best"`
+}
+
+type serverReport struct {
+	Requests       int               `json:"req"`
+	Success        int               `json:"ok"`
+	AverageLatency float64           `json:"al"`
+	Errors         int               `json:"errs"`
+	Failures       reporter.Counters `json:"failures"`
+	Events         reporter.Counters `json:"events"`
+	Server         string            `json:"server"`
+}
+
+// snapshot gathers up the current stats and optionally resets them
+func (t *local) snapshot(resetCounters bool) *resolverReport {
 	if resetCounters {
 		t.mu.Lock()
 		defer t.mu.Unlock()
@@ -105,39 +174,35 @@ func (t *local) Report(resetCounters bool) string {
 		defer t.mu.RUnlock()
 	}
 
-	// Create the best server reports first as that lets us calculate the summary stats for the
-	// main report as we pass thru the individual server stats.
-
-	bestReport := ""
-	errs := 0
+	rr := &resolverReport{Success: t.success, Failures: reporter.NewCounters(gfxNames[:], t.failures[:]),
+		Latency: reporter.Percentiles(&t.latency)}
 	for _, v := range t.failures {
-		errs += v
+		rr.Errors += v
 	}
+	rr.Requests = rr.Success + rr.Errors
+
 	for _, bs := range t.bsList {
-		bsErrs := 0
+		sr := &serverReport{Success: bs.success, Failures: reporter.NewCounters(sfxNames[:], bs.failures[:]),
+			Events: reporter.NewCounters(evxNames[:], bs.events[:]), Server: bs.name}
 		for _, v := range bs.failures {
-			bsErrs += v
+			sr.Errors += v
 		}
-		var al float64
+		sr.Requests = sr.Success + sr.Errors
 		if bs.success > 0 {
-			al = bs.latency.Seconds() / float64(bs.success)
+			sr.AverageLatency = bs.latency.Seconds() / float64(bs.success)
 		}
-		bestReport += fmt.Sprintf("Server: req=%d ok=%d al=%0.3f errs=%d (%s) (ev %s) %s\n",
-			bs.success+bsErrs, bs.success, al, bsErrs, formatCounters("%d", "/", bs.failures[:]),
-			formatCounters("%d", "/", bs.events[:]), bs.name)
+		rr.Servers = append(rr.Servers, sr)
 		if resetCounters {
 			bs.resetCounters()
 		}
 	}
 
-	mainReport := fmt.Sprintf("Totals: req=%d ok=%d errs=%d (%s)\n",
-		t.success+errs, t.success, errs, formatCounters("%d", "/", t.failures[:]))
-
 	if resetCounters {
 		t.resetCounters()
 	}
+	rr.Best = t.bestServer.Stats()
 
-	return mainReport + bestReport
+	return rr
 }
 
 // formatCounters returns a nice %d/%d/%d format from an array of ints. This is less error-prone
@@ -7,11 +7,12 @@ import (
 )
 
 const (
-	zero1 = `Totals: req=0 ok=0 errs=0 (0/0)
+	zero1 = `Totals: req=0 ok=0 errs=0 (0/0) (lat 0.000/0.000/0.000)
 Server: req=0 ok=0 al=0.000 errs=0 (0/0/0/0/0/0) (ev 0/0) 127.0.0.127:53
-Server: req=0 ok=0 al=0.000 errs=0 (0/0/0/0/0/0) (ev 0/0) [::127]:53`
+Server: req=0 ok=0 al=0.000 errs=0 (0/0/0/0/0/0) (ev 0/0) [::127]:53
+Best: traditional ix=0 cycles=0 consec=0/0`
 
-	all1 = `Totals: req=5 ok=2 errs=3 (1/2)
+	all1 = `Totals: req=5 ok=2 errs=3 (1/2) (lat 1.000/2.000/2.000)
 Server: req=8 ok=2 al=1.500 errs=6 (1/1/1/1/1/1) (ev 2/2) 127.0.0.127:53
 Server: req=1 ok=0 al=0.000 errs=1 (0/0/1/0/0/0) (ev 1/0) [::127]:53`
 )
@@ -22,6 +23,10 @@ func TestReporter(t *testing.T) {
 	if !strings.Contains(nm, "Resolver") {
 		t.Error("Name() does not contain the word 'Resolver'", nm)
 	}
+	named, _ := New(Config{ResolvConfPath: "testdata/two.resolv.conf", Name: "two"})
+	if nm := named.Name(); !strings.Contains(nm, "(two)") {
+		t.Error("Name() does not contain Config.Name", nm)
+	}
 
 	st := res.Report(false)
 	if !strings.Contains(st, zero1) {
@@ -29,9 +34,9 @@ func TestReporter(t *testing.T) {
 	}
 
 	res.addServerSuccess(0, true, false, time.Second) // Report successful server responses
-	res.addGeneralSuccess()
+	res.addGeneralSuccess(time.Second)
 	res.addServerSuccess(0, false, true, time.Second*2) // (1+2)/2 - 1.5s latency
-	res.addGeneralSuccess()
+	res.addGeneralSuccess(time.Second * 2)
 
 	res.addServerFailure(0, true, false, sfxExchangeError) // Report all possible errors to force
 	res.addServerFailure(0, false, true, sfxFormatError)   // every counter to tick over from zero
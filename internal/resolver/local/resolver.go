@@ -3,6 +3,7 @@
 package local
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strings"
@@ -10,6 +11,7 @@ import (
 	"time"
 
 	"github.com/markdingo/trustydns/internal/bestserver"
+	"github.com/markdingo/trustydns/internal/latencyhistogram"
 	"github.com/markdingo/trustydns/internal/resolver"
 
 	"github.com/miekg/dns"
@@ -27,6 +29,9 @@ const (
 	gfxArraySize
 )
 
+// gfxNames are the JSON report keys of the general failures array
+var gfxNames = [gfxArraySize]string{"timeout", "maxAttempts"}
+
 // sfx = Server Failure Index into per-best-server error array
 
 type sfxInt int
@@ -41,6 +46,10 @@ const (
 	sfxArraySize
 )
 
+// sfxNames are the JSON report keys of the per-best-server failures array
+var sfxNames = [sfxArraySize]string{"exchangeError", "formatError", "serverFail", "refused",
+	"notImplemented", "other"}
+
 // evx = EVent indeX into per-best-server event array
 const (
 	evxTCPFallback = iota
@@ -48,6 +57,9 @@ const (
 	evxArraySize
 )
 
+// evxNames are the JSON report keys of the per-best-server events array
+var evxNames = [evxArraySize]string{"tcpFallback", "tcpSuperior"}
+
 // DNSClientExchanger is an interface which implements dns.Client.Exchange() - the only dns.Client
 // method used by localresolver. It exists so we can supply a mock dns.Client for testing.
 type DNSClientExchanger interface {
@@ -96,6 +108,7 @@ type resolverStats struct {
 	success      int
 	failures     [gfxArraySize]int
 	totalLatency time.Duration
+	latency      latencyhistogram.Histogram // Resolution latency of all successful Resolve() calls
 }
 
 type local struct {
@@ -106,6 +119,9 @@ type local struct {
 
 	bestServer bestserver.Manager // Tracks which servers are performing well for us
 
+	lgMu     sync.Mutex // Protects lastGood
+	lastGood map[lastGoodKey]*dns.Msg
+
 	mu sync.RWMutex // Protects everything below here
 
 	bsList []*bestServer
@@ -125,6 +141,36 @@ func New(config Config) (*local, error) {
 		return nil, err
 	}
 
+	if t.config.UDPBufferSize == 0 {
+		t.config.UDPBufferSize = DefaultUDPBufferSize
+	}
+	if t.config.UDPBufferSize < 512 || t.config.UDPBufferSize > 65535 {
+		return nil, fmt.Errorf(me+": UDPBufferSize of %d must be in range 512-65535", t.config.UDPBufferSize)
+	}
+
+	if t.config.ParallelQueries < 0 {
+		return nil, fmt.Errorf(me+": ParallelQueries of %d cannot be negative", t.config.ParallelQueries)
+	}
+
+	switch t.config.FailureResponse {
+	case FailureError, FailureServFail, FailureLastKnownGood:
+	default:
+		return nil, fmt.Errorf(me+": Unknown FailureResponse policy %d", t.config.FailureResponse)
+	}
+	if t.config.FailureTTL < 0 {
+		return nil, fmt.Errorf(me+": FailureTTL of %s cannot be negative", t.config.FailureTTL)
+	}
+	if t.config.FailureTTL == 0 {
+		t.config.FailureTTL = DefaultFailureTTL
+	}
+	if t.config.LastKnownGoodEntries < 0 {
+		return nil, fmt.Errorf(me+": LastKnownGoodEntries of %d cannot be negative", t.config.LastKnownGoodEntries)
+	}
+	if t.config.LastKnownGoodEntries == 0 {
+		t.config.LastKnownGoodEntries = DefaultLastKnownGoodEntries
+	}
+	t.lastGood = make(map[lastGoodKey]*dns.Msg)
+
 	if t.config.NewDNSClientExchangerFunc == nil {
 		t.config.NewDNSClientExchangerFunc = defaultNewDNSClientExchangerFunc
 	}
@@ -142,7 +188,7 @@ func New(config Config) (*local, error) {
 	}
 
 	// Construct our best server collection with the traditional bestserver algorithm as that is
-	// intended to mimic res_send semantics.
+	// intended to mimic res_send semantics, unless the caller prefers the hybrid algorithm.
 
 	t.bsList = make([]*bestServer, 0, len(servers))
 	ifList := make([]bestserver.Server, 0, len(servers)) // Need a separate list as go doesn't coerce arrays
@@ -151,7 +197,12 @@ func New(config Config) (*local, error) {
 		t.bsList = append(t.bsList, bs)
 		ifList = append(ifList, bs)
 	}
-	t.bestServer, err = bestserver.NewTraditional(bestserver.TraditionalConfig{}, ifList)
+	if t.config.HybridBestServer {
+		t.bestServer, err = bestserver.NewHybrid(bestserver.HybridConfig{}, ifList)
+	} else {
+		t.bestServer, err = bestserver.NewTraditional(
+			bestserver.TraditionalConfig{ReorderBySuccess: t.config.ReorderBySuccess}, ifList)
+	}
 	if err != nil {
 		return nil, errors.New(me + ":Loading '" + t.config.ResolvConfPath + "' " + err.Error())
 	}
@@ -271,7 +322,29 @@ func (t *local) InBailiwickDomains() (ret []string) {
 	return
 }
 
-// Resolve more or less re-implements res_send(3). Iterate over the best servers until we get an
+// Resolve is ResolveContext without any deadline.
+func (t *local) Resolve(q *dns.Msg, qMeta *resolver.QueryMetaData) (*dns.Msg, *resolver.ResponseMetaData, error) {
+	return t.ResolveContext(context.Background(), q, qMeta)
+}
+
+// ResolveContext resolves via the servers and, if they all fail, applies Config.FailureResponse
+// which may replace the error with a synthetic or previously seen response.
+func (t *local) ResolveContext(ctx context.Context, q *dns.Msg, qMeta *resolver.QueryMetaData) (*dns.Msg, *resolver.ResponseMetaData, error) {
+	r, respMeta, err := t.resolve(ctx, q, qMeta)
+	if err == nil {
+		t.rememberGood(q, r)
+		return r, respMeta, nil
+	}
+
+	if fr := t.failureResponse(q); fr != nil {
+		return fr, &resolver.ResponseMetaData{TransportType: qMeta.TransportType, TransportDuration: 1,
+			PayloadSize: fr.Len()}, nil
+	}
+
+	return nil, nil, err
+}
+
+// resolve more or less re-implements res_send(3). Iterate over the best servers until we get an
 // acceptable response or run out of attempts or time.
 //
 // If the response indicates a TCP fallback (rcode=0, truncated=true) then re-exchange the same
@@ -280,7 +353,25 @@ func (t *local) InBailiwickDomains() (ret []string) {
 // in this case but they could all fail or this could be the last chance we have due to retry limits
 // or timeouts. I guess it's a question of how aggressive to be in getting a good response. Arguably
 // we should hold on to a TC=1 as a potential response unless we get something better.
-func (t *local) Resolve(q *dns.Msg, qMeta *resolver.QueryMetaData) (*dns.Msg, *resolver.ResponseMetaData, error) {
+//
+// If Config.ParallelQueries is greater than one, resolution is handed off to resolveParallel().
+//
+// An individual exchange cannot be interrupted as DNSClientExchanger has no such capability, so ctx
+// is checked between attempts and stops any further iteration once it is done.
+func (t *local) resolve(ctx context.Context, q *dns.Msg, qMeta *resolver.QueryMetaData) (*dns.Msg, *resolver.ResponseMetaData, error) {
+	// Advertise our own UDP buffer size rather than whatever the client offered us. Take a copy
+	// first as the query belongs to the caller. Non-EDNS0 queries are left alone as their
+	// responses are limited to 512 bytes regardless.
+
+	if opt := q.IsEdns0(); opt != nil && int(opt.UDPSize()) != t.config.UDPBufferSize {
+		q = q.Copy()
+		q.IsEdns0().SetUDPSize(uint16(t.config.UDPBufferSize))
+	}
+
+	if t.config.ParallelQueries > 1 && t.bestServer.Len() > 1 {
+		return t.resolveParallel(ctx, q, qMeta)
+	}
+
 	timeAvailable := time.Second * time.Duration(t.resolverConfig.Timeout) // How long have we got?
 	var timeUsed time.Duration
 	respMeta := &resolver.ResponseMetaData{TransportType: qMeta.TransportType}
@@ -296,102 +387,198 @@ func (t *local) Resolve(q *dns.Msg, qMeta *resolver.QueryMetaData) (*dns.Msg, *r
 	for attempts := 1; attempts <= maxAttempts; attempts++ {
 		respMeta.ServerTries++
 		server, bsix := t.bestServer.Best()
-		respMeta.FinalServerUsed = server.Name()          // Set response metadata in
-		respMeta.TransportType = resolver.DNSTransportUDP // happy anticipation of success.
-		respMeta.QueryTries++
-		r, rtt, err := exchanger.Exchange(q, server.Name())
-		tcpFallback := false
-		tcpSuperior := false
-		if err == nil && r.Rcode == dns.RcodeSuccess && r.Truncated { // Fall back to TCP?
-			tcpFallback = true
-			tcpExchanger := t.config.NewDNSClientExchangerFunc("tcp")
-			respMeta.QueryTries++
-			tcpReply, tcpRtt, tcpErr := tcpExchanger.Exchange(q, server.Name())
-			if tcpErr == nil && tcpReply.Rcode == dns.RcodeSuccess { // Superior to UDP?
-				tcpSuperior = true // TCP reply is superior to the UDP reply, so prefer it
-				r = tcpReply
-				respMeta.TransportType = resolver.DNSTransportTCP // Report successful transport
-			}
-			rtt += tcpRtt // Treat as one big fat query for stats purposes
-		}
+		respMeta.FinalServerUsed = server.Name() // Set response metadata in happy anticipation of success
+		xr := t.exchange(exchanger, q, server.Name())
+		respMeta.QueryTries += xr.queries
+		respMeta.TransportType = xr.transport
 
-		// We want to know three things about the query: 1) whether it was "successful" in
-		// the bestServer sense; 2) whether the response was an interesting error worthy of
-		// tracking in our stats and 3) whether the resolution loop should iterate and retry
-		// or stop and return to the caller.
-		//
-		// Iteration on error depends on whether the error can be attributed to the query or
-		// the server. If the former, iteration stops. If the latter, iteration
-		// continues. In some cases our definition of a server-failure vs a query-failure
-		// differs from the standard libc implementation. E.g. Not Implemented is considered
-		// a per-server error as each server could be running a different implementation.
-
-		var bsSuccess bool  // Best Server success
-		var sfx sfxInt = -1 // Worthy stats index if GE zero
-		var iterate bool    // Loop around and retry (within retry limits)
-
-		switch {
-		case err != nil: // packet exchange failed. Assume a network or server issue.
-			bsSuccess = false // Tell bestServer to demote
-			sfx = sfxExchangeError
-			iterate = true // Iterate on a server issue
-
-		case r.Rcode == dns.RcodeSuccess:
-			bsSuccess = true
-			iterate = false
-
-		case r.Rcode == dns.RcodeFormatError: // Assume query is bogus so stop iterating
-			bsSuccess = true
-			sfx = sfxFormatError
-			iterate = false
-
-		case r.Rcode == dns.RcodeServerFailure: // Assume server-specific issue
-			bsSuccess = false
-			sfx = sfxServerFail
-			iterate = true
-
-		case r.Rcode == dns.RcodeNameError: // NXDomain is actually a good return!
-			bsSuccess = true
-			iterate = false
-
-		case r.Rcode == dns.RcodeRefused: // Assume a server access control issue
-			bsSuccess = false
-			sfx = sfxRefused
-			iterate = true
-
-		case r.Rcode == dns.RcodeNotImplemented: // Assume server-specific
-			bsSuccess = true
-			sfx = sfxNotImplemented
-			iterate = true
-
-		default: // All other Rcodes are returned to the caller
-			bsSuccess = true
-			sfx = sfxOther
-			iterate = false
-		}
-
-		// Switch has set bsSuccess, iterate and sfx
-
-		timeUsed += rtt
-		t.bestServer.Result(server, bsSuccess, time.Now(), rtt)
-		if sfx == -1 {
-			t.addServerSuccess(bsix, tcpFallback, tcpSuperior, rtt)
-		} else {
-			t.addServerFailure(bsix, tcpFallback, tcpSuperior, sfx)
-		}
+		iterate := t.recordExchange(server, bsix, xr)
+		timeUsed += xr.rtt
 		if !iterate {
-			t.addGeneralSuccess()
 			respMeta.ResolutionDuration = timeUsed
-			respMeta.PayloadSize = r.Len()
-			return r, respMeta, nil
+			t.addGeneralSuccess(respMeta.ResolutionDuration)
+			respMeta.PayloadSize = xr.reply.Len()
+			return xr.reply, respMeta, nil
 		}
 
 		if timeUsed > timeAvailable { // Run out of time to iterate?
 			t.addGeneralFailure(gfxTimeout)
 			return nil, nil, fmt.Errorf(me+": Query timeout: %ds", t.resolverConfig.Timeout)
 		}
+		if err := ctx.Err(); err != nil { // Caller has run out of time?
+			t.addGeneralFailure(gfxTimeout)
+			return nil, nil, fmt.Errorf(me+": Query abandoned: %s", err.Error())
+		}
 	}
 
 	t.addGeneralFailure(gfxMaxAttempts)
 	return nil, nil, fmt.Errorf(me+":Query attempts exceeded: %d", t.resolverConfig.Attempts)
 }
+
+// resolveParallel sends the query to the top Config.ParallelQueries servers simultaneously and
+// returns the first acceptable response, much like happy eyeballs. Every exchange is reported to
+// bestServer and the stats as it completes, including those that lose the race, so the latency of
+// all servers continues to be tracked. The losers cannot be cancelled as the Exchanger interface
+// has no such capability, so their responses are simply discarded.
+//
+// The "top" servers are the first in bestServer.Order(), which mirrors the order in which the serial
+// loop would have tried them, including any ReorderBySuccess re-sorting.
+func (t *local) resolveParallel(ctx context.Context, q *dns.Msg, qMeta *resolver.QueryMetaData) (*dns.Msg, *resolver.ResponseMetaData, error) {
+	timeAvailable := time.Second * time.Duration(t.resolverConfig.Timeout)
+	respMeta := &resolver.ResponseMetaData{TransportType: qMeta.TransportType}
+	respMeta.TransportDuration = 1
+
+	servers := t.bestServer.Servers()
+	order := t.bestServer.Order()
+	fanout := t.config.ParallelQueries
+	if fanout > len(order) {
+		fanout = len(order)
+	}
+
+	type parallelResult struct {
+		server  string
+		xr      exchangeResult
+		iterate bool
+	}
+	results := make(chan parallelResult, fanout) // Buffered so losers never block
+	startTime := time.Now()
+	for _, six := range order[:fanout] {
+		six := six
+		server := servers[six]
+		go func() {
+			xr := t.exchange(t.config.NewDNSClientExchangerFunc(""), q, server.Name())
+			results <- parallelResult{server.Name(), xr, t.recordExchange(server, six, xr)}
+		}()
+	}
+
+	timer := time.NewTimer(timeAvailable)
+	defer timer.Stop()
+	for received := 0; received < fanout; received++ {
+		select {
+		case pr := <-results:
+			respMeta.ServerTries++
+			respMeta.QueryTries += pr.xr.queries
+			if pr.iterate {
+				continue
+			}
+			respMeta.FinalServerUsed = pr.server
+			respMeta.TransportType = pr.xr.transport
+			respMeta.ResolutionDuration = time.Since(startTime)
+			t.addGeneralSuccess(respMeta.ResolutionDuration)
+			respMeta.PayloadSize = pr.xr.reply.Len()
+			return pr.xr.reply, respMeta, nil
+
+		case <-timer.C:
+			t.addGeneralFailure(gfxTimeout)
+			return nil, nil, fmt.Errorf(me+": Query timeout: %ds", t.resolverConfig.Timeout)
+
+		case <-ctx.Done():
+			t.addGeneralFailure(gfxTimeout)
+			return nil, nil, fmt.Errorf(me+": Query abandoned: %s", ctx.Err().Error())
+		}
+	}
+
+	t.addGeneralFailure(gfxMaxAttempts)
+	return nil, nil, fmt.Errorf(me+":Query attempts exceeded: %d parallel", fanout)
+}
+
+// exchangeResult is returned by exchange() to describe the outcome of a query to one server.
+type exchangeResult struct {
+	reply       *dns.Msg
+	rtt         time.Duration
+	err         error
+	queries     int // Number of queries sent - two if TCP fallback occurred
+	transport   resolver.DNSTransportType
+	tcpFallback bool
+	tcpSuperior bool
+}
+
+// exchange sends the query to a single server and falls back to TCP if the UDP response is
+// truncated.
+func (t *local) exchange(exchanger DNSClientExchanger, q *dns.Msg, server string) (xr exchangeResult) {
+	xr.transport = resolver.DNSTransportUDP
+	xr.queries++
+	xr.reply, xr.rtt, xr.err = exchanger.Exchange(q, server)
+	if xr.err == nil && xr.reply.Rcode == dns.RcodeSuccess && xr.reply.Truncated { // Fall back to TCP?
+		xr.tcpFallback = true
+		tcpExchanger := t.config.NewDNSClientExchangerFunc("tcp")
+		xr.queries++
+		tcpReply, tcpRtt, tcpErr := tcpExchanger.Exchange(q, server)
+		if tcpErr == nil && tcpReply.Rcode == dns.RcodeSuccess { // Superior to UDP?
+			xr.tcpSuperior = true // TCP reply is superior to the UDP reply, so prefer it
+			xr.reply = tcpReply
+			xr.transport = resolver.DNSTransportTCP // Report successful transport
+		}
+		xr.rtt += tcpRtt // Treat as one big fat query for stats purposes
+	}
+
+	return
+}
+
+// recordExchange reports the outcome of an exchange to bestServer and the stats. It returns true if
+// the caller should iterate and try another server.
+//
+// We want to know three things about the query: 1) whether it was "successful" in the bestServer
+// sense; 2) whether the response was an interesting error worthy of tracking in our stats and 3)
+// whether the resolution loop should iterate and retry or stop and return to the caller.
+//
+// Iteration on error depends on whether the error can be attributed to the query or the server. If
+// the former, iteration stops. If the latter, iteration continues. In some cases our definition of
+// a server-failure vs a query-failure differs from the standard libc implementation. E.g. Not
+// Implemented is considered a per-server error as each server could be running a different
+// implementation.
+func (t *local) recordExchange(server bestserver.Server, bsix int, xr exchangeResult) (iterate bool) {
+	var bsSuccess bool  // Best Server success
+	var sfx sfxInt = -1 // Worthy stats index if GE zero
+
+	switch {
+	case xr.err != nil: // packet exchange failed. Assume a network or server issue.
+		bsSuccess = false // Tell bestServer to demote
+		sfx = sfxExchangeError
+		iterate = true // Iterate on a server issue
+
+	case xr.reply.Rcode == dns.RcodeSuccess:
+		bsSuccess = true
+		iterate = false
+
+	case xr.reply.Rcode == dns.RcodeFormatError: // Assume query is bogus so stop iterating
+		bsSuccess = true
+		sfx = sfxFormatError
+		iterate = false
+
+	case xr.reply.Rcode == dns.RcodeServerFailure: // Assume server-specific issue
+		bsSuccess = false
+		sfx = sfxServerFail
+		iterate = true
+
+	case xr.reply.Rcode == dns.RcodeNameError: // NXDomain is actually a good return!
+		bsSuccess = true
+		iterate = false
+
+	case xr.reply.Rcode == dns.RcodeRefused: // Assume a server access control issue
+		bsSuccess = false
+		sfx = sfxRefused
+		iterate = true
+
+	case xr.reply.Rcode == dns.RcodeNotImplemented: // Assume server-specific
+		bsSuccess = true
+		sfx = sfxNotImplemented
+		iterate = true
+
+	default: // All other Rcodes are returned to the caller
+		bsSuccess = true
+		sfx = sfxOther
+		iterate = false
+	}
+
+	// Switch has set bsSuccess, iterate and sfx
+
+	t.bestServer.Result(server, bsSuccess, time.Now(), xr.rtt)
+	if sfx == -1 {
+		t.addServerSuccess(bsix, xr.tcpFallback, xr.tcpSuperior, xr.rtt)
+	} else {
+		t.addServerFailure(bsix, xr.tcpFallback, xr.tcpSuperior, sfx)
+	}
+
+	return
+}
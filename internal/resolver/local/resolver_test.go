@@ -1,11 +1,15 @@
 package local
 
 import (
+	"context"
 	"errors"
+	"net"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/markdingo/trustydns/internal/bestserver"
 	"github.com/markdingo/trustydns/internal/resolver"
 
 	"github.com/miekg/dns"
@@ -17,6 +21,15 @@ func TestNew(t *testing.T) {
 		t.Error("New() failed which it should have succeeded", err)
 	}
 
+	if res.bestServer.Algorithm() != bestserver.TraditionalAlgorithm {
+		t.Error("New() should default to the traditional algorithm, not", res.bestServer.Algorithm())
+	}
+
+	res, err = New(Config{ResolvConfPath: "testdata/resolv.conf", HybridBestServer: true})
+	if err != nil || res.bestServer.Algorithm() != bestserver.HybridAlgorithm {
+		t.Error("New() should have used the hybrid algorithm", err)
+	}
+
 	res, err = New(Config{ResolvConfPath: ""})
 	if err == nil {
 		t.Error("New() did not failed with an empty path")
@@ -146,8 +159,9 @@ type mockResponse struct {
 }
 
 type mockExchanger struct {
-	ix       int // Next response to return
-	response []mockResponse
+	ix        int // Next response to return
+	response  []mockResponse
+	lastQuery *dns.Msg
 }
 
 func (me *mockExchanger) append(reply *dns.Msg, duration time.Duration, err error) {
@@ -160,6 +174,7 @@ func (me *mockExchanger) Exchange(query *dns.Msg, server string) (reply *dns.Msg
 		return nil, 0, errors.New("Test setup probably bogus as exchange count exceeded")
 	}
 	me.ix++
+	me.lastQuery = query
 	return me.response[ix].reply, me.response[ix].duration, me.response[ix].err
 }
 
@@ -204,6 +219,40 @@ func TestBasicResolver(t *testing.T) {
 	}
 }
 
+func TestUDPBufferSize(t *testing.T) {
+	for _, bad := range []int{-1, 511, 65536} {
+		_, err := New(Config{ResolvConfPath: "testdata/resolv.conf", UDPBufferSize: bad})
+		if err == nil {
+			t.Error("Expected New to reject UDPBufferSize of", bad)
+		}
+	}
+
+	for _, tc := range []struct{ config, expect int }{{0, DefaultUDPBufferSize}, {4096, 4096}} {
+		mock := newMockOne(&dns.Msg{}, time.Millisecond, nil)
+		res, err := New(Config{ResolvConfPath: "testdata/resolv.conf", UDPBufferSize: tc.config,
+			NewDNSClientExchangerFunc: func(string) DNSClientExchanger { return mock }})
+		if err != nil {
+			t.Fatal("New failed with UDPBufferSize", tc.config, err)
+		}
+		q := &dns.Msg{}
+		q.SetQuestion("example.net.", dns.TypeA)
+		q.SetEdns0(512, false)
+		_, _, err = res.Resolve(q, qMeta)
+		if err != nil {
+			t.Fatal("Mock Exchanger failed", err)
+		}
+		if mock.lastQuery == nil || mock.lastQuery.IsEdns0() == nil {
+			t.Fatal("Exchanger did not receive an EDNS0 query")
+		}
+		if got := int(mock.lastQuery.IsEdns0().UDPSize()); got != tc.expect {
+			t.Error("Outbound UDPSize should be", tc.expect, "not", got)
+		}
+		if q.IsEdns0().UDPSize() != 512 {
+			t.Error("Resolve modified the caller's query", q.IsEdns0())
+		}
+	}
+}
+
 func TestNXDomain(t *testing.T) {
 	res, err := New(Config{ResolvConfPath: "testdata/resolv.conf",
 		NewDNSClientExchangerFunc: func(string) DNSClientExchanger {
@@ -275,6 +324,29 @@ func TestTimeout(t *testing.T) {
 	}
 }
 
+// Test that a done ctx stops iteration over servers
+func TestResolveContext(t *testing.T) {
+	res, err := New(Config{ResolvConfPath: "testdata/resolv.conf",
+		NewDNSClientExchangerFunc: func(string) DNSClientExchanger {
+			return newMockOne(nil, time.Millisecond, errors.New("Mock Exchange Error"))
+		}})
+	if err != nil {
+		t.Fatal("New failed with mock Exchanger", err)
+	}
+
+	_, _, err = res.Resolve(&dns.Msg{}, qMeta)
+	if err == nil || !strings.Contains(err.Error(), "attempts exceeded") {
+		t.Error("Expected all attempts to be made without a ctx, got", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err = res.ResolveContext(ctx, &dns.Msg{}, qMeta)
+	if err == nil || !strings.Contains(err.Error(), "abandoned") {
+		t.Error("Expected abandoned error with a done ctx, got", err)
+	}
+}
+
 // Test for rcode == refused moves best server to next
 func TestRcodeRefused(t *testing.T) {
 	res, err := New(Config{ResolvConfPath: "testdata/resolv.conf",
@@ -495,3 +567,262 @@ func TestResolveFallback(t *testing.T) {
 		t.Error("Wrong message length returned. Expected", r0.Len(), "got", meta)
 	}
 }
+
+// serverExchanger is a concurrency-safe mock which returns a response based on the server name
+// after an optional delay.
+type serverExchanger struct {
+	mu      sync.Mutex
+	delay   map[string]time.Duration
+	rcode   map[string]int
+	queried map[string]int
+}
+
+func (se *serverExchanger) Exchange(query *dns.Msg, server string) (*dns.Msg, time.Duration, error) {
+	se.mu.Lock()
+	se.queried[server]++
+	delay := se.delay[server]
+	rcode, ok := se.rcode[server]
+	se.mu.Unlock()
+
+	time.Sleep(delay)
+	if !ok {
+		return nil, delay, errors.New("serverExchanger has no response for " + server)
+	}
+	r := &dns.Msg{}
+	r.SetReply(query)
+	r.Rcode = rcode
+
+	return r, delay, nil
+}
+
+func TestResolveParallel(t *testing.T) {
+	_, err := New(Config{ResolvConfPath: "testdata/two.resolv.conf", ParallelQueries: -1})
+	if err == nil {
+		t.Error("Expected New to reject negative ParallelQueries")
+	}
+
+	se := &serverExchanger{
+		delay:   map[string]time.Duration{"127.0.0.127:53": time.Millisecond * 100},
+		rcode:   map[string]int{"127.0.0.127:53": dns.RcodeSuccess, "[::127]:53": dns.RcodeServerFailure},
+		queried: make(map[string]int)}
+	res, err := New(Config{ResolvConfPath: "testdata/two.resolv.conf", ParallelQueries: 2,
+		NewDNSClientExchangerFunc: func(string) DNSClientExchanger { return se }})
+	if err != nil {
+		t.Fatal("New failed with ParallelQueries", err)
+	}
+
+	q := &dns.Msg{}
+	q.SetQuestion("example.net.", dns.TypeA)
+	r, rMeta, err := res.Resolve(q, qMeta)
+	if err != nil {
+		t.Fatal("Parallel Resolve failed", err)
+	}
+	if r.Rcode != dns.RcodeSuccess {
+		t.Error("Parallel Resolve should have ignored the SERVFAIL", r.MsgHdr)
+	}
+	if rMeta.FinalServerUsed != "127.0.0.127:53" || rMeta.ServerTries != 2 {
+		t.Error("Parallel Resolve meta data is wrong", rMeta)
+	}
+	if se.queried["127.0.0.127:53"] != 1 || se.queried["[::127]:53"] != 1 {
+		t.Error("Parallel Resolve did not query both servers once", se.queried)
+	}
+
+	// Make both servers fail
+	se.mu.Lock()
+	se.rcode["127.0.0.127:53"] = dns.RcodeRefused
+	se.mu.Unlock()
+	_, _, err = res.Resolve(q, qMeta)
+	if err == nil {
+		t.Fatal("Expected Parallel Resolve to fail when all servers fail")
+	}
+	if !strings.Contains(err.Error(), "attempts exceeded") {
+		t.Error("Unexpected error from failed Parallel Resolve", err)
+	}
+}
+
+// Parallel queries should go to the top servers in the re-sorted order, not the resolv.conf order.
+func TestResolveParallelReordered(t *testing.T) {
+	se := &serverExchanger{rcode: make(map[string]int), queried: make(map[string]int)}
+	res, err := New(Config{ResolvConfPath: "testdata/resolv.conf", ParallelQueries: 2,
+		NewDNSClientExchangerFunc: func(string) DNSClientExchanger { return se }})
+	if err != nil {
+		t.Fatal("Setup failed", err)
+	}
+	servers := res.bestServer.Servers()
+	for _, s := range servers {
+		se.rcode[s.Name()] = dns.RcodeSuccess
+	}
+	res.bestServer, err = bestserver.NewTraditional(bestserver.TraditionalConfig{ReorderBySuccess: true,
+		ReorderInterval: time.Second}, servers)
+	if err != nil {
+		t.Fatal("Setup failed", err)
+	}
+
+	// Re-sort so that the order is 10.0.0.1, 10.0.0.3, 192.168.1.1, 10.0.0.2
+
+	now := time.Now()
+	res.bestServer.Result(servers[0], false, now, 0)
+	res.bestServer.Result(servers[2], false, now.Add(time.Second), 0)
+	if got := res.bestServer.Order(); got[0] != 1 || got[1] != 3 {
+		t.Fatal("Setup did not re-sort servers", got)
+	}
+
+	q := &dns.Msg{}
+	q.SetQuestion("example.net.", dns.TypeA)
+	if _, _, err := res.Resolve(q, qMeta); err != nil {
+		t.Fatal("Parallel Resolve failed", err)
+	}
+	time.Sleep(time.Millisecond * 50) // Let the loser complete
+	se.mu.Lock()
+	defer se.mu.Unlock()
+	if len(se.queried) != 2 || se.queried["10.0.0.1:53"] != 1 || se.queried["10.0.0.3:53"] != 1 {
+		t.Error("Parallel Resolve did not query the top two re-sorted servers", se.queried)
+	}
+}
+
+// failingExchanger succeeds with reply unless fail is set.
+type failingExchanger struct {
+	reply *dns.Msg
+	fail  bool
+}
+
+func (fe *failingExchanger) Exchange(query *dns.Msg, server string) (*dns.Msg, time.Duration, error) {
+	if fe.fail {
+		return nil, time.Millisecond, errors.New("Mock exchange failed")
+	}
+	r := fe.reply.Copy()
+	r.SetReply(query)
+	r.Answer = fe.reply.Answer
+
+	return r, time.Millisecond, nil
+}
+
+func TestFailureResponse(t *testing.T) {
+	for _, c := range []Config{{FailureResponse: FailurePolicy(99)}, {FailureTTL: -time.Second},
+		{LastKnownGoodEntries: -1}} {
+		c.ResolvConfPath = "testdata/resolv.conf"
+		if _, err := New(c); err == nil {
+			t.Error("Expected New() to reject", c)
+		}
+	}
+
+	good := &dns.Msg{}
+	rr, _ := dns.NewRR("example.net. 3600 IN A 192.0.2.1")
+	good.Answer = append(good.Answer, rr)
+
+	q := &dns.Msg{}
+	q.SetQuestion("Example.NET.", dns.TypeA)
+	q.SetEdns0(1232, false)
+
+	testCases := []struct {
+		policy  FailurePolicy
+		primed  bool // Resolve successfully before failing
+		isErr   bool
+		rcode   int
+		answers int
+	}{
+		{FailureError, true, true, 0, 0},
+		{FailureServFail, true, false, dns.RcodeServerFailure, 0},
+		{FailureLastKnownGood, false, false, dns.RcodeServerFailure, 0},
+		{FailureLastKnownGood, true, false, dns.RcodeSuccess, 1},
+	}
+
+	for ix, tc := range testCases {
+		fe := &failingExchanger{reply: good}
+		res, err := New(Config{ResolvConfPath: "testdata/resolv.conf", FailureResponse: tc.policy,
+			NewDNSClientExchangerFunc: func(string) DNSClientExchanger { return fe }})
+		if err != nil {
+			t.Fatal(ix, err)
+		}
+		if tc.primed {
+			pq := &dns.Msg{}
+			pq.SetQuestion("example.net.", dns.TypeA)
+			if _, _, err := res.Resolve(pq, qMeta); err != nil {
+				t.Fatal(ix, "Priming failed", err)
+			}
+		}
+		fe.fail = true
+		r, _, err := res.Resolve(q, qMeta)
+		if tc.isErr {
+			if err == nil {
+				t.Error(ix, "Expected an error return")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(ix, "Unexpected error", err)
+		}
+		if r.Id != q.Id || r.Rcode != tc.rcode || len(r.Answer) != tc.answers {
+			t.Error(ix, "Wrong response", r)
+			continue
+		}
+		if tc.answers > 0 && r.Answer[0].Header().Ttl != uint32(DefaultFailureTTL/time.Second) {
+			t.Error(ix, "TTL not capped", r.Answer[0])
+		}
+		if tc.rcode == dns.RcodeServerFailure {
+			if opt := r.IsEdns0(); opt == nil || len(opt.Option) != 1 {
+				t.Error(ix, "Expected an EDE in the SERVFAIL", r)
+			}
+		}
+	}
+	if good.Answer[0].Header().Ttl != 3600 {
+		t.Error("Original response TTL was modified", good.Answer[0])
+	}
+}
+
+func TestLastKnownGoodLimit(t *testing.T) {
+	fe := &failingExchanger{reply: &dns.Msg{}}
+	res, err := New(Config{ResolvConfPath: "testdata/resolv.conf", FailureResponse: FailureLastKnownGood,
+		LastKnownGoodEntries: 2, NewDNSClientExchangerFunc: func(string) DNSClientExchanger { return fe }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []string{"a.example.", "b.example.", "c.example.", "c.example."} {
+		q := &dns.Msg{}
+		q.SetQuestion(n, dns.TypeA)
+		res.Resolve(q, qMeta)
+	}
+	if len(res.lastGood) != 2 {
+		t.Error("Expected lastGood to be limited to 2, not", len(res.lastGood))
+	}
+}
+
+// Last known good responses must not leak across ECS subnets or between DO=0 and DO=1 queries
+func TestLastGoodKey(t *testing.T) {
+	newQ := func(name string, do bool, ecs string, netmask uint8) *dns.Msg {
+		q := &dns.Msg{}
+		q.SetQuestion(name, dns.TypeA)
+		if do || len(ecs) > 0 {
+			q.SetEdns0(1232, do)
+		}
+		if len(ecs) > 0 {
+			opt := q.IsEdns0()
+			opt.Option = append(opt.Option, &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1,
+				SourceNetmask: netmask, Address: net.ParseIP(ecs)})
+		}
+		return q
+	}
+	base, _ := newLastGoodKey(newQ("example.net.", false, "", 0))
+	testCases := []struct {
+		q    *dns.Msg
+		same bool
+	}{
+		{newQ("Example.NET.", false, "", 0), true},
+		{newQ("example.net.", true, "", 0), false},
+		{newQ("example.net.", false, "192.0.2.1", 24), false},
+		{newQ("example.net.", false, "192.0.2.0", 0), true}, // Zero source prefix is anonymous
+	}
+	for ix, tc := range testCases {
+		key, ok := newLastGoodKey(tc.q)
+		if !ok || (key == base) != tc.same {
+			t.Error(ix, "Key comparison wrong", base, key)
+		}
+	}
+
+	k1, _ := newLastGoodKey(newQ("example.net.", false, "192.0.2.1", 24))
+	k2, _ := newLastGoodKey(newQ("example.net.", false, "192.0.2.99", 24))
+	k3, _ := newLastGoodKey(newQ("example.net.", false, "198.51.100.1", 24))
+	if k1 != k2 || k1 == k3 {
+		t.Error("ECS keys should only match within the same subnet", k1, k2, k3)
+	}
+}
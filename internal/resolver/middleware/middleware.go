@@ -0,0 +1,95 @@
+/*
+Package middleware (aka internal/resolver/middleware) is a resolver which calls a chain of hooks
+before and after passing each query to another resolver. It provides a way of adding logging,
+metrics and query or response rewriting to any resolver without modifying it.
+
+Hooks are called in the order supplied to Wrap() prior to resolution and in reverse order after
+resolution so that the first hook sees the query first and the response last, i.e:
+
+	r := middleware.Wrap(next, timing, rewrite)
+
+results in timing.OnQuery, rewrite.OnQuery, next.Resolve, rewrite.OnResponse, timing.OnResponse.
+*/
+package middleware
+
+import (
+	"context"
+	"github.com/markdingo/trustydns/internal/resolver"
+
+	"github.com/miekg/dns"
+)
+
+// Hook is implemented by anything which wants to see or modify queries and responses. OnQuery may
+// modify the query and OnResponse may modify the response and its meta data. OnResponse is only
+// called if resolution succeeds.
+type Hook interface {
+	OnQuery(query *dns.Msg)
+	OnResponse(resp *dns.Msg, respMeta *resolver.ResponseMetaData)
+}
+
+// Funcs is a convenience Hook for callers which only need a simple function. Either function may
+// be nil.
+type Funcs struct {
+	Query    func(query *dns.Msg)
+	Response func(resp *dns.Msg, respMeta *resolver.ResponseMetaData)
+}
+
+// OnQuery implements the Hook interface
+func (t Funcs) OnQuery(query *dns.Msg) {
+	if t.Query != nil {
+		t.Query(query)
+	}
+}
+
+// OnResponse implements the Hook interface
+func (t Funcs) OnResponse(resp *dns.Msg, respMeta *resolver.ResponseMetaData) {
+	if t.Response != nil {
+		t.Response(resp, respMeta)
+	}
+}
+
+type middleware struct {
+	next  resolver.Resolver
+	hooks []Hook
+}
+
+// Wrap returns a resolver which calls hooks around every call to next.Resolve(). If there are no
+// hooks, next is returned unchanged.
+func Wrap(next resolver.Resolver, hooks ...Hook) resolver.Resolver {
+	if len(hooks) == 0 {
+		return next
+	}
+
+	return &middleware{next: next, hooks: append([]Hook{}, hooks...)}
+}
+
+// InBailiwick defers to the wrapped resolver. Note that it sees the qName prior to any rewriting
+// by OnQuery hooks.
+func (t *middleware) InBailiwick(qName string) bool {
+	return t.next.InBailiwick(qName)
+}
+
+// Resolve is ResolveContext without any deadline.
+func (t *middleware) Resolve(q *dns.Msg, qMeta *resolver.QueryMetaData) (*dns.Msg, *resolver.ResponseMetaData, error) {
+	return t.ResolveContext(context.Background(), q, qMeta)
+}
+
+// ResolveContext calls the OnQuery hooks with a copy of the query so that the caller's query is
+// never modified, resolves the possibly modified copy, then calls the OnResponse hooks.
+func (t *middleware) ResolveContext(ctx context.Context, q *dns.Msg, qMeta *resolver.QueryMetaData) (*dns.Msg, *resolver.ResponseMetaData, error) {
+	q = q.Copy()
+	for _, h := range t.hooks {
+		h.OnQuery(q)
+	}
+
+	resp, respMeta, err := resolver.ResolveContext(ctx, t.next, q, qMeta)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for ix := len(t.hooks) - 1; ix >= 0; ix-- {
+		t.hooks[ix].OnResponse(resp, respMeta)
+	}
+
+	return resp, respMeta, nil
+}
@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/markdingo/trustydns/internal/resolver"
+
+	"github.com/miekg/dns"
+)
+
+// mockResolver echoes the query as the response or fails
+type mockResolver struct {
+	fail  bool
+	query *dns.Msg // As seen by Resolve()
+}
+
+func (t *mockResolver) InBailiwick(qName string) bool {
+	return qName == "example.net."
+}
+
+func (t *mockResolver) Resolve(q *dns.Msg, qMeta *resolver.QueryMetaData) (*dns.Msg, *resolver.ResponseMetaData, error) {
+	t.query = q
+	if t.fail {
+		return nil, nil, errors.New("Mock failure")
+	}
+	resp := &dns.Msg{}
+	resp.SetReply(q)
+
+	return resp, &resolver.ResponseMetaData{FinalServerUsed: "mock"}, nil
+}
+
+// tracer records the order in which hooks are called
+type tracer struct {
+	name  string
+	trace *[]string
+}
+
+func (t *tracer) OnQuery(query *dns.Msg) {
+	*t.trace = append(*t.trace, t.name+"Q")
+}
+
+func (t *tracer) OnResponse(resp *dns.Msg, respMeta *resolver.ResponseMetaData) {
+	*t.trace = append(*t.trace, t.name+"R")
+}
+
+func TestOrder(t *testing.T) {
+	var trace []string
+	mr := &mockResolver{}
+	r := Wrap(mr, &tracer{"a", &trace}, &tracer{"b", &trace})
+	if !r.InBailiwick("example.net.") || r.InBailiwick("example.org.") {
+		t.Error("InBailiwick not passed thru to wrapped resolver")
+	}
+	q := &dns.Msg{}
+	q.SetQuestion("example.net.", dns.TypeA)
+	_, _, err := r.Resolve(q, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(trace, ","); got != "aQ,bQ,bR,aR" {
+		t.Error("Hooks called in wrong order", got)
+	}
+
+	trace = nil
+	mr.fail = true
+	_, _, err = r.Resolve(q, nil)
+	if err == nil {
+		t.Error("Expected error to be returned")
+	}
+	if got := strings.Join(trace, ","); got != "aQ,bQ" {
+		t.Error("OnResponse should not be called on error", got)
+	}
+
+	if Wrap(mr) != mr {
+		t.Error("Wrap without hooks should return the wrapped resolver")
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	mr := &mockResolver{}
+	rewrite := Funcs{
+		Query: func(query *dns.Msg) {
+			query.Question[0].Name = "rewritten.example.net."
+		},
+		Response: func(resp *dns.Msg, respMeta *resolver.ResponseMetaData) {
+			resp.Question[0].Name = "example.net."
+			respMeta.FinalServerUsed += "+hook"
+		},
+	}
+	r := Wrap(mr, rewrite, Funcs{}) // Nil funcs are harmless
+	q := &dns.Msg{}
+	q.SetQuestion("example.net.", dns.TypeA)
+	resp, respMeta, err := r.Resolve(q, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mr.query.Question[0].Name != "rewritten.example.net." {
+		t.Error("OnQuery rewrite not seen by wrapped resolver", mr.query.Question[0].Name)
+	}
+	if q.Question[0].Name != "example.net." {
+		t.Error("Caller's query should not be modified", q.Question[0].Name)
+	}
+	if resp.Question[0].Name != "example.net." || respMeta.FinalServerUsed != "mock+hook" {
+		t.Error("OnResponse rewrite not returned", resp.Question[0].Name, respMeta.FinalServerUsed)
+	}
+}
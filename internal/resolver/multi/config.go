@@ -0,0 +1,24 @@
+package multi
+
+import "time"
+
+// Policy determines the order in which child resolvers are tried.
+type Policy int
+
+const (
+	RoundRobin   Policy = iota // Start with the next child in turn
+	Weighted                   // Start with a child chosen in proportion to Config.Weights
+	FirstHealthy               // Always start with the first child which has not recently failed
+)
+
+// DefaultResetFailedAfter is used if Config.ResetFailedAfter is zero.
+const DefaultResetFailedAfter = time.Minute
+
+// Config is passed to the New() constructor.
+type Config struct {
+	Policy           Policy
+	Weights          []int         // One per child - only used by Weighted
+	ResetFailedAfter time.Duration // A failed child is avoided for this long
+
+	NowFunc func() time.Time // Caller can supply their own clock, normally for testing
+}
@@ -0,0 +1,51 @@
+package multi
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Name implements the reporter interface
+func (t *multi) Name() string {
+	return "Multi Resolver"
+}
+
+// multiReport is a snapshot of the stats shared by Report() and ReportJSON()
+type multiReport struct {
+	Queries   int   `json:"queries"`
+	Failovers int   `json:"failovers"`
+	Failures  int   `json:"failures"`
+	Chosen    []int `json:"chosen"` // Per child in New() order
+}
+
+func (t *multi) snapshot(resetCounters bool) *multiReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	mr := &multiReport{Queries: t.queries, Failovers: t.failovers, Failures: t.failures,
+		Chosen: append([]int{}, t.chosen...)}
+	if resetCounters {
+		t.stats = stats{chosen: make([]int, len(t.children))}
+	}
+
+	return mr
+}
+
+// Report implements the reporter interface
+func (t *multi) Report(resetCounters bool) string {
+	mr := t.snapshot(resetCounters)
+	chosen := make([]string, 0, len(mr.Chosen))
+	for _, v := range mr.Chosen {
+		chosen = append(chosen, strconv.Itoa(v))
+	}
+
+	return fmt.Sprintf("q=%d failover=%d fail=%d (%s)", mr.Queries, mr.Failovers, mr.Failures,
+		strings.Join(chosen, "/"))
+}
+
+// ReportJSON implements the reporter.MetricsReporter interface
+func (t *multi) ReportJSON(resetCounters bool) ([]byte, error) {
+	return json.Marshal(t.snapshot(resetCounters))
+}
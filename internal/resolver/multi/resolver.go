@@ -0,0 +1,224 @@
+/*
+Package multi (aka internal/resolver/multi) is a resolver which spreads queries across a set of child
+resolvers. The Config.Policy determines which child is tried first and the remaining children are
+tried in turn should it fail, thus every policy also provides failover. A child which fails is
+avoided for Config.ResetFailedAfter unless all children have failed.
+
+A typical use is to combine multiple DoH resolvers, each configured for a different provider, into
+the one resolver.Resolver.
+*/
+package multi
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/markdingo/trustydns/internal/resolver"
+
+	"github.com/miekg/dns"
+)
+
+const me = "multiresolver"
+
+type child struct {
+	resolver.Resolver
+	weight   int
+	current  int       // Smooth weighted round-robin state
+	failedAt time.Time // Zero if healthy
+}
+
+type multi struct {
+	config Config
+
+	mu       sync.Mutex // Protects everything below here
+	children []*child
+	next     int // Round-robin index
+	stats
+}
+
+type stats struct {
+	queries   int
+	failovers int   // Queries which needed more than one child
+	failures  int   // Queries for which all children failed
+	chosen    []int // Successful resolutions per child
+}
+
+// New constructs a multi resolver from the children in priority order.
+func New(children []resolver.Resolver, config Config) (*multi, error) {
+	if len(children) == 0 {
+		return nil, fmt.Errorf(me + ": No child resolvers supplied")
+	}
+	switch config.Policy {
+	case RoundRobin, FirstHealthy:
+	case Weighted:
+		if len(config.Weights) != len(children) {
+			return nil, fmt.Errorf(me+": %d weights supplied for %d resolvers",
+				len(config.Weights), len(children))
+		}
+		for _, w := range config.Weights {
+			if w <= 0 {
+				return nil, fmt.Errorf(me+": Weight %d must be greater than zero", w)
+			}
+		}
+	default:
+		return nil, fmt.Errorf(me+": Unknown Policy %d", config.Policy)
+	}
+	if config.ResetFailedAfter == 0 {
+		config.ResetFailedAfter = DefaultResetFailedAfter
+	}
+	if config.NowFunc == nil {
+		config.NowFunc = time.Now
+	}
+
+	t := &multi{config: config}
+	for ix, r := range children {
+		c := &child{Resolver: r, weight: 1}
+		if config.Policy == Weighted {
+			c.weight = config.Weights[ix]
+		}
+		t.children = append(t.children, c)
+	}
+	t.chosen = make([]int, len(children))
+
+	return t, nil
+}
+
+// InBailiwick returns true if any child considers qName in-bailiwick.
+func (t *multi) InBailiwick(qName string) bool {
+	for _, c := range t.children {
+		if c.InBailiwick(qName) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// order returns the indices of the children in the order they should be tried for qName as
+// determined by the policy. Children which have recently failed are moved to the end so that they
+// are only tried as a last resort. Children which do not consider qName in-bailiwick are excluded.
+func (t *multi) order(qName string, now time.Time) []int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var healthy, failed []int
+	for ix, c := range t.children {
+		if !c.InBailiwick(qName) {
+			continue
+		}
+		if !c.failedAt.IsZero() && now.Sub(c.failedAt) < t.config.ResetFailedAfter {
+			failed = append(failed, ix)
+		} else {
+			healthy = append(healthy, ix)
+		}
+	}
+	if len(healthy) > 1 {
+		first := 0
+		switch t.config.Policy {
+		case RoundRobin:
+			first = t.next % len(healthy)
+			t.next++
+		case Weighted:
+			first = t.pickWeighted(healthy)
+		}
+		healthy = append(append([]int{}, healthy[first:]...), healthy[:first]...)
+	}
+
+	return append(healthy, failed...)
+}
+
+// pickWeighted implements smooth weighted round-robin (as used by nginx) over the candidate
+// children and returns the position within candidates of the chosen child. Caller must hold the
+// lock.
+func (t *multi) pickWeighted(candidates []int) int {
+	total := 0
+	best := 0
+	for pos, ix := range candidates {
+		c := t.children[ix]
+		c.current += c.weight
+		total += c.weight
+		if c.current > t.children[candidates[best]].current {
+			best = pos
+		}
+	}
+	t.children[candidates[best]].current -= total
+
+	return best
+}
+
+// Resolve is ResolveContext without any deadline.
+func (t *multi) Resolve(q *dns.Msg, qMeta *resolver.QueryMetaData) (*dns.Msg, *resolver.ResponseMetaData, error) {
+	return t.ResolveContext(context.Background(), q, qMeta)
+}
+
+// ResolveContext tries each child in policy order until one succeeds or ctx is done. The returned
+// ResponseMetaData is that of the successful child with the tries and durations accumulated across
+// all children attempted. As a failed child returns no meta data it is counted as a single query
+// and server try.
+func (t *multi) ResolveContext(ctx context.Context, q *dns.Msg, qMeta *resolver.QueryMetaData) (*dns.Msg, *resolver.ResponseMetaData, error) {
+	qName := ""
+	if len(q.Question) > 0 {
+		qName = q.Question[0].Name
+	}
+	order := t.order(qName, t.config.NowFunc())
+	if len(order) == 0 {
+		return nil, nil, fmt.Errorf(me+": No resolver in bailiwick for %s", qName)
+	}
+
+	agg := resolver.ResponseMetaData{}
+	var lastErr error
+	for attempt, ix := range order {
+		if ctx.Err() != nil { // Caller has given up so don't blame the remaining children
+			lastErr = ctx.Err()
+			break
+		}
+		c := t.children[ix]
+		resp, respMeta, err := resolver.ResolveContext(ctx, c.Resolver, q, qMeta)
+		if err != nil {
+			if ctx.Err() != nil { // Nor the child that was cut short
+				lastErr = err
+				break
+			}
+			lastErr = err
+			agg.QueryTries++
+			agg.ServerTries++
+			t.mu.Lock()
+			c.failedAt = t.config.NowFunc()
+			t.mu.Unlock()
+			continue
+		}
+
+		agg.QueryTries += respMeta.QueryTries
+		agg.ServerTries += respMeta.ServerTries
+		agg.TransportDuration += respMeta.TransportDuration
+		agg.ResolutionDuration += respMeta.ResolutionDuration
+		respMeta.QueryTries = agg.QueryTries
+		respMeta.ServerTries = agg.ServerTries
+		respMeta.TransportDuration = agg.TransportDuration
+		respMeta.ResolutionDuration = agg.ResolutionDuration
+
+		t.mu.Lock()
+		c.failedAt = time.Time{}
+		t.queries++
+		t.chosen[ix]++
+		if attempt > 0 {
+			t.failovers++
+		}
+		t.mu.Unlock()
+
+		return resp, respMeta, nil
+	}
+
+	t.mu.Lock()
+	t.queries++
+	t.failures++
+	t.mu.Unlock()
+
+	if ctx.Err() != nil {
+		return nil, nil, fmt.Errorf(me+": Query abandoned: %s", lastErr.Error())
+	}
+
+	return nil, nil, fmt.Errorf(me+": All %d resolvers failed. Last error: %s", len(order), lastErr.Error())
+}
@@ -0,0 +1,227 @@
+package multi
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/markdingo/trustydns/internal/resolver"
+
+	"github.com/miekg/dns"
+)
+
+// mockResolver answers or fails and counts calls
+type mockResolver struct {
+	name      string
+	bailiwick string // Empty means everything
+	fail      bool
+	calls     int
+}
+
+func (t *mockResolver) InBailiwick(qName string) bool {
+	return len(t.bailiwick) == 0 || strings.HasSuffix(qName, t.bailiwick)
+}
+
+func (t *mockResolver) Resolve(q *dns.Msg, qMeta *resolver.QueryMetaData) (*dns.Msg, *resolver.ResponseMetaData, error) {
+	t.calls++
+	if t.fail {
+		return nil, nil, errors.New(t.name + " failed")
+	}
+
+	return &dns.Msg{}, &resolver.ResponseMetaData{FinalServerUsed: t.name, QueryTries: 1, ServerTries: 1,
+		TransportDuration: time.Millisecond}, nil
+}
+
+func newQuery(qName string) *dns.Msg {
+	q := &dns.Msg{}
+	q.SetQuestion(qName, dns.TypeA)
+
+	return q
+}
+
+func newChildren(names ...string) ([]resolver.Resolver, []*mockResolver) {
+	var rs []resolver.Resolver
+	var mrs []*mockResolver
+	for _, n := range names {
+		mr := &mockResolver{name: n}
+		rs = append(rs, mr)
+		mrs = append(mrs, mr)
+	}
+
+	return rs, mrs
+}
+
+func TestNew(t *testing.T) {
+	rs, _ := newChildren("a", "b")
+	testCases := []struct {
+		children []resolver.Resolver
+		config   Config
+		err      string
+	}{
+		{rs, Config{}, ""},
+		{nil, Config{}, "No child resolvers"},
+		{rs, Config{Policy: Weighted, Weights: []int{1, 2}}, ""},
+		{rs, Config{Policy: Weighted, Weights: []int{1}}, "2 resolvers"},
+		{rs, Config{Policy: Weighted, Weights: []int{1, 0}}, "greater than zero"},
+		{rs, Config{Policy: Policy(99)}, "Unknown Policy"},
+	}
+	for ix, tc := range testCases {
+		_, err := New(tc.children, tc.config)
+		if len(tc.err) == 0 {
+			if err != nil {
+				t.Error(ix, "Unexpected error", err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Error(ix, "Expected error with", tc.err, "got", err)
+		}
+	}
+}
+
+// resolveN returns the FinalServerUsed for n queries
+func resolveN(t *testing.T, m *multi, n int) string {
+	var used []string
+	for ix := 0; ix < n; ix++ {
+		_, respMeta, err := m.Resolve(newQuery("example.net."), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		used = append(used, respMeta.FinalServerUsed)
+	}
+
+	return strings.Join(used, "")
+}
+
+func TestPolicies(t *testing.T) {
+	rs, _ := newChildren("a", "b", "c")
+	testCases := []struct {
+		config Config
+		expect string
+	}{
+		{Config{Policy: RoundRobin}, "abcabc"},
+		{Config{Policy: FirstHealthy}, "aaaaaa"},
+		{Config{Policy: Weighted, Weights: []int{4, 1, 1}}, "aabaca"}, // Smooth so b and c interleave
+	}
+	for ix, tc := range testCases {
+		m, err := New(rs, tc.config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := resolveN(t, m, 6)
+		if got != tc.expect {
+			t.Error(ix, "Expected", tc.expect, "got", got)
+		}
+	}
+}
+
+func TestFailover(t *testing.T) {
+	now := time.Now()
+	rs, mrs := newChildren("a", "b", "c")
+	m, err := New(rs, Config{Policy: FirstHealthy, NowFunc: func() time.Time { return now }})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mrs[0].fail = true
+	_, respMeta, err := m.Resolve(newQuery("example.net."), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if respMeta.FinalServerUsed != "b" {
+		t.Error("Expected failover to b, not", respMeta.FinalServerUsed)
+	}
+
+	// a should now be avoided without being tried
+
+	got := resolveN(t, m, 2)
+	if got != "bb" || mrs[0].calls != 1 {
+		t.Error("Expected recently failed child to be avoided", got, mrs[0].calls)
+	}
+
+	// After ResetFailedAfter a is tried first again
+
+	mrs[0].fail = false
+	now = now.Add(DefaultResetFailedAfter)
+	if got := resolveN(t, m, 1); got != "a" {
+		t.Error("Expected a to be reinstated, got", got)
+	}
+
+	for _, mr := range mrs {
+		mr.fail = true
+	}
+	_, _, err = m.Resolve(newQuery("example.net."), nil)
+	if err == nil || !strings.Contains(err.Error(), "All 3 resolvers failed") {
+		t.Error("Expected all failed error, got", err)
+	}
+
+	rep := m.Report(true)
+	if rep != "q=5 failover=1 fail=1 (1/3/0)" {
+		t.Error("Unexpected report", rep)
+	}
+	if rep = m.Report(false); rep != "q=0 failover=0 fail=0 (0/0/0)" {
+		t.Error("resetCounters did not reset", rep)
+	}
+	if m.Name() != "Multi Resolver" {
+		t.Error("Unexpected Name", m.Name())
+	}
+	if _, err := m.ReportJSON(false); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBailiwickAndMeta(t *testing.T) {
+	rs, mrs := newChildren("a", "b")
+	mrs[0].bailiwick = "example.org."
+	mrs[1].bailiwick = "example.net."
+	m, err := New(rs, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.InBailiwick("www.example.org.") || !m.InBailiwick("www.example.net.") || m.InBailiwick("example.com.") {
+		t.Error("InBailiwick should be the union of the children")
+	}
+	if got := resolveN(t, m, 3); got != "bbb" {
+		t.Error("Expected only the in-bailiwick child to be used, got", got)
+	}
+	if _, _, err := m.Resolve(newQuery("example.com."), nil); err == nil {
+		t.Error("Expected an error when no child is in bailiwick")
+	}
+
+	// Meta data accumulates across failed attempts
+
+	mrs[1].bailiwick = ""
+	mrs[0].bailiwick = ""
+	m, _ = New(rs, Config{Policy: FirstHealthy})
+	mrs[0].fail = true
+	_, respMeta, err := m.Resolve(newQuery("example.net."), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if respMeta.FinalServerUsed != "b" || respMeta.QueryTries != 2 || respMeta.ServerTries != 2 ||
+		respMeta.TransportDuration != time.Millisecond {
+		t.Error("Unexpected meta data", respMeta)
+	}
+}
+
+func TestResolveContext(t *testing.T) {
+	rs, mrs := newChildren("a", "b")
+	m, err := New(rs, Config{Policy: FirstHealthy})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err = m.ResolveContext(ctx, newQuery("example.net."), nil)
+	if err == nil || !strings.Contains(err.Error(), "abandoned") {
+		t.Error("Expected abandoned error, got", err)
+	}
+	if mrs[0].calls != 0 || mrs[1].calls != 0 {
+		t.Error("No child should be tried once ctx is done", mrs[0].calls, mrs[1].calls)
+	}
+	if got := resolveN(t, m, 1); got != "a" {
+		t.Error("Abandoned query should not mark children as failed, got", got)
+	}
+}
@@ -0,0 +1,16 @@
+package plain
+
+import "time"
+
+// DefaultTimeout is used if Config.Timeout is zero.
+const DefaultTimeout = time.Second * 5
+
+// Config is passed to the New() constructor.
+type Config struct {
+	Server  string        // host:port or host - port 53 is assumed if absent
+	Timeout time.Duration // Per exchange
+	TCPOnly bool          // Use TCP for every exchange rather than UDP with TCP fallback
+
+	// Caller can create their own Exchangers on our behalf
+	NewDNSClientExchangerFunc func(net string, timeout time.Duration) DNSClientExchanger
+}
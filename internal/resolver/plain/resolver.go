@@ -0,0 +1,104 @@
+// Package plain (aka internal/resolver/plain) is a minimal resolver implementation which sends every
+// query to a single DNS server over UDP, falling back to TCP if the response is truncated. It is
+// intended as a last-resort catch-all so InBailiwick() accepts every qName.
+package plain
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/markdingo/trustydns/internal/resolver"
+
+	"github.com/miekg/dns"
+)
+
+const me = "plainresolver"
+
+// DNSClientExchanger is an interface which implements dns.Client.Exchange() - the only dns.Client
+// method used by plainresolver. It exists so we can supply a mock dns.Client for testing.
+type DNSClientExchanger interface {
+	Exchange(query *dns.Msg, server string) (reply *dns.Msg, rtt time.Duration, err error)
+}
+
+type plain struct {
+	config Config
+}
+
+// defaultNewDNSClientExchangerFunc returns the default struct which meets the DNSClientExchanger
+// interface, namely a miekg/dns.Client.
+func defaultNewDNSClientExchangerFunc(net string, timeout time.Duration) DNSClientExchanger {
+	return &dns.Client{Net: net, Timeout: timeout}
+}
+
+// New constructs a plain resolver. The server address is normalized to include a port.
+func New(config Config) (*plain, error) {
+	if len(config.Server) == 0 {
+		return nil, fmt.Errorf(me + ": Server address not supplied")
+	}
+	if _, _, err := net.SplitHostPort(config.Server); err != nil {
+		config.Server = net.JoinHostPort(config.Server, "53")
+	}
+	host, _, err := net.SplitHostPort(config.Server)
+	if err != nil {
+		return nil, fmt.Errorf(me+": %s", err.Error())
+	}
+	if net.ParseIP(host) == nil {
+		return nil, fmt.Errorf(me+": %s is not an IP address", host)
+	}
+	if config.Timeout == 0 {
+		config.Timeout = DefaultTimeout
+	}
+	if config.NewDNSClientExchangerFunc == nil {
+		config.NewDNSClientExchangerFunc = defaultNewDNSClientExchangerFunc
+	}
+
+	return &plain{config: config}, nil
+}
+
+// InBailiwick always returns true as a plain resolver is a catch-all.
+func (t *plain) InBailiwick(qName string) bool {
+	return true
+}
+
+// Resolve sends the query to the configured server. A truncated UDP response is re-tried over TCP
+// and the TCP response is preferred if it is successful. If Config.TCPOnly is set, UDP is not used
+// at all.
+func (t *plain) Resolve(q *dns.Msg, qMeta *resolver.QueryMetaData) (*dns.Msg, *resolver.ResponseMetaData, error) {
+	respMeta := &resolver.ResponseMetaData{TransportType: resolver.DNSTransportUDP,
+		TransportDuration: 1, // No transport for plain resolver so pretend API takes a nanosecond
+		FinalServerUsed:   t.config.Server, ServerTries: 1, QueryTries: 1}
+
+	if t.config.TCPOnly {
+		tcp := t.config.NewDNSClientExchangerFunc("tcp", t.config.Timeout)
+		reply, rtt, err := tcp.Exchange(q, t.config.Server)
+		if err != nil {
+			return nil, nil, fmt.Errorf(me+": %s", err.Error())
+		}
+		respMeta.TransportType = resolver.DNSTransportTCP
+		respMeta.ResolutionDuration = rtt
+		respMeta.PayloadSize = reply.Len()
+		return reply, respMeta, nil
+	}
+
+	udp := t.config.NewDNSClientExchangerFunc("", t.config.Timeout)
+	reply, rtt, err := udp.Exchange(q, t.config.Server)
+	if err != nil {
+		return nil, nil, fmt.Errorf(me+": %s", err.Error())
+	}
+	if reply.Truncated {
+		respMeta.QueryTries++
+		tcp := t.config.NewDNSClientExchangerFunc("tcp", t.config.Timeout)
+		tcpReply, tcpRtt, tcpErr := tcp.Exchange(q, t.config.Server)
+		rtt += tcpRtt
+		if tcpErr == nil && tcpReply.Rcode == dns.RcodeSuccess {
+			reply = tcpReply
+			respMeta.TransportType = resolver.DNSTransportTCP
+		}
+	}
+
+	respMeta.ResolutionDuration = rtt
+	respMeta.PayloadSize = reply.Len()
+
+	return reply, respMeta, nil
+}
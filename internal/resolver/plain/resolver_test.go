@@ -0,0 +1,151 @@
+package plain
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/markdingo/trustydns/internal/resolver"
+
+	"github.com/miekg/dns"
+)
+
+// mockExchanger returns a canned reply and remembers the server it was sent to.
+type mockExchanger struct {
+	reply  *dns.Msg
+	err    error
+	server string
+}
+
+func (me *mockExchanger) Exchange(query *dns.Msg, server string) (*dns.Msg, time.Duration, error) {
+	me.server = server
+	return me.reply, time.Millisecond, me.err
+}
+
+func TestNew(t *testing.T) {
+	testCases := []struct {
+		server string
+		expect string // Normalized server or error substring
+		ok     bool
+	}{
+		{"127.0.0.1", "127.0.0.1:53", true},
+		{"127.0.0.1:5353", "127.0.0.1:5353", true},
+		{"::1", "[::1]:53", true},
+		{"[::1]:5353", "[::1]:5353", true},
+		{"", "not supplied", false},
+		{"example.net", "not an IP address", false},
+		{"example.net:53", "not an IP address", false},
+	}
+
+	for _, tc := range testCases {
+		res, err := New(Config{Server: tc.server})
+		if tc.ok {
+			if err != nil {
+				t.Error("Unexpected error from", tc.server, err)
+			} else if res.config.Server != tc.expect {
+				t.Error("Expected", tc.expect, "got", res.config.Server)
+			}
+			continue
+		}
+		if err == nil {
+			t.Error("Expected error from", tc.server)
+		} else if !strings.Contains(err.Error(), tc.expect) {
+			t.Error("Expected", tc.expect, "in error, got", err)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	udp := &mockExchanger{reply: &dns.Msg{}}
+	tcp := &mockExchanger{reply: &dns.Msg{}}
+	res, err := New(Config{Server: "192.0.2.1",
+		NewDNSClientExchangerFunc: func(net string, timeout time.Duration) DNSClientExchanger {
+			if net == "tcp" {
+				return tcp
+			}
+			return udp
+		}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.InBailiwick("anything.example.") {
+		t.Error("Plain resolver should be in bailiwick for everything")
+	}
+
+	_, respMeta, err := res.Resolve(&dns.Msg{}, &resolver.QueryMetaData{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if udp.server != "192.0.2.1:53" || tcp.server != "" {
+		t.Error("Expected UDP exchange only with 192.0.2.1:53", udp.server, tcp.server)
+	}
+	if respMeta.TransportType != resolver.DNSTransportUDP || respMeta.QueryTries != 1 {
+		t.Error("Wrong response meta data for UDP", respMeta)
+	}
+
+	// Truncated UDP falls back to TCP
+
+	udp.reply = &dns.Msg{}
+	udp.reply.Truncated = true
+	resp, respMeta, err := res.Resolve(&dns.Msg{}, &resolver.QueryMetaData{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp != tcp.reply {
+		t.Error("Expected TCP reply to supersede truncated UDP reply")
+	}
+	if respMeta.TransportType != resolver.DNSTransportTCP || respMeta.QueryTries != 2 {
+		t.Error("Wrong response meta data for TCP fallback", respMeta)
+	}
+
+	// Failed TCP fallback retains the truncated UDP reply
+
+	tcp.reply = &dns.Msg{}
+	tcp.reply.Rcode = dns.RcodeServerFailure
+	resp, _, err = res.Resolve(&dns.Msg{}, &resolver.QueryMetaData{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp != udp.reply {
+		t.Error("Expected truncated UDP reply when TCP fails")
+	}
+
+	udp.err = errors.New("Mock exchange failed")
+	_, _, err = res.Resolve(&dns.Msg{}, &resolver.QueryMetaData{})
+	if err == nil || !strings.Contains(err.Error(), "Mock exchange failed") {
+		t.Error("Expected exchange error to be returned, got", err)
+	}
+}
+
+func TestResolveTCPOnly(t *testing.T) {
+	udp := &mockExchanger{reply: &dns.Msg{}}
+	tcp := &mockExchanger{reply: &dns.Msg{}}
+	res, err := New(Config{Server: "192.0.2.1:5353", TCPOnly: true,
+		NewDNSClientExchangerFunc: func(net string, timeout time.Duration) DNSClientExchanger {
+			if net == "tcp" {
+				return tcp
+			}
+			return udp
+		}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, respMeta, err := res.Resolve(&dns.Msg{}, &resolver.QueryMetaData{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp != tcp.reply || udp.server != "" || tcp.server != "192.0.2.1:5353" {
+		t.Error("Expected TCP exchange only with 192.0.2.1:5353", udp.server, tcp.server)
+	}
+	if respMeta.TransportType != resolver.DNSTransportTCP || respMeta.QueryTries != 1 {
+		t.Error("Wrong response meta data for TCPOnly", respMeta)
+	}
+
+	tcp.err = errors.New("Mock TCP exchange failed")
+	_, _, err = res.Resolve(&dns.Msg{}, &resolver.QueryMetaData{})
+	if err == nil || !strings.Contains(err.Error(), "Mock TCP exchange failed") {
+		t.Error("Expected TCP exchange error to be returned, got", err)
+	}
+}
@@ -2,6 +2,7 @@
 package resolver
 
 import (
+	"context"
 	"time"
 
 	"github.com/miekg/dns"
@@ -27,6 +28,7 @@ const (
 // place-holder in the event that we want to add more stuff later.
 type QueryMetaData struct {
 	TransportType DNSTransportType // Of the original inbound query
+	TraceID       string           // Correlates log entries across resolution tiers - if set
 }
 
 // ResponseMetaData returns metadata about the qhery made by Resolve(). It mostly contains
@@ -42,6 +44,23 @@ type ResponseMetaData struct {
 	QueryTries      int    // Number of resolution attempts were made
 	ServerTries     int    // Number of different servers were tried
 	FinalServerUsed string // Name of the last server attempted
+
+	CacheControl *CacheControl // Only present if the upstream supplied caching directives
+
+	// CNAMETarget is set by resolvers which only have part of the answer. The Answer ends with a
+	// CNAME to this name which the resolver left for another resolver to chase.
+	CNAMETarget string
+
+	RawResponse []byte // Wire-format response as received prior to any modification - if available
+}
+
+// CacheControl contains the subset of HTTP Cache-Control directives (rfc7234 and rfc5861) which are
+// relevant to a cache holding a DNS response.
+type CacheControl struct {
+	MaxAge               time.Duration // Only valid if HasMaxAge is true
+	HasMaxAge            bool
+	StaleWhileRevalidate time.Duration // Period beyond expiry that a stale response may be served
+	NoCache              bool          // Response must not be served from a cache
 }
 
 type Resolver interface {
@@ -52,3 +71,45 @@ type Resolver interface {
 	// nil.
 	Resolve(query *dns.Msg, queryMeta *QueryMetaData) (resp *dns.Msg, respMeta *ResponseMetaData, err error)
 }
+
+// ContextResolver is implemented by resolvers which can abandon an in-flight resolution when ctx is
+// done.
+type ContextResolver interface {
+	ResolveContext(ctx context.Context, query *dns.Msg, queryMeta *QueryMetaData) (resp *dns.Msg, respMeta *ResponseMetaData, err error)
+}
+
+// ResolveContext resolves the query with r, giving up when ctx is done. If r implements
+// ContextResolver the cancellation is left to r, otherwise r.Resolve() is run in a separate
+// go-routine with a copy of the query and abandoned if ctx is done first. An abandoned Resolve()
+// runs to completion in the background and its results are discarded.
+func ResolveContext(ctx context.Context, r Resolver, query *dns.Msg, queryMeta *QueryMetaData) (*dns.Msg, *ResponseMetaData, error) {
+	if cr, ok := r.(ContextResolver); ok {
+		return cr.ResolveContext(ctx, query, queryMeta)
+	}
+	if ctx.Done() == nil { // Can never be cancelled so avoid the go-routine
+		return r.Resolve(query, queryMeta)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	type result struct {
+		resp     *dns.Msg
+		respMeta *ResponseMetaData
+		err      error
+	}
+	resChan := make(chan result, 1) // Buffered so an abandoned go-routine does not block forever
+	query = query.Copy()            // As the caller may reuse the query once we return
+	go func() {
+		var res result
+		res.resp, res.respMeta, res.err = r.Resolve(query, queryMeta)
+		resChan <- res
+	}()
+
+	select {
+	case res := <-resChan:
+		return res.resp, res.respMeta, res.err
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
+}
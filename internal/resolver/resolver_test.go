@@ -0,0 +1,69 @@
+package resolver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// slowResolver waits for release before responding
+type slowResolver struct {
+	release chan struct{}
+}
+
+func (t *slowResolver) InBailiwick(qName string) bool {
+	return true
+}
+
+func (t *slowResolver) Resolve(q *dns.Msg, qMeta *QueryMetaData) (*dns.Msg, *ResponseMetaData, error) {
+	<-t.release
+	return &dns.Msg{}, &ResponseMetaData{FinalServerUsed: "slow"}, nil
+}
+
+// contextResolver records that ResolveContext was used
+type contextResolver struct {
+	slowResolver
+	called bool
+}
+
+func (t *contextResolver) ResolveContext(ctx context.Context, q *dns.Msg, qMeta *QueryMetaData) (*dns.Msg, *ResponseMetaData, error) {
+	t.called = true
+	return &dns.Msg{}, &ResponseMetaData{}, nil
+}
+
+func TestResolveContext(t *testing.T) {
+	q := &dns.Msg{}
+	q.SetQuestion("example.net.", dns.TypeA)
+	sr := &slowResolver{release: make(chan struct{})}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	_, _, err := ResolveContext(ctx, sr, q, nil)
+	if err != context.DeadlineExceeded {
+		t.Error("Expected DeadlineExceeded, got", err)
+	}
+	_, _, err = ResolveContext(ctx, sr, q, nil) // Already expired
+	if err != context.DeadlineExceeded {
+		t.Error("Expected DeadlineExceeded from expired ctx, got", err)
+	}
+
+	close(sr.release) // Now resolves immediately
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, respMeta, err := ResolveContext(ctx, sr, q, nil)
+	if err != nil || respMeta.FinalServerUsed != "slow" {
+		t.Error("Expected successful resolution", err, respMeta)
+	}
+	_, _, err = ResolveContext(context.Background(), sr, q, nil)
+	if err != nil {
+		t.Error("Expected successful resolution with background ctx", err)
+	}
+
+	cr := &contextResolver{}
+	ResolveContext(ctx, cr, q, nil)
+	if !cr.called {
+		t.Error("ResolveContext not used for a ContextResolver")
+	}
+}
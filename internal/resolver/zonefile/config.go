@@ -0,0 +1,7 @@
+package zonefile
+
+// Config is passed to the New() constructor.
+type Config struct {
+	Path   string // RFC1035 master file
+	Origin string // Used for relative names prior to any $ORIGIN - the SOA owner if empty
+}
@@ -0,0 +1,68 @@
+package zonefile
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// addStats bumps one of the stats counters
+func (t *zone) addStats(counter *int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	*counter++
+}
+
+func (t *zone) Name() string {
+	return "Zone File (" + t.origin + ")"
+}
+
+/*
+Report returns a single line string showing stats suitable for printing to a log file. Zero
+counters if resetCounters is true.
+
+req=120 ok=100 nodata=5 nxdomain=14 refused=1
+
+	^      ^      ^        ^          ^
+	|      |      |        |          +--Queries for a class other than IN or ANY
+	|      |      |        +--Name does not exist in the zone
+	|      |      +--Name exists but not with the qtype
+	|      +--Answers, including partial CNAME chains which leave the zone
+	+--Total requests
+*/
+func (t *zone) Report(resetCounters bool) string {
+	zr := t.snapshot(resetCounters)
+
+	return fmt.Sprintf("req=%d ok=%d nodata=%d nxdomain=%d refused=%d\n",
+		zr.Requests, zr.Answers, zr.NoData, zr.NXDomain, zr.Refused)
+}
+
+// ReportJSON implements the reporter.MetricsReporter interface
+func (t *zone) ReportJSON(resetCounters bool) ([]byte, error) {
+	return json.Marshal(t.snapshot(resetCounters))
+}
+
+// zoneReport is a snapshot of the zone stats shared by Report() and ReportJSON()
+type zoneReport struct {
+	Requests int    `json:"req"`
+	Answers  int    `json:"ok"`
+	NoData   int    `json:"nodata"`
+	NXDomain int    `json:"nxdomain"`
+	Refused  int    `json:"refused"`
+	Origin   string `json:"origin"`
+}
+
+// snapshot gathers up the current stats and optionally resets them
+func (t *zone) snapshot(resetCounters bool) *zoneReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	zr := &zoneReport{Answers: t.answers, NoData: t.nodata, NXDomain: t.nxdomain, Refused: t.refused,
+		Origin: t.origin}
+	zr.Requests = zr.Answers + zr.NoData + zr.NXDomain + zr.Refused
+	if resetCounters {
+		t.stats = stats{}
+	}
+
+	return zr
+}
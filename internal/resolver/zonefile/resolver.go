@@ -0,0 +1,274 @@
+/*
+Package zonefile (aka internal/resolver/zonefile) is a resolver which answers authoritatively from an
+RFC1035 master file loaded into memory at construction. It is intended for small zones served
+alongside a recursive resolver so InBailiwick() only accepts names within the zone. Names at or
+below a delegation in the zone are not in bailiwick either, so they fall through to the recursive
+resolver.
+
+Responses have AA set and are constructed much as an authoritative server would: CNAMEs are chased
+within the zone, wildcards are expanded and negative responses carry the zone SOA in the Authority
+section with the TTL set per RFC2308. DNSSEC is not supported. A CNAME chain which leaves the zone
+results in a partial answer with ResponseMetaData.CNAMETarget set so that the caller can have the
+remainder resolved elsewhere.
+*/
+package zonefile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/markdingo/trustydns/internal/resolver"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	me             = "zonefile"
+	maxCNAMEChases = 8 // Loop protection
+)
+
+type rrsets map[uint16][]dns.RR
+
+type zone struct {
+	config Config
+	origin string            // Lower-case FQDN of the zone apex
+	soa    *dns.SOA          // Returned in the Authority section of negative responses
+	names  map[string]rrsets // Lower-case owner names, including empty non-terminals
+	cuts   map[string]bool   // Delegation points below the apex
+
+	mu sync.Mutex // Protects everything below here
+	stats
+}
+
+type stats struct {
+	answers  int
+	nodata   int
+	nxdomain int
+	refused  int
+}
+
+// New loads the zone file named in the Config. The file must contain exactly one SOA, which defines
+// the zone origin, and every record must be of class IN and within the zone.
+func New(config Config) (*zone, error) {
+	if len(config.Path) == 0 {
+		return nil, errors.New(me + ": Zone file path not supplied")
+	}
+	f, err := os.Open(config.Path)
+	if err != nil {
+		return nil, fmt.Errorf(me+": %s", err.Error())
+	}
+	defer f.Close()
+
+	origin := ""
+	if len(config.Origin) > 0 {
+		origin = dns.Fqdn(config.Origin)
+	}
+
+	var rrs []dns.RR
+	t := &zone{config: config, names: make(map[string]rrsets), cuts: make(map[string]bool)}
+	zp := dns.NewZoneParser(f, origin, config.Path)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		if rr.Header().Class != dns.ClassINET {
+			return nil, fmt.Errorf(me+": %s: %s is not class IN", config.Path, rr.Header().Name)
+		}
+		if soa, ok := rr.(*dns.SOA); ok {
+			if t.soa != nil {
+				return nil, fmt.Errorf(me+": %s: More than one SOA", config.Path)
+			}
+			t.soa = soa
+		}
+		rrs = append(rrs, rr)
+	}
+	if err := zp.Err(); err != nil {
+		return nil, fmt.Errorf(me+": %s", err.Error())
+	}
+	if t.soa == nil {
+		return nil, fmt.Errorf(me+": %s: No SOA found", config.Path)
+	}
+	t.origin = strings.ToLower(t.soa.Hdr.Name)
+
+	for _, rr := range rrs {
+		name := strings.ToLower(rr.Header().Name)
+		if !dns.IsSubDomain(t.origin, name) {
+			return nil, fmt.Errorf(me+": %s: %s is outside the zone %s", config.Path, name, t.origin)
+		}
+		if _, ok := t.names[name]; !ok {
+			t.names[name] = make(rrsets)
+		}
+		rrType := rr.Header().Rrtype
+		t.names[name][rrType] = append(t.names[name][rrType], rr)
+		if rrType == dns.TypeNS && name != t.origin {
+			t.cuts[name] = true
+		}
+
+		// Add empty non-terminals so that they return NODATA rather than NXDOMAIN
+
+		for p := parent(name); p != t.origin && len(p) > 0; p = parent(p) {
+			if _, ok := t.names[p]; !ok {
+				t.names[p] = make(rrsets)
+			}
+		}
+	}
+
+	return t, nil
+}
+
+// Origin returns the zone apex as a lower-case FQDN
+func (t *zone) Origin() string {
+	return t.origin
+}
+
+// InBailiwick returns true if qName is within the zone and not at or below a delegation.
+func (t *zone) InBailiwick(qName string) bool {
+	qName = strings.ToLower(dns.Fqdn(qName))
+	if !dns.IsSubDomain(t.origin, qName) {
+		return false
+	}
+	for n := qName; n != t.origin && len(n) > 0; n = parent(n) {
+		if t.cuts[n] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Resolve constructs an authoritative response from the zone data. Only the first question is
+// answered and queries for classes other than IN and ANY are refused.
+func (t *zone) Resolve(q *dns.Msg, qMeta *resolver.QueryMetaData) (*dns.Msg, *resolver.ResponseMetaData, error) {
+	startTime := time.Now()
+	if len(q.Question) == 0 {
+		return nil, nil, errors.New(me + ": Query does not contain a question")
+	}
+
+	resp := &dns.Msg{}
+	resp.SetReply(q)
+	resp.RecursionAvailable = true // We're part of a recursive server
+	if opt := q.IsEdns0(); opt != nil {
+		resp.SetEdns0(opt.UDPSize(), false)
+	}
+
+	qs := q.Question[0]
+	target := ""
+	if qs.Qclass != dns.ClassINET && qs.Qclass != dns.ClassANY {
+		resp.Rcode = dns.RcodeRefused
+		t.addStats(&t.refused)
+	} else {
+		resp.Authoritative = true
+		target = t.answer(resp, qs)
+	}
+
+	respMeta := &resolver.ResponseMetaData{TransportDuration: 1, // No transport for a zone file
+		ResolutionDuration: time.Since(startTime), PayloadSize: resp.Len(),
+		FinalServerUsed: t.config.Path, ServerTries: 1, QueryTries: 1, CNAMETarget: target}
+	if qMeta != nil {
+		respMeta.TransportType = qMeta.TransportType
+	}
+
+	return resp, respMeta, nil
+}
+
+// answer fills in the response to the question by following any CNAME chain within the zone. The
+// rcode reflects the last name in the chain as per RFC6604. If the chain leaves the zone the
+// response is a partial answer and the CNAME target is returned for the caller to chase.
+func (t *zone) answer(resp *dns.Msg, qs dns.Question) (target string) {
+	qName := qs.Name
+	for chase := 0; chase < maxCNAMEChases; chase++ {
+		if !t.InBailiwick(qName) { // Chased out of the zone
+			t.addStats(&t.answers)
+			return qName
+		}
+		sets, found := t.lookup(qName)
+		if !found {
+			resp.Rcode = dns.RcodeNameError
+			resp.Ns = append(resp.Ns, t.negativeSOA())
+			t.addStats(&t.nxdomain)
+			return ""
+		}
+
+		var rrs []dns.RR
+		if qs.Qtype == dns.TypeANY {
+			for _, set := range sets {
+				rrs = append(rrs, set...)
+			}
+		} else {
+			rrs = sets[qs.Qtype]
+		}
+		if len(rrs) > 0 {
+			resp.Answer = append(resp.Answer, rrs...)
+			t.addStats(&t.answers)
+			return ""
+		}
+
+		cname := sets[dns.TypeCNAME]
+		if len(cname) == 0 {
+			resp.Ns = append(resp.Ns, t.negativeSOA())
+			t.addStats(&t.nodata)
+			return ""
+		}
+		resp.Answer = append(resp.Answer, cname[0])
+		qName = cname[0].(*dns.CNAME).Target
+	}
+
+	t.addStats(&t.answers) // Chain too long - return what we have
+
+	return ""
+}
+
+// lookup returns copies of the RRsets owned by qName. If qName does not exist, a matching wildcard
+// is expanded with qName as the owner. An empty non-terminal returns found with no RRsets.
+func (t *zone) lookup(qName string) (rrsets, bool) {
+	name := strings.ToLower(dns.Fqdn(qName))
+	sets, found := t.names[name]
+	wildcard := false
+	if !found {
+		encloser := parent(name) // Find the closest encloser and look for a wildcard below it
+		for ; encloser != t.origin && len(encloser) > 0; encloser = parent(encloser) {
+			if _, ok := t.names[encloser]; ok {
+				break
+			}
+		}
+		sets, wildcard = t.names["*."+encloser]
+		if !wildcard {
+			return nil, false
+		}
+	}
+
+	res := make(rrsets, len(sets))
+	for rrType, set := range sets {
+		for _, rr := range set {
+			rr = dns.Copy(rr)
+			if wildcard {
+				rr.Header().Name = qName
+			}
+			res[rrType] = append(res[rrType], rr)
+		}
+	}
+
+	return res, true
+}
+
+// negativeSOA returns the SOA for the Authority section of a negative response with the TTL set to
+// the lesser of the SOA TTL and MINIMUM as per RFC2308.
+func (t *zone) negativeSOA() dns.RR {
+	soa := dns.Copy(t.soa).(*dns.SOA)
+	if soa.Minttl < soa.Hdr.Ttl {
+		soa.Hdr.Ttl = soa.Minttl
+	}
+
+	return soa
+}
+
+// parent returns the parent of the lower-case FQDN name or the empty string if name is the root.
+func parent(name string) string {
+	ix := strings.IndexByte(name, '.')
+	if ix < 0 || ix == len(name)-1 {
+		return ""
+	}
+
+	return name[ix+1:]
+}
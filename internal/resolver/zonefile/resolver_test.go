@@ -0,0 +1,194 @@
+package zonefile
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/markdingo/trustydns/internal/resolver"
+
+	"github.com/miekg/dns"
+)
+
+func TestNew(t *testing.T) {
+	testCases := []struct {
+		config Config
+		origin string // Expected origin or error substring
+		ok     bool
+	}{
+		{Config{Path: "testdata/example.zone"}, "example.net.", true},
+		{Config{Path: "testdata/relative.zone", Origin: "Example.COM"}, "example.com.", true},
+		{Config{Path: "testdata/relative.zone"}, "relative.zone", false},
+		{Config{Path: "testdata/nosoa.zone"}, "No SOA", false},
+		{Config{Path: "testdata/outofzone.zone"}, "outside the zone", false},
+		{Config{Path: "testdata/nosuchfile"}, "no such file", false},
+		{Config{}, "not supplied", false},
+	}
+	for ix, tc := range testCases {
+		z, err := New(tc.config)
+		if tc.ok {
+			if err != nil {
+				t.Error(ix, "Unexpected error", err)
+			} else if z.Origin() != tc.origin {
+				t.Error(ix, "Origin expected", tc.origin, "got", z.Origin())
+			}
+			continue
+		}
+		if err == nil {
+			t.Error(ix, "Expected an error containing", tc.origin)
+		} else if !strings.Contains(err.Error(), tc.origin) {
+			t.Error(ix, "Error expected to contain", tc.origin, "got", err)
+		}
+	}
+}
+
+func TestInBailiwick(t *testing.T) {
+	z, err := New(Config{Path: "testdata/example.zone"})
+	if err != nil {
+		t.Fatal("Setup error", err)
+	}
+	testCases := []struct {
+		qName  string
+		expect bool
+	}{
+		{"example.net.", true},
+		{"EXAMPLE.net", true},
+		{"www.example.net.", true},
+		{"nosuchname.example.net.", true},
+		{"sub.example.net.", false}, // Delegated
+		{"ns.sub.example.net.", false},
+		{"example.org.", false},
+		{"net.", false},
+		{"myexample.net.", false},
+	}
+	for _, tc := range testCases {
+		if got := z.InBailiwick(tc.qName); got != tc.expect {
+			t.Error(tc.qName, "expected", tc.expect, "got", got)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	z, err := New(Config{Path: "testdata/example.zone"})
+	if err != nil {
+		t.Fatal("Setup error", err)
+	}
+	testCases := []struct {
+		qName  string
+		qType  uint16
+		rcode  int
+		answer []string // Expected Answer RRs in order, as owner/type/rdata
+		ns     bool     // Expect SOA in Authority
+	}{
+		{"example.net.", dns.TypeA, dns.RcodeSuccess, []string{"example.net./A/192.0.2.1"}, false},
+		{"MAIL.example.net.", dns.TypeAAAA, dns.RcodeSuccess,
+			[]string{"mail.example.net./AAAA/2001:db8::25"}, false},
+		{"www.example.net.", dns.TypeA, dns.RcodeSuccess,
+			[]string{"www.example.net./CNAME/example.net.", "example.net./A/192.0.2.1"}, false},
+		{"alias.example.net.", dns.TypeA, dns.RcodeSuccess,
+			[]string{"alias.example.net./CNAME/www.example.net.", "www.example.net./CNAME/example.net.",
+				"example.net./A/192.0.2.1"}, false},
+		{"www.example.net.", dns.TypeCNAME, dns.RcodeSuccess,
+			[]string{"www.example.net./CNAME/example.net."}, false},
+		{"ext.example.net.", dns.TypeA, dns.RcodeSuccess, // Chased out of the zone
+			[]string{"ext.example.net./CNAME/www.example.org."}, false},
+		{"mail.example.net.", dns.TypeTXT, dns.RcodeSuccess, nil, true},    // NODATA
+		{"b.c.example.net.", dns.TypeA, dns.RcodeSuccess, nil, true},       // Empty non-terminal
+		{"nosuch.example.net.", dns.TypeA, dns.RcodeNameError, nil, true},  // NXDOMAIN
+		{"x.a.b.c.example.net.", dns.TypeA, dns.RcodeNameError, nil, true}, // Below a name
+		{"Foo.wild.example.net.", dns.TypeTXT, dns.RcodeSuccess,
+			[]string{"Foo.wild.example.net./TXT/\"wildcard\""}, false},
+		{"a.b.wild.example.net.", dns.TypeTXT, dns.RcodeSuccess,
+			[]string{"a.b.wild.example.net./TXT/\"wildcard\""}, false},
+		{"foo.wild.example.net.", dns.TypeA, dns.RcodeSuccess, nil, true}, // Wildcard NODATA
+	}
+	for _, tc := range testCases {
+		q := &dns.Msg{}
+		q.SetQuestion(tc.qName, tc.qType)
+		resp, respMeta, err := z.Resolve(q, &resolver.QueryMetaData{TransportType: resolver.DNSTransportHTTP})
+		if err != nil {
+			t.Error(tc.qName, "Unexpected error", err)
+			continue
+		}
+		if !resp.Authoritative || resp.Id != q.Id || resp.Rcode != tc.rcode {
+			t.Error(tc.qName, "Wrong header or rcode", resp.MsgHdr)
+		}
+		if len(resp.Answer) != len(tc.answer) {
+			t.Error(tc.qName, "Answer expected", tc.answer, "got", resp.Answer)
+			continue
+		}
+		for ix, rr := range resp.Answer {
+			got := strings.Fields(rr.String()) // owner TTL class type rdata...
+			flat := got[0] + "/" + got[3] + "/" + strings.Join(got[4:], " ")
+			if flat != tc.answer[ix] {
+				t.Error(tc.qName, ix, "Answer expected", tc.answer[ix], "got", flat)
+			}
+		}
+		if tc.ns {
+			if len(resp.Ns) != 1 || resp.Ns[0].Header().Rrtype != dns.TypeSOA || resp.Ns[0].Header().Ttl != 300 {
+				t.Error(tc.qName, "Expected SOA with negative TTL in Authority, got", resp.Ns)
+			}
+		} else if len(resp.Ns) != 0 {
+			t.Error(tc.qName, "Did not expect an Authority section", resp.Ns)
+		}
+		expectTarget := ""
+		if tc.qName == "ext.example.net." {
+			expectTarget = "www.example.org."
+		}
+		if respMeta.CNAMETarget != expectTarget {
+			t.Error(tc.qName, "CNAMETarget expected", expectTarget, "got", respMeta.CNAMETarget)
+		}
+		if respMeta.TransportType != resolver.DNSTransportHTTP || respMeta.PayloadSize != resp.Len() ||
+			respMeta.FinalServerUsed != "testdata/example.zone" {
+			t.Error(tc.qName, "Wrong respMeta", respMeta)
+		}
+	}
+
+	// Zone data must not be modified by wildcard expansion
+
+	q := &dns.Msg{}
+	q.SetQuestion("*.wild.example.net.", dns.TypeTXT)
+	resp, _, _ := z.Resolve(q, nil)
+	if len(resp.Answer) != 1 || resp.Answer[0].Header().Name != "*.wild.example.net." {
+		t.Error("Wildcard owner was modified", resp.Answer)
+	}
+}
+
+func TestResolveMisc(t *testing.T) {
+	z, err := New(Config{Path: "testdata/example.zone"})
+	if err != nil {
+		t.Fatal("Setup error", err)
+	}
+
+	if _, _, err := z.Resolve(&dns.Msg{}, nil); err == nil {
+		t.Error("Expected an error with no question")
+	}
+
+	q := &dns.Msg{}
+	q.SetQuestion("example.net.", dns.TypeA)
+	q.Question[0].Qclass = dns.ClassCHAOS
+	resp, _, err := z.Resolve(q, nil)
+	if err != nil || resp.Rcode != dns.RcodeRefused || resp.Authoritative {
+		t.Error("Expected non-authoritative REFUSED for CHAOS", err, resp)
+	}
+
+	q.SetQuestion("example.net.", dns.TypeANY)
+	q.SetEdns0(1232, false)
+	resp, _, _ = z.Resolve(q, nil)
+	if len(resp.Answer) != 4 { // SOA, NS, MX and A
+		t.Error("Expected four RRs for ANY, got", resp.Answer)
+	}
+	if opt := resp.IsEdns0(); opt == nil || opt.UDPSize() != 1232 {
+		t.Error("Expected OPT in response", resp)
+	}
+
+	rep := z.Report(true)
+	if rep != "req=2 ok=1 nodata=0 nxdomain=0 refused=1\n" {
+		t.Error("Report wrong", rep)
+	}
+	if rep = z.Report(false); rep != "req=0 ok=0 nodata=0 nxdomain=0 refused=0\n" {
+		t.Error("Report did not reset", rep)
+	}
+	if z.Name() != "Zone File (example.net.)" {
+		t.Error("Name wrong", z.Name())
+	}
+}
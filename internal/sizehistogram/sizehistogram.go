@@ -0,0 +1,68 @@
+/*
+Package sizehistogram accumulates DNS message sizes into a fixed set of buckets for bandwidth
+planning. The bucket bounds are chosen to line up with the sizes that matter to DNS transport: 512
+is the classic UDP limit, 1232 is the EDNS buffer size recommended by DNS Flag Day 2020 and 4096 is
+the traditional EDNS default. Typical usage:
+
+	var h sizehistogram.Histogram
+
+	h.Add(resp.Len())
+	...
+	fmt.Println("Sizes:", h.Format())
+
+A Histogram is not safe for concurrent use as it is normally embedded in a stats struct which is
+already protected by the caller. The zero value is ready to use and resetting is simply a matter of
+assigning a zero value.
+*/
+package sizehistogram
+
+import (
+	"fmt"
+	"strings"
+)
+
+// bounds are the inclusive upper bounds of each bucket. An additional overflow bucket holds
+// everything larger than the last bound.
+var bounds = []int{128, 256, 512, 1232, 4096}
+
+// Histogram is the core structure used by sizehistogram
+type Histogram struct {
+	counts [6]int // len(bounds)+1 for the overflow bucket
+	total  int
+}
+
+// Add records a single message size
+func (t *Histogram) Add(size int) {
+	ix := 0
+	for ix < len(bounds) && size > bounds[ix] {
+		ix++
+	}
+	t.counts[ix]++
+	t.total++
+}
+
+// Count returns the number of sizes added
+func (t *Histogram) Count() int {
+	return t.total
+}
+
+// Counts returns a copy of the bucket counts in ascending size order. The last element is the
+// overflow bucket.
+func (t *Histogram) Counts() []int {
+	return append([]int{}, t.counts[:]...)
+}
+
+// Format returns the bucket counts as "128=n 256=n 512=n 1232=n 4096=n more=n" where each count is
+// of the sizes up to and including the bound which are larger than the previous bound.
+func Format(counts []int) string {
+	res := make([]string, 0, len(counts))
+	for ix, c := range counts {
+		if ix < len(bounds) {
+			res = append(res, fmt.Sprintf("%d=%d", bounds[ix], c))
+		} else {
+			res = append(res, fmt.Sprintf("more=%d", c))
+		}
+	}
+
+	return strings.Join(res, " ")
+}
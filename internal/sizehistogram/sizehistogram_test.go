@@ -0,0 +1,34 @@
+package sizehistogram
+
+import (
+	"testing"
+)
+
+func TestHistogram(t *testing.T) {
+	var h Histogram
+	if h.Count() != 0 {
+		t.Error("Zero value Histogram should have a zero count, not", h.Count())
+	}
+	if got := Format(h.Counts()); got != "128=0 256=0 512=0 1232=0 4096=0 more=0" {
+		t.Error("Zero value format wrong", got)
+	}
+
+	for _, s := range []int{0, 12, 128, 129, 512, 513, 1232, 1233, 4096, 4097, 65535} {
+		h.Add(s)
+	}
+	if h.Count() != 11 {
+		t.Error("Count should be 11, not", h.Count())
+	}
+	exp := "128=3 256=1 512=1 1232=2 4096=2 more=2"
+	if got := Format(h.Counts()); got != exp {
+		t.Error("Format expected", exp, "got", got)
+	}
+
+	// Counts must be a copy
+
+	c := h.Counts()
+	c[0] = 100
+	if h.Counts()[0] != 3 {
+		t.Error("Counts() did not return a copy")
+	}
+}
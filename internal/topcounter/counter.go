@@ -0,0 +1,111 @@
+/*
+Package topcounter counts occurrences of small integer keys such as DNS qtypes and rcodes so that
+the most frequent can be reported. Typical usage:
+
+	var c topcounter.Counter
+
+	c.Add(dnsQ.Question[0].Qtype)
+	...
+	fmt.Println(topcounter.Format(c.Top(5, func(k uint16) string { return dns.Type(k).String() })))
+
+The number of distinct keys tracked is bounded by MaxKeys so that a client sending a spread of
+unusual keys cannot grow the Counter without limit. Once the bound is reached, occurrences of new
+keys are still counted but only in aggregate as part of the "other" entry.
+
+A Counter is not safe for concurrent use as it is normally embedded in a stats struct which is
+already protected by the caller. The zero value is ready to use and resetting is simply a matter of
+assigning a zero value.
+*/
+package topcounter
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// MaxKeys is the maximum number of distinct keys individually counted by a Counter
+const MaxKeys = 64
+
+// OtherName is the name of the Entry which aggregates all keys not otherwise listed by Top()
+const OtherName = "other"
+
+// Counter is the core structure used by topcounter
+type Counter struct {
+	counts   map[uint16]int
+	overflow int // Occurrences of keys which arrived after MaxKeys was reached
+}
+
+// Entry is a named count as returned by Top()
+type Entry struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// Add records a single occurrence of key
+func (t *Counter) Add(key uint16) {
+	if t.counts == nil {
+		t.counts = make(map[uint16]int)
+	}
+	if _, ok := t.counts[key]; !ok && len(t.counts) >= MaxKeys {
+		t.overflow++
+		return
+	}
+	t.counts[key]++
+}
+
+// Total returns the number of occurrences of all keys
+func (t *Counter) Total() int {
+	total := t.overflow
+	for _, c := range t.counts {
+		total += c
+	}
+
+	return total
+}
+
+// Top returns up to n entries for the most frequent keys in descending order of count. Keys with
+// equal counts are ordered by key value. The name of each key is supplied by the name function. If
+// there are occurrences of any other keys, a final entry called OtherName holds their sum. Return
+// an empty slice if nothing has been added.
+func (t *Counter) Top(n int, name func(key uint16) string) []Entry {
+	keys := make([]uint16, 0, len(t.counts))
+	for k := range t.counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		ci, cj := t.counts[keys[i]], t.counts[keys[j]]
+		if ci != cj {
+			return ci > cj
+		}
+		return keys[i] < keys[j]
+	})
+
+	entries := make([]Entry, 0, n+1)
+	other := t.overflow
+	for ix, k := range keys {
+		if ix < n {
+			entries = append(entries, Entry{Name: name(k), Count: t.counts[k]})
+		} else {
+			other += t.counts[k]
+		}
+	}
+	if other > 0 {
+		entries = append(entries, Entry{Name: OtherName, Count: other})
+	}
+
+	return entries
+}
+
+// Format returns the entries in a compact "name=count name=count" form suitable for a report line
+func Format(entries []Entry) string {
+	var b strings.Builder
+	for ix, e := range entries {
+		if ix > 0 {
+			b.WriteByte(' ')
+		}
+		fmt.Fprintf(&b, "%s=%d", e.Name, e.Count)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,73 @@
+package topcounter
+
+import (
+	"strconv"
+	"testing"
+)
+
+func keyName(k uint16) string {
+	return "K" + strconv.Itoa(int(k))
+}
+
+func TestTop(t *testing.T) {
+	var c Counter
+	if len(c.Top(5, keyName)) != 0 || c.Total() != 0 {
+		t.Error("Zero value Counter should be empty", c.Top(5, keyName), c.Total())
+	}
+
+	for ix := 0; ix < 5; ix++ {
+		c.Add(1)
+	}
+	for ix := 0; ix < 3; ix++ {
+		c.Add(28)
+		c.Add(12) // Ties with 28 so should sort ahead of it
+	}
+	c.Add(16)
+	c.Add(255)
+
+	testCases := []struct {
+		n      int
+		expect string
+	}{
+		{0, "other=13"},
+		{1, "K1=5 other=8"},
+		{3, "K1=5 K12=3 K28=3 other=2"},
+		{5, "K1=5 K12=3 K28=3 K16=1 K255=1"},
+		{10, "K1=5 K12=3 K28=3 K16=1 K255=1"},
+	}
+
+	for _, tc := range testCases {
+		got := Format(c.Top(tc.n, keyName))
+		if got != tc.expect {
+			t.Error("Top", tc.n, "Expected", tc.expect, "got", got)
+		}
+	}
+	if c.Total() != 13 {
+		t.Error("Expected Total of 13, got", c.Total())
+	}
+
+	c = Counter{}
+	if c.Total() != 0 {
+		t.Error("Zero assignment did not reset Counter")
+	}
+}
+
+// Test that the number of distinct keys is bounded and the excess is reported as other
+func TestMaxKeys(t *testing.T) {
+	var c Counter
+	for k := 0; k < MaxKeys*2; k++ {
+		c.Add(uint16(k))
+	}
+	c.Add(0) // Existing keys are still counted individually
+
+	if len(c.counts) != MaxKeys {
+		t.Error("Expected", MaxKeys, "distinct keys, got", len(c.counts))
+	}
+	if c.Total() != MaxKeys*2+1 {
+		t.Error("Overflow keys not counted in Total", c.Total())
+	}
+	got := Format(c.Top(1, keyName))
+	if got != "K0=2 other="+strconv.Itoa(MaxKeys*2-1) {
+		t.Error("Unexpected Top with overflow", got)
+	}
+}